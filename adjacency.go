@@ -0,0 +1,769 @@
+package dynamotree
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// DefaultChildrenIndexName is the default value of
+// AdjacencyTree.ChildrenIndexName if one is not specified.
+const DefaultChildrenIndexName = "Children"
+
+// AdjacencyTree stores a tree as an adjacency list. Each node of the tree
+// is a single row which records the ID of its parent node and its own
+// name, and a global secondary index on those attributes finds the
+// children of a node. This is an alternative to the materialized paths
+// used by Tree, where each object has a directory row for every one of its
+// ancestors.
+//
+// Storing an object writes only the rows of ancestors that do not exist
+// yet, and Rename moves a subtree of any size by updating a single row. In
+// exchange, finding the node for a key requires a read for each of its
+// parts, and List reads from the index, which is eventually consistent, so
+// it may not reflect recent writes.
+//
+// Links record the key of their target, so renaming a node breaks links
+// to it and to its descendants. Events are not supported.
+//
+// The table must have the index described by TableDefinition, and should
+// not be shared with a Tree. ConvertToAdjacencyList and
+// ConvertToMaterializedPath copy data between the two layouts.
+type AdjacencyTree struct {
+	// Tree supplies the table, the SpecialCharacter and the other settings
+	// that govern keys and links. It must not be used to access the table
+	// directly.
+	Tree *Tree
+
+	// ChildrenIndexName is the name of the index used to find the children
+	// of each node. If not specified, the value given by
+	// DefaultChildrenIndexName is used.
+	ChildrenIndexName string
+
+	initOnce sync.Once
+}
+
+func (a *AdjacencyTree) init() {
+	if a.ChildrenIndexName == "" {
+		a.ChildrenIndexName = DefaultChildrenIndexName
+	}
+}
+
+func (a *AdjacencyTree) ready() error {
+	a.initOnce.Do(a.init)
+	return a.Tree.ready()
+}
+
+// The attributes that record the structure of the tree are named using
+// the special character, so they cannot collide with the attributes of
+// objects.
+func (a *AdjacencyTree) parentAttribute() string { return a.Tree.SpecialCharacter + "Parent" }
+func (a *AdjacencyTree) nameAttribute() string   { return a.Tree.SpecialCharacter + "Name" }
+func (a *AdjacencyTree) objectAttribute() string { return a.Tree.SpecialCharacter + "Object" }
+
+// rootID is the ID of the root node, which has no parent.
+func (a *AdjacencyTree) rootID() string { return a.Tree.SpecialCharacter }
+
+// nodeID returns the ID given to a node when it is created as the child
+// named name of parent. Because the ID is derived from the node's
+// position, a node that has never been renamed can be found with a
+// consistent read of its row rather than a query of the index.
+func (a *AdjacencyTree) nodeID(parent, name string) string {
+	sum := sha256.Sum256([]byte(parent + a.Tree.SpecialCharacter + name))
+	return a.Tree.SpecialCharacter + hex.EncodeToString(sum[:16])
+}
+
+// TableDefinition returns a description of the table the tree expects,
+// including the index of children.
+func (a *AdjacencyTree) TableDefinition() *TableDefinition {
+	a.initOnce.Do(a.init)
+	d := a.Tree.TableDefinition()
+	d.GlobalSecondaryIndexes = append(d.GlobalSecondaryIndexes, IndexDefinition{
+		IndexName: a.ChildrenIndexName,
+		HashKey:   a.parentAttribute(),
+		RangeKey:  a.nameAttribute(),
+	})
+	return d
+}
+
+// CreateTable creates the table described by TableDefinition if it does
+// not exist, in the same way as Tree.CreateTable.
+func (a *AdjacencyTree) CreateTable() error {
+	a.initOnce.Do(a.init)
+	t := a.Tree
+	_, err := t.DB.CreateTable(a.TableDefinition().CreateTableInput())
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeResourceInUseException {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	return t.putMetadata()
+}
+
+// getNode returns the row of the node id, or nil if it does not exist.
+func (a *AdjacencyTree) getNode(id string) (map[string]*dynamodb.AttributeValue, error) {
+	t := a.Tree
+	resp, err := t.DB.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(t.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Key":   &dynamodb.AttributeValue{S: aws.String(id)},
+			"Child": &dynamodb.AttributeValue{S: aws.String(t.SpecialCharacter)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Item) == 0 {
+		return nil, nil
+	}
+	return resp.Item, nil
+}
+
+// isChild returns true if row is the row of the child named name of
+// parent.
+func (a *AdjacencyTree) isChild(row map[string]*dynamodb.AttributeValue, parent, name string) bool {
+	p, n := row[a.parentAttribute()], row[a.nameAttribute()]
+	return p != nil && n != nil && aws.StringValue(p.S) == parent && aws.StringValue(n.S) == name
+}
+
+// lookup returns the row of the child named name of parent, or nil if
+// there is none.
+func (a *AdjacencyTree) lookup(parent, name string) (map[string]*dynamodb.AttributeValue, error) {
+	row, err := a.getNode(a.nodeID(parent, name))
+	if err != nil || (row != nil && a.isChild(row, parent, name)) {
+		return row, err
+	}
+
+	// The node may have been renamed to this position.
+	t := a.Tree
+	resp, err := t.DB.Query(&dynamodb.QueryInput{
+		TableName:              aws.String(t.TableName),
+		IndexName:              aws.String(a.ChildrenIndexName),
+		KeyConditionExpression: aws.String("#P = :parent AND #N = :name"),
+		ExpressionAttributeNames: map[string]*string{
+			"#P": aws.String(a.parentAttribute()),
+			"#N": aws.String(a.nameAttribute()),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":parent": &dynamodb.AttributeValue{S: aws.String(parent)},
+			":name":   &dynamodb.AttributeValue{S: aws.String(name)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range resp.Items {
+		// The index may be stale, so confirm with the node itself.
+		row, err := a.getNode(aws.StringValue(item["Key"].S))
+		if err != nil {
+			return nil, err
+		}
+		if row != nil && a.isChild(row, parent, name) {
+			return row, nil
+		}
+	}
+	return nil, nil
+}
+
+// find returns the ID and row of the node at key. The row is nil if the
+// node does not exist.
+func (a *AdjacencyTree) find(key []string) (string, map[string]*dynamodb.AttributeValue, error) {
+	id := a.rootID()
+	if len(key) == 0 {
+		row, err := a.getNode(id)
+		return id, row, err
+	}
+	var row map[string]*dynamodb.AttributeValue
+	for _, part := range key {
+		var err error
+		row, err = a.lookup(id, part)
+		if err != nil || row == nil {
+			return "", nil, err
+		}
+		id = aws.StringValue(row["Key"].S)
+	}
+	return id, row, nil
+}
+
+// ensure returns the ID of the node at key, creating it and any of its
+// ancestors that do not exist.
+func (a *AdjacencyTree) ensure(key []string) (string, error) {
+	_, id, err := a.place(key)
+	return id, err
+}
+
+// place returns the IDs of the parent of the node at key, and of the node
+// itself, creating them if they do not exist. The root has no parent.
+func (a *AdjacencyTree) place(key []string) (parent string, id string, err error) {
+	id = a.rootID()
+	for _, part := range key {
+		parent = id
+		row, err := a.lookup(parent, part)
+		if err != nil {
+			return "", "", err
+		}
+		if row == nil {
+			if row, err = a.createNode(parent, part); err != nil {
+				return "", "", err
+			}
+		}
+		id = aws.StringValue(row["Key"].S)
+	}
+	return parent, id, nil
+}
+
+// createNode creates a node named name below parent and returns its row.
+// If another caller has created the same node concurrently, its row is
+// returned instead.
+func (a *AdjacencyTree) createNode(parent, name string) (map[string]*dynamodb.AttributeValue, error) {
+	t := a.Tree
+	id := a.nodeID(parent, name)
+	for {
+		row := map[string]*dynamodb.AttributeValue{
+			"Key":               &dynamodb.AttributeValue{S: aws.String(id)},
+			"Child":             &dynamodb.AttributeValue{S: aws.String(t.SpecialCharacter)},
+			a.parentAttribute(): &dynamodb.AttributeValue{S: aws.String(parent)},
+			a.nameAttribute():   &dynamodb.AttributeValue{S: aws.String(name)},
+		}
+		_, err := t.DB.PutItem(&dynamodb.PutItemInput{
+			TableName:           aws.String(t.TableName),
+			Item:                row,
+			ConditionExpression: aws.String("attribute_not_exists(#K)"),
+			ExpressionAttributeNames: map[string]*string{
+				"#K": aws.String("Key"),
+			},
+		})
+		if !isConditionalCheckFailed(err) {
+			return row, err
+		}
+
+		existing, err := a.getNode(id)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil && a.isChild(existing, parent, name) {
+			return existing, nil
+		}
+		// The ID is held by a node that has since been renamed.
+		suffix, err := t.randomHex(16)
+		if err != nil {
+			return nil, err
+		}
+		id = t.SpecialCharacter + suffix
+	}
+}
+
+// writeNode replaces the row of the node id, the child of parent at key,
+// with attributes. The write fails with ErrConditionFailed if the node
+// has been moved since it was found.
+func (a *AdjacencyTree) writeNode(parent, id string, key []string, attributes map[string]*dynamodb.AttributeValue) error {
+	t := a.Tree
+	attributes["Key"] = &dynamodb.AttributeValue{S: aws.String(id)}
+	attributes["Child"] = &dynamodb.AttributeValue{S: aws.String(t.SpecialCharacter)}
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(t.TableName),
+		Item:      attributes,
+	}
+	if len(key) > 0 {
+		name := key[len(key)-1]
+		attributes[a.parentAttribute()] = &dynamodb.AttributeValue{S: aws.String(parent)}
+		attributes[a.nameAttribute()] = &dynamodb.AttributeValue{S: aws.String(name)}
+		expr, err := expression.NewBuilder().WithCondition(
+			expression.Name(a.parentAttribute()).Equal(expression.Value(parent)).And(
+				expression.Name(a.nameAttribute()).Equal(expression.Value(name)))).Build()
+		if err != nil {
+			return err
+		}
+		input.ConditionExpression = expr.Condition()
+		input.ExpressionAttributeNames = expr.Names()
+		input.ExpressionAttributeValues = expr.Values()
+	}
+	_, err := t.DB.PutItem(input)
+	if isConditionalCheckFailed(err) {
+		return &ConditionFailedError{Err: err}
+	}
+	return err
+}
+
+// Put stores item in the tree according to key.
+func (a *AdjacencyTree) Put(key []string, item Storable) (err error) {
+	defer annotateError(&err, "Put", key)
+	if err := a.ready(); err != nil {
+		return err
+	}
+	key, err = a.Tree.transformKey(key)
+	if err != nil {
+		return err
+	}
+	attributes, err := item.MarshalDynamoDB()
+	if err != nil {
+		return err
+	}
+	return a.put(key, attributes)
+}
+
+func (a *AdjacencyTree) put(key []string, attributes map[string]*dynamodb.AttributeValue) error {
+	t := a.Tree
+	if err := t.ValidateKey(key); err != nil {
+		return err
+	}
+	if err := t.checkAttributes(attributes); err != nil {
+		return err
+	}
+	parent, id, err := a.place(key)
+	if err != nil {
+		return err
+	}
+	attributes[a.objectAttribute()] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+	t.addChecksum(attributes)
+	return a.writeNode(parent, id, key, attributes)
+}
+
+// PutLink creates a new link key that is a symbolic link to target.
+func (a *AdjacencyTree) PutLink(key []string, target []string) (err error) {
+	defer annotateError(&err, "PutLink", key)
+	if err := a.ready(); err != nil {
+		return err
+	}
+	key, target, err = a.Tree.transformLink(key, target)
+	if err != nil {
+		return err
+	}
+	return a.putLink(key, target)
+}
+
+func (a *AdjacencyTree) putLink(key []string, target []string) error {
+	t := a.Tree
+	if err := t.ValidateKey(key); err != nil {
+		return err
+	}
+	if err := t.ValidateKey(target); err != nil {
+		return err
+	}
+	parent, id, err := a.place(key)
+	if err != nil {
+		return err
+	}
+	return a.writeNode(parent, id, key, map[string]*dynamodb.AttributeValue{
+		a.objectAttribute(): &dynamodb.AttributeValue{BOOL: aws.Bool(true)},
+		t.LinkAttribute:     &dynamodb.AttributeValue{S: aws.String(t.EncodeKey(target))},
+	})
+}
+
+// Get fetches an item from the tree, following symbolic links, in the
+// same way as Tree.Get.
+func (a *AdjacencyTree) Get(key []string, ob Storable) (err error) {
+	defer annotateError(&err, "Get", key)
+	if err := a.ready(); err != nil {
+		return err
+	}
+	t := a.Tree
+	key, err = t.transformKey(key)
+	if err != nil {
+		return err
+	}
+
+	target := key
+	for hops := 0; ; hops++ {
+		_, row, err := a.find(target)
+		if err != nil {
+			return err
+		}
+		if row == nil || row[a.objectAttribute()] == nil {
+			return ErrNotFound
+		}
+		linkTarget, ok := t.linkTarget(row)
+		if !ok {
+			if err := t.verifyChecksum(row); err != nil {
+				return err
+			}
+			return ob.UnmarshalDynamoDB(row)
+		}
+		if hops >= t.MaxLinkHops {
+			return &LinkHopsError{Key: key, MaxLinkHops: t.MaxLinkHops}
+		}
+		target = t.DecodeKey(linkTarget)
+	}
+}
+
+// GetLink returns the target of the link at key. If the key does not
+// exist it returns ErrNotFound, and if it is not a link, ErrNotLink.
+func (a *AdjacencyTree) GetLink(key []string) (target []string, err error) {
+	defer annotateError(&err, "GetLink", key)
+	if err := a.ready(); err != nil {
+		return nil, err
+	}
+	t := a.Tree
+	key, err = t.transformKey(key)
+	if err != nil {
+		return nil, err
+	}
+	_, row, err := a.find(key)
+	if err != nil {
+		return nil, err
+	}
+	if row == nil || row[a.objectAttribute()] == nil {
+		return nil, ErrNotFound
+	}
+	linkTarget, ok := t.linkTarget(row)
+	if !ok {
+		return nil, ErrNotLink
+	}
+	return t.DecodeKey(linkTarget), nil
+}
+
+// Delete removes the object or link at key. If the node has children, it
+// remains as a directory; otherwise its row is removed. Deleting a key
+// that does not exist is not an error.
+//
+// A child created below key while it is being deleted may be orphaned.
+func (a *AdjacencyTree) Delete(key []string) (err error) {
+	defer annotateError(&err, "Delete", key)
+	if err := a.ready(); err != nil {
+		return err
+	}
+	t := a.Tree
+	key, err = t.transformKey(key)
+	if err != nil {
+		return err
+	}
+	id, row, err := a.find(key)
+	if err != nil || row == nil {
+		return err
+	}
+
+	hasChildren := false
+	if err := a.children(id, func(string) bool {
+		hasChildren = true
+		return false
+	}); err != nil {
+		return err
+	}
+	if hasChildren {
+		// The root has no parent.
+		parent := ""
+		if len(key) > 0 {
+			parent = aws.StringValue(row[a.parentAttribute()].S)
+		}
+		return a.writeNode(parent, id, key, map[string]*dynamodb.AttributeValue{})
+	}
+	_, err = t.DB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(t.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Key":   row["Key"],
+			"Child": row["Child"],
+		},
+	})
+	return err
+}
+
+// children calls fn with the name of each child of the node id, in
+// order, until fn returns false.
+func (a *AdjacencyTree) children(id string, fn func(name string) bool) error {
+	t := a.Tree
+	return t.DB.QueryPages(&dynamodb.QueryInput{
+		TableName:              aws.String(t.TableName),
+		IndexName:              aws.String(a.ChildrenIndexName),
+		KeyConditionExpression: aws.String("#P = :parent"),
+		ExpressionAttributeNames: map[string]*string{
+			"#P": aws.String(a.parentAttribute()),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":parent": &dynamodb.AttributeValue{S: aws.String(id)},
+		},
+	}, func(p *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range p.Items {
+			if !fn(aws.StringValue(item[a.nameAttribute()].S)) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// List enumerates the immediate children of keyPrefix in the same way as
+// Tree.List. Because it reads from the index, it may not reflect the most
+// recent writes.
+func (a *AdjacencyTree) List(keyPrefix []string, itemFunc func(string, error) bool) {
+	fn, prefix := itemFunc, keyPrefix
+	itemFunc = func(item string, err error) bool { return fn(item, wrapError("List", prefix, err)) }
+	if err := a.ready(); err != nil {
+		itemFunc("", err)
+		return
+	}
+	keyPrefix, err := a.Tree.transformKey(keyPrefix)
+	if err != nil {
+		itemFunc("", err)
+		return
+	}
+	id, row, err := a.find(keyPrefix)
+	if err != nil {
+		itemFunc("", err)
+		return
+	}
+	if row == nil && len(keyPrefix) > 0 {
+		return
+	}
+
+	if err := a.children(id, func(name string) bool { return itemFunc(name, nil) }); err != nil {
+		itemFunc("", err)
+	}
+}
+
+// Walk enumerates every key below prefix, depth first, in the same way as
+// Tree.Walk.
+func (a *AdjacencyTree) Walk(prefix []string, walkFunc func([]string, error) bool) {
+	fn, keyPrefix := walkFunc, prefix
+	walkFunc = func(key []string, err error) bool { return fn(key, wrapError("Walk", keyPrefix, err)) }
+	if err := a.ready(); err != nil {
+		walkFunc(nil, err)
+		return
+	}
+	prefix, err := a.Tree.transformKey(prefix)
+	if err != nil {
+		walkFunc(nil, err)
+		return
+	}
+	a.walk(prefix, walkFunc)
+}
+
+func (a *AdjacencyTree) walk(prefix []string, walkFunc func([]string, error) bool) {
+	id, row, err := a.find(prefix)
+	if err != nil {
+		walkFunc(nil, err)
+		return
+	}
+	if row != nil || len(prefix) == 0 {
+		a.walkNode(id, prefix, walkFunc)
+	}
+}
+
+// walkNode visits the descendants of the node id at prefix, returning
+// false if walkFunc asked to stop.
+func (a *AdjacencyTree) walkNode(id string, prefix []string, walkFunc func([]string, error) bool) bool {
+	names := []string{}
+	if err := a.children(id, func(name string) bool {
+		names = append(names, name)
+		return true
+	}); err != nil {
+		walkFunc(nil, err)
+		return false
+	}
+
+	for _, name := range names {
+		row, err := a.lookup(id, name)
+		if err != nil {
+			walkFunc(nil, err)
+			return false
+		}
+		if row == nil {
+			continue // removed, or renamed, since the index was read
+		}
+		key := make([]string, len(prefix), len(prefix)+1)
+		copy(key, prefix)
+		key = append(key, name)
+		if !walkFunc(key, nil) {
+			return false
+		}
+		if !a.walkNode(aws.StringValue(row["Key"].S), key, walkFunc) {
+			return false
+		}
+	}
+	return true
+}
+
+// Rename moves the node at oldKey, and everything below it, to newKey by
+// updating the node's row. The parent of newKey is created if it does not
+// exist. If something is already stored at newKey, Rename returns an
+// *AlreadyExistsError for newKey.
+//
+// Whether newKey is free is checked before the node is moved, and not
+// atomically with it, since which node is at a key is found through the
+// children index, which is eventually consistent. So two nodes may end up
+// at newKey if it is written, or renamed to, while Rename runs; callers
+// that rename concurrently should serialize their renames to the same
+// parent.
+func (a *AdjacencyTree) Rename(oldKey, newKey []string) (err error) {
+	defer annotateError(&err, "Rename", oldKey)
+	if err := a.ready(); err != nil {
+		return err
+	}
+	t := a.Tree
+	oldKey, newKey, err = t.transformLink(oldKey, newKey)
+	if err != nil {
+		return err
+	}
+	if err := t.ValidateKey(newKey); err != nil {
+		return err
+	}
+	if len(oldKey) == 0 || len(newKey) == 0 {
+		return fmt.Errorf("cannot rename the root of the tree")
+	}
+	if hasKeyPrefix(newKey, oldKey) {
+		return fmt.Errorf("cannot rename %q to a key below itself", strings.Join(oldKey, "/"))
+	}
+
+	id, row, err := a.find(oldKey)
+	if err != nil {
+		return err
+	}
+	if row == nil {
+		return ErrNotFound
+	}
+	parent, err := a.ensure(newKey[:len(newKey)-1])
+	if err != nil {
+		return err
+	}
+	name := newKey[len(newKey)-1]
+	if existing, err := a.lookup(parent, name); err != nil {
+		return err
+	} else if existing != nil {
+		return &AlreadyExistsError{Key: newKey}
+	}
+
+	expr, err := expression.NewBuilder().
+		WithCondition(expression.Name(a.parentAttribute()).Equal(expression.Value(aws.StringValue(row[a.parentAttribute()].S))).And(
+			expression.Name(a.nameAttribute()).Equal(expression.Value(aws.StringValue(row[a.nameAttribute()].S))))).
+		WithUpdate(expression.
+			Set(expression.Name(a.parentAttribute()), expression.Value(parent)).
+			Set(expression.Name(a.nameAttribute()), expression.Value(name))).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = t.DB.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(t.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Key":   &dynamodb.AttributeValue{S: aws.String(id)},
+			"Child": &dynamodb.AttributeValue{S: aws.String(t.SpecialCharacter)},
+		},
+		ConditionExpression:       expr.Condition(),
+		UpdateExpression:          expr.Update(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	if isConditionalCheckFailed(err) {
+		return &ConditionFailedError{Err: err}
+	}
+	return err
+}
+
+// objectAttributes returns the attributes of the object whose row is row,
+// without the attributes that t uses internally.
+func objectAttributes(t *Tree, row map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	rv := make(map[string]*dynamodb.AttributeValue, len(row))
+	for name, value := range row {
+		if name == "Key" || name == "Child" || name == t.LinkAttribute ||
+			strings.HasPrefix(name, t.SpecialCharacter) {
+			continue
+		}
+		rv[name] = value
+	}
+	return rv
+}
+
+// ConvertToAdjacencyList copies every object, link and directory of src
+// into dst. Events are not copied. The trees should not be modified while
+// the conversion is running.
+func ConvertToAdjacencyList(src *Tree, dst *AdjacencyTree) error {
+	if err := src.ready(); err != nil {
+		return err
+	}
+	if err := dst.ready(); err != nil {
+		return err
+	}
+
+	convert := func(key []string) error {
+		row, err := src.getRow(src.EncodeKey(key), nil)
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			if len(key) == 0 {
+				return nil
+			}
+			_, err := dst.ensure(key)
+			return err
+		}
+		if linkTarget, ok := src.linkTarget(row); ok {
+			return dst.putLink(key, src.DecodeKey(linkTarget))
+		}
+		return dst.put(key, objectAttributes(src, row))
+	}
+
+	if err := convert(nil); err != nil {
+		return err
+	}
+	var err error
+	src.walk(nil, func(key []string, innerErr error) bool {
+		if innerErr != nil {
+			err = innerErr
+			return false
+		}
+		err = convert(key)
+		return err == nil
+	}, nil)
+	return err
+}
+
+// ConvertToMaterializedPath copies every object, link and directory of
+// src into dst. The trees should not be modified while the conversion is
+// running.
+func ConvertToMaterializedPath(src *AdjacencyTree, dst *Tree) error {
+	if err := src.ready(); err != nil {
+		return err
+	}
+	if err := dst.ready(); err != nil {
+		return err
+	}
+
+	convert := func(key []string) error {
+		_, row, err := src.find(key)
+		if err != nil {
+			return err
+		}
+		if row == nil || row[src.objectAttribute()] == nil {
+			if len(key) == 0 {
+				return nil
+			}
+			if err := dst.ValidateKey(key); err != nil {
+				return err
+			}
+			_, writeRequests := dst.directoryRequests(key)
+			return dst.batchWrite(writeRequests, nil)
+		}
+		var writeRequests []*dynamodb.WriteRequest
+		if linkTarget, ok := src.Tree.linkTarget(row); ok {
+			writeRequests, err = dst.putLinkRequests(key, src.Tree.DecodeKey(linkTarget))
+		} else {
+			writeRequests, err = dst.putRequests(key, rawItem(objectAttributes(src.Tree, row)))
+		}
+		if err != nil {
+			return err
+		}
+		return dst.write(writeRequests, nil)
+	}
+
+	if err := convert(nil); err != nil {
+		return err
+	}
+	var err error
+	src.walk(nil, func(key []string, innerErr error) bool {
+		if innerErr != nil {
+			err = innerErr
+			return false
+		}
+		err = convert(key)
+		return err == nil
+	})
+	return err
+}
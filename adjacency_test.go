@@ -0,0 +1,188 @@
+package dynamotree
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func newTestAdjacencyTree(c *C) *AdjacencyTree {
+	db := dynamodb.New(session.New(), testConfig)
+	a := &AdjacencyTree{Tree: &Tree{TableName: uniuri.New(), DB: db}}
+	c.Assert(a.CreateTable(), IsNil)
+	return a
+}
+
+func adjacencyKeys(a *AdjacencyTree, c *C, prefix []string) [][]string {
+	keys := [][]string{}
+	a.Walk(prefix, func(key []string, err error) bool {
+		c.Assert(err, IsNil)
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+func (suite *StoreImplTest) TestAdjacencyBasics(c *C) {
+	a := newTestAdjacencyTree(c)
+	c.Assert(a.CreateTable(), IsNil) // the table exists already
+
+	alice := AccountT{ID: "12345", Name: "alice"}
+	bob := AccountT{ID: "23456", Name: "bob"}
+	c.Assert(a.Put([]string{"Accounts", "12345"}, &alice), IsNil)
+	c.Assert(a.Put([]string{"Accounts", "23456"}, &bob), IsNil)
+	c.Assert(a.Put([]string{"Accounts", "12345", "Links", "x"}, &bob), IsNil)
+	c.Assert(a.PutLink([]string{"ByName", "alice"}, []string{"Accounts", "12345"}), IsNil)
+
+	var v AccountT
+	c.Assert(a.Get([]string{"Accounts", "12345"}, &v), IsNil)
+	c.Assert(v, DeepEquals, alice)
+	c.Assert(a.Get([]string{"ByName", "alice"}, &v), IsNil)
+	c.Assert(v, DeepEquals, alice)
+	c.Assert(a.Get([]string{"Accounts"}, &v), ErrorIs, ErrNotFound)
+	c.Assert(a.Get([]string{"Accounts", "missing"}, &v), ErrorIs, ErrNotFound)
+
+	target, err := a.GetLink([]string{"ByName", "alice"})
+	c.Assert(err, IsNil)
+	c.Assert(target, DeepEquals, []string{"Accounts", "12345"})
+	_, err = a.GetLink([]string{"Accounts", "12345"})
+	c.Assert(err, ErrorIs, ErrNotLink)
+
+	items := []string{}
+	a.List([]string{"Accounts"}, func(item string, err error) bool {
+		c.Assert(err, IsNil)
+		items = append(items, item)
+		return true
+	})
+	c.Assert(items, DeepEquals, []string{"12345", "23456"})
+
+	c.Assert(adjacencyKeys(a, c, nil), DeepEquals, [][]string{
+		{"Accounts"},
+		{"Accounts", "12345"},
+		{"Accounts", "12345", "Links"},
+		{"Accounts", "12345", "Links", "x"},
+		{"Accounts", "23456"},
+		{"ByName"},
+		{"ByName", "alice"},
+	})
+
+	// a node with children remains as a directory
+	c.Assert(a.Delete([]string{"Accounts", "12345"}), IsNil)
+	c.Assert(a.Get([]string{"Accounts", "12345"}, &v), ErrorIs, ErrNotFound)
+	c.Assert(a.Get([]string{"Accounts", "12345", "Links", "x"}, &v), IsNil)
+	c.Assert(a.Delete([]string{"Accounts", "23456"}), IsNil)
+	c.Assert(a.Delete([]string{"Accounts", "missing"}), IsNil)
+	c.Assert(adjacencyKeys(a, c, []string{"Accounts"}), DeepEquals, [][]string{
+		{"Accounts", "12345"},
+		{"Accounts", "12345", "Links"},
+		{"Accounts", "12345", "Links", "x"},
+	})
+
+	// the root, with children, remains as a directory too
+	c.Assert(a.Put(nil, &alice), IsNil)
+	c.Assert(a.Get(nil, &v), IsNil)
+	c.Assert(a.Delete(nil), IsNil)
+	c.Assert(a.Get(nil, &v), ErrorIs, ErrNotFound)
+	c.Assert(a.Get([]string{"Accounts", "12345", "Links", "x"}, &v), IsNil)
+}
+
+func (suite *StoreImplTest) TestAdjacencyRename(c *C) {
+	a := newTestAdjacencyTree(c)
+
+	alice := AccountT{ID: "12345", Name: "alice"}
+	c.Assert(a.Put([]string{"Accounts", "12345", "Profile"}, &alice), IsNil)
+	c.Assert(a.Put([]string{"Accounts", "12345", "Links", "x"}, &alice), IsNil)
+	c.Assert(a.Put([]string{"Archive", "other"}, &alice), IsNil)
+
+	c.Assert(a.Rename([]string{"Accounts", "12345"}, []string{"Archive", "2018", "12345"}), IsNil)
+
+	var v AccountT
+	c.Assert(a.Get([]string{"Accounts", "12345", "Profile"}, &v), ErrorIs, ErrNotFound)
+	c.Assert(a.Get([]string{"Archive", "2018", "12345", "Profile"}, &v), IsNil)
+	c.Assert(v, DeepEquals, alice)
+	c.Assert(adjacencyKeys(a, c, []string{"Archive"}), DeepEquals, [][]string{
+		{"Archive", "2018"},
+		{"Archive", "2018", "12345"},
+		{"Archive", "2018", "12345", "Links"},
+		{"Archive", "2018", "12345", "Links", "x"},
+		{"Archive", "2018", "12345", "Profile"},
+		{"Archive", "other"},
+	})
+
+	// A new node created at the old position gets a node of its own.
+	c.Assert(a.Put([]string{"Accounts", "12345"}, &alice), IsNil)
+	c.Assert(adjacencyKeys(a, c, []string{"Accounts"}), DeepEquals, [][]string{
+		{"Accounts", "12345"},
+	})
+
+	// Renaming back onto the old position collides with it.
+	c.Assert(a.Rename([]string{"Archive", "2018", "12345"}, []string{"Accounts", "12345"}), ErrorIs, ErrAlreadyExists)
+	c.Assert(a.Rename([]string{"Archive", "2018", "12345"}, []string{"Accounts", "12345"}), ErrorMatches, `Rename "Accounts/12345": already exists`)
+	c.Assert(a.Rename([]string{"Archive", "missing"}, []string{"Accounts", "missing"}), ErrorIs, ErrNotFound)
+	c.Assert(a.Rename([]string{"Archive"}, []string{"Archive", "Inner"}), NotNil)
+
+	// Parts are compared whole, not as joined paths.
+	c.Assert(a.Put([]string{"a", "b/c"}, &alice), IsNil)
+	c.Assert(a.Rename([]string{"a", "b/c"}, []string{"a/b", "c", "d"}), IsNil)
+	c.Assert(a.Get([]string{"a/b", "c", "d"}, &v), IsNil)
+
+	// Moving the new node away and the old one back finds each by index.
+	c.Assert(a.Rename([]string{"Accounts", "12345"}, []string{"Accounts", "new"}), IsNil)
+	c.Assert(a.Rename([]string{"Archive", "2018", "12345"}, []string{"Accounts", "12345"}), IsNil)
+	c.Assert(a.Get([]string{"Accounts", "12345", "Profile"}, &v), IsNil)
+	c.Assert(a.Get([]string{"Accounts", "new"}, &v), IsNil)
+}
+
+func (suite *StoreImplTest) TestAdjacencyConvert(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	src := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(src.CreateTable(), IsNil)
+
+	alice := AccountT{ID: "12345", Name: "alice"}
+	c.Assert(src.Put([]string{"Accounts", "12345"}, &alice), IsNil)
+	c.Assert(src.Put([]string{"Accounts", "12345", "Links", "x"}, &alice), IsNil)
+	c.Assert(src.PutLink([]string{"ByName", "alice"}, []string{"Accounts", "12345"}), IsNil)
+	c.Assert(src.Delete([]string{"ByName", "alice"}), IsNil)
+	c.Assert(src.PutLink([]string{"ByName", "bob"}, []string{"Accounts", "12345"}), IsNil)
+
+	a := newTestAdjacencyTree(c)
+	c.Assert(ConvertToAdjacencyList(src, a), IsNil)
+
+	var v AccountT
+	c.Assert(a.Get([]string{"ByName", "bob"}, &v), IsNil)
+	c.Assert(v, DeepEquals, alice)
+	c.Assert(adjacencyKeys(a, c, nil), DeepEquals, [][]string{
+		{"Accounts"},
+		{"Accounts", "12345"},
+		{"Accounts", "12345", "Links"},
+		{"Accounts", "12345", "Links", "x"},
+		{"ByName"},
+		{"ByName", "bob"},
+	})
+
+	dst := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(dst.CreateTable(), IsNil)
+	c.Assert(a.Put([]string{"Empty", "Directory", "x"}, &alice), IsNil)
+	c.Assert(a.Delete([]string{"Empty", "Directory", "x"}), IsNil)
+	c.Assert(ConvertToMaterializedPath(a, dst), IsNil)
+
+	c.Assert(dst.Get([]string{"ByName", "bob"}, &v), IsNil)
+	c.Assert(v, DeepEquals, alice)
+	keys := [][]string{}
+	dst.Walk(nil, func(key []string, err error) bool {
+		c.Assert(err, IsNil)
+		keys = append(keys, key)
+		return true
+	})
+	c.Assert(keys, DeepEquals, [][]string{
+		{"Accounts"},
+		{"Accounts", "12345"},
+		{"Accounts", "12345", "Links"},
+		{"Accounts", "12345", "Links", "x"},
+		{"ByName"},
+		{"ByName", "bob"},
+		{"Empty"},
+		{"Empty", "Directory"},
+	})
+}
@@ -0,0 +1,413 @@
+package dynamotree
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// ArchiveFormat is the format of an archive written by ExportArchive.
+type ArchiveFormat int
+
+// The formats supported by ExportArchive and ImportArchive.
+const (
+	ArchiveTar ArchiveFormat = iota
+	ArchiveZip
+)
+
+// ExportArchive writes each object below prefix (and at prefix itself) to w
+// as a file in an archive of the given format. Each object is stored as the
+// file path/to/key.json, where the parts of the key are escaped with
+// url.PathEscape, containing the object's attributes as JSON. Symbolic links
+// are stored as symbolic links to the target's file.
+//
+// The JSON representation does not distinguish sets from lists, or binary
+// values from strings (binary values are written as base64), so these are
+// not preserved by ImportArchive. Given Redacted, the attributes that
+// Tree.Redactor considers sensitive are masked.
+//
+// The progress of the export can be reported using WithProgress. The
+// archive is written as a stream, so an export cannot be resumed and
+// WithCheckpoint is not supported.
+func (t *Tree) ExportArchive(prefix []string, w io.Writer, format ArchiveFormat, opts ...Option) error {
+	o, cancel := newCallOptions(opts)
+	defer cancel()
+	if o.checkpoint != "" {
+		return errors.New("ExportArchive cannot be resumed from a checkpoint")
+	}
+	if err := refuseCapability("ExportArchive", prefix, o); err != nil {
+		return err
+	}
+	if err := t.ready(); err != nil {
+		return err
+	}
+	prefix, err := t.transformKey(prefix)
+	if err != nil {
+		return err
+	}
+	j, err := t.startJob("ExportArchive", [][]string{prefix}, o)
+	if err != nil {
+		return err
+	}
+
+	var aw archiveWriter
+	switch format {
+	case ArchiveTar:
+		aw = &tarArchiveWriter{w: tar.NewWriter(w), modTime: t.now()}
+	case ArchiveZip:
+		aw = &zipArchiveWriter{w: zip.NewWriter(w), modTime: t.now()}
+	default:
+		return fmt.Errorf("unknown archive format %d", format)
+	}
+
+	export := func(key []string) error {
+		if len(key) == 0 {
+			return nil
+		}
+		row, err := t.getRow(t.EncodeKey(key), o)
+		if err != nil || row == nil {
+			return err
+		}
+		name := archivePath(key)
+		if linkTarget, ok := t.linkTarget(row); ok {
+			target := t.DecodeKey(linkTarget)
+			linkname := strings.Repeat("../", len(key)-1) + archivePath(target)
+			return aw.WriteLink(name, linkname)
+		}
+
+		row = objectAttributes(t, row)
+		if o.redact {
+			row = t.Redact(key, row)
+		}
+		buf, err := json.Marshal(attributesToJSON(row))
+		if err != nil {
+			return err
+		}
+		return aw.WriteFile(name, buf)
+	}
+
+	if err := export(prefix); err != nil {
+		return err
+	}
+	if _, err := j.walk(prefix, func(key []string) (bool, error) { return true, export(key) }); err != nil {
+		return err
+	}
+	return aw.Close()
+}
+
+// ImportArchive reads an archive written by ExportArchive from r and stores
+// each of the objects and links it contains. Files that do not end in
+// .json are ignored. Zip archives are read into memory before they are
+// imported.
+//
+// The progress of the import, counting each file in the archive, can be
+// reported using WithProgress. An import given WithCheckpoint that fails
+// resumes when it is called again with the same archive by skipping the
+// files imported before.
+func (t *Tree) ImportArchive(r io.Reader, format ArchiveFormat, opts ...Option) (err error) {
+	o, cancel := newCallOptions(opts)
+	defer cancel()
+	if err := refuseCapability("ImportArchive", nil, o); err != nil {
+		return err
+	}
+	if err := t.ready(); err != nil {
+		return err
+	}
+	j, err := t.startJob("ImportArchive", nil, o)
+	if err != nil {
+		return err
+	}
+	defer func() { err = j.finish(err) }()
+
+	// The files imported before the import was resumed are skipped.
+	skip := j.items
+	importFile := func(name string, mode os.FileMode, content io.Reader) error {
+		if skip > 0 {
+			skip--
+			return nil
+		}
+		key, err := t.importFile(name, mode, content, o)
+		if err != nil {
+			return err
+		}
+		return j.done(key)
+	}
+
+	switch format {
+	case ArchiveTar:
+		tr := tar.NewReader(r)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			content := io.Reader(tr)
+			if header.Typeflag == tar.TypeSymlink {
+				content = strings.NewReader(header.Linkname)
+			}
+			if err := importFile(header.Name, header.FileInfo().Mode(), content); err != nil {
+				return err
+			}
+		}
+
+	case ArchiveZip:
+		buf, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		zr, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
+		if err != nil {
+			return err
+		}
+		for _, f := range zr.File {
+			content, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = importFile(f.Name, f.Mode(), content)
+			content.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown archive format %d", format)
+	}
+}
+
+// importFile stores the object or link in the file of an archive called
+// name, returning its key, or nil if the file does not hold one.
+func (t *Tree) importFile(name string, mode os.FileMode, content io.Reader, o *callOptions) ([]string, error) {
+	key, ok := archiveKey(name)
+	if !ok {
+		return nil, nil
+	}
+	buf, err := ioutil.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode&os.ModeSymlink != 0 {
+		target, ok := archiveKey(path.Join(path.Dir(name), string(buf)))
+		if !ok {
+			return nil, fmt.Errorf("%s: link target %s is not an object", name, buf)
+		}
+		return key, t.PutLink(key, target, WithContext(o.context()))
+	}
+	if !mode.IsRegular() {
+		return nil, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	decoder.UseNumber()
+	var value map[string]interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return key, t.Put(key, rawItem(jsonToAttribute(value).M), WithContext(o.context()))
+}
+
+// archivePath returns the name of the file holding the object at key.
+func archivePath(key []string) string {
+	parts := make([]string, len(key))
+	for i, part := range key {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/") + ".json"
+}
+
+// archiveKey is the inverse of archivePath. It returns false if name is
+// not the name of an object's file.
+func archiveKey(name string) ([]string, bool) {
+	name = path.Clean(strings.TrimPrefix(name, "/"))
+	if !strings.HasSuffix(name, ".json") || strings.HasPrefix(name, "../") {
+		return nil, false
+	}
+	parts := strings.Split(strings.TrimSuffix(name, ".json"), "/")
+	for i, part := range parts {
+		var err error
+		if parts[i], err = url.PathUnescape(part); err != nil {
+			return nil, false
+		}
+	}
+	return parts, true
+}
+
+type archiveWriter interface {
+	WriteFile(name string, content []byte) error
+	WriteLink(name string, linkname string) error
+	Close() error
+}
+
+type tarArchiveWriter struct {
+	w       *tar.Writer
+	modTime time.Time
+}
+
+func (a *tarArchiveWriter) WriteFile(name string, content []byte) error {
+	err := a.w.WriteHeader(&tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     int64(len(content)),
+		ModTime:  a.modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = a.w.Write(content)
+	return err
+}
+
+func (a *tarArchiveWriter) WriteLink(name string, linkname string) error {
+	return a.w.WriteHeader(&tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeSymlink,
+		Linkname: linkname,
+		Mode:     0777,
+		ModTime:  a.modTime,
+	})
+}
+
+func (a *tarArchiveWriter) Close() error {
+	return a.w.Close()
+}
+
+// zipArchiveWriter stores symbolic links as files containing the link
+// target, with the symlink mode bit set, as Info-ZIP does.
+type zipArchiveWriter struct {
+	w       *zip.Writer
+	modTime time.Time
+}
+
+func (a *zipArchiveWriter) write(name string, mode os.FileMode, content []byte) error {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: a.modTime}
+	header.SetMode(mode)
+	w, err := a.w.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
+
+func (a *zipArchiveWriter) WriteFile(name string, content []byte) error {
+	return a.write(name, 0644, content)
+}
+
+func (a *zipArchiveWriter) WriteLink(name string, linkname string) error {
+	return a.write(name, os.ModeSymlink|0777, []byte(linkname))
+}
+
+func (a *zipArchiveWriter) Close() error {
+	return a.w.Close()
+}
+
+// rawItem is a Storable holding attributes as they are stored in DynamoDB.
+type rawItem map[string]*dynamodb.AttributeValue
+
+func (r rawItem) MarshalDynamoDB() (map[string]*dynamodb.AttributeValue, error) {
+	rv := make(map[string]*dynamodb.AttributeValue, len(r))
+	for k, v := range r {
+		rv[k] = v
+	}
+	return rv, nil
+}
+
+func (r rawItem) UnmarshalDynamoDB(item map[string]*dynamodb.AttributeValue) error {
+	for k, v := range item {
+		r[k] = v
+	}
+	return nil
+}
+
+// attributesToJSON returns the attributes of an item as a value that can
+// be passed to json.Marshal.
+func attributesToJSON(item map[string]*dynamodb.AttributeValue) map[string]interface{} {
+	rv := make(map[string]interface{}, len(item))
+	for k, v := range item {
+		rv[k] = attributeToJSON(v)
+	}
+	return rv
+}
+
+func attributeToJSON(v *dynamodb.AttributeValue) interface{} {
+	switch {
+	case v.S != nil:
+		return *v.S
+	case v.N != nil:
+		return json.Number(*v.N)
+	case v.BOOL != nil:
+		return *v.BOOL
+	case v.B != nil:
+		return base64.StdEncoding.EncodeToString(v.B)
+	case v.M != nil:
+		return attributesToJSON(v.M)
+	case v.L != nil:
+		rv := make([]interface{}, len(v.L))
+		for i, e := range v.L {
+			rv[i] = attributeToJSON(e)
+		}
+		return rv
+	case v.SS != nil:
+		return aws.StringValueSlice(v.SS)
+	case v.NS != nil:
+		rv := make([]json.Number, len(v.NS))
+		for i, n := range v.NS {
+			rv[i] = json.Number(*n)
+		}
+		return rv
+	case v.BS != nil:
+		rv := make([]string, len(v.BS))
+		for i, b := range v.BS {
+			rv[i] = base64.StdEncoding.EncodeToString(b)
+		}
+		return rv
+	}
+	return nil
+}
+
+// jsonToAttribute is the inverse of attributeToJSON, for values decoded
+// with json.Decoder.UseNumber.
+func jsonToAttribute(v interface{}) *dynamodb.AttributeValue {
+	switch v := v.(type) {
+	case string:
+		return &dynamodb.AttributeValue{S: aws.String(v)}
+	case json.Number:
+		return &dynamodb.AttributeValue{N: aws.String(v.String())}
+	case bool:
+		return &dynamodb.AttributeValue{BOOL: aws.Bool(v)}
+	case map[string]interface{}:
+		m := make(map[string]*dynamodb.AttributeValue, len(v))
+		for k, e := range v {
+			m[k] = jsonToAttribute(e)
+		}
+		return &dynamodb.AttributeValue{M: m}
+	case []interface{}:
+		l := make([]*dynamodb.AttributeValue, len(v))
+		for i, e := range v {
+			l[i] = jsonToAttribute(e)
+		}
+		return &dynamodb.AttributeValue{L: l}
+	}
+	return &dynamodb.AttributeValue{NULL: aws.Bool(true)}
+}
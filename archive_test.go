@@ -0,0 +1,72 @@
+package dynamotree
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestArchive(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	err := s.CreateTable()
+	c.Assert(err, IsNil)
+
+	alice := AccountT{ID: "12345", Name: "alice", Email: "alice@example.com"}
+	bob := AccountT{ID: "a/b", Name: "bob", Email: "bob@example.com"}
+	c.Assert(s.Put([]string{"Tenants", "t1"}, &alice), IsNil)
+	c.Assert(s.Put([]string{"Tenants", "t1", "Accounts", "12345"}, &alice), IsNil)
+	c.Assert(s.Put([]string{"Tenants", "t1", "Accounts", "a/b"}, &bob), IsNil)
+	c.Assert(s.PutLink([]string{"Tenants", "t1", "ByEmail", "bob@example.com"},
+		[]string{"Tenants", "t1", "Accounts", "a/b"}), IsNil)
+	c.Assert(s.Put([]string{"Tenants", "t2", "Accounts", "6789"}, &bob), IsNil)
+
+	buf := bytes.NewBuffer(nil)
+	err = s.ExportArchive([]string{"Tenants", "t1"}, buf, ArchiveTar)
+	c.Assert(err, IsNil)
+
+	names := []string{}
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, IsNil)
+		names = append(names, header.Name+" "+header.Linkname)
+	}
+	c.Assert(names, DeepEquals, []string{
+		"Tenants/t1.json ",
+		"Tenants/t1/Accounts/12345.json ",
+		"Tenants/t1/Accounts/a%2Fb.json ",
+		"Tenants/t1/ByEmail/bob@example.com.json ../../../Tenants/t1/Accounts/a%2Fb.json",
+	})
+
+	for _, format := range []ArchiveFormat{ArchiveTar, ArchiveZip} {
+		buf := bytes.NewBuffer(nil)
+		err = s.ExportArchive([]string{"Tenants", "t1"}, buf, format)
+		c.Assert(err, IsNil)
+
+		s2 := &Tree{TableName: uniuri.New(), DB: db}
+		c.Assert(s2.CreateTable(), IsNil)
+		err = s2.ImportArchive(buf, format)
+		c.Assert(err, IsNil)
+
+		var v AccountT
+		c.Assert(s2.Get([]string{"Tenants", "t1"}, &v), IsNil)
+		c.Assert(v, DeepEquals, alice)
+		c.Assert(s2.Get([]string{"Tenants", "t1", "Accounts", "12345"}, &v), IsNil)
+		c.Assert(v, DeepEquals, alice)
+		c.Assert(s2.Get([]string{"Tenants", "t1", "ByEmail", "bob@example.com"}, &v), IsNil)
+		c.Assert(v, DeepEquals, bob)
+		link, err := s2.GetLink([]string{"Tenants", "t1", "ByEmail", "bob@example.com"})
+		c.Assert(err, IsNil)
+		c.Assert(link, DeepEquals, []string{"Tenants", "t1", "Accounts", "a/b"})
+		c.Assert(s2.Get([]string{"Tenants", "t2", "Accounts", "6789"}, &v), ErrorIs, ErrNotFound)
+	}
+}
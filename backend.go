@@ -0,0 +1,76 @@
+package dynamotree
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Backend is the store in which a tree reads the rows of its objects and
+// links and writes its rows in batches. Each row is identified by its Key
+// and Child attributes, which are its hash and range keys. A Backend may
+// wrap DynamoDBBackend, for example to trace or cache the tree's reads,
+// or keep the rows elsewhere, such as in memory for tests.
+//
+// Listing, conditional and transactional writes, and the other requests
+// that depend on DynamoDB's expressions, are made by the tree through
+// Tree.DB whatever its Backend.
+type Backend interface {
+	// GetRow returns the row with the given Key and Child, or nil if
+	// there is none. If consistent is true, the read must reflect every
+	// write that completed before it.
+	GetRow(ctx aws.Context, key, child string, consistent bool, opts ...request.Option) (map[string]*dynamodb.AttributeValue, error)
+
+	// WriteRows makes the puts and deletes of writeRequests, of which
+	// there are at most 25, and returns those it did not make, which the
+	// tree sends again.
+	WriteRows(ctx aws.Context, writeRequests []*dynamodb.WriteRequest, opts ...request.Option) (unprocessed []*dynamodb.WriteRequest, err error)
+}
+
+// DynamoDBBackend is the Backend that keeps the rows in the DynamoDB table
+// TableName, using DB. It is the Backend of a tree whose Backend is nil.
+// DB is used as it is given, so one set as a tree's Backend, or wrapped by
+// it, does not apply the tree's KeySchema, Credentials or client settings
+// to the requests it makes.
+type DynamoDBBackend struct {
+	DB        *dynamodb.DynamoDB
+	TableName string
+}
+
+// GetRow returns the row with the given Key and Child, using GetItem.
+func (b *DynamoDBBackend) GetRow(ctx aws.Context, key, child string, consistent bool, opts ...request.Option) (map[string]*dynamodb.AttributeValue, error) {
+	resp, err := b.DB.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(b.TableName),
+		ConsistentRead: aws.Bool(consistent),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Key":   &dynamodb.AttributeValue{S: aws.String(key)},
+			"Child": &dynamodb.AttributeValue{S: aws.String(child)},
+		},
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Item) == 0 {
+		return nil, nil
+	}
+	return resp.Item, nil
+}
+
+// WriteRows makes writeRequests using BatchWriteItem.
+func (b *DynamoDBBackend) WriteRows(ctx aws.Context, writeRequests []*dynamodb.WriteRequest, opts ...request.Option) ([]*dynamodb.WriteRequest, error) {
+	output, err := b.DB.BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{
+		RequestItems: map[string][]*dynamodb.WriteRequest{b.TableName: writeRequests},
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return output.UnprocessedItems[b.TableName], nil
+}
+
+// backend returns the tree's Backend.
+func (t *Tree) backend() Backend {
+	if t.Backend != nil {
+		return t.Backend
+	}
+	return &DynamoDBBackend{DB: t.DB, TableName: t.TableName}
+}
@@ -0,0 +1,64 @@
+package dynamotree
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+// countingBackend counts the requests made of the Backend it wraps, and
+// fails reads once err is set.
+type countingBackend struct {
+	Backend
+	mu     sync.Mutex
+	reads  int
+	writes int
+	err    error
+}
+
+func (b *countingBackend) GetRow(ctx aws.Context, key, child string, consistent bool, opts ...request.Option) (map[string]*dynamodb.AttributeValue, error) {
+	b.mu.Lock()
+	b.reads++
+	err := b.err
+	b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return b.Backend.GetRow(ctx, key, child, consistent, opts...)
+}
+
+func (b *countingBackend) WriteRows(ctx aws.Context, writeRequests []*dynamodb.WriteRequest, opts ...request.Option) ([]*dynamodb.WriteRequest, error) {
+	b.mu.Lock()
+	b.writes++
+	b.mu.Unlock()
+	return b.Backend.WriteRows(ctx, writeRequests, opts...)
+}
+
+func (suite *StoreImplTest) TestBackend(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	tableName := uniuri.New()
+	backend := &countingBackend{Backend: &DynamoDBBackend{DB: db, TableName: tableName}}
+	s := &Tree{TableName: tableName, DB: db, Backend: backend}
+	c.Assert(s.CreateTable(), IsNil)
+
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &AccountT{ID: "12345", Name: "alice"}), IsNil)
+	c.Assert(s.PutLink([]string{"Users", "alice"}, []string{"Accounts", "12345"}), IsNil)
+	v := AccountT{}
+	c.Assert(s.Get([]string{"Users", "alice"}, &v), IsNil)
+	c.Assert(v, DeepEquals, AccountT{ID: "12345", Name: "alice"})
+	c.Assert(backend.writes, Equals, 2)
+	c.Assert(backend.reads, Equals, 2)
+
+	// The rows are those that the tree would have written itself.
+	c.Assert((&Tree{TableName: tableName, DB: db}).Get([]string{"Accounts", "12345"}, &v), IsNil)
+
+	boom := errors.New("boom")
+	backend.err = boom
+	c.Assert(s.Get([]string{"Accounts", "12345"}, &v), ErrorIs, boom)
+}
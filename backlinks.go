@@ -0,0 +1,131 @@
+package dynamotree
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// ErrHasReferences is matched, using errors.Is, by the *ReferencesError
+// returned when Delete is given FailIfReferenced and links still point at
+// the key.
+var ErrHasReferences = errors.New("links refer to the key")
+
+// ReferencesError is returned when Delete is given FailIfReferenced and
+// the links at References still point at Key.
+type ReferencesError struct {
+	Op         string
+	Key        []string
+	References [][]string
+}
+
+func (e *ReferencesError) Error() string {
+	return fmt.Sprintf("%s %q: %d links refer to the key, including %q", e.Op,
+		strings.Join(e.Key, "/"), len(e.References), strings.Join(e.References[0], "/"))
+}
+
+// Is returns true if target is ErrHasReferences.
+func (e *ReferencesError) Is(target error) bool { return target == ErrHasReferences }
+
+// FailIfReferenced causes Delete to fail with a *ReferencesError, leaving
+// the tree unmodified, if any link points at the key, so that deleting an
+// object never silently leaves links dangling. The links are found using
+// the index kept by Tree.MaintainBacklinks, which must be set.
+//
+// The check and the delete are not atomic, so a link created while Delete
+// runs is not detected.
+func FailIfReferenced() Option {
+	return func(o *callOptions) {
+		o.failIfReferenced = true
+	}
+}
+
+// backlinksChild returns the range key below which the backlinks of an
+// object are stored, ¦.links¦. Like events, backlinks share the hash key
+// of the object's own row and do not appear in List. Each backlink is
+// named by the encoded key of the link.
+func (t *Tree) backlinksChild() string {
+	return t.SpecialCharacter + ".links" + t.SpecialCharacter
+}
+
+// backlinkRow returns the key of the backlink row that records the link at
+// key to target.
+func (t *Tree) backlinkRow(key []string, targetPathKey string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"Key":   &dynamodb.AttributeValue{S: aws.String(targetPathKey)},
+		"Child": &dynamodb.AttributeValue{S: aws.String(t.backlinksChild() + t.EncodeKey(key))},
+	}
+}
+
+// References returns the keys of the links that point at key, in the
+// order of their encoded keys. The links are found using the index kept
+// by Tree.MaintainBacklinks, so links created before it was set are not
+// found. Only links that point directly at key are returned, not those
+// that reach it through other links.
+func (t *Tree) References(key []string, opts ...Option) (references [][]string, err error) {
+	defer annotateError(&err, "References", key)
+	o, cancel := t.startCall("References", key, &err, opts)
+	defer cancel()
+	if err := refuseCapability("References", key, o); err != nil {
+		return nil, err
+	}
+	if err := t.ready(); err != nil {
+		return nil, err
+	}
+	key, err = t.transformKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.ValidateKey(key); err != nil {
+		return nil, err
+	}
+	return t.references(t.EncodeKey(key), o)
+}
+
+// references returns the keys of the links recorded as pointing at the
+// object whose row has the key pathKey. A backlink is left behind when
+// its link is replaced, so each link is read to check that it still
+// points at pathKey.
+func (t *Tree) references(pathKey string, o *callOptions) ([][]string, error) {
+	if !t.MaintainBacklinks {
+		return nil, errors.New("the backlink index is not maintained; set Tree.MaintainBacklinks")
+	}
+
+	backlinksChild := t.backlinksChild()
+	linkKeys := []string{}
+	err := t.DB.QueryPagesWithContext(o.context(), &dynamodb.QueryInput{
+		TableName:              aws.String(t.TableName),
+		ConsistentRead:         o.consistent(),
+		KeyConditionExpression: aws.String("#K = :key AND begins_with(#C, :links)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#K": aws.String("Key"),
+			"#C": aws.String("Child"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":key":   &dynamodb.AttributeValue{S: aws.String(pathKey)},
+			":links": &dynamodb.AttributeValue{S: aws.String(backlinksChild)},
+		}}, func(p *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, row := range p.Items {
+			linkKeys = append(linkKeys, strings.TrimPrefix(aws.StringValue(row["Child"].S), backlinksChild))
+		}
+		return true
+	}, o.request()...)
+	if err != nil {
+		return nil, err
+	}
+
+	references := [][]string{}
+	for _, linkKey := range linkKeys {
+		row, err := t.getRow(linkKey, o)
+		if err != nil {
+			return nil, err
+		}
+		if target, isLink := t.linkTarget(row); isLink && target == pathKey {
+			references = append(references, t.DecodeKey(linkKey))
+		}
+	}
+	return references, nil
+}
@@ -0,0 +1,59 @@
+package dynamotree
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestFailIfReferenced(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db, MaintainBacklinks: true}
+	c.Assert(s.CreateTable(), IsNil)
+
+	v := AccountT{ID: "12345", Name: "alice"}
+	c.Assert(s.Put([]string{"Accounts", "alice"}, &v), IsNil)
+	c.Assert(s.PutLink([]string{"Users", "alice"}, []string{"Accounts", "alice"}), IsNil)
+	c.Assert(s.PutLink([]string{"Admins", "alice"}, []string{"Accounts", "alice"}), IsNil)
+
+	references, err := s.References([]string{"Accounts", "alice"}, ConsistentRead())
+	c.Assert(err, IsNil)
+	c.Assert(references, DeepEquals, [][]string{{"Admins", "alice"}, {"Users", "alice"}})
+
+	// Backlinks do not appear in List
+	names := []string{}
+	s.List([]string{"Accounts", "alice"}, func(name string, err error) bool {
+		c.Assert(err, IsNil)
+		names = append(names, name)
+		return true
+	})
+	c.Assert(names, DeepEquals, []string{})
+
+	err = s.Delete([]string{"Accounts", "alice"}, FailIfReferenced(), ConsistentRead())
+	c.Assert(err, ErrorIs, ErrHasReferences)
+	c.Assert(err.(*ReferencesError).References, HasLen, 2)
+	c.Assert(err.(*ReferencesError).Op, Equals, "Delete")
+	c.Assert(s.Get([]string{"Accounts", "alice"}, &AccountT{}), IsNil)
+
+	// A link that is replaced, or deleted, no longer counts
+	c.Assert(s.PutLink([]string{"Users", "alice"}, []string{"Accounts", "bob"}), IsNil)
+	c.Assert(s.Delete([]string{"Admins", "alice"}), IsNil)
+	references, err = s.References([]string{"Accounts", "alice"}, ConsistentRead())
+	c.Assert(err, IsNil)
+	c.Assert(references, DeepEquals, [][]string{})
+
+	c.Assert(s.Delete([]string{"Accounts", "alice"}, FailIfReferenced(), ConsistentRead()), IsNil)
+	c.Assert(s.Get([]string{"Accounts", "alice"}, &AccountT{}), ErrorIs, ErrNotFound)
+
+	// The backlink of the deleted link was removed
+	row, err := s.getItem(s.EncodeKey([]string{"Accounts", "alice"}),
+		s.backlinksChild()+s.EncodeKey([]string{"Admins", "alice"}), nil)
+	c.Assert(err, IsNil)
+	c.Assert(row, IsNil)
+
+	// Without the index, references cannot be checked
+	s2 := &Tree{TableName: tableName, DB: db}
+	c.Assert(s2.Delete([]string{"Users", "alice"}, FailIfReferenced()), NotNil)
+}
@@ -0,0 +1,561 @@
+package dynamotree
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// BackupStore holds the files written by a Backup. Names are paths
+// separated by slashes, such as "baseline/20240102T150405.000000000Z.json.gz".
+type BackupStore interface {
+	// WriteFile stores data as the file name, replacing any existing file.
+	WriteFile(ctx context.Context, name string, data []byte) error
+
+	// ReadFile returns the contents of the file name, or ErrNotFound if
+	// it does not exist.
+	ReadFile(ctx context.Context, name string) ([]byte, error)
+
+	// ListFiles returns the names of the files that begin with prefix, in
+	// lexical order.
+	ListFiles(ctx context.Context, prefix string) ([]string, error)
+}
+
+// S3BackupStore is a BackupStore that keeps its files in an S3 bucket,
+// below Prefix.
+type S3BackupStore struct {
+	S3     *s3.S3
+	Bucket string
+	Prefix string
+}
+
+// WriteFile stores data as the object Prefix+name.
+func (s *S3BackupStore) WriteFile(ctx context.Context, name string, data []byte) error {
+	_, err := s.S3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.Prefix + name),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+// ReadFile returns the contents of the object Prefix+name.
+func (s *S3BackupStore) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	output, err := s.S3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.Prefix + name),
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer output.Body.Close()
+	return ioutil.ReadAll(output.Body)
+}
+
+// ListFiles returns the names of the objects below Prefix+prefix, without
+// Prefix.
+func (s *S3BackupStore) ListFiles(ctx context.Context, prefix string) ([]string, error) {
+	names := []string{}
+	err := s.S3.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(s.Prefix + prefix),
+	}, func(p *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range p.Contents {
+			names = append(names, strings.TrimPrefix(aws.StringValue(object.Key), s.Prefix))
+		}
+		return true
+	})
+	return names, err
+}
+
+// DefaultBackupPollInterval is the interval at which Backup.Run reads the
+// table's stream if PollInterval is not specified.
+const DefaultBackupPollInterval = 10 * time.Second
+
+// backupTimeFormat is the format of the times in the names of backup
+// files, chosen so that the names sort in the order of the times.
+const backupTimeFormat = "20060102T150405.000000000Z"
+
+// backupClockSkew is how much earlier than the start of a baseline the
+// changes replayed over it begin, to allow for the approximate times that
+// DynamoDB Streams records.
+const backupClockSkew = time.Minute
+
+// Backup keeps a backup of the part of a tree below Prefix in Store. It
+// consists of baselines, each a table export written by Baseline, and the
+// changes to the rows below Prefix read from the table's DynamoDB stream
+// by Run or given to Record. Restore reconstructs the subtree as of any
+// time since the first baseline, which table-level point-in-time recovery
+// cannot do for a single part of a table.
+//
+// The stream must include new images (NEW_IMAGE or NEW_AND_OLD_IMAGES),
+// and must be read continuously from before the first baseline is taken,
+// as DynamoDB keeps stream records for only 24 hours.
+type Backup struct {
+	Tree   *Tree
+	Prefix []string
+	Store  BackupStore
+
+	// Streams is the client used by Run to read the table's stream, whose
+	// ARN is given by StreamARN. If StreamARN is not specified, the
+	// table's latest stream is used.
+	Streams   *dynamodbstreams.DynamoDBStreams
+	StreamARN string
+
+	// PollInterval is the interval at which Run reads the stream once it
+	// has caught up. If not specified, DefaultBackupPollInterval is used.
+	PollInterval time.Duration
+}
+
+// backupChange is a line of a file of changes. Item holds the row written
+// or, if Removed is true, the key of the row removed.
+type backupChange struct {
+	SequenceNumber string
+	Time           time.Time
+	Removed        bool `json:",omitempty"`
+	Item           map[string]map[string]interface{}
+}
+
+// Baseline writes a table export of the subtree to the store, as the
+// starting point for restoring it as of a later time.
+func (b *Backup) Baseline(ctx context.Context) error {
+	start := b.Tree.now()
+	buf := bytes.NewBuffer(nil)
+	gz := gzip.NewWriter(buf)
+	if err := b.Tree.WriteTableExport(b.Prefix, gz, WithContext(ctx)); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return b.Store.WriteFile(ctx, "baseline/"+start.UTC().Format(backupTimeFormat)+".json.gz", buf.Bytes())
+}
+
+// Record writes those of records, read from the shard shardID of the
+// table's stream, that change the subtree to the store. It is called by
+// Run, and may instead be called by an application that reads the stream
+// itself, for example in an AWS Lambda function. Records given more than
+// once are harmless.
+func (b *Backup) Record(ctx context.Context, shardID string, records []*dynamodbstreams.Record) error {
+	prefix, err := b.Tree.transformKey(b.Prefix)
+	if err != nil {
+		return err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	gz := gzip.NewWriter(buf)
+	encoder := json.NewEncoder(gz)
+	var first, last *dynamodbstreams.StreamRecord
+	for _, record := range records {
+		r := record.Dynamodb
+		if r == nil {
+			continue
+		}
+		row, err := b.Tree.exportRow(r.Keys)
+		if err != nil {
+			return err
+		}
+		if row == nil || row.Kind == ExportMetadata || !hasKeyPrefix(row.Key, prefix) {
+			continue
+		}
+
+		change := backupChange{
+			SequenceNumber: aws.StringValue(r.SequenceNumber),
+			Time:           aws.TimeValue(r.ApproximateCreationDateTime),
+		}
+		switch {
+		case aws.StringValue(record.EventName) == dynamodbstreams.OperationTypeRemove:
+			change.Removed = true
+			change.Item = attributesToDynamoDBJSON(r.Keys)
+		case r.NewImage != nil:
+			change.Item = attributesToDynamoDBJSON(r.NewImage)
+		default:
+			return fmt.Errorf("stream record %s has no new image; the stream must include new images",
+				change.SequenceNumber)
+		}
+		if err := encoder.Encode(change); err != nil {
+			return err
+		}
+		if first == nil {
+			first = r
+		}
+		last = r
+	}
+	if first == nil {
+		return nil
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("changes/%s_%s_%s_%s.json.gz",
+		aws.TimeValue(first.ApproximateCreationDateTime).UTC().Format(backupTimeFormat),
+		aws.TimeValue(last.ApproximateCreationDateTime).UTC().Format(backupTimeFormat),
+		shardID, aws.StringValue(first.SequenceNumber))
+	return b.Store.WriteFile(ctx, name, buf.Bytes())
+}
+
+// Run reads the table's stream, recording the changes to the subtree as it
+// goes, until ctx is done, when it returns ctx.Err(). The position reached
+// in each shard is kept in the store, so Run resumes where it stopped.
+func (b *Backup) Run(ctx context.Context) error {
+	b.Tree.initOnce.Do(b.Tree.init)
+	if b.Tree.keyMapper != nil {
+		return ErrKeySchemaStream
+	}
+	streamARN := b.StreamARN
+	if streamARN == "" {
+		output, err := b.Tree.DB.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+			TableName: aws.String(b.Tree.TableName),
+		})
+		if err != nil {
+			return err
+		}
+		if output.Table.LatestStreamArn == nil {
+			return fmt.Errorf("table %s does not have a stream", b.Tree.TableName)
+		}
+		streamARN = *output.Table.LatestStreamArn
+	}
+	pollInterval := b.PollInterval
+	if pollInterval == 0 {
+		pollInterval = DefaultBackupPollInterval
+	}
+
+	for {
+		if err := b.poll(ctx, streamARN); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// backupShardClosed is the checkpoint of a shard that has been read to its
+// end.
+const backupShardClosed = "closed"
+
+// poll reads the new records of each shard of the stream.
+func (b *Backup) poll(ctx context.Context, streamARN string) error {
+	input := &dynamodbstreams.DescribeStreamInput{StreamArn: aws.String(streamARN)}
+	for {
+		output, err := b.Streams.DescribeStreamWithContext(ctx, input)
+		if err != nil {
+			return err
+		}
+		for _, shard := range output.StreamDescription.Shards {
+			if err := b.readShard(ctx, streamARN, aws.StringValue(shard.ShardId)); err != nil {
+				return err
+			}
+		}
+		if output.StreamDescription.LastEvaluatedShardId == nil {
+			return nil
+		}
+		input.ExclusiveStartShardId = output.StreamDescription.LastEvaluatedShardId
+	}
+}
+
+// readShard records the records of shardID after its checkpoint, until it
+// has caught up.
+func (b *Backup) readShard(ctx context.Context, streamARN, shardID string) error {
+	checkpointName := "checkpoints/" + shardID
+	checkpoint, err := b.Store.ReadFile(ctx, checkpointName)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if string(checkpoint) == backupShardClosed {
+		return nil
+	}
+
+	input := &dynamodbstreams.GetShardIteratorInput{
+		StreamArn:         aws.String(streamARN),
+		ShardId:           aws.String(shardID),
+		ShardIteratorType: aws.String(dynamodbstreams.ShardIteratorTypeTrimHorizon),
+	}
+	if len(checkpoint) > 0 {
+		input.ShardIteratorType = aws.String(dynamodbstreams.ShardIteratorTypeAfterSequenceNumber)
+		input.SequenceNumber = aws.String(string(checkpoint))
+	}
+	output, err := b.Streams.GetShardIteratorWithContext(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	iterator := output.ShardIterator
+	for iterator != nil {
+		output, err := b.Streams.GetRecordsWithContext(ctx, &dynamodbstreams.GetRecordsInput{
+			ShardIterator: iterator,
+		})
+		if err != nil {
+			return err
+		}
+		iterator = output.NextShardIterator
+		if len(output.Records) == 0 {
+			break
+		}
+		if err := b.Record(ctx, shardID, output.Records); err != nil {
+			return err
+		}
+		last := output.Records[len(output.Records)-1].Dynamodb
+		if err := b.Store.WriteFile(ctx, checkpointName, []byte(aws.StringValue(last.SequenceNumber))); err != nil {
+			return err
+		}
+	}
+	if iterator == nil {
+		return b.Store.WriteFile(ctx, checkpointName, []byte(backupShardClosed))
+	}
+	return nil
+}
+
+// Restore writes the subtree, as it was at asOf, below dstPrefix of dst,
+// which may be another tree or b.Tree itself. Restore starts from the
+// latest baseline taken before asOf and replays the changes recorded
+// since, so the result is only as precise as the approximate times that
+// DynamoDB Streams records for each change. Links whose targets are in
+// the subtree are rewritten to point below dstPrefix.
+//
+// Restore writes each row of the restored subtree, but does not remove
+// anything already stored below dstPrefix, which should therefore be
+// empty. The restored rows are held in memory until they are written.
+func (b *Backup) Restore(ctx context.Context, dst *Tree, dstPrefix []string, asOf time.Time) error {
+	src := b.Tree
+	src.initOnce.Do(src.init)
+	if err := dst.ready(); err != nil {
+		return err
+	}
+	if dst.SpecialCharacter != src.SpecialCharacter {
+		return fmt.Errorf("cannot restore to a tree with SpecialCharacter %q rather than %q; use MigrateDelimiter",
+			dst.SpecialCharacter, src.SpecialCharacter)
+	}
+	srcPrefix, err := src.transformKey(b.Prefix)
+	if err != nil {
+		return err
+	}
+	dstPrefix, err = dst.transformKey(dstPrefix)
+	if err != nil {
+		return err
+	}
+	if err := dst.ValidateKey(dstPrefix); err != nil {
+		return err
+	}
+	r := &restorer{src: src, dst: dst, srcPrefix: srcPrefix, dstPrefix: dstPrefix,
+		rows: map[RowID]*dynamodb.WriteRequest{}}
+	_, ancestors := dst.directoryRequests(dstPrefix)
+	for _, writeRequest := range ancestors {
+		r.rows[rowID(writeRequest)] = writeRequest
+	}
+
+	// The latest baseline taken before asOf
+	baselines, err := b.Store.ListFiles(ctx, "baseline/")
+	if err != nil {
+		return err
+	}
+	var baseline string
+	var baselineTime time.Time
+	for _, name := range baselines {
+		t, err := time.Parse(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, "baseline/"), ".json.gz"))
+		if err != nil || t.After(asOf) {
+			continue
+		}
+		baseline, baselineTime = name, t
+	}
+	if baseline == "" {
+		return fmt.Errorf("no baseline was taken before %s", asOf.Format(time.RFC3339))
+	}
+	data, err := b.Store.ReadFile(ctx, baseline)
+	if err != nil {
+		return err
+	}
+	src.ReadTableExport(bytes.NewReader(data), func(row *ExportRow, innerErr error) bool {
+		if innerErr != nil {
+			err = fmt.Errorf("%s: %s", baseline, innerErr)
+			return false
+		}
+		r.apply(row, false)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	// Changes to each row are applied in order, so that the last change
+	// made before asOf wins.
+	changes, err := b.readChanges(ctx, baselineTime.Add(-backupClockSkew), asOf)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		row, err := dynamoDBJSONToAttributes(change.Item)
+		if err != nil {
+			return err
+		}
+		exportRow, err := src.exportRow(row)
+		if err != nil {
+			return err
+		}
+		if exportRow != nil {
+			r.apply(exportRow, change.Removed)
+		}
+	}
+
+	ids := make([]RowID, 0, len(r.rows))
+	for id := range r.rows {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if ids[i].Key != ids[j].Key {
+			return ids[i].Key < ids[j].Key
+		}
+		return ids[i].Child < ids[j].Child
+	})
+	writeRequests := make([]*dynamodb.WriteRequest, len(ids))
+	for i, id := range ids {
+		writeRequests[i] = r.rows[id]
+	}
+	o, cancel := newCallOptions([]Option{WithContext(ctx)})
+	defer cancel()
+	return dst.batchWrite(writeRequests, o)
+}
+
+// readChanges returns the changes recorded in the files that may contain
+// changes made between since and until, in the order in which they were
+// made. Changes made after until are omitted.
+func (b *Backup) readChanges(ctx context.Context, since, until time.Time) ([]*backupChange, error) {
+	names, err := b.Store.ListFiles(ctx, "changes/")
+	if err != nil {
+		return nil, err
+	}
+	changes := []*backupChange{}
+	for _, name := range names {
+		fields := strings.Split(strings.TrimPrefix(name, "changes/"), "_")
+		if len(fields) < 2 {
+			continue
+		}
+		first, err1 := time.Parse(backupTimeFormat, fields[0])
+		last, err2 := time.Parse(backupTimeFormat, fields[1])
+		if err1 != nil || err2 != nil || first.After(until) || last.Before(since) {
+			continue
+		}
+
+		data, err := b.Store.ReadFile(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		decoder := json.NewDecoder(bufio.NewReader(gz))
+		for {
+			change := &backupChange{}
+			if err := decoder.Decode(change); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("%s: %s", name, err)
+			}
+			if !change.Time.After(until) {
+				changes = append(changes, change)
+			}
+		}
+	}
+
+	// Sequence numbers increase over time for each row, and have no
+	// leading zeros, so they compare by length and then lexically.
+	sort.SliceStable(changes, func(i, j int) bool {
+		x, y := changes[i].SequenceNumber, changes[j].SequenceNumber
+		if len(x) != len(y) {
+			return len(x) < len(y)
+		}
+		return x < y
+	})
+	return changes, nil
+}
+
+// restorer holds the state of a call to Restore. rows maps each row of the
+// restored subtree to the request that writes or removes it in dst.
+type restorer struct {
+	src, dst             *Tree
+	srcPrefix, dstPrefix []string
+	rows                 map[RowID]*dynamodb.WriteRequest
+}
+
+// apply records the state of row, which was removed if removed is true.
+// Rows outside of the subtree are ignored.
+func (r *restorer) apply(row *ExportRow, removed bool) {
+	if row.Kind == ExportMetadata || !hasKeyPrefix(row.Key, r.srcPrefix) {
+		return
+	}
+	key := r.rebase(row.Key)
+
+	newRow := make(map[string]*dynamodb.AttributeValue, len(row.Row))
+	for name, value := range row.Row {
+		newRow[name] = value
+	}
+	childKey := aws.StringValue(row.Row["Child"].S)
+	switch row.Kind {
+	case ExportObject, ExportLink:
+		newRow["Key"] = &dynamodb.AttributeValue{S: aws.String(r.dst.EncodeKey(key))}
+		if row.Kind == ExportLink && hasKeyPrefix(row.LinkTarget, r.srcPrefix) {
+			delete(newRow, r.src.SpecialCharacter)
+			delete(newRow, r.src.LinkAttribute)
+			newRow[r.dst.LinkAttribute] = &dynamodb.AttributeValue{
+				S: aws.String(r.dst.EncodeKey(r.rebase(row.LinkTarget))),
+			}
+		}
+	case ExportAuxiliary:
+		newRow["Key"] = &dynamodb.AttributeValue{S: aws.String(r.dst.EncodeKey(key))}
+		backlinksChild := r.src.backlinksChild()
+		if strings.HasPrefix(childKey, backlinksChild) {
+			linkKey := r.src.DecodeKey(strings.TrimPrefix(childKey, backlinksChild))
+			if hasKeyPrefix(linkKey, r.srcPrefix) {
+				newRow["Child"] = &dynamodb.AttributeValue{
+					S: aws.String(r.dst.backlinksChild() + r.dst.EncodeKey(r.rebase(linkKey))),
+				}
+			}
+		}
+	case ExportDirectoryEntry:
+		if len(key) == 0 {
+			return // the root has no directory entry
+		}
+		newRow["Key"] = &dynamodb.AttributeValue{S: aws.String(r.dst.dirKey(key[:len(key)-1]))}
+		newRow["Child"] = &dynamodb.AttributeValue{S: aws.String(r.dst.childName(key))}
+	}
+
+	id := RowID{Key: aws.StringValue(newRow["Key"].S), Child: aws.StringValue(newRow["Child"].S)}
+	if removed {
+		r.rows[id] = &dynamodb.WriteRequest{
+			DeleteRequest: &dynamodb.DeleteRequest{
+				Key: map[string]*dynamodb.AttributeValue{"Key": newRow["Key"], "Child": newRow["Child"]},
+			},
+		}
+		return
+	}
+	r.rows[id] = &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: newRow}}
+}
+
+// rebase returns key, which is below srcPrefix, moved below dstPrefix.
+func (r *restorer) rebase(key []string) []string {
+	rv := make([]string, 0, len(r.dstPrefix)+len(key)-len(r.srcPrefix))
+	rv = append(rv, r.dstPrefix...)
+	return append(rv, key[len(r.srcPrefix):]...)
+}
@@ -0,0 +1,160 @@
+package dynamotree
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+// memoryBackupStore is a BackupStore that keeps its files in memory.
+type memoryBackupStore struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (s *memoryBackupStore) WriteFile(ctx context.Context, name string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.files == nil {
+		s.files = map[string][]byte{}
+	}
+	s.files[name] = data
+	return nil
+}
+
+func (s *memoryBackupStore) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return data, nil
+}
+
+func (s *memoryBackupStore) ListFiles(ctx context.Context, prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := []string{}
+	for name := range s.files {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (suite *StoreImplTest) TestBackup(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+	ctx := context.Background()
+
+	alice := AccountT{ID: "12345", Name: "alice"}
+	bob := AccountT{ID: "6789", Name: "bob"}
+	c.Assert(s.Put([]string{"Tenants", "t1", "Accounts", "alice"}, &alice), IsNil)
+	c.Assert(s.Put([]string{"Tenants", "t2", "Accounts", "bob"}, &bob), IsNil)
+
+	store := &memoryBackupStore{}
+	b := &Backup{Tree: s, Prefix: []string{"Tenants", "t1"}, Store: store}
+	c.Assert(b.Baseline(ctx), IsNil)
+
+	// records returns the stream records that writing the rows of key
+	// (or removing them) would produce.
+	seq := 1000000000000000000
+	start := time.Now().Add(time.Second)
+	records := func(at time.Duration, key []string, remove bool) []*dynamodbstreams.Record {
+		rows := [][2]string{}
+		_, writeRequests := s.directoryRequests(key)
+		for _, writeRequest := range writeRequests {
+			rows = append(rows, [2]string{*writeRequest.PutRequest.Item["Key"].S, *writeRequest.PutRequest.Item["Child"].S})
+		}
+		rows = append(rows, [2]string{s.EncodeKey(key), s.SpecialCharacter})
+		if remove {
+			rows = rows[len(rows)-2:]
+		}
+		rv := []*dynamodbstreams.Record{}
+		for _, row := range rows {
+			seq++
+			keys := map[string]*dynamodb.AttributeValue{
+				"Key":   {S: aws.String(row[0])},
+				"Child": {S: aws.String(row[1])},
+			}
+			record := &dynamodbstreams.Record{
+				EventName: aws.String(dynamodbstreams.OperationTypeRemove),
+				Dynamodb: &dynamodbstreams.StreamRecord{
+					ApproximateCreationDateTime: aws.Time(start.Add(at)),
+					SequenceNumber:              aws.String(fmt.Sprintf("%d000", seq)),
+					Keys:                        keys,
+				},
+			}
+			if !remove {
+				image, err := s.getItem(row[0], row[1], nil)
+				c.Assert(err, IsNil)
+				record.EventName = aws.String(dynamodbstreams.OperationTypeInsert)
+				record.Dynamodb.NewImage = image
+			}
+			rv = append(rv, record)
+		}
+		return rv
+	}
+
+	c.Assert(s.Put([]string{"Tenants", "t1", "Accounts", "carol"}, &bob), IsNil)
+	c.Assert(s.PutLink([]string{"Tenants", "t1", "Links", "carol"}, []string{"Tenants", "t1", "Accounts", "carol"}), IsNil)
+	c.Assert(s.Put([]string{"Tenants", "t2", "Accounts", "dave"}, &bob), IsNil)
+	c.Assert(b.Record(ctx, "shard-1", records(time.Minute, []string{"Tenants", "t1", "Accounts", "carol"}, false)), IsNil)
+	c.Assert(b.Record(ctx, "shard-1", records(time.Minute, []string{"Tenants", "t1", "Links", "carol"}, false)), IsNil)
+	c.Assert(b.Record(ctx, "shard-2", records(time.Minute, []string{"Tenants", "t2", "Accounts", "dave"}, false)), IsNil)
+	c.Assert(s.Delete([]string{"Tenants", "t1", "Accounts", "alice"}), IsNil)
+	c.Assert(b.Record(ctx, "shard-2", records(2*time.Minute, []string{"Tenants", "t1", "Accounts", "alice"}, true)), IsNil)
+
+	// Records outside of the prefix are not kept
+	names, err := store.ListFiles(ctx, "changes/")
+	c.Assert(err, IsNil)
+	c.Assert(names, HasLen, 3)
+
+	list := func(t *Tree) []string {
+		keys := []string{}
+		t.Walk(nil, func(key []string, err error) bool {
+			c.Assert(err, IsNil)
+			keys = append(keys, strings.Join(key, "/"))
+			return true
+		})
+		return keys
+	}
+
+	s2 := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s2.CreateTable(), IsNil)
+	c.Assert(b.Restore(ctx, s2, []string{"Restored"}, start.Add(90*time.Second)), IsNil)
+	c.Assert(list(s2), DeepEquals, []string{"Restored", "Restored/Accounts", "Restored/Accounts/alice",
+		"Restored/Accounts/carol", "Restored/Links", "Restored/Links/carol"})
+	var v AccountT
+	c.Assert(s2.Get([]string{"Restored", "Links", "carol"}, &v), IsNil)
+	c.Assert(v, DeepEquals, bob)
+	target, err := s2.GetLink([]string{"Restored", "Links", "carol"})
+	c.Assert(err, IsNil)
+	c.Assert(target, DeepEquals, []string{"Restored", "Accounts", "carol"})
+
+	s3 := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s3.CreateTable(), IsNil)
+	c.Assert(b.Restore(ctx, s3, []string{"Tenants", "t1"}, start.Add(3*time.Minute)), IsNil)
+	c.Assert(list(s3), DeepEquals, []string{"Tenants", "Tenants/t1", "Tenants/t1/Accounts",
+		"Tenants/t1/Accounts/carol", "Tenants/t1/Links", "Tenants/t1/Links/carol"})
+
+	s4 := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s4.CreateTable(), IsNil)
+	c.Assert(b.Restore(ctx, s4, nil, start.Add(-time.Hour)), ErrorMatches, "no baseline was taken before .*")
+	c.Assert(b.Restore(ctx, s4, nil, start), IsNil)
+	c.Assert(list(s4), DeepEquals, []string{"Accounts", "Accounts/alice"})
+}
@@ -0,0 +1,86 @@
+package dynamotree
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+)
+
+var benchmarkAccount = AccountT{
+	ID:    "12345",
+	Name:  "alice",
+	Email: "alice@example.com",
+}
+
+func benchmarkTree(b *testing.B) *Tree {
+	s := &Tree{
+		TableName: uniuri.New(),
+		DB:        dynamodb.New(session.New(), testConfig),
+	}
+	if err := s.CreateTable(); err != nil {
+		b.Fatal(err)
+	}
+	return s
+}
+
+// BenchmarkPutRequests measures the cost of preparing the rows for Put,
+// excluding the round trip to DynamoDB.
+func BenchmarkPutRequests(b *testing.B) {
+	for _, depth := range []int{2, 4, 16} {
+		b.Run(fmt.Sprintf("depth=%d", depth), func(b *testing.B) {
+			s := &Tree{TableName: "benchmark"}
+			s.initOnce.Do(s.init)
+			key := []string{}
+			for i := 0; i < depth; i++ {
+				key = append(key, fmt.Sprintf("part%d", i))
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.putRequests(key, &benchmarkAccount); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkPut(b *testing.B) {
+	s := benchmarkTree(b)
+	key := []string{"Accounts", "12345", "Links", "xyzpdq"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.Put(key, &benchmarkAccount); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	s := benchmarkTree(b)
+	key := []string{"Accounts", "12345", "Links", "xyzpdq"}
+	if err := s.Put(key, &benchmarkAccount); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var v AccountT
+		if err := s.Get(key, &v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeKey(b *testing.B) {
+	s := &Tree{}
+	key := []string{"Accounts", "12345", "Links", "xyzpdq"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.EncodeKey(key)
+	}
+}
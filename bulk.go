@@ -0,0 +1,421 @@
+package dynamotree
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// DeleteAll removes the object or link at prefix and every key below it,
+// as Delete does for each, deepest first. opts apply to each Delete, so for
+// example FailIfReferenced causes DeleteAll to stop at the first key to
+// which a link refers. If DeleteAll fails part way through, the keys it
+// has not yet reached are left in place; calling it again removes them.
+// Its progress can be reported using WithProgress and recorded using
+// WithCheckpoint.
+func (t *Tree) DeleteAll(prefix []string, opts ...Option) (err error) {
+	defer annotateError(&err, "DeleteAll", prefix)
+	o, cancel := t.startCall("DeleteAll", prefix, &err, opts)
+	defer cancel()
+	if err := refuseCapability("DeleteAll", prefix, o); err != nil {
+		return err
+	}
+	if err := t.ready(); err != nil {
+		return err
+	}
+	prefix, err = t.transformKey(prefix)
+	if err != nil {
+		return err
+	}
+	if err := t.ValidateKey(prefix); err != nil {
+		return err
+	}
+	j, err := t.startJob("DeleteAll", [][]string{prefix}, o)
+	if err != nil {
+		return err
+	}
+	remove := func(key []string) error { return t.Delete(key, opts...) }
+	err = j.walkPostOrder(t, prefix, remove)
+	if err == nil {
+		if err = remove(prefix); err == nil {
+			err = j.done(prefix)
+		}
+	}
+	return j.finish(err)
+}
+
+// LimitWriteRate causes DeleteChildren to write no more than rowsPerSecond
+// rows each second, on average, so that emptying a large directory does
+// not starve other traffic on the table.
+func LimitWriteRate(rowsPerSecond float64) Option {
+	return func(o *callOptions) {
+		o.writeRate = rowsPerSecond
+	}
+}
+
+// DeleteChildren removes the objects and links stored at the immediate
+// children of prefix for which filter, if not nil, returns true, along with
+// their directory entries, and returns the number removed. The directory
+// is queried page by page and the children removed as they are listed, in
+// batches of 100 using BatchWriteItem, so a directory of any size can be
+// emptied in one call.
+//
+// Each child is removed as Delete would remove it, so a child below which
+// other keys are stored keeps its directory entry; use DeleteAll to remove
+// those keys too. opts apply as they do to Delete, and in addition the
+// children examined can be limited using MaxItems, the rate of writes
+// limited using LimitWriteRate, and progress reported using WithProgress.
+// If DeleteChildren fails part way through, the children it removed are
+// not restored; calling it again removes the rest.
+func (t *Tree) DeleteChildren(prefix []string, filter func(name string) bool, opts ...Option) (deleted int, err error) {
+	defer annotateError(&err, "DeleteChildren", prefix)
+	o, cancel := t.startCall("DeleteChildren", prefix, &err, opts)
+	defer cancel()
+	if err := refuseCapability("DeleteChildren", prefix, o); err != nil {
+		return 0, err
+	}
+	if err := t.ready(); err != nil {
+		return 0, err
+	}
+	prefix, err = t.transformKey(prefix)
+	if err != nil {
+		return 0, err
+	}
+	if err := t.ValidateKey(prefix); err != nil {
+		return 0, err
+	}
+	j, err := t.startJob("DeleteChildren", [][]string{prefix}, o)
+	if err != nil {
+		return 0, err
+	}
+
+	d := &childDeleter{tree: t, o: o, j: j, started: t.now()}
+	pending := [][]string{}
+	t.list(prefix, func(name string, innerErr error) bool {
+		if innerErr != nil {
+			err = innerErr
+			return false
+		}
+		if filter != nil && !filter(name) {
+			return true
+		}
+		key := make([]string, len(prefix), len(prefix)+1)
+		copy(key, prefix)
+		pending = append(pending, append(key, name))
+		if len(pending) == maxBatchGetKeys {
+			err = d.delete(pending)
+			pending = nil
+		}
+		return err == nil
+	}, o)
+	if err == nil && len(pending) > 0 {
+		err = d.delete(pending)
+	}
+	return d.deleted, j.finish(err)
+}
+
+// childDeleter holds the state of a call to DeleteChildren.
+type childDeleter struct {
+	tree    *Tree
+	o       *callOptions
+	j       *job
+	started time.Time
+
+	// rows is the number of rows written, and deleted the number of
+	// objects and links removed.
+	rows    int
+	deleted int
+}
+
+// delete removes the objects and links at keys, and their directory
+// entries, using as few batch writes as it can.
+func (d *childDeleter) delete(keys [][]string) error {
+	t, o := d.tree, d.o
+	for _, key := range keys {
+		defer t.lockKey(key)()
+	}
+	infos, err := t.statKeys(keys, o)
+	if err != nil {
+		return err
+	}
+
+	writeRequests := []*dynamodb.WriteRequest{}
+	leafKinds := map[RowID]NodeKind{}
+	removed := [][]string{}
+	for _, info := range infos {
+		key := info.Key
+		// The directory entry is kept so that the keys below remain
+		// reachable by List and Walk.
+		hasChildren, err := t.hasChildren(t.dirKey(key), o)
+		if err != nil {
+			return err
+		}
+		if info.Kind == NodeDirectory && hasChildren {
+			continue
+		}
+		if _, err := t.checkGuards(key, guardDelete, o); err != nil {
+			return err
+		}
+		requests, err := t.deleteRequests(key)
+		if err != nil {
+			return err
+		}
+		if info.Kind == NodeDirectory {
+			// Only a directory entry left behind by an earlier failure
+			// remains.
+			writeRequests = append(writeRequests, requests[0])
+			continue
+		}
+		if o.failIfReferenced {
+			references, err := t.references(t.EncodeKey(key), o)
+			if err != nil {
+				return err
+			}
+			if len(references) > 0 {
+				return &ReferencesError{Op: "DeleteChildren", Key: key, References: references}
+			}
+		}
+		if hasChildren {
+			requests = requests[1:]
+		}
+		leafKinds[rowID(requests[len(requests)-1])] = info.Kind
+		if t.MaintainBacklinks && info.Kind == NodeLink {
+			requests = append(requests, &dynamodb.WriteRequest{
+				DeleteRequest: &dynamodb.DeleteRequest{
+					Key: t.backlinkRow(key, t.EncodeKey(info.LinkTarget)),
+				},
+			})
+		}
+		writeRequests = append(writeRequests, requests...)
+		removed = append(removed, key)
+	}
+	writeRequests = t.tenantRows(writeRequests)
+	if len(writeRequests) == 0 {
+		return nil
+	}
+	err = t.batchWrite(writeRequests, o)
+	t.countWrites(writeRequests, err, leafKinds, o)
+	if err != nil {
+		return err
+	}
+	d.rows += len(writeRequests)
+
+	for _, key := range removed {
+		if err := t.refreshLinkCopies(key, nil, o); err != nil {
+			return err
+		}
+		d.deleted++
+		if err := d.j.done(key); err != nil {
+			return err
+		}
+	}
+	return d.pace()
+}
+
+// pace waits until the rows written do not exceed the rate given using
+// LimitWriteRate.
+func (d *childDeleter) pace() error {
+	if d.o.writeRate <= 0 {
+		return nil
+	}
+	expected := time.Duration(float64(d.rows) / d.o.writeRate * float64(time.Second))
+	elapsed := d.tree.since(d.started)
+	if elapsed >= expected {
+		return nil
+	}
+	select {
+	case <-d.tree.clock().After(expected - elapsed):
+		return nil
+	case <-d.o.context().Done():
+		return d.o.context().Err()
+	}
+}
+
+// Copy stores a copy of the object or link at srcPrefix, and of each one
+// below it, at the corresponding key below dstPrefix in dst, which may be
+// t itself. Links are copied as they are, so a copy of a link to a key
+// below srcPrefix refers to the original key rather than to its copy.
+// opts apply to each Put and PutLink to dst. Copy's progress can be
+// reported using WithProgress and recorded using WithCheckpoint.
+func (t *Tree) Copy(srcPrefix []string, dst *Tree, dstPrefix []string, opts ...Option) (err error) {
+	defer annotateError(&err, "Copy", srcPrefix)
+	c, err := t.newCopier("Copy", srcPrefix, dst, dstPrefix, opts)
+	if err != nil {
+		return err
+	}
+	defer c.cancel()
+	return c.j.finish(c.copyAll(false))
+}
+
+// Sync makes the keys at and below dstPrefix in dst the same as those at
+// and below srcPrefix, as Copy does, but writes only the objects and links
+// that differ, and then removes those that are not stored below
+// srcPrefix. opts apply to each Put, PutLink and Delete of dst. Sync's
+// progress can be reported using WithProgress and recorded using
+// WithCheckpoint.
+func (t *Tree) Sync(srcPrefix []string, dst *Tree, dstPrefix []string, opts ...Option) (err error) {
+	defer annotateError(&err, "Sync", srcPrefix)
+	c, err := t.newCopier("Sync", srcPrefix, dst, dstPrefix, opts)
+	if err != nil {
+		return err
+	}
+	defer c.cancel()
+	if c.j.phase != syncDeletePhase {
+		if err := c.copyAll(true); err != nil {
+			return c.j.finish(err)
+		}
+		if err := c.j.startPhase(syncDeletePhase); err != nil {
+			return c.j.finish(err)
+		}
+	}
+	err = c.j.walkPostOrder(dst, c.dstPrefix, c.prune)
+	if err == nil {
+		if err = c.prune(c.dstPrefix); err == nil {
+			err = c.j.done(c.dstPrefix)
+		}
+	}
+	return c.j.finish(err)
+}
+
+// syncDeletePhase is the phase of Sync in which the keys of dst that are
+// not in the source are removed.
+const syncDeletePhase = "delete"
+
+// copier holds the state of a call to Copy or Sync.
+type copier struct {
+	src, dst             *Tree
+	srcPrefix, dstPrefix []string
+	opts                 []Option
+	o                    *callOptions
+	cancel               func()
+	j                    *job
+}
+
+func (t *Tree) newCopier(op string, srcPrefix []string, dst *Tree, dstPrefix []string, opts []Option) (*copier, error) {
+	if err := t.ready(); err != nil {
+		return nil, err
+	}
+	if err := dst.ready(); err != nil {
+		return nil, err
+	}
+	srcPrefix, err := t.transformKey(srcPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if dstPrefix, err = dst.transformKey(dstPrefix); err != nil {
+		return nil, err
+	}
+	if dst == t && hasKeyPrefix(dstPrefix, srcPrefix) {
+		return nil, fmt.Errorf("cannot %s %q to a key below itself", op, srcPrefix)
+	}
+	o, cancel := newCallOptions(opts)
+	if err := refuseCapability(op, srcPrefix, o); err != nil {
+		cancel()
+		return nil, err
+	}
+	j, err := t.startJob(op, [][]string{srcPrefix, dstPrefix}, o)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &copier{src: t, dst: dst, srcPrefix: srcPrefix, dstPrefix: dstPrefix,
+		opts: opts, o: o, cancel: cancel, j: j}, nil
+}
+
+// copyAll copies srcPrefix and each key below it. If onlyChanged is true,
+// keys that are already the same in dst are not written.
+func (c *copier) copyAll(onlyChanged bool) error {
+	copyKey := func(key []string) (bool, error) {
+		return true, wrapError(c.j.op, key, c.copy(key, onlyChanged))
+	}
+	if skip, _ := c.j.skip(c.srcPrefix); !skip {
+		if _, err := copyKey(c.srcPrefix); err != nil {
+			return err
+		}
+		if err := c.j.done(c.srcPrefix); err != nil {
+			return err
+		}
+	}
+	_, err := c.j.walk(c.srcPrefix, copyKey)
+	return err
+}
+
+// rebase returns the key in one tree corresponding to key in the other.
+func rebase(key, from, to []string) []string {
+	rv := make([]string, 0, len(to)+len(key)-len(from))
+	return append(append(rv, to...), key[len(from):]...)
+}
+
+// copy copies the object or link at key, if there is one.
+func (c *copier) copy(key []string, onlyChanged bool) error {
+	row, err := c.src.getRow(c.src.EncodeKey(key), c.o)
+	if err != nil || row == nil {
+		return err
+	}
+	dstKey := rebase(key, c.srcPrefix, c.dstPrefix)
+	if onlyChanged {
+		dstRow, err := c.dst.getRow(c.dst.EncodeKey(dstKey), c.o)
+		if err != nil {
+			return err
+		}
+		if c.same(row, dstRow) {
+			return nil
+		}
+	}
+	if linkTarget, ok := c.src.linkTarget(row); ok {
+		return c.dst.PutLink(dstKey, c.src.DecodeKey(linkTarget), c.opts...)
+	}
+	return c.dst.Put(dstKey, rawItem(objectAttributes(c.src, row)), c.opts...)
+}
+
+// same returns true if the row of src stored at a key is the same as that
+// of dst stored at the corresponding key.
+func (c *copier) same(srcRow, dstRow map[string]*dynamodb.AttributeValue) bool {
+	if dstRow == nil {
+		return false
+	}
+	srcTarget, srcIsLink := c.src.linkTarget(srcRow)
+	dstTarget, dstIsLink := c.dst.linkTarget(dstRow)
+	if srcIsLink || dstIsLink {
+		return srcIsLink && dstIsLink &&
+			reflect.DeepEqual(c.src.DecodeKey(srcTarget), c.dst.DecodeKey(dstTarget))
+	}
+	attributes := func(row map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+		rv := make(map[string]*dynamodb.AttributeValue, len(row))
+		for name, value := range row {
+			if name != "Key" && name != "Child" {
+				rv[name] = value
+			}
+		}
+		return rv
+	}
+	return len(Diff(attributes(srcRow), attributes(dstRow))) == 0
+}
+
+// prune removes dstKey from dst if nothing is stored at the corresponding
+// key in the source.
+func (c *copier) prune(dstKey []string) error {
+	key := rebase(dstKey, c.dstPrefix, c.srcPrefix)
+	row, err := c.src.getRow(c.src.EncodeKey(key), c.o)
+	if err != nil || row != nil {
+		return err
+	}
+	dstRow, err := c.dst.getRow(c.dst.EncodeKey(dstKey), c.o)
+	if err != nil {
+		return err
+	}
+	if dstRow == nil && len(dstKey) > 0 {
+		// Only the directory entry remains, which Delete keeps if there
+		// are keys below it.
+		hasChildren, err := c.dst.hasChildren(c.dst.dirKey(dstKey), c.o)
+		if err != nil || hasChildren {
+			return err
+		}
+	}
+	if dstRow == nil && len(dstKey) == 0 {
+		return nil
+	}
+	return c.dst.Delete(dstKey, c.opts...)
+}
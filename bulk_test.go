@@ -0,0 +1,241 @@
+package dynamotree
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+// walkKeys returns the keys below prefix in s.
+func walkKeys(c *C, s *Tree, prefix []string) [][]string {
+	keys := [][]string{}
+	s.Walk(prefix, func(key []string, err error) bool {
+		c.Assert(err, IsNil)
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+func (suite *StoreImplTest) TestDeleteAll(c *C) {
+	defer func(n int) { checkpointItems = n }(checkpointItems)
+	checkpointItems = 1
+
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+	v := AccountT{ID: "12345", Name: "alice"}
+	c.Assert(s.Put([]string{"Accounts"}, &v), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "12345", "Links", "xyzpdq"}, &v), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "6789"}, &v), IsNil)
+	c.Assert(s.PutLink([]string{"Links", "xyzpdq"}, []string{"Accounts", "6789"}), IsNil)
+
+	// The first attempt is cancelled after two keys are removed.
+	ctx, cancel := context.WithCancel(context.Background())
+	err := s.DeleteAll([]string{"Accounts"}, WithContext(ctx), WithCheckpoint("delete"),
+		WithProgress(func(p Progress) {
+			if p.Items == 2 {
+				cancel()
+			}
+		}))
+	c.Assert(err, ErrorMatches, "(?s).*context canceled.*")
+	c.Assert(walkKeys(c, s, nil), DeepEquals, [][]string{
+		{"Accounts"},
+		{"Accounts", "12345"},
+		{"Accounts", "6789"},
+		{"Links"},
+		{"Links", "xyzpdq"},
+	})
+
+	items := 0
+	err = s.DeleteAll([]string{"Accounts"}, WithCheckpoint("delete"), WithProgress(func(p Progress) {
+		c.Assert(p.Resumed, Equals, true)
+		items = p.Items
+	}))
+	c.Assert(err, IsNil)
+	c.Assert(items, Equals, 5)
+	c.Assert(walkKeys(c, s, nil), DeepEquals, [][]string{
+		{"Links"},
+		{"Links", "xyzpdq"},
+	})
+	c.Assert(s.Get([]string{"Accounts"}, &v), ErrorIs, ErrNotFound)
+	c.Assert(s.Get([]string{"Accounts", "6789"}, &v), ErrorIs, ErrNotFound)
+	row, err := s.getItem(MetadataKey, checkpointChild("delete"), nil)
+	c.Assert(err, IsNil)
+	c.Assert(row, IsNil)
+}
+
+func (suite *StoreImplTest) TestCopy(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+	alice := AccountT{ID: "12345", Name: "alice"}
+	bob := AccountT{ID: "6789", Name: "bob"}
+	c.Assert(s.Put([]string{"Tenants", "t1"}, &alice), IsNil)
+	c.Assert(s.Put([]string{"Tenants", "t1", "Accounts", "12345"}, &alice), IsNil)
+	c.Assert(s.Put([]string{"Tenants", "t1", "Accounts", "6789"}, &bob), IsNil)
+	c.Assert(s.PutLink([]string{"Tenants", "t1", "ByName", "bob"}, []string{"Tenants", "t1", "Accounts", "6789"}), IsNil)
+
+	err := s.Copy([]string{"Tenants", "t1"}, s, []string{"Tenants", "t1", "Copy"})
+	c.Assert(err, ErrorMatches, `cannot Copy \["Tenants" "t1"\] to a key below itself`)
+
+	s2 := &Tree{TableName: uniuri.New(), DB: db, SpecialCharacter: "|"}
+	c.Assert(s2.CreateTable(), IsNil)
+	for _, dst := range []*Tree{s, s2} {
+		c.Assert(s.Copy([]string{"Tenants", "t1"}, dst, []string{"Tenants", "t2"}), IsNil)
+		c.Assert(walkKeys(c, dst, []string{"Tenants", "t2"}), DeepEquals, [][]string{
+			{"Tenants", "t2", "Accounts"},
+			{"Tenants", "t2", "Accounts", "12345"},
+			{"Tenants", "t2", "Accounts", "6789"},
+			{"Tenants", "t2", "ByName"},
+			{"Tenants", "t2", "ByName", "bob"},
+		})
+		var v AccountT
+		c.Assert(dst.Get([]string{"Tenants", "t2"}, &v), IsNil)
+		c.Assert(v, DeepEquals, alice)
+		c.Assert(dst.Get([]string{"Tenants", "t2", "Accounts", "6789"}, &v), IsNil)
+		c.Assert(v, DeepEquals, bob)
+		target, err := dst.GetLink([]string{"Tenants", "t2", "ByName", "bob"})
+		c.Assert(err, IsNil)
+		c.Assert(target, DeepEquals, []string{"Tenants", "t1", "Accounts", "6789"})
+	}
+}
+
+func (suite *StoreImplTest) TestSync(c *C) {
+	defer func(n int) { checkpointItems = n }(checkpointItems)
+	checkpointItems = 1
+
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+	s2 := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s2.CreateTable(), IsNil)
+	alice := AccountT{ID: "12345", Name: "alice"}
+	bob := AccountT{ID: "6789", Name: "bob"}
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &alice), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "6789"}, &bob), IsNil)
+	c.Assert(s.PutLink([]string{"ByName", "bob"}, []string{"Accounts", "6789"}), IsNil)
+
+	c.Assert(s2.Put([]string{"Mirror", "Accounts", "12345"}, &bob), IsNil)
+	c.Assert(s2.Put([]string{"Mirror", "Accounts", "6789"}, &bob), IsNil)
+	c.Assert(s2.Put([]string{"Mirror", "Accounts", "0000", "Old"}, &bob), IsNil)
+	c.Assert(s2.Put([]string{"Mirror", "Removed"}, &bob), IsNil)
+	c.Assert(s2.PutLink([]string{"Mirror", "ByName", "bob"}, []string{"Accounts", "12345"}), IsNil)
+
+	// The first attempt is cancelled part way through copying.
+	ctx, cancel := context.WithCancel(context.Background())
+	err := s.Sync(nil, s2, []string{"Mirror"}, WithContext(ctx), WithCheckpoint("sync"),
+		WithProgress(func(p Progress) {
+			if p.Items == 2 {
+				cancel()
+			}
+		}))
+	c.Assert(err, ErrorMatches, "(?s).*context canceled.*")
+
+	keys := [][]string{}
+	c.Assert(s.Sync(nil, s2, []string{"Mirror"}, WithCheckpoint("sync"), WithProgress(func(p Progress) {
+		c.Assert(p.Resumed, Equals, true)
+		keys = append(keys, p.Key)
+	})), IsNil)
+	c.Assert(keys, DeepEquals, [][]string{
+		// Copying resumes after the last key copied
+		{"Accounts", "12345"},
+		{"Accounts", "6789"},
+		{"ByName"},
+		{"ByName", "bob"},
+		// and then the keys of the destination are removed, deepest first
+		{"Mirror", "Accounts", "0000", "Old"},
+		{"Mirror", "Accounts", "0000"},
+		{"Mirror", "Accounts", "12345"},
+		{"Mirror", "Accounts", "6789"},
+		{"Mirror", "Accounts"},
+		{"Mirror", "ByName", "bob"},
+		{"Mirror", "ByName"},
+		{"Mirror", "Removed"},
+		{"Mirror"},
+	})
+
+	c.Assert(walkKeys(c, s2, nil), DeepEquals, [][]string{
+		{"Mirror"},
+		{"Mirror", "Accounts"},
+		{"Mirror", "Accounts", "12345"},
+		{"Mirror", "Accounts", "6789"},
+		{"Mirror", "ByName"},
+		{"Mirror", "ByName", "bob"},
+	})
+	var v AccountT
+	c.Assert(s2.Get([]string{"Mirror", "Accounts", "12345"}, &v), IsNil)
+	c.Assert(v, DeepEquals, alice)
+	target, err := s2.GetLink([]string{"Mirror", "ByName", "bob"})
+	c.Assert(err, IsNil)
+	c.Assert(target, DeepEquals, []string{"Accounts", "6789"})
+	c.Assert(s2.Get([]string{"Mirror", "Removed"}, &v), ErrorIs, ErrNotFound)
+}
+
+func (suite *StoreImplTest) TestDeleteChildren(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db, MaintainBacklinks: true}
+	c.Assert(s.CreateTable(), IsNil)
+	v := AccountT{ID: "12345", Name: "alice"}
+	for i := 0; i < 150; i++ {
+		c.Assert(s.Put([]string{"Accounts", fmt.Sprintf("a%03d", i)}, &v), IsNil)
+	}
+	c.Assert(s.Put([]string{"Accounts", "keep"}, &v), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "a000", "Links", "xyzpdq"}, &v), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "dir", "Links", "xyzpdq"}, &v), IsNil)
+	c.Assert(s.PutLink([]string{"Accounts", "alias"}, []string{"Accounts", "keep"}), IsNil)
+
+	writes := 0
+	countWrites := WithRequestOptions(func(r *request.Request) {
+		if r.Operation.Name == "BatchWriteItem" {
+			writes++
+		}
+	})
+	items := 0
+	deleted, err := s.DeleteChildren([]string{"Accounts"}, func(name string) bool {
+		return strings.HasPrefix(name, "a")
+	}, countWrites, LimitWriteRate(100000), WithProgress(func(p Progress) { items = p.Items }))
+	c.Assert(err, IsNil)
+	c.Assert(deleted, Equals, 151)
+	c.Assert(items, Equals, 151)
+	// Each of the two batches of children is written 25 rows at a time.
+	c.Assert(writes, Equals, 13)
+
+	c.Assert(walkKeys(c, s, []string{"Accounts"}), DeepEquals, [][]string{
+		{"Accounts", "a000"},
+		{"Accounts", "a000", "Links"},
+		{"Accounts", "a000", "Links", "xyzpdq"},
+		{"Accounts", "dir"},
+		{"Accounts", "dir", "Links"},
+		{"Accounts", "dir", "Links", "xyzpdq"},
+		{"Accounts", "keep"},
+	})
+	c.Assert(s.Get([]string{"Accounts", "a000"}, &v), ErrorIs, ErrNotFound)
+	refs, err := s.References([]string{"Accounts", "keep"})
+	c.Assert(err, IsNil)
+	c.Assert(refs, HasLen, 0)
+
+	// A child to which a link refers is not removed with FailIfReferenced.
+	c.Assert(s.PutLink([]string{"Current"}, []string{"Accounts", "keep"}), IsNil)
+	deleted, err = s.DeleteChildren([]string{"Accounts"}, nil, FailIfReferenced())
+	c.Assert(err, ErrorIs, ErrHasReferences)
+	c.Assert(deleted, Equals, 0)
+
+	deleted, err = s.DeleteChildren([]string{"Accounts"}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(deleted, Equals, 1)
+	c.Assert(walkKeys(c, s, []string{"Accounts"}), DeepEquals, [][]string{
+		{"Accounts", "a000"},
+		{"Accounts", "a000", "Links"},
+		{"Accounts", "a000", "Links", "xyzpdq"},
+		{"Accounts", "dir"},
+		{"Accounts", "dir", "Links"},
+		{"Accounts", "dir", "Links", "xyzpdq"},
+	})
+}
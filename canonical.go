@@ -0,0 +1,233 @@
+package dynamotree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Canonicalize returns a copy of attributes in which each value that
+// DynamoDB stores alike is written alike, so that attributes can be
+// compared regardless of how they were marshalled or returned by
+// DynamoDB: numbers are written as plain decimals without leading or
+// trailing zeros, so that "1.50", "1.5" and "15E-1" are all "1.5", and
+// the members of sets are sorted, numbers by their value. Hash and Diff
+// compare attributes in this form.
+func Canonicalize(attributes map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	if attributes == nil {
+		return nil
+	}
+	rv := make(map[string]*dynamodb.AttributeValue, len(attributes))
+	for name, value := range attributes {
+		rv[name] = canonicalValue(value)
+	}
+	return rv
+}
+
+// Hash returns a digest of attributes, as a base64 string, that is the
+// same for any two sets of attributes with the same Canonicalize form,
+// such as to record in an audit log what an object held, or to tell
+// whether it has changed without keeping a copy of it.
+func Hash(attributes map[string]*dynamodb.AttributeValue) string {
+	h := sha256.New()
+	writeCanonical(h, &dynamodb.AttributeValue{M: Canonicalize(attributes)})
+	return base64.RawStdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Diff returns the names of the attributes, in order, that are in only one
+// of a and b, or whose values differ once they are in Canonicalize form.
+func Diff(a, b map[string]*dynamodb.AttributeValue) []string {
+	names := []string{}
+	encode := func(v *dynamodb.AttributeValue) string {
+		buf := bytes.NewBuffer(nil)
+		writeCanonical(buf, canonicalValue(v))
+		return buf.String()
+	}
+	for name, value := range a {
+		other, ok := b[name]
+		if !ok || encode(value) != encode(other) {
+			names = append(names, name)
+		}
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// canonicalValue returns a copy of v in Canonicalize form.
+func canonicalValue(v *dynamodb.AttributeValue) *dynamodb.AttributeValue {
+	switch {
+	case v == nil:
+		return nil
+	case v.N != nil:
+		return &dynamodb.AttributeValue{N: aws.String(canonicalNumber(*v.N))}
+	case v.B != nil:
+		return &dynamodb.AttributeValue{B: append([]byte{}, v.B...)}
+	case v.SS != nil:
+		members := aws.StringValueSlice(v.SS)
+		sort.Strings(members)
+		return &dynamodb.AttributeValue{SS: aws.StringSlice(members)}
+	case v.NS != nil:
+		members := make([]string, len(v.NS))
+		for i, n := range v.NS {
+			members[i] = canonicalNumber(aws.StringValue(n))
+		}
+		sort.Slice(members, func(i, j int) bool { return numberLess(members[i], members[j]) })
+		return &dynamodb.AttributeValue{NS: aws.StringSlice(members)}
+	case v.BS != nil:
+		members := make([][]byte, len(v.BS))
+		for i, b := range v.BS {
+			members[i] = append([]byte{}, b...)
+		}
+		sort.Slice(members, func(i, j int) bool { return bytes.Compare(members[i], members[j]) < 0 })
+		return &dynamodb.AttributeValue{BS: members}
+	case v.L != nil:
+		elements := make([]*dynamodb.AttributeValue, len(v.L))
+		for i, element := range v.L {
+			elements[i] = canonicalValue(element)
+		}
+		return &dynamodb.AttributeValue{L: elements}
+	case v.M != nil:
+		return &dynamodb.AttributeValue{M: Canonicalize(v.M)}
+	}
+	rv := *v
+	return &rv
+}
+
+// writeCanonical writes to w an encoding of v, which is in Canonicalize
+// form, in which the names of maps are sorted. Each value is prefixed with
+// its type, and each string with its length, so that no two values have
+// the same encoding.
+func writeCanonical(w io.Writer, v *dynamodb.AttributeValue) {
+	writeString := func(s string) { fmt.Fprintf(w, "%d:%s", len(s), s) }
+	writeSet := func(typ string, members []string) {
+		fmt.Fprintf(w, "%s%d:", typ, len(members))
+		for _, member := range members {
+			writeString(member)
+		}
+	}
+	switch {
+	case v == nil:
+		io.WriteString(w, "-")
+	case v.S != nil:
+		io.WriteString(w, "S")
+		writeString(*v.S)
+	case v.N != nil:
+		io.WriteString(w, "N")
+		writeString(*v.N)
+	case v.B != nil:
+		io.WriteString(w, "B")
+		writeString(string(v.B))
+	case v.BOOL != nil:
+		fmt.Fprintf(w, "BOOL%t", *v.BOOL)
+	case v.NULL != nil:
+		io.WriteString(w, "NULL")
+	case v.SS != nil:
+		writeSet("SS", aws.StringValueSlice(v.SS))
+	case v.NS != nil:
+		writeSet("NS", aws.StringValueSlice(v.NS))
+	case v.BS != nil:
+		members := make([]string, len(v.BS))
+		for i, b := range v.BS {
+			members[i] = string(b)
+		}
+		writeSet("BS", members)
+	case v.L != nil:
+		fmt.Fprintf(w, "L%d:", len(v.L))
+		for _, element := range v.L {
+			writeCanonical(w, element)
+		}
+	case v.M != nil:
+		names := make([]string, 0, len(v.M))
+		for name := range v.M {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(w, "M%d:", len(names))
+		for _, name := range names {
+			writeString(name)
+			writeCanonical(w, v.M[name])
+		}
+	default:
+		io.WriteString(w, "-")
+	}
+}
+
+// maxNumberExponent bounds the exponent of the numbers that canonicalNumber
+// writes out in full. DynamoDB allows exponents from -130 to 125.
+const maxNumberExponent = 1000
+
+// canonicalNumber returns n, a number as DynamoDB accepts it, as a plain
+// decimal without a sign on zero, leading zeros or trailing zeros in its
+// fraction. Text that is not such a number is returned unchanged.
+func canonicalNumber(n string) string {
+	s := strings.TrimSpace(n)
+	negative := strings.HasPrefix(s, "-")
+	if negative || strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+
+	exponent := 0
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.Atoi(s[i+1:])
+		if err != nil || e > maxNumberExponent || e < -maxNumberExponent {
+			return n
+		}
+		s, exponent = s[:i], e
+	}
+	integer, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		integer, fraction = s[:i], s[i+1:]
+	}
+	digits := integer + fraction
+	if digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return n
+	}
+
+	// point is the number of digits before the decimal point.
+	point := len(integer) + exponent
+	trimmed := strings.TrimLeft(digits, "0")
+	point -= len(digits) - len(trimmed)
+	digits = strings.TrimRight(trimmed, "0")
+	if digits == "" {
+		return "0"
+	}
+
+	var rv string
+	switch {
+	case point <= 0:
+		rv = "0." + strings.Repeat("0", -point) + digits
+	case point >= len(digits):
+		rv = digits + strings.Repeat("0", point-len(digits))
+	default:
+		rv = digits[:point] + "." + digits[point:]
+	}
+	if negative {
+		rv = "-" + rv
+	}
+	return rv
+}
+
+// numberLess returns true if the number a, in canonicalNumber form, is
+// less than b.
+func numberLess(a, b string) bool {
+	x, okX := new(big.Rat).SetString(a)
+	y, okY := new(big.Rat).SetString(b)
+	if !okX || !okY {
+		return a < b
+	}
+	return x.Cmp(y) < 0
+}
@@ -0,0 +1,55 @@
+package dynamotree
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestCanonicalize(c *C) {
+	for n, want := range map[string]string{
+		"1.50":   "1.5",
+		"15E-1":  "1.5",
+		"+0015":  "15",
+		"1e2":    "100",
+		"-0.000": "0",
+		"-.05":   "-0.05",
+		"0.5e-2": "0.005",
+		"12.5e1": "125",
+		"bogus":  "bogus",
+	} {
+		c.Assert(canonicalNumber(n), Equals, want, Commentf("%s", n))
+	}
+
+	a := map[string]*dynamodb.AttributeValue{
+		"N":  {N: aws.String("1.50")},
+		"NS": {NS: aws.StringSlice([]string{"10", "9", "1e1"})},
+		"SS": {SS: aws.StringSlice([]string{"b", "a"})},
+		"BS": {BS: [][]byte{[]byte("y"), []byte("x")}},
+		"M":  {M: map[string]*dynamodb.AttributeValue{"N": {N: aws.String("2.0")}}},
+		"L":  {L: []*dynamodb.AttributeValue{{N: aws.String("3.00")}, {S: aws.String("s")}}},
+	}
+	b := map[string]*dynamodb.AttributeValue{
+		"N":  {N: aws.String("1.5")},
+		"NS": {NS: aws.StringSlice([]string{"1e1", "10", "9"})},
+		"SS": {SS: aws.StringSlice([]string{"a", "b"})},
+		"BS": {BS: [][]byte{[]byte("x"), []byte("y")}},
+		"M":  {M: map[string]*dynamodb.AttributeValue{"N": {N: aws.String("2")}}},
+		"L":  {L: []*dynamodb.AttributeValue{{N: aws.String("3")}, {S: aws.String("s")}}},
+	}
+	c.Assert(Canonicalize(a), DeepEquals, Canonicalize(b))
+	c.Assert(aws.StringValueSlice(Canonicalize(a)["NS"].NS), DeepEquals, []string{"9", "10", "10"})
+	c.Assert(aws.StringValue(a["N"].N), Equals, "1.50")
+	c.Assert(Hash(a), Equals, Hash(b))
+	c.Assert(Diff(a, b), DeepEquals, []string{})
+
+	b["L"] = &dynamodb.AttributeValue{L: []*dynamodb.AttributeValue{{S: aws.String("s")}, {N: aws.String("3")}}}
+	b["Extra"] = &dynamodb.AttributeValue{S: aws.String("")}
+	delete(b, "SS")
+	c.Assert(Diff(a, b), DeepEquals, []string{"Extra", "L", "SS"})
+	c.Assert(Hash(a), Not(Equals), Hash(b))
+
+	// A string is not confused with a number of the same text.
+	c.Assert(Hash(map[string]*dynamodb.AttributeValue{"A": {S: aws.String("1")}}), Not(Equals),
+		Hash(map[string]*dynamodb.AttributeValue{"A": {N: aws.String("1")}}))
+}
@@ -0,0 +1,268 @@
+package dynamotree
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CapabilityOperations are the operations that check the token given by
+// WithCapability, and so the operations for which a Capability can be
+// minted.
+var CapabilityOperations = []string{
+	"AppendEvent",
+	"Delete",
+	"FastGet",
+	"Get",
+	"GetLink",
+	"List",
+	"Put",
+	"PutLink",
+	"PutLinkIfAbsent",
+	"Stat",
+	"Walk",
+}
+
+// Capability authorizes a single operation on a key, or on the keys at
+// and below it, until it expires. MintCapability signs a capability as a
+// token that can be handed to a frontend or a partner service, which
+// then passes it, such as through an API gateway, to a service that makes
+// the call on its behalf with WithCapability:
+//
+//	token, err := tree.MintCapability(dynamotree.Capability{
+//	    Operation: "Put",
+//	    Key:       []string{"Uploads", userID},
+//	    Prefix:    true,
+//	    Expires:   time.Now().Add(15 * time.Minute),
+//	})
+//
+//	// ... and later, given the token by the client:
+//	err = tree.Put(key, &upload, dynamotree.WithCapability(token))
+type Capability struct {
+	// Operation is the name of the operation authorized, one of
+	// CapabilityOperations.
+	Operation string
+
+	// Key is the key on which Operation is authorized, as it is given to
+	// the call, before KeyTransformer is applied to it. For List and
+	// Walk, it is the prefix listed.
+	//
+	// A link and the keys it is followed to must all be at or below Key:
+	// PutLink and PutLinkIfAbsent refuse a target outside it, and Get
+	// refuses to follow a link, or serve the copy of its target held by
+	// the link, to a key outside it.
+	Key []string
+
+	// Prefix authorizes the operation on the keys below Key too. It must
+	// be set for Walk, which reads the keys below the prefix it is given.
+	Prefix bool
+
+	// Expires is when the capability stops authorizing the operation.
+	Expires time.Time
+}
+
+// ErrCapabilityDenied is matched, using errors.Is, by the
+// *CapabilityError returned when a call is not authorized by the token
+// given to it by WithCapability.
+var ErrCapabilityDenied = errors.New("capability denied")
+
+// CapabilityError is returned when the token given by WithCapability to a
+// call of Op on Key does not authorize it. Reason tells why.
+type CapabilityError struct {
+	Op     string
+	Key    []string
+	Reason string
+}
+
+func (e *CapabilityError) Error() string {
+	return fmt.Sprintf("%s %q: capability denied: %s", e.Op, strings.Join(e.Key, "/"), e.Reason)
+}
+
+// Is returns true if target is ErrCapabilityDenied.
+func (e *CapabilityError) Is(target error) bool { return target == ErrCapabilityDenied }
+
+// errNoCapabilityKeys is returned by MintCapability if the tree has no
+// CapabilityKeys.
+var errNoCapabilityKeys = errors.New("dynamotree: Tree.CapabilityKeys is empty")
+
+// WithCapability causes a call to be made only if token, as returned by
+// MintCapability with the same Tree.CapabilityKeys, authorizes it, failing
+// with a *CapabilityError otherwise. The token is checked before the call
+// reads or writes anything. Only the CapabilityOperations check the token;
+// every other call given the option fails with a *CapabilityError, so that
+// a token is never ignored.
+func WithCapability(token string) Option {
+	return func(o *callOptions) {
+		o.capability = &token
+	}
+}
+
+// capabilityToken is the signed part of a token.
+type capabilityToken struct {
+	Operation string   `json:"op"`
+	Key       []string `json:"key"`
+	Prefix    bool     `json:"prefix,omitempty"`
+	Expires   int64    `json:"exp"`
+}
+
+// MintCapability returns a token authorizing c, signed with the first of
+// Tree.CapabilityKeys. The token holds c as it is, readable by its holder,
+// so c should not hold anything secret.
+func (t *Tree) MintCapability(c Capability) (string, error) {
+	if len(t.CapabilityKeys) == 0 {
+		return "", errNoCapabilityKeys
+	}
+	if !isCapabilityOperation(c.Operation) {
+		return "", fmt.Errorf("dynamotree: capabilities cannot be minted for %q", c.Operation)
+	}
+	if c.Operation == "Walk" && !c.Prefix {
+		return "", errors.New("dynamotree: a capability for Walk must set Prefix")
+	}
+	if c.Expires.IsZero() {
+		return "", errors.New("dynamotree: a capability must expire")
+	}
+	payload, err := json.Marshal(capabilityToken{
+		Operation: c.Operation,
+		Key:       c.Key,
+		Prefix:    c.Prefix,
+		Expires:   c.Expires.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(signCapability(t.CapabilityKeys[0], payload)), nil
+}
+
+// VerifyCapability returns the capability that token authorizes, if it
+// was signed with one of Tree.CapabilityKeys and has not expired, such as
+// for a gateway to inspect a token before passing a call on.
+func (t *Tree) VerifyCapability(token string) (c *Capability, err error) {
+	defer annotateError(&err, "VerifyCapability", nil)
+	c, reason := t.parseCapability(token)
+	if reason != "" {
+		return nil, &CapabilityError{Reason: reason}
+	}
+	return c, nil
+}
+
+// parseCapability returns the capability that token authorizes, or the
+// reason that it authorizes nothing.
+func (t *Tree) parseCapability(token string) (*Capability, string) {
+	i := strings.IndexByte(token, '.')
+	if i < 0 {
+		return nil, "malformed token"
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(token[:i])
+	if err != nil {
+		return nil, "malformed token"
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil {
+		return nil, "malformed token"
+	}
+	signed := false
+	for _, key := range t.CapabilityKeys {
+		if hmac.Equal(signature, signCapability(key, payload)) {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		return nil, "invalid signature"
+	}
+	var ct capabilityToken
+	if err := json.Unmarshal(payload, &ct); err != nil {
+		return nil, "malformed token"
+	}
+	c := &Capability{
+		Operation: ct.Operation,
+		Key:       ct.Key,
+		Prefix:    ct.Prefix,
+		Expires:   time.Unix(ct.Expires, 0),
+	}
+	if !t.now().Before(c.Expires) {
+		return nil, "expired"
+	}
+	return c, ""
+}
+
+// checkCapability returns a *CapabilityError if o was given a token by
+// WithCapability that does not authorize op on key, as given to the call.
+func (t *Tree) checkCapability(op string, key []string, o *callOptions) error {
+	if o.capability == nil {
+		return nil
+	}
+	c, reason := t.parseCapability(*o.capability)
+	switch {
+	case reason != "":
+	case c.Operation != op:
+		reason = fmt.Sprintf("granted for %s", c.Operation)
+	case c.Prefix && !hasKeyPrefix(key, c.Key), !c.Prefix && !equalKeys(key, c.Key):
+		reason = fmt.Sprintf("granted for %q", strings.Join(c.Key, "/"))
+	}
+	if reason != "" {
+		return &CapabilityError{Key: key, Reason: reason}
+	}
+	o.capabilityKey = c.Key
+	return nil
+}
+
+// checkCapabilityReach returns a *CapabilityError if o was given a token by
+// WithCapability and the call to key would reach reached, such as the
+// target of a link, which is not at or below the key that the token was
+// granted for. If stored is set, key and reached are as they are stored,
+// after KeyTransformer has been applied to them. It must be called after
+// checkCapability.
+func (t *Tree) checkCapabilityReach(key, reached []string, stored bool, o *callOptions) error {
+	if o == nil || o.capability == nil {
+		return nil
+	}
+	granted := o.capabilityKey
+	if stored {
+		var err error
+		if granted, err = t.transformKey(granted); err != nil {
+			return err
+		}
+	}
+	if !hasKeyPrefix(reached, granted) {
+		return &CapabilityError{Key: key, Reason: fmt.Sprintf("link to %q is outside %q",
+			strings.Join(reached, "/"), strings.Join(granted, "/"))}
+	}
+	return nil
+}
+
+// refuseCapability returns a *CapabilityError if o was given a token by
+// WithCapability, for the operations that do not check one.
+func refuseCapability(op string, key []string, o *callOptions) error {
+	if o.capability == nil {
+		return nil
+	}
+	return &CapabilityError{Op: op, Key: key, Reason: "not supported by " + op}
+}
+
+// signCapability returns the signature of the payload of a token.
+func signCapability(key, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func isCapabilityOperation(op string) bool {
+	for _, name := range CapabilityOperations {
+		if name == op {
+			return true
+		}
+	}
+	return false
+}
+
+// equalKeys returns true if a and b have the same parts.
+func equalKeys(a, b []string) bool {
+	return len(a) == len(b) && hasKeyPrefix(a, b)
+}
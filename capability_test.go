@@ -0,0 +1,125 @@
+package dynamotree
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestCapabilities(c *C) {
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	s := &Tree{TableName: uniuri.New(), DB: dynamodb.New(session.New(), testConfig), Clock: clock,
+		CapabilityKeys: [][]byte{[]byte("secret")}}
+	c.Assert(s.CreateTable(), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &AccountT{ID: "12345", Name: "alice"}), IsNil)
+
+	_, err := (&Tree{}).MintCapability(Capability{Operation: "Get", Expires: clock.now})
+	c.Assert(err, ErrorMatches, `dynamotree: Tree.CapabilityKeys is empty`)
+	_, err = s.MintCapability(Capability{Operation: "DeleteAll", Expires: clock.now})
+	c.Assert(err, ErrorMatches, `dynamotree: capabilities cannot be minted for "DeleteAll"`)
+	_, err = s.MintCapability(Capability{Operation: "Walk", Expires: clock.now})
+	c.Assert(err, ErrorMatches, `dynamotree: a capability for Walk must set Prefix`)
+	_, err = s.MintCapability(Capability{Operation: "Get"})
+	c.Assert(err, ErrorMatches, `dynamotree: a capability must expire`)
+
+	get, err := s.MintCapability(Capability{Operation: "Get", Key: []string{"Accounts", "12345"},
+		Expires: clock.now.Add(time.Minute)})
+	c.Assert(err, IsNil)
+	put, err := s.MintCapability(Capability{Operation: "Put", Key: []string{"Uploads"}, Prefix: true,
+		Expires: clock.now.Add(time.Minute)})
+	c.Assert(err, IsNil)
+
+	capability, err := s.VerifyCapability(put)
+	c.Assert(err, IsNil)
+	c.Assert(capability, DeepEquals, &Capability{Operation: "Put", Key: []string{"Uploads"}, Prefix: true,
+		Expires: time.Unix(clock.now.Add(time.Minute).Unix(), 0)})
+
+	v := AccountT{}
+	c.Assert(s.Get([]string{"Accounts", "12345"}, &v, WithCapability(get)), IsNil)
+	c.Assert(v.Name, Equals, "alice")
+	err = s.Get([]string{"Accounts", "67890"}, &v, WithCapability(get))
+	c.Assert(err, ErrorIs, ErrCapabilityDenied)
+	c.Assert(err, ErrorMatches, `Get "Accounts/67890": capability denied: granted for "Accounts/12345"`)
+	err = s.Delete([]string{"Accounts", "12345"}, WithCapability(get))
+	c.Assert(err, ErrorMatches, `Delete "Accounts/12345": capability denied: granted for Get`)
+	c.Assert(s.Get([]string{"Accounts", "12345"}, &v), IsNil)
+
+	c.Assert(s.Put([]string{"Uploads", "a", "1"}, &AccountT{ID: "1"}, WithCapability(put)), IsNil)
+	c.Assert(s.Put([]string{"Uploads"}, &AccountT{ID: "1"}, WithCapability(put)), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "1"}, &AccountT{ID: "1"}, WithCapability(put)), ErrorIs, ErrCapabilityDenied)
+	info, err := s.Stat([]string{"Accounts", "1"})
+	c.Assert(err, IsNil)
+	c.Assert(info.Kind, Equals, NodeMissing)
+
+	// A listing given a token for another prefix lists nothing.
+	names := []string{}
+	s.List([]string{"Accounts"}, func(name string, err error) bool {
+		c.Assert(err, ErrorIs, ErrCapabilityDenied)
+		names = append(names, name)
+		return true
+	}, WithCapability(put))
+	c.Assert(names, DeepEquals, []string{""})
+
+	// The operations that do not check a token refuse one.
+	err = s.DeleteAll([]string{"Accounts"}, WithCapability(get))
+	c.Assert(err, ErrorIs, ErrCapabilityDenied)
+	c.Assert(err, ErrorMatches, `DeleteAll "Accounts": capability denied: not supported by DeleteAll`)
+	c.Assert(s.Copy([]string{"Accounts"}, s, []string{"Copies"}, WithCapability(get)), ErrorIs, ErrCapabilityDenied)
+	c.Assert(s.WalkParallel([]string{"Accounts"}, 2, func([]string) error { return nil }, WithCapability(get)),
+		ErrorIs, ErrCapabilityDenied)
+	_, err = s.ExistsMulti([][]string{{"Accounts", "12345"}}, WithCapability(get))
+	c.Assert(err, ErrorIs, ErrCapabilityDenied)
+	s.ReadEvents([]string{"Accounts", "12345"}, time.Time{}, func(event *Event, err error) bool {
+		c.Assert(err, ErrorIs, ErrCapabilityDenied)
+		return true
+	}, WithCapability(get))
+	c.Assert(s.Get([]string{"Accounts", "12345"}, &v), IsNil)
+
+	// A link made with a token, and the links followed with one, stay
+	// within the key the token was granted for, even when the link holds
+	// a copy of its target.
+	s.LinkCopyMaxSize = 1024
+	c.Assert(s.Put([]string{"Tenants", "a", "1"}, &AccountT{ID: "a1"}), IsNil)
+	c.Assert(s.Put([]string{"Tenants", "b", "secret"}, &AccountT{ID: "secret"}), IsNil)
+	putLink, err := s.MintCapability(Capability{Operation: "PutLink", Key: []string{"Tenants", "a"}, Prefix: true,
+		Expires: clock.now.Add(time.Minute)})
+	c.Assert(err, IsNil)
+	err = s.PutLink([]string{"Tenants", "a", "escape"}, []string{"Tenants", "b", "secret"}, WithCapability(putLink))
+	c.Assert(err, ErrorMatches, `PutLink "Tenants/a/escape": capability denied: link to "Tenants/b/secret" is outside "Tenants/a"`)
+	c.Assert(s.PutLink([]string{"Tenants", "a", "one"}, []string{"Tenants", "a", "1"}, WithCapability(putLink)), IsNil)
+	c.Assert(s.PutLink([]string{"Tenants", "a", "escape"}, []string{"Tenants", "b", "secret"}), IsNil)
+	getTenant, err := s.MintCapability(Capability{Operation: "Get", Key: []string{"Tenants", "a"}, Prefix: true,
+		Expires: clock.now.Add(time.Minute)})
+	c.Assert(err, IsNil)
+	c.Assert(s.Get([]string{"Tenants", "a", "one"}, &v, WithCapability(getTenant)), IsNil)
+	c.Assert(v.ID, Equals, "a1")
+	err = s.Get([]string{"Tenants", "a", "escape"}, &v, WithCapability(getTenant))
+	c.Assert(err, ErrorMatches, `Get "Tenants/a/escape": capability denied: link to "Tenants/b/secret" is outside "Tenants/a"`)
+	err = s.Get([]string{"Tenants", "a", "escape"}, &v, WithCapability(getTenant), ConsistentRead())
+	c.Assert(err, ErrorIs, ErrCapabilityDenied)
+
+	// A token that has been altered, was signed with another key or has
+	// expired authorizes nothing.
+	err = s.Get([]string{"Accounts", "12345"}, &v, WithCapability(get[:len(get)-2]+"AA"))
+	c.Assert(err, ErrorMatches, `Get "Accounts/12345": capability denied: invalid signature`)
+	err = s.Get([]string{"Accounts", "12345"}, &v, WithCapability("garbage"))
+	c.Assert(err, ErrorMatches, `Get "Accounts/12345": capability denied: malformed token`)
+	other := &Tree{CapabilityKeys: [][]byte{[]byte("other")}}
+	forged, err := other.MintCapability(Capability{Operation: "Get", Key: []string{"Accounts", "12345"},
+		Expires: clock.now.Add(time.Minute)})
+	c.Assert(err, IsNil)
+	c.Assert(s.Get([]string{"Accounts", "12345"}, &v, WithCapability(forged)), ErrorIs, ErrCapabilityDenied)
+
+	// Tokens signed with a retired key verify until it is removed.
+	s.CapabilityKeys = [][]byte{[]byte("new"), []byte("secret")}
+	c.Assert(s.Get([]string{"Accounts", "12345"}, &v, WithCapability(get)), IsNil)
+
+	clock.now = clock.now.Add(time.Minute)
+	err = s.Get([]string{"Accounts", "12345"}, &v, WithCapability(get))
+	c.Assert(err, ErrorMatches, `Get "Accounts/12345": capability denied: expired`)
+	_, err = s.VerifyCapability(get)
+	c.Assert(err, ErrorMatches, `VerifyCapability "": capability denied: expired`)
+}
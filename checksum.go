@@ -0,0 +1,71 @@
+package dynamotree
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// ErrChecksumMismatch is matched, using errors.Is, by the *ChecksumError
+// returned when Tree.Checksums is set and an object is read whose
+// attributes no longer match the checksum stored with them
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ChecksumError is returned when Tree.Checksums is set and an object is
+// read whose attributes were changed since it was stored, other than by
+// the tree. It matches ErrChecksumMismatch using errors.Is.
+type ChecksumError struct {
+	Op  string
+	Key []string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%s %q: checksum mismatch", e.Op, strings.Join(e.Key, "/"))
+}
+
+// Is returns true if target is ErrChecksumMismatch.
+func (e *ChecksumError) Is(target error) bool { return target == ErrChecksumMismatch }
+
+// checksumAttribute is the attribute of an object's row that holds the
+// checksum of its attributes. See Tree.Checksums.
+func (t *Tree) checksumAttribute() string { return t.SpecialCharacter + "Checksum" }
+
+// addChecksum adds to the row attributes, if Tree.Checksums is set, the
+// checksum of the object's attributes.
+func (t *Tree) addChecksum(attributes map[string]*dynamodb.AttributeValue) {
+	if !t.Checksums {
+		return
+	}
+	attributes[t.checksumAttribute()] = &dynamodb.AttributeValue{S: aws.String(t.checksum(attributes))}
+}
+
+// verifyChecksum returns ErrChecksumMismatch if Tree.Checksums is set and
+// row holds a checksum that does not match its attributes, and removes the
+// checksum from row, so that it is not given to the object. A row stored
+// without a checksum, before Tree.Checksums was set or by another writer,
+// is not checked.
+func (t *Tree) verifyChecksum(row map[string]*dynamodb.AttributeValue) error {
+	stored, ok := row[t.checksumAttribute()]
+	if !ok {
+		return nil
+	}
+	delete(row, t.checksumAttribute())
+	if t.Checksums && aws.StringValue(stored.S) != t.checksum(row) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// checksum returns the checksum of the object attributes of row, leaving
+// out its key, the attributes that the tree stores with it, and
+// Tree.TenantAttribute, which is added to the row after it is computed.
+func (t *Tree) checksum(row map[string]*dynamodb.AttributeValue) string {
+	attributes := objectAttributes(t, row)
+	if t.TenantAttribute != "" {
+		delete(attributes, t.TenantAttribute)
+	}
+	return Hash(attributes)
+}
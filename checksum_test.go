@@ -0,0 +1,175 @@
+package dynamotree
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestChecksums(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db, Checksums: true, KeepVersions: true}
+	c.Assert(s.CreateTable(), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &AccountT{ID: "12345", Name: "alice"}), IsNil)
+
+	v := AccountT{}
+	c.Assert(s.Get([]string{"Accounts", "12345"}, &v), IsNil)
+	c.Assert(v, DeepEquals, AccountT{ID: "12345", Name: "alice"})
+
+	// The checksum is not given to the object.
+	item := rawItem{}
+	c.Assert(s.Get([]string{"Accounts", "12345"}, item), IsNil)
+	c.Assert(item[s.checksumAttribute()], IsNil)
+
+	// Numbers and sets may be returned in another form than they were
+	// written in.
+	c.Assert(s.Put([]string{"Counts", "a"}, rawItem{
+		"N":  {N: aws.String("1.50")},
+		"SS": {SS: aws.StringSlice([]string{"b", "a"})},
+	}), IsNil)
+	_, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(s.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Key":   {S: aws.String(s.EncodeKey([]string{"Counts", "a"}))},
+			"Child": {S: aws.String(s.SpecialCharacter)},
+		},
+		UpdateExpression: aws.String("SET N = :n, SS = :ss"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":n":  {N: aws.String("15e-1")},
+			":ss": {SS: aws.StringSlice([]string{"a", "b"})},
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.Get([]string{"Counts", "a"}, rawItem{}), IsNil)
+
+	// An object changed by another writer is detected.
+	_, err = db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(s.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Key":   {S: aws.String(s.EncodeKey([]string{"Accounts", "12345"}))},
+			"Child": {S: aws.String(s.SpecialCharacter)},
+		},
+		UpdateExpression:          aws.String("SET #N = :name"),
+		ExpressionAttributeNames:  map[string]*string{"#N": aws.String("Name")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":name": {S: aws.String("mallory")}},
+	})
+	c.Assert(err, IsNil)
+	err = s.Get([]string{"Accounts", "12345"}, &v)
+	c.Assert(err, ErrorIs, ErrChecksumMismatch)
+	c.Assert(err, ErrorMatches, `Get "Accounts/12345": checksum mismatch`)
+	c.Assert(s.FastGet([]string{"Accounts", "12345"}, nil, &v), ErrorIs, ErrChecksumMismatch)
+	c.Assert(s.FastGet([]string{"Accounts", "12345"}, []string{"Name"}, &v), IsNil)
+
+	// The version stored by Put is unchanged.
+	v = AccountT{}
+	c.Assert(s.GetAsOf([]string{"Accounts", "12345"}, s.now(), &v), IsNil)
+	c.Assert(v.Name, Equals, "alice")
+
+	// Rows written without a checksum are read as before.
+	_, err = db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.TableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"Key":   {S: aws.String(s.EncodeKey([]string{"Accounts", "67890"}))},
+			"Child": {S: aws.String(s.SpecialCharacter)},
+			"Name":  {S: aws.String("bob")},
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.Get([]string{"Accounts", "67890"}, &v), IsNil)
+	c.Assert(v.Name, Equals, "bob")
+
+	// Nor is it given to a data subject's bundle.
+	bundle, err := s.ExportSubject([]string{"Accounts"})
+	c.Assert(err, IsNil)
+	c.Assert(bundle.Objects, HasLen, 1)
+	c.Assert(bundle.Objects[0].Item[s.checksumAttribute()], IsNil)
+	c.Assert(bundle.Versions, HasLen, 1)
+	c.Assert(bundle.Versions[0].Item[s.checksumAttribute()], IsNil)
+}
+
+func (suite *StoreImplTest) TestChecksumsCopyAndArchive(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db, Checksums: true}
+	c.Assert(s.CreateTable(), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &AccountT{ID: "12345", Name: "alice"}), IsNil)
+
+	// The checksum is stored anew with the copy, rather than copied.
+	c.Assert(s.Copy([]string{"Accounts"}, s, []string{"Copies"}), IsNil)
+	v := AccountT{}
+	c.Assert(s.Get([]string{"Copies", "12345"}, &v), IsNil)
+	c.Assert(v, DeepEquals, AccountT{ID: "12345", Name: "alice"})
+	c.Assert(s.Sync([]string{"Accounts"}, s, []string{"Copies"}), IsNil)
+
+	// The checksum is left out of archives, so that they can be imported.
+	for _, format := range []ArchiveFormat{ArchiveTar, ArchiveZip} {
+		buf := &bytes.Buffer{}
+		c.Assert(s.ExportArchive([]string{"Accounts"}, buf, format), IsNil)
+		c.Assert(bytes.Contains(buf.Bytes(), []byte("Checksum")), Equals, false)
+		s2 := &Tree{TableName: uniuri.New(), DB: db, Checksums: true}
+		c.Assert(s2.CreateTable(), IsNil)
+		c.Assert(s2.ImportArchive(buf, format), IsNil)
+		v = AccountT{}
+		c.Assert(s2.Get([]string{"Accounts", "12345"}, &v), IsNil)
+		c.Assert(v, DeepEquals, AccountT{ID: "12345", Name: "alice"})
+	}
+
+	buf := &bytes.Buffer{}
+	c.Assert(s.ExportDocuments([]string{"Accounts"}, buf, DocumentsMongo), IsNil)
+	c.Assert(bytes.Contains(buf.Bytes(), []byte("Checksum")), Equals, false)
+}
+
+func (suite *StoreImplTest) TestChecksumsMirrorAdjacencyWatch(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db, Checksums: true}
+	c.Assert(s.CreateTable(), IsNil)
+	key := []string{"Accounts", "12345"}
+	c.Assert(s.Put(key, &AccountT{ID: "12345", Name: "alice"}), IsNil)
+
+	// corrupt changes the object whose row has the given Key, as another
+	// writer might.
+	corrupt := func(tableName, pathKey string) {
+		_, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName: aws.String(tableName),
+			Key: map[string]*dynamodb.AttributeValue{
+				"Key":   {S: aws.String(pathKey)},
+				"Child": {S: aws.String(s.SpecialCharacter)},
+			},
+			UpdateExpression:          aws.String("SET #N = :name"),
+			ExpressionAttributeNames:  map[string]*string{"#N": aws.String("Name")},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":name": {S: aws.String("mallory")}},
+		})
+		c.Assert(err, IsNil)
+	}
+	corrupt(s.TableName, s.EncodeKey(key))
+
+	// The mirror checks the checksum each time, leaving its own copy of
+	// the row alone.
+	m := &Mirror{Tree: s, Prefixes: [][]string{{"Accounts"}}, MaxStaleness: time.Hour}
+	c.Assert(m.load(), IsNil)
+	m.lastSync = time.Now()
+	v := AccountT{}
+	c.Assert(m.Get(key, &v), ErrorIs, ErrChecksumMismatch)
+	c.Assert(m.Get(key, &v), ErrorIs, ErrChecksumMismatch)
+
+	err := s.WatchKey(context.Background(), key, time.Millisecond, &v, func(err error) bool {
+		c.Assert(err, ErrorIs, ErrChecksumMismatch)
+		return false
+	})
+	c.Assert(err, IsNil)
+
+	a := &AdjacencyTree{Tree: &Tree{TableName: uniuri.New(), DB: db, Checksums: true}}
+	c.Assert(a.CreateTable(), IsNil)
+	c.Assert(a.Put(key, &AccountT{ID: "12345", Name: "alice"}), IsNil)
+	c.Assert(a.Get(key, &v), IsNil)
+	c.Assert(v, DeepEquals, AccountT{ID: "12345", Name: "alice"})
+	id, _, err := a.find(key)
+	c.Assert(err, IsNil)
+	corrupt(a.Tree.TableName, id)
+	c.Assert(a.Get(key, &v), ErrorIs, ErrChecksumMismatch)
+}
@@ -0,0 +1,47 @@
+package dynamotree
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// needsClient returns true if the tree must create its own client, because
+// DB is nil or the tree's Endpoint, Region, HTTPClient or Lambda have been
+// set.
+func (t *Tree) needsClient() bool {
+	return t.DB == nil || t.Endpoint != "" || t.Region != "" || t.HTTPClient != nil || t.Lambda
+}
+
+// newClient returns a client for DynamoDB configured by the Endpoint,
+// Region, HTTPClient and Lambda settings of the tree. The rest of its configuration is that
+// of DB, if it is set, or else that found in the environment and the
+// shared configuration files, as session.NewSession finds it.
+func (t *Tree) newClient() *dynamodb.DynamoDB {
+	config := &aws.Config{}
+	if t.DB != nil {
+		config = t.DB.Config.Copy()
+	}
+	if t.Endpoint != "" {
+		config.Endpoint = aws.String(t.Endpoint)
+	}
+	if t.Region != "" {
+		config.Region = aws.String(t.Region)
+	}
+	if t.HTTPClient != nil {
+		config.HTTPClient = t.HTTPClient
+	}
+	if t.Lambda {
+		t.lambdaConfig(config)
+	}
+	db := dynamodb.New(session.New(), config)
+	if t.DB != nil {
+		// The handlers that the caller added to DB, such as for tracing,
+		// remain in place.
+		db.Handlers = t.DB.Handlers.Copy()
+	}
+	if t.Lambda {
+		db.Handlers.Validate.PushFront(limitToDeadline)
+	}
+	return db
+}
@@ -0,0 +1,78 @@
+package dynamotree
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	mathrand "math/rand"
+	"time"
+)
+
+// Clock is the source of the current time for a tree, and of the delays
+// that it waits for, as given by Tree.Clock. Tests may give a tree a clock
+// that they advance themselves, so that the timestamps the tree records,
+// the expiry of TTLs, leases and versions, and the pacing of rate-limited
+// operations can be checked without waiting.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After returns a channel on which the time is sent once d has
+	// passed.
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock is the Clock of a tree whose Clock is nil.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// clock returns the tree's Clock.
+func (t *Tree) clock() Clock {
+	if t.Clock == nil {
+		return systemClock{}
+	}
+	return t.Clock
+}
+
+// now returns the current time according to the tree's Clock.
+func (t *Tree) now() time.Time {
+	return t.clock().Now()
+}
+
+// since returns the time elapsed since start according to the tree's
+// Clock.
+func (t *Tree) since(start time.Time) time.Duration {
+	return t.now().Sub(start)
+}
+
+// random returns the tree's source of random bytes.
+func (t *Tree) random() io.Reader {
+	if t.Rand == nil {
+		return rand.Reader
+	}
+	return t.Rand
+}
+
+// randomHex returns n random bytes from the tree's Rand, hex encoded.
+func (t *Tree) randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(t.random(), buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// randomIntn returns a random number in [0, n) from the tree's Rand.
+func (t *Tree) randomIntn(n int) int {
+	if t.Rand == nil {
+		return mathrand.Intn(n)
+	}
+	var buf [8]byte
+	if _, err := io.ReadFull(t.Rand, buf[:]); err != nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(buf[:]) % uint64(n))
+}
@@ -0,0 +1,76 @@
+package dynamotree
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+// fakeClock is a Clock that stands still until it is waited on, when it
+// advances by the delay at once.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	waited time.Duration
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	f.waited += d
+	ch := make(chan time.Time, 1)
+	ch <- f.now
+	return ch
+}
+
+func (suite *StoreImplTest) TestClock(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	s := &Tree{TableName: uniuri.New(), DB: db, Clock: clock,
+		Rand:         bytes.NewReader(bytes.Repeat([]byte{0xab}, 1000)),
+		TTLAttribute: "Expires",
+		RetentionPolicies: []RetentionPolicy{
+			{Prefix: []string{"Accounts"}, EventTTL: time.Hour},
+		}}
+	c.Assert(s.CreateTable(), IsNil)
+
+	id, err := s.AppendEvent([]string{"Accounts", "alice"}, &AccountT{Name: "login"})
+	c.Assert(err, IsNil)
+	c.Assert(id, Equals, timeOrderedPrefix(start)+"-abababab")
+	s.ReadEvents([]string{"Accounts", "alice"}, time.Time{}, func(event *Event, err error) bool {
+		c.Assert(err, IsNil)
+		c.Assert(event.Time.Equal(start), Equals, true)
+		c.Assert(aws.StringValue(event.Item["Expires"].N), Equals, "1577840400")
+		return true
+	})
+
+	msg, err := (&Queue{Tree: s, Prefix: []string{"Queue"}}).Enqueue(&AccountT{ID: "1"})
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasSuffix(msg, "-abababab"), Equals, true)
+
+	// Pacing waits on the clock rather than in real time.
+	for _, name := range []string{"a", "b", "c", "d"} {
+		c.Assert(s.Put([]string{"Users", name}, &AccountT{ID: name}), IsNil)
+	}
+	began := time.Now()
+	deleted, err := s.DeleteChildren([]string{"Users"}, nil, LimitWriteRate(0.01))
+	c.Assert(err, IsNil)
+	c.Assert(deleted, Equals, 4)
+	c.Assert(clock.waited > time.Minute, Equals, true)
+	c.Assert(time.Since(began) < time.Minute, Equals, true)
+}
@@ -0,0 +1,127 @@
+package dynamotree
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync/atomic"
+)
+
+// ErrClosed is returned by the tree's operations once Close has been
+// called.
+var ErrClosed = errors.New("tree is closed")
+
+// Close stops the tree's background work and waits for it to finish, and
+// then causes the tree's operations to return ErrClosed. In order, it:
+//
+//   - makes the writes waiting to be coalesced, if Tree.CoalesceWrites is
+//     set, and makes those that follow at once;
+//   - closes each WriteBehind on the tree, making the writes queued in it,
+//     and stops each Mirror of the tree, the latest first;
+//   - waits for the coalesced writes in progress;
+//   - stops the loops of Sweeper.Run, Maintenance.Run, WatchKey and
+//     Config.Watch running on the tree, which return ErrClosed, and the
+//     requests kept up by Open, and waits for them to return.
+//
+// Close returns the first error that any of these report, such as that of
+// a queued write that failed. If ctx is done first, Close returns ctx's
+// error, and the work continues in the background. Operations already in
+// progress when Close is called are not waited for. Calling Close again
+// does nothing.
+func (t *Tree) Close(ctx context.Context) error {
+	t.initOnce.Do(t.init)
+	t.lifeMu.Lock()
+	if t.closed {
+		t.lifeMu.Unlock()
+		return nil
+	}
+	t.closed = true
+	ids := make([]int, 0, len(t.closers))
+	for id := range t.closers {
+		ids = append(ids, id)
+	}
+	t.lifeMu.Unlock()
+
+	// Writes are no longer held back to be coalesced, since the writes
+	// made by the closers below would otherwise wait for them. What was
+	// started last is closed first.
+	if t.coalescer != nil {
+		t.coalescer.drain()
+	}
+	var err error
+	sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+	for _, id := range ids {
+		t.lifeMu.Lock()
+		closer, ok := t.closers[id]
+		t.lifeMu.Unlock()
+		if !ok {
+			continue
+		}
+		if e := closer(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+	if t.coalescer != nil {
+		if e := t.coalescer.wait(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	t.lifeMu.Lock()
+	atomic.StoreUint32(&t.isClosed, 1)
+	if t.closing == nil {
+		t.closing = make(chan struct{})
+	}
+	close(t.closing)
+	t.lifeMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return err
+}
+
+// onClose registers closer to be called by Close, and returns a function
+// that removes it again. If Close has already been called, closer is not
+// registered.
+func (t *Tree) onClose(closer func(context.Context) error) (remove func()) {
+	t.lifeMu.Lock()
+	defer t.lifeMu.Unlock()
+	if t.closed {
+		return func() {}
+	}
+	if t.closers == nil {
+		t.closers = map[int]func(context.Context) error{}
+	}
+	id := t.nextCloser
+	t.nextCloser++
+	t.closers[id] = closer
+	return func() {
+		t.lifeMu.Lock()
+		defer t.lifeMu.Unlock()
+		delete(t.closers, id)
+	}
+}
+
+// background registers a worker that Close waits for. It returns a channel
+// that is closed when the worker should stop, and a function that the
+// worker must call once it has.
+func (t *Tree) background() (closing <-chan struct{}, done func()) {
+	t.lifeMu.Lock()
+	defer t.lifeMu.Unlock()
+	if t.closing == nil {
+		t.closing = make(chan struct{})
+	}
+	if atomic.LoadUint32(&t.isClosed) == 1 {
+		return t.closing, func() {}
+	}
+	t.workers.Add(1)
+	return t.closing, t.workers.Done
+}
@@ -0,0 +1,53 @@
+package dynamotree
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestClose(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db, CoalesceWrites: time.Hour}
+	c.Assert(s.CreateTable(), IsNil)
+	c.Assert(s.Open(context.Background(), KeepAlive(time.Millisecond)), IsNil)
+
+	wb := &WriteBehind{Tree: s}
+	c.Assert(wb.Put([]string{"Accounts", "12345"}, &AccountT{ID: "12345"}), IsNil)
+
+	// A write waiting to be coalesced is made by Close.
+	putErr := make(chan error, 1)
+	go func() { putErr <- s.Put([]string{"Accounts", "67890"}, &AccountT{ID: "67890"}) }()
+
+	watchErr := make(chan error, 1)
+	go func() {
+		var v AccountT
+		watchErr <- s.WatchKey(context.Background(), []string{"Config"}, time.Millisecond, &v,
+			func(error) bool { return true })
+	}()
+	sweepErr := make(chan error, 1)
+	go func() {
+		sweepErr <- (&Sweeper{Tree: s, Interval: time.Millisecond}).Run(context.Background())
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	c.Assert(s.Close(context.Background()), IsNil)
+	c.Assert(<-putErr, IsNil)
+	c.Assert(<-watchErr, ErrorIs, ErrClosed)
+	c.Assert(<-sweepErr, ErrorIs, ErrClosed)
+	c.Assert(wb.Put([]string{"Accounts", "1"}, &AccountT{ID: "1"}), ErrorIs, ErrWriteBehindClosed)
+
+	var v AccountT
+	c.Assert(s.Get([]string{"Accounts", "12345"}, &v), ErrorIs, ErrClosed)
+	c.Assert(s.Put([]string{"Accounts", "1"}, &AccountT{ID: "1"}), ErrorIs, ErrClosed)
+	c.Assert(s.Close(context.Background()), IsNil)
+
+	// Both writes were made.
+	s2 := &Tree{TableName: s.TableName, DB: db}
+	c.Assert(s2.Get([]string{"Accounts", "12345"}, &v, ConsistentRead()), IsNil)
+	c.Assert(s2.Get([]string{"Accounts", "67890"}, &v, ConsistentRead()), IsNil)
+}
@@ -0,0 +1,216 @@
+package dynamotree
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// maxBatchWriteRows is the number of rows a BatchWriteItem request may
+// write or remove.
+const maxBatchWriteRows = 25
+
+// writeCoalescer gathers the rows of concurrent writes for
+// Tree.CoalesceWrites and writes them together.
+type writeCoalescer struct {
+	tree   *Tree
+	window time.Duration
+
+	mu       sync.Mutex
+	pending  []*coalescedWrite
+	rows     map[RowID]bool
+	timer    *time.Timer
+	draining bool
+	inFlight sync.WaitGroup
+}
+
+// coalescedWrite is the write of a single call, which completes when done
+// is closed. errs then holds the error for each of its rows.
+type coalescedWrite struct {
+	writeRequests []*dynamodb.WriteRequest
+	errs          []error
+	done          chan struct{}
+}
+
+// coalescedRow is a row of a shared batch, and the rows of the writes
+// that it stands for.
+type coalescedRow struct {
+	writeRequest *dynamodb.WriteRequest
+	writes       []*coalescedWrite
+	indexes      []int
+}
+
+// canCoalesce returns true if the writeRequests of a call made with o may
+// share requests with other calls: they fit in a single batch and the call
+// does not change the requests it makes.
+func (t *Tree) canCoalesce(writeRequests []*dynamodb.WriteRequest, o *callOptions) bool {
+	return t.coalescer != nil && len(writeRequests) <= maxBatchWriteRows &&
+		(o == nil || len(o.requestOptions) == 0)
+}
+
+// coalescedWrite writes writeRequests together with those of concurrent
+// calls, returning a *MultiRowError, as batchWrite does, if any are not
+// written. If the call's context is done first, it returns its error,
+// and the rows may or may not be written.
+func (t *Tree) coalescedWrite(writeRequests []*dynamodb.WriteRequest, o *callOptions) error {
+	w := &coalescedWrite{
+		writeRequests: writeRequests,
+		errs:          make([]error, len(writeRequests)),
+		done:          make(chan struct{}),
+	}
+	t.coalescer.add(w)
+	select {
+	case <-w.done:
+	case <-o.context().Done():
+		return o.context().Err()
+	}
+
+	var e *MultiRowError
+	for _, err := range w.errs {
+		if err != nil && e == nil {
+			e = &MultiRowError{Err: err}
+		}
+	}
+	if e == nil {
+		return nil
+	}
+	for i, writeRequest := range writeRequests {
+		if w.errs[i] != nil {
+			e.Failed = append(e.Failed, rowID(writeRequest))
+		} else {
+			e.Written = append(e.Written, rowID(writeRequest))
+		}
+	}
+	return e
+}
+
+// add queues w, to be written when the window has passed since the first
+// of the queued writes, or once they have enough rows to fill a batch.
+func (c *writeCoalescer) add(w *coalescedWrite) {
+	c.mu.Lock()
+	c.pending = append(c.pending, w)
+	if c.rows == nil {
+		c.rows = map[RowID]bool{}
+	}
+	for _, writeRequest := range w.writeRequests {
+		c.rows[rowID(writeRequest)] = true
+	}
+	if len(c.rows) >= maxBatchWriteRows || c.draining {
+		writes := c.take()
+		c.mu.Unlock()
+		go c.write(writes)
+		return
+	}
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.window, c.flush)
+	}
+	c.mu.Unlock()
+}
+
+// take returns the queued writes and empties the queue. The caller must
+// hold c.mu, and must pass the writes to write.
+func (c *writeCoalescer) take() []*coalescedWrite {
+	writes := c.pending
+	c.pending, c.rows = nil, nil
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.inFlight.Add(1)
+	return writes
+}
+
+// flush writes the queued writes.
+func (c *writeCoalescer) flush() {
+	c.mu.Lock()
+	writes := c.take()
+	c.mu.Unlock()
+	c.write(writes)
+}
+
+// drain writes the queued writes, and causes those queued later to be
+// written at once, for Tree.Close.
+func (c *writeCoalescer) drain() {
+	c.mu.Lock()
+	c.draining = true
+	c.mu.Unlock()
+	c.flush()
+}
+
+// wait waits for the writes being written.
+func (c *writeCoalescer) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		c.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// write writes the rows of writes in as few batches as it can, one after
+// another. A row written identically by several writes, as the directory
+// entries of keys with the same parent are, is written once. A row that
+// writes differ about is written in a later batch than the one before it,
+// so the writes queued last prevail.
+func (c *writeCoalescer) write(writes []*coalescedWrite) {
+	defer c.inFlight.Done()
+	var batches [][]*coalescedRow
+	var batch []*coalescedRow
+	inBatch := map[RowID]*coalescedRow{}
+	for _, w := range writes {
+		for i, writeRequest := range w.writeRequests {
+			id := rowID(writeRequest)
+			if row, ok := inBatch[id]; ok && reflect.DeepEqual(row.writeRequest, writeRequest) {
+				row.writes = append(row.writes, w)
+				row.indexes = append(row.indexes, i)
+				continue
+			} else if ok || len(batch) == maxBatchWriteRows {
+				batches = append(batches, batch)
+				batch, inBatch = nil, map[RowID]*coalescedRow{}
+			}
+			row := &coalescedRow{writeRequest: writeRequest, writes: []*coalescedWrite{w}, indexes: []int{i}}
+			batch = append(batch, row)
+			inBatch[id] = row
+		}
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+
+	for _, batch := range batches {
+		writeRequests := make([]*dynamodb.WriteRequest, len(batch))
+		for i, row := range batch {
+			writeRequests[i] = row.writeRequest
+		}
+		err := c.tree.batchWrite(writeRequests, nil)
+		if err == nil {
+			continue
+		}
+		failed := map[RowID]bool{}
+		if e, ok := err.(*MultiRowError); ok {
+			for _, id := range e.Failed {
+				failed[id] = true
+			}
+			err = e.Err
+		}
+		for _, row := range batch {
+			if len(failed) > 0 && !failed[rowID(row.writeRequest)] {
+				continue
+			}
+			for i, w := range row.writes {
+				w.errs[row.indexes[i]] = err
+			}
+		}
+	}
+	for _, w := range writes {
+		close(w.done)
+	}
+}
@@ -0,0 +1,76 @@
+package dynamotree
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestCoalesceWrites(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	var batchWrites int64
+	db.Handlers.Send.PushFront(func(r *request.Request) {
+		if r.Operation.Name == "BatchWriteItem" {
+			atomic.AddInt64(&batchWrites, 1)
+		}
+	})
+	s := &Tree{TableName: uniuri.New(), DB: db, CoalesceWrites: 50 * time.Millisecond}
+	c.Assert(s.CreateTable(), IsNil)
+
+	// Ten Puts write 21 rows, as they share the entry of Accounts in the
+	// root, so they fit in a single request.
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.Put([]string{"Accounts", fmt.Sprint(i)}, &AccountT{ID: fmt.Sprint(i)})
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		c.Assert(err, IsNil)
+	}
+	c.Assert(atomic.LoadInt64(&batchWrites), Equals, int64(1))
+	c.Assert(walkKeys(c, s, []string{"Accounts"}), HasLen, 10)
+	for i := range errs {
+		v := AccountT{}
+		c.Assert(s.Get([]string{"Accounts", fmt.Sprint(i)}, &v), IsNil)
+		c.Assert(v.ID, Equals, fmt.Sprint(i))
+	}
+
+	// Deletes are coalesced too.
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.Delete([]string{"Accounts", fmt.Sprint(i)})
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		c.Assert(err, IsNil)
+	}
+	c.Assert(walkKeys(c, s, []string{"Accounts"}), HasLen, 0)
+
+	// A write on its own is made once the window has passed.
+	start := time.Now()
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &AccountT{ID: "12345"}), IsNil)
+	c.Assert(time.Since(start) >= 50*time.Millisecond, Equals, true)
+	v := AccountT{}
+	c.Assert(s.Get([]string{"Accounts", "12345"}, &v), IsNil)
+
+	// Writes made with request options are not coalesced.
+	start = time.Now()
+	c.Assert(s.Put([]string{"Accounts", "67890"}, &AccountT{ID: "67890"},
+		WithRequestOptions(func(*request.Request) {})), IsNil)
+	c.Assert(time.Since(start) < 50*time.Millisecond, Equals, true)
+}
@@ -0,0 +1,212 @@
+package dynamotree
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// Config is a configuration store built on a Tree. Each setting is stored
+// as an object at Prefix + scope + name, where scope is a list of
+// increasingly specific key parts such as a service and an environment.
+//
+// When a setting is read, the most specific value wins: with a Prefix of
+// {"Config"}, reading "Timeout" in scope {"Service"} returns the value
+// stored at ¦Config¦Service¦Timeout if there is one, or else the value at
+// ¦Config¦Timeout.
+type Config struct {
+	// Tree is the tree in which settings are stored.
+	Tree *Tree
+
+	// Prefix is the key below which settings are stored.
+	Prefix []string
+}
+
+// configValue is the object stored for each setting.
+type configValue struct {
+	key   []string
+	Value *dynamodb.AttributeValue
+}
+
+func (v *configValue) MarshalDynamoDB() (map[string]*dynamodb.AttributeValue, error) {
+	return map[string]*dynamodb.AttributeValue{"Value": v.Value}, nil
+}
+
+func (v *configValue) UnmarshalDynamoDB(item map[string]*dynamodb.AttributeValue) error {
+	value, ok := item["Value"]
+	if !ok {
+		return fmt.Errorf("%s is not a configuration value", strings.Join(v.key, "/"))
+	}
+	v.Value = value
+	return nil
+}
+
+func (c *Config) key(scope []string, name string) []string {
+	key := make([]string, 0, len(c.Prefix)+len(scope)+1)
+	key = append(key, c.Prefix...)
+	key = append(key, scope...)
+	return append(key, name)
+}
+
+// lookup returns the most specific value of the setting name in scope.
+func (c *Config) lookup(scope []string, name string) (*dynamodb.AttributeValue, error) {
+	for i := len(scope); i >= 0; i-- {
+		v := configValue{key: c.key(scope[:i], name)}
+		err := c.Tree.Get(v.key, &v)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return v.Value, nil
+	}
+	return nil, ErrNotFound
+}
+
+// Get unmarshals the value of the setting name in scope into out, which
+// must be a pointer, using dynamodbattribute.Unmarshal. If the setting is
+// not set in scope or any of its parents, Get returns ErrNotFound.
+func (c *Config) Get(scope []string, name string, out interface{}) error {
+	value, err := c.lookup(scope, name)
+	if err != nil {
+		return err
+	}
+	return dynamodbattribute.Unmarshal(value, out)
+}
+
+// Set stores value, marshaled using dynamodbattribute.Marshal, as the
+// setting name in scope.
+func (c *Config) Set(scope []string, name string, value interface{}) error {
+	av, err := dynamodbattribute.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return c.Tree.Put(c.key(scope, name), &configValue{Value: av})
+}
+
+// Delete removes the setting name from scope. Values set in the parents
+// of scope are not affected.
+func (c *Config) Delete(scope []string, name string) error {
+	return c.Tree.Delete(c.key(scope, name))
+}
+
+// String returns the value of the string setting name in scope, or def if
+// it is not set.
+func (c *Config) String(scope []string, name string, def string) (string, error) {
+	var v string
+	if err := c.Get(scope, name, &v); errors.Is(err, ErrNotFound) {
+		return def, nil
+	} else if err != nil {
+		return "", err
+	}
+	return v, nil
+}
+
+// Int returns the value of the integer setting name in scope, or def if it
+// is not set.
+func (c *Config) Int(scope []string, name string, def int) (int, error) {
+	var v int
+	if err := c.Get(scope, name, &v); errors.Is(err, ErrNotFound) {
+		return def, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return v, nil
+}
+
+// Bool returns the value of the boolean setting name in scope, or def if it
+// is not set.
+func (c *Config) Bool(scope []string, name string, def bool) (bool, error) {
+	var v bool
+	if err := c.Get(scope, name, &v); errors.Is(err, ErrNotFound) {
+		return def, nil
+	} else if err != nil {
+		return false, err
+	}
+	return v, nil
+}
+
+// Duration returns the value of the duration setting name in scope, or def
+// if it is not set. Durations are stored as strings in the format accepted
+// by time.ParseDuration.
+func (c *Config) Duration(scope []string, name string, def time.Duration) (time.Duration, error) {
+	s, err := c.String(scope, name, "")
+	if err != nil {
+		return 0, err
+	}
+	if s == "" {
+		return def, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// SetString stores the string setting name in scope.
+func (c *Config) SetString(scope []string, name string, value string) error {
+	return c.Set(scope, name, value)
+}
+
+// SetInt stores the integer setting name in scope.
+func (c *Config) SetInt(scope []string, name string, value int) error {
+	return c.Set(scope, name, value)
+}
+
+// SetBool stores the boolean setting name in scope.
+func (c *Config) SetBool(scope []string, name string, value bool) error {
+	return c.Set(scope, name, value)
+}
+
+// SetDuration stores the duration setting name in scope.
+func (c *Config) SetDuration(scope []string, name string, value time.Duration) error {
+	return c.Set(scope, name, value.String())
+}
+
+// Watch polls the setting name in scope every interval and reports each
+// change to fn, in the same way as Tree.WatchKey. A change to the value in
+// any of the parents of scope is reported if it changes the value that
+// Get would return. When the value changes it is unmarshaled into out and
+// fn is called with a nil error; if the setting is no longer set, fn is
+// called with ErrNotFound.
+//
+// Watch returns nil when fn returns false, ctx.Err() when ctx is done, or
+// ErrClosed when the tree is closed. Interval must be positive.
+func (c *Config) Watch(ctx context.Context, scope []string, name string, interval time.Duration, out interface{}, fn func(error) bool) error {
+	if interval <= 0 {
+		return fmt.Errorf("cannot watch at an interval of %s", interval)
+	}
+	closing, done := c.Tree.background()
+	defer done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	first := true
+	var lastValue *dynamodb.AttributeValue
+	var lastErr error
+	for {
+		value, err := c.lookup(scope, name)
+		if first || !sameError(err, lastErr) || !reflect.DeepEqual(value, lastValue) {
+			first = false
+			lastValue, lastErr = value, err
+			if err == nil {
+				err = dynamodbattribute.Unmarshal(value, out)
+			}
+			if !fn(err) {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-closing:
+			return ErrClosed
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package dynamotree
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestConfig(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	err := s.CreateTable()
+	c.Assert(err, IsNil)
+
+	config := &Config{Tree: s, Prefix: []string{"Config"}}
+	service := []string{"Service"}
+
+	timeout, err := config.Duration(service, "Timeout", time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(timeout, Equals, time.Second)
+
+	err = config.SetDuration(nil, "Timeout", 5*time.Second)
+	c.Assert(err, IsNil)
+	timeout, err = config.Duration(service, "Timeout", time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(timeout, Equals, 5*time.Second)
+
+	err = config.SetDuration(service, "Timeout", 10*time.Second)
+	c.Assert(err, IsNil)
+	timeout, err = config.Duration(service, "Timeout", time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(timeout, Equals, 10*time.Second)
+	timeout, err = config.Duration([]string{"OtherService"}, "Timeout", time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(timeout, Equals, 5*time.Second)
+
+	err = config.Delete(service, "Timeout")
+	c.Assert(err, IsNil)
+	timeout, err = config.Duration(service, "Timeout", time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(timeout, Equals, 5*time.Second)
+
+	c.Assert(config.SetString(service, "Name", "frob"), IsNil)
+	c.Assert(config.SetInt(service, "Workers", 4), IsNil)
+	c.Assert(config.SetBool(service, "Enabled", true), IsNil)
+
+	name, err := config.String(service, "Name", "")
+	c.Assert(err, IsNil)
+	c.Assert(name, Equals, "frob")
+	workers, err := config.Int(service, "Workers", 1)
+	c.Assert(err, IsNil)
+	c.Assert(workers, Equals, 4)
+	enabled, err := config.Bool(service, "Enabled", false)
+	c.Assert(err, IsNil)
+	c.Assert(enabled, Equals, true)
+
+	// the wrong type is an error, not the default
+	_, err = config.Int(service, "Name", 1)
+	c.Assert(err, NotNil)
+
+	// objects that aren't settings are an error
+	err = s.Put([]string{"Config", "Account"}, &AccountT{ID: "12345"})
+	c.Assert(err, IsNil)
+	_, err = config.String(nil, "Account", "")
+	c.Assert(err, ErrorMatches, "Config/Account is not a configuration value")
+}
+
+func (suite *StoreImplTest) TestConfigWatch(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	err := s.CreateTable()
+	c.Assert(err, IsNil)
+
+	config := &Config{Tree: s, Prefix: []string{"Config"}}
+	service := []string{"Service"}
+	c.Assert(config.SetInt(nil, "Workers", 1), IsNil)
+
+	seen := []int{}
+	var workers int
+	err = config.Watch(context.Background(), service, "Workers", time.Millisecond, &workers, func(err error) bool {
+		if err == ErrNotFound {
+			seen = append(seen, 0)
+			return false
+		}
+		c.Assert(err, IsNil)
+		seen = append(seen, workers)
+
+		switch workers {
+		case 1:
+			c.Assert(config.SetInt(service, "Workers", 2), IsNil)
+		case 2:
+			c.Assert(config.SetInt(nil, "Workers", 3), IsNil)
+			c.Assert(config.Delete(service, "Workers"), IsNil)
+		case 3:
+			c.Assert(config.Delete(nil, "Workers"), IsNil)
+		}
+		return true
+	})
+	c.Assert(err, IsNil)
+	c.Assert(seen, DeepEquals, []int{1, 2, 3, 0})
+
+	err = config.Watch(context.Background(), service, "Workers", 0, &workers, func(err error) bool {
+		c.Fatalf("fn called with %v", err)
+		return false
+	})
+	c.Assert(err, ErrorMatches, `cannot watch at an interval of 0s`)
+
+	// A failure that repeats on each poll is reported once.
+	c.Assert(s.PutLink([]string{"Config", "Service", "Workers"}, []string{"Config", "Service", "Workers"}), IsNil)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	calls := 0
+	config.Watch(ctx, service, "Workers", time.Millisecond, &workers, func(err error) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		c.Assert(err, ErrorMatches, `Get: resolving "Config/Service/Workers" requires following more than \d+ links`)
+		calls++
+		return true
+	})
+	c.Assert(calls, Equals, 1)
+}
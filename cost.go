@@ -0,0 +1,247 @@
+package dynamotree
+
+import (
+	"math"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// OpKind identifies the kind of operation described by an Op.
+type OpKind int
+
+// The operations whose cost can be estimated by EstimateCost.
+const (
+	OpGet OpKind = iota
+	OpPut
+	OpPutLink
+	OpDelete
+	OpList
+	OpWalk
+)
+
+// Op describes an operation whose cost is to be estimated by EstimateCost.
+type Op struct {
+	// Kind is the kind of operation.
+	Kind OpKind
+
+	// Key is the key of the object for Get, Put, PutLink and Delete, or the
+	// prefix for List and Walk.
+	Key []string
+
+	// Target is the target of the link for PutLink.
+	Target []string
+
+	// ItemSize is the approximate size in bytes of the object's attributes
+	// as returned by MarshalDynamoDB, for Get, Put and Delete, or of the
+	// target's attributes for PutLink, which reads and copies them when
+	// Tree.LinkCopyMaxSize is set.
+	ItemSize int
+
+	// Links is the number of symbolic links that Get follows before reaching
+	// the object.
+	Links int
+
+	// Children is the number of immediate children of the prefix for List,
+	// or the total number of keys below the prefix for Walk.
+	Children int
+
+	// ReturnWriteCounts is true if the Delete is given ReturnWriteCounts,
+	// which makes it read the row it removes.
+	ReturnWriteCounts bool
+}
+
+// Cost is the estimated cost of an operation.
+type Cost struct {
+	// RowsRead is the number of rows read.
+	RowsRead int
+
+	// RowsWritten is the number of rows written or deleted.
+	RowsWritten int
+
+	// RoundTrips is the number of requests made to DynamoDB.
+	RoundTrips int
+
+	// ReadCapacityUnits is the approximate number of read capacity units
+	// consumed, assuming eventually consistent reads.
+	ReadCapacityUnits float64
+
+	// WriteCapacityUnits is the approximate number of write capacity units
+	// consumed.
+	WriteCapacityUnits float64
+}
+
+// estimatedChildSize is the size in bytes assumed for the name of each
+// child when estimating the cost of List and Walk.
+const estimatedChildSize = 16
+
+// EstimateCost returns the approximate cost of performing op. The estimate
+// uses DynamoDB's published sizing rules (1 WCU per 1KB written, 0.5 RCU per
+// 4KB read with eventual consistency), the depth of the key and the size of
+// the item. The rows that Put and PutLink write are those that PlanPut and
+// PlanPutLink describe, and for a Put or PutLink that would be rejected,
+// for example because its key is not valid, the estimate is zero. It does
+// not account for retries of throttled or unprocessed requests.
+func (t *Tree) EstimateCost(op Op) Cost {
+	t.initOnce.Do(t.init)
+	cost := Cost{}
+
+	switch op.Kind {
+	case OpGet:
+		for i := 0; i < op.Links; i++ {
+			cost.add(readCost(t.linkRowSize(op.Key, op.Key)))
+		}
+		cost.add(readCost(t.leafRowSize(op.Key) + op.ItemSize))
+
+	case OpPut:
+		writeRequests, err := t.putRequests(op.Key, rawItem{})
+		if err != nil {
+			return Cost{}
+		}
+		writeRequests = t.flatten(op.Key, writeRequests, nil)
+		cost.add(writeCost(t.rowSizes(writeRequests, op.ItemSize)))
+		if t.copiesLinkTargets() {
+			// Put looks up the links to the object to refresh their copies.
+			cost.add(queryCost(0, 0))
+		}
+
+	case OpPutLink:
+		writeRequests, err := t.putLinkRequests(op.Key, op.Target)
+		if err != nil {
+			return Cost{}
+		}
+		writeRequests = t.flatten(op.Key, writeRequests, nil)
+		copySize := 0
+		if t.copiesLinkTargets() {
+			cost.add(readCost(t.leafRowSize(op.Target) + op.ItemSize))
+			if op.ItemSize <= t.LinkCopyMaxSize {
+				copySize = op.ItemSize
+			}
+		}
+		rows := t.rowSizes(writeRequests, 0)
+		rows[len(rows)-1] += copySize
+		cost.add(writeCost(rows))
+
+	case OpDelete:
+		// Delete checks whether the key has children, so as to keep its
+		// directory entry, and reads the row it removes to remove its
+		// backlink or count it.
+		if len(op.Key) > 0 {
+			cost.add(queryCost(1, t.directoryRowSize(op.Key, estimatedChildSize)))
+		}
+		if t.MaintainBacklinks || op.ReturnWriteCounts {
+			cost.add(readCost(t.leafRowSize(op.Key) + op.ItemSize))
+		}
+
+		// Deletes consume capacity according to the size of the deleted row.
+		dirRows := t.directoryRowSizes(op.Key)
+		rows := []int{t.leafRowSize(op.Key) + op.ItemSize}
+		if len(dirRows) > 0 {
+			rows = append(rows, dirRows[len(dirRows)-1])
+		}
+		cost.add(writeCost(rows))
+
+	case OpList:
+		cost.add(queryCost(op.Children, t.directoryRowSize(op.Key, estimatedChildSize)))
+
+	case OpWalk:
+		// Walk queries the prefix and each of the keys below it. Rows found
+		// deeper in the tree have longer keys, but we don't know the shape
+		// of the tree, so we estimate every row as being one level deeper
+		// than the prefix.
+		rowSize := t.directoryRowSize(op.Key, estimatedChildSize) +
+			len(t.SpecialCharacter) + estimatedChildSize
+		queries := op.Children + 1
+		cost.RowsRead = op.Children
+		cost.RoundTrips = queries
+		cost.ReadCapacityUnits = 0.5 * (float64(queries) +
+			math.Floor(float64(op.Children*rowSize)/4096))
+	}
+	return cost
+}
+
+func (c *Cost) add(other Cost) {
+	c.RowsRead += other.RowsRead
+	c.RowsWritten += other.RowsWritten
+	c.RoundTrips += other.RoundTrips
+	c.ReadCapacityUnits += other.ReadCapacityUnits
+	c.WriteCapacityUnits += other.WriteCapacityUnits
+}
+
+// rowSizes returns the size of the row written or removed by each of
+// writeRequests, counting objectSize bytes more for the object's row,
+// which is the last, and for the version of it that is kept when
+// Tree.KeepVersions is set.
+func (t *Tree) rowSizes(writeRequests []*dynamodb.WriteRequest, objectSize int) []int {
+	rv := make([]int, len(writeRequests))
+	for i, writeRequest := range writeRequests {
+		if writeRequest.DeleteRequest != nil {
+			rv[i] = itemSize(writeRequest.DeleteRequest.Key)
+			continue
+		}
+		item := writeRequest.PutRequest.Item
+		rv[i] = itemSize(item)
+		if i == len(writeRequests)-1 || strings.HasPrefix(stringValue(item["Child"]), t.versionsChild()) {
+			rv[i] += objectSize
+		}
+	}
+	return rv
+}
+
+// directoryRowSizes returns the size of each of the directory rows that
+// Put writes for key.
+func (t *Tree) directoryRowSizes(key []string) []int {
+	rv := make([]int, 0, len(key))
+	for i := range key {
+		rv = append(rv, t.directoryRowSize(key[:i], len(t.encodePart(key[i]))))
+	}
+	return rv
+}
+
+// directoryRowSize returns the size of the row recording a child of
+// prefix whose name is childSize bytes long.
+func (t *Tree) directoryRowSize(prefix []string, childSize int) int {
+	return len("Key") + len(t.dirKey(prefix)) + len("Child") + childSize
+}
+
+// leafRowSize returns the size of the row holding the object at key,
+// excluding the object's own attributes.
+func (t *Tree) leafRowSize(key []string) int {
+	return len("Key") + len(t.EncodeKey(key)) + len("Child") + len(t.SpecialCharacter)
+}
+
+// linkRowSize returns the size of the row holding a link from key to target.
+func (t *Tree) linkRowSize(key []string, target []string) int {
+	return t.leafRowSize(key) + len(t.LinkAttribute) + len(t.EncodeKey(target))
+}
+
+func readCost(size int) Cost {
+	return Cost{
+		RowsRead:          1,
+		RoundTrips:        1,
+		ReadCapacityUnits: 0.5 * math.Ceil(float64(size)/4096),
+	}
+}
+
+func writeCost(rowSizes []int) Cost {
+	cost := Cost{
+		RowsWritten: len(rowSizes),
+		RoundTrips:  (len(rowSizes) + 24) / 25,
+	}
+	for _, size := range rowSizes {
+		cost.WriteCapacityUnits += math.Ceil(float64(size) / 1024)
+	}
+	return cost
+}
+
+// queryCost returns the cost of a query that returns rows rows of rowSize
+// bytes each. A single query returns at most 1MB of data.
+func queryCost(rows int, rowSize int) Cost {
+	size := rows * rowSize
+	pages := size/(1024*1024) + 1
+	return Cost{
+		RowsRead:          rows,
+		RoundTrips:        pages,
+		ReadCapacityUnits: 0.5 * math.Max(float64(pages), math.Ceil(float64(size)/4096)),
+	}
+}
@@ -0,0 +1,113 @@
+package dynamotree
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestEstimateCost(c *C) {
+	s := &Tree{TableName: "t"}
+
+	c.Assert(s.EstimateCost(Op{Kind: OpPut, Key: []string{"Accounts", "12345"}, ItemSize: 100}), DeepEquals, Cost{
+		RowsWritten:        3,
+		RoundTrips:         1,
+		WriteCapacityUnits: 3,
+	})
+
+	c.Assert(s.EstimateCost(Op{Kind: OpPut, Key: []string{"Accounts", "12345"}, ItemSize: 2000}), DeepEquals, Cost{
+		RowsWritten:        3,
+		RoundTrips:         1,
+		WriteCapacityUnits: 4,
+	})
+
+	key := []string{}
+	for i := 0; i < 30; i++ {
+		key = append(key, "X")
+	}
+	c.Assert(s.EstimateCost(Op{Kind: OpPut, Key: key, ItemSize: 100}), DeepEquals, Cost{
+		RowsWritten:        31,
+		RoundTrips:         2,
+		WriteCapacityUnits: 31,
+	})
+
+	c.Assert(s.EstimateCost(Op{Kind: OpPutLink, Key: []string{"Links", "xyz"}, Target: []string{"Accounts", "12345"}}), DeepEquals, Cost{
+		RowsWritten:        3,
+		RoundTrips:         1,
+		WriteCapacityUnits: 3,
+	})
+
+	// The rows are those that Put and PutLink write: the history and
+	// version rows of KeepVersions, without the ancestors' directory
+	// entries of FlatDirectories, and with the backlink of
+	// MaintainBacklinks.
+	versions := &Tree{TableName: "t", KeepVersions: true}
+	c.Assert(versions.EstimateCost(Op{Kind: OpPut, Key: []string{"Accounts", "12345"}, ItemSize: 2000}), DeepEquals, Cost{
+		RowsWritten:        6,
+		RoundTrips:         1,
+		WriteCapacityUnits: 9,
+	})
+	flat := &Tree{TableName: "t", FlatDirectories: true}
+	c.Assert(flat.EstimateCost(Op{Kind: OpPut, Key: []string{"a", "b", "c"}, ItemSize: 100}), DeepEquals, Cost{
+		RowsWritten:        2,
+		RoundTrips:         1,
+		WriteCapacityUnits: 2,
+	})
+	copies := &Tree{TableName: "t", MaintainBacklinks: true, LinkCopyMaxSize: 4096}
+	c.Assert(copies.EstimateCost(Op{Kind: OpPutLink, Key: []string{"Links", "xyz"}, Target: []string{"Accounts", "12345"}, ItemSize: 2000}), DeepEquals, Cost{
+		RowsRead:           1,
+		RowsWritten:        4,
+		RoundTrips:         2,
+		ReadCapacityUnits:  0.5,
+		WriteCapacityUnits: 5,
+	})
+	c.Assert(copies.EstimateCost(Op{Kind: OpPut, Key: []string{"Accounts", "12345"}, ItemSize: 100}), DeepEquals, Cost{
+		RowsWritten:        3,
+		RoundTrips:         2,
+		ReadCapacityUnits:  0.5,
+		WriteCapacityUnits: 3,
+	})
+	c.Assert(s.EstimateCost(Op{Kind: OpPut, Key: []string{"Accounts", ""}}), DeepEquals, Cost{})
+
+	c.Assert(s.EstimateCost(Op{Kind: OpGet, Key: []string{"Accounts", "12345"}, ItemSize: 5000, Links: 1}), DeepEquals, Cost{
+		RowsRead:          2,
+		RoundTrips:        2,
+		ReadCapacityUnits: 1.5,
+	})
+
+	c.Assert(s.EstimateCost(Op{Kind: OpDelete, Key: []string{"Accounts", "12345"}, ItemSize: 100}), DeepEquals, Cost{
+		RowsRead:           1,
+		RowsWritten:        2,
+		RoundTrips:         2,
+		ReadCapacityUnits:  0.5,
+		WriteCapacityUnits: 2,
+	})
+
+	c.Assert(s.EstimateCost(Op{Kind: OpDelete, Key: []string{"Accounts", "12345"}, ItemSize: 100, ReturnWriteCounts: true}), DeepEquals, Cost{
+		RowsRead:           2,
+		RowsWritten:        2,
+		RoundTrips:         3,
+		ReadCapacityUnits:  1,
+		WriteCapacityUnits: 2,
+	})
+
+	// The root has no directory entry to keep.
+	backlinks := &Tree{TableName: "t", MaintainBacklinks: true}
+	c.Assert(backlinks.EstimateCost(Op{Kind: OpDelete, Key: []string{}, ItemSize: 100}), DeepEquals, Cost{
+		RowsRead:           1,
+		RowsWritten:        1,
+		RoundTrips:         2,
+		ReadCapacityUnits:  0.5,
+		WriteCapacityUnits: 1,
+	})
+
+	c.Assert(s.EstimateCost(Op{Kind: OpList, Key: []string{"Accounts"}, Children: 1000}), DeepEquals, Cost{
+		RowsRead:          1000,
+		RoundTrips:        1,
+		ReadCapacityUnits: 4.5,
+	})
+
+	c.Assert(s.EstimateCost(Op{Kind: OpWalk, Key: []string{"Accounts"}, Children: 99}), DeepEquals, Cost{
+		RowsRead:          99,
+		RoundTrips:        100,
+		ReadCapacityUnits: 50.5,
+	})
+}
@@ -0,0 +1,38 @@
+package dynamotree
+
+import (
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// WithCredentials causes the requests that a call makes to DynamoDB to be
+// signed with creds, in place of the tree's credentials, so that a service
+// storing the data of many tenants in one tree can act under the
+// permissions of the tenant it is serving, for example:
+//
+//	creds := stscreds.NewCredentials(sess, tenantRoleARN)
+//	tree.Get(key, &item, dynamotree.WithCredentials(creds))
+//
+// The credentials should be kept and reused from call to call, as they
+// are retrieved again only once they expire. The checks that the tree
+// makes before its first operation, such as reading the table's metadata
+// row, use the tree's own credentials.
+func WithCredentials(creds *credentials.Credentials) Option {
+	return func(o *callOptions) {
+		o.requestOptions = append(o.requestOptions, func(r *request.Request) {
+			r.Config.Credentials = creds
+		})
+	}
+}
+
+// withCredentials returns a copy of db that signs its requests with creds.
+// The copy shares db's configuration, handlers and retryer.
+func withCredentials(db *dynamodb.DynamoDB, creds *credentials.Credentials) *dynamodb.DynamoDB {
+	c := *db.Client
+	c.Config.Credentials = creds
+	c.Handlers = db.Handlers.Copy()
+	rv := *db
+	rv.Client = &c
+	return &rv
+}
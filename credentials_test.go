@@ -0,0 +1,34 @@
+package dynamotree
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestCredentials(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db,
+		Credentials: credentials.NewStaticCredentials("TREEKEY", "secret", "")}
+	c.Assert(s.CreateTable(), IsNil)
+	c.Assert(db.Config.Credentials == s.DB.Config.Credentials, Equals, false)
+
+	var authorization string
+	signed := WithRequestOptions(func(r *request.Request) {
+		r.Handlers.Sign.PushBack(func(r *request.Request) {
+			authorization = r.HTTPRequest.Header.Get("Authorization")
+		})
+	})
+	v := AccountT{ID: "12345", Name: "alice"}
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &v, signed), IsNil)
+	c.Assert(strings.Contains(authorization, "Credential=TREEKEY/"), Equals, true, Commentf("%s", authorization))
+
+	tenant := credentials.NewStaticCredentials("TENANTKEY", "secret", "")
+	c.Assert(s.Get([]string{"Accounts", "12345"}, &v, WithCredentials(tenant), signed), IsNil)
+	c.Assert(strings.Contains(authorization, "Credential=TENANTKEY/"), Equals, true, Commentf("%s", authorization))
+}
@@ -0,0 +1,112 @@
+package dynamotree
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// DeadlineError is returned, when a call is given SplitDeadline, by a
+// request that used up its share of the time left before the deadline of
+// the call's context. It records how far the call got, and matches
+// context.DeadlineExceeded using errors.Is. The call's context is not yet
+// done, so the caller has time left to act on it. For a call that writes,
+// it is the Err of a *MultiRowError that lists the rows written.
+type DeadlineError struct {
+	// RowsWritten is the number of rows the call had written.
+	RowsWritten int
+
+	// PagesRead is the number of pages of Query and Scan results the call
+	// had read.
+	PagesRead int
+}
+
+func (e *DeadlineError) Error() string {
+	return fmt.Sprintf("request ran out of its share of the deadline after %d rows written and %d pages read",
+		e.RowsWritten, e.PagesRead)
+}
+
+// Unwrap returns context.DeadlineExceeded.
+func (e *DeadlineError) Unwrap() error { return context.DeadlineExceeded }
+
+// SplitDeadline causes each request that a call makes, if the context of
+// the call has a deadline, to be given only a share of the time left, so
+// that one slow request, such as a page of a large directory or a batch
+// write that is retried, does not take all of it. A batch write may take
+// the time left divided by the number of batches still to be written, and
+// any other request, such as a page of a Query, half of the time left. A
+// request that runs out of time fails with a *DeadlineError.
+func SplitDeadline() Option {
+	return func(o *callOptions) {
+		o.splitDeadline = true
+	}
+}
+
+// budget counts the progress of a call given SplitDeadline.
+type budget struct {
+	batchesLeft int
+	rowsWritten int
+	pagesRead   int
+}
+
+// shareDeadline is a request.Option that gives r its share of the time
+// left before the deadline of o's context.
+func (o *callOptions) shareDeadline(r *request.Request) {
+	parent := r.Context()
+	deadline, ok := parent.Deadline()
+	if !ok {
+		return
+	}
+	o.statsMu.Lock()
+	parts := 2
+	if r.Operation.Name == "BatchWriteItem" && o.budget.batchesLeft > 0 {
+		parts = o.budget.batchesLeft
+	}
+	o.statsMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(parent, time.Until(deadline)/time.Duration(parts))
+	r.SetContext(ctx)
+	r.Handlers.AfterRetry.PushBack(func(r *request.Request) {
+		if r.Error == nil || r.WillRetry() || ctx.Err() != context.DeadlineExceeded || parent.Err() != nil {
+			return
+		}
+		o.statsMu.Lock()
+		defer o.statsMu.Unlock()
+		r.Error = &DeadlineError{RowsWritten: o.budget.rowsWritten, PagesRead: o.budget.pagesRead}
+	})
+	r.Handlers.Complete.PushBack(func(r *request.Request) {
+		cancel()
+		if r.Error != nil {
+			return
+		}
+		switch r.Operation.Name {
+		case "Query", "Scan":
+			o.statsMu.Lock()
+			defer o.statsMu.Unlock()
+			o.budget.pagesRead++
+		}
+	})
+}
+
+// startBatches records that a call given SplitDeadline has n batches left
+// to write.
+func (o *callOptions) startBatches(n int) {
+	if o == nil || !o.splitDeadline {
+		return
+	}
+	o.statsMu.Lock()
+	defer o.statsMu.Unlock()
+	o.budget.batchesLeft = n
+}
+
+// wroteRows records that a call given SplitDeadline has written n rows.
+func (o *callOptions) wroteRows(n int) {
+	if o == nil || !o.splitDeadline {
+		return
+	}
+	o.statsMu.Lock()
+	defer o.statsMu.Unlock()
+	o.budget.rowsWritten += n
+}
@@ -0,0 +1,75 @@
+package dynamotree
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestSplitDeadline(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+
+	// delay makes each request for operation after the first n wait.
+	delay := func(operation string, n int) Option {
+		return WithRequestOptions(func(r *request.Request) {
+			if r.Operation.Name != operation {
+				return
+			}
+			r.Handlers.Send.PushFront(func(r *request.Request) {
+				if n--; n < 0 {
+					select {
+					case <-time.After(900 * time.Millisecond):
+					case <-r.Context().Done():
+					}
+				}
+			})
+		})
+	}
+
+	writeRequests := []*dynamodb.WriteRequest{}
+	for i := 0; i < 30; i++ {
+		id := strconv.Itoa(i)
+		rows, err := s.putRequests([]string{id}, &AccountT{ID: id})
+		c.Assert(err, IsNil)
+		writeRequests = append(writeRequests, rows...)
+	}
+	c.Assert(len(s.newPlan(writeRequests).Requests), Equals, 3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	o, done := newCallOptions([]Option{WithContext(ctx), SplitDeadline(), delay("BatchWriteItem", 1)})
+	err := s.batchWrite(writeRequests, o)
+	done()
+	c.Assert(err, FitsTypeOf, &MultiRowError{})
+	c.Assert(err.(*MultiRowError).Written, HasLen, 25)
+	c.Assert(err, ErrorIs, context.DeadlineExceeded)
+	c.Assert(err.(*MultiRowError).Err, DeepEquals, &DeadlineError{RowsWritten: 25})
+	c.Assert(ctx.Err(), IsNil)
+
+	// Walk reads a page for each directory.
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var walkErr error
+	s.Walk([]string{}, func(key []string, err error) bool {
+		if err != nil {
+			walkErr = err
+		}
+		return true
+	}, WithContext(ctx), SplitDeadline(), delay("Query", 2))
+	c.Assert(walkErr, ErrorIs, context.DeadlineExceeded)
+	c.Assert(walkErr, ErrorMatches, `.*after 0 rows written and 2 pages read`)
+	c.Assert(ctx.Err(), IsNil)
+
+	// Without a deadline, nothing changes.
+	o, done = newCallOptions([]Option{SplitDeadline()})
+	c.Assert(s.batchWrite(writeRequests, o), IsNil)
+	done()
+}
@@ -0,0 +1,239 @@
+package dynamotree
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// DocumentFormat is the shape of the documents written by ExportDocuments.
+type DocumentFormat int
+
+// The formats supported by ExportDocuments.
+const (
+	// DocumentsFirestore writes each object as a Firestore document, as
+	// the Firestore REST API represents one: its name is its path of
+	// alternating collection and document IDs, relative to the database's
+	// documents, and its fields are typed values such as
+	// {"stringValue": "alice"}. Because a document's path has an even
+	// number of parts, the object at a key with an odd number of parts is
+	// written as the document FirestoreObjectID in the collection named
+	// by its key. Each link is written as a document with the single field
+	// DocumentLinkField, a referenceValue naming its target's document.
+	DocumentsFirestore DocumentFormat = iota
+
+	// DocumentsMongo writes each object as a MongoDB document, in
+	// relaxed Extended JSON, following MongoDB's pattern for trees with
+	// materialized paths: its _id is its key, with its parts separated by
+	// "/", _parent is the key of its parent and _ancestors the keys of
+	// each of its ancestors, from the root, so that a subtree can be found
+	// using an index on _ancestors. These fields take the place of any
+	// attribute of the same name. Each link is written as a document whose
+	// DocumentLinkField holds the _id of its target.
+	DocumentsMongo
+)
+
+// FirestoreObjectID is the ID of the Firestore document in which
+// ExportDocuments writes the object at a key with an odd number of parts.
+const FirestoreObjectID = "_object"
+
+// DocumentLinkField is the name of the field of the documents that
+// ExportDocuments writes for symbolic links, which holds the target.
+const DocumentLinkField = "__link"
+
+// ExportDocuments writes each object and link below prefix (and at prefix
+// itself) to w as a document in the format of another document store,
+// one JSON document per line, so that the documents can be imported into
+// that store, preserving the hierarchy of the keys. Given Redacted, the
+// attributes that Tree.Redactor considers sensitive are masked. The
+// progress of the export can be reported using WithProgress.
+func (t *Tree) ExportDocuments(prefix []string, w io.Writer, format DocumentFormat, opts ...Option) error {
+	o, cancel := newCallOptions(opts)
+	defer cancel()
+	if o.checkpoint != "" {
+		return errors.New("ExportDocuments cannot be resumed from a checkpoint")
+	}
+	if err := refuseCapability("ExportDocuments", prefix, o); err != nil {
+		return err
+	}
+	if err := t.ready(); err != nil {
+		return err
+	}
+	prefix, err := t.transformKey(prefix)
+	if err != nil {
+		return err
+	}
+	var document func(key, target []string, item map[string]*dynamodb.AttributeValue) interface{}
+	switch format {
+	case DocumentsFirestore:
+		document = firestoreDocument
+	case DocumentsMongo:
+		document = mongoDocument
+	default:
+		return fmt.Errorf("unknown document format %d", format)
+	}
+	j, err := t.startJob("ExportDocuments", [][]string{prefix}, o)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	export := func(key []string) error {
+		if len(key) == 0 {
+			return nil
+		}
+		row, err := t.getRow(t.EncodeKey(key), o)
+		if err != nil || row == nil {
+			return err
+		}
+		if linkTarget, ok := t.linkTarget(row); ok {
+			return encoder.Encode(document(key, t.DecodeKey(linkTarget), nil))
+		}
+		row = objectAttributes(t, row)
+		if o.redact {
+			row = t.Redact(key, row)
+		}
+		return encoder.Encode(document(key, nil, row))
+	}
+
+	if err := export(prefix); err != nil {
+		return err
+	}
+	_, err = j.walk(prefix, func(key []string) (bool, error) { return true, export(key) })
+	return err
+}
+
+// firestorePath returns the path of the Firestore document for key.
+func firestorePath(key []string) string {
+	if len(key)%2 == 1 {
+		key = append(key[:len(key):len(key)], FirestoreObjectID)
+	}
+	return strings.Join(key, "/")
+}
+
+// firestoreDocument returns the Firestore document for the object item, or
+// the link to target, at key.
+func firestoreDocument(key, target []string, item map[string]*dynamodb.AttributeValue) interface{} {
+	fields := map[string]interface{}{}
+	if target != nil {
+		fields[DocumentLinkField] = map[string]interface{}{"referenceValue": firestorePath(target)}
+	}
+	for name, value := range item {
+		fields[name] = firestoreValue(value)
+	}
+	return map[string]interface{}{"name": firestorePath(key), "fields": fields}
+}
+
+// firestoreValue returns value as a Firestore Value.
+func firestoreValue(v *dynamodb.AttributeValue) interface{} {
+	array := func(values []interface{}) interface{} {
+		return map[string]interface{}{"arrayValue": map[string]interface{}{"values": values}}
+	}
+	number := func(n string) interface{} {
+		if strings.ContainsAny(n, ".eE") {
+			return map[string]interface{}{"doubleValue": json.Number(n)}
+		}
+		// Firestore gives 64-bit integers as strings.
+		return map[string]interface{}{"integerValue": n}
+	}
+	switch {
+	case v.S != nil:
+		return map[string]interface{}{"stringValue": *v.S}
+	case v.N != nil:
+		return number(*v.N)
+	case v.BOOL != nil:
+		return map[string]interface{}{"booleanValue": *v.BOOL}
+	case v.B != nil:
+		return map[string]interface{}{"bytesValue": base64.StdEncoding.EncodeToString(v.B)}
+	case v.M != nil:
+		fields := make(map[string]interface{}, len(v.M))
+		for name, e := range v.M {
+			fields[name] = firestoreValue(e)
+		}
+		return map[string]interface{}{"mapValue": map[string]interface{}{"fields": fields}}
+	case v.L != nil:
+		values := make([]interface{}, len(v.L))
+		for i, e := range v.L {
+			values[i] = firestoreValue(e)
+		}
+		return array(values)
+	case v.SS != nil:
+		values := make([]interface{}, len(v.SS))
+		for i, s := range v.SS {
+			values[i] = map[string]interface{}{"stringValue": aws.StringValue(s)}
+		}
+		return array(values)
+	case v.NS != nil:
+		values := make([]interface{}, len(v.NS))
+		for i, n := range v.NS {
+			values[i] = number(aws.StringValue(n))
+		}
+		return array(values)
+	case v.BS != nil:
+		values := make([]interface{}, len(v.BS))
+		for i, b := range v.BS {
+			values[i] = map[string]interface{}{"bytesValue": base64.StdEncoding.EncodeToString(b)}
+		}
+		return array(values)
+	}
+	return map[string]interface{}{"nullValue": nil}
+}
+
+// mongoDocument returns the MongoDB document for the object item, or the
+// link to target, at key.
+func mongoDocument(key, target []string, item map[string]*dynamodb.AttributeValue) interface{} {
+	document := make(map[string]interface{}, len(item)+4)
+	for name, value := range item {
+		document[name] = mongoValue(value)
+	}
+	ancestors := make([]string, len(key)-1)
+	for i := range ancestors {
+		ancestors[i] = strings.Join(key[:i+1], "/")
+	}
+	document["_id"] = strings.Join(key, "/")
+	document["_parent"] = strings.Join(key[:len(key)-1], "/")
+	document["_ancestors"] = ancestors
+	if target != nil {
+		document[DocumentLinkField] = strings.Join(target, "/")
+	}
+	return document
+}
+
+// mongoValue returns value in relaxed Extended JSON. Sets become arrays.
+func mongoValue(v *dynamodb.AttributeValue) interface{} {
+	binary := func(b []byte) interface{} {
+		return map[string]interface{}{"$binary": map[string]interface{}{
+			"base64":  base64.StdEncoding.EncodeToString(b),
+			"subType": "00",
+		}}
+	}
+	switch {
+	case v.B != nil:
+		return binary(v.B)
+	case v.BS != nil:
+		rv := make([]interface{}, len(v.BS))
+		for i, b := range v.BS {
+			rv[i] = binary(b)
+		}
+		return rv
+	case v.M != nil:
+		rv := make(map[string]interface{}, len(v.M))
+		for name, e := range v.M {
+			rv[name] = mongoValue(e)
+		}
+		return rv
+	case v.L != nil:
+		rv := make([]interface{}, len(v.L))
+		for i, e := range v.L {
+			rv[i] = mongoValue(e)
+		}
+		return rv
+	}
+	return attributeToJSON(v)
+}
@@ -0,0 +1,67 @@
+package dynamotree
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestExportDocuments(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "12345"}, rawItem{
+		"ID":   {S: aws.String("12345")},
+		"Name": {S: aws.String("alice")},
+	}), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "12345", "Settings"}, rawItem{"ID": {S: aws.String("s")}}), IsNil)
+	c.Assert(s.PutLink([]string{"Accounts", "alice"}, []string{"Accounts", "12345"}), IsNil)
+	c.Assert(s.Put([]string{"Other"}, &AccountT{ID: "o"}), IsNil)
+
+	documents := func(format DocumentFormat) []map[string]interface{} {
+		buf := bytes.NewBuffer(nil)
+		c.Assert(s.ExportDocuments([]string{"Accounts"}, buf, format), IsNil)
+		rv := []map[string]interface{}{}
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var document map[string]interface{}
+			c.Assert(json.Unmarshal([]byte(line), &document), IsNil)
+			rv = append(rv, document)
+		}
+		return rv
+	}
+
+	firestore := documents(DocumentsFirestore)
+	c.Assert(firestore, DeepEquals, []map[string]interface{}{
+		{"name": "Accounts/12345", "fields": map[string]interface{}{
+			"ID":   map[string]interface{}{"stringValue": "12345"},
+			"Name": map[string]interface{}{"stringValue": "alice"},
+		}},
+		{"name": "Accounts/12345/Settings/_object", "fields": map[string]interface{}{
+			"ID": map[string]interface{}{"stringValue": "s"},
+		}},
+		{"name": "Accounts/alice", "fields": map[string]interface{}{
+			DocumentLinkField: map[string]interface{}{"referenceValue": "Accounts/12345"},
+		}},
+	})
+
+	mongo := documents(DocumentsMongo)
+	c.Assert(mongo, DeepEquals, []map[string]interface{}{
+		{"_id": "Accounts/12345", "_parent": "Accounts", "_ancestors": []interface{}{"Accounts"},
+			"ID": "12345", "Name": "alice"},
+		{"_id": "Accounts/12345/Settings", "_parent": "Accounts/12345",
+			"_ancestors": []interface{}{"Accounts", "Accounts/12345"}, "ID": "s"},
+		{"_id": "Accounts/alice", "_parent": "Accounts", "_ancestors": []interface{}{"Accounts"},
+			DocumentLinkField: "Accounts/12345"},
+	})
+
+	c.Assert(firestoreValue(&dynamodb.AttributeValue{N: aws.String("42")}), DeepEquals,
+		map[string]interface{}{"integerValue": "42"})
+	c.Assert(mongoValue(&dynamodb.AttributeValue{B: []byte("hi")}), DeepEquals,
+		map[string]interface{}{"$binary": map[string]interface{}{"base64": "aGk=", "subType": "00"}})
+}
@@ -1,11 +1,22 @@
 package dynamotree
 
 import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
 )
 
 // Tree implements hierarchical storage
@@ -16,12 +27,346 @@ type Tree struct {
 	// DB is a reference to the DynamoDB service
 	DB *dynamodb.DynamoDB
 
+	// Backend, if not nil, is the store in which the tree reads and
+	// writes its rows by key, in place of the table TableName in DB. See
+	// Backend for the requests it carries.
+	Backend Backend
+
+	// Endpoint, Region and HTTPClient, if set, configure the client that
+	// the tree uses in place of DB's own configuration, for example to use
+	// DynamoDB Local or LocalStack, or an *http.Client with timeouts and a
+	// connection pool tuned for the application. Before the tree's first
+	// operation, DB is then replaced by a new client configured as DB was,
+	// apart from these settings, with the same handlers. If DB is nil, the
+	// tree creates a client configured by these settings and by the
+	// environment and shared configuration files, as session.NewSession
+	// does.
+	Endpoint   string
+	Region     string
+	HTTPClient *http.Client
+
+	// Lambda tunes the tree's client for use in AWS Lambda, where a process
+	// serves one invocation at a time for as long as the execution
+	// environment is kept: unless HTTPClient is set, connections to
+	// DynamoDB are kept open between invocations; requests are retried at
+	// most LambdaMaxRetries times, unless DB's configuration gives another
+	// number; and each request made with a context that has a deadline,
+	// such as the context given to the handler and passed to the tree's
+	// methods using WithContext, is abandoned LambdaDeadlineMargin before
+	// the deadline, so that no request outlives the invocation. As ever,
+	// the table is checked when the tree is first used, not when it is
+	// created; Open makes the check in advance.
+	Lambda bool
+
+	// Credentials, if not nil, are used to sign the tree's requests in
+	// place of the credentials DB was created with, for example those of
+	// a role assumed for one tenant using stscreds.NewCredentials. Before
+	// the tree's first operation, DB is replaced by a copy of the client
+	// that uses them. WithCredentials gives credentials for a single call.
+	Credentials *credentials.Credentials
+
+	// FastGetDB, if not nil, is the client through which FastGet reads, in
+	// place of DB, for example a DynamoDB Accelerator (DAX) client, which
+	// implements dynamodbiface.DynamoDBAPI. The tree's other operations
+	// always use DB.
+	FastGetDB dynamodbiface.DynamoDBAPI
+
+	// KeySchema, if not nil, is used to store the tree in a table whose key
+	// attributes are not named "Key" and "Child", or which holds other data
+	// besides the tree's. Before the tree's first operation, DB is replaced
+	// by a copy of the client that applies the mapping.
+	KeySchema *KeySchemaMapping
+
+	// ItemType, if not empty, is written to the ItemTypeAttribute of each
+	// of the tree's rows, and queries and scans return only the rows that
+	// have it, so that the tree can share a table with other data. It
+	// sets the TypeAttribute and TypeValue of KeySchema.
+	ItemType string
+
+	// NoScan, if set, causes the operations that would scan the table,
+	// listed in ScanOperations, to return a *ScanError instead, so that
+	// every request the tree makes reads only the rows of known keys.
+	// Scans of the table through DB fail too.
+	NoScan bool
+
+	// OnScan, if not nil, is called with the name of each operation, as
+	// listed in ScanOperations, that is about to scan the table, or would
+	// have if NoScan were not set. It may be used to find the callers
+	// that would need changing before setting NoScan.
+	OnScan func(operation string)
+
+	// OnOperation, if not nil, is called once each call to Put, PutLink,
+	// PutLinkIfAbsent, Get, GetLink, FastGet, Stat, GetAsOf, Delete,
+	// DeleteAll, DeleteChildren, AppendEvent, References, List, Walk or
+	// WalkParallel returns, with its duration and outcome, for example to
+	// record the rate and latency of each operation in a monitoring system.
+	OnOperation func(OperationMetrics)
+
+	// MetricsPrefixDepth is the number of parts of each key that are given
+	// to OnOperation, in OperationMetrics.Prefix, such as 1 for a tree
+	// whose keys begin with the name of a collection, or 2 for one whose
+	// keys begin with a tenant and then a collection. If zero, none are.
+	MetricsPrefixDepth int
+
+	// SlowOperationThreshold, if not zero, is the duration at or beyond
+	// which a call to one of the operations reported to OnOperation is
+	// reported to OnSlowOperation, with its key and the requests it made.
+	SlowOperationThreshold time.Duration
+
+	// OnSlowOperation is called with each slow call. If it is nil, slow
+	// calls are written to the Logger of DB's configuration, if it has
+	// one.
+	OnSlowOperation func(*SlowOperation)
+
+	// WideDirectoryThreshold, if not zero, is the number of children at
+	// which a directory found by List, Walk or CountChildren is reported
+	// to OnWideDirectory, once for the life of the tree. See
+	// WideDirectory.
+	WideDirectoryThreshold int
+
+	// OnWideDirectory is called with each wide directory found. If it is
+	// nil, wide directories are written to the Logger of DB's
+	// configuration, if it has one.
+	OnWideDirectory func(*WideDirectory)
+
+	// HeatSampler, if not nil, counts the reads and writes of each key
+	// given to the operations reported to OnOperation, so that the
+	// hottest keys and prefixes can be found. See HeatSampler.
+	HeatSampler *HeatSampler
+
+	// Clock, if not nil, is used in place of the system clock to tell the
+	// time: to timestamp versions, events and queue messages, to decide
+	// when TTLs and leases expire, and to pace rate-limited operations.
+	// The intervals of loops such as Sweeper.Run and WatchKey use the
+	// system clock.
+	Clock Clock
+
+	// Rand, if not nil, is read in place of crypto/rand.Reader for the
+	// random parts of generated IDs, such as those of versions, events,
+	// queue messages and leases, and to choose the rows checked by
+	// IntegrityJob. The delays between the retries of the AWS SDK's
+	// retryer are chosen by the SDK.
+	Rand io.Reader
+
+	// Checksums, if set, causes Put to store with each object a checksum
+	// of its attributes, which Get, GetAsOf, and FastGet when it is given
+	// no attributes, check before filling in the object, returning a
+	// *ChecksumError if they no longer match, so that an object corrupted
+	// by another writer of the table or by a migration is detected when
+	// it is read rather than used. Objects stored without a checksum are
+	// read as before.
+	Checksums bool
+
 	// SpecialCharacter is the character that delimits parts of keys in storage.
 	// It may not appear in keys or at the beginning of attribute names.
 	// If not specified, the value given by DefaultSpecialCharacter is used.
+	// It may be a string of more than one character, subject to the rules
+	// given by ValidateSpecialCharacter, which are checked before the
+	// tree's first operation.
 	SpecialCharacter string
 
+	// FlatDirectories causes Put, PutLink and PutLinkIfAbsent to write only
+	// the directory entry of each key in its immediate parent, rather than
+	// an entry for each part of the key, for applications that look up
+	// keys only in the directory that holds them, so that a key of n parts
+	// costs 2 rather than n+1 rows. List and Walk of a directory above the
+	// parent do not find the keys so stored unless the entries of their
+	// ancestors are written otherwise, and neither then do DeleteAll,
+	// DeleteChildren and the other methods that walk the tree. Keys stored
+	// before FlatDirectories was set keep their entries. The option of the
+	// same name sets it for a single call.
+	FlatDirectories bool
+
+	// MaxKeyDepth is the maximum number of parts that a key stored with Put
+	// or PutLink (or the target of a link) may have. Because each part
+	// requires an additional directory row, this bounds the number of rows
+	// written by a single operation. If zero, keys may be of any depth.
+	MaxKeyDepth int
+
+	// MaxLinkHops is the maximum number of symbolic links that Get will
+	// follow when resolving a key. If not specified, the value given by
+	// DefaultMaxLinkHops is used.
+	MaxLinkHops int
+
+	// LinkAttribute is the name of the attribute that holds the target of
+	// a symbolic link. If not specified, the SpecialCharacter is used, as
+	// in earlier versions. Choosing a plain name such as "__link" makes
+	// links easier to recognize for tools outside of this package. Links
+	// whose target is held in an attribute named by the SpecialCharacter
+	// are always recognized, so LinkAttribute may be set for a table that
+	// already contains links. Objects may not have an attribute of this
+	// name.
+	LinkAttribute string
+
+	// KeyEncoding determines how parts of keys are stored. By default parts
+	// are stored as they are, and may not be empty or contain the
+	// SpecialCharacter. Other encodings allow parts to be arbitrary byte
+	// strings, which are decoded transparently by List, Walk and DecodeKey.
+	KeyEncoding KeyEncoding
+
+	// KeyTransformer, if not nil, is applied to every key (and link target
+	// and prefix) passed to the tree before it is validated or used. It
+	// lets an application enforce rules for keys, such as slugification or
+	// length limits, in one place. An error returned by KeyTransformer is
+	// returned by the operation. Because keys returned by List and Walk are
+	// passed through it again when they are used, KeyTransformer should
+	// return a key it has already transformed unchanged.
+	KeyTransformer func(key []string) ([]string, error)
+
+	// DefaultAttributes are added to every object stored by Put, and by
+	// the other means of writing objects such as a Loader, so that
+	// metadata such as the environment or schema version is recorded
+	// without each Storable having to include it. An attribute of the same
+	// name marshalled by the object takes precedence. The attributes are
+	// subject to the same rules as the object's own, so their names may
+	// not begin with the SpecialCharacter or be the LinkAttribute.
+	DefaultAttributes map[string]*dynamodb.AttributeValue
+
+	// Redactor, if not nil, decides which attributes of objects are
+	// sensitive, so that Redact and exports given Redacted mask them.
+	Redactor Redactor
+
+	// TenantAttribute, if not empty, is the name of an attribute that Put,
+	// PutLink and AppendEvent add to each row they write, holding the
+	// first part of the key written, which identifies its tenant when the
+	// tree holds the keys of many tenants below top-level keys. It
+	// replaces any attribute of the same name that the object has. The
+	// attribute lets tools outside of this package, such as IAM policies
+	// using the dynamodb:Attributes condition key, streams and exports,
+	// attribute rows to tenants.
+	TenantAttribute string
+
+	// TenantPartitions causes Put, PutLink and Delete not to write the
+	// rows that the tenants below top-level keys share, the tenants'
+	// entries in the root directory and the history of the root, so that
+	// every row they write for a tenant has a partition key that begins
+	// with the tenant, and IAM policies using the dynamodb:LeadingKeys
+	// condition key, such as those given by TenantPolicy, can confine
+	// each tenant to its own rows. Objects, links and events may then not
+	// be stored at the empty key. PutTenant records a tenant in the root
+	// directory, so that listing the root finds it.
+	TenantPartitions bool
+
+	// WriteGuards protect the keys below certain prefixes from being
+	// written by Put, PutLink, Delete and AppendEvent. See WriteGuard.
+	WriteGuards []WriteGuard
+
+	// CapabilityKeys are the secrets with which MintCapability signs
+	// tokens, using the first, and with which the tokens given by
+	// WithCapability are verified, using any, so that a key can be
+	// replaced without invalidating the tokens already issued.
+	CapabilityKeys [][]byte
+
+	// MaintainBacklinks causes PutLink to record, alongside the target of
+	// each link, a backlink row naming the link, and Delete to remove the
+	// backlink of a link it deletes. The backlinks are used by References
+	// and FailIfReferenced. Links created before MaintainBacklinks was set
+	// have no backlinks.
+	MaintainBacklinks bool
+
+	// LinkCopyMaxSize, if not zero, causes PutLink to store in the row of
+	// each link a copy of the object it points at, if the object's
+	// attributes take up no more than LinkCopyMaxSize bytes, so that Get
+	// can read an object through a link in a single request. Put and
+	// Delete then update the copies held by the links to the object they
+	// write, which they find using the index kept by MaintainBacklinks,
+	// which must also be set. Because the copies are updated after the
+	// object is written, a Get through a link may briefly return the
+	// previous version of the object; Get given ConsistentRead always
+	// reads the object itself. Objects written by other means, such as a
+	// Loader, do not update the copies.
+	LinkCopyMaxSize int
+
+	// KeepVersions causes Put, PutLink and Delete to record each version
+	// of the objects and links they write, so that GetAsOf and ListAsOf
+	// can read the tree as it was at a past time. Each write then also
+	// stores a copy of the object and a row for each part of its key.
+	// Versions written before KeepVersions was set are not available.
+	KeepVersions bool
+
+	// RetentionPolicies limit how long versions and events are kept below
+	// certain prefixes. They are enforced by a Sweeper.
+	RetentionPolicies []RetentionPolicy
+
+	// TTLAttribute is the name of the attribute that the table's time to
+	// live setting uses, if any. Events and objects subject to the EventTTL
+	// or ObjectTTL of a RetentionPolicy are given this attribute, holding
+	// the time at which they expire in seconds since the epoch, so that
+	// DynamoDB removes them.
+	TTLAttribute string
+
+	// KeyLocker, if not nil, serializes the writes that this process makes
+	// to each key: Put, PutLink, PutLinkIfAbsent and Delete hold the lock
+	// for the key while they read and write its rows, so that concurrent
+	// writes to the same key do not interleave their directory and object
+	// rows and expose surprising intermediate states to Get and List.
+	// Writes from other processes are not serialized. See KeyMutex.
+	KeyLocker KeyLocker
+
+	// ProtectLinks causes Put to fail with an *IsLinkError, rather than
+	// replace the link with the object, when the key holds a symbolic
+	// link, unless ReplaceLink is given. The check is made by a condition
+	// on the write, so Put then writes the object's row before its
+	// directory entries, as WithCondition does.
+	ProtectLinks bool
+
+	// ReportDirectories causes Get, when nothing is stored at the key (or
+	// at the target of a link) but there are keys below it, to return an
+	// *IsDirectoryError rather than a *NotFoundError, so that callers can
+	// tell a directory from a key that does not exist. It costs an extra
+	// Query each time Get finds nothing.
+	ReportDirectories bool
+
+	// VerifySchema causes the tree to check, before its first operation,
+	// that the table has the schema that the tree expects. If the check
+	// fails, each operation returns a *SchemaError. See CheckSchema.
+	VerifySchema bool
+
+	// ReadCapacityUnits and WriteCapacityUnits are the provisioned
+	// throughput of the table that CreateTable creates. If zero, 1 is
+	// used.
+	ReadCapacityUnits  int64
+	WriteCapacityUnits int64
+
+	// CoalesceWrites, if positive, causes Put, PutLink and Delete calls
+	// made at about the same time, from any number of goroutines, to share
+	// BatchWriteItem requests, which raises the throughput of bursts of
+	// small writes and lowers the number of requests they make. Each call
+	// whose rows fit in one request waits up to CoalesceWrites for the
+	// rows of other calls, until there are enough to fill a request, and
+	// returns once its own rows are written. Directory entries written by
+	// several of the calls are written once. Calls that give a condition,
+	// ask for the old item, or are made with options that change their
+	// requests, such as WithRequestOptions, are not coalesced. A few
+	// milliseconds is usually enough.
+	CoalesceWrites time.Duration
+
 	initOnce sync.Once
+
+	readyMu sync.Mutex
+	isReady uint32
+
+	// tableVersion is the version of the storage layout recorded for the
+	// table, and legacySchema the migrations from that version to the
+	// current one, which are used to read rows not yet migrated.
+	tableVersion int
+	legacySchema []schemaMigration
+
+	coalescer *writeCoalescer
+
+	// The state of the tree's background work and of Close; see close.go.
+	lifeMu     sync.Mutex
+	closed     bool
+	isClosed   uint32
+	closing    chan struct{}
+	closers    map[int]func(context.Context) error
+	nextCloser int
+	workers    sync.WaitGroup
+
+	keyMapper *keyMapper
+
+	// wideDirectories holds the directories reported to OnWideDirectory.
+	wideDirectories sync.Map
 }
 
 // CreateTable creates the DynamoDB table specified by TableName if
@@ -30,82 +375,179 @@ type Tree struct {
 //
 // If you wish to create the table on your own, you must specify a
 // string type hash key named "Key" and a string type range key named
-// "Child".
-func (t *Tree) CreateTable() error {
+// "Child", or set KeySchema to use other names. TableDefinition describes
+// the table in full.
+//
+// CreateTable also records SpecialCharacter in the table's metadata row,
+// or returns a *SchemaError if the table was created with a different
+// SpecialCharacter.
+func (t *Tree) CreateTable(opts ...Option) error {
 	t.initOnce.Do(t.init)
+	o, cancel := newCallOptions(opts)
+	defer cancel()
 
-	_, err := t.DB.CreateTable(&dynamodb.CreateTableInput{
-		TableName: aws.String(t.TableName),
-		KeySchema: []*dynamodb.KeySchemaElement{
-			{
-				AttributeName: aws.String("Key"),
-				KeyType:       aws.String(dynamodb.KeyTypeHash),
-			},
-			{
-				AttributeName: aws.String("Child"),
-				KeyType:       aws.String(dynamodb.KeyTypeRange),
-			},
-		},
-		AttributeDefinitions: []*dynamodb.AttributeDefinition{
-			&dynamodb.AttributeDefinition{
-				AttributeName: aws.String("Key"),
-				AttributeType: aws.String(dynamodb.ScalarAttributeTypeS),
-			},
-			&dynamodb.AttributeDefinition{
-				AttributeName: aws.String("Child"),
-				AttributeType: aws.String(dynamodb.ScalarAttributeTypeS),
-			}},
-		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
-			ReadCapacityUnits:  aws.Int64(1), // TODO(ross): make this configurable
-			WriteCapacityUnits: aws.Int64(1),
-		},
-	})
+	_, err := t.DB.CreateTableWithContext(o.context(), t.TableDefinition().CreateTableInput(), o.request()...)
 	// TODO(ross): detect this error correctly
-	if err != nil && strings.HasPrefix(err.Error(), "ResourceInUseException") {
-		return nil
+	if err != nil && !strings.HasPrefix(err.Error(), "ResourceInUseException") {
+		return err
 	}
-	return err
+	return t.putMetadata()
 }
 
 func (t *Tree) init() {
 	if t.SpecialCharacter == "" {
 		t.SpecialCharacter = DefaultSpecialCharacter
 	}
+	if t.MaxLinkHops == 0 {
+		t.MaxLinkHops = DefaultMaxLinkHops
+	}
+	if t.LinkAttribute == "" {
+		t.LinkAttribute = t.SpecialCharacter
+	}
+	if t.needsClient() {
+		t.DB = t.newClient()
+	}
+	if t.Credentials != nil {
+		t.DB = withCredentials(t.DB, t.Credentials)
+	}
+	if m := t.keySchema(); m != nil {
+		t.keyMapper = &keyMapper{table: t.TableName, m: m}
+		t.DB = withKeySchema(t.DB, t.keyMapper)
+	}
+	if t.NoScan {
+		t.DB = withoutScans(t.DB, t.TableName)
+	}
+	if t.CoalesceWrites > 0 {
+		t.coalescer = &writeCoalescer{tree: t, window: t.CoalesceWrites}
+	}
 }
 
-// Put stores item in the tree according to "key".
-func (t *Tree) Put(key []string, item Storable) error {
+// ready initializes the tree and, until it has done so successfully,
+// checks its settings with CheckConfig and that the table is usable: that
+// its metadata row (if any) agrees
+// with SpecialCharacter, and if VerifySchema is set, that it has the
+// expected schema. Once the tree is closed, it returns ErrClosed.
+func (t *Tree) ready() error {
 	t.initOnce.Do(t.init)
+	if atomic.LoadUint32(&t.isClosed) == 1 {
+		return ErrClosed
+	}
+	if atomic.LoadUint32(&t.isReady) == 1 {
+		return nil
+	}
 
-	writeRequests := []*dynamodb.WriteRequest{}
+	t.readyMu.Lock()
+	defer t.readyMu.Unlock()
+	if t.isReady == 1 {
+		return nil
+	}
+	if err := t.CheckConfig(); err != nil {
+		return err
+	}
+	if t.VerifySchema {
+		if err := t.CheckSchema(); err != nil {
+			return err
+		}
+	}
+	if err := t.checkMetadata(); err != nil {
+		return err
+	}
+	atomic.StoreUint32(&t.isReady, 1)
+	return nil
+}
 
-	pathKey := ""
-	for i := 0; i < len(key); i++ {
-		if strings.Contains(key[i], t.SpecialCharacter) {
-			return ErrReservedCharacterInKey
+// Put stores item in the tree according to "key".
+//
+// Put writes a directory entry for each part of key as well as the row of
+// the object. The key is validated and item is marshalled before anything
+// is sent to DynamoDB, so if either fails the table is not modified. If
+// Put fails once it has begun writing, it returns a *MultiRowError that
+// describes which of the rows were written.
+//
+// If key holds a symbolic link, Put replaces the link's row with that of
+// the object, so none of the link's attributes remain, unless
+// Tree.ProtectLinks is set, in which case Put returns an *IsLinkError and
+// the tree is not modified.
+func (t *Tree) Put(key []string, item Storable, opts ...Option) (err error) {
+	defer annotateError(&err, "Put", key)
+	o, cancel := t.startCall("Put", key, &err, opts)
+	defer cancel()
+	t.initOnce.Do(t.init)
+	if err := t.checkCapability("Put", key, o); err != nil {
+		return err
+	}
+	key, err = t.transformKey(key)
+	if err != nil {
+		return err
+	}
+	defer t.lockKey(key)()
+	writeRequests, err := t.putRequests(key, item)
+	if err != nil {
+		return err
+	}
+	writeRequests = t.flatten(key, writeRequests, o)
+	guard, err := t.checkGuards(key, guardCreate, o)
+	if err != nil {
+		return err
+	}
+	if err := t.ready(); err != nil {
+		return err
+	}
+	protectLink := t.ProtectLinks && !o.replaceLink
+	if protectLink {
+		WithCondition(t.notLinkCondition())(o)
+	}
+	err = t.write(writeRequests, o)
+	t.countWrites(writeRequests, err, nil, o)
+	if protectLink && errors.Is(err, ErrConditionFailed) {
+		existing, getErr := t.getRow(t.EncodeKey(key), o)
+		if getErr != nil {
+			return getErr
+		}
+		if _, isLink := t.linkTarget(existing); isLink {
+			return ErrIsLink
 		}
-		pathKey += t.SpecialCharacter
-		ChildKey := key[i]
+	}
+	if err := guardFailed(guard, key, err); err != nil {
+		return err
+	}
+	return t.refreshLinkCopies(key, writeRequests[len(writeRequests)-1].PutRequest.Item, o)
+}
 
-		writeRequests = append(writeRequests, &dynamodb.WriteRequest{
-			PutRequest: &dynamodb.PutRequest{
-				Item: map[string]*dynamodb.AttributeValue{
-					"Key": &dynamodb.AttributeValue{
-						S: aws.String(pathKey),
-					},
-					"Child": &dynamodb.AttributeValue{
-						S: aws.String(ChildKey),
-					},
-				},
-			},
-		})
-		pathKey += key[i]
+// notLinkCondition returns the condition that the row written is not a
+// link.
+func (t *Tree) notLinkCondition() expression.ConditionBuilder {
+	cond := expression.AttributeNotExists(expression.Name(t.LinkAttribute))
+	if t.LinkAttribute != t.SpecialCharacter {
+		cond = cond.And(expression.AttributeNotExists(expression.Name(t.SpecialCharacter)))
+	}
+	return cond
+}
+
+// putRequests returns the write requests needed to store item at key.
+func (t *Tree) putRequests(key []string, item Storable) ([]*dynamodb.WriteRequest, error) {
+	if err := t.ValidateKey(key); err != nil {
+		return nil, err
+	}
+	if err := t.checkTenant(key); err != nil {
+		return nil, err
 	}
 
-	attributes, err := item.MarshalDynamoDB()
+	marshalled, err := item.MarshalDynamoDB()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// The caller's map is copied, rather than modified, to add the key.
+	attributes := make(map[string]*dynamodb.AttributeValue, len(t.DefaultAttributes)+len(marshalled)+2)
+	for name, value := range t.DefaultAttributes {
+		attributes[name] = value
+	}
+	for name, value := range marshalled {
+		attributes[name] = value
 	}
+
+	pathKey, writeRequests := t.directoryRequests(key)
 	attributes["Key"] = &dynamodb.AttributeValue{
 		S: aws.String(pathKey),
 	}
@@ -113,79 +555,210 @@ func (t *Tree) Put(key []string, item Storable) error {
 		S: aws.String(t.SpecialCharacter),
 	}
 
-	for fieldName := range attributes {
-		if strings.HasPrefix(fieldName, t.SpecialCharacter) {
-			return ErrReservedCharacterInAttribute
-		}
+	if err := t.checkAttributes(attributes); err != nil {
+		return nil, err
+	}
+	if name, expires := t.objectExpiry(key, t.now()); expires != nil && attributes[name] == nil {
+		attributes[name] = expires
 	}
+	t.addChecksum(attributes)
 
+	versions, err := t.versionRequests(key, attributes)
+	if err != nil {
+		return nil, err
+	}
+	writeRequests = append(writeRequests, versions...)
 	writeRequests = append(writeRequests, &dynamodb.WriteRequest{
 		PutRequest: &dynamodb.PutRequest{
 			Item: attributes,
 		},
 	})
+	t.stampTenant(key, writeRequests)
+
+	return t.tenantRows(writeRequests), nil
+}
 
-	for i := 0; i < len(writeRequests); i += 25 {
-		n := i + 25
-		if n >= len(writeRequests) {
-			n = len(writeRequests)
+// checkAttributes returns a *ReservedCharacterError if the name of any of
+// attributes begins with the special character, or a
+// *ReservedAttributeError if it is the LinkAttribute or, for a tree with a
+// KeySchema or an ItemType, one of the attributes that the mapping writes,
+// which would otherwise replace the object's value or the row's key.
+func (t *Tree) checkAttributes(attributes map[string]*dynamodb.AttributeValue) error {
+	for fieldName := range attributes {
+		if strings.HasPrefix(fieldName, t.SpecialCharacter) {
+			return &ReservedCharacterError{Attribute: fieldName}
 		}
-		input := &dynamodb.BatchWriteItemInput{
-			RequestItems: map[string][]*dynamodb.WriteRequest{
-				t.TableName: writeRequests[i:n],
-			},
+		if fieldName == t.LinkAttribute {
+			return &ReservedAttributeError{Attribute: fieldName}
 		}
-
-		for {
-			output, err := t.DB.BatchWriteItem(input)
-			if err != nil {
-				return err
-			}
-			if len(output.UnprocessedItems) == 0 {
-				break
+		if km := t.keyMapper; km != nil && fieldName != "Key" && fieldName != "Child" {
+			switch fieldName {
+			case km.m.hashAttribute(), km.m.rangeAttribute(), km.m.TypeAttribute:
+				return &ReservedAttributeError{Attribute: fieldName}
 			}
-			input.RequestItems = output.UnprocessedItems
 		}
 	}
-
 	return nil
 }
 
-// PutLink creates a new link key that is a symbolic link to target.
-func (t *Tree) PutLink(key []string, target []string) error {
-	t.initOnce.Do(t.init)
-	writeRequests := []*dynamodb.WriteRequest{}
+// linkTarget returns the encoded target of the link whose row is row, or
+// false if row is not a link.
+func (t *Tree) linkTarget(row map[string]*dynamodb.AttributeValue) (string, bool) {
+	if v, ok := row[t.LinkAttribute]; ok {
+		return aws.StringValue(v.S), true
+	}
+	if v, ok := row[t.SpecialCharacter]; ok {
+		return aws.StringValue(v.S), true
+	}
+	return "", false
+}
+
+// directoryRequests returns the key of the row that holds the object at
+// key, and the write requests for each of the directory rows that lead
+// to it. The returned slice has room for the caller to append the
+// object's own row. The caller must have validated key.
+//
+// Put is on the hot path of most applications, so this function takes
+// some care to allocate as little as possible: each directory key is a
+// prefix of the object's key, so the object's key is built once and the
+// directory keys are sliced from it, and the rows are allocated together
+// rather than one at a time.
+func (t *Tree) directoryRequests(key []string) (string, []*dynamodb.WriteRequest) {
+	pathKey := t.EncodeKey(key)
 
-	pathKey := ""
-	for i := 0; i < len(key); i++ {
-		if strings.Contains(key[i], t.SpecialCharacter) {
-			return ErrReservedCharacterInKey
+	strs := make([]string, 2*len(key))
+	values := make([]dynamodb.AttributeValue, 2*len(key))
+	putRequests := make([]dynamodb.PutRequest, len(key))
+	requests := make([]dynamodb.WriteRequest, len(key))
+	writeRequests := make([]*dynamodb.WriteRequest, len(key), len(key)+1)
+	offset := 0
+	for i := range key {
+		offset += len(t.SpecialCharacter)
+		strs[2*i] = pathKey[:offset]
+		strs[2*i+1] = t.encodePart(key[i])
+		offset += len(strs[2*i+1])
+		values[2*i].S = &strs[2*i]
+		values[2*i+1].S = &strs[2*i+1]
+		putRequests[i].Item = map[string]*dynamodb.AttributeValue{
+			"Key":   &values[2*i],
+			"Child": &values[2*i+1],
 		}
-		pathKey += t.SpecialCharacter
-		ChildKey := key[i]
+		requests[i].PutRequest = &putRequests[i]
+		writeRequests[i] = &requests[i]
+	}
+	return pathKey, writeRequests
+}
 
-		writeRequests = append(writeRequests, &dynamodb.WriteRequest{
-			PutRequest: &dynamodb.PutRequest{
-				Item: map[string]*dynamodb.AttributeValue{
-					"Key": &dynamodb.AttributeValue{
-						S: aws.String(pathKey),
-					},
-					"Child": &dynamodb.AttributeValue{
-						S: aws.String(ChildKey),
-					},
-				},
-			},
-		})
-		pathKey += key[i]
+// PutLink creates a new link key that is a symbolic link to target.
+func (t *Tree) PutLink(key []string, target []string, opts ...Option) (err error) {
+	defer annotateError(&err, "PutLink", key)
+	o, cancel := t.startCall("PutLink", key, &err, opts)
+	defer cancel()
+	t.initOnce.Do(t.init)
+	if err := t.checkCapability("PutLink", key, o); err != nil {
+		return err
+	}
+	if err := t.checkCapabilityReach(key, target, false, o); err != nil {
+		return err
+	}
+	key, target, err = t.transformLink(key, target)
+	if err != nil {
+		return err
+	}
+	defer t.lockKey(key)()
+	writeRequests, err := t.putLinkRequests(key, target)
+	if err != nil {
+		return err
+	}
+	writeRequests = t.flatten(key, writeRequests, o)
+	guard, err := t.checkGuards(key, guardCreate, o)
+	if err != nil {
+		return err
+	}
+	if err := t.ready(); err != nil {
+		return err
+	}
+	if err := t.addLinkCopy(writeRequests, target, o); err != nil {
+		return err
 	}
+	err = t.write(writeRequests, o)
+	t.countWrites(writeRequests, err, nil, o)
+	return guardFailed(guard, key, err)
+}
 
-	for _, targetKeyPart := range target {
-		if strings.Contains(targetKeyPart, t.SpecialCharacter) {
-			return ErrReservedCharacterInKey
+// PutLinkIfAbsent creates a new link key that is a symbolic link to target,
+// but only if nothing is stored at key. If a link already exists at key this
+// function returns ErrAlreadyExists, or a *GuardError if an append-only
+// WriteGuard protects key. If an object is stored at key it returns
+// ErrNotLink. In each case the tree is not modified.
+func (t *Tree) PutLinkIfAbsent(key []string, target []string, opts ...Option) (err error) {
+	defer annotateError(&err, "PutLinkIfAbsent", key)
+	opts = append(opts[:len(opts):len(opts)],
+		WithCondition(expression.AttributeNotExists(expression.Name("Key"))))
+	o, cancel := t.startCall("PutLinkIfAbsent", key, &err, opts)
+	defer cancel()
+	if err := t.ready(); err != nil {
+		return err
+	}
+	if err := t.checkCapability("PutLinkIfAbsent", key, o); err != nil {
+		return err
+	}
+	if err := t.checkCapabilityReach(key, target, false, o); err != nil {
+		return err
+	}
+	key, target, err = t.transformLink(key, target)
+	if err != nil {
+		return err
+	}
+	defer t.lockKey(key)()
+	writeRequests, err := t.putLinkRequests(key, target)
+	if err != nil {
+		return err
+	}
+	writeRequests = t.flatten(key, writeRequests, o)
+	guard, err := t.checkGuards(key, guardCreate, o)
+	if err != nil {
+		return err
+	}
+	if err := t.addLinkCopy(writeRequests, target, o); err != nil {
+		return err
+	}
+
+	err = t.write(writeRequests, o)
+	t.countWrites(writeRequests, err, nil, o)
+	if errors.Is(err, ErrConditionFailed) {
+		existing, getErr := t.getRow(t.EncodeKey(key), o)
+		if getErr != nil {
+			return getErr
+		}
+		if existing != nil {
+			if _, isLink := t.linkTarget(existing); !isLink {
+				return ErrNotLink
+			}
+		}
+		if guard == nil {
+			return ErrAlreadyExists
 		}
 	}
+	return guardFailed(guard, key, err)
+}
+
+// putLinkRequests returns the write requests needed to store a link
+// from key to target.
+func (t *Tree) putLinkRequests(key []string, target []string) ([]*dynamodb.WriteRequest, error) {
+	if err := t.ValidateKey(key); err != nil {
+		return nil, err
+	}
+	if err := t.ValidateKey(target); err != nil {
+		return nil, err
+	}
+	if err := t.checkTenant(key); err != nil {
+		return nil, err
+	}
 
-	targetPathKey := t.SpecialCharacter + strings.Join(target, t.SpecialCharacter)
+	pathKey, writeRequests := t.directoryRequests(key)
+
+	targetPathKey := t.EncodeKey(target)
 	attributes := map[string]*dynamodb.AttributeValue{
 		"Key": &dynamodb.AttributeValue{
 			S: aws.String(pathKey),
@@ -193,175 +766,427 @@ func (t *Tree) PutLink(key []string, target []string) error {
 		"Child": &dynamodb.AttributeValue{
 			S: aws.String(t.SpecialCharacter),
 		},
-		t.SpecialCharacter: &dynamodb.AttributeValue{
+		t.LinkAttribute: &dynamodb.AttributeValue{
 			S: aws.String(targetPathKey),
 		},
 	}
 
+	if t.MaintainBacklinks {
+		writeRequests = append(writeRequests, &dynamodb.WriteRequest{
+			PutRequest: &dynamodb.PutRequest{
+				Item: t.backlinkRow(key, targetPathKey),
+			},
+		})
+	}
+	versions, err := t.versionRequests(key, attributes)
+	if err != nil {
+		return nil, err
+	}
+	writeRequests = append(writeRequests, versions...)
 	writeRequests = append(writeRequests, &dynamodb.WriteRequest{
 		PutRequest: &dynamodb.PutRequest{
 			Item: attributes,
 		},
 	})
+	t.stampTenant(key, writeRequests)
 
-	for i := 0; i < len(writeRequests); i += 25 {
-		n := i + 25
-		if n >= len(writeRequests) {
-			n = len(writeRequests)
-		}
-
-		input := &dynamodb.BatchWriteItemInput{
-			RequestItems: map[string][]*dynamodb.WriteRequest{
-				t.TableName: writeRequests[i:n],
-			},
-		}
-
-		for {
-			output, err := t.DB.BatchWriteItem(input)
-			if err != nil {
-				return err
-			}
-			if len(output.UnprocessedItems) == 0 {
-				break
-			}
-			input.RequestItems = output.UnprocessedItems
-		}
-	}
-
-	return nil
+	return t.tenantRows(writeRequests), nil
 }
 
 // Get fetches an item from the tree. `ob` points to an object
 // which will be filled in with the properties of the object.
 //
-// If the object does not exist, this function returns ErrNotFound.
+// If the object does not exist, this function returns ErrNotFound. If
+// Tree.ReportDirectories is set and there are keys below the missing
+// object, the error is an *IsDirectoryError, which also matches
+// ErrNotFound.
 //
 // If the object at "key" is a symbolic link, this function follows
-// the link and returns the object referenced by the link target.
-func (t *Tree) Get(key []string, ob Storable) error {
-	t.initOnce.Do(t.init)
-	pathKey := t.SpecialCharacter + strings.Join(key, t.SpecialCharacter)
-
-	resp, err := t.DB.GetItem(&dynamodb.GetItemInput{
-		TableName: aws.String(t.TableName),
-		Key: map[string]*dynamodb.AttributeValue{
-			"Key": &dynamodb.AttributeValue{
-				S: aws.String(pathKey),
-			},
-			"Child": &dynamodb.AttributeValue{
-				S: aws.String(t.SpecialCharacter),
-			},
-		},
-	})
+// the link and returns the object referenced by the link target. If
+// more than MaxLinkHops links must be followed, this function returns
+// a *LinkHopsError.
+func (t *Tree) Get(key []string, ob Storable, opts ...Option) (err error) {
+	defer annotateError(&err, "Get", key)
+	o, cancel := t.startCall("Get", key, &err, opts)
+	defer cancel()
+	if err := t.ready(); err != nil {
+		return err
+	}
+	if err := t.checkCapability("Get", key, o); err != nil {
+		return err
+	}
+	key, err = t.transformKey(key)
 	if err != nil {
 		return err
 	}
-	if len(resp.Item) == 0 {
-		return ErrNotFound
+	row, err := t.resolve(key, o)
+	if err != nil {
+		return err
+	}
+	if err := t.verifyChecksum(row); err != nil {
+		return err
 	}
+	return ob.UnmarshalDynamoDB(row)
+}
 
-	// If the object is a symlink, then return it recursively
-	if linkTarget, ok := resp.Item[t.SpecialCharacter]; ok {
-		key := strings.Split(*linkTarget.S, t.SpecialCharacter)[1:]
-		return t.Get(key, ob)
+// resolve returns the row of the object at key, following symbolic links.
+func (t *Tree) resolve(key []string, o *callOptions) (map[string]*dynamodb.AttributeValue, error) {
+	var chain []string
+	pathKey := t.EncodeKey(key)
+	for hops := 0; ; hops++ {
+		row, err := t.getRow(pathKey, o)
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			return nil, t.notFound(pathKey, o)
+		}
+
+		// If the object is a symlink, then fetch the link target, unless
+		// the link holds a copy of it
+		linkTarget, ok := t.linkTarget(row)
+		if ok {
+			if err := t.checkCapabilityReach(key, t.DecodeKey(linkTarget), true, o); err != nil {
+				return nil, err
+			}
+		}
+		if linkCopy, isCopy := row[t.linkCopyAttribute()]; ok && isCopy && o.consistent() == nil {
+			return linkCopy.M, nil
+		}
+		if !ok {
+			if hops > 0 && o != nil && o.atomicLinks && len(t.legacySchema) == 0 {
+				return t.resolveAtomically(key, append(chain, pathKey), o)
+			}
+			return row, nil
+		}
+		if hops >= t.MaxLinkHops {
+			return nil, &LinkHopsError{Key: key, MaxLinkHops: t.MaxLinkHops}
+		}
+		chain = append(chain, pathKey)
+		pathKey = linkTarget
 	}
+}
 
-	if err := ob.UnmarshalDynamoDB(resp.Item); err != nil {
+// notFound returns the error for a Get that finds nothing stored under
+// pathKey: ErrIsDirectory if ReportDirectories is set and there are keys
+// below it, and otherwise ErrNotFound.
+func (t *Tree) notFound(pathKey string, o *callOptions) error {
+	if !t.ReportDirectories {
+		return ErrNotFound
+	}
+	hasChildren, err := t.hasChildren(t.dirKey(t.DecodeKey(pathKey)), o)
+	if err != nil {
 		return err
 	}
-
-	return nil
+	if hasChildren {
+		return ErrIsDirectory
+	}
+	return ErrNotFound
 }
 
 // GetLink returns the target of the link at "key". If the key does
 // not exist, this function returns ErrNotFound. If the key exists but
 // is not a link, this functino returns ErrNotLink.
-func (t *Tree) GetLink(key []string) ([]string, error) {
-	t.initOnce.Do(t.init)
-	pathKey := t.SpecialCharacter + strings.Join(key, t.SpecialCharacter)
-
-	resp, err := t.DB.GetItem(&dynamodb.GetItemInput{
-		TableName: aws.String(t.TableName),
-		Key: map[string]*dynamodb.AttributeValue{
-			"Key": &dynamodb.AttributeValue{
-				S: aws.String(pathKey),
-			},
-			"Child": &dynamodb.AttributeValue{
-				S: aws.String(t.SpecialCharacter),
-			},
-		},
-	})
+func (t *Tree) GetLink(key []string, opts ...Option) (target []string, err error) {
+	defer annotateError(&err, "GetLink", key)
+	o, cancel := t.startCall("GetLink", key, &err, opts)
+	defer cancel()
+	if err := t.ready(); err != nil {
+		return nil, err
+	}
+	if err := t.checkCapability("GetLink", key, o); err != nil {
+		return nil, err
+	}
+	key, err = t.transformKey(key)
 	if err != nil {
 		return nil, err
 	}
-	if len(resp.Item) == 0 {
+	row, err := t.getRow(t.EncodeKey(key), o)
+	if err != nil {
+		return nil, err
+	}
+	if row == nil {
 		return nil, ErrNotFound
 	}
 
-	linkTarget, ok := resp.Item[t.SpecialCharacter]
+	linkTarget, ok := t.linkTarget(row)
 	if !ok {
 		return nil, ErrNotLink
 	}
 
-	key = strings.Split(*linkTarget.S, t.SpecialCharacter)[1:]
-	return key, nil
+	return t.DecodeKey(linkTarget), nil
 }
 
 // List enumerates the immediate child objects at keyPrefix. For each item
 // found it calls itemFunc with the name of the item. If an error occurs,
 // itemFunc is called with a non-nill error. itemFunc should return true to
 // continue iterating or false to stop.
-func (t *Tree) List(keyPrefix []string, itemFunc func(string, error) bool) {
-	t.initOnce.Do(t.init)
-	pathKey := t.SpecialCharacter + strings.Join(keyPrefix, t.SpecialCharacter) + t.SpecialCharacter
+//
+// A listing can be limited using MaxItems or CapacityBudget, and resumed
+// using ReturnCursor and StartAfter. ListVerified leaves out the entries
+// of children that no longer exist.
+func (t *Tree) List(keyPrefix []string, itemFunc func(string, error) bool, opts ...Option) {
+	fn, prefix := itemFunc, keyPrefix
+	var listErr error
+	itemFunc = func(item string, err error) bool {
+		if err != nil && listErr == nil {
+			listErr = err
+		}
+		return fn(item, wrapError("List", prefix, err))
+	}
+	o, cancel := t.startCall("List", prefix, &listErr, opts)
+	defer cancel()
+	if err := t.ready(); err != nil {
+		itemFunc("", err)
+		return
+	}
+	if err := t.checkCapability("List", prefix, o); err != nil {
+		itemFunc("", err)
+		return
+	}
+	keyPrefix, err := t.transformKey(keyPrefix)
+	if err != nil {
+		itemFunc("", err)
+		return
+	}
+	t.list(keyPrefix, itemFunc, o)
+}
+
+// list is List for a prefix that has already been transformed.
+func (t *Tree) list(keyPrefix []string, itemFunc func(string, error) bool, o *callOptions) {
+	t.listChildren(keyPrefix, "", itemFunc, o)
+}
 
-	err := t.DB.QueryPages(&dynamodb.QueryInput{
+// listChildren lists the children of keyPrefix whose stored names begin
+// with namePrefix.
+func (t *Tree) listChildren(keyPrefix []string, namePrefix string, itemFunc func(string, error) bool, o *callOptions) {
+	pathKey := t.dirKey(keyPrefix)
+	input := &dynamodb.QueryInput{
 		TableName:              aws.String(t.TableName),
+		ConsistentRead:         o.consistent(),
 		KeyConditionExpression: aws.String("#K = :key"),
 		ExpressionAttributeNames: map[string]*string{
 			"#K": aws.String("Key"),
 		},
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":key": &dynamodb.AttributeValue{S: aws.String(pathKey)},
-		}}, func(p *dynamodb.QueryOutput, lastPage bool) (shouldContinue bool) {
+		}}
+	if namePrefix != "" {
+		input.KeyConditionExpression = aws.String("#K = :key AND begins_with(#C, :prefix)")
+		input.ExpressionAttributeNames["#C"] = aws.String("Child")
+		input.ExpressionAttributeValues[":prefix"] = &dynamodb.AttributeValue{S: aws.String(namePrefix)}
+	}
+
+	b := o.listBudget()
+	if b.startAfter != "" {
+		startAfter, err := decodeCursor(b.startAfter)
+		if err != nil {
+			itemFunc("", err)
+			return
+		}
+		input.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
+			"Key":   &dynamodb.AttributeValue{S: aws.String(pathKey)},
+			"Child": &dynamodb.AttributeValue{S: aws.String(startAfter)},
+		}
+	}
+	if b.maxItems > 0 {
+		input.Limit = aws.Int64(int64(b.maxItems))
+	}
+	if b.capacity > 0 {
+		input.ReturnConsumedCapacity = aws.String(dynamodb.ReturnConsumedCapacityTotal)
+	}
+
+	// last is the stored name of the last child passed to itemFunc, from
+	// which a cursor resumes.
+	last, items, consumed, stopped := "", 0, 0.0, false
+	var verifyErr error
+	err := t.DB.QueryPagesWithContext(o.context(), input, func(p *dynamodb.QueryOutput, lastPage bool) (shouldContinue bool) {
+		names := make([]string, 0, len(p.Items))
 		for _, attrs := range p.Items {
-			shouldContinue := itemFunc(*attrs["Child"].S, nil)
-			if !shouldContinue {
+			// The partition of the root directory also holds the rows of
+			// the object stored at the root.
+			if !strings.HasPrefix(*attrs["Child"].S, t.SpecialCharacter) {
+				names = append(names, *attrs["Child"].S)
+			}
+		}
+		if o != nil && o.listVerified && len(names) > 0 {
+			names, verifyErr = t.verifyChildren(keyPrefix, names, o)
+			if verifyErr != nil {
 				return false
 			}
 		}
+		for _, name := range names {
+			last = name
+			items++
+			shouldContinue := itemFunc(t.decodePart(name), nil)
+			if !shouldContinue || (b.maxItems > 0 && items >= b.maxItems) {
+				stopped = true
+				return false
+			}
+		}
+		if p.ConsumedCapacity != nil {
+			consumed += aws.Float64Value(p.ConsumedCapacity.CapacityUnits)
+		}
+		if b.capacity > 0 && consumed >= b.capacity && !lastPage {
+			stopped = true
+			if key := p.LastEvaluatedKey["Child"]; key != nil {
+				last = aws.StringValue(key.S)
+			}
+			return false
+		}
 		return true
-	})
+	}, o.request()...)
+	if err == nil {
+		err = verifyErr
+	}
+	t.noteChildren("List", keyPrefix, items)
 
 	if err != nil {
 		itemFunc("", err)
+		return
+	}
+	if b.cursor != nil {
+		*b.cursor = ""
+		if stopped {
+			*b.cursor = encodeCursor(last)
+		}
 	}
 }
 
-// Delete removes the item given by "key" from the tree and it's
-// containing directory. It does not remove directories that may
-// have been created automatically when the object was created.
-func (t *Tree) Delete(key []string) error {
-	t.initOnce.Do(t.init)
+// Delete removes the item given by "key" from the tree and, unless there
+// are keys below it, its entry in the containing directory. It does not
+// remove directories that may have been created automatically when the
+// object was created. If Tree.MaintainBacklinks is set and the item is a
+// link, its backlink is removed too.
+//
+// Deleting a key with a single part removes its entry from the root
+// directory. Deleting the empty key removes the object stored at the
+// root of the tree, which has no containing directory.
+func (t *Tree) Delete(key []string, opts ...Option) (err error) {
+	defer annotateError(&err, "Delete", key)
+	o, cancel := t.startCall("Delete", key, &err, opts)
+	defer cancel()
+	if err := t.ready(); err != nil {
+		return err
+	}
+	if err := t.checkCapability("Delete", key, o); err != nil {
+		return err
+	}
+	key, err = t.transformKey(key)
+	if err != nil {
+		return err
+	}
+	defer t.lockKey(key)()
+	writeRequests, err := t.deleteRequests(key)
+	if err != nil {
+		return err
+	}
+	if _, err := t.checkGuards(key, guardDelete, o); err != nil {
+		return err
+	}
+	if o.failIfReferenced {
+		references, err := t.references(t.EncodeKey(key), o)
+		if err != nil {
+			return err
+		}
+		if len(references) > 0 {
+			return &ReferencesError{References: references}
+		}
+	}
+	if len(key) > 0 {
+		// The directory entry is kept so that the keys below remain
+		// reachable by List and Walk.
+		hasChildren, err := t.hasChildren(t.dirKey(key), o)
+		if err != nil {
+			return err
+		}
+		if hasChildren {
+			writeRequests = writeRequests[1:]
+		}
+	}
+	writeRequests = t.tenantRows(writeRequests)
+	var leafKinds map[RowID]NodeKind
+	if t.MaintainBacklinks || o.writeCounts != nil {
+		row, err := t.getRow(t.EncodeKey(key), o)
+		if err != nil {
+			return err
+		}
+		leafKinds = map[RowID]NodeKind{rowID(writeRequests[len(writeRequests)-1]): t.nodeInfo(key, row).Kind}
+		if target, isLink := t.linkTarget(row); isLink && t.MaintainBacklinks {
+			leaf := len(writeRequests) - 1
+			writeRequests = append(writeRequests[:leaf:leaf], &dynamodb.WriteRequest{
+				DeleteRequest: &dynamodb.DeleteRequest{
+					Key: t.backlinkRow(key, target),
+				},
+			}, writeRequests[leaf])
+		}
+	}
+	err = t.write(writeRequests, o)
+	t.countWrites(writeRequests, err, leafKinds, o)
+	if err != nil {
+		return err
+	}
+	return t.refreshLinkCopies(key, nil, o)
+}
+
+// hasChildren returns true if the directory stored under pathKey has any
+// entries.
+func (t *Tree) hasChildren(pathKey string, o *callOptions) (bool, error) {
+	output, err := t.DB.QueryWithContext(o.context(), &dynamodb.QueryInput{
+		TableName:              aws.String(t.TableName),
+		ConsistentRead:         o.consistent(),
+		KeyConditionExpression: aws.String("#K = :key"),
+		ExpressionAttributeNames: map[string]*string{
+			"#K": aws.String("Key"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":key": &dynamodb.AttributeValue{S: aws.String(pathKey)},
+		},
+		Limit: aws.Int64(1),
+	}, o.request()...)
+	if err != nil {
+		return false, err
+	}
+	return len(output.Items) > 0, nil
+}
+
+// deleteRequests returns the write requests needed to remove the item
+// at key and its entry in the containing directory.
+func (t *Tree) deleteRequests(key []string) ([]*dynamodb.WriteRequest, error) {
+	if err := t.ValidateKey(key); err != nil {
+		return nil, err
+	}
+	if err := t.checkTenant(key); err != nil {
+		return nil, err
+	}
+
 	writeRequests := []*dynamodb.WriteRequest{}
 
-	pathKey := t.SpecialCharacter + strings.Join(key[:len(key)-1], t.SpecialCharacter) + t.SpecialCharacter
-	ChildKey := key[len(key)-1]
+	if len(key) > 0 {
+		pathKey := t.dirKey(key[:len(key)-1])
+		ChildKey := t.childName(key)
 
-	writeRequests = append(writeRequests, &dynamodb.WriteRequest{
-		DeleteRequest: &dynamodb.DeleteRequest{
-			Key: map[string]*dynamodb.AttributeValue{
-				"Key": &dynamodb.AttributeValue{
-					S: aws.String(pathKey),
-				},
-				"Child": &dynamodb.AttributeValue{
-					S: aws.String(ChildKey),
+		writeRequests = append(writeRequests, &dynamodb.WriteRequest{
+			DeleteRequest: &dynamodb.DeleteRequest{
+				Key: map[string]*dynamodb.AttributeValue{
+					"Key": &dynamodb.AttributeValue{
+						S: aws.String(pathKey),
+					},
+					"Child": &dynamodb.AttributeValue{
+						S: aws.String(ChildKey),
+					},
 				},
 			},
-		},
-	})
+		})
+	}
+
+	versions, err := t.versionRequests(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	writeRequests = append(writeRequests, versions...)
 
-	pathKey += key[len(key)-1]
+	pathKey := t.EncodeKey(key)
 	writeRequests = append(writeRequests, &dynamodb.WriteRequest{
 		DeleteRequest: &dynamodb.DeleteRequest{
 			Key: map[string]*dynamodb.AttributeValue{
@@ -375,21 +1200,408 @@ func (t *Tree) Delete(key []string) error {
 		},
 	})
 
-	input := &dynamodb.BatchWriteItemInput{
-		RequestItems: map[string][]*dynamodb.WriteRequest{
-			t.TableName: writeRequests,
-		},
+	return writeRequests, nil
+}
+
+// getRow returns the object or link row whose Key is pathKey, or nil if
+// the row does not exist.
+func (t *Tree) getRow(pathKey string, o *callOptions) (map[string]*dynamodb.AttributeValue, error) {
+	if len(t.legacySchema) == 0 {
+		return t.getItem(pathKey, t.SpecialCharacter, o)
+	}
+
+	// The table is being migrated, so the row may be in an older layout,
+	// possibly under another key.
+	key, child := pathKey, t.SpecialCharacter
+	for i := len(t.legacySchema); i >= 0; i-- {
+		if i < len(t.legacySchema) {
+			if t.legacySchema[i].legacyKey == nil {
+				continue
+			}
+			key, child = t.legacySchema[i].legacyKey(t, key, child)
+		}
+		row, err := t.getItem(key, child, o)
+		if err != nil {
+			return nil, err
+		}
+		if row != nil {
+			return t.upgradeRow(row)
+		}
+	}
+	return nil, nil
+}
+
+// getItem returns the row with the given Key and Child, or nil if there is
+// none.
+func (t *Tree) getItem(key, child string, o *callOptions) (map[string]*dynamodb.AttributeValue, error) {
+	return t.backend().GetRow(o.context(), key, child, aws.BoolValue(o.consistent()), o.request()...)
+}
+
+// write issues writeRequests, the last of which must be the row of the
+// object (or link) itself. If o has a condition, asks for the old item or
+// asks for the object to be written first, the object's row is written
+// first, on its own, so that nothing is written if the condition is not
+// met. If o asks for a rollback, the object's row is written last, on its
+// own, once the directory entries have been written.
+func (t *Tree) write(writeRequests []*dynamodb.WriteRequest, o *callOptions) error {
+	leaf := writeRequests[len(writeRequests)-1]
+	switch {
+	case o != nil && o.rollBack && leaf.PutRequest != nil:
+		return t.writeLeafLast(writeRequests, o)
+	case o == nil || (o.condition == nil && o.oldItem == nil && !o.leafFirst):
+		var err error
+		if t.canCoalesce(writeRequests, o) {
+			err = t.coalescedWrite(writeRequests, o)
+		} else {
+			err = t.batchWrite(writeRequests, o)
+		}
+		if e, ok := err.(*MultiRowError); ok {
+			e.LeafWritten = true
+			for _, id := range e.Failed {
+				if id == rowID(leaf) {
+					e.LeafWritten = false
+				}
+			}
+		}
+		return err
+	}
+
+	if err := t.writeLeaf(leaf, o); err != nil {
+		return err
+	}
+	err := t.batchWrite(writeRequests[:len(writeRequests)-1], o)
+	if e, ok := err.(*MultiRowError); ok {
+		e.Written = append([]RowID{rowID(leaf)}, e.Written...)
+		e.LeafWritten = true
 	}
+	return err
+}
+
+// writeLeafLast writes the directory entries of writeRequests and then
+// the object's row. If that fails, the directory entries left without
+// anything stored at or below them are removed again.
+func (t *Tree) writeLeafLast(writeRequests []*dynamodb.WriteRequest, o *callOptions) error {
+	leaf := writeRequests[len(writeRequests)-1]
+	var e *MultiRowError
+	if err := t.batchWrite(writeRequests[:len(writeRequests)-1], o); err != nil {
+		e = err.(*MultiRowError)
+		e.Failed = append(e.Failed, rowID(leaf))
+	} else if err := t.writeLeaf(leaf, o); err != nil {
+		e = &MultiRowError{Failed: []RowID{rowID(leaf)}, Err: err}
+		for _, writeRequest := range writeRequests[:len(writeRequests)-1] {
+			e.Written = append(e.Written, rowID(writeRequest))
+		}
+	} else {
+		return nil
+	}
+
+	if err := t.rollBack(e, o); err != nil {
+		return e
+	}
+	// Nothing that the write changed remains, so an unmet condition is
+	// reported as it would be had nothing been written.
+	if _, ok := e.Err.(*ConditionFailedError); ok {
+		return e.Err
+	}
+	return e
+}
 
-	for {
-		output, err := t.DB.BatchWriteItem(input)
+// rollBack removes the directory entries in e.Written, deepest first,
+// until it finds one that something is stored at or below. Each row that
+// is removed is moved from e.Written to e.RolledBack.
+func (t *Tree) rollBack(e *MultiRowError, o *callOptions) error {
+	consistent := &callOptions{ctx: o.context(), requestOptions: o.request(), consistentRead: true}
+	for len(e.Written) > 0 {
+		id := e.Written[len(e.Written)-1]
+
+		// The entry's key is encoded as the concatenation of the entry's
+		// hash and range keys.
+		pathKey := id.Key + id.Child
+		row, err := t.getRow(pathKey, consistent)
+		if err != nil {
+			return err
+		}
+		if row != nil {
+			return nil
+		}
+		hasChildren, err := t.hasChildren(pathKey+t.SpecialCharacter, consistent)
 		if err != nil {
 			return err
 		}
-		if len(output.UnprocessedItems) == 0 {
-			break
+		if hasChildren {
+			return nil
+		}
+
+		_, err = t.DB.DeleteItemWithContext(o.context(), &dynamodb.DeleteItemInput{
+			TableName: aws.String(t.TableName),
+			Key: map[string]*dynamodb.AttributeValue{
+				"Key":   &dynamodb.AttributeValue{S: aws.String(id.Key)},
+				"Child": &dynamodb.AttributeValue{S: aws.String(id.Child)},
+			},
+		}, o.request()...)
+		if err != nil {
+			return err
 		}
-		input.RequestItems = output.UnprocessedItems
+		e.Written = e.Written[:len(e.Written)-1]
+		e.RolledBack = append(e.RolledBack, id)
 	}
 	return nil
 }
+
+// writeLeaf writes row, the row of an object or link, on its own, applying
+// the condition of o and filling in the old item o asks for.
+func (t *Tree) writeLeaf(row *dynamodb.WriteRequest, o *callOptions) error {
+	var condition *string
+	var names map[string]*string
+	var values map[string]*dynamodb.AttributeValue
+	if o.condition != nil {
+		expr, err := expression.NewBuilder().WithCondition(*o.condition).Build()
+		if err != nil {
+			return err
+		}
+		condition, names, values = expr.Condition(), expr.Names(), expr.Values()
+	}
+	var returnValues *string
+	if o.oldItem != nil {
+		returnValues = aws.String(dynamodb.ReturnValueAllOld)
+	}
+
+	var err error
+	var old map[string]*dynamodb.AttributeValue
+	if row.PutRequest != nil {
+		var output *dynamodb.PutItemOutput
+		output, err = t.DB.PutItemWithContext(o.context(), &dynamodb.PutItemInput{
+			TableName:                 aws.String(t.TableName),
+			Item:                      row.PutRequest.Item,
+			ConditionExpression:       condition,
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+			ReturnValues:              returnValues,
+		}, o.request()...)
+		if err == nil {
+			old = output.Attributes
+		}
+	} else {
+		var output *dynamodb.DeleteItemOutput
+		output, err = t.DB.DeleteItemWithContext(o.context(), &dynamodb.DeleteItemInput{
+			TableName:                 aws.String(t.TableName),
+			Key:                       row.DeleteRequest.Key,
+			ConditionExpression:       condition,
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+			ReturnValues:              returnValues,
+		}, o.request()...)
+		if err == nil {
+			old = output.Attributes
+		}
+	}
+	if isConditionalCheckFailed(err) {
+		return &ConditionFailedError{Err: err}
+	}
+	if err != nil {
+		return err
+	}
+
+	if o.oldItemFound != nil {
+		*o.oldItemFound = len(old) > 0
+	}
+	if o.oldItem != nil && len(old) > 0 {
+		if err := o.oldItem.UnmarshalDynamoDB(old); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batchWrite issues writeRequests in batches of 25, the maximum that
+// BatchWriteItem allows, retrying any unprocessed items.
+func (t *Tree) batchWrite(writeRequests []*dynamodb.WriteRequest, o *callOptions) error {
+	requests := t.newPlan(writeRequests).Requests
+	for i, input := range requests {
+		batch := input.RequestItems[t.TableName]
+		pending := batch
+		o.startBatches(len(requests) - i)
+		for attempt := 0; ; attempt++ {
+			unprocessed, err := t.backend().WriteRows(o.context(), pending, o.request()...)
+			if err != nil {
+				return newMultiRowError(writeRequests[:i*25], batch,
+					pending, writeRequests[i*25+len(batch):], err)
+			}
+			o.wroteRows(len(pending) - len(unprocessed))
+			if len(unprocessed) == 0 {
+				break
+			}
+			pending = unprocessed
+
+			// Items go unprocessed when the table is short of capacity, so
+			// they are sent again only after a delay.
+			select {
+			case <-t.clock().After(t.unprocessedDelay(attempt)):
+			case <-o.context().Done():
+				return newMultiRowError(writeRequests[:i*25], batch,
+					pending, writeRequests[i*25+len(batch):], o.context().Err())
+			}
+		}
+	}
+	return nil
+}
+
+// The delay before the items that a batch write left unprocessed are sent
+// again doubles with each attempt from unprocessedMinDelay, up to
+// unprocessedMaxDelay.
+const (
+	unprocessedMinDelay = 50 * time.Millisecond
+	unprocessedMaxDelay = 5 * time.Second
+)
+
+// unprocessedDelay returns the delay before the unprocessed items of a
+// batch write are sent again for the attempt'th time, counting from zero.
+// A random half of the delay is left out, so that writers that are short
+// of capacity together do not retry together.
+func (t *Tree) unprocessedDelay(attempt int) time.Duration {
+	d := unprocessedMaxDelay
+	if attempt < 10 && unprocessedMinDelay<<uint(attempt) < d {
+		d = unprocessedMinDelay << uint(attempt)
+	}
+	return d/2 + time.Duration(t.randomIntn(int(d/2)))
+}
+
+// newMultiRowError returns a MultiRowError for a batch write that stopped
+// with err while writing batch, after the rows before it had been written
+// and before the rows after it were attempted. Of the rows of batch, those
+// still pending had not been written.
+func newMultiRowError(before, batch, pending, after []*dynamodb.WriteRequest, err error) *MultiRowError {
+	e := &MultiRowError{Err: err}
+	isPending := map[RowID]bool{}
+	for _, writeRequest := range pending {
+		isPending[rowID(writeRequest)] = true
+	}
+	for _, writeRequest := range before {
+		e.Written = append(e.Written, rowID(writeRequest))
+	}
+	for _, writeRequest := range batch {
+		if id := rowID(writeRequest); isPending[id] {
+			e.Failed = append(e.Failed, id)
+		} else {
+			e.Written = append(e.Written, id)
+		}
+	}
+	for _, writeRequest := range after {
+		e.Failed = append(e.Failed, rowID(writeRequest))
+	}
+	return e
+}
+
+// rowID returns the row that writeRequest writes or removes.
+func rowID(writeRequest *dynamodb.WriteRequest) RowID {
+	if writeRequest.PutRequest != nil {
+		row := writeRequest.PutRequest.Item
+		return RowID{Key: aws.StringValue(row["Key"].S), Child: aws.StringValue(row["Child"].S)}
+	}
+	row := writeRequest.DeleteRequest.Key
+	return RowID{Key: aws.StringValue(row["Key"].S), Child: aws.StringValue(row["Child"].S)}
+}
+
+// annotateError replaces *errp with the typed error that wrapError returns
+// for it. It is deferred by the public methods, so key is the key given by
+// the caller rather than the result of KeyTransformer.
+func annotateError(errp *error, op string, key []string) {
+	*errp = wrapError(op, key, *errp)
+}
+
+// wrapError returns err, as returned by the operation op on key, as one of
+// the typed errors that records the operation and key.
+func wrapError(op string, key []string, err error) error {
+	switch err {
+	case nil:
+		return nil
+	case ErrNotFound:
+		return &NotFoundError{Op: op, Key: key}
+	case ErrNotLink:
+		return &NotLinkError{Op: op, Key: key}
+	case ErrIsDirectory:
+		return &IsDirectoryError{Op: op, Key: key}
+	case ErrIsLink:
+		return &IsLinkError{Op: op, Key: key}
+	case ErrChecksumMismatch:
+		return &ChecksumError{Op: op, Key: key}
+	case ErrAlreadyExists:
+		return &AlreadyExistsError{Op: op, Key: key}
+	}
+	switch e := err.(type) {
+	case *ConditionFailedError:
+		if e.Op == "" {
+			e.Op, e.Key = op, key
+		}
+	case *MultiRowError:
+		if e.Op == "" {
+			e.Op, e.Key = op, key
+			e.Err = wrapError(op, key, e.Err)
+		}
+	case *ThrottledError:
+		if e.Op == "" {
+			e.Op, e.Key = op, key
+		}
+	case *ReferencesError:
+		if e.Op == "" {
+			e.Op, e.Key = op, key
+		}
+	case *AlreadyExistsError:
+		if e.Op == "" {
+			e.Op = op
+		}
+	case *ReservedAttributeError:
+		if e.Op == "" {
+			e.Op, e.Key = op, key
+		}
+	case *CapabilityError:
+		if e.Op == "" {
+			e.Op = op
+		}
+	case *KeyDepthError:
+		if e.Op == "" {
+			e.Op = op
+		}
+	case *LinkHopsError:
+		if e.Op == "" {
+			e.Op = op
+		}
+	case *ReservedCharacterError:
+		if e.Op == "" {
+			e.Op = op
+		}
+	case *GuardError:
+		if e.Op == "" {
+			e.Op = op
+			e.Err = wrapError(op, key, e.Err)
+		}
+	case awserr.Error:
+		if isThrottling(err) {
+			return &ThrottledError{Op: op, Key: key, Err: err, RetryAfter: client.DefaultRetryerMinThrottleDelay}
+		}
+	}
+	return err
+}
+
+// isThrottling returns true if err is the error DynamoDB returns when it
+// rejects a request because of throttling.
+func isThrottling(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		switch awsErr.Code() {
+		case dynamodb.ErrCodeProvisionedThroughputExceededException,
+			dynamodb.ErrCodeRequestLimitExceeded,
+			"ThrottlingException":
+			return true
+		}
+	}
+	return false
+}
+
+// isConditionalCheckFailed returns true if err indicates that the condition
+// attached to a write was not met.
+func isConditionalCheckFailed(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+	}
+	return false
+}
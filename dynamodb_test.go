@@ -1,11 +1,20 @@
 package dynamotree
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
 	"github.com/dchest/uniuri"
 	. "gopkg.in/check.v1"
 )
@@ -46,7 +55,7 @@ func (a AccountT) MarshalDynamoDB() (map[string]*dynamodb.AttributeValue, error)
 
 func (suite *StoreImplTest) TestBasics(c *C) {
 	tableName := uniuri.New()
-	db := dynamodb.New(session.New(), fakeDynamodbServer.Config)
+	db := dynamodb.New(session.New(), testConfig)
 	s := &Tree{TableName: tableName, DB: db}
 	err := s.CreateTable()
 	c.Assert(err, IsNil)
@@ -96,10 +105,10 @@ func (suite *StoreImplTest) TestBasics(c *C) {
 	c.Assert(err, IsNil)
 
 	err = s.Get([]string{"Accounts", "12345"}, nil)
-	c.Assert(err, DeepEquals, ErrNotFound)
+	c.Assert(err, ErrorIs, ErrNotFound)
 
 	err = s.Get([]string{"AccountsByEmail", "alice@example.com"}, nil)
-	c.Assert(err, DeepEquals, ErrNotFound)
+	c.Assert(err, ErrorIs, ErrNotFound)
 
 	items = []string{}
 	s.List([]string{"Accounts"}, func(item string, err error) bool {
@@ -132,7 +141,7 @@ func (suite *StoreImplTest) TestBasics(c *C) {
 
 func (suite *StoreImplTest) TestReservedCharacters(c *C) {
 	tableName := uniuri.New()
-	db := dynamodb.New(session.New(), fakeDynamodbServer.Config)
+	db := dynamodb.New(session.New(), testConfig)
 	s := &Tree{
 		TableName:        tableName,
 		DB:               db,
@@ -147,11 +156,11 @@ func (suite *StoreImplTest) TestReservedCharacters(c *C) {
 		Email: "alice@example.com",
 	}
 	err = s.Put([]string{"Accounts", "12X345"}, &v)
-	c.Assert(err, Equals, ErrReservedCharacterInKey)
+	c.Assert(err, ErrorIs, ErrReservedCharacterInKey)
 
 	v.Xfoo = "cannot be set"
 	err = s.Put([]string{"Accounts", "12345"}, &v)
-	c.Assert(err, Equals, ErrReservedCharacterInAttribute)
+	c.Assert(err, ErrorIs, ErrReservedCharacterInAttribute)
 
 	v.Xfoo = ""
 	v.FooXBar = "can be set"
@@ -159,15 +168,15 @@ func (suite *StoreImplTest) TestReservedCharacters(c *C) {
 	c.Assert(err, IsNil)
 
 	err = s.PutLink([]string{"AccountsXEmail", "alice@example.com"}, []string{"Accounts", "12345"})
-	c.Assert(err, Equals, ErrReservedCharacterInKey)
+	c.Assert(err, ErrorIs, ErrReservedCharacterInKey)
 
 	err = s.PutLink([]string{"AccountsByEmail", "alice@example.com"}, []string{"AccountsX", "12345"})
-	c.Assert(err, Equals, ErrReservedCharacterInKey)
+	c.Assert(err, ErrorIs, ErrReservedCharacterInKey)
 }
 
 func (suite *StoreImplTest) TestDoubleCreate(c *C) {
 	tableName := uniuri.New()
-	db := dynamodb.New(session.New(), fakeDynamodbServer.Config)
+	db := dynamodb.New(session.New(), testConfig)
 	s := &Tree{TableName: tableName, DB: db}
 	err := s.CreateTable()
 	c.Assert(err, IsNil)
@@ -180,7 +189,7 @@ func (suite *StoreImplTest) TestDoubleCreate(c *C) {
 
 func (suite *StoreImplTest) TestMarshalFails(c *C) {
 	tableName := uniuri.New()
-	db := dynamodb.New(session.New(), fakeDynamodbServer.Config)
+	db := dynamodb.New(session.New(), testConfig)
 	s := &Tree{TableName: tableName, DB: db}
 	err := s.CreateTable()
 	c.Assert(err, IsNil)
@@ -191,13 +200,27 @@ func (suite *StoreImplTest) TestMarshalFails(c *C) {
 		Email:             "alice@example.com",
 		MarshalFailPlease: true,
 	}
-	_ = s.Put([]string{"Accounts", "12345"}, &v)
-	//c.Assert(err, ErrorMatches, "could not grob the frob")
+	// Nothing is sent, not even the requests that a new tree makes to
+	// check the table.
+	requests := 0
+	countingDB := dynamodb.New(session.New(), testConfig)
+	countingDB.Handlers.Send.PushBack(func(r *request.Request) { requests++ })
+	s2 := &Tree{TableName: tableName, DB: countingDB, VerifySchema: true}
+	err = s2.Put([]string{"Accounts", "12345"}, &v)
+	c.Assert(err, ErrorMatches, "could not grob the frob")
+	c.Assert(requests, Equals, 0)
+
+	var v2 AccountT
+	c.Assert(s.Get([]string{"Accounts", "12345"}, &v2), ErrorIs, ErrNotFound)
+	s.List(nil, func(item string, err error) bool {
+		c.Errorf("unexpected item %q, %v", item, err)
+		return true
+	})
 }
 
 func (suite *StoreImplTest) TestUnmarshalFails(c *C) {
 	tableName := uniuri.New()
-	db := dynamodb.New(session.New(), fakeDynamodbServer.Config)
+	db := dynamodb.New(session.New(), testConfig)
 	s := &Tree{TableName: tableName, DB: db}
 	err := s.CreateTable()
 	c.Assert(err, IsNil)
@@ -218,7 +241,7 @@ func (suite *StoreImplTest) TestUnmarshalFails(c *C) {
 
 func (suite *StoreImplTest) TestLinkFailures(c *C) {
 	tableName := uniuri.New()
-	db := dynamodb.New(session.New(), fakeDynamodbServer.Config)
+	db := dynamodb.New(session.New(), testConfig)
 	s := &Tree{TableName: tableName, DB: db}
 	err := s.CreateTable()
 	c.Assert(err, IsNil)
@@ -236,15 +259,180 @@ func (suite *StoreImplTest) TestLinkFailures(c *C) {
 	c.Assert(err, IsNil)
 
 	_, err = s.GetLink([]string{"AccountsByEmail", "missing"})
-	c.Assert(err, Equals, ErrNotFound)
+	c.Assert(err, ErrorIs, ErrNotFound)
 
 	_, err = s.GetLink([]string{"Accounts", "12345"})
-	c.Assert(err, Equals, ErrNotLink)
+	c.Assert(err, ErrorIs, ErrNotLink)
+}
+
+func (suite *StoreImplTest) TestLinkAttribute(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	legacy := &Tree{TableName: tableName, DB: db}
+	c.Assert(legacy.CreateTable(), IsNil)
+
+	v := AccountT{ID: "12345", Name: "alice"}
+	c.Assert(legacy.Put([]string{"Accounts", "12345"}, &v), IsNil)
+	c.Assert(legacy.PutLink([]string{"Old", "alice"}, []string{"Accounts", "12345"}), IsNil)
+
+	s := &Tree{TableName: tableName, DB: db, LinkAttribute: "__link"}
+	c.Assert(s.PutLink([]string{"New", "alice"}, []string{"Old", "alice"}), IsNil)
+
+	row, err := s.getRow(s.EncodeKey([]string{"New", "alice"}), nil)
+	c.Assert(err, IsNil)
+	c.Assert(*row["__link"].S, Equals, "¦Old¦alice")
+	_, ok := row["¦"]
+	c.Assert(ok, Equals, false)
+
+	// links written either way are followed
+	var v2 AccountT
+	c.Assert(s.Get([]string{"New", "alice"}, &v2), IsNil)
+	c.Assert(v2, DeepEquals, v)
+	target, err := s.GetLink([]string{"Old", "alice"})
+	c.Assert(err, IsNil)
+	c.Assert(target, DeepEquals, []string{"Accounts", "12345"})
+	c.Assert(s.PutLinkIfAbsent([]string{"Old", "alice"}, []string{"Accounts", "12345"}), ErrorIs, ErrAlreadyExists)
+	c.Assert(s.PutLinkIfAbsent([]string{"Old", "alice"}, []string{"Accounts", "12345"}), ErrorMatches, `PutLinkIfAbsent "Old/alice": already exists`)
+	c.Assert(s.PutLinkIfAbsent([]string{"Accounts", "12345"}, []string{"Old", "alice"}), ErrorIs, ErrNotLink)
+
+	err = s.Put([]string{"Accounts", "54321"}, rawItem{
+		"__link": &dynamodb.AttributeValue{S: aws.String("x")},
+	})
+	c.Assert(err, ErrorIs, ErrReservedAttribute)
+	c.Assert(err, ErrorMatches, `Put "Accounts/54321": attribute name "__link" is reserved`)
+}
+
+func (suite *StoreImplTest) TestTypedErrors(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+
+	var v AccountT
+	err := s.Get([]string{"Accounts", "missing"}, &v)
+	var notFound *NotFoundError
+	c.Assert(errors.As(err, &notFound), Equals, true)
+	c.Assert(notFound.Op, Equals, "Get")
+	c.Assert(notFound.Key, DeepEquals, []string{"Accounts", "missing"})
+	c.Assert(err, ErrorIs, ErrNotFound)
+	c.Assert(err, ErrorMatches, `Get "Accounts/missing": not found`)
+
+	err = s.Put([]string{"Accounts", "12¦3"}, &AccountT{ID: "123"})
+	var reserved *ReservedCharacterError
+	c.Assert(errors.As(err, &reserved), Equals, true)
+	c.Assert(reserved.Op, Equals, "Put")
+	c.Assert(reserved.Component, Equals, "12¦3")
+	c.Assert(err, ErrorMatches, `Put: part "12¦3" of key "Accounts/12¦3" contains the reserved character`)
+	c.Assert(err, ErrorIs, ErrReservedCharacterInKey)
+
+	c.Assert(s.Put([]string{"Accounts", "123"}, &AccountT{ID: "123"}), IsNil)
+	err = s.Put([]string{"Accounts", "123"}, &AccountT{ID: "123"},
+		WithCondition(expression.AttributeNotExists(expression.Name("Key"))))
+	var failed *ConditionFailedError
+	c.Assert(errors.As(err, &failed), Equals, true)
+	c.Assert(failed.Op, Equals, "Put")
+	c.Assert(failed.Key, DeepEquals, []string{"Accounts", "123"})
+	var awsErr awserr.Error
+	c.Assert(errors.As(err, &awsErr), Equals, true)
+	c.Assert(awsErr.Code(), Equals, dynamodb.ErrCodeConditionalCheckFailedException)
+
+}
+
+func (suite *StoreImplTest) TestMultiRowError(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+
+	// 30 directory rows and the object's row make two batches
+	key := []string{}
+	for i := 0; i < 30; i++ {
+		key = append(key, fmt.Sprintf("%d", i))
+	}
+	v := AccountT{ID: "12345"}
+	err := s.Put(key, &v, throttleRequest("BatchWriteItem", 2))
+	multiRowErr, ok := err.(*MultiRowError)
+	c.Assert(ok, Equals, true)
+	c.Assert(multiRowErr.Op, Equals, "Put")
+	c.Assert(multiRowErr.Key, DeepEquals, key)
+	c.Assert(multiRowErr.Written, HasLen, 25)
+	c.Assert(multiRowErr.Failed, HasLen, 6)
+	c.Assert(multiRowErr.Failed[5], Equals, RowID{Key: s.EncodeKey(key), Child: "¦"})
+	c.Assert(multiRowErr.LeafWritten, Equals, false)
+	c.Assert(err, ErrorIs, ErrThrottled)
+
+	var v2 AccountT
+	c.Assert(s.Get(key, &v2), ErrorIs, ErrNotFound)
+	c.Assert(s.Put(key, &v), IsNil)
+
+	// When the old item is returned, the object's row is written first.
+	err = s.Put(key, &v, ReturnOldItem(&v2, nil), throttleRequest("BatchWriteItem", 1))
+	multiRowErr, ok = err.(*MultiRowError)
+	c.Assert(ok, Equals, true)
+	c.Assert(multiRowErr.Written, DeepEquals, []RowID{{Key: s.EncodeKey(key), Child: "¦"}})
+	c.Assert(multiRowErr.Failed, HasLen, 30)
+	c.Assert(multiRowErr.LeafWritten, Equals, true)
+}
+
+func (suite *StoreImplTest) TestRootKeys(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+
+	listRoot := func() []string {
+		items := []string{}
+		s.List(nil, func(item string, err error) bool {
+			c.Assert(err, IsNil)
+			items = append(items, item)
+			return true
+		})
+		return items
+	}
+
+	global := AccountT{ID: "global"}
+	c.Assert(s.Put([]string{}, &global), IsNil)
+	c.Assert(listRoot(), DeepEquals, []string{})
+
+	v := AccountT{ID: "12345", Name: "alice"}
+	c.Assert(s.Put([]string{"Accounts"}, &v), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &v), IsNil)
+	row, err := s.getItem("¦", "Accounts", nil)
+	c.Assert(err, IsNil)
+	c.Assert(row, NotNil)
+	row, err = s.getItem("¦Accounts", "¦", nil)
+	c.Assert(err, IsNil)
+	c.Assert(*row["ID"].S, Equals, "12345")
+
+	var v2 AccountT
+	c.Assert(s.Get(nil, &v2), IsNil)
+	c.Assert(v2, DeepEquals, global)
+	c.Assert(s.Get([]string{"Accounts"}, &v2), IsNil)
+	c.Assert(v2, DeepEquals, v)
+	c.Assert(listRoot(), DeepEquals, []string{"Accounts"})
+
+	keys := [][]string{}
+	s.Walk(nil, func(key []string, err error) bool {
+		c.Assert(err, IsNil)
+		keys = append(keys, key)
+		return true
+	})
+	c.Assert(keys, DeepEquals, [][]string{{"Accounts"}, {"Accounts", "12345"}})
+
+	// The directory entry is kept while there are keys below it.
+	c.Assert(s.Delete([]string{"Accounts"}), IsNil)
+	c.Assert(s.Get([]string{"Accounts"}, &v2), ErrorIs, ErrNotFound)
+	c.Assert(listRoot(), DeepEquals, []string{"Accounts"})
+	c.Assert(s.Delete([]string{"Accounts", "12345"}), IsNil)
+	c.Assert(s.Delete([]string{"Accounts"}), IsNil)
+	c.Assert(listRoot(), DeepEquals, []string{})
+
+	c.Assert(s.Delete(nil), IsNil)
+	c.Assert(s.Get(nil, &v2), ErrorIs, ErrNotFound)
 }
 
 func (suite *StoreImplTest) TestListAbort(c *C) {
 	tableName := uniuri.New()
-	db := dynamodb.New(session.New(), fakeDynamodbServer.Config)
+	db := dynamodb.New(session.New(), testConfig)
 	s := &Tree{TableName: tableName, DB: db}
 	err := s.CreateTable()
 	c.Assert(err, IsNil)
@@ -269,7 +457,7 @@ func (suite *StoreImplTest) TestListAbort(c *C) {
 
 func (suite *StoreImplTest) TestLongPath(c *C) {
 	tableName := uniuri.New()
-	db := dynamodb.New(session.New(), fakeDynamodbServer.Config)
+	db := dynamodb.New(session.New(), testConfig)
 	s := &Tree{TableName: tableName, DB: db}
 	err := s.CreateTable()
 	c.Assert(err, IsNil)
@@ -299,3 +487,334 @@ func (suite *StoreImplTest) TestLongPath(c *C) {
 	err = s.Delete(key2)
 	c.Assert(err, IsNil)
 }
+
+func (suite *StoreImplTest) TestLimits(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db, MaxKeyDepth: 3, MaxLinkHops: 1}
+	err := s.CreateTable()
+	c.Assert(err, IsNil)
+
+	v := AccountT{
+		ID:    "12345",
+		Name:  "alice",
+		Email: "alice@example.com",
+	}
+	err = s.Put([]string{"a", "b", "c", "d"}, &v)
+	c.Assert(err, DeepEquals, &KeyDepthError{Op: "Put", Key: []string{"a", "b", "c", "d"}, MaxKeyDepth: 3})
+	err = s.PutLink([]string{"a", "b", "c", "d"}, []string{"Accounts", "12345"})
+	c.Assert(err, FitsTypeOf, &KeyDepthError{})
+	err = s.PutLink([]string{"Link"}, []string{"a", "b", "c", "d"})
+	c.Assert(err, FitsTypeOf, &KeyDepthError{})
+
+	err = s.Put([]string{"a", "b", "c"}, &v)
+	c.Assert(err, IsNil)
+	err = s.PutLink([]string{"Link1"}, []string{"a", "b", "c"})
+	c.Assert(err, IsNil)
+	err = s.PutLink([]string{"Link2"}, []string{"Link1"})
+	c.Assert(err, IsNil)
+
+	var v2 AccountT
+	err = s.Get([]string{"Link1"}, &v2)
+	c.Assert(err, IsNil)
+	c.Assert(v2, DeepEquals, v)
+
+	err = s.Get([]string{"Link2"}, &v2)
+	c.Assert(err, DeepEquals, &LinkHopsError{Op: "Get", Key: []string{"Link2"}, MaxLinkHops: 1})
+}
+
+func (suite *StoreImplTest) TestLinkCycle(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	err := s.CreateTable()
+	c.Assert(err, IsNil)
+
+	err = s.PutLink([]string{"a"}, []string{"b"})
+	c.Assert(err, IsNil)
+	err = s.PutLink([]string{"b"}, []string{"a"})
+	c.Assert(err, IsNil)
+
+	var v AccountT
+	err = s.Get([]string{"a"}, &v)
+	c.Assert(err, DeepEquals, &LinkHopsError{Op: "Get", Key: []string{"a"}, MaxLinkHops: DefaultMaxLinkHops})
+}
+
+func (suite *StoreImplTest) TestDeleteTopLevel(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	err := s.CreateTable()
+	c.Assert(err, IsNil)
+
+	v := AccountT{
+		ID:    "12345",
+		Name:  "alice",
+		Email: "alice@example.com",
+	}
+	err = s.Put([]string{"Singleton"}, &v)
+	c.Assert(err, IsNil)
+
+	items := []string{}
+	s.List(nil, func(item string, err error) bool {
+		c.Assert(err, IsNil)
+		items = append(items, item)
+		return true
+	})
+	c.Assert(items, DeepEquals, []string{"Singleton"})
+
+	err = s.Delete([]string{"Singleton"})
+	c.Assert(err, IsNil)
+
+	err = s.Get([]string{"Singleton"}, &v)
+	c.Assert(err, ErrorIs, ErrNotFound)
+
+	items = []string{}
+	s.List(nil, func(item string, err error) bool {
+		c.Assert(err, IsNil)
+		items = append(items, item)
+		return true
+	})
+	c.Assert(items, DeepEquals, []string{})
+
+	err = s.Delete(nil)
+	c.Assert(err, IsNil)
+	err = s.Delete([]string{})
+	c.Assert(err, IsNil)
+
+	err = s.Delete([]string{"Accounts", ""})
+	c.Assert(err, Equals, ErrEmptyKeyPart)
+	err = s.Delete([]string{"Acc¦ounts"})
+	c.Assert(err, ErrorIs, ErrReservedCharacterInKey)
+}
+
+func (suite *StoreImplTest) TestPutLinkIfAbsent(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	err := s.CreateTable()
+	c.Assert(err, IsNil)
+
+	v := AccountT{
+		ID:    "12345",
+		Name:  "alice",
+		Email: "alice@example.com",
+	}
+	err = s.Put([]string{"Accounts", "12345"}, &v)
+	c.Assert(err, IsNil)
+
+	err = s.PutLinkIfAbsent([]string{"AccountsByEmail", "alice@example.com"}, []string{"Accounts", "12345"})
+	c.Assert(err, IsNil)
+
+	link, err := s.GetLink([]string{"AccountsByEmail", "alice@example.com"})
+	c.Assert(err, IsNil)
+	c.Assert(link, DeepEquals, []string{"Accounts", "12345"})
+
+	items := []string{}
+	s.List([]string{"AccountsByEmail"}, func(item string, err error) bool {
+		c.Assert(err, IsNil)
+		items = append(items, item)
+		return true
+	})
+	c.Assert(items, DeepEquals, []string{"alice@example.com"})
+
+	err = s.PutLinkIfAbsent([]string{"AccountsByEmail", "alice@example.com"}, []string{"Accounts", "6789"})
+	c.Assert(err, ErrorIs, ErrAlreadyExists)
+
+	link, err = s.GetLink([]string{"AccountsByEmail", "alice@example.com"})
+	c.Assert(err, IsNil)
+	c.Assert(link, DeepEquals, []string{"Accounts", "12345"})
+
+	err = s.PutLinkIfAbsent([]string{"Accounts", "12345"}, []string{"Accounts", "6789"})
+	c.Assert(err, ErrorIs, ErrNotLink)
+
+	var v2 AccountT
+	err = s.Get([]string{"Accounts", "12345"}, &v2)
+	c.Assert(err, IsNil)
+	c.Assert(v2, DeepEquals, v)
+}
+
+func (suite *StoreImplTest) TestReportDirectories(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db, ReportDirectories: true}
+	c.Assert(s.CreateTable(), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &AccountT{ID: "12345"}), IsNil)
+	c.Assert(s.PutLink([]string{"All"}, []string{"Accounts"}), IsNil)
+
+	var v AccountT
+	err := s.Get([]string{"Accounts"}, &v)
+	var isDirectory *IsDirectoryError
+	c.Assert(errors.As(err, &isDirectory), Equals, true)
+	c.Assert(isDirectory.Key, DeepEquals, []string{"Accounts"})
+	c.Assert(err, ErrorIs, ErrIsDirectory)
+	c.Assert(err, ErrorIs, ErrNotFound)
+	c.Assert(err, ErrorMatches, `Get "Accounts": is a directory`)
+
+	c.Assert(s.Get([]string{"All"}, &v), ErrorIs, ErrIsDirectory)
+	c.Assert(s.Get(nil, &v), ErrorIs, ErrIsDirectory)
+
+	err = s.Get([]string{"Accounts", "12345", "missing"}, &v)
+	c.Assert(err, ErrorIs, ErrNotFound)
+	c.Assert(errors.Is(err, ErrIsDirectory), Equals, false)
+
+	s.ReportDirectories = false
+	err = s.Get([]string{"Accounts"}, &v)
+	c.Assert(err, ErrorIs, ErrNotFound)
+	c.Assert(errors.Is(err, ErrIsDirectory), Equals, false)
+}
+
+func (suite *StoreImplTest) TestProtectLinks(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db, LinkAttribute: "__link"}
+	c.Assert(s.CreateTable(), IsNil)
+	alice := AccountT{ID: "12345", Name: "alice"}
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &alice), IsNil)
+	c.Assert(s.PutLink([]string{"Current"}, []string{"Accounts", "12345"}), IsNil)
+
+	// By default the link is replaced, leaving none of its attributes
+	bob := AccountT{ID: "6789", Name: "bob"}
+	c.Assert(s.Put([]string{"Current"}, &bob), IsNil)
+	_, err := s.GetLink([]string{"Current"})
+	c.Assert(err, ErrorIs, ErrNotLink)
+	row, err := s.getRow(s.EncodeKey([]string{"Current"}), nil)
+	c.Assert(err, IsNil)
+	c.Assert(row["__link"], IsNil)
+
+	// With ProtectLinks, Put fails unless ReplaceLink is given
+	s.ProtectLinks = true
+	c.Assert(s.PutLink([]string{"Current"}, []string{"Accounts", "12345"}), IsNil)
+	err = s.Put([]string{"Current"}, &bob)
+	var isLink *IsLinkError
+	c.Assert(errors.As(err, &isLink), Equals, true)
+	c.Assert(isLink.Key, DeepEquals, []string{"Current"})
+	c.Assert(err, ErrorIs, ErrIsLink)
+	c.Assert(err, ErrorMatches, `Put "Current": is a link`)
+	target, err := s.GetLink([]string{"Current"})
+	c.Assert(err, IsNil)
+	c.Assert(target, DeepEquals, []string{"Accounts", "12345"})
+
+	// Objects may still be replaced, and conditions still apply
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &alice), IsNil)
+	err = s.Put([]string{"Accounts", "12345"}, &alice,
+		WithCondition(expression.AttributeNotExists(expression.Name("Key"))))
+	c.Assert(err, ErrorIs, ErrConditionFailed)
+
+	c.Assert(s.Put([]string{"Current"}, &bob, ReplaceLink()), IsNil)
+	var v AccountT
+	c.Assert(s.Get([]string{"Current"}, &v), IsNil)
+	c.Assert(v, DeepEquals, bob)
+}
+
+func (suite *StoreImplTest) TestDefaultAttributes(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db, LinkAttribute: "__link",
+		DefaultAttributes: map[string]*dynamodb.AttributeValue{
+			"Environment": &dynamodb.AttributeValue{S: aws.String("prod")},
+			"Name":        &dynamodb.AttributeValue{S: aws.String("nobody")},
+		}}
+	c.Assert(s.CreateTable(), IsNil)
+
+	// The object's own attributes take precedence over the defaults
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &AccountT{ID: "12345", Name: "alice"}), IsNil)
+	row, err := s.getRow(s.EncodeKey([]string{"Accounts", "12345"}), nil)
+	c.Assert(err, IsNil)
+	c.Assert(aws.StringValue(row["Environment"].S), Equals, "prod")
+	c.Assert(aws.StringValue(row["Name"].S), Equals, "alice")
+
+	// Links are not objects, so they are not given the defaults
+	c.Assert(s.PutLink([]string{"Current"}, []string{"Accounts", "12345"}), IsNil)
+	row, err = s.getRow(s.EncodeKey([]string{"Current"}), nil)
+	c.Assert(err, IsNil)
+	c.Assert(row["Environment"], IsNil)
+
+	s.DefaultAttributes["__link"] = &dynamodb.AttributeValue{S: aws.String("x")}
+	err = s.Put([]string{"Accounts", "6789"}, &AccountT{ID: "6789"})
+	c.Assert(err, ErrorIs, ErrReservedAttribute)
+}
+
+// countingTransport counts the HTTP requests that it makes.
+type countingTransport struct {
+	requests int64
+}
+
+func (t *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	atomic.AddInt64(&t.requests, 1)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func (suite *StoreImplTest) TestClientConfiguration(c *C) {
+	transport := &countingTransport{}
+	s := &Tree{TableName: uniuri.New(),
+		Endpoint:    aws.StringValue(testConfig.Endpoint),
+		Region:      "eu-west-1",
+		HTTPClient:  &http.Client{Transport: transport},
+		Credentials: testConfig.Credentials,
+	}
+	c.Assert(s.CreateTable(), IsNil)
+	c.Assert(s.DB, NotNil)
+	c.Assert(aws.StringValue(s.DB.Config.Region), Equals, "eu-west-1")
+	v := AccountT{ID: "12345", Name: "alice"}
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &v), IsNil)
+	c.Assert(s.Get([]string{"Accounts", "12345"}, &v), IsNil)
+	c.Assert(transport.requests > 2, Equals, true)
+
+	// The handlers of DB remain when its configuration is overridden
+	db := dynamodb.New(session.New(), testConfig)
+	sent := 0
+	db.Handlers.Send.PushFront(func(r *request.Request) { sent++ })
+	s2 := &Tree{TableName: s.TableName, DB: db, HTTPClient: &http.Client{Transport: transport}}
+	before := transport.requests
+	c.Assert(s2.Get([]string{"Accounts", "12345"}, &v), IsNil)
+	c.Assert(sent > 0, Equals, true)
+	c.Assert(transport.requests > before, Equals, true)
+}
+
+func (suite *StoreImplTest) TestBatchWriteUnprocessed(c *C) {
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db, Clock: clock}
+	c.Assert(s.CreateTable(), IsNil)
+
+	// The table leaves the items of the first three requests unprocessed,
+	// although it writes them.
+	sends := 0
+	unprocessed := WithRequestOptions(func(r *request.Request) {
+		if r.Operation.Name != "BatchWriteItem" {
+			return
+		}
+		r.Handlers.Unmarshal.PushBack(func(r *request.Request) {
+			sends++
+			if sends <= 3 {
+				r.Data.(*dynamodb.BatchWriteItemOutput).UnprocessedItems = r.Params.(*dynamodb.BatchWriteItemInput).RequestItems
+			}
+		})
+	})
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &AccountT{ID: "12345"}, unprocessed), IsNil)
+	c.Assert(sends, Equals, 4)
+
+	// The delays of 50ms, 100ms and 200ms are each cut by up to half.
+	c.Assert(clock.waited >= 175*time.Millisecond, Equals, true, Commentf("%s", clock.waited))
+	c.Assert(clock.waited < 350*time.Millisecond, Equals, true, Commentf("%s", clock.waited))
+	for attempt := 0; attempt < 20; attempt++ {
+		c.Assert(s.unprocessedDelay(attempt) <= unprocessedMaxDelay, Equals, true)
+	}
+
+	// A call whose context is done meanwhile stops waiting.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sends = 0
+	s.Clock = blockedClock{}
+	err := s.Put([]string{"Accounts", "6789"}, &AccountT{ID: "6789"}, unprocessed, WithContext(ctx),
+		WithRequestOptions(func(r *request.Request) { r.Handlers.Complete.PushBack(func(*request.Request) { cancel() }) }))
+	c.Assert(err, ErrorIs, context.Canceled)
+	c.Assert(sends, Equals, 1)
+}
+
+// blockedClock is a Clock whose time never passes.
+type blockedClock struct{}
+
+func (blockedClock) Now() time.Time                       { return time.Time{} }
+func (blockedClock) After(time.Duration) <-chan time.Time { return nil }
@@ -0,0 +1,68 @@
+// Package dynamotreetest provides helpers for testing code that uses
+// dynamotree.
+package dynamotreetest
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/crewjam/dynamotree"
+	"github.com/crewjam/dynamotree/internal/dynamodblocal"
+)
+
+// EndpointEnv is the name of an environment variable that, if set, gives
+// the URL of a running DynamoDB Local instance for StartLocal to use.
+const EndpointEnv = dynamodblocal.EndpointEnv
+
+// Local is an instance of DynamoDB Local, which behaves much more like
+// the real DynamoDB than an in-process fake does.
+type Local struct {
+	// Endpoint is the URL of the instance.
+	Endpoint string
+
+	// Config is the configuration needed to talk to the instance.
+	Config *aws.Config
+
+	server *dynamodblocal.Server
+}
+
+// StartLocal returns a DynamoDB Local instance. If the environment variable
+// named by EndpointEnv is set, the instance at that URL is used. Otherwise
+// an instance listening on localhost:8000 is used if there is one, and
+// failing that a new "amazon/dynamodb-local" docker container is started.
+// Call Close to stop any container that was started.
+func StartLocal() (*Local, error) {
+	server, err := dynamodblocal.Start()
+	if err != nil {
+		return nil, err
+	}
+	return &Local{
+		Endpoint: server.Endpoint,
+		Config:   server.Config,
+		server:   server,
+	}, nil
+}
+
+// Close stops the docker container if StartLocal created one.
+func (l *Local) Close() error {
+	return l.server.Close()
+}
+
+// NewTree returns a Tree backed by a newly created table with a unique name.
+func (l *Local) NewTree() (*dynamotree.Tree, error) {
+	tableName := make([]byte, 8)
+	if _, err := rand.Read(tableName); err != nil {
+		return nil, err
+	}
+	t := &dynamotree.Tree{
+		TableName: fmt.Sprintf("dynamotreetest-%x", tableName),
+		DB:        dynamodb.New(session.New(), l.Config),
+	}
+	if err := t.CreateTable(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
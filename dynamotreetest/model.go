@@ -0,0 +1,283 @@
+package dynamotreetest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/crewjam/dynamotree"
+)
+
+// Model is an in-memory reference implementation of how a Tree stores
+// objects and links, with the default settings, as observed through Put,
+// PutLink, Delete, Get, GetLink and List. RunModel compares a Tree with a
+// Model, so that changes to the tree, and other implementations of its
+// semantics, can be checked against it.
+//
+// As in a Tree, each key written is recorded in the directory of its
+// parent, and the directory entries that lead to it are recorded in
+// theirs. Delete removes the key's own entry only if nothing is recorded
+// below the key, and leaves the entries of its parents in place.
+type Model struct {
+	// MaxLinkHops is the maximum number of links that Get follows. If
+	// zero, dynamotree.DefaultMaxLinkHops is used.
+	MaxLinkHops int
+
+	rows    map[string]modelRow
+	entries map[string]map[string]bool
+}
+
+// modelRow is an object, or a link if target is not nil.
+type modelRow struct {
+	item   map[string]*dynamodb.AttributeValue
+	target []string
+}
+
+// NewModel returns an empty model.
+func NewModel() *Model {
+	return &Model{rows: map[string]modelRow{}, entries: map[string]map[string]bool{}}
+}
+
+// modelPath returns the key of the model's maps for key.
+func modelPath(key []string) string {
+	return strings.Join(key, "\x00")
+}
+
+// addEntries records key in the directory of its parent, and so on up to
+// the root.
+func (m *Model) addEntries(key []string) {
+	for i := range key {
+		dir := modelPath(key[:i])
+		if m.entries[dir] == nil {
+			m.entries[dir] = map[string]bool{}
+		}
+		m.entries[dir][key[i]] = true
+	}
+}
+
+// Put stores item at key.
+func (m *Model) Put(key []string, item map[string]*dynamodb.AttributeValue) {
+	m.addEntries(key)
+	m.rows[modelPath(key)] = modelRow{item: item}
+}
+
+// PutLink stores a link at key to target.
+func (m *Model) PutLink(key, target []string) {
+	m.addEntries(key)
+	m.rows[modelPath(key)] = modelRow{target: append([]string{}, target...)}
+}
+
+// Delete removes what is stored at key.
+func (m *Model) Delete(key []string) {
+	delete(m.rows, modelPath(key))
+	if len(key) > 0 && len(m.entries[modelPath(key)]) == 0 {
+		delete(m.entries[modelPath(key[:len(key)-1])], key[len(key)-1])
+	}
+}
+
+// Get returns the object stored at key, following links, or
+// dynamotree.ErrNotFound, or a *dynamotree.LinkHopsError if more than
+// MaxLinkHops links must be followed.
+func (m *Model) Get(key []string) (map[string]*dynamodb.AttributeValue, error) {
+	maxLinkHops := m.MaxLinkHops
+	if maxLinkHops == 0 {
+		maxLinkHops = dynamotree.DefaultMaxLinkHops
+	}
+	path := key
+	for hops := 0; ; hops++ {
+		row, ok := m.rows[modelPath(path)]
+		if !ok {
+			return nil, dynamotree.ErrNotFound
+		}
+		if row.target == nil {
+			return row.item, nil
+		}
+		if hops >= maxLinkHops {
+			return nil, &dynamotree.LinkHopsError{Key: key, MaxLinkHops: maxLinkHops}
+		}
+		path = row.target
+	}
+}
+
+// GetLink returns the target of the link at key, or dynamotree.ErrNotFound,
+// or dynamotree.ErrNotLink if an object is stored there.
+func (m *Model) GetLink(key []string) ([]string, error) {
+	row, ok := m.rows[modelPath(key)]
+	if !ok {
+		return nil, dynamotree.ErrNotFound
+	}
+	if row.target == nil {
+		return nil, dynamotree.ErrNotLink
+	}
+	return row.target, nil
+}
+
+// List returns the names recorded in the directory of prefix, in order.
+func (m *Model) List(prefix []string) []string {
+	names := []string{}
+	for name := range m.entries[modelPath(prefix)] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ModelOptions configure RunModel.
+type ModelOptions struct {
+	// Seed seeds the random choice of operations, so that a failure can be
+	// reproduced.
+	Seed int64
+
+	// Steps is the number of operations to make. If zero, 200 are made.
+	Steps int
+
+	// Names are the parts from which keys are made. If empty, "a", "b" and
+	// "c" are used. Few names make it likely that operations meet.
+	Names []string
+
+	// MaxDepth is the most parts that a key may have. If zero, keys have
+	// up to 3 parts.
+	MaxDepth int
+}
+
+// RunModel makes a random sequence of Put, PutLink, Delete, Get, GetLink
+// and List calls on tree, which must be empty, and on a Model, and fails
+// the test at the first call whose result differs between them, reporting
+// the calls that led to it. The tree must use the default settings, apart
+// from those, such as KeyEncoding or MaintainBacklinks, that do not change
+// the results of these calls.
+func RunModel(t testing.TB, tree *dynamotree.Tree, options ModelOptions) {
+	t.Helper()
+	steps, names, maxDepth := options.Steps, options.Names, options.MaxDepth
+	if steps == 0 {
+		steps = 200
+	}
+	if len(names) == 0 {
+		names = []string{"a", "b", "c"}
+	}
+	if maxDepth == 0 {
+		maxDepth = 3
+	}
+	random := rand.New(rand.NewSource(options.Seed))
+	model := NewModel()
+	model.MaxLinkHops = tree.MaxLinkHops
+
+	randomKey := func(minDepth int) []string {
+		key := make([]string, minDepth+random.Intn(maxDepth-minDepth+1))
+		for i := range key {
+			key[i] = names[random.Intn(len(names))]
+		}
+		return key
+	}
+	history := []string{}
+	fail := func(format string, args ...interface{}) {
+		t.Helper()
+		t.Fatalf("model differs from tree (seed %d):\n%s\n%s", options.Seed,
+			strings.Join(history, "\n"), fmt.Sprintf(format, args...))
+	}
+
+	for step := 0; step < steps; step++ {
+		key := randomKey(1)
+		switch op := random.Intn(6); op {
+		case 0:
+			item := rawItem{"V": &dynamodb.AttributeValue{N: aws.String(fmt.Sprint(step))}}
+			history = append(history, fmt.Sprintf("Put(%q, %d)", key, step))
+			if err := tree.Put(key, &item); err != nil {
+				fail("Put: %s", err)
+			}
+			model.Put(key, item)
+		case 1:
+			target := randomKey(1)
+			history = append(history, fmt.Sprintf("PutLink(%q, %q)", key, target))
+			if err := tree.PutLink(key, target); err != nil {
+				fail("PutLink: %s", err)
+			}
+			model.PutLink(key, target)
+		case 2:
+			history = append(history, fmt.Sprintf("Delete(%q)", key))
+			if err := tree.Delete(key); err != nil {
+				fail("Delete: %s", err)
+			}
+			model.Delete(key)
+		case 3:
+			history = append(history, fmt.Sprintf("Get(%q)", key))
+			var item rawItem
+			err := tree.Get(key, &item, dynamotree.ConsistentRead())
+			want, wantErr := model.Get(key)
+			if got, expected := errorClass(err), errorClass(wantErr); got != expected {
+				fail("Get returned %s, expected %s", got, expected)
+			}
+			if err == nil && itemText(tree, item) != itemText(tree, want) {
+				fail("Get returned %s, expected %s", itemText(tree, item), itemText(tree, want))
+			}
+		case 4:
+			history = append(history, fmt.Sprintf("GetLink(%q)", key))
+			target, err := tree.GetLink(key, dynamotree.ConsistentRead())
+			want, wantErr := model.GetLink(key)
+			if got, expected := errorClass(err), errorClass(wantErr); got != expected {
+				fail("GetLink returned %s, expected %s", got, expected)
+			}
+			if err == nil && !reflect.DeepEqual(target, want) {
+				fail("GetLink returned %q, expected %q", target, want)
+			}
+		case 5:
+			prefix := randomKey(0)
+			history = append(history, fmt.Sprintf("List(%q)", prefix))
+			got := []string{}
+			var listErr error
+			tree.List(prefix, func(name string, err error) bool {
+				if err != nil {
+					listErr = err
+					return false
+				}
+				got = append(got, name)
+				return true
+			}, dynamotree.ConsistentRead())
+			if listErr != nil {
+				fail("List: %s", listErr)
+			}
+			if want := model.List(prefix); !reflect.DeepEqual(got, want) {
+				fail("List returned %q, expected %q", got, want)
+			}
+		}
+	}
+}
+
+// errorClass returns the kind of err that the tree and the model must
+// agree on.
+func errorClass(err error) string {
+	var linkHops *dynamotree.LinkHopsError
+	switch {
+	case err == nil:
+		return "no error"
+	case errors.As(err, &linkHops):
+		return "LinkHopsError"
+	case errors.Is(err, dynamotree.ErrNotLink):
+		return "ErrNotLink"
+	case errors.Is(err, dynamotree.ErrNotFound):
+		return "ErrNotFound"
+	}
+	return "error " + err.Error()
+}
+
+// itemText returns the attributes of item, apart from those the tree adds,
+// in the form in which Snapshot writes them.
+func itemText(tree *dynamotree.Tree, item map[string]*dynamodb.AttributeValue) string {
+	attributes := map[string]interface{}{}
+	for name, value := range dynamotree.Canonicalize(item) {
+		if name != "Key" && name != "Child" && !strings.HasPrefix(name, tree.SpecialCharacter) {
+			attributes[name] = canonicalValue(value)
+		}
+	}
+	// The values are all strings, numbers, booleans, lists and maps, which
+	// can always be marshalled.
+	buf, _ := json.Marshal(attributes)
+	return string(buf)
+}
@@ -0,0 +1,22 @@
+package dynamotreetest
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRunModel(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		t.Run(fmt.Sprint(seed), func(t *testing.T) {
+			RunModel(t, newTestTree(t), ModelOptions{Seed: seed})
+		})
+	}
+
+	// Deeper keys over more names, so that fewer operations meet.
+	RunModel(t, newTestTree(t), ModelOptions{Seed: 6, Steps: 300, Names: []string{"a", "b", "c", "d"}, MaxDepth: 4})
+
+	// Settings that do not change the results of the calls.
+	tree := newTestTree(t)
+	tree.MaintainBacklinks = true
+	RunModel(t, tree, ModelOptions{Seed: 7})
+}
@@ -0,0 +1,148 @@
+package dynamotreetest
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/crewjam/dynamotree"
+)
+
+// Seeder populates a tree with fixtures, for integration tests and local
+// development. Keys are written as paths, their parts separated by "/",
+// for example:
+//
+//	seeder := &dynamotreetest.Seeder{
+//		Tree: tree,
+//		Objects: map[string]dynamotree.Storable{
+//			"Accounts/12345": &Account{ID: "12345", Name: "alice"},
+//		},
+//		Links: map[string]string{
+//			"Users/alice": "Accounts/12345",
+//		},
+//	}
+//	if err := seeder.Reset(); err != nil {
+//		...
+//	}
+type Seeder struct {
+	// Tree is the tree to populate.
+	Tree *dynamotree.Tree
+
+	// Objects are stored at their keys using Put.
+	Objects map[string]dynamotree.Storable
+
+	// Links are created from their keys to their targets using PutLink,
+	// once the objects have been stored.
+	Links map[string]string
+}
+
+// splitPath returns the key written as path.
+func splitPath(path string) []string {
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
+
+// sortedPaths returns the keys of m in order, so that seeding is repeatable.
+func sortedPaths(m map[string]dynamotree.Storable) []string {
+	paths := make([]string, 0, len(m))
+	for path := range m {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Seed stores the objects and links in the tree, replacing those already
+// stored at their keys but leaving the rest of the tree alone.
+func (s *Seeder) Seed() error {
+	for _, path := range sortedPaths(s.Objects) {
+		if err := s.Tree.Put(splitPath(path), s.Objects[path]); err != nil {
+			return err
+		}
+	}
+	links := make([]string, 0, len(s.Links))
+	for path := range s.Links {
+		links = append(links, path)
+	}
+	sort.Strings(links)
+	for _, path := range links {
+		if err := s.Tree.PutLink(splitPath(path), splitPath(s.Links[path])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset removes everything stored in the tree's table, including versions
+// and events, apart from the table's metadata, and then seeds it, so that
+// each test can begin from the same state.
+func (s *Seeder) Reset() error {
+	if err := s.Clear(); err != nil {
+		return err
+	}
+	return s.Seed()
+}
+
+// Clear removes everything stored in the tree's table, apart from the
+// table's metadata.
+func (s *Seeder) Clear() error {
+	t := s.Tree
+	if err := t.Open(context.Background()); err != nil {
+		return err
+	}
+	writeRequests := []*dynamodb.WriteRequest{}
+	err := t.DB.ScanPages(&dynamodb.ScanInput{
+		TableName:            aws.String(t.TableName),
+		ConsistentRead:       aws.Bool(true),
+		ProjectionExpression: aws.String("#K, #C"),
+		ExpressionAttributeNames: map[string]*string{
+			"#K": aws.String("Key"),
+			"#C": aws.String("Child"),
+		},
+	}, func(p *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, row := range p.Items {
+			if aws.StringValue(row["Key"].S) != dynamotree.MetadataKey {
+				writeRequests = append(writeRequests, &dynamodb.WriteRequest{
+					DeleteRequest: &dynamodb.DeleteRequest{Key: row},
+				})
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for len(writeRequests) > 0 {
+		n := len(writeRequests)
+		if n > 25 {
+			n = 25
+		}
+		input := &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]*dynamodb.WriteRequest{t.TableName: writeRequests[:n]},
+		}
+		for len(input.RequestItems) > 0 {
+			output, err := t.DB.BatchWriteItem(input)
+			if err != nil {
+				return err
+			}
+			input.RequestItems = output.UnprocessedItems
+		}
+		writeRequests = writeRequests[n:]
+	}
+	return nil
+}
+
+// Teardown deletes the tree's table.
+func (s *Seeder) Teardown() error {
+	if err := s.Tree.Open(context.Background()); err != nil {
+		return err
+	}
+	_, err := s.Tree.DB.DeleteTable(&dynamodb.DeleteTableInput{
+		TableName: aws.String(s.Tree.TableName),
+	})
+	return err
+}
@@ -0,0 +1,77 @@
+package dynamotreetest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/crewjam/dynamotree"
+)
+
+func TestSeeder(t *testing.T) {
+	tree := newTestTree(t)
+	seeder := &Seeder{
+		Tree: tree,
+		Objects: map[string]dynamotree.Storable{
+			"Accounts/12345": account("alice"),
+			"Accounts/23456": account("bob"),
+		},
+		Links: map[string]string{
+			"Users/alice": "Accounts/12345",
+		},
+	}
+	if err := seeder.Seed(); err != nil {
+		t.Fatal(err)
+	}
+	want := "Accounts/12345 = {\"Name\":\"alice\"}\n" +
+		"Accounts/23456 = {\"Name\":\"bob\"}\n" +
+		"Users/alice -> Accounts/12345\n"
+	assertSnapshotText(t, tree, want)
+
+	// Clear removes everything, including what was not seeded, but leaves
+	// the table usable.
+	if err := tree.Put([]string{"Other", "x"}, account("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := seeder.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	assertSnapshotText(t, tree, "")
+	var item rawItem
+	if err := tree.Get([]string{"Users", "alice"}, &item, dynamotree.ConsistentRead()); !errors.Is(err, dynamotree.ErrNotFound) {
+		t.Errorf("Get after Clear returned %v, expected ErrNotFound", err)
+	}
+
+	// Reset seeds the tree anew, replacing what was changed.
+	if err := tree.Put([]string{"Accounts", "12345"}, account("mallory")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Put([]string{"Other", "x"}, account("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := seeder.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	assertSnapshotText(t, tree, want)
+	if err := tree.Get([]string{"Users", "alice"}, &item, dynamotree.ConsistentRead()); err != nil {
+		t.Fatal(err)
+	}
+	if got := itemText(tree, item); got != `{"Name":"alice"}` {
+		t.Errorf("Get after Reset returned %s", got)
+	}
+
+	if err := seeder.Teardown(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// assertSnapshotText fails the test if the Snapshot of tree is not want.
+func assertSnapshotText(t *testing.T, tree *dynamotree.Tree, want string) {
+	t.Helper()
+	got, err := Snapshot(tree, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("snapshot is:\n%s\nexpected:\n%s", got, want)
+	}
+}
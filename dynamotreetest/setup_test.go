@@ -0,0 +1,43 @@
+package dynamotreetest
+
+import (
+	"log"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/crewjam/dynamotree"
+	"github.com/crewjam/fakeaws/fakedynamodb"
+	"github.com/dchest/uniuri"
+)
+
+// testConfig is the configuration for the fake DynamoDB the tests use.
+var testConfig *aws.Config
+
+func TestMain(m *testing.M) {
+	fakeDynamodbServer, err := fakedynamodb.New()
+	if err != nil {
+		log.Panicf("fakedynamodb: %s", err)
+	}
+	testConfig = fakeDynamodbServer.Config
+	rv := m.Run()
+	fakeDynamodbServer.Close()
+	os.Exit(rv)
+}
+
+// newTestTree returns a tree backed by a new table.
+func newTestTree(t *testing.T) *dynamotree.Tree {
+	t.Helper()
+	tree := &dynamotree.Tree{TableName: uniuri.New(), DB: dynamodb.New(session.New(), testConfig)}
+	if err := tree.CreateTable(); err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+// account is an object stored by the tests.
+func account(name string) *rawItem {
+	return &rawItem{"Name": &dynamodb.AttributeValue{S: aws.String(name)}}
+}
@@ -0,0 +1,193 @@
+package dynamotreetest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/crewjam/dynamotree"
+)
+
+// UpdateSnapshotsEnv is the name of an environment variable that, if set,
+// causes AssertSnapshot to write the golden files rather than compare
+// them, for example:
+//
+//	DYNAMOTREE_UPDATE_SNAPSHOTS=1 go test ./...
+const UpdateSnapshotsEnv = "DYNAMOTREE_UPDATE_SNAPSHOTS"
+
+// rawItem is a Storable that holds the attributes of an object as they
+// are stored.
+type rawItem map[string]*dynamodb.AttributeValue
+
+func (r *rawItem) MarshalDynamoDB() (map[string]*dynamodb.AttributeValue, error) {
+	return *r, nil
+}
+
+func (r *rawItem) UnmarshalDynamoDB(item map[string]*dynamodb.AttributeValue) error {
+	*r = item
+	return nil
+}
+
+// Snapshot returns a description of the objects and links stored below
+// prefix, in which the same contents always give the same text, so that
+// the whole state of a tree after a test can be compared with what it
+// should be. Each object or link takes a line, in the order of their
+// keys, written as paths whose parts are separated by "/":
+//
+//	Accounts/12345 = {"ID":"12345","Name":"alice"}
+//	Users/alice -> Accounts/12345
+//
+// The attributes of each object are written as JSON, in the order of
+// their names. Numbers are written as dynamotree.Canonicalize writes
+// them, sets as lists in order, and binary values in base64. Versions,
+// events and directories with nothing below them are not included.
+func Snapshot(tree *dynamotree.Tree, prefix []string) (string, error) {
+	lines := []string{}
+	var walkErr error
+	tree.WalkInfo(prefix, func(info *dynamotree.NodeInfo, err error) bool {
+		if err != nil {
+			walkErr = err
+			return false
+		}
+		path := strings.Join(info.Key, "/")
+		switch info.Kind {
+		case dynamotree.NodeLink:
+			lines = append(lines, path+" -> "+strings.Join(info.LinkTarget, "/"))
+		case dynamotree.NodeItem:
+			var item rawItem
+			if err := tree.Get(info.Key, &item, dynamotree.ConsistentRead()); err != nil {
+				walkErr = err
+				return false
+			}
+			lines = append(lines, path+" = "+itemText(tree, item))
+		}
+		return true
+	}, dynamotree.ConsistentRead())
+	if walkErr != nil {
+		return "", walkErr
+	}
+	sort.Strings(lines)
+	if len(lines) == 0 {
+		return "", nil
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// canonicalValue returns value in the form in which Snapshot writes it.
+func canonicalValue(value *dynamodb.AttributeValue) interface{} {
+	sorted := func(values []*string) []string {
+		rv := aws.StringValueSlice(values)
+		sort.Strings(rv)
+		return rv
+	}
+	switch {
+	case value.S != nil:
+		return aws.StringValue(value.S)
+	case value.N != nil:
+		return json.Number(aws.StringValue(value.N))
+	case value.BOOL != nil:
+		return aws.BoolValue(value.BOOL)
+	case value.B != nil:
+		return base64.StdEncoding.EncodeToString(value.B)
+	case value.SS != nil:
+		return sorted(value.SS)
+	case value.NS != nil:
+		numbers := aws.StringValueSlice(value.NS)
+		sort.Slice(numbers, func(i, j int) bool {
+			var a, b float64
+			fmt.Sscan(numbers[i], &a)
+			fmt.Sscan(numbers[j], &b)
+			return a < b
+		})
+		rv := make([]json.Number, len(numbers))
+		for i, n := range numbers {
+			rv[i] = json.Number(n)
+		}
+		return rv
+	case value.BS != nil:
+		rv := make([]string, len(value.BS))
+		for i, b := range value.BS {
+			rv[i] = base64.StdEncoding.EncodeToString(b)
+		}
+		sort.Strings(rv)
+		return rv
+	case value.M != nil:
+		rv := make(map[string]interface{}, len(value.M))
+		for name, v := range value.M {
+			rv[name] = canonicalValue(v)
+		}
+		return rv
+	case value.L != nil:
+		rv := make([]interface{}, len(value.L))
+		for i, v := range value.L {
+			rv[i] = canonicalValue(v)
+		}
+		return rv
+	}
+	return nil
+}
+
+// AssertSnapshot fails the test if the Snapshot of the keys below prefix
+// differs from the contents of goldenFile. If the environment variable
+// named by UpdateSnapshotsEnv is set, it writes the snapshot to goldenFile
+// instead, creating the file's directory if need be.
+func AssertSnapshot(t testing.TB, tree *dynamotree.Tree, prefix []string, goldenFile string) {
+	t.Helper()
+	got, err := Snapshot(tree, prefix)
+	if err != nil {
+		t.Fatalf("snapshot of %s: %s", strings.Join(prefix, "/"), err)
+	}
+	if os.Getenv(UpdateSnapshotsEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(goldenFile), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(goldenFile, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatalf("%s (set %s to create it)", err, UpdateSnapshotsEnv)
+	}
+	if !bytes.Equal([]byte(got), want) {
+		t.Errorf("snapshot of %s differs from %s (set %s to update it):\n%s",
+			strings.Join(prefix, "/"), goldenFile, UpdateSnapshotsEnv, diffLines(string(want), got))
+	}
+}
+
+// diffLines returns the lines of want that are not in got, prefixed with
+// "-", and those of got that are not in want, prefixed with "+". Both are
+// sorted, as snapshots are.
+func diffLines(want, got string) string {
+	lines := func(s string) []string {
+		if s == "" {
+			return nil
+		}
+		return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	}
+	a, b := lines(want), lines(got)
+	buf := bytes.NewBuffer(nil)
+	for len(a) > 0 || len(b) > 0 {
+		switch {
+		case len(b) == 0 || (len(a) > 0 && a[0] < b[0]):
+			fmt.Fprintf(buf, "-%s\n", a[0])
+			a = a[1:]
+		case len(a) == 0 || b[0] < a[0]:
+			fmt.Fprintf(buf, "+%s\n", b[0])
+			b = b[1:]
+		default:
+			a, b = a[1:], b[1:]
+		}
+	}
+	return buf.String()
+}
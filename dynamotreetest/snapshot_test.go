@@ -0,0 +1,103 @@
+package dynamotreetest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// recordingT is a testing.TB that records the failures reported to it
+// rather than failing the test.
+type recordingT struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recordingT) Helper() {}
+
+func (r *recordingT) Errorf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingT) Fatalf(format string, args ...interface{}) {
+	r.Errorf(format, args...)
+}
+
+func (r *recordingT) Fatal(args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprint(args...))
+}
+
+func TestSnapshot(t *testing.T) {
+	tree := newTestTree(t)
+	if err := tree.Put([]string{"Accounts", "12345"}, &rawItem{
+		"Name":  &dynamodb.AttributeValue{S: aws.String("alice")},
+		"Score": &dynamodb.AttributeValue{N: aws.String("1.50")},
+		"Tags":  &dynamodb.AttributeValue{SS: aws.StringSlice([]string{"b", "a"})},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.PutLink([]string{"Users", "alice"}, []string{"Accounts", "12345"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The golden file in testdata matches.
+	AssertSnapshot(t, tree, nil, filepath.Join("testdata", "snapshot.golden"))
+
+	// With UpdateSnapshotsEnv set, the golden file is written, along with
+	// its directory.
+	dir, err := ioutil.TempDir("", "dynamotreetest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	goldenFile := filepath.Join(dir, "new", "snapshot.golden")
+	os.Setenv(UpdateSnapshotsEnv, "1")
+	AssertSnapshot(t, tree, nil, goldenFile)
+	os.Unsetenv(UpdateSnapshotsEnv)
+	written, err := ioutil.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ioutil.ReadFile(filepath.Join("testdata", "snapshot.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(written) != string(want) {
+		t.Errorf("golden file written is:\n%s\nexpected:\n%s", written, want)
+	}
+
+	// A tree that differs from the golden file fails, with the lines that
+	// differ.
+	if err := tree.Put([]string{"Accounts", "23456"}, account("bob")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Delete([]string{"Users", "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	r := &recordingT{TB: t}
+	AssertSnapshot(r, tree, nil, goldenFile)
+	if len(r.failures) != 1 {
+		t.Fatalf("AssertSnapshot reported %q, expected one failure", r.failures)
+	}
+	for _, line := range []string{
+		"\n+Accounts/23456 = {\"Name\":\"bob\"}\n",
+		"\n-Users/alice -> Accounts/12345\n",
+	} {
+		if !strings.Contains(r.failures[0], line) {
+			t.Errorf("AssertSnapshot reported %q, expected it to include %q", r.failures[0], line)
+		}
+	}
+
+	// A missing golden file fails, saying how to create it.
+	r = &recordingT{TB: t}
+	AssertSnapshot(r, tree, nil, filepath.Join(dir, "missing.golden"))
+	if len(r.failures) == 0 || !strings.Contains(r.failures[0], UpdateSnapshotsEnv) {
+		t.Errorf("AssertSnapshot reported %q for a missing golden file", r.failures)
+	}
+}
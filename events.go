@@ -0,0 +1,171 @@
+package dynamotree
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Event is an event read by ReadEvents.
+type Event struct {
+	// ID identifies the event. IDs sort in the order in which the events
+	// were appended.
+	ID string
+
+	// Time is the time at which the event was appended.
+	Time time.Time
+
+	// Item holds the attributes of the event.
+	Item map[string]*dynamodb.AttributeValue
+}
+
+// Unmarshal unmarshals the event into ob.
+func (e *Event) Unmarshal(ob Storable) error {
+	return ob.UnmarshalDynamoDB(e.Item)
+}
+
+// eventsChild returns the range key below which the events of an object
+// are stored, ¦.events¦. Events share the hash key of the object's own row,
+// so they are ordered by the table's range key and do not appear in List.
+func (t *Tree) eventsChild() string {
+	return t.SpecialCharacter + ".events" + t.SpecialCharacter
+}
+
+// AppendEvent appends event to the log of events for key and returns the
+// ID of the new event. Events are immutable once appended.
+//
+// The event log is independent of the object stored at key: events may be
+// appended whether or not the object exists, they do not appear in List,
+// and they are not removed by Delete.
+//
+// If the event is subject to a RetentionPolicy with an EventTTL and the
+// tree has a TTLAttribute, the event is given the time at which it
+// expires.
+func (t *Tree) AppendEvent(key []string, event Storable, opts ...Option) (id string, err error) {
+	defer annotateError(&err, "AppendEvent", key)
+	o, cancel := t.startCall("AppendEvent", key, &err, opts)
+	defer cancel()
+	if err := t.ready(); err != nil {
+		return "", err
+	}
+	if err := t.checkCapability("AppendEvent", key, o); err != nil {
+		return "", err
+	}
+	key, err = t.transformKey(key)
+	if err != nil {
+		return "", err
+	}
+	if err := t.ValidateKey(key); err != nil {
+		return "", err
+	}
+	if err := t.checkTenant(key); err != nil {
+		return "", err
+	}
+	if _, err := t.checkGuards(key, guardAppend, o); err != nil {
+		return "", err
+	}
+
+	attributes, err := event.MarshalDynamoDB()
+	if err != nil {
+		return "", err
+	}
+	if err := t.checkAttributes(attributes); err != nil {
+		return "", err
+	}
+
+	now := t.now()
+	id, err = t.newTimeOrderedID(now)
+	if err != nil {
+		return "", err
+	}
+	if name, expires := t.eventExpiry(key, now); expires != nil {
+		attributes[name] = expires
+	}
+	attributes["Key"] = &dynamodb.AttributeValue{
+		S: aws.String(t.EncodeKey(key)),
+	}
+	attributes["Child"] = &dynamodb.AttributeValue{
+		S: aws.String(t.eventsChild() + id),
+	}
+	if t.TenantAttribute != "" && len(key) > 0 {
+		attributes[t.TenantAttribute] = &dynamodb.AttributeValue{S: aws.String(key[0])}
+	}
+	_, err = t.DB.PutItemWithContext(o.context(), &dynamodb.PutItemInput{
+		TableName:           aws.String(t.TableName),
+		Item:                attributes,
+		ConditionExpression: aws.String("attribute_not_exists(#K)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#K": aws.String("Key"),
+		},
+	}, o.request()...)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// ReadEvents calls fn for each of the events of key appended at or after
+// since, in the order in which they were appended. If fn returns false,
+// ReadEvents stops. If an error occurs, fn is called with a nil event and
+// the error.
+func (t *Tree) ReadEvents(key []string, since time.Time, fn func(*Event, error) bool, opts ...Option) {
+	eventFunc, eventKey := fn, key
+	fn = func(event *Event, err error) bool { return eventFunc(event, wrapError("ReadEvents", eventKey, err)) }
+	o, cancel := newCallOptions(opts)
+	defer cancel()
+	if err := refuseCapability("ReadEvents", key, o); err != nil {
+		fn(nil, err)
+		return
+	}
+	if err := t.ready(); err != nil {
+		fn(nil, err)
+		return
+	}
+	key, err := t.transformKey(key)
+	if err != nil {
+		fn(nil, err)
+		return
+	}
+	if err := t.ValidateKey(key); err != nil {
+		fn(nil, err)
+		return
+	}
+
+	// Event IDs begin with a decimal timestamp, which sorts before ":".
+	eventsChild := t.eventsChild()
+	var innerErr error
+	err = t.DB.QueryPagesWithContext(o.context(), &dynamodb.QueryInput{
+		TableName:              aws.String(t.TableName),
+		ConsistentRead:         o.consistent(),
+		KeyConditionExpression: aws.String("#K = :key AND #C BETWEEN :start AND :end"),
+		ExpressionAttributeNames: map[string]*string{
+			"#K": aws.String("Key"),
+			"#C": aws.String("Child"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":key":   &dynamodb.AttributeValue{S: aws.String(t.EncodeKey(key))},
+			":start": &dynamodb.AttributeValue{S: aws.String(eventsChild + timeOrderedPrefix(since))},
+			":end":   &dynamodb.AttributeValue{S: aws.String(eventsChild + ":")},
+		}}, func(p *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, attrs := range p.Items {
+			id := strings.TrimPrefix(*attrs["Child"].S, eventsChild)
+			event := &Event{ID: id, Item: attrs}
+			event.Time, innerErr = timeOrderedIDTime(id)
+			if innerErr != nil {
+				return false
+			}
+			if !fn(event, nil) {
+				return false
+			}
+		}
+		return true
+	}, o.request()...)
+	if err == nil {
+		err = innerErr
+	}
+	if err != nil {
+		fn(nil, err)
+	}
+}
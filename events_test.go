@@ -0,0 +1,65 @@
+package dynamotree
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestEvents(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	err := s.CreateTable()
+	c.Assert(err, IsNil)
+
+	key := []string{"Accounts", "12345"}
+	v := AccountT{ID: "12345", Name: "alice"}
+	err = s.Put(key, &v)
+	c.Assert(err, IsNil)
+
+	start := time.Now()
+	id1, err := s.AppendEvent(key, &AccountT{Name: "created"})
+	c.Assert(err, IsNil)
+	time.Sleep(time.Millisecond)
+	middle := time.Now()
+	id2, err := s.AppendEvent(key, &AccountT{Name: "renamed"})
+	c.Assert(err, IsNil)
+	c.Assert(id1 < id2, Equals, true)
+
+	// events for other keys are not included
+	_, err = s.AppendEvent([]string{"Accounts", "6789"}, &AccountT{Name: "created"})
+	c.Assert(err, IsNil)
+
+	readEvents := func(since time.Time) []string {
+		names := []string{}
+		s.ReadEvents(key, since, func(event *Event, err error) bool {
+			c.Assert(err, IsNil)
+			c.Assert(event.Time.Before(start), Equals, false)
+			var e AccountT
+			c.Assert(event.Unmarshal(&e), IsNil)
+			names = append(names, e.Name)
+			return true
+		})
+		return names
+	}
+	c.Assert(readEvents(time.Time{}), DeepEquals, []string{"created", "renamed"})
+	c.Assert(readEvents(middle), DeepEquals, []string{"renamed"})
+	c.Assert(readEvents(time.Now().Add(time.Second)), DeepEquals, []string{})
+
+	// events don't interfere with the object or the directory
+	var v2 AccountT
+	err = s.Get(key, &v2)
+	c.Assert(err, IsNil)
+	c.Assert(v2, DeepEquals, v)
+	items := []string{}
+	s.List([]string{"Accounts"}, func(item string, err error) bool {
+		c.Assert(err, IsNil)
+		items = append(items, item)
+		return true
+	})
+	c.Assert(items, DeepEquals, []string{"12345"})
+}
@@ -24,6 +24,7 @@ import (
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha1"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -136,7 +137,7 @@ func RequireAccount(c *web.C, h http.Handler) http.Handler {
 		}
 
 		err := tree.Get([]string{"Accounts", username}, &account)
-		if err == dynamotree.ErrNotFound {
+		if errors.Is(err, dynamotree.ErrNotFound) {
 			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 			return
 		}
@@ -189,7 +190,7 @@ func CreateLink(c web.C, w http.ResponseWriter, r *http.Request) {
 func ServeLink(c web.C, w http.ResponseWriter, r *http.Request) {
 	l := Link{}
 	err := tree.Get([]string{"Links", strings.TrimPrefix(r.URL.Path, "/")}, &l)
-	if err == dynamotree.ErrNotFound {
+	if errors.Is(err, dynamotree.ErrNotFound) {
 		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 		return
 	}
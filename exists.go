@@ -0,0 +1,84 @@
+package dynamotree
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// maxBatchGetKeys is the maximum number of keys that BatchGetItem allows.
+const maxBatchGetKeys = 100
+
+// ExistsMulti reports, for each of keys, whether an object or link is
+// stored at it. Links are not followed, so a link whose target does not
+// exist is reported as existing. The keys are read using BatchGetItem,
+// 100 at a time, fetching only the key attribute of each row.
+func (t *Tree) ExistsMulti(keys [][]string, opts ...Option) (exists []bool, err error) {
+	defer annotateError(&err, "ExistsMulti", nil)
+	o, cancel := newCallOptions(opts)
+	defer cancel()
+	if err := refuseCapability("ExistsMulti", nil, o); err != nil {
+		return nil, err
+	}
+	if err := t.ready(); err != nil {
+		return nil, err
+	}
+
+	// indexes maps the stored form of each key to its positions in keys,
+	// as BatchGetItem does not allow a key to be requested twice.
+	indexes := map[string][]int{}
+	pathKeys := []string{}
+	for i, key := range keys {
+		key, err := t.transformKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := t.ValidateKey(key); err != nil {
+			return nil, err
+		}
+		pathKey := t.EncodeKey(key)
+		if _, ok := indexes[pathKey]; !ok {
+			pathKeys = append(pathKeys, pathKey)
+		}
+		indexes[pathKey] = append(indexes[pathKey], i)
+	}
+
+	exists = make([]bool, len(keys))
+	for len(pathKeys) > 0 {
+		n := len(pathKeys)
+		if n > maxBatchGetKeys {
+			n = maxBatchGetKeys
+		}
+		requestKeys := make([]map[string]*dynamodb.AttributeValue, n)
+		for i, pathKey := range pathKeys[:n] {
+			requestKeys[i] = map[string]*dynamodb.AttributeValue{
+				"Key":   &dynamodb.AttributeValue{S: aws.String(pathKey)},
+				"Child": &dynamodb.AttributeValue{S: aws.String(t.SpecialCharacter)},
+			}
+		}
+		pathKeys = pathKeys[n:]
+
+		requestItems := map[string]*dynamodb.KeysAndAttributes{
+			t.TableName: &dynamodb.KeysAndAttributes{
+				Keys:                     requestKeys,
+				ConsistentRead:           o.consistent(),
+				ProjectionExpression:     aws.String("#K"),
+				ExpressionAttributeNames: map[string]*string{"#K": aws.String("Key")},
+			},
+		}
+		for len(requestItems) > 0 {
+			output, err := t.DB.BatchGetItemWithContext(o.context(), &dynamodb.BatchGetItemInput{
+				RequestItems: requestItems,
+			}, o.request()...)
+			if err != nil {
+				return nil, err
+			}
+			for _, row := range output.Responses[t.TableName] {
+				for _, i := range indexes[aws.StringValue(row["Key"].S)] {
+					exists[i] = true
+				}
+			}
+			requestItems = output.UnprocessedKeys
+		}
+	}
+	return exists, nil
+}
@@ -0,0 +1,39 @@
+package dynamotree
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestExistsMulti(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+
+	v := AccountT{ID: "12345", Name: "alice"}
+	keys := [][]string{}
+	expected := []bool{}
+	for i := 0; i < 250; i++ {
+		key := []string{"Accounts", fmt.Sprintf("%d", i)}
+		if i%3 == 0 {
+			c.Assert(s.Put(key, &v), IsNil)
+		}
+		keys = append(keys, key)
+		expected = append(expected, i%3 == 0)
+	}
+	c.Assert(s.PutLink([]string{"Links", "dangling"}, []string{"Accounts", "missing"}), IsNil)
+	keys = append(keys, []string{"Links", "dangling"}, []string{"Accounts", "0"}, []string{"Accounts"})
+	expected = append(expected, true, true, false)
+
+	exists, err := s.ExistsMulti(keys)
+	c.Assert(err, IsNil)
+	c.Assert(exists, DeepEquals, expected)
+
+	_, err = s.ExistsMulti([][]string{{"Accounts", "a¦b"}})
+	c.Assert(err, ErrorIs, ErrReservedCharacterInKey)
+}
@@ -0,0 +1,195 @@
+package dynamotree
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+)
+
+// DefaultExpiryPollInterval is the PollInterval used when an ExpiryCleaner
+// does not specify one.
+const DefaultExpiryPollInterval = 10 * time.Second
+
+// ExpiryCleaner removes the directory entries of the objects that DynamoDB
+// removes once the time held in their Tree.TTLAttribute has passed, so
+// that List and Walk do not return keys at which nothing is stored any
+// longer. DynamoDB removes only the row of the object; the cleaner learns
+// of each removal from the table's DynamoDB stream, which must be enabled.
+// Any StreamViewType will do, as only the keys of records are read.
+//
+// The entry of an object that has keys below it is kept, as Delete keeps
+// it, as is that of an object that has been stored again since it expired.
+// Objects subject to a RetentionPolicy with an ObjectTTL are also cleaned
+// up by Sweeper, which does not need the stream but must traverse the
+// keys below the policy's prefix to find them.
+type ExpiryCleaner struct {
+	// Tree is the tree to clean up.
+	Tree *Tree
+
+	// Streams is the client used to read the table's stream.
+	Streams *dynamodbstreams.DynamoDBStreams
+
+	// StreamARN is the ARN of the table's stream. If empty, the table's
+	// latest stream is used.
+	StreamARN string
+
+	// PollInterval is how often the stream is read. If zero,
+	// DefaultExpiryPollInterval is used.
+	PollInterval time.Duration
+
+	// OnClean, if not nil, is called with the key of each object whose
+	// removal is handled, and the error, if any, that prevented its entry
+	// from being removed, or with a nil key and the error that prevented
+	// the stream from being read. The records that fail are read again at
+	// the next PollInterval.
+	OnClean func(key []string, err error)
+
+	reader    *streamReader
+	stop      chan struct{}
+	done      chan struct{}
+	untrack   func()
+	closeOnce sync.Once
+}
+
+// Start begins consuming the stream from its latest records. Objects
+// removed before Start is called are not cleaned up. Call Close to stop
+// consuming the stream.
+func (e *ExpiryCleaner) Start() error {
+	t := e.Tree
+	if err := t.ready(); err != nil {
+		return err
+	}
+	if t.keyMapper != nil {
+		return ErrKeySchemaStream
+	}
+	if e.PollInterval == 0 {
+		e.PollInterval = DefaultExpiryPollInterval
+	}
+	if e.StreamARN == "" {
+		arn, err := t.latestStreamARN()
+		if err != nil {
+			return err
+		}
+		e.StreamARN = arn
+	}
+	reader, err := newStreamReader(e.Streams, e.StreamARN)
+	if err != nil {
+		return err
+	}
+	e.reader = reader
+
+	e.stop = make(chan struct{})
+	e.done = make(chan struct{})
+	go e.run()
+	e.untrack = t.onClose(func(context.Context) error { return e.Close() })
+	return nil
+}
+
+// Close stops consuming the stream. Closing the tree closes the cleaner.
+// Close may be called more than once, and while the tree is closing.
+func (e *ExpiryCleaner) Close() error {
+	if e.stop == nil {
+		return nil
+	}
+	e.closeOnce.Do(func() {
+		close(e.stop)
+		<-e.done
+		e.untrack()
+	})
+	return nil
+}
+
+func (e *ExpiryCleaner) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+		}
+		handled := false
+		err := e.reader.poll(func(record *dynamodbstreams.Record) error {
+			err := e.HandleRecord(context.Background(), record)
+			handled = err != nil
+			return err
+		})
+		if err != nil && !handled && e.OnClean != nil {
+			e.OnClean(nil, err)
+		}
+	}
+}
+
+// HandleRecord removes the directory entry of the object whose removal by
+// DynamoDB's time to live is described by record, a record of the table's
+// stream. Records of other changes are ignored. Once started, the cleaner
+// calls HandleRecord for each record of the stream, but it may be called
+// instead by another consumer of the stream, such as a function triggered
+// by it, without calling Start.
+func (e *ExpiryCleaner) HandleRecord(ctx context.Context, record *dynamodbstreams.Record) error {
+	t := e.Tree
+	if err := t.ready(); err != nil {
+		return err
+	}
+	if t.keyMapper != nil {
+		return ErrKeySchemaStream
+	}
+	if !isExpiry(record) {
+		return nil
+	}
+	keys := record.Dynamodb.Keys
+	if aws.StringValue(keys["Child"].S) != t.SpecialCharacter {
+		return nil
+	}
+	key := t.DecodeKey(aws.StringValue(keys["Key"].S))
+	if len(key) == 0 {
+		return nil
+	}
+	o, cancel := newCallOptions([]Option{WithContext(ctx), ConsistentRead()})
+	defer cancel()
+	err := t.removeExpiredEntry(key, o)
+	if e.OnClean != nil {
+		e.OnClean(key, err)
+	}
+	return err
+}
+
+// isExpiry returns true if record describes the removal of a row by
+// DynamoDB's time to live, rather than by a client of the table.
+func isExpiry(record *dynamodbstreams.Record) bool {
+	return aws.StringValue(record.EventName) == dynamodbstreams.OperationTypeRemove &&
+		record.Dynamodb != nil && record.UserIdentity != nil &&
+		aws.StringValue(record.UserIdentity.Type) == "Service" &&
+		aws.StringValue(record.UserIdentity.PrincipalId) == "dynamodb.amazonaws.com"
+}
+
+// removeExpiredEntry removes the directory entry of key, whose object has
+// been removed, unless the object has been stored again or there are keys
+// below it, and removes the copies of the object held by the links to it.
+func (t *Tree) removeExpiredEntry(key []string, o *callOptions) error {
+	defer t.lockKey(key)()
+	row, err := t.getRow(t.EncodeKey(key), o)
+	if err != nil || row != nil {
+		return err
+	}
+	if err := t.refreshLinkCopies(key, nil, o); err != nil {
+		return err
+	}
+	hasChildren, err := t.hasChildren(t.dirKey(key), o)
+	if err != nil || hasChildren {
+		return err
+	}
+	return t.batchWrite([]*dynamodb.WriteRequest{{
+		DeleteRequest: &dynamodb.DeleteRequest{
+			Key: map[string]*dynamodb.AttributeValue{
+				"Key":   &dynamodb.AttributeValue{S: aws.String(t.dirKey(key[:len(key)-1]))},
+				"Child": &dynamodb.AttributeValue{S: aws.String(t.childName(key))},
+			},
+		},
+	}}, o)
+}
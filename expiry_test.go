@@ -0,0 +1,103 @@
+package dynamotree
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+// The fake DynamoDB implements neither streams nor time to live, so this
+// test removes the rows itself and feeds the cleaner stream records.
+func (suite *StoreImplTest) TestExpiryCleaner(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db, TTLAttribute: "Expires"}
+	c.Assert(s.CreateTable(), IsNil)
+	for _, key := range [][]string{{"Sessions", "a"}, {"Sessions", "b"}, {"Sessions", "b", "c"}, {"Sessions", "d"}} {
+		c.Assert(s.Put(key, &AccountT{Name: key[len(key)-1]}), IsNil)
+	}
+
+	cleaned := [][]string{}
+	cleaner := &ExpiryCleaner{Tree: s, OnClean: func(key []string, err error) {
+		c.Assert(err, IsNil)
+		cleaned = append(cleaned, key)
+	}}
+	expire := func(key []string, byTTL bool) {
+		keys := map[string]*dynamodb.AttributeValue{
+			"Key":   {S: aws.String(s.EncodeKey(key))},
+			"Child": {S: aws.String(s.SpecialCharacter)},
+		}
+		_, err := db.DeleteItem(&dynamodb.DeleteItemInput{TableName: aws.String(s.TableName), Key: keys})
+		c.Assert(err, IsNil)
+		record := &dynamodbstreams.Record{
+			EventName: aws.String(dynamodbstreams.OperationTypeRemove),
+			Dynamodb:  &dynamodbstreams.StreamRecord{Keys: keys},
+		}
+		if byTTL {
+			record.UserIdentity = &dynamodbstreams.Identity{
+				Type:        aws.String("Service"),
+				PrincipalId: aws.String("dynamodb.amazonaws.com"),
+			}
+		}
+		c.Assert(cleaner.HandleRecord(context.Background(), record), IsNil)
+	}
+
+	expire([]string{"Sessions", "a"}, true)
+	expire([]string{"Sessions", "b"}, true)
+	expire([]string{"Sessions", "d"}, false)
+	c.Assert(cleaned, DeepEquals, [][]string{{"Sessions", "a"}, {"Sessions", "b"}})
+
+	// The entry of b is kept for the key below it, and that of d because
+	// it was removed by a client, which would have removed the entry.
+	c.Assert(walkKeys(c, s, []string{"Sessions"}), DeepEquals, [][]string{
+		{"Sessions", "b"},
+		{"Sessions", "b", "c"},
+		{"Sessions", "d"},
+	})
+
+	// An object stored again before its removal is handled is kept.
+	c.Assert(s.Put([]string{"Sessions", "e"}, &AccountT{Name: "e"}), IsNil)
+	c.Assert(s.removeExpiredEntry([]string{"Sessions", "e"}, nil), IsNil)
+	c.Assert(s.Get([]string{"Sessions", "e"}, &AccountT{}), IsNil)
+	names := []string{}
+	s.List([]string{"Sessions"}, func(name string, err error) bool {
+		c.Assert(err, IsNil)
+		names = append(names, name)
+		return true
+	})
+	c.Assert(names, DeepEquals, []string{"b", "d", "e"})
+
+	// The copies of an expired object held by the links to it are removed
+	// with it.
+	s.LinkCopyMaxSize, s.MaintainBacklinks = 1024, true
+	c.Assert(s.Put([]string{"Sessions", "f"}, &AccountT{Name: "f"}), IsNil)
+	c.Assert(s.PutLink([]string{"Current"}, []string{"Sessions", "f"}), IsNil)
+	expire([]string{"Sessions", "f"}, true)
+	c.Assert(s.Get([]string{"Current"}, &AccountT{}), ErrorIs, ErrNotFound)
+
+	// The cleaner may be closed by several callers at once, and by closing
+	// the tree. Its polling is stood in for by a goroutine that stops when
+	// it is told to.
+	cleaner.stop, cleaner.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		<-cleaner.stop
+		close(cleaner.done)
+	}()
+	cleaner.untrack = s.onClose(func(context.Context) error { return cleaner.Close() })
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Check(cleaner.Close(), IsNil)
+		}()
+	}
+	c.Assert(s.Close(context.Background()), IsNil)
+	wg.Wait()
+	c.Assert(cleaner.Close(), IsNil)
+}
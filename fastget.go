@@ -0,0 +1,107 @@
+package dynamotree
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// FastGet fetches the object at key, like Get, for the hot paths where the
+// caller knows that the key holds an object rather than a link, such as
+// the redirects of a link shortener. It makes exactly one GetItem request,
+// through Tree.FastGetDB if it is set, fetching only the named attributes,
+// or all of them if none are named, so that ob is filled in with those
+// attributes alone.
+//
+// FastGet does not follow links: if the key holds a link, it returns
+// ErrIsLink, and the caller may fall back to Get. If nothing is stored at
+// the key, it returns ErrNotFound, without looking for keys below it as
+// Get does with Tree.ReportDirectories. While the table is being migrated,
+// FastGet reads as Get does.
+func (t *Tree) FastGet(key []string, attributes []string, ob Storable, opts ...Option) (err error) {
+	defer annotateError(&err, "FastGet", key)
+	o, cancel := t.startCall("FastGet", key, &err, opts)
+	defer cancel()
+	if err := t.ready(); err != nil {
+		return err
+	}
+	if err := t.checkCapability("FastGet", key, o); err != nil {
+		return err
+	}
+	key, err = t.transformKey(key)
+	if err != nil {
+		return err
+	}
+	pathKey := t.EncodeKey(key)
+
+	var row map[string]*dynamodb.AttributeValue
+	if len(t.legacySchema) > 0 {
+		row, err = t.getRow(pathKey, o)
+	} else {
+		row, err = t.fastGetItem(pathKey, attributes, o)
+	}
+	if err != nil {
+		return err
+	}
+	if row == nil {
+		return ErrNotFound
+	}
+	if _, ok := t.linkTarget(row); ok {
+		return ErrIsLink
+	}
+	if len(attributes) == 0 {
+		if err := t.verifyChecksum(row); err != nil {
+			return err
+		}
+	}
+	return ob.UnmarshalDynamoDB(row)
+}
+
+// fastGetItem returns the named attributes of the object row at pathKey,
+// with its key, so that a row without them is still found, and the link
+// attribute, so that a link is recognized, or nil if there is no row.
+func (t *Tree) fastGetItem(pathKey string, attributes []string, o *callOptions) (map[string]*dynamodb.AttributeValue, error) {
+	input := &dynamodb.GetItemInput{
+		TableName:      aws.String(t.TableName),
+		ConsistentRead: o.consistent(),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Key":   &dynamodb.AttributeValue{S: aws.String(pathKey)},
+			"Child": &dynamodb.AttributeValue{S: aws.String(t.SpecialCharacter)},
+		},
+	}
+	if len(attributes) > 0 {
+		names := map[string]*string{"#K": aws.String("Key"), "#L": aws.String(t.LinkAttribute)}
+		projection := []string{"#K", "#L"}
+		for i, name := range attributes {
+			placeholder := fmt.Sprintf("#A%d", i)
+			names[placeholder] = aws.String(name)
+			projection = append(projection, placeholder)
+		}
+		input.ProjectionExpression = aws.String(strings.Join(projection, ", "))
+		input.ExpressionAttributeNames = names
+	}
+
+	var db dynamodbiface.DynamoDBAPI = t.DB
+	if t.FastGetDB != nil {
+		// The mapping of Tree.KeySchema is applied by the handlers of DB,
+		// which FastGetDB does not have.
+		db = t.FastGetDB
+		if t.keyMapper != nil {
+			input = t.keyMapper.getItemInput(input)
+		}
+	}
+	resp, err := db.GetItemWithContext(o.context(), input, o.request()...)
+	if err != nil {
+		return nil, err
+	}
+	if t.FastGetDB != nil && t.keyMapper != nil {
+		resp.Item = t.keyMapper.fromTableRow(resp.Item)
+	}
+	if len(resp.Item) == 0 {
+		return nil, nil
+	}
+	return resp.Item, nil
+}
@@ -0,0 +1,54 @@
+package dynamotree
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+// countingGets is a client that counts its GetItem requests, standing in
+// for a DAX client.
+type countingGets struct {
+	dynamodbiface.DynamoDBAPI
+	gets int
+}
+
+func (c *countingGets) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	c.gets++
+	return c.DynamoDBAPI.GetItemWithContext(ctx, input, opts...)
+}
+
+func (suite *StoreImplTest) TestFastGet(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &AccountT{ID: "12345", Name: "alice", Email: "alice@example.com"}), IsNil)
+	c.Assert(s.PutLink([]string{"Users", "alice"}, []string{"Accounts", "12345"}), IsNil)
+
+	v := AccountT{}
+	c.Assert(s.FastGet([]string{"Accounts", "12345"}, []string{"Name"}, &v), IsNil)
+	c.Assert(v, DeepEquals, AccountT{Name: "alice"})
+
+	v = AccountT{}
+	c.Assert(s.FastGet([]string{"Accounts", "12345"}, nil, &v), IsNil)
+	c.Assert(v, DeepEquals, AccountT{ID: "12345", Name: "alice", Email: "alice@example.com"})
+
+	v = AccountT{}
+	c.Assert(s.FastGet([]string{"Accounts", "12345"}, []string{"Missing"}, &v), IsNil)
+	c.Assert(v, DeepEquals, AccountT{})
+
+	c.Assert(s.FastGet([]string{"Users", "alice"}, []string{"Name"}, &v), ErrorIs, ErrIsLink)
+	c.Assert(s.FastGet([]string{"Accounts", "67890"}, []string{"Name"}, &v), ErrorIs, ErrNotFound)
+	c.Assert(s.FastGet([]string{"Accounts"}, []string{"Name"}, &v), ErrorIs, ErrNotFound)
+
+	dax := &countingGets{DynamoDBAPI: db}
+	s.FastGetDB = dax
+	v = AccountT{}
+	c.Assert(s.FastGet([]string{"Accounts", "12345"}, []string{"Name"}, &v), IsNil)
+	c.Assert(v.Name, Equals, "alice")
+	c.Assert(dax.gets, Equals, 1)
+}
@@ -0,0 +1,79 @@
+package dynamotree
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// Find calls fn with the key and attributes of each object below prefix
+// for which cond is true, in the same order as Walk, for example:
+//
+//	tree.Find([]string{"Accounts"}, expression.Name("Email").Equal(expression.Value("alice@example.com")), fn)
+//
+// cond is evaluated by DynamoDB as a filter expression, so only matching
+// objects are returned, but every object below prefix is read and
+// consumes capacity. Symbolic links are not followed, and are never
+// matched. The attributes passed to fn do not include Key and Child, and
+// can be decoded using dynamodbattribute.UnmarshalMap or the
+// UnmarshalDynamoDB method of a Storable.
+//
+// fn should return true to continue iterating or false to stop. If an
+// error occurs, fn is called with a non-nil error.
+func (t *Tree) Find(prefix []string, cond expression.ConditionBuilder,
+	fn func(key []string, item map[string]*dynamodb.AttributeValue, err error) bool, opts ...Option) {
+	findFunc, keyPrefix := fn, prefix
+	fn = func(key []string, item map[string]*dynamodb.AttributeValue, err error) bool {
+		return findFunc(key, item, wrapError("Find", keyPrefix, err))
+	}
+	o, cancel := newCallOptions(opts)
+	defer cancel()
+	o.list = listBudget{} // the limits of List do not apply to each directory
+	if err := t.ready(); err != nil {
+		fn(nil, nil, err)
+		return
+	}
+	prefix, err := t.transformKey(prefix)
+	if err != nil {
+		fn(nil, nil, err)
+		return
+	}
+
+	filter := cond.And(expression.AttributeNotExists(expression.Name(t.LinkAttribute)))
+	if t.LinkAttribute != t.SpecialCharacter {
+		filter = filter.And(expression.AttributeNotExists(expression.Name(t.SpecialCharacter)))
+	}
+
+	t.walk(prefix, func(key []string, err error) bool {
+		if err != nil {
+			return fn(nil, nil, err)
+		}
+		expr, err := expression.NewBuilder().
+			WithKeyCondition(expression.Key("Key").Equal(expression.Value(t.EncodeKey(key))).
+				And(expression.Key("Child").Equal(expression.Value(t.SpecialCharacter)))).
+			WithFilter(filter).
+			Build()
+		if err != nil {
+			return fn(nil, nil, err)
+		}
+		output, err := t.DB.QueryWithContext(o.context(), &dynamodb.QueryInput{
+			TableName:                 aws.String(t.TableName),
+			ConsistentRead:            o.consistent(),
+			KeyConditionExpression:    expr.KeyCondition(),
+			FilterExpression:          expr.Filter(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+		}, o.request()...)
+		if err != nil {
+			return fn(nil, nil, err)
+		}
+		for _, item := range output.Items {
+			delete(item, "Key")
+			delete(item, "Child")
+			if !fn(key, item, nil) {
+				return false
+			}
+		}
+		return true
+	}, o)
+}
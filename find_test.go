@@ -0,0 +1,48 @@
+package dynamotree
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestFind(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+
+	alice := AccountT{ID: "1", Name: "alice", Email: "alice@example.com"}
+	bob := AccountT{ID: "2", Name: "bob", Email: "bob@example.com"}
+	alice2 := AccountT{ID: "3", Name: "alice", Email: "alice@example.org"}
+	c.Assert(s.Put([]string{"Accounts", "1"}, &alice), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "2"}, &bob), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "2", "Old", "3"}, &alice2), IsNil)
+	c.Assert(s.Put([]string{"Other", "1"}, &alice), IsNil)
+	c.Assert(s.PutLink([]string{"Accounts", "alice"}, []string{"Accounts", "1"}), IsNil)
+
+	keys := [][]string{}
+	found := []AccountT{}
+	s.Find([]string{"Accounts"}, expression.Name("Name").Equal(expression.Value("alice")),
+		func(key []string, item map[string]*dynamodb.AttributeValue, err error) bool {
+			c.Assert(err, IsNil)
+			keys = append(keys, key)
+			var v AccountT
+			c.Assert(v.UnmarshalDynamoDB(item), IsNil)
+			found = append(found, v)
+			return true
+		})
+	c.Assert(keys, DeepEquals, [][]string{{"Accounts", "1"}, {"Accounts", "2", "Old", "3"}})
+	c.Assert(found, DeepEquals, []AccountT{alice, alice2})
+
+	keys = [][]string{}
+	s.Find(nil, expression.Name("Email").BeginsWith("alice@"),
+		func(key []string, item map[string]*dynamodb.AttributeValue, err error) bool {
+			c.Assert(err, IsNil)
+			keys = append(keys, key)
+			return false
+		})
+	c.Assert(keys, DeepEquals, [][]string{{"Accounts", "1"}})
+}
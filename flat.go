@@ -0,0 +1,38 @@
+package dynamotree
+
+import "github.com/aws/aws-sdk-go/service/dynamodb"
+
+// FlatDirectories causes Put, PutLink or PutLinkIfAbsent to write the
+// directory entries of key as Tree.FlatDirectories does, whether or not
+// the tree has it set.
+func FlatDirectories() Option {
+	return func(o *callOptions) {
+		o.flat = true
+	}
+}
+
+// flat returns true if the call whose options are o writes only the
+// directory entry of a key in its immediate parent.
+func (t *Tree) flat(o *callOptions) bool {
+	return t.FlatDirectories || (o != nil && o.flat)
+}
+
+// flatten removes from writeRequests, which store an object or link at
+// key, the directory entries of the parts of key above its immediate
+// parent, if the call whose options are o writes flat directories.
+func (t *Tree) flatten(key []string, writeRequests []*dynamodb.WriteRequest, o *callOptions) []*dynamodb.WriteRequest {
+	if !t.flat(o) || len(key) < 2 {
+		return writeRequests
+	}
+	ancestors := make(map[RowID]bool, len(key)-1)
+	for i := 0; i < len(key)-1; i++ {
+		ancestors[RowID{Key: t.dirKey(key[:i]), Child: t.encodePart(key[i])}] = true
+	}
+	rv := make([]*dynamodb.WriteRequest, 0, len(writeRequests))
+	for _, writeRequest := range writeRequests {
+		if writeRequest.PutRequest == nil || !ancestors[rowID(writeRequest)] {
+			rv = append(rv, writeRequest)
+		}
+	}
+	return rv
+}
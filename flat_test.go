@@ -0,0 +1,38 @@
+package dynamotree
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestFlatDirectories(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db, FlatDirectories: true}
+	c.Assert(s.CreateTable(), IsNil)
+
+	key := []string{"Tenants", "t1", "Users", "alice", "Sessions", "s1"}
+	plan, err := s.PlanPut(key, &AccountT{ID: "s1"})
+	c.Assert(err, IsNil)
+	c.Assert(plan.Requests, HasLen, 1)
+	c.Assert(plan.Requests[0].RequestItems[s.TableName], HasLen, 2)
+	c.Assert(s.Put(key, &AccountT{ID: "s1"}), IsNil)
+	c.Assert(s.PutLink([]string{"Tenants", "t1", "Users", "alice", "Sessions", "current"}, key), IsNil)
+
+	var v AccountT
+	c.Assert(s.Get([]string{"Tenants", "t1", "Users", "alice", "Sessions", "current"}, &v), IsNil)
+	c.Assert(v.ID, Equals, "s1")
+	c.Assert(walkKeys(c, s, []string{"Tenants", "t1", "Users", "alice", "Sessions"}), DeepEquals, [][]string{
+		{"Tenants", "t1", "Users", "alice", "Sessions", "current"},
+		{"Tenants", "t1", "Users", "alice", "Sessions", "s1"},
+	})
+	c.Assert(walkKeys(c, s, []string{}), HasLen, 0)
+
+	// The option writes a single call flat.
+	s = &Tree{TableName: s.TableName, DB: db}
+	c.Assert(s.Put([]string{"A", "B", "C"}, &AccountT{ID: "c"}, FlatDirectories()), IsNil)
+	c.Assert(s.Put([]string{"X", "Y", "Z"}, &AccountT{ID: "z"}), IsNil)
+	c.Assert(walkKeys(c, s, []string{}), DeepEquals, [][]string{{"X"}, {"X", "Y"}, {"X", "Y", "Z"}})
+	c.Assert(walkKeys(c, s, []string{"A", "B"}), DeepEquals, [][]string{{"A", "B", "C"}})
+}
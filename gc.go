@@ -0,0 +1,165 @@
+package dynamotree
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// GCResult describes the garbage found (and, unless DryRun was specified,
+// removed) by GC.
+type GCResult struct {
+	// DanglingLinks are the keys of symbolic links whose targets do not exist.
+	DanglingLinks [][]string
+
+	// EmptyDirectories are the keys whose directory entries have neither an
+	// object nor any descendants.
+	EmptyDirectories [][]string
+}
+
+// GC traverses the tree below prefix and removes structural garbage: symbolic
+// links whose targets no longer exist, and directory entries that have no
+// object and no descendants (such as those left behind by Delete).
+//
+// GC is not atomic with respect to concurrent writers. A Put that runs
+// concurrently with GC may have its directory entries removed before the
+// object itself is written, so GC should be run when the part of the tree
+// being collected is not being modified.
+//
+// Given DryRun, GC reports the garbage it finds without removing it. GC
+// stops once the context given by WithContext or WithTimeout is done.
+func (t *Tree) GC(prefix []string, opts ...Option) (result *GCResult, err error) {
+	defer annotateError(&err, "GC", prefix)
+	o, cancel := t.startCall("GC", prefix, &err, opts)
+	defer cancel()
+	if err := refuseCapability("GC", prefix, o); err != nil {
+		return nil, err
+	}
+	if err := t.ready(); err != nil {
+		return nil, err
+	}
+	prefix, err = t.transformKey(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	gc := &collector{tree: t, o: o, result: &GCResult{}}
+	if _, err := gc.collect(prefix); err != nil {
+		return nil, err
+	}
+	if err := gc.flush(); err != nil {
+		return nil, err
+	}
+	return gc.result, nil
+}
+
+type collector struct {
+	tree          *Tree
+	o             *callOptions
+	result        *GCResult
+	writeRequests []*dynamodb.WriteRequest
+}
+
+// collect examines each child of prefix, removing the garbage it
+// finds. It returns true if nothing remains below prefix.
+func (gc *collector) collect(prefix []string) (bool, error) {
+	t := gc.tree
+	dirKey := t.dirKey(prefix)
+	if err := gc.o.context().Err(); err != nil {
+		return false, err
+	}
+
+	children := []string{}
+	var err error
+	t.list(prefix, func(child string, innerErr error) bool {
+		if innerErr != nil {
+			err = innerErr
+			return false
+		}
+		children = append(children, child)
+		return true
+	}, gc.o)
+	if err != nil {
+		return false, err
+	}
+
+	empty := true
+	for _, child := range children {
+		key := make([]string, len(prefix), len(prefix)+1)
+		copy(key, prefix)
+		key = append(key, child)
+
+		live := false
+		leaf, err := t.getRow(t.EncodeKey(key), gc.o)
+		if err != nil {
+			return false, err
+		}
+		if leaf != nil {
+			linkTarget, isLink := t.linkTarget(leaf)
+			if !isLink {
+				live = true
+			} else {
+				target, err := t.getRow(linkTarget, gc.o)
+				if err != nil {
+					return false, err
+				}
+				if target != nil {
+					live = true
+				} else {
+					gc.result.DanglingLinks = append(gc.result.DanglingLinks, key)
+					if err := gc.remove(t.EncodeKey(key), t.SpecialCharacter); err != nil {
+						return false, err
+					}
+				}
+			}
+		}
+
+		childEmpty, err := gc.collect(key)
+		if err != nil {
+			return false, err
+		}
+		if !childEmpty {
+			live = true
+		}
+
+		if live {
+			empty = false
+			continue
+		}
+		gc.result.EmptyDirectories = append(gc.result.EmptyDirectories, key)
+		if err := gc.remove(dirKey, t.childName(key)); err != nil {
+			return false, err
+		}
+	}
+	return empty, nil
+}
+
+// remove queues the row identified by pathKey and childKey for deletion.
+func (gc *collector) remove(pathKey, childKey string) error {
+	if gc.o.dryRun {
+		return nil
+	}
+	gc.writeRequests = append(gc.writeRequests, &dynamodb.WriteRequest{
+		DeleteRequest: &dynamodb.DeleteRequest{
+			Key: map[string]*dynamodb.AttributeValue{
+				"Key": &dynamodb.AttributeValue{
+					S: aws.String(pathKey),
+				},
+				"Child": &dynamodb.AttributeValue{
+					S: aws.String(childKey),
+				},
+			},
+		},
+	})
+	if len(gc.writeRequests) < 25 {
+		return nil
+	}
+	return gc.flush()
+}
+
+func (gc *collector) flush() error {
+	if err := gc.tree.batchWrite(gc.writeRequests, gc.o); err != nil {
+		return err
+	}
+	gc.writeRequests = nil
+	return nil
+}
@@ -0,0 +1,111 @@
+package dynamotree
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestGC(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	err := s.CreateTable()
+	c.Assert(err, IsNil)
+
+	v := AccountT{
+		ID:    "12345",
+		Name:  "alice",
+		Email: "alice@example.com",
+	}
+	err = s.Put([]string{"Accounts", "12345"}, &v)
+	c.Assert(err, IsNil)
+	err = s.Put([]string{"Accounts", "6789"}, &v)
+	c.Assert(err, IsNil)
+	err = s.PutLink([]string{"AccountsByEmail", "alice@example.com"}, []string{"Accounts", "12345"})
+	c.Assert(err, IsNil)
+
+	// nothing to collect yet
+	result, err := s.GC(nil)
+	c.Assert(err, IsNil)
+	c.Assert(result.DanglingLinks, HasLen, 0)
+	c.Assert(result.EmptyDirectories, HasLen, 0)
+
+	err = s.Delete([]string{"Accounts", "12345"})
+	c.Assert(err, IsNil)
+	err = s.Delete([]string{"Accounts", "6789"})
+	c.Assert(err, IsNil)
+
+	expected := &GCResult{
+		DanglingLinks: [][]string{
+			{"AccountsByEmail", "alice@example.com"},
+		},
+		EmptyDirectories: [][]string{
+			{"Accounts"},
+			{"AccountsByEmail", "alice@example.com"},
+			{"AccountsByEmail"},
+		},
+	}
+
+	result, err = s.GC(nil, DryRun())
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, expected)
+
+	_, err = s.GetLink([]string{"AccountsByEmail", "alice@example.com"})
+	c.Assert(err, IsNil)
+
+	result, err = s.GC(nil)
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, expected)
+
+	_, err = s.GetLink([]string{"AccountsByEmail", "alice@example.com"})
+	c.Assert(err, ErrorIs, ErrNotFound)
+
+	items := []string{}
+	s.List(nil, func(item string, err error) bool {
+		c.Assert(err, IsNil)
+		items = append(items, item)
+		return true
+	})
+	c.Assert(items, DeepEquals, []string{})
+}
+
+func (suite *StoreImplTest) TestGCPrefix(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	err := s.CreateTable()
+	c.Assert(err, IsNil)
+
+	v := AccountT{
+		ID:    "12345",
+		Name:  "alice",
+		Email: "alice@example.com",
+	}
+	err = s.Put([]string{"Accounts", "12345", "Links", "xyzpdq"}, &v)
+	c.Assert(err, IsNil)
+	err = s.Delete([]string{"Accounts", "12345", "Links", "xyzpdq"})
+	c.Assert(err, IsNil)
+	err = s.Put([]string{"Other", "abc", "def"}, &v)
+	c.Assert(err, IsNil)
+	err = s.Delete([]string{"Other", "abc", "def"})
+	c.Assert(err, IsNil)
+
+	result, err := s.GC([]string{"Accounts"})
+	c.Assert(err, IsNil)
+	c.Assert(result.DanglingLinks, HasLen, 0)
+	c.Assert(result.EmptyDirectories, DeepEquals, [][]string{
+		{"Accounts", "12345", "Links"},
+		{"Accounts", "12345"},
+	})
+
+	// GC only examines the prefix it is given
+	items := []string{}
+	s.List([]string{"Other"}, func(item string, err error) bool {
+		c.Assert(err, IsNil)
+		items = append(items, item)
+		return true
+	})
+	c.Assert(items, DeepEquals, []string{"abc"})
+}
@@ -0,0 +1,112 @@
+package dynamotree
+
+import (
+	"path"
+	"strings"
+)
+
+// Glob calls fn with each key in the tree that matches pattern, in the
+// same order as Walk. Each part of pattern either names a part of the key
+// exactly or is a pattern using the syntax of path.Match, such as "*" or
+// "abc*", for example:
+//
+//	tree.Glob([]string{"Accounts", "*", "Links", "abc*"}, fn)
+//
+// A part that contains no wildcards is looked up directly. Otherwise the
+// directory is listed, but only the children that start with the
+// pattern's literal prefix are read, unless KeyEncoding is set. If
+// pattern is malformed, fn is called once with path.ErrBadPattern.
+// KeyTransformer is not applied to pattern.
+//
+// fn should return true to continue iterating or false to stop.
+func (t *Tree) Glob(pattern []string, fn func([]string, error) bool, opts ...Option) {
+	globFunc, keyPattern := fn, pattern
+	fn = func(key []string, err error) bool { return globFunc(key, wrapError("Glob", keyPattern, err)) }
+	o, cancel := newCallOptions(opts)
+	defer cancel()
+	o.list = listBudget{} // the limits of List do not apply to each directory
+	if err := refuseCapability("Glob", pattern, o); err != nil {
+		fn(nil, err)
+		return
+	}
+	if err := t.ready(); err != nil {
+		fn(nil, err)
+		return
+	}
+	for _, part := range pattern {
+		if _, err := path.Match(part, ""); err != nil {
+			fn(nil, err)
+			return
+		}
+	}
+	t.glob(nil, pattern, fn, o)
+}
+
+// glob calls fn with the keys below prefix that match pattern, returning
+// false if fn asked to stop.
+func (t *Tree) glob(prefix []string, pattern []string, fn func([]string, error) bool, o *callOptions) bool {
+	if len(pattern) == 0 {
+		return true
+	}
+	part, rest := pattern[0], pattern[1:]
+
+	children := []string{}
+	if !hasMeta(part) {
+		row, err := t.getItem(t.dirKey(prefix), t.encodePart(part), o)
+		if err != nil {
+			return fn(nil, err)
+		}
+		if row != nil {
+			children = append(children, part)
+		}
+	} else {
+		namePrefix := ""
+		if t.KeyEncoding == KeyEncodingNone {
+			namePrefix = literalPrefix(part)
+		}
+		var err error
+		t.listChildren(prefix, namePrefix, func(child string, innerErr error) bool {
+			if innerErr != nil {
+				err = innerErr
+				return false
+			}
+			if matched, _ := path.Match(part, child); matched {
+				children = append(children, child)
+			}
+			return true
+		}, o)
+		if err != nil {
+			return fn(nil, err)
+		}
+	}
+
+	for _, child := range children {
+		key := make([]string, len(prefix), len(prefix)+1)
+		copy(key, prefix)
+		key = append(key, child)
+		if len(rest) == 0 {
+			if !fn(key, nil) {
+				return false
+			}
+			continue
+		}
+		if !t.glob(key, rest, fn, o) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasMeta returns true if part contains any of the characters that
+// path.Match treats specially.
+func hasMeta(part string) bool {
+	return strings.ContainsAny(part, `*?[\`)
+}
+
+// literalPrefix returns the part of pattern before its first wildcard.
+func literalPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
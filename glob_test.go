@@ -0,0 +1,72 @@
+package dynamotree
+
+import (
+	"path"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestGlob(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+
+	v := AccountT{ID: "12345", Name: "alice"}
+	for _, key := range [][]string{
+		{"Accounts", "1", "Links", "abc123"},
+		{"Accounts", "1", "Links", "xyz"},
+		{"Accounts", "2", "Links", "abc456"},
+		{"Accounts", "2", "Profile"},
+		{"Accounts", "3", "Other", "abc123"},
+	} {
+		c.Assert(s.Put(key, &v), IsNil)
+	}
+
+	glob := func(pattern ...string) [][]string {
+		keys := [][]string{}
+		s.Glob(pattern, func(key []string, err error) bool {
+			c.Assert(err, IsNil)
+			keys = append(keys, key)
+			return true
+		})
+		return keys
+	}
+
+	c.Assert(glob("Accounts", "*", "Links", "abc*"), DeepEquals, [][]string{
+		{"Accounts", "1", "Links", "abc123"},
+		{"Accounts", "2", "Links", "abc456"},
+	})
+	c.Assert(glob("Accounts", "*", "*", "abc123"), DeepEquals, [][]string{
+		{"Accounts", "1", "Links", "abc123"},
+		{"Accounts", "3", "Other", "abc123"},
+	})
+	c.Assert(glob("Accounts", "[23]"), DeepEquals, [][]string{
+		{"Accounts", "2"},
+		{"Accounts", "3"},
+	})
+	c.Assert(glob("Accounts", "2", "Profile"), DeepEquals, [][]string{{"Accounts", "2", "Profile"}})
+	c.Assert(glob("Accounts", "4", "*"), DeepEquals, [][]string{})
+
+	var err error
+	s.Glob([]string{"Accounts", "[1"}, func(key []string, innerErr error) bool {
+		err = innerErr
+		return false
+	})
+	c.Assert(err, ErrorIs, path.ErrBadPattern)
+
+	// with KeyEncoding the names are matched once they are decoded
+	s2 := &Tree{TableName: uniuri.New(), DB: db, KeyEncoding: KeyEncodingHex}
+	c.Assert(s2.CreateTable(), IsNil)
+	c.Assert(s2.Put([]string{"Accounts", "a¦b"}, &v), IsNil)
+	keys := [][]string{}
+	s2.Glob([]string{"Accounts", "a*"}, func(key []string, err error) bool {
+		c.Assert(err, IsNil)
+		keys = append(keys, key)
+		return true
+	})
+	c.Assert(keys, DeepEquals, [][]string{{"Accounts", "a¦b"}})
+}
@@ -0,0 +1,143 @@
+package dynamotree
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// GuardMode is the kind of protection a WriteGuard gives the keys below
+// its prefix.
+type GuardMode int
+
+const (
+	// GuardImmutable rejects every Put, PutLink, Delete and AppendEvent.
+	GuardImmutable GuardMode = iota
+
+	// GuardAppendOnly allows objects and links to be created, and events
+	// to be appended, but not replaced or deleted.
+	GuardAppendOnly
+
+	// GuardAdminOnly allows writes only by calls given the AsAdmin
+	// option.
+	GuardAdminOnly
+)
+
+func (m GuardMode) String() string {
+	switch m {
+	case GuardImmutable:
+		return "immutable"
+	case GuardAppendOnly:
+		return "append-only"
+	case GuardAdminOnly:
+		return "admin-only"
+	}
+	return fmt.Sprintf("GuardMode(%d)", int(m))
+}
+
+// WriteGuard protects the keys at and below Prefix, for example:
+//
+//	tree.WriteGuards = []dynamotree.WriteGuard{
+//	    {Prefix: []string{".audit"}, Mode: dynamotree.GuardAppendOnly},
+//	    {Prefix: []string{"System"}, Mode: dynamotree.GuardAdminOnly},
+//	}
+//
+// Prefix is compared with keys once KeyTransformer has been applied to
+// them, that is, with the keys as they are stored.
+type WriteGuard struct {
+	Prefix []string
+	Mode   GuardMode
+}
+
+// ErrWriteGuarded is matched, using errors.Is, by the *GuardError returned
+// when a write is rejected by one of Tree.WriteGuards.
+var ErrWriteGuarded = errors.New("write rejected by guard")
+
+// GuardError is returned when a write to Key is rejected by Guard. If the
+// guard is GuardAppendOnly and the object already existed, Err is the
+// *ConditionFailedError of the write.
+type GuardError struct {
+	Op    string
+	Key   []string
+	Guard WriteGuard
+	Err   error
+}
+
+func (e *GuardError) Error() string {
+	return fmt.Sprintf("%s %q: %q is %s", e.Op, strings.Join(e.Key, "/"),
+		strings.Join(e.Guard.Prefix, "/"), e.Guard.Mode)
+}
+
+// Is returns true if target is ErrWriteGuarded.
+func (e *GuardError) Is(target error) bool { return target == ErrWriteGuarded }
+
+// Unwrap returns the error of the rejected write, if any.
+func (e *GuardError) Unwrap() error { return e.Err }
+
+// AsAdmin allows a call to write below the prefixes protected by a
+// WriteGuard of any mode. It is intended for maintenance, such as
+// correcting or expiring records that are otherwise immutable.
+func AsAdmin() Option {
+	return func(o *callOptions) {
+		o.admin = true
+	}
+}
+
+// Kinds of write checked by checkGuards.
+const (
+	guardCreate = iota // Put or PutLink
+	guardDelete        // Delete
+	guardAppend        // AppendEvent
+)
+
+// checkGuards checks that a write of the given kind to key is allowed by
+// t.WriteGuards. If the write is a Put or PutLink that is allowed only if
+// nothing is stored at key, it adds that condition to o and returns the
+// guard, so that the caller can report a failure of the condition.
+func (t *Tree) checkGuards(key []string, kind int, o *callOptions) (*WriteGuard, error) {
+	if o.admin {
+		return nil, nil
+	}
+	var appendOnly *WriteGuard
+	for i := range t.WriteGuards {
+		guard := &t.WriteGuards[i]
+		if !hasKeyPrefix(key, guard.Prefix) {
+			continue
+		}
+		switch {
+		case guard.Mode == GuardAppendOnly && kind == guardCreate:
+			appendOnly = guard
+		case guard.Mode == GuardAppendOnly && kind == guardAppend:
+		default:
+			return nil, &GuardError{Key: key, Guard: *guard}
+		}
+	}
+	if appendOnly != nil {
+		WithCondition(expression.AttributeNotExists(expression.Name("Key")))(o)
+	}
+	return appendOnly, nil
+}
+
+// guardFailed returns err, or a *GuardError if err is the failure of the
+// condition that guard added to a write.
+func guardFailed(guard *WriteGuard, key []string, err error) error {
+	if guard != nil && errors.Is(err, ErrConditionFailed) {
+		return &GuardError{Key: key, Guard: *guard, Err: err}
+	}
+	return err
+}
+
+// hasKeyPrefix returns true if the first parts of key are prefix.
+func hasKeyPrefix(key, prefix []string) bool {
+	if len(key) < len(prefix) {
+		return false
+	}
+	for i, part := range prefix {
+		if key[i] != part {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package dynamotree
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestWriteGuards(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db, WriteGuards: []WriteGuard{
+		{Prefix: []string{".audit"}, Mode: GuardAppendOnly},
+		{Prefix: []string{"System"}, Mode: GuardAdminOnly},
+		{Prefix: []string{"Frozen"}, Mode: GuardImmutable},
+	}}
+	c.Assert(s.CreateTable(), IsNil)
+	v := AccountT{ID: "12345", Name: "alice"}
+
+	// append-only
+	key := []string{".audit", "1"}
+	c.Assert(s.Put(key, &v), IsNil)
+	err := s.Put(key, &v)
+	c.Assert(err, ErrorIs, ErrWriteGuarded)
+	c.Assert(err, ErrorIs, ErrConditionFailed)
+	c.Assert(err, ErrorMatches, `Put ".audit/1": ".audit" is append-only`)
+	c.Assert(s.PutLink(key, []string{"Accounts", "1"}), ErrorIs, ErrWriteGuarded)
+	c.Assert(s.Delete(key), ErrorIs, ErrWriteGuarded)
+	_, err = s.AppendEvent(key, &v)
+	c.Assert(err, IsNil)
+	c.Assert(s.PutLink([]string{".audit", "2"}, []string{"Accounts", "1"}), IsNil)
+	c.Assert(s.PutLinkIfAbsent([]string{".audit", "3"}, []string{"Accounts", "1"}), IsNil)
+	err = s.PutLinkIfAbsent([]string{".audit", "3"}, []string{"Accounts", "2"})
+	c.Assert(err, ErrorIs, ErrWriteGuarded)
+	c.Assert(err, ErrorMatches, `PutLinkIfAbsent ".audit/3": ".audit" is append-only`)
+	c.Assert(s.PutLinkIfAbsent(key, []string{"Accounts", "1"}), ErrorIs, ErrNotLink)
+
+	// admin-only
+	key = []string{"System", "config"}
+	c.Assert(s.Put(key, &v), ErrorIs, ErrWriteGuarded)
+	c.Assert(s.Put(key, &v, AsAdmin()), IsNil)
+	c.Assert(s.Delete(key), ErrorIs, ErrWriteGuarded)
+	c.Assert(s.Delete(key, AsAdmin()), IsNil)
+
+	// immutable
+	key = []string{"Frozen", "a", "b"}
+	c.Assert(s.Put(key, &v), ErrorIs, ErrWriteGuarded)
+	_, err = s.AppendEvent(key, &v)
+	c.Assert(err, ErrorIs, ErrWriteGuarded)
+	c.Assert(s.Put(key, &v, AsAdmin()), IsNil)
+	c.Assert(s.Delete(key), ErrorIs, ErrWriteGuarded)
+
+	// keys that merely share a prefix of a part are not guarded
+	c.Assert(s.Put([]string{"Systems", "1"}, &v), IsNil)
+	c.Assert(s.Put([]string{"Systems", "1"}, &v), IsNil)
+}
@@ -0,0 +1,300 @@
+package dynamotree
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHeatWindow is the Window of a HeatSampler whose Window is zero.
+const DefaultHeatWindow = time.Minute
+
+// DefaultHeatMaxKeys is the MaxKeys of a HeatSampler whose MaxKeys is zero.
+const DefaultHeatMaxKeys = 10000
+
+// DefaultHeatTop is the number of keys and prefixes reported by
+// HeatSampler.ServeHTTP when the request does not give one.
+const DefaultHeatTop = 20
+
+// HeatSampler counts the reads and writes of the keys given to a tree's
+// operations, as set by Tree.HeatSampler, so that the hottest keys and
+// prefixes, which are the likeliest to be throttled, can be found without
+// enabling CloudWatch Contributor Insights on the table. Each call to one
+// of the operations reported to Tree.OnOperation is counted once, against
+// the key given to it, as a write if it is to Put, PutLink,
+// PutLinkIfAbsent, Delete, DeleteAll, DeleteChildren or AppendEvent and
+// otherwise as a read, whether or not it succeeds. A call that reads or
+// writes many keys, such as Walk or DeleteAll, is counted only against the
+// key given to it.
+//
+// Counts are kept for the current Window and the one before it, so that
+// Top reports between one and two Windows of calls. A HeatSampler may be
+// used by several trees, and its methods by several goroutines, at once.
+// It is also an http.Handler that serves the report of Top as JSON, with
+// the number of keys and prefixes given by the query parameter n.
+type HeatSampler struct {
+	// SampleRate is the fraction of calls counted, between 0 and 1, such as
+	// 0.01 to count one call in a hundred. The counts reported are scaled
+	// up to estimate the number of calls. If it is zero, every call is
+	// counted.
+	SampleRate float64
+
+	// Window is the length of the windows over which calls are counted. If
+	// it is zero, DefaultHeatWindow is used.
+	Window time.Duration
+
+	// PrefixDepth is the number of parts of the longest prefix of each key
+	// that is counted; each shorter prefix is counted too. If it is zero,
+	// every prefix of the key, other than the key itself, is counted.
+	PrefixDepth int
+
+	// MaxKeys is the number of keys, and separately of prefixes, counted
+	// in each window. Once it is reached, the least counted of a small
+	// random sample of them is dropped to make room for each new one, so
+	// the counts of those that are not among the hottest may be low, and a
+	// key that is not the least counted may be dropped. If it is zero,
+	// DefaultHeatMaxKeys is used.
+	MaxKeys int
+
+	// Clock, if not nil, is used in place of the system clock to decide
+	// when each window ends.
+	Clock Clock
+
+	mu       sync.Mutex
+	start    time.Time
+	current  *heatWindow
+	previous *heatWindow
+}
+
+// HeatReport is the report of a HeatSampler on the calls it counted.
+type HeatReport struct {
+	// Start and End are the times between which the calls were counted.
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	// Sampled is the number of calls counted.
+	Sampled int64 `json:"sampled"`
+
+	// Keys are the hottest keys, and Prefixes the hottest prefixes, the
+	// hottest first.
+	Keys     []HeatCount `json:"keys"`
+	Prefixes []HeatCount `json:"prefixes"`
+}
+
+// HeatCount is the estimated number of reads and writes of a key or
+// prefix.
+type HeatCount struct {
+	Key    []string `json:"key"`
+	Reads  int64    `json:"reads"`
+	Writes int64    `json:"writes"`
+}
+
+// Total returns the number of reads and writes counted.
+func (c HeatCount) Total() int64 { return c.Reads + c.Writes }
+
+// heatWindow holds the counts of a window.
+type heatWindow struct {
+	sampled  int64
+	keys     map[string]*HeatCount
+	prefixes map[string]*HeatCount
+}
+
+// heatWriteOperations are the operations counted as writes.
+var heatWriteOperations = map[string]bool{
+	"Put":             true,
+	"PutLink":         true,
+	"PutLinkIfAbsent": true,
+	"Delete":          true,
+	"DeleteAll":       true,
+	"DeleteChildren":  true,
+	"AppendEvent":     true,
+}
+
+// sampleHeat counts the call to op on key in the tree's HeatSampler, if it
+// has one and the call is sampled.
+func (t *Tree) sampleHeat(op string, key []string) {
+	h := t.HeatSampler
+	if h == nil {
+		return
+	}
+	if h.SampleRate > 0 && h.SampleRate < 1 && float64(t.randomIntn(1<<30)) >= h.SampleRate*(1<<30) {
+		return
+	}
+	h.observe(op, key)
+}
+
+func (h *HeatSampler) now() time.Time {
+	if h.Clock == nil {
+		return time.Now()
+	}
+	return h.Clock.Now()
+}
+
+func (h *HeatSampler) window() time.Duration {
+	if h.Window <= 0 {
+		return DefaultHeatWindow
+	}
+	return h.Window
+}
+
+func (h *HeatSampler) maxKeys() int {
+	if h.MaxKeys <= 0 {
+		return DefaultHeatMaxKeys
+	}
+	return h.MaxKeys
+}
+
+// rotate begins a new window if the current one has ended by now. h.mu
+// must be held.
+func (h *HeatSampler) rotate(now time.Time) {
+	window := h.window()
+	switch {
+	case h.current == nil || !now.Before(h.start.Add(2*window)):
+		h.start, h.previous = now, nil
+	case !now.Before(h.start.Add(window)):
+		h.start, h.previous = h.start.Add(window), h.current
+	default:
+		return
+	}
+	h.current = &heatWindow{keys: map[string]*HeatCount{}, prefixes: map[string]*HeatCount{}}
+}
+
+// observe counts a call to op on key.
+func (h *HeatSampler) observe(op string, key []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.rotate(h.now())
+	write := heatWriteOperations[op]
+	h.current.sampled++
+	h.count(h.current.keys, key, write)
+	depth := len(key) - 1
+	if h.PrefixDepth > 0 && h.PrefixDepth < depth {
+		depth = h.PrefixDepth
+	}
+	for i := 1; i <= depth; i++ {
+		h.count(h.current.prefixes, key[:i], write)
+	}
+}
+
+// heatEvictionSample is the number of entries among which count looks for
+// the least counted, so that making room for a key takes the same time
+// however large MaxKeys is.
+const heatEvictionSample = 8
+
+// count adds a read or write of key to counts, dropping the least counted
+// of a sample of its entries to make room for it if counts holds MaxKeys
+// entries. Go's map iteration starts at a random entry, so the sample
+// differs from each call to the next.
+func (h *HeatSampler) count(counts map[string]*HeatCount, key []string, write bool) {
+	name := strings.Join(key, "\x00")
+	c, ok := counts[name]
+	if !ok {
+		if len(counts) >= h.maxKeys() {
+			var least string
+			var leastCount *HeatCount
+			sampled := 0
+			for name, c := range counts {
+				if leastCount == nil || c.Total() < leastCount.Total() {
+					least, leastCount = name, c
+				}
+				if sampled++; sampled == heatEvictionSample {
+					break
+				}
+			}
+			delete(counts, least)
+		}
+		c = &HeatCount{Key: append([]string(nil), key...)}
+		counts[name] = c
+	}
+	if write {
+		c.Writes++
+	} else {
+		c.Reads++
+	}
+}
+
+// Top reports the n hottest keys and the n hottest prefixes counted in the
+// current window and the one before it.
+func (h *HeatSampler) Top(n int) *HeatReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+	h.rotate(now)
+	rv := &HeatReport{Start: h.start, End: now}
+	windows := []*heatWindow{h.current}
+	if h.previous != nil {
+		rv.Start = h.start.Add(-h.window())
+		windows = append(windows, h.previous)
+	}
+	scale := 1.0
+	if h.SampleRate > 0 && h.SampleRate < 1 {
+		scale = 1 / h.SampleRate
+	}
+	keys := make([]map[string]*HeatCount, len(windows))
+	prefixes := make([]map[string]*HeatCount, len(windows))
+	for i, w := range windows {
+		rv.Sampled += w.sampled
+		keys[i], prefixes[i] = w.keys, w.prefixes
+	}
+	rv.Keys = topHeat(keys, n, scale)
+	rv.Prefixes = topHeat(prefixes, n, scale)
+	return rv
+}
+
+// topHeat returns the n entries of the sum of counts with the highest
+// totals, scaled by scale.
+func topHeat(counts []map[string]*HeatCount, n int, scale float64) []HeatCount {
+	sum := map[string]*HeatCount{}
+	for _, m := range counts {
+		for name, c := range m {
+			s, ok := sum[name]
+			if !ok {
+				s = &HeatCount{Key: c.Key}
+				sum[name] = s
+			}
+			s.Reads += c.Reads
+			s.Writes += c.Writes
+		}
+	}
+	rv := make([]HeatCount, 0, len(sum))
+	for _, s := range sum {
+		rv = append(rv, *s)
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		if rv[i].Total() != rv[j].Total() {
+			return rv[i].Total() > rv[j].Total()
+		}
+		return strings.Join(rv[i].Key, "\x00") < strings.Join(rv[j].Key, "\x00")
+	})
+	if n >= 0 && len(rv) > n {
+		rv = rv[:n]
+	}
+	for i := range rv {
+		rv[i].Reads = int64(math.Round(float64(rv[i].Reads) * scale))
+		rv[i].Writes = int64(math.Round(float64(rv[i].Writes) * scale))
+	}
+	return rv
+}
+
+func (h *HeatSampler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	n := DefaultHeatTop
+	if s := r.URL.Query().Get("n"); s != "" {
+		var err error
+		if n, err = strconv.Atoi(s); err != nil || n < 0 {
+			http.Error(w, "invalid n", http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.Top(n))
+}
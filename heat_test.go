@@ -0,0 +1,97 @@
+package dynamotree
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestHeatSampler(c *C) {
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	h := &HeatSampler{Window: time.Minute, PrefixDepth: 2, Clock: clock}
+	s := &Tree{TableName: uniuri.New(), DB: dynamodb.New(session.New(), testConfig), HeatSampler: h}
+	c.Assert(s.CreateTable(), IsNil)
+
+	for i := 0; i < 3; i++ {
+		c.Assert(s.Put([]string{"Tenants", "a", "Users", "1"}, &AccountT{ID: "1"}), IsNil)
+	}
+	c.Assert(s.Put([]string{"Tenants", "b", "Users", "2"}, &AccountT{ID: "2"}), IsNil)
+	v := AccountT{}
+	c.Assert(s.Get([]string{"Tenants", "a", "Users", "1"}, &v), IsNil)
+	c.Assert(s.Get([]string{"Tenants", "a", "Users", "3"}, &v), ErrorIs, ErrNotFound)
+
+	report := h.Top(2)
+	c.Assert(report.Sampled, Equals, int64(6))
+	c.Assert(report.Start.Equal(clock.Now()), Equals, true)
+	c.Assert(report.Keys, DeepEquals, []HeatCount{
+		{Key: []string{"Tenants", "a", "Users", "1"}, Reads: 1, Writes: 3},
+		{Key: []string{"Tenants", "a", "Users", "3"}, Reads: 1},
+	})
+	c.Assert(report.Prefixes, DeepEquals, []HeatCount{
+		{Key: []string{"Tenants"}, Reads: 2, Writes: 4},
+		{Key: []string{"Tenants", "a"}, Reads: 2, Writes: 3},
+	})
+
+	// The previous window is reported with the current one, and then
+	// forgotten.
+	clock.now = clock.now.Add(90 * time.Second)
+	c.Assert(s.Delete([]string{"Tenants", "b", "Users", "2"}), IsNil)
+	report = h.Top(1)
+	c.Assert(report.Sampled, Equals, int64(7))
+	c.Assert(report.End.Sub(report.Start), Equals, 90*time.Second)
+	clock.now = clock.now.Add(time.Minute)
+	report = h.Top(5)
+	c.Assert(report.Sampled, Equals, int64(1))
+	c.Assert(report.Keys, DeepEquals, []HeatCount{{Key: []string{"Tenants", "b", "Users", "2"}, Writes: 1}})
+
+	// Sampled counts are scaled up to estimate the calls made.
+	clock.now = clock.now.Add(time.Hour)
+	h.SampleRate = 0.25
+	s.Rand = bytes.NewReader(make([]byte, 64))
+	c.Assert(s.Get([]string{"Tenants", "a", "Users", "1"}, &v), IsNil)
+	c.Assert(h.Top(1).Keys, DeepEquals, []HeatCount{{Key: []string{"Tenants", "a", "Users", "1"}, Reads: 4}})
+
+	// The least counted key is dropped to make room for a new one.
+	clock.now = clock.now.Add(time.Hour)
+	h.SampleRate, h.MaxKeys = 0, 2
+	h.observe("Get", []string{"x"})
+	h.observe("Get", []string{"x"})
+	h.observe("Get", []string{"y"})
+	h.observe("Put", []string{"z"})
+	c.Assert(h.Top(-1).Keys, DeepEquals, []HeatCount{{Key: []string{"x"}, Reads: 2}, {Key: []string{"z"}, Writes: 1}})
+
+	// Making room looks at a sample of the keys, which keeps the hottest.
+	clock.now = clock.now.Add(time.Hour)
+	h.MaxKeys = 100
+	for i := 0; i < 50; i++ {
+		h.observe("Get", []string{"hot"})
+	}
+	for i := 0; i < 1000; i++ {
+		h.observe("Get", []string{fmt.Sprint(i)})
+	}
+	c.Assert(len(h.current.keys) <= 100, Equals, true)
+	c.Assert(h.Top(1).Keys, DeepEquals, []HeatCount{{Key: []string{"hot"}, Reads: 50}})
+	clock.now = clock.now.Add(time.Hour)
+	h.MaxKeys = 2
+	h.observe("Get", []string{"x"})
+	h.observe("Get", []string{"x"})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?n=1", nil))
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(w.Header().Get("Content-Type"), Equals, "application/json")
+	var served HeatReport
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &served), IsNil)
+	c.Assert(served.Keys, DeepEquals, []HeatCount{{Key: []string{"x"}, Reads: 2}})
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?n=many", nil))
+	c.Assert(w.Code, Equals, http.StatusBadRequest)
+}
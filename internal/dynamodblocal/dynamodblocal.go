@@ -0,0 +1,113 @@
+// Package dynamodblocal locates or starts an instance of DynamoDB Local
+// (https://docs.aws.amazon.com/amazondynamodb/latest/developerguide/DynamoDBLocal.html)
+// for use in tests.
+package dynamodblocal
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// EndpointEnv is the name of an environment variable that, if set, gives
+// the URL of a running DynamoDB Local instance, e.g. "http://localhost:8000".
+const EndpointEnv = "DYNAMODB_LOCAL_ENDPOINT"
+
+// DefaultEndpoint is the endpoint where DynamoDB Local listens by default.
+// If EndpointEnv is not set and something is listening here, it is used.
+const DefaultEndpoint = "http://localhost:8000"
+
+// Image is the docker image started when no running instance is found.
+const Image = "amazon/dynamodb-local"
+
+// StartTimeout is how long Start waits for a newly started container to
+// accept connections.
+var StartTimeout = 30 * time.Second
+
+// Server is a DynamoDB Local instance.
+type Server struct {
+	// Endpoint is the URL of the instance.
+	Endpoint string
+
+	// Config is the configuration needed to talk to the instance.
+	Config *aws.Config
+
+	containerID string
+}
+
+// Start returns a DynamoDB Local instance. It uses the endpoint given by
+// EndpointEnv if that is set, otherwise an instance listening on
+// DefaultEndpoint, otherwise it starts a new docker container running
+// Image. Call Close when finished to stop any container that was started.
+func Start() (*Server, error) {
+	if endpoint := os.Getenv(EndpointEnv); endpoint != "" {
+		return newServer(endpoint, ""), nil
+	}
+	if reachable(DefaultEndpoint) {
+		return newServer(DefaultEndpoint, ""), nil
+	}
+
+	output, err := exec.Command("docker", "run", "-d", "--rm", "-p", "127.0.0.1::8000", Image).Output()
+	if err != nil {
+		return nil, fmt.Errorf("dynamodblocal: docker run: %s", err)
+	}
+	containerID := strings.TrimSpace(string(output))
+
+	output, err = exec.Command("docker", "port", containerID, "8000").Output()
+	if err != nil {
+		exec.Command("docker", "rm", "-f", containerID).Run()
+		return nil, fmt.Errorf("dynamodblocal: docker port: %s", err)
+	}
+	hostPort := strings.TrimSpace(strings.SplitN(string(output), "\n", 2)[0])
+	s := newServer("http://"+hostPort, containerID)
+
+	deadline := time.Now().Add(StartTimeout)
+	for !reachable(s.Endpoint) {
+		if time.Now().After(deadline) {
+			s.Close()
+			return nil, fmt.Errorf("dynamodblocal: timed out waiting for %s", s.Endpoint)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return s, nil
+}
+
+func newServer(endpoint string, containerID string) *Server {
+	return &Server{
+		Endpoint: endpoint,
+		Config: aws.NewConfig().
+			WithEndpoint(endpoint).
+			WithRegion("us-east-1").
+			WithCredentials(credentials.NewStaticCredentials("dynamotree", "dynamotree", "")),
+		containerID: containerID,
+	}
+}
+
+// Close stops the docker container if Start created one.
+func (s *Server) Close() error {
+	if s.containerID == "" {
+		return nil
+	}
+	return exec.Command("docker", "rm", "-f", s.containerID).Run()
+}
+
+// reachable returns true if something accepts TCP connections at endpoint.
+func reachable(endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return false
+	}
+	conn, err := net.DialTimeout("tcp", u.Host, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
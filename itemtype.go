@@ -0,0 +1,69 @@
+package dynamotree
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// ItemTypeAttribute is the attribute in which Tree.ItemType is stored.
+const ItemTypeAttribute = "ItemType"
+
+// The placeholders for the type attribute and its value in the expressions
+// that a keyMapper adds to requests.
+const (
+	typeName  = "#dtType"
+	typeValue = ":dtType"
+)
+
+// keySchema returns the mapping the tree applies to its requests, which is
+// KeySchema with the type attribute given by ItemType, or nil if there is
+// none.
+func (t *Tree) keySchema() *KeySchemaMapping {
+	if t.ItemType == "" {
+		return t.KeySchema
+	}
+	m := KeySchemaMapping{}
+	if t.KeySchema != nil {
+		m = *t.KeySchema
+	}
+	m.TypeAttribute = ItemTypeAttribute
+	m.TypeValue = t.ItemType
+	return &m
+}
+
+// typeFilter adds, in place, a condition on the type attribute to a filter
+// expression, so that a query or scan returns only the tree's rows.
+func (km *keyMapper) typeFilter(names *map[string]*string, values *map[string]*dynamodb.AttributeValue, filter **string) {
+	if km.m.TypeAttribute == "" {
+		return
+	}
+	if *names == nil {
+		*names = map[string]*string{}
+	}
+	if *values == nil {
+		*values = map[string]*dynamodb.AttributeValue{}
+	}
+	(*names)[typeName] = aws.String(km.m.TypeAttribute)
+	(*values)[typeValue] = &dynamodb.AttributeValue{S: aws.String(km.m.TypeValue)}
+	condition := typeName + " = " + typeValue
+	if aws.StringValue(*filter) != "" {
+		condition = "(" + **filter + ") AND " + condition
+	}
+	*filter = aws.String(condition)
+}
+
+// typeProjection adds, in place, the type attribute to a projection
+// expression, if there is one, so that the rows fetched can be recognized
+// as the tree's.
+func (km *keyMapper) typeProjection(names *map[string]*string, projection **string) {
+	if km.m.TypeAttribute == "" || *projection == nil || strings.TrimSpace(**projection) == "" {
+		return
+	}
+	if *names == nil {
+		*names = map[string]*string{}
+	}
+	(*names)[typeName] = aws.String(km.m.TypeAttribute)
+	*projection = aws.String(**projection + ", " + typeName)
+}
@@ -0,0 +1,88 @@
+package dynamotree
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestItemType(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db, ItemType: "dynamotree"}
+	c.Assert(s.CreateTable(), IsNil)
+
+	// Rows of another application that happen to look like the tree's.
+	others := []map[string]*dynamodb.AttributeValue{
+		{"Key": {S: aws.String("¦Accounts")}, "Child": {S: aws.String("999")}},
+		{"Key": {S: aws.String("¦Accounts¦999")}, "Child": {S: aws.String("¦")}, "Name": {S: aws.String("bob")}},
+	}
+	for _, item := range others {
+		_, err := db.PutItem(&dynamodb.PutItemInput{TableName: aws.String(s.TableName), Item: item})
+		c.Assert(err, IsNil)
+	}
+
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &AccountT{ID: "12345", Name: "alice"}), IsNil)
+	resp, err := db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Key":   {S: aws.String("¦Accounts¦12345")},
+			"Child": {S: aws.String("¦")},
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(aws.StringValue(resp.Item[ItemTypeAttribute].S), Equals, "dynamotree")
+
+	v := AccountT{}
+	c.Assert(s.Get([]string{"Accounts", "12345"}, &v), IsNil)
+	c.Assert(v, DeepEquals, AccountT{ID: "12345", Name: "alice"})
+	c.Assert(s.Get([]string{"Accounts", "999"}, &v), ErrorIs, ErrNotFound)
+	c.Assert(s.FastGet([]string{"Accounts", "999"}, []string{"Name"}, &v), ErrorIs, ErrNotFound)
+	c.Assert(walkKeys(c, s, []string{}), DeepEquals, [][]string{
+		{"Accounts"},
+		{"Accounts", "12345"},
+	})
+	for segment := 0; segment < integritySegments; segment++ {
+		problems, err := s.checkSegment(context.Background(), segment, 100)
+		c.Assert(err, IsNil)
+		c.Assert(problems, HasLen, 0)
+	}
+
+	// Deleting the tree leaves the other rows alone.
+	c.Assert(s.DeleteAll([]string{}), IsNil)
+	for _, item := range others {
+		resp, err := db.GetItem(&dynamodb.GetItemInput{
+			TableName: aws.String(s.TableName),
+			Key:       map[string]*dynamodb.AttributeValue{"Key": item["Key"], "Child": item["Child"]},
+		})
+		c.Assert(err, IsNil)
+		c.Assert(resp.Item, DeepEquals, item)
+	}
+
+	_, err = NewTree(uniuri.New(), db, func(t *Tree) {
+		t.ItemType = "dynamotree"
+		t.KeySchema = &KeySchemaMapping{TypeAttribute: "EntityType", TypeValue: "tree"}
+	})
+	c.Assert(err, ErrorMatches, `.*Tree.ItemType cannot be used because KeySchema.TypeAttribute is also set`)
+
+	_, err = NewTree(uniuri.New(), db, func(t *Tree) {
+		t.ItemType = "dynamotree"
+		t.KeySchema = &KeySchemaMapping{HashAttribute: "PK", RangeAttribute: ItemTypeAttribute}
+	})
+	c.Assert(err, ErrorMatches, `.*Tree.ItemType cannot be used because its attribute "ItemType" is a key attribute`)
+}
+
+func (suite *StoreImplTest) TestItemTypeReservedAttribute(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db, ItemType: "dynamotree"}
+	c.Assert(s.CreateTable(), IsNil)
+
+	key := []string{"Things", "1"}
+	err := s.Put(key, rawItem{ItemTypeAttribute: {S: aws.String("widget")}})
+	c.Assert(err, ErrorIs, ErrReservedAttribute)
+	c.Assert(err, ErrorMatches, `Put "Things/1": attribute name "ItemType" is reserved`)
+	c.Assert(s.Get(key, &AccountT{}), ErrorIs, ErrNotFound)
+}
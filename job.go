@@ -0,0 +1,354 @@
+package dynamotree
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Progress describes the progress of a long-running operation, such as
+// DeleteAll, DeleteChildren, Copy, Sync, ExportArchive, ImportArchive or
+// Walk, as reported to the function given using WithProgress.
+type Progress struct {
+	Op string
+
+	// Items is the number of keys processed, including those processed
+	// before the operation was resumed from a checkpoint.
+	Items int
+
+	// Total is the number of keys expected, as given using ExpectedItems,
+	// or zero if it is not known.
+	Total int
+
+	// Key is the last key processed.
+	Key []string
+
+	// Elapsed is the time since the operation, or its resumption, began.
+	Elapsed time.Duration
+
+	// ETA is the estimated time until the operation completes, based on
+	// the rate at which keys have been processed since it began or was
+	// resumed. It is zero if Total is not known.
+	ETA time.Duration
+
+	// Resumed is true if the operation was resumed from a checkpoint.
+	Resumed bool
+}
+
+// WithProgress causes a long-running operation to call fn after each key
+// it processes.
+func WithProgress(fn func(Progress)) Option {
+	return func(o *callOptions) {
+		o.progress = fn
+	}
+}
+
+// ExpectedItems gives the number of keys a long-running operation is
+// expected to process, from which the ETA reported to WithProgress is
+// estimated.
+func ExpectedItems(n int) Option {
+	return func(o *callOptions) {
+		o.expectedItems = n
+	}
+}
+
+// WithCheckpoint causes a long-running operation to record its progress in
+// the table under name as it runs. If the operation fails, or the process
+// running it stops, calling it again with the same arguments and name
+// resumes it from the last checkpoint rather than starting again. The
+// checkpoint is removed when the operation completes.
+//
+// Progress is recorded every few keys, so a resumed operation may repeat
+// the work done for the keys processed since the last checkpoint; a
+// resumed Walk may call walkFunc again for those keys. Checkpoints are
+// stored alongside the metadata row, so they are not part of the tree.
+func WithCheckpoint(name string) Option {
+	return func(o *callOptions) {
+		o.checkpoint = name
+	}
+}
+
+// checkpointItems is the number of keys processed between checkpoints. It
+// is a variable so that checkpoints can be tested.
+var checkpointItems = 100
+
+// checkpointChild returns the value of the Child attribute of the row in
+// which the checkpoint called name is recorded.
+func checkpointChild(name string) string { return "checkpoint:" + name }
+
+// ClearCheckpoint removes the checkpoint recorded under name, so that an
+// operation given WithCheckpoint(name) starts from the beginning.
+func (t *Tree) ClearCheckpoint(name string, opts ...Option) (err error) {
+	defer annotateError(&err, "ClearCheckpoint", nil)
+	o, cancel := newCallOptions(opts)
+	defer cancel()
+	if err := refuseCapability("ClearCheckpoint", nil, o); err != nil {
+		return err
+	}
+	if err := t.ready(); err != nil {
+		return err
+	}
+	_, err = t.DB.DeleteItemWithContext(o.context(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(t.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Key":   &dynamodb.AttributeValue{S: aws.String(MetadataKey)},
+			"Child": &dynamodb.AttributeValue{S: aws.String(checkpointChild(name))},
+		},
+	}, o.request()...)
+	return err
+}
+
+// job tracks the progress of a long-running operation, reporting it to
+// o.progress and recording checkpoints if o.checkpoint is set.
+type job struct {
+	tree    *Tree
+	o       *callOptions
+	op      string
+	args    string
+	started time.Time
+	resumed bool
+
+	// phase is the part of the operation in progress, for operations that
+	// make more than one pass over the tree.
+	phase string
+
+	// items is the number of keys processed, and runItems the number
+	// processed since the job began or was resumed.
+	items    int
+	runItems int
+	saved    int
+	key      []string
+
+	// resumeAfter is the last key processed, according to the
+	// checkpoint, when the job was resumed.
+	resumeAfter []string
+}
+
+// startJob begins the operation op, whose arguments are keys, resuming it
+// from its checkpoint if there is one.
+func (t *Tree) startJob(op string, keys [][]string, o *callOptions) (*job, error) {
+	args := make([]string, len(keys))
+	for i, key := range keys {
+		args[i] = t.EncodeKey(key)
+	}
+	j := &job{tree: t, o: o, op: op, args: strings.Join(args, " "), started: t.now()}
+	if o.checkpoint == "" {
+		return j, nil
+	}
+	row, err := t.getItem(MetadataKey, checkpointChild(o.checkpoint), &callOptions{ctx: o.ctx, consistentRead: true})
+	if err != nil || row == nil {
+		return j, err
+	}
+	if aws.StringValue(row["Op"].S) != op || aws.StringValue(row["Args"].S) != j.args {
+		return nil, fmt.Errorf("checkpoint %q was recorded by %s %s", o.checkpoint,
+			aws.StringValue(row["Op"].S), aws.StringValue(row["Args"].S))
+	}
+	j.resumed = true
+	if v, ok := row["Phase"]; ok {
+		j.phase = aws.StringValue(v.S)
+	}
+	if v, ok := row["Items"]; ok {
+		j.items, _ = strconv.Atoi(aws.StringValue(v.N))
+		j.saved = j.items
+	}
+	if v, ok := row["LastKey"]; ok {
+		j.resumeAfter = t.DecodeKey(aws.StringValue(v.S))
+		j.key = j.resumeAfter
+	}
+	return j, nil
+}
+
+// done records that key has been processed.
+func (j *job) done(key []string) error {
+	j.items++
+	j.runItems++
+	j.key = key
+	if j.o.progress != nil {
+		j.o.progress(j.progress())
+	}
+	if j.o.checkpoint != "" && j.items-j.saved >= checkpointItems {
+		return j.save(j.o.context())
+	}
+	return nil
+}
+
+func (j *job) progress() Progress {
+	p := Progress{
+		Op:      j.op,
+		Items:   j.items,
+		Total:   j.o.expectedItems,
+		Key:     j.key,
+		Elapsed: j.tree.since(j.started),
+		Resumed: j.resumed,
+	}
+	if remaining := p.Total - p.Items; remaining > 0 && j.runItems > 0 {
+		p.ETA = time.Duration(float64(p.Elapsed) * float64(remaining) / float64(j.runItems))
+	}
+	return p
+}
+
+// startPhase records that the job has moved on to phase, in which the
+// keys processed before are not skipped.
+func (j *job) startPhase(phase string) error {
+	j.phase = phase
+	j.key, j.resumeAfter = nil, nil
+	if j.o.checkpoint == "" {
+		return nil
+	}
+	return j.save(j.o.context())
+}
+
+// save records the checkpoint.
+func (j *job) save(ctx context.Context) error {
+	t := j.tree
+	item := map[string]*dynamodb.AttributeValue{
+		"Key":     &dynamodb.AttributeValue{S: aws.String(MetadataKey)},
+		"Child":   &dynamodb.AttributeValue{S: aws.String(checkpointChild(j.o.checkpoint))},
+		"Op":      &dynamodb.AttributeValue{S: aws.String(j.op)},
+		"Args":    &dynamodb.AttributeValue{S: aws.String(j.args)},
+		"Items":   &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(j.items))},
+		"Updated": &dynamodb.AttributeValue{S: aws.String(j.tree.now().UTC().Format(time.RFC3339))},
+	}
+	if j.phase != "" {
+		item["Phase"] = &dynamodb.AttributeValue{S: aws.String(j.phase)}
+	}
+	if j.key != nil {
+		item["LastKey"] = &dynamodb.AttributeValue{S: aws.String(t.EncodeKey(j.key))}
+	}
+	_, err := t.DB.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(t.TableName),
+		Item:      item,
+	}, j.o.request()...)
+	if err == nil {
+		j.saved = j.items
+	}
+	return err
+}
+
+// finish completes the job, whose outcome is err. If the job succeeded its
+// checkpoint is removed, and otherwise its progress is recorded so that
+// it can be resumed.
+func (j *job) finish(err error) error {
+	if j.o.checkpoint == "" {
+		return err
+	}
+	if err != nil {
+		j.stop()
+		return err
+	}
+	return j.tree.ClearCheckpoint(j.o.checkpoint, WithContext(j.o.context()))
+}
+
+// stop records the progress of a job that stopped before it completed,
+// even if it stopped because its context was cancelled, so that it can be
+// resumed.
+func (j *job) stop() {
+	if j.o.checkpoint != "" && j.items > j.saved {
+		j.save(context.Background())
+	}
+}
+
+// skip returns whether key, and whether the keys below key, were processed
+// before the job was resumed, assuming that keys are processed depth
+// first with each key before its descendants.
+func (j *job) skip(key []string) (self, below bool) {
+	if j.resumeAfter == nil {
+		return false, false
+	}
+	t := j.tree
+	for i := range key {
+		if i == len(j.resumeAfter) {
+			return false, false // key is below the last key processed
+		}
+		if a, b := t.encodePart(key[i]), t.encodePart(j.resumeAfter[i]); a != b {
+			return a < b, a < b
+		}
+	}
+	return true, false // key is at or above the last key processed
+}
+
+// walk calls fn for each key below prefix, depth first, skipping those
+// processed before the job was resumed, and records that each key has been
+// processed once fn returns. It stops early if fn returns false.
+func (j *job) walk(prefix []string, fn func(key []string) (bool, error)) (bool, error) {
+	t := j.tree
+	children := []string{}
+	var err error
+	t.list(prefix, func(child string, innerErr error) bool {
+		if innerErr != nil {
+			err = innerErr
+			return false
+		}
+		children = append(children, child)
+		return true
+	}, j.o)
+	if err != nil {
+		return false, err
+	}
+
+	for _, child := range children {
+		key := make([]string, len(prefix), len(prefix)+1)
+		copy(key, prefix)
+		key = append(key, child)
+		skipSelf, skipBelow := j.skip(key)
+		if !skipSelf {
+			more, err := fn(key)
+			if err != nil || !more {
+				return false, err
+			}
+			if err := j.done(key); err != nil {
+				return false, err
+			}
+		}
+		if !skipBelow {
+			more, err := j.walk(key, fn)
+			if err != nil || !more {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// walkPostOrder calls fn for each key below prefix in t, which need not be
+// the tree that records the job's checkpoint, depth first, with the keys
+// below each key before the key itself. It records that each key has been
+// processed once fn returns. The keys a resumed job processed before are
+// not skipped, so fn must be safe to repeat; this suits fn that remove the
+// keys, which are then no longer found.
+func (j *job) walkPostOrder(t *Tree, prefix []string, fn func(key []string) error) error {
+	children := []string{}
+	var err error
+	t.list(prefix, func(child string, innerErr error) bool {
+		if innerErr != nil {
+			err = innerErr
+			return false
+		}
+		children = append(children, child)
+		return true
+	}, j.o)
+	if err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		key := make([]string, len(prefix), len(prefix)+1)
+		copy(key, prefix)
+		key = append(key, child)
+		if err := j.walkPostOrder(t, key, fn); err != nil {
+			return err
+		}
+		if err := fn(key); err != nil {
+			return err
+		}
+		if err := j.done(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
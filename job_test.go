@@ -0,0 +1,92 @@
+package dynamotree
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestWalkCheckpoint(c *C) {
+	defer func(n int) { checkpointItems = n }(checkpointItems)
+	checkpointItems = 1
+
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+	v := AccountT{ID: "12345", Name: "alice"}
+	c.Assert(s.Put([]string{"Accounts", "12345", "Links", "xyzpdq"}, &v), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "6789"}, &v), IsNil)
+	c.Assert(s.PutLink([]string{"Links", "xyzpdq"}, []string{"Accounts", "6789"}), IsNil)
+	all := [][]string{
+		{"Accounts"},
+		{"Accounts", "12345"},
+		{"Accounts", "12345", "Links"},
+		{"Accounts", "12345", "Links", "xyzpdq"},
+		{"Accounts", "6789"},
+		{"Links"},
+		{"Links", "xyzpdq"},
+	}
+
+	// The walk stops at the third key, which is visited again when it
+	// resumes.
+	keys := [][]string{}
+	s.Walk(nil, func(key []string, err error) bool {
+		c.Assert(err, IsNil)
+		keys = append(keys, key)
+		return len(keys) < 3
+	}, WithCheckpoint("walk"))
+	c.Assert(keys, DeepEquals, all[:3])
+
+	// Another operation cannot use the checkpoint
+	s.Walk([]string{"Accounts"}, func(key []string, err error) bool {
+		c.Assert(err, ErrorMatches, `checkpoint "walk" was recorded by Walk ¦`)
+		return true
+	}, WithCheckpoint("walk"))
+
+	keys = [][]string{}
+	progress := []Progress{}
+	s.Walk(nil, func(key []string, err error) bool {
+		c.Assert(err, IsNil)
+		keys = append(keys, key)
+		return true
+	}, WithCheckpoint("walk"), ExpectedItems(len(all)), WithProgress(func(p Progress) {
+		progress = append(progress, p)
+	}))
+	c.Assert(keys, DeepEquals, all[2:])
+	c.Assert(progress, HasLen, len(all)-2)
+	for i, p := range progress {
+		c.Assert(p.Op, Equals, "Walk")
+		c.Assert(p.Items, Equals, i+3)
+		c.Assert(p.Total, Equals, len(all))
+		c.Assert(p.Key, DeepEquals, all[i+2])
+		c.Assert(p.Resumed, Equals, true)
+		c.Assert(p.ETA >= 0, Equals, true)
+	}
+	c.Assert(progress[len(progress)-1].ETA, Equals, time.Duration(0))
+
+	// Once the walk completes, the checkpoint is removed.
+	row, err := s.getItem(MetadataKey, checkpointChild("walk"), nil)
+	c.Assert(err, IsNil)
+	c.Assert(row, IsNil)
+	keys = [][]string{}
+	s.Walk(nil, func(key []string, err error) bool {
+		c.Assert(err, IsNil)
+		keys = append(keys, key)
+		return true
+	}, WithCheckpoint("walk"))
+	c.Assert(keys, DeepEquals, all)
+
+	// ClearCheckpoint causes the walk to start again.
+	s.Walk(nil, func(key []string, err error) bool { return false }, WithCheckpoint("walk"))
+	s.Walk(nil, func(key []string, err error) bool { return len(key) < 2 }, WithCheckpoint("walk"))
+	c.Assert(s.ClearCheckpoint("walk"), IsNil)
+	keys = [][]string{}
+	s.Walk(nil, func(key []string, err error) bool {
+		keys = append(keys, key)
+		return true
+	}, WithCheckpoint("walk"))
+	c.Assert(keys, DeepEquals, all)
+}
@@ -0,0 +1,474 @@
+package dynamotree
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// DefaultJobHeartbeat is how often a job started by Jobs records its
+// progress, if Jobs.Heartbeat is not specified.
+const DefaultJobHeartbeat = 10 * time.Second
+
+// jobChildPrefix begins the value of the Child attribute of each row that
+// records a job started by Jobs. The rows are stored alongside the
+// metadata row, so they are not part of the tree.
+const jobChildPrefix = "job:"
+
+// JobState is the state of a job started by Jobs.
+type JobState string
+
+// The states of a job.
+const (
+	JobRunning   JobState = "running"
+	JobCompleted JobState = "completed"
+	JobFailed    JobState = "failed"
+	JobCanceled  JobState = "canceled"
+
+	// JobAbandoned is a job recorded as running that has not recorded its
+	// progress for three heartbeats, because the process running it
+	// stopped.
+	JobAbandoned JobState = "abandoned"
+)
+
+// ErrJobNotFound is returned by the methods of Jobs for an ID that names no
+// job.
+var ErrJobNotFound = errors.New("job not found")
+
+// JobStatus is the status of a job, as recorded in the table.
+type JobStatus struct {
+	ID string `json:"id"`
+
+	// Op and Description are as given to Start.
+	Op          string `json:"op"`
+	Description string `json:"description,omitempty"`
+
+	State JobState `json:"state"`
+
+	// Items is the number of keys processed, as reported to WithProgress.
+	Items int `json:"items"`
+
+	Started time.Time `json:"started"`
+
+	// Updated is when the job last recorded its progress.
+	Updated time.Time `json:"updated"`
+
+	// Error is the error with which a failed job stopped.
+	Error string `json:"error,omitempty"`
+
+	// CancelRequested is true if Cancel has been called for the job.
+	CancelRequested bool `json:"cancelRequested,omitempty"`
+}
+
+// JobFunc runs the operation of a job, such as DeleteAll, Sync or
+// ImportArchive, passing opts to it. opts give the operation the job's
+// context, by which it is canceled, a checkpoint, from which Resume
+// resumes it, and a function that records its progress, so operations
+// that take no options, such as GC, can be run only to completion.
+type JobFunc func(opts ...Option) error
+
+// Jobs runs long-running operations in the background, recording the
+// status of each in the table, so that it can be listed, polled and
+// canceled by ID from any process, such as by an administrative API or
+// command, and survives the process that started it:
+//
+//	jobs := &dynamotree.Jobs{Tree: tree}
+//	id, err := jobs.Start("DeleteAll", "Tenants/acme", func(opts ...dynamotree.Option) error {
+//	    return tree.DeleteAll([]string{"Tenants", "acme"}, opts...)
+//	})
+//
+// The status of a job is kept until it is removed with Remove.
+type Jobs struct {
+	Tree *Tree
+
+	// Heartbeat is how often a running job records its progress and
+	// checks whether it has been canceled. If zero, DefaultJobHeartbeat is
+	// used.
+	Heartbeat time.Duration
+
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+}
+
+// Start records a job for op, described by description, and runs it in
+// the background, returning its ID.
+func (j *Jobs) Start(op, description string, run JobFunc) (string, error) {
+	t := j.Tree
+	if err := t.ready(); err != nil {
+		return "", err
+	}
+	now := t.now()
+	id, err := t.newTimeOrderedID(now)
+	if err != nil {
+		return "", err
+	}
+	token, err := t.randomHex(8)
+	if err != nil {
+		return "", err
+	}
+	_, err = t.DB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(t.TableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"Key":         &dynamodb.AttributeValue{S: aws.String(MetadataKey)},
+			"Child":       &dynamodb.AttributeValue{S: aws.String(jobChildPrefix + id)},
+			"Op":          &dynamodb.AttributeValue{S: aws.String(op)},
+			"Description": &dynamodb.AttributeValue{S: aws.String(description)},
+			"State":       &dynamodb.AttributeValue{S: aws.String(string(JobRunning))},
+			"Items":       &dynamodb.AttributeValue{N: aws.String("0")},
+			"Started":     &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(now.UnixNano(), 10))},
+			"Updated":     &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(now.UnixNano(), 10))},
+			"Heartbeat":   &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(int64(j.heartbeat()), 10))},
+			"Run":         &dynamodb.AttributeValue{S: aws.String(token)},
+		},
+		ConditionExpression:      aws.String("attribute_not_exists(#K)"),
+		ExpressionAttributeNames: map[string]*string{"#K": aws.String("Key")},
+	})
+	if err != nil {
+		return "", err
+	}
+	j.launch(id, token, run)
+	return id, nil
+}
+
+// Resume runs again the job id, which failed, was canceled or was
+// abandoned, from its checkpoint. run must run the same operation, with
+// the same arguments, as the job was started with. If the job is resumed
+// by another process, or records its progress, between Resume reading its
+// status and running it, Resume returns an error and does not run it.
+func (j *Jobs) Resume(id string, run JobFunc) error {
+	t := j.Tree
+	status, err := j.Get(id)
+	if err != nil {
+		return err
+	}
+	stored := status.State
+	switch stored {
+	case JobRunning, JobCompleted:
+		return fmt.Errorf("job %s is %s", id, status.State)
+	case JobAbandoned:
+		stored = JobRunning
+	}
+
+	token, err := t.randomHex(8)
+	if err != nil {
+		return err
+	}
+
+	// The job is resumed only if it has not changed since its status was
+	// read, so that of several processes resuming it, or of a process
+	// resuming it and the one still running it, one runs it. The new run
+	// token stops a process still running the job from recording its
+	// progress over the resumed run's.
+	_, err = t.DB.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String(t.TableName),
+		Key:                 jobRowKey(id),
+		ConditionExpression: aws.String("#S = :state AND #U = :updated"),
+		UpdateExpression:    aws.String("SET #S = :running, #U = :now, #H = :heartbeat, #R = :run REMOVE #E, #C"),
+		ExpressionAttributeNames: map[string]*string{
+			"#S": aws.String("State"),
+			"#U": aws.String("Updated"),
+			"#H": aws.String("Heartbeat"),
+			"#R": aws.String("Run"),
+			"#E": aws.String("Error"),
+			"#C": aws.String("Cancel"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":state":     &dynamodb.AttributeValue{S: aws.String(string(stored))},
+			":updated":   &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(status.Updated.UnixNano(), 10))},
+			":running":   &dynamodb.AttributeValue{S: aws.String(string(JobRunning))},
+			":now":       &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(t.now().UnixNano(), 10))},
+			":heartbeat": &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(int64(j.heartbeat()), 10))},
+			":run":       &dynamodb.AttributeValue{S: aws.String(token)},
+		},
+	})
+	if isConditionalCheckFailed(err) {
+		return fmt.Errorf("job %s has already been resumed", id)
+	}
+	if err != nil {
+		return err
+	}
+	j.launch(id, token, run)
+	return nil
+}
+
+// Get returns the status of the job id.
+func (j *Jobs) Get(id string) (*JobStatus, error) {
+	t := j.Tree
+	if err := t.ready(); err != nil {
+		return nil, err
+	}
+	row, err := t.getItem(MetadataKey, jobChildPrefix+id, &callOptions{ctx: context.Background(), consistentRead: true})
+	if err != nil {
+		return nil, err
+	}
+	if row == nil {
+		return nil, ErrJobNotFound
+	}
+	return j.status(row), nil
+}
+
+// List returns the status of every job, in the order they were started.
+func (j *Jobs) List() ([]*JobStatus, error) {
+	t := j.Tree
+	if err := t.ready(); err != nil {
+		return nil, err
+	}
+	statuses := []*JobStatus{}
+	err := t.DB.QueryPages(&dynamodb.QueryInput{
+		TableName:              aws.String(t.TableName),
+		ConsistentRead:         aws.Bool(true),
+		KeyConditionExpression: aws.String("#K = :key AND begins_with(#C, :prefix)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#K": aws.String("Key"),
+			"#C": aws.String("Child"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":key":    &dynamodb.AttributeValue{S: aws.String(MetadataKey)},
+			":prefix": &dynamodb.AttributeValue{S: aws.String(jobChildPrefix)},
+		},
+	}, func(p *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, row := range p.Items {
+			statuses = append(statuses, j.status(row))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// Cancel asks the job id to stop. A job running in this process stops at
+// once, and one running in another process once it next records its
+// progress. A canceled job can be resumed with Resume.
+func (j *Jobs) Cancel(id string) error {
+	t := j.Tree
+	if err := t.ready(); err != nil {
+		return err
+	}
+	_, err := t.DB.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                aws.String(t.TableName),
+		Key:                      jobRowKey(id),
+		UpdateExpression:         aws.String("SET #C = :true"),
+		ConditionExpression:      aws.String("attribute_exists(#K)"),
+		ExpressionAttributeNames: map[string]*string{"#C": aws.String("Cancel"), "#K": aws.String("Key")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":true": &dynamodb.AttributeValue{BOOL: aws.Bool(true)},
+		},
+	})
+	if isConditionalCheckFailed(err) {
+		return ErrJobNotFound
+	}
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	if cancel, ok := j.running[id]; ok {
+		cancel()
+	}
+	j.mu.Unlock()
+	return nil
+}
+
+// Remove removes the status and checkpoint of the job id, which must not
+// be running.
+func (j *Jobs) Remove(id string) error {
+	t := j.Tree
+	status, err := j.Get(id)
+	if err != nil {
+		return err
+	}
+	if status.State == JobRunning {
+		return fmt.Errorf("job %s is %s", id, status.State)
+	}
+	if err := t.ClearCheckpoint(jobChildPrefix + id); err != nil {
+		return err
+	}
+	_, err = t.DB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(t.TableName),
+		Key:       jobRowKey(id),
+	})
+	return err
+}
+
+func (j *Jobs) heartbeat() time.Duration {
+	if j.Heartbeat > 0 {
+		return j.Heartbeat
+	}
+	return DefaultJobHeartbeat
+}
+
+// launch runs the job id in the background, as the run identified by
+// token.
+func (j *Jobs) launch(id, token string, run JobFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	j.mu.Lock()
+	if j.running == nil {
+		j.running = map[string]context.CancelFunc{}
+	}
+	j.running[id] = cancel
+	j.mu.Unlock()
+	go j.run(ctx, cancel, id, token, run)
+}
+
+// run runs the job id, recording its progress at each heartbeat and its
+// outcome when it stops. The run is stopped if the job is removed, or
+// resumed by another process, while it runs.
+func (j *Jobs) run(ctx context.Context, cancel context.CancelFunc, id, token string, run JobFunc) {
+	t := j.Tree
+	closing, done := t.background()
+	defer done()
+	defer func() {
+		j.mu.Lock()
+		delete(j.running, id)
+		j.mu.Unlock()
+		cancel()
+	}()
+
+	var mu sync.Mutex
+	items := 0
+	stopped, heartbeatDone := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		ticker := time.NewTicker(j.heartbeat())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopped:
+				return
+			case <-closing:
+				cancel()
+				return
+			case <-ticker.C:
+			}
+			mu.Lock()
+			n := items
+			mu.Unlock()
+			row, err := j.record(ctx, id, token, n, "", "")
+			if err == errJobSuperseded || err == nil && aws.BoolValue(jobAttribute(row, "Cancel").BOOL) {
+				cancel()
+			}
+		}
+	}()
+
+	err := run(WithContext(ctx), WithCheckpoint(jobChildPrefix+id), WithProgress(func(p Progress) {
+		mu.Lock()
+		items = p.Items
+		mu.Unlock()
+	}))
+	close(stopped)
+	<-heartbeatDone
+
+	state, message := JobCompleted, ""
+	if err != nil {
+		state, message = JobFailed, err.Error()
+		if ctx.Err() != nil {
+			// The job was stopped by Cancel, or by closing the tree.
+			row, getErr := t.getItem(MetadataKey, jobChildPrefix+id, &callOptions{ctx: context.Background(), consistentRead: true})
+			if getErr == nil && aws.BoolValue(jobAttribute(row, "Cancel").BOOL) {
+				state, message = JobCanceled, ""
+			}
+		}
+	}
+	// The outcome is recorded even though the run's context has been
+	// canceled by now if the job was canceled.
+	j.record(context.WithoutCancel(ctx), id, token, items, state, message)
+}
+
+// errJobSuperseded is returned by record when the job's row has been
+// removed, or the job resumed as another run, since the run started.
+var errJobSuperseded = errors.New("job has been removed or resumed")
+
+// record records the progress of the job id, and its state if state is
+// not empty, returning its row. The row is updated only while it records
+// the run identified by token.
+func (j *Jobs) record(ctx context.Context, id, token string, items int, state JobState, message string) (map[string]*dynamodb.AttributeValue, error) {
+	t := j.Tree
+	update := "SET #I = :items, #U = :now"
+	names := map[string]*string{
+		"#K": aws.String("Key"),
+		"#R": aws.String("Run"),
+		"#I": aws.String("Items"),
+		"#U": aws.String("Updated"),
+	}
+	values := map[string]*dynamodb.AttributeValue{
+		":run":   &dynamodb.AttributeValue{S: aws.String(token)},
+		":items": &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(items))},
+		":now":   &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(t.now().UnixNano(), 10))},
+	}
+	if state != "" {
+		update += ", #S = :state"
+		names["#S"] = aws.String("State")
+		values[":state"] = &dynamodb.AttributeValue{S: aws.String(string(state))}
+	}
+	if message != "" {
+		update += ", #E = :error"
+		names["#E"] = aws.String("Error")
+		values[":error"] = &dynamodb.AttributeValue{S: aws.String(message)}
+	}
+	resp, err := t.DB.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(t.TableName),
+		Key:                       jobRowKey(id),
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("attribute_exists(#K) AND #R = :run"),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ReturnValues:              aws.String(dynamodb.ReturnValueAllNew),
+	})
+	if isConditionalCheckFailed(err) {
+		return nil, errJobSuperseded
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Attributes, nil
+}
+
+// status returns the status recorded in row, the row of a job.
+func (j *Jobs) status(row map[string]*dynamodb.AttributeValue) *JobStatus {
+	number := func(name string) int64 {
+		n, _ := strconv.ParseInt(aws.StringValue(jobAttribute(row, name).N), 10, 64)
+		return n
+	}
+	str := func(name string) string { return aws.StringValue(jobAttribute(row, name).S) }
+	child := str("Child")
+	status := &JobStatus{
+		ID:              child[len(jobChildPrefix):],
+		Op:              str("Op"),
+		Description:     str("Description"),
+		State:           JobState(str("State")),
+		Items:           int(number("Items")),
+		Started:         time.Unix(0, number("Started")),
+		Updated:         time.Unix(0, number("Updated")),
+		Error:           str("Error"),
+		CancelRequested: aws.BoolValue(jobAttribute(row, "Cancel").BOOL),
+	}
+	heartbeat := time.Duration(number("Heartbeat"))
+	if status.State == JobRunning && heartbeat > 0 && j.Tree.since(status.Updated) > 3*heartbeat {
+		status.State = JobAbandoned
+	}
+	return status
+}
+
+// jobAttribute returns the attribute name of row, or an empty value if
+// row does not have it.
+func jobAttribute(row map[string]*dynamodb.AttributeValue, name string) *dynamodb.AttributeValue {
+	if v, ok := row[name]; ok && v != nil {
+		return v
+	}
+	return &dynamodb.AttributeValue{}
+}
+
+// jobRowKey returns the key of the row of the job id.
+func jobRowKey(id string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"Key":   &dynamodb.AttributeValue{S: aws.String(MetadataKey)},
+		"Child": &dynamodb.AttributeValue{S: aws.String(jobChildPrefix + id)},
+	}
+}
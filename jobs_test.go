@@ -0,0 +1,226 @@
+package dynamotree
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestJobs(c *C) {
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	s := &Tree{TableName: uniuri.New(), DB: dynamodb.New(session.New(), testConfig), Clock: clock}
+	c.Assert(s.CreateTable(), IsNil)
+	c.Assert(s.Put([]string{"Tenants", "a", "1"}, &AccountT{ID: "1"}), IsNil)
+	c.Assert(s.Put([]string{"Tenants", "a", "2"}, &AccountT{ID: "2"}), IsNil)
+	jobs := &Jobs{Tree: s, Heartbeat: 10 * time.Millisecond}
+
+	// advance moves the clock on, so that the jobs started after it sort
+	// after those started before.
+	advance := func(d time.Duration) {
+		clock.mu.Lock()
+		clock.now = clock.now.Add(d)
+		clock.mu.Unlock()
+	}
+
+	// wait returns the status of the job id once it has stopped.
+	wait := func(id string) *JobStatus {
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			status, err := jobs.Get(id)
+			c.Assert(err, IsNil)
+			if (status.State != JobRunning && status.State != JobAbandoned) || time.Now().After(deadline) {
+				return status
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	deleteAll, err := jobs.Start("DeleteAll", "Tenants/a", func(opts ...Option) error {
+		return s.DeleteAll([]string{"Tenants", "a"}, opts...)
+	})
+	c.Assert(err, IsNil)
+	status := wait(deleteAll)
+	c.Assert(status.State, Equals, JobCompleted)
+	c.Assert(status.Op, Equals, "DeleteAll")
+	c.Assert(status.Description, Equals, "Tenants/a")
+	c.Assert(status.Items, Equals, 3)
+	c.Assert(status.Started.Equal(clock.Now()), Equals, true)
+	c.Assert(walkKeys(c, s, nil), DeepEquals, [][]string{{"Tenants"}})
+
+	// A failed job is resumed from its checkpoint.
+	advance(time.Second)
+	failing, err := jobs.Start("Import", "", func(opts ...Option) error { return errors.New("boom") })
+	c.Assert(err, IsNil)
+	status = wait(failing)
+	c.Assert(status.State, Equals, JobFailed)
+	c.Assert(status.Error, Equals, "boom")
+	checkpoint := make(chan string, 1)
+	c.Assert(jobs.Resume(failing, func(opts ...Option) error {
+		o, cancel := newCallOptions(opts)
+		defer cancel()
+		checkpoint <- o.checkpoint
+		return nil
+	}), IsNil)
+	c.Assert(<-checkpoint, Equals, "job:"+failing)
+	c.Assert(wait(failing).State, Equals, JobCompleted)
+	c.Assert(jobs.Resume(failing, nil), ErrorMatches, `job .* is completed`)
+
+	// A job is canceled from another process at its next heartbeat.
+	advance(time.Second)
+	blocked := func(opts ...Option) error {
+		o, cancel := newCallOptions(opts)
+		defer cancel()
+		o.progress(Progress{Items: 7})
+		<-o.context().Done()
+		return o.context().Err()
+	}
+	blocking, err := jobs.Start("Sync", "", blocked)
+	c.Assert(err, IsNil)
+	other := &Jobs{Tree: s}
+	c.Assert(other.Cancel(blocking), IsNil)
+	status = wait(blocking)
+	c.Assert(status.State, Equals, JobCanceled)
+	c.Assert(status.Items, Equals, 7)
+	c.Assert(status.CancelRequested, Equals, true)
+
+	// A job whose process stopped is abandoned once it misses three
+	// heartbeats.
+	advance(time.Second)
+	slow := &Jobs{Tree: s, Heartbeat: time.Hour}
+	abandoned, err := slow.Start("GC", "", blocked)
+	c.Assert(err, IsNil)
+	status, err = jobs.Get(abandoned)
+	c.Assert(err, IsNil)
+	c.Assert(status.State, Equals, JobRunning)
+	advance(4 * time.Hour)
+	status, err = jobs.Get(abandoned)
+	c.Assert(err, IsNil)
+	c.Assert(status.State, Equals, JobAbandoned)
+	c.Assert(slow.Cancel(abandoned), IsNil)
+	c.Assert(wait(abandoned).State, Equals, JobCanceled)
+
+	statuses, err := other.List()
+	c.Assert(err, IsNil)
+	ids := []string{}
+	for _, status := range statuses {
+		ids = append(ids, status.ID)
+	}
+	c.Assert(ids, DeepEquals, []string{deleteAll, failing, blocking, abandoned})
+
+	c.Assert(jobs.Remove(deleteAll), IsNil)
+	_, err = jobs.Get(deleteAll)
+	c.Assert(err, Equals, ErrJobNotFound)
+	c.Assert(jobs.Cancel(deleteAll), Equals, ErrJobNotFound)
+}
+
+func (suite *StoreImplTest) TestJobsResumeOnce(c *C) {
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db, Clock: clock}
+	c.Assert(s.CreateTable(), IsNil)
+	jobs := &Jobs{Tree: s, Heartbeat: time.Hour}
+	other := &Jobs{Tree: s, Heartbeat: time.Hour}
+
+	var runs int32
+	done := make(chan struct{})
+	run := func(opts ...Option) error {
+		atomic.AddInt32(&runs, 1)
+		<-done
+		return nil
+	}
+	defer close(done)
+
+	// Of two processes resuming a failed job at once, one runs it.
+	id, err := jobs.Start("Import", "", func(opts ...Option) error { return errors.New("boom") })
+	c.Assert(err, IsNil)
+	for {
+		status, err := jobs.Get(id)
+		c.Assert(err, IsNil)
+		if status.State == JobFailed {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The other process resumes the job just before the first one does.
+	raced := false
+	db.Handlers.Build.PushBack(func(r *request.Request) {
+		if r.Operation.Name == "UpdateItem" && !raced {
+			raced = true
+			c.Check(other.Resume(id, run), IsNil)
+		}
+	})
+	c.Assert(jobs.Resume(id, run), ErrorMatches, `job .* has already been resumed`)
+
+	// An abandoned job is resumed.
+	clock.mu.Lock()
+	clock.now = clock.now.Add(4 * time.Hour)
+	clock.mu.Unlock()
+	status, err := jobs.Get(id)
+	c.Assert(err, IsNil)
+	c.Assert(status.State, Equals, JobAbandoned)
+	c.Assert(jobs.Resume(id, run), IsNil)
+	c.Assert(other.Resume(id, run), ErrorMatches, `job .* is running`)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for atomic.LoadInt32(&runs) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	c.Assert(atomic.LoadInt32(&runs), Equals, int32(2))
+}
+
+func (suite *StoreImplTest) TestJobsSuperseded(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+	jobs := &Jobs{Tree: s, Heartbeat: 10 * time.Millisecond}
+
+	stopped := make(chan struct{}, 1)
+	blocked := func(opts ...Option) error {
+		o, cancel := newCallOptions(opts)
+		defer cancel()
+		<-o.context().Done()
+		stopped <- struct{}{}
+		return o.context().Err()
+	}
+
+	// A run whose job is resumed by another process stops, and does not
+	// record its outcome over the other run's.
+	id, err := jobs.Start("Sync", "", blocked)
+	c.Assert(err, IsNil)
+	_, err = db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                 aws.String(s.TableName),
+		Key:                       jobRowKey(id),
+		UpdateExpression:          aws.String("SET #R = :run"),
+		ExpressionAttributeNames:  map[string]*string{"#R": aws.String("Run")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":run": {S: aws.String("other")}},
+	})
+	c.Assert(err, IsNil)
+	<-stopped
+	c.Assert(s.Close(context.Background()), IsNil)
+	other := &Jobs{Tree: &Tree{TableName: s.TableName, DB: db}}
+	status, err := other.Get(id)
+	c.Assert(err, IsNil)
+	c.Assert(status.State, Equals, JobRunning)
+	c.Assert(status.Error, Equals, "")
+
+	// A run whose job is removed stops, and does not recreate its row.
+	s = &Tree{TableName: s.TableName, DB: db}
+	jobs = &Jobs{Tree: s, Heartbeat: 10 * time.Millisecond}
+	id, err = jobs.Start("Sync", "", blocked)
+	c.Assert(err, IsNil)
+	_, err = db.DeleteItem(&dynamodb.DeleteItemInput{TableName: aws.String(s.TableName), Key: jobRowKey(id)})
+	c.Assert(err, IsNil)
+	<-stopped
+	c.Assert(s.Close(context.Background()), IsNil)
+	_, err = other.Get(id)
+	c.Assert(err, Equals, ErrJobNotFound)
+}
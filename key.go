@@ -0,0 +1,250 @@
+package dynamotree
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"unicode/utf8"
+)
+
+// EncodeKey returns the value of the Key attribute of the row that holds
+// the object (or link) at key. For example, with the default
+// SpecialCharacter, []string{"Accounts", "123456"} is encoded as
+// "¦Accounts¦123456".
+func (t *Tree) EncodeKey(key []string) string {
+	t.initOnce.Do(t.init)
+	return t.encodeKey(key, false)
+}
+
+// DecodeKey is the inverse of EncodeKey. It returns nil if pathKey is not
+// an encoded key.
+func (t *Tree) DecodeKey(pathKey string) []string {
+	t.initOnce.Do(t.init)
+	if !strings.HasPrefix(pathKey, t.SpecialCharacter) {
+		return nil
+	}
+	if pathKey == t.SpecialCharacter {
+		return []string{}
+	}
+	parts := strings.Split(pathKey[len(t.SpecialCharacter):], t.SpecialCharacter)
+	if t.KeyEncoding != KeyEncodingNone {
+		for i, part := range parts {
+			parts[i] = t.decodePart(part)
+		}
+	}
+	return parts
+}
+
+// KeyEncoding determines how the parts of keys are stored.
+type KeyEncoding int
+
+// The values of Tree.KeyEncoding.
+const (
+	// KeyEncodingNone stores each part of a key as it is. Parts may not be
+	// empty or contain the SpecialCharacter.
+	KeyEncodingNone KeyEncoding = iota
+
+	// KeyEncodingHex stores unsafe parts of keys as EncodedPartPrefix
+	// followed by the hex encoding of the part. Hex encoding preserves the
+	// order of the encoded parts among themselves, but as EncodedPartPrefix
+	// sorts after letters and digits, List returns the encoded children
+	// after most of those stored as they are, rather than in byte order.
+	KeyEncodingHex
+
+	// KeyEncodingBase64URL stores unsafe parts of keys as EncodedPartPrefix
+	// followed by the unpadded base64url encoding of the part, which is
+	// shorter than hex but does not preserve order.
+	KeyEncodingBase64URL
+)
+
+// EncodedPartPrefix marks a part of a key that has been encoded according
+// to Tree.KeyEncoding.
+//
+// When KeyEncoding is not KeyEncodingNone, a part is unsafe, and is
+// encoded, if it is empty, is not valid UTF-8, contains the SpecialCharacter
+// or begins with EncodedPartPrefix. Other parts are stored as they are, so
+// an encoding may be enabled on an existing tree provided that none of its
+// keys have parts beginning with EncodedPartPrefix.
+const EncodedPartPrefix = "~"
+
+// encodePart returns the stored form of part.
+func (t *Tree) encodePart(part string) string {
+	if t.KeyEncoding == KeyEncodingNone {
+		return part
+	}
+	if part != "" && utf8.ValidString(part) &&
+		!strings.Contains(part, t.SpecialCharacter) &&
+		!strings.HasPrefix(part, EncodedPartPrefix) {
+		return part
+	}
+	if t.KeyEncoding == KeyEncodingBase64URL {
+		return EncodedPartPrefix + base64.RawURLEncoding.EncodeToString([]byte(part))
+	}
+	return EncodedPartPrefix + hex.EncodeToString([]byte(part))
+}
+
+// decodePart is the inverse of encodePart. Parts that are not validly
+// encoded are returned as they are.
+func (t *Tree) decodePart(stored string) string {
+	if t.KeyEncoding == KeyEncodingNone || !strings.HasPrefix(stored, EncodedPartPrefix) {
+		return stored
+	}
+	encoded := stored[len(EncodedPartPrefix):]
+	var buf []byte
+	var err error
+	if t.KeyEncoding == KeyEncodingBase64URL {
+		buf, err = base64.RawURLEncoding.DecodeString(encoded)
+	} else {
+		buf, err = hex.DecodeString(encoded)
+	}
+	if err != nil {
+		return stored
+	}
+	return string(buf)
+}
+
+// transformKey returns key as transformed by KeyTransformer. The
+// transformer is given a copy of key, which it may modify.
+func (t *Tree) transformKey(key []string) ([]string, error) {
+	if t.KeyTransformer == nil {
+		return key, nil
+	}
+	return t.KeyTransformer(append([]string(nil), key...))
+}
+
+// transformLink applies KeyTransformer to the key and target of a link.
+func (t *Tree) transformLink(key, target []string) ([]string, []string, error) {
+	key, err := t.transformKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	target, err = t.transformKey(target)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, target, nil
+}
+
+// ValidateKey returns an error if key cannot be stored in the tree: if one
+// of its parts is empty (ErrEmptyKeyPart) or contains the reserved
+// character (*ReservedCharacterError), or if it is deeper than
+// MaxKeyDepth allows (*KeyDepthError). If KeyEncoding is set, any part is
+// allowed.
+func (t *Tree) ValidateKey(key []string) error {
+	t.initOnce.Do(t.init)
+	if t.MaxKeyDepth > 0 && len(key) > t.MaxKeyDepth {
+		return &KeyDepthError{Key: key, MaxKeyDepth: t.MaxKeyDepth}
+	}
+	return t.validateParts(key)
+}
+
+// validateParts checks each part of key, but not its depth.
+func (t *Tree) validateParts(key []string) error {
+	if t.KeyEncoding != KeyEncodingNone {
+		return nil
+	}
+	for _, part := range key {
+		if part == "" {
+			return ErrEmptyKeyPart
+		}
+		if strings.Contains(part, t.SpecialCharacter) {
+			return &ReservedCharacterError{Key: key, Component: part}
+		}
+	}
+	return nil
+}
+
+// ValidateSpecialCharacter returns a *SpecialCharacterError if sc cannot be
+// used as a Tree's SpecialCharacter.
+//
+// The SpecialCharacter may be any non-empty UTF-8 string, including one of
+// several characters, provided that keys delimited by it can be split
+// unambiguously. That requires that no proper prefix of sc is also a suffix
+// of it: with a SpecialCharacter of "+-+", for example, the parts "a+-"
+// and "b" would be encoded as "+-+a+-+-+b", which splits as "a", "-+b".
+// The SpecialCharacter must also not be a prefix of MetadataKey, and a
+// tree whose KeyEncoding is set also refuses one that contains
+// EncodedPartPrefix.
+func ValidateSpecialCharacter(sc string) error {
+	if sc == "" {
+		return &SpecialCharacterError{SpecialCharacter: sc, Problem: "it is empty"}
+	}
+	if !utf8.ValidString(sc) {
+		return &SpecialCharacterError{SpecialCharacter: sc, Problem: "it is not valid UTF-8"}
+	}
+	for n := 1; n < len(sc); n++ {
+		if sc[:n] == sc[len(sc)-n:] {
+			return &SpecialCharacterError{
+				SpecialCharacter: sc,
+				Problem:          "it begins and ends with " + sc[:n],
+			}
+		}
+	}
+	if strings.HasPrefix(MetadataKey, sc) {
+		return &SpecialCharacterError{
+			SpecialCharacter: sc,
+			Problem:          "it is a prefix of " + MetadataKey,
+		}
+	}
+	return nil
+}
+
+// checkSpecialCharacter returns a *SpecialCharacterError if the tree's
+// SpecialCharacter cannot be used, either by ValidateSpecialCharacter or
+// because it contains EncodedPartPrefix while KeyEncoding is set, which
+// would let an encoded part be mistaken for the delimiter.
+func (t *Tree) checkSpecialCharacter() error {
+	if err := ValidateSpecialCharacter(t.SpecialCharacter); err != nil {
+		return err
+	}
+	if t.KeyEncoding != KeyEncodingNone && strings.Contains(t.SpecialCharacter, EncodedPartPrefix) {
+		return &SpecialCharacterError{
+			SpecialCharacter: t.SpecialCharacter,
+			Problem:          "it contains EncodedPartPrefix " + EncodedPartPrefix + ", which KeyEncoding uses",
+		}
+	}
+	return nil
+}
+
+// childName returns the value of the Child attribute of the directory row
+// that records key, which must not be empty.
+func (t *Tree) childName(key []string) string {
+	return t.encodePart(key[len(key)-1])
+}
+
+// dirKey returns the value of the Key attribute of the rows that record
+// the children of prefix.
+func (t *Tree) dirKey(prefix []string) string {
+	return t.encodeKey(prefix, len(prefix) > 0)
+}
+
+// encodeKey returns the encoding of key, followed by SpecialCharacter
+// if trailing is true.
+func (t *Tree) encodeKey(key []string, trailing bool) string {
+	if t.KeyEncoding != KeyEncodingNone {
+		encoded := make([]string, len(key))
+		for i, part := range key {
+			encoded[i] = t.encodePart(part)
+		}
+		key = encoded
+	}
+
+	n := len(t.SpecialCharacter) * len(key)
+	if trailing || len(key) == 0 {
+		n += len(t.SpecialCharacter)
+	}
+	for _, part := range key {
+		n += len(part)
+	}
+
+	var b strings.Builder
+	b.Grow(n)
+	for _, part := range key {
+		b.WriteString(t.SpecialCharacter)
+		b.WriteString(part)
+	}
+	if trailing || len(key) == 0 {
+		b.WriteString(t.SpecialCharacter)
+	}
+	return b.String()
+}
@@ -0,0 +1,188 @@
+package dynamotree
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestEncodeKey(c *C) {
+	s := &Tree{}
+	c.Assert(s.EncodeKey([]string{"Accounts", "123456"}), Equals, "¦Accounts¦123456")
+	c.Assert(s.EncodeKey([]string{"Accounts"}), Equals, "¦Accounts")
+	c.Assert(s.EncodeKey([]string{}), Equals, "¦")
+	c.Assert(s.dirKey([]string{"Accounts", "123456"}), Equals, "¦Accounts¦123456¦")
+	c.Assert(s.dirKey([]string{}), Equals, "¦")
+
+	c.Assert(s.DecodeKey("¦Accounts¦123456"), DeepEquals, []string{"Accounts", "123456"})
+	c.Assert(s.DecodeKey("¦Accounts"), DeepEquals, []string{"Accounts"})
+	c.Assert(s.DecodeKey("¦"), DeepEquals, []string{})
+	c.Assert(s.DecodeKey("Accounts"), IsNil)
+
+	s = &Tree{SpecialCharacter: "/"}
+	c.Assert(s.EncodeKey([]string{"Accounts", "123456"}), Equals, "/Accounts/123456")
+	c.Assert(s.DecodeKey("/Accounts/123456"), DeepEquals, []string{"Accounts", "123456"})
+}
+
+func (suite *StoreImplTest) TestValidateKey(c *C) {
+	s := &Tree{MaxKeyDepth: 2}
+	c.Assert(s.ValidateKey([]string{"Accounts", "123456"}), IsNil)
+	c.Assert(s.ValidateKey([]string{}), IsNil)
+	c.Assert(s.ValidateKey([]string{"Accounts", "12¦3456"}), ErrorIs, ErrReservedCharacterInKey)
+	c.Assert(s.ValidateKey([]string{"Accounts", ""}), Equals, ErrEmptyKeyPart)
+	c.Assert(s.ValidateKey([]string{"a", "b", "c"}), FitsTypeOf, &KeyDepthError{})
+}
+
+func (suite *StoreImplTest) TestMultiCharacterSpecialCharacter(c *C) {
+	s := &Tree{SpecialCharacter: "<sep>"}
+	c.Assert(s.EncodeKey([]string{"Accounts", "a<b"}), Equals, "<sep>Accounts<sep>a<b")
+	c.Assert(s.DecodeKey("<sep>Accounts<sep>a<b"), DeepEquals, []string{"Accounts", "a<b"})
+	c.Assert(s.dirKey([]string{"Accounts"}), Equals, "<sep>Accounts<sep>")
+	c.Assert(s.ValidateKey([]string{"Accounts", "a<sep>b"}), ErrorIs, ErrReservedCharacterInKey)
+
+	// parts that end with part of the delimiter, where the delimiter cannot
+	// overlap itself, still round trip
+	s = &Tree{SpecialCharacter: "→|"}
+	key := []string{"a→", "|b", "→"}
+	c.Assert(s.ValidateKey(key), IsNil)
+	c.Assert(s.DecodeKey(s.EncodeKey(key)), DeepEquals, key)
+}
+
+func (suite *StoreImplTest) TestValidateSpecialCharacter(c *C) {
+	for _, sc := range []string{"¦", "/", "→|", "<sep>"} {
+		c.Assert(ValidateSpecialCharacter(sc), IsNil, Commentf("%q", sc))
+	}
+	c.Assert(ValidateSpecialCharacter(""), ErrorMatches, `SpecialCharacter "" cannot be used because it is empty`)
+	c.Assert(ValidateSpecialCharacter("\xff"), ErrorMatches, `.* because it is not valid UTF-8`)
+	c.Assert(ValidateSpecialCharacter("::"), ErrorMatches, `.* because it begins and ends with :`)
+	c.Assert(ValidateSpecialCharacter("+-+"), ErrorMatches, `.* because it begins and ends with \+`)
+	c.Assert(ValidateSpecialCharacter("abab"), ErrorMatches, `.* because it begins and ends with ab`)
+	c.Assert(ValidateSpecialCharacter("_d"), ErrorMatches, `.* because it is a prefix of _dynamotree`)
+
+	s := &Tree{TableName: "unused", SpecialCharacter: "+-+"}
+	c.Assert(s.Put([]string{"Accounts"}, &AccountT{}), FitsTypeOf, &SpecialCharacterError{})
+}
+
+func (suite *StoreImplTest) TestKeyEncoding(c *C) {
+	s := &Tree{KeyEncoding: KeyEncodingHex}
+	c.Assert(s.EncodeKey([]string{"Accounts", "a¦b", "", "~x", "\xff"}), Equals,
+		"¦Accounts¦~61c2a662¦~¦~7e78¦~ff")
+	c.Assert(s.DecodeKey("¦Accounts¦~61c2a662¦~¦~7e78¦~ff"), DeepEquals,
+		[]string{"Accounts", "a¦b", "", "~x", "\xff"})
+	c.Assert(s.dirKey([]string{"a¦b"}), Equals, "¦~61c2a662¦")
+	c.Assert(s.ValidateKey([]string{"a¦b", ""}), IsNil)
+
+	s = &Tree{KeyEncoding: KeyEncodingBase64URL}
+	c.Assert(s.EncodeKey([]string{"Accounts", "a¦b"}), Equals, "¦Accounts¦~YcKmYg")
+	c.Assert(s.DecodeKey("¦Accounts¦~YcKmYg"), DeepEquals, []string{"Accounts", "a¦b"})
+
+	// parts that happen to look encoded, but aren't, are returned as they are
+	c.Assert(s.DecodeKey("¦~!"), DeepEquals, []string{"~!"})
+
+	// a SpecialCharacter containing EncodedPartPrefix would be found in
+	// encoded parts
+	for _, sc := range []string{"~", "~a", "#~"} {
+		s = &Tree{TableName: "Accounts", Region: "us-east-1", KeyEncoding: KeyEncodingHex, SpecialCharacter: sc}
+		err := s.CheckConfig()
+		c.Assert(err, FitsTypeOf, &SpecialCharacterError{})
+		c.Assert(err, ErrorMatches, `SpecialCharacter ".*" cannot be used because it contains EncodedPartPrefix ~, which KeyEncoding uses`)
+	}
+	s = &Tree{TableName: "Accounts", Region: "us-east-1", SpecialCharacter: "~"}
+	c.Assert(s.CheckConfig(), IsNil)
+}
+
+func (suite *StoreImplTest) TestKeyEncodingStorage(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db, KeyEncoding: KeyEncodingHex}
+	err := s.CreateTable()
+	c.Assert(err, IsNil)
+
+	v := AccountT{ID: "12345", Name: "alice"}
+	binary := string([]byte{0, 1, 0xff})
+	c.Assert(s.Put([]string{"Files", "a/b¦c", binary}, &v), IsNil)
+	c.Assert(s.Put([]string{"Files", "plain"}, &v), IsNil)
+
+	var v2 AccountT
+	c.Assert(s.Get([]string{"Files", "a/b¦c", binary}, &v2), IsNil)
+	c.Assert(v2, DeepEquals, v)
+
+	keys := [][]string{}
+	s.Walk(nil, func(key []string, err error) bool {
+		c.Assert(err, IsNil)
+		keys = append(keys, key)
+		return true
+	})
+	c.Assert(keys, DeepEquals, [][]string{
+		{"Files"},
+		{"Files", "plain"},
+		{"Files", "a/b¦c"},
+		{"Files", "a/b¦c", binary},
+	})
+
+	c.Assert(s.Delete([]string{"Files", "a/b¦c", binary}), IsNil)
+	result, err := s.GC(nil)
+	c.Assert(err, IsNil)
+	c.Assert(result.EmptyDirectories, DeepEquals, [][]string{{"Files", "a/b¦c"}})
+	items := []string{}
+	s.List([]string{"Files"}, func(item string, err error) bool {
+		c.Assert(err, IsNil)
+		items = append(items, item)
+		return true
+	})
+	c.Assert(items, DeepEquals, []string{"plain"})
+}
+
+func (suite *StoreImplTest) TestKeyTransformer(c *C) {
+	errTooLong := errors.New("too long")
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db,
+		KeyTransformer: func(key []string) ([]string, error) {
+			for i, part := range key {
+				if len(part) > 10 {
+					return nil, errTooLong
+				}
+				key[i] = strings.ToLower(part)
+			}
+			return key, nil
+		},
+	}
+	c.Assert(s.CreateTable(), IsNil)
+
+	v := AccountT{ID: "12345", Name: "alice"}
+	key := []string{"Accounts", "Alice"}
+	c.Assert(s.Put(key, &v), IsNil)
+	c.Assert(key, DeepEquals, []string{"Accounts", "Alice"})
+	c.Assert(s.PutLink([]string{"Users", "ALICE"}, key), IsNil)
+
+	var v2 AccountT
+	c.Assert(s.Get([]string{"accounts", "alice"}, &v2), IsNil)
+	c.Assert(v2, DeepEquals, v)
+	c.Assert(s.Get([]string{"users", "Alice"}, &v2), IsNil)
+	target, err := s.GetLink([]string{"Users", "Alice"})
+	c.Assert(err, IsNil)
+	c.Assert(target, DeepEquals, []string{"accounts", "alice"})
+
+	keys := [][]string{}
+	s.Walk([]string{"ACCOUNTS"}, func(key []string, err error) bool {
+		c.Assert(err, IsNil)
+		keys = append(keys, key)
+		return true
+	})
+	c.Assert(keys, DeepEquals, [][]string{{"accounts", "alice"}})
+
+	c.Assert(s.Put([]string{"Accounts", "AVeryLongName"}, &v), Equals, errTooLong)
+	c.Assert(s.Get([]string{"Accounts", "AVeryLongName"}, &v2), Equals, errTooLong)
+	c.Assert(s.PutLink([]string{"Users", "bob"}, []string{"Accounts", "AVeryLongName"}), Equals, errTooLong)
+	s.List([]string{"AVeryLongName"}, func(item string, err error) bool {
+		c.Assert(err, Equals, errTooLong)
+		return false
+	})
+
+	c.Assert(s.Delete([]string{"ACCOUNTS", "ALICE"}), IsNil)
+	c.Assert(s.Get(key, &v2), ErrorIs, ErrNotFound)
+}
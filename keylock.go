@@ -0,0 +1,63 @@
+package dynamotree
+
+import (
+	"fmt"
+	"sync"
+)
+
+// KeyLocker serializes the writes that a process makes to each key. Lock
+// blocks until the caller holds the lock for key, and returns the function
+// that releases it.
+type KeyLocker interface {
+	Lock(key []string) (unlock func())
+}
+
+// KeyMutex is a KeyLocker that holds a mutex for each key being written
+// in the process. The zero value is ready to use.
+type KeyMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+
+	// refs is the number of callers holding or waiting for the lock. The
+	// lock is forgotten once there are none.
+	refs int
+}
+
+// Lock implements KeyLocker.
+func (m *KeyMutex) Lock(key []string) func() {
+	id := fmt.Sprintf("%q", key)
+	m.mu.Lock()
+	if m.locks == nil {
+		m.locks = map[string]*keyLock{}
+	}
+	l, ok := m.locks[id]
+	if !ok {
+		l = &keyLock{}
+		m.locks[id] = l
+	}
+	l.refs++
+	m.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		m.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(m.locks, id)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// lockKey locks key using KeyLocker, if the tree has one, and returns the
+// function that unlocks it.
+func (t *Tree) lockKey(key []string) func() {
+	if t.KeyLocker == nil {
+		return func() {}
+	}
+	return t.KeyLocker.Lock(key)
+}
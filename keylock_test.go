@@ -0,0 +1,90 @@
+package dynamotree
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+// countingLocker is a KeyLocker that records the most callers that held
+// the lock for each key at once.
+type countingLocker struct {
+	KeyMutex
+	mu      sync.Mutex
+	holding map[string]int
+	max     map[string]int
+}
+
+func (l *countingLocker) Lock(key []string) func() {
+	unlock := l.KeyMutex.Lock(key)
+	id := fmt.Sprint(key)
+	l.mu.Lock()
+	l.holding[id]++
+	if l.holding[id] > l.max[id] {
+		l.max[id] = l.holding[id]
+	}
+	l.mu.Unlock()
+	return func() {
+		l.mu.Lock()
+		l.holding[id]--
+		l.mu.Unlock()
+		unlock()
+	}
+}
+
+func (suite *StoreImplTest) TestKeyMutex(c *C) {
+	var m KeyMutex
+	unlock := m.Lock([]string{"Accounts", "alice"})
+
+	// Other keys are not locked
+	m.Lock([]string{"Accounts"})()
+	m.Lock([]string{"Accounts", "bob"})()
+
+	locked := make(chan bool)
+	go func() {
+		unlock := m.Lock([]string{"Accounts", "alice"})
+		locked <- true
+		unlock()
+	}()
+	select {
+	case <-locked:
+		c.Fatal("expected Lock to block")
+	case <-time.After(20 * time.Millisecond):
+	}
+	unlock()
+	<-locked
+	m.mu.Lock()
+	c.Assert(m.locks, HasLen, 0)
+	m.mu.Unlock()
+}
+
+func (suite *StoreImplTest) TestKeyLocker(c *C) {
+	locker := &countingLocker{holding: map[string]int{}, max: map[string]int{}}
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db, KeyLocker: locker}
+	c.Assert(s.CreateTable(), IsNil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := []string{"Accounts", "alice"}
+			v := AccountT{ID: fmt.Sprint(i), Name: "alice"}
+			c.Check(s.Put(key, &v), IsNil)
+			c.Check(s.PutLink([]string{"ByID", v.ID}, key), IsNil)
+			if i%2 == 0 {
+				c.Check(s.Delete(key), IsNil)
+			}
+		}(i)
+	}
+	wg.Wait()
+	c.Assert(locker.max[fmt.Sprint([]string{"Accounts", "alice"})], Equals, 1)
+	c.Assert(locker.max, HasLen, 11)
+	c.Assert(locker.locks, HasLen, 0)
+}
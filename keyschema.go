@@ -0,0 +1,635 @@
+package dynamotree
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// KeySchemaMapping describes how the rows of a tree are stored in a table
+// that was not created for it, such as one shared with other data whose
+// key attributes have other names. The tree refers to its attributes as
+// "Key" and "Child" throughout, and the mapping is applied to each request
+// it sends to the table and to each response, for example:
+//
+//	tree := &dynamotree.Tree{
+//		TableName: "Shared",
+//		KeySchema: &dynamotree.KeySchemaMapping{
+//			HashAttribute:  "PK",
+//			RangeAttribute: "SK",
+//			KeyPrefix:      "tree#",
+//			TypeAttribute:  "EntityType",
+//			TypeValue:      "dynamotree",
+//		},
+//	}
+//
+// Mirror and Backup read the table's stream, which is not mapped, so
+// Mirror.Start and Backup.Run return ErrKeySchemaStream for a tree that has
+// a KeySchema. ReadTableExport likewise expects the tree's own names.
+type KeySchemaMapping struct {
+	// HashAttribute is the name of the table's hash key. If empty, "Key"
+	// is used.
+	HashAttribute string
+
+	// RangeAttribute is the name of the table's range key. If empty,
+	// "Child" is used.
+	RangeAttribute string
+
+	// KeyPrefix, if not empty, is added to the hash key of each of the
+	// tree's rows, so that they do not share partitions with other data in
+	// the table. Rows that do not have the prefix are left out of the
+	// results of queries and scans.
+	KeyPrefix string
+
+	// TypeAttribute and TypeValue, if set, name an attribute that is
+	// written with the value TypeValue in each of the tree's rows, so that
+	// other readers of the table can tell them apart. Queries and scans
+	// are filtered on it, and a row without it is treated as missing. The
+	// attribute is removed from items before they are unmarshalled.
+	// Tree.ItemType sets both.
+	TypeAttribute string
+	TypeValue     string
+}
+
+// ErrKeySchemaStream is returned by Mirror.Start, Backup.Run and the
+// methods of ExpiryCleaner for a tree that has a KeySchema or an ItemType.
+var ErrKeySchemaStream = errors.New("the table's stream cannot be read through a KeySchema")
+
+// keySchemaHandlerName is the name of the handlers that apply a tree's
+// KeySchemaMapping.
+const keySchemaHandlerName = "dynamotree.KeySchemaMapping"
+
+// hashAttribute returns the name of the table's hash key.
+func (m *KeySchemaMapping) hashAttribute() string {
+	if m == nil || m.HashAttribute == "" {
+		return "Key"
+	}
+	return m.HashAttribute
+}
+
+// rangeAttribute returns the name of the table's range key.
+func (m *KeySchemaMapping) rangeAttribute() string {
+	if m == nil || m.RangeAttribute == "" {
+		return "Child"
+	}
+	return m.RangeAttribute
+}
+
+// prefix returns KeyPrefix.
+func (m *KeySchemaMapping) prefix() string {
+	if m == nil {
+		return ""
+	}
+	return m.KeyPrefix
+}
+
+// check returns a *ConfigError if the mapping cannot be used.
+func (m *KeySchemaMapping) check() error {
+	if m == nil {
+		return nil
+	}
+	if m.hashAttribute() == m.rangeAttribute() {
+		return &ConfigError{Field: "KeySchema", Problem: fmt.Sprintf(
+			"the hash and range attributes are both %q", m.hashAttribute())}
+	}
+	if (m.TypeAttribute == "") != (m.TypeValue == "") {
+		return &ConfigError{Field: "KeySchema", Problem: "TypeAttribute and TypeValue must be set together"}
+	}
+	switch m.TypeAttribute {
+	case "Key", "Child", m.hashAttribute(), m.rangeAttribute():
+		return &ConfigError{Field: "KeySchema", Problem: fmt.Sprintf(
+			"TypeAttribute %q is a key attribute", m.TypeAttribute)}
+	}
+	return nil
+}
+
+// withKeySchema returns a copy of db that applies km to the requests it
+// sends and to their responses. The copy shares db's configuration and
+// retryer.
+func withKeySchema(db *dynamodb.DynamoDB, km *keyMapper) *dynamodb.DynamoDB {
+	c := *db.Client
+	c.Handlers = db.Handlers.Copy()
+	c.Handlers.Build.RemoveByName(keySchemaHandlerName)
+	c.Handlers.Unmarshal.RemoveByName(keySchemaHandlerName)
+	c.Handlers.Build.PushFrontNamed(request.NamedHandler{Name: keySchemaHandlerName, Fn: km.mapRequest})
+	c.Handlers.Unmarshal.PushBackNamed(request.NamedHandler{Name: keySchemaHandlerName, Fn: km.mapResponse})
+	rv := *db
+	rv.Client = &c
+	return &rv
+}
+
+// keyMapper applies a KeySchemaMapping to the requests for one table.
+type keyMapper struct {
+	table string
+	m     *KeySchemaMapping
+}
+
+// mapRequest replaces the parameters of r, if they are for the mapper's
+// table, with a copy in which the tree's attribute names and hash keys are
+// those of the table. The caller's parameters are not modified.
+func (km *keyMapper) mapRequest(r *request.Request) {
+	switch in := r.Params.(type) {
+	case *dynamodb.GetItemInput:
+		if aws.StringValue(in.TableName) != km.table {
+			return
+		}
+		r.Params = km.getItemInput(in)
+	case *dynamodb.PutItemInput:
+		if aws.StringValue(in.TableName) != km.table {
+			return
+		}
+		in = awsutil.CopyOf(in).(*dynamodb.PutItemInput)
+		in.Item = km.toTableItem(in.Item)
+		km.values(in.ExpressionAttributeNames, in.ExpressionAttributeValues, in.ConditionExpression)
+		km.names(in.ExpressionAttributeNames)
+		r.Params = in
+	case *dynamodb.DeleteItemInput:
+		if aws.StringValue(in.TableName) != km.table {
+			return
+		}
+		in = awsutil.CopyOf(in).(*dynamodb.DeleteItemInput)
+		in.Key = km.toTable(in.Key)
+		km.values(in.ExpressionAttributeNames, in.ExpressionAttributeValues, in.ConditionExpression)
+		km.names(in.ExpressionAttributeNames)
+		r.Params = in
+	case *dynamodb.UpdateItemInput:
+		if aws.StringValue(in.TableName) != km.table {
+			return
+		}
+		in = awsutil.CopyOf(in).(*dynamodb.UpdateItemInput)
+		in.Key = km.toTable(in.Key)
+		km.values(in.ExpressionAttributeNames, in.ExpressionAttributeValues, in.ConditionExpression)
+		km.names(in.ExpressionAttributeNames)
+		km.stampUpdate(in)
+		r.Params = in
+	case *dynamodb.QueryInput:
+		if aws.StringValue(in.TableName) != km.table {
+			return
+		}
+		in = awsutil.CopyOf(in).(*dynamodb.QueryInput)
+		in.ExclusiveStartKey = km.toTable(in.ExclusiveStartKey)
+		km.values(in.ExpressionAttributeNames, in.ExpressionAttributeValues, in.KeyConditionExpression, in.FilterExpression)
+		km.names(in.ExpressionAttributeNames)
+		km.typeProjection(&in.ExpressionAttributeNames, &in.ProjectionExpression)
+		km.typeFilter(&in.ExpressionAttributeNames, &in.ExpressionAttributeValues, &in.FilterExpression)
+		r.Params = in
+	case *dynamodb.ScanInput:
+		if aws.StringValue(in.TableName) != km.table {
+			return
+		}
+		in = awsutil.CopyOf(in).(*dynamodb.ScanInput)
+		in.ExclusiveStartKey = km.toTable(in.ExclusiveStartKey)
+		km.values(in.ExpressionAttributeNames, in.ExpressionAttributeValues, in.FilterExpression)
+		km.names(in.ExpressionAttributeNames)
+		km.typeProjection(&in.ExpressionAttributeNames, &in.ProjectionExpression)
+		km.typeFilter(&in.ExpressionAttributeNames, &in.ExpressionAttributeValues, &in.FilterExpression)
+		r.Params = in
+	case *dynamodb.BatchGetItemInput:
+		if in.RequestItems[km.table] == nil {
+			return
+		}
+		in = awsutil.CopyOf(in).(*dynamodb.BatchGetItemInput)
+		ka := in.RequestItems[km.table]
+		for i := range ka.Keys {
+			ka.Keys[i] = km.toTable(ka.Keys[i])
+		}
+		km.names(ka.ExpressionAttributeNames)
+		km.typeProjection(&ka.ExpressionAttributeNames, &ka.ProjectionExpression)
+		r.Params = in
+	case *dynamodb.BatchWriteItemInput:
+		if in.RequestItems[km.table] == nil {
+			return
+		}
+		in = awsutil.CopyOf(in).(*dynamodb.BatchWriteItemInput)
+		for _, wr := range in.RequestItems[km.table] {
+			if wr.PutRequest != nil {
+				wr.PutRequest.Item = km.toTableItem(wr.PutRequest.Item)
+			}
+			if wr.DeleteRequest != nil {
+				wr.DeleteRequest.Key = km.toTable(wr.DeleteRequest.Key)
+			}
+		}
+		r.Params = in
+	case *dynamodb.TransactGetItemsInput:
+		in = awsutil.CopyOf(in).(*dynamodb.TransactGetItemsInput)
+		for _, ti := range in.TransactItems {
+			if ti.Get != nil && aws.StringValue(ti.Get.TableName) == km.table {
+				ti.Get.Key = km.toTable(ti.Get.Key)
+				km.names(ti.Get.ExpressionAttributeNames)
+				km.typeProjection(&ti.Get.ExpressionAttributeNames, &ti.Get.ProjectionExpression)
+			}
+		}
+		r.Params = in
+	case *dynamodb.TransactWriteItemsInput:
+		in = awsutil.CopyOf(in).(*dynamodb.TransactWriteItemsInput)
+		for _, ti := range in.TransactItems {
+			switch {
+			case ti.Put != nil && aws.StringValue(ti.Put.TableName) == km.table:
+				ti.Put.Item = km.toTableItem(ti.Put.Item)
+				km.values(ti.Put.ExpressionAttributeNames, ti.Put.ExpressionAttributeValues, ti.Put.ConditionExpression)
+				km.names(ti.Put.ExpressionAttributeNames)
+			case ti.Delete != nil && aws.StringValue(ti.Delete.TableName) == km.table:
+				ti.Delete.Key = km.toTable(ti.Delete.Key)
+				km.values(ti.Delete.ExpressionAttributeNames, ti.Delete.ExpressionAttributeValues, ti.Delete.ConditionExpression)
+				km.names(ti.Delete.ExpressionAttributeNames)
+			case ti.Update != nil && aws.StringValue(ti.Update.TableName) == km.table:
+				ti.Update.Key = km.toTable(ti.Update.Key)
+				km.values(ti.Update.ExpressionAttributeNames, ti.Update.ExpressionAttributeValues, ti.Update.ConditionExpression)
+				km.names(ti.Update.ExpressionAttributeNames)
+			case ti.ConditionCheck != nil && aws.StringValue(ti.ConditionCheck.TableName) == km.table:
+				ti.ConditionCheck.Key = km.toTable(ti.ConditionCheck.Key)
+				km.values(ti.ConditionCheck.ExpressionAttributeNames, ti.ConditionCheck.ExpressionAttributeValues, ti.ConditionCheck.ConditionExpression)
+				km.names(ti.ConditionCheck.ExpressionAttributeNames)
+			}
+		}
+		r.Params = in
+	}
+}
+
+// mapResponse rewrites the output of r, if its request was for the mapper's
+// table, so that the items in it have the tree's attribute names and hash
+// keys. Items from a query or scan that are not the tree's are removed.
+func (km *keyMapper) mapResponse(r *request.Request) {
+	switch out := r.Data.(type) {
+	case *dynamodb.GetItemOutput:
+		if km.forTable(r) {
+			out.Item = km.fromTableRow(out.Item)
+		}
+	case *dynamodb.PutItemOutput:
+		if km.forTable(r) {
+			out.Attributes = km.fromTableRow(out.Attributes)
+		}
+	case *dynamodb.DeleteItemOutput:
+		if km.forTable(r) {
+			out.Attributes = km.fromTableRow(out.Attributes)
+		}
+	case *dynamodb.UpdateItemOutput:
+		if km.forTable(r) {
+			out.Attributes = km.fromTableRow(out.Attributes)
+		}
+	case *dynamodb.QueryOutput:
+		if km.forTable(r) {
+			var dropped int
+			out.Items, dropped = km.fromTableItems(out.Items)
+			out.Count = aws.Int64(aws.Int64Value(out.Count) - int64(dropped))
+			out.LastEvaluatedKey = km.fromTableKey(out.LastEvaluatedKey)
+		}
+	case *dynamodb.ScanOutput:
+		if km.forTable(r) {
+			var dropped int
+			out.Items, dropped = km.fromTableItems(out.Items)
+			out.Count = aws.Int64(aws.Int64Value(out.Count) - int64(dropped))
+			out.LastEvaluatedKey = km.fromTableKey(out.LastEvaluatedKey)
+		}
+	case *dynamodb.BatchGetItemOutput:
+		if items, ok := out.Responses[km.table]; ok {
+			out.Responses[km.table], _ = km.fromTableItems(items)
+		}
+		if ka := out.UnprocessedKeys[km.table]; ka != nil {
+			for i := range ka.Keys {
+				ka.Keys[i] = km.fromTableKey(ka.Keys[i])
+			}
+			for k, v := range ka.ExpressionAttributeNames {
+				ka.ExpressionAttributeNames[k] = aws.String(km.treeName(aws.StringValue(v)))
+			}
+		}
+	case *dynamodb.BatchWriteItemOutput:
+		for _, wr := range out.UnprocessedItems[km.table] {
+			if wr.PutRequest != nil {
+				wr.PutRequest.Item, _ = km.fromTable(wr.PutRequest.Item)
+			}
+			if wr.DeleteRequest != nil {
+				wr.DeleteRequest.Key = km.fromTableKey(wr.DeleteRequest.Key)
+			}
+		}
+	case *dynamodb.TransactGetItemsOutput:
+		in, _ := r.Params.(*dynamodb.TransactGetItemsInput)
+		for i, resp := range out.Responses {
+			if in == nil || i >= len(in.TransactItems) || in.TransactItems[i].Get == nil ||
+				aws.StringValue(in.TransactItems[i].Get.TableName) != km.table {
+				continue
+			}
+			resp.Item = km.fromTableRow(resp.Item)
+		}
+	}
+}
+
+// getItemInput returns a copy of in as it is sent to the table.
+func (km *keyMapper) getItemInput(in *dynamodb.GetItemInput) *dynamodb.GetItemInput {
+	in = awsutil.CopyOf(in).(*dynamodb.GetItemInput)
+	in.Key = km.toTable(in.Key)
+	km.names(in.ExpressionAttributeNames)
+	km.typeProjection(&in.ExpressionAttributeNames, &in.ProjectionExpression)
+	return in
+}
+
+// forTable returns true if r is a request for the mapper's table.
+func (km *keyMapper) forTable(r *request.Request) bool {
+	var table *string
+	switch in := r.Params.(type) {
+	case *dynamodb.GetItemInput:
+		table = in.TableName
+	case *dynamodb.PutItemInput:
+		table = in.TableName
+	case *dynamodb.DeleteItemInput:
+		table = in.TableName
+	case *dynamodb.UpdateItemInput:
+		table = in.TableName
+	case *dynamodb.QueryInput:
+		table = in.TableName
+	case *dynamodb.ScanInput:
+		table = in.TableName
+	}
+	return aws.StringValue(table) == km.table
+}
+
+// tableName returns the name in the table of the tree's attribute name.
+func (km *keyMapper) tableName(name string) string {
+	switch name {
+	case "Key":
+		return km.m.hashAttribute()
+	case "Child":
+		return km.m.rangeAttribute()
+	}
+	return name
+}
+
+// treeName returns the tree's name for the table's attribute name.
+func (km *keyMapper) treeName(name string) string {
+	switch name {
+	case km.m.hashAttribute():
+		return "Key"
+	case km.m.rangeAttribute():
+		return "Child"
+	}
+	return name
+}
+
+// toTable returns a copy of the key or item, as the tree writes it, as it
+// is stored in the table.
+func (km *keyMapper) toTable(item map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	if item == nil {
+		return nil
+	}
+	rv := make(map[string]*dynamodb.AttributeValue, len(item))
+	for name, value := range item {
+		if name == "Key" && value != nil && value.S != nil {
+			if s := *value.S; strings.HasPrefix(s, foreignKeyMarker) {
+				value = &dynamodb.AttributeValue{S: aws.String(strings.TrimPrefix(s, foreignKeyMarker))}
+			} else {
+				value = &dynamodb.AttributeValue{S: aws.String(km.m.prefix() + s)}
+			}
+		}
+		rv[km.tableName(name)] = value
+	}
+	return rv
+}
+
+// toTableItem is toTable for an item that is written whole, to which the
+// type attribute is added.
+func (km *keyMapper) toTableItem(item map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	rv := km.toTable(item)
+	if rv != nil && km.m.TypeAttribute != "" {
+		rv[km.m.TypeAttribute] = &dynamodb.AttributeValue{S: aws.String(km.m.TypeValue)}
+	}
+	return rv
+}
+
+// stampUpdate adds the type attribute to the attributes that in sets, so
+// that an update that creates a row marks it as the tree's.
+func (km *keyMapper) stampUpdate(in *dynamodb.UpdateItemInput) {
+	if km.m.TypeAttribute == "" || in.UpdateExpression == nil {
+		return
+	}
+	if in.ExpressionAttributeNames == nil {
+		in.ExpressionAttributeNames = map[string]*string{}
+	}
+	if in.ExpressionAttributeValues == nil {
+		in.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{}
+	}
+	in.ExpressionAttributeNames[typeName] = aws.String(km.m.TypeAttribute)
+	in.ExpressionAttributeValues[typeValue] = &dynamodb.AttributeValue{S: aws.String(km.m.TypeValue)}
+	expr := *in.UpdateExpression
+	stamp := typeName + " = " + typeValue
+	for _, tok := range tokenizeExpression(expr) {
+		if tok.kind == tokenWord && strings.EqualFold(tok.text, "SET") {
+			in.UpdateExpression = aws.String(expr[:tok.end] + " " + stamp + "," + expr[tok.end:])
+			return
+		}
+	}
+	in.UpdateExpression = aws.String("SET " + stamp + " " + expr)
+}
+
+// fromTable returns a copy of the item, as it is stored in the table, as
+// the tree reads it. It returns false if the item is not one of the tree's
+// rows: if its hash key does not have the KeyPrefix, or it does not have
+// the type attribute with TypeValue.
+func (km *keyMapper) fromTable(item map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, bool) {
+	if item == nil {
+		return nil, true
+	}
+	rv := make(map[string]*dynamodb.AttributeValue, len(item))
+	ok := true
+	if km.m.TypeAttribute != "" {
+		v := item[km.m.TypeAttribute]
+		ok = v != nil && aws.StringValue(v.S) == km.m.TypeValue
+	}
+	for name, value := range item {
+		if km.m.TypeAttribute != "" && name == km.m.TypeAttribute {
+			continue
+		}
+		name = km.treeName(name)
+		if name == "Key" && value != nil && value.S != nil && km.m.prefix() != "" {
+			if !strings.HasPrefix(*value.S, km.m.prefix()) {
+				ok = false
+			}
+			value = &dynamodb.AttributeValue{S: aws.String(strings.TrimPrefix(*value.S, km.m.prefix()))}
+		}
+		rv[name] = value
+	}
+	return rv, ok
+}
+
+// foreignKeyMarker begins the hash key, in a LastEvaluatedKey, of a row
+// that does not have the tree's KeyPrefix, so that toTable gives it back to
+// the table unchanged when the key is used to continue a scan. Encoded keys
+// never begin with it.
+const foreignKeyMarker = "\x00"
+
+// fromTableKey is fromTable for a key, such as LastEvaluatedKey, which is
+// kept whether or not it has the prefix, so that paging can continue.
+func (km *keyMapper) fromTableKey(key map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	if key == nil {
+		return nil
+	}
+	rv := make(map[string]*dynamodb.AttributeValue, len(key))
+	for name, value := range key {
+		name = km.treeName(name)
+		if name == "Key" && value != nil && value.S != nil && km.m.prefix() != "" {
+			if strings.HasPrefix(*value.S, km.m.prefix()) {
+				value = &dynamodb.AttributeValue{S: aws.String(strings.TrimPrefix(*value.S, km.m.prefix()))}
+			} else {
+				value = &dynamodb.AttributeValue{S: aws.String(foreignKeyMarker + *value.S)}
+			}
+		}
+		rv[name] = value
+	}
+	return rv
+}
+
+// fromTableRow is fromTable for the row of a single key, which is nil if
+// the row is not the tree's.
+func (km *keyMapper) fromTableRow(item map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	rv, ok := km.fromTable(item)
+	if !ok {
+		return nil
+	}
+	return rv
+}
+
+// fromTableItems returns the items that are the tree's rows, as the tree
+// reads them, and the number of items that were left out.
+func (km *keyMapper) fromTableItems(items []map[string]*dynamodb.AttributeValue) ([]map[string]*dynamodb.AttributeValue, int) {
+	rv := items[:0]
+	for _, item := range items {
+		if item, ok := km.fromTable(item); ok {
+			rv = append(rv, item)
+		}
+	}
+	return rv, len(items) - len(rv)
+}
+
+// names renames, in place, the attribute names of an expression.
+func (km *keyMapper) names(names map[string]*string) {
+	for k, v := range names {
+		if v != nil {
+			names[k] = aws.String(km.tableName(*v))
+		}
+	}
+}
+
+// tokenKind is the kind of an exprToken.
+type tokenKind int
+
+const (
+	tokenWord   tokenKind = iota // a keyword, function or attribute name
+	tokenName                    // an attribute name placeholder, such as #K
+	tokenValue                   // a value placeholder, such as :key
+	tokenSymbol                  // an operator or punctuation
+)
+
+// exprToken is a token of an expression, which runs from start to end in
+// the expression.
+type exprToken struct {
+	kind       tokenKind
+	text       string
+	start, end int
+}
+
+// tokenizeExpression splits a condition, key condition, filter or update
+// expression into tokens, so that keywords are not confused with the text
+// of the names and placeholders that contain them.
+func tokenizeExpression(expr string) []exprToken {
+	isWord := func(c byte) bool {
+		return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	}
+	tokens := []exprToken{}
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		start := i
+		var kind tokenKind
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '#' || c == ':' || isWord(c):
+			kind = tokenWord
+			if c == '#' {
+				kind = tokenName
+			} else if c == ':' {
+				kind = tokenValue
+			}
+			for i++; i < len(expr) && isWord(expr[i]); i++ {
+			}
+		case strings.HasPrefix(expr[i:], "<>") || strings.HasPrefix(expr[i:], "<=") || strings.HasPrefix(expr[i:], ">="):
+			kind, i = tokenSymbol, i+2
+		default:
+			kind, i = tokenSymbol, i+1
+		}
+		tokens = append(tokens, exprToken{kind: kind, text: expr[start:i], start: start, end: i})
+	}
+	return tokens
+}
+
+// isComparator returns true if tok is a comparison operator.
+func (tok exprToken) isComparator() bool {
+	switch tok.text {
+	case "=", "<>", "<", "<=", ">", ">=":
+		return tok.kind == tokenSymbol
+	}
+	return false
+}
+
+// comparedValues returns, for each attribute name placeholder in the
+// tokens of an expression, the value placeholders it is compared with by
+// a comparison, begins_with, BETWEEN or IN.
+func comparedValues(tokens []exprToken) map[string][]string {
+	rv := map[string][]string{}
+	is := func(i int, kind tokenKind, text string) bool {
+		return i < len(tokens) && tokens[i].kind == kind && (text == "" || strings.EqualFold(tokens[i].text, text))
+	}
+	for i, tok := range tokens {
+		switch {
+		case tok.kind == tokenName && i+2 < len(tokens) && tokens[i+1].isComparator() && is(i+2, tokenValue, ""):
+			rv[tok.text] = append(rv[tok.text], tokens[i+2].text)
+		case tok.kind == tokenValue && i+2 < len(tokens) && tokens[i+1].isComparator() && is(i+2, tokenName, ""):
+			rv[tokens[i+2].text] = append(rv[tokens[i+2].text], tok.text)
+		case is(i, tokenWord, "begins_with") && is(i+1, tokenSymbol, "(") && is(i+2, tokenName, "") &&
+			is(i+3, tokenSymbol, ",") && is(i+4, tokenValue, "") && is(i+5, tokenSymbol, ")"):
+			rv[tokens[i+2].text] = append(rv[tokens[i+2].text], tokens[i+4].text)
+		case tok.kind == tokenName && is(i+1, tokenWord, "BETWEEN") && is(i+2, tokenValue, "") &&
+			is(i+3, tokenWord, "AND") && is(i+4, tokenValue, ""):
+			rv[tok.text] = append(rv[tok.text], tokens[i+2].text, tokens[i+4].text)
+		case tok.kind == tokenName && is(i+1, tokenWord, "IN") && is(i+2, tokenSymbol, "("):
+			for j := i + 3; is(j, tokenValue, ""); j += 2 {
+				rv[tok.text] = append(rv[tok.text], tokens[j].text)
+				if !is(j+1, tokenSymbol, ",") {
+					break
+				}
+			}
+		}
+	}
+	return rv
+}
+
+// values adds KeyPrefix, in place, to the values that the expressions
+// compare with the hash key. It must be called before names.
+func (km *keyMapper) values(names map[string]*string, values map[string]*dynamodb.AttributeValue, exprs ...*string) {
+	if km.m.prefix() == "" || len(values) == 0 {
+		return
+	}
+	prefixed := map[string]bool{}
+	for _, expr := range exprs {
+		if expr == nil {
+			continue
+		}
+		for name, placeholders := range comparedValues(tokenizeExpression(*expr)) {
+			if aws.StringValue(names[name]) != "Key" {
+				continue
+			}
+			for _, p := range placeholders {
+				v := values[p]
+				if prefixed[p] || v == nil || v.S == nil {
+					continue
+				}
+				prefixed[p] = true
+				values[p] = &dynamodb.AttributeValue{S: aws.String(km.m.prefix() + *v.S)}
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package dynamotree
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestKeySchema(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db, KeySchema: &KeySchemaMapping{
+		HashAttribute:  "PK",
+		RangeAttribute: "SK",
+		KeyPrefix:      "tree#",
+		TypeAttribute:  "EntityType",
+		TypeValue:      "dynamotree",
+	}}
+	c.Assert(s.TableDefinition().HashKey, Equals, "PK")
+	c.Assert(s.TableDefinition().RangeKey, Equals, "SK")
+	c.Assert(s.CreateTable(), IsNil)
+
+	// Another application's row in the same table.
+	other := map[string]*dynamodb.AttributeValue{
+		"PK":   {S: aws.String("order#1")},
+		"SK":   {S: aws.String("¦")},
+		"Name": {S: aws.String("order")},
+	}
+	_, err := db.PutItem(&dynamodb.PutItemInput{TableName: aws.String(s.TableName), Item: other})
+	c.Assert(err, IsNil)
+
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &AccountT{ID: "12345", Name: "alice"}), IsNil)
+	c.Assert(s.PutLink([]string{"Users", "alice"}, []string{"Accounts", "12345"}), IsNil)
+
+	resp, err := db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"PK": {S: aws.String("tree#¦Accounts¦12345")},
+			"SK": {S: aws.String("¦")},
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(aws.StringValue(resp.Item["Name"].S), Equals, "alice")
+	c.Assert(aws.StringValue(resp.Item["EntityType"].S), Equals, "dynamotree")
+	c.Assert(resp.Item["Key"], IsNil)
+
+	v := AccountT{}
+	c.Assert(s.Get([]string{"Users", "alice"}, &v), IsNil)
+	c.Assert(v, DeepEquals, AccountT{ID: "12345", Name: "alice"})
+	v = AccountT{}
+	c.Assert(s.FastGet([]string{"Accounts", "12345"}, []string{"Name"}, &v), IsNil)
+	c.Assert(v.Name, Equals, "alice")
+	c.Assert(walkKeys(c, s, []string{}), DeepEquals, [][]string{
+		{"Accounts"},
+		{"Accounts", "12345"},
+		{"Users"},
+		{"Users", "alice"},
+	})
+
+	// Scans leave out the rows of the other application.
+	for segment := 0; segment < integritySegments; segment++ {
+		problems, err := s.checkSegment(context.Background(), segment, 100)
+		c.Assert(err, IsNil)
+		c.Assert(problems, HasLen, 0)
+	}
+
+	c.Assert(s.DeleteAll([]string{}), IsNil)
+	c.Assert(s.Get([]string{"Accounts", "12345"}, &v), ErrorIs, ErrNotFound)
+	resp, err = db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.TableName),
+		Key:       map[string]*dynamodb.AttributeValue{"PK": other["PK"], "SK": other["SK"]},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(resp.Item, DeepEquals, other)
+
+	c.Assert(s.TenantLeadingKeys("t1"), DeepEquals, []string{"tree#¦t1", "tree#¦t1¦*"})
+	c.Assert((&Mirror{Tree: s}).Start(), Equals, ErrKeySchemaStream)
+
+	_, err = NewTree(uniuri.New(), db, func(t *Tree) {
+		t.KeySchema = &KeySchemaMapping{HashAttribute: "PK", RangeAttribute: "PK"}
+	})
+	c.Assert(err, FitsTypeOf, &ConfigError{})
+	_, err = NewTree(uniuri.New(), db, func(t *Tree) {
+		t.KeySchema = &KeySchemaMapping{TypeAttribute: "EntityType"}
+	})
+	c.Assert(err, ErrorMatches, `.*TypeAttribute and TypeValue must be set together`)
+	_, err = NewTree(uniuri.New(), db, func(t *Tree) {
+		t.TTLAttribute = "SK"
+		t.KeySchema = &KeySchemaMapping{HashAttribute: "PK", RangeAttribute: "SK"}
+	})
+	c.Assert(err, ErrorMatches, `.*"SK" is an attribute that the tree writes in its rows`)
+}
+
+func (suite *StoreImplTest) TestKeySchemaReservedAttributes(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db, KeySchema: &KeySchemaMapping{
+		HashAttribute:  "PK",
+		RangeAttribute: "SK",
+		TypeAttribute:  "EntityType",
+		TypeValue:      "dynamotree",
+	}}
+	c.Assert(s.CreateTable(), IsNil)
+
+	// An object may not have an attribute that would be written over the
+	// row's key or type.
+	for _, name := range []string{"PK", "SK", "EntityType"} {
+		err := s.Put([]string{"Accounts", "12345"}, rawItem{
+			"Name": {S: aws.String("alice")},
+			name:   {S: aws.String("x")},
+		})
+		c.Assert(err, ErrorIs, ErrReservedAttribute)
+		c.Assert(err, ErrorMatches, `Put "Accounts/12345": attribute name "`+name+`" is reserved`)
+	}
+	c.Assert(s.Get([]string{"Accounts", "12345"}, &AccountT{}), ErrorIs, ErrNotFound)
+}
+
+func (suite *StoreImplTest) TestKeySchemaExpressions(c *C) {
+	km := &keyMapper{table: "t", m: &KeySchemaMapping{KeyPrefix: "tree#", TypeAttribute: "EntityType", TypeValue: "dynamotree"}}
+
+	// Keywords are found only where they are tokens of their own, not in
+	// the placeholders that contain them.
+	in := &dynamodb.UpdateItemInput{
+		UpdateExpression: aws.String("REMOVE #OFFSET, #RESET SET #N = :reset"),
+		ExpressionAttributeNames: map[string]*string{
+			"#OFFSET": aws.String("Offset"),
+			"#RESET":  aws.String("Reset"),
+			"#N":      aws.String("N"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":reset": {N: aws.String("0")}},
+	}
+	km.stampUpdate(in)
+	c.Assert(aws.StringValue(in.UpdateExpression), Equals,
+		"REMOVE #OFFSET, #RESET SET #dtType = :dtType, #N = :reset")
+	in.UpdateExpression = aws.String("REMOVE #OFFSET ADD #N :reset")
+	km.stampUpdate(in)
+	c.Assert(aws.StringValue(in.UpdateExpression), Equals,
+		"SET #dtType = :dtType REMOVE #OFFSET ADD #N :reset")
+
+	names := map[string]*string{"#K": aws.String("Key"), "#KEYS": aws.String("Keys")}
+	values := map[string]*dynamodb.AttributeValue{
+		":a": {S: aws.String("a")}, ":b": {S: aws.String("b")}, ":c": {S: aws.String("c")},
+		":d": {S: aws.String("d")}, ":e": {S: aws.String("e")}, ":f": {S: aws.String("f")},
+	}
+	km.values(names, values, aws.String("#K = :a AND :b<>#K AND begins_with ( #K, :c ) AND #KEYS = :f"),
+		aws.String("#K BETWEEN :d AND :e OR #K IN (:a, :b)"))
+	got := map[string]string{}
+	for name, v := range values {
+		got[name] = aws.StringValue(v.S)
+	}
+	c.Assert(got, DeepEquals, map[string]string{
+		":a": "tree#a", ":b": "tree#b", ":c": "tree#c", ":d": "tree#d", ":e": "tree#e", ":f": "f",
+	})
+}
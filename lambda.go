@@ -0,0 +1,68 @@
+package dynamotree
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// LambdaMaxRetries is the number of times that a tree with Tree.Lambda set
+// retries a failed request, unless the client's configuration gives
+// another number. The default of the DynamoDB client, 10, can spend the
+// whole of a short invocation retrying.
+const LambdaMaxRetries = 3
+
+// LambdaDeadlineMargin is the time that a tree with Tree.Lambda set leaves
+// between the end of each request and the deadline of its context, so that
+// the handler has time to report a request that did not finish before the
+// invocation ends.
+const LambdaDeadlineMargin = 200 * time.Millisecond
+
+// lambdaHTTPClient returns the HTTP client used by a tree with Tree.Lambda
+// set. An execution environment serves one invocation at a time, but is
+// reused for many, so the connections to DynamoDB are kept open for as
+// long as the environment is likely to be kept.
+func lambdaHTTPClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout: 2 * time.Second,
+			MaxIdleConns:        16,
+			MaxIdleConnsPerHost: 16,
+			IdleConnTimeout:     15 * time.Minute,
+			ForceAttemptHTTP2:   true,
+		},
+	}
+}
+
+// lambdaConfig adjusts config, the configuration of a tree's client, for
+// Tree.Lambda.
+func (t *Tree) lambdaConfig(config *aws.Config) {
+	if t.HTTPClient == nil && (config.HTTPClient == nil || config.HTTPClient == http.DefaultClient) {
+		config.HTTPClient = lambdaHTTPClient()
+	}
+	if config.MaxRetries == nil || *config.MaxRetries == aws.UseServiceDefaultRetries {
+		config.MaxRetries = aws.Int(LambdaMaxRetries)
+	}
+}
+
+// limitToDeadline is a request handler that brings forward the deadline of
+// the request's context by LambdaDeadlineMargin, so that the request,
+// including its retries, ends before the invocation does.
+func limitToDeadline(r *request.Request) {
+	deadline, ok := r.Context().Deadline()
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithDeadline(r.Context(), deadline.Add(-LambdaDeadlineMargin))
+	r.SetContext(ctx)
+	r.Handlers.Complete.PushBack(func(*request.Request) { cancel() })
+}
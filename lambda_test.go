@@ -0,0 +1,35 @@
+package dynamotree
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestLambda(c *C) {
+	config := testConfig.Copy()
+	config.MaxRetries = nil
+	db := dynamodb.New(session.New(), config)
+	s := &Tree{TableName: uniuri.New(), DB: db, Lambda: true}
+	c.Assert(s.CreateTable(), IsNil)
+	c.Assert(aws.IntValue(s.DB.Config.MaxRetries), Equals, LambdaMaxRetries)
+	c.Assert(s.DB.Config.HTTPClient == http.DefaultClient, Equals, false)
+
+	v := AccountT{ID: "12345", Name: "alice"}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &v, WithContext(ctx)), IsNil)
+
+	// A request is not made too close to the deadline of the invocation
+	ctx, cancel = context.WithTimeout(context.Background(), LambdaDeadlineMargin/2)
+	defer cancel()
+	start := time.Now()
+	c.Assert(s.Get([]string{"Accounts", "12345"}, &v, WithContext(ctx)), NotNil)
+	c.Assert(time.Since(start) < LambdaDeadlineMargin/2, Equals, true)
+}
@@ -0,0 +1,135 @@
+package dynamotree
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// linkCopyAttribute is the attribute of a link row that holds a copy of
+// the object the link points at. See Tree.LinkCopyMaxSize.
+func (t *Tree) linkCopyAttribute() string { return t.SpecialCharacter + "Copy" }
+
+// copiesLinkTargets returns true if links hold copies of their targets.
+func (t *Tree) copiesLinkTargets() bool { return t.LinkCopyMaxSize > 0 && t.MaintainBacklinks }
+
+// linkCopy returns the copy of the object whose row is target to store in
+// the rows of links to it, or nil if it is not to be copied: because it
+// does not exist, is itself a link, or is larger than LinkCopyMaxSize.
+func (t *Tree) linkCopy(target map[string]*dynamodb.AttributeValue) *dynamodb.AttributeValue {
+	if target == nil {
+		return nil
+	}
+	if _, isLink := t.linkTarget(target); isLink {
+		return nil
+	}
+	attributes := make(map[string]*dynamodb.AttributeValue, len(target))
+	for name, value := range target {
+		if name != "Key" && name != "Child" {
+			attributes[name] = value
+		}
+	}
+	if itemSize(attributes) > t.LinkCopyMaxSize {
+		return nil
+	}
+	return &dynamodb.AttributeValue{M: attributes}
+}
+
+// addLinkCopy adds to the row of the link to target, which is the last of
+// writeRequests, a copy of target's object.
+func (t *Tree) addLinkCopy(writeRequests []*dynamodb.WriteRequest, target []string, o *callOptions) error {
+	if !t.copiesLinkTargets() {
+		return nil
+	}
+	row, err := t.getRow(t.EncodeKey(target), o)
+	if err != nil {
+		return err
+	}
+	if linkCopy := t.linkCopy(row); linkCopy != nil {
+		writeRequests[len(writeRequests)-1].PutRequest.Item[t.linkCopyAttribute()] = linkCopy
+	}
+	return nil
+}
+
+// refreshLinkCopies replaces the copies held by the links to the object at
+// key, which now has the row target, or nil if it was deleted. A link that
+// is changed meanwhile so that it no longer points at key is left alone.
+func (t *Tree) refreshLinkCopies(key []string, target map[string]*dynamodb.AttributeValue, o *callOptions) error {
+	if !t.copiesLinkTargets() {
+		return nil
+	}
+	pathKey := t.EncodeKey(key)
+	references, err := t.references(pathKey, o)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.UpdateItemInput{
+		TableName:           aws.String(t.TableName),
+		ConditionExpression: aws.String("#L = :target"),
+		ExpressionAttributeNames: map[string]*string{
+			"#L": aws.String(t.LinkAttribute),
+			"#C": aws.String(t.linkCopyAttribute()),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":target": &dynamodb.AttributeValue{S: aws.String(pathKey)},
+		},
+		UpdateExpression: aws.String("REMOVE #C"),
+	}
+	if linkCopy := t.linkCopy(target); linkCopy != nil {
+		input.ExpressionAttributeValues[":copy"] = linkCopy
+		input.UpdateExpression = aws.String("SET #C = :copy")
+	}
+	for _, link := range references {
+		input.Key = map[string]*dynamodb.AttributeValue{
+			"Key":   &dynamodb.AttributeValue{S: aws.String(t.EncodeKey(link))},
+			"Child": &dynamodb.AttributeValue{S: aws.String(t.SpecialCharacter)},
+		}
+		_, err := t.DB.UpdateItemWithContext(o.context(), input, o.request()...)
+		if err == nil {
+			o.countLinksWritten(1)
+		} else if !isConditionalCheckFailed(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// itemSize returns the approximate size in bytes of an item with the given
+// attributes, as DynamoDB counts it.
+func itemSize(attributes map[string]*dynamodb.AttributeValue) int {
+	size := 0
+	for name, value := range attributes {
+		size += len(name) + attributeSize(value)
+	}
+	return size
+}
+
+func attributeSize(v *dynamodb.AttributeValue) int {
+	size := 0
+	switch {
+	case v.S != nil:
+		size = len(*v.S)
+	case v.N != nil:
+		size = len(*v.N)
+	case v.B != nil:
+		size = len(v.B)
+	case v.BOOL != nil, v.NULL != nil:
+		size = 1
+	case v.M != nil:
+		size = 3 + itemSize(v.M)
+	case v.L != nil:
+		size = 3
+		for _, item := range v.L {
+			size += 1 + attributeSize(item)
+		}
+	}
+	for _, s := range v.SS {
+		size += len(*s)
+	}
+	for _, n := range v.NS {
+		size += len(*n)
+	}
+	for _, b := range v.BS {
+		size += len(b)
+	}
+	return size
+}
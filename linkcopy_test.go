@@ -0,0 +1,72 @@
+package dynamotree
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestLinkCopies(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db, MaintainBacklinks: true, LinkCopyMaxSize: 200}
+	c.Assert(s.CreateTable(), IsNil)
+	reads := 0
+	countReads := WithRequestOptions(func(r *request.Request) {
+		if r.Operation.Name == "GetItem" {
+			reads++
+		}
+	})
+
+	bob := AccountT{ID: "6789", Name: "bob"}
+	c.Assert(s.Put([]string{"Accounts", "bob"}, &bob), IsNil)
+	c.Assert(s.PutLink([]string{"Current"}, []string{"Accounts", "bob"}), IsNil)
+	var v AccountT
+	c.Assert(s.Get([]string{"Current"}, &v, countReads), IsNil)
+	c.Assert(v, DeepEquals, bob)
+	c.Assert(reads, Equals, 1)
+
+	// Writing the object updates the copy
+	bob.Email = "bob@example.com"
+	c.Assert(s.Put([]string{"Accounts", "bob"}, &bob), IsNil)
+	reads = 0
+	c.Assert(s.Get([]string{"Current"}, &v, countReads), IsNil)
+	c.Assert(v, DeepEquals, bob)
+	c.Assert(reads, Equals, 1)
+
+	// Consistent reads always read the object
+	reads = 0
+	c.Assert(s.Get([]string{"Current"}, &v, countReads, ConsistentRead()), IsNil)
+	c.Assert(v, DeepEquals, bob)
+	c.Assert(reads, Equals, 2)
+
+	// Objects that are too large are not copied
+	bob.Name = strings.Repeat("x", 200)
+	c.Assert(s.Put([]string{"Accounts", "bob"}, &bob), IsNil)
+	reads = 0
+	c.Assert(s.Get([]string{"Current"}, &v, countReads), IsNil)
+	c.Assert(v, DeepEquals, bob)
+	c.Assert(reads, Equals, 2)
+
+	// Deleting the object removes the copy
+	bob.Name = "bob"
+	c.Assert(s.Put([]string{"Accounts", "bob"}, &bob), IsNil)
+	c.Assert(s.Delete([]string{"Accounts", "bob"}), IsNil)
+	c.Assert(s.Get([]string{"Current"}, &v), ErrorIs, ErrNotFound)
+
+	// A link that no longer points at the object keeps its own copy
+	carol := AccountT{ID: "1111", Name: "carol"}
+	c.Assert(s.Put([]string{"Accounts", "bob"}, &bob), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "carol"}, &carol), IsNil)
+	c.Assert(s.PutLink([]string{"Current"}, []string{"Accounts", "bob"}), IsNil)
+	c.Assert(s.PutLink([]string{"Current"}, []string{"Accounts", "carol"}), IsNil)
+	bob.Email = "robert@example.com"
+	c.Assert(s.Put([]string{"Accounts", "bob"}, &bob), IsNil)
+	reads = 0
+	c.Assert(s.Get([]string{"Current"}, &v, countReads), IsNil)
+	c.Assert(v, DeepEquals, carol)
+	c.Assert(reads, Equals, 1)
+}
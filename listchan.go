@@ -0,0 +1,58 @@
+package dynamotree
+
+import (
+	"context"
+)
+
+// ChildEntry is a child of a directory, as sent by ListChan.
+type ChildEntry struct {
+	// Name is the name of the child, as passed to the function given to
+	// List.
+	Name string
+
+	// Key is the full key of the child: the prefix given to ListChan
+	// followed by Name.
+	Key []string
+}
+
+// ListChan enumerates the immediate children of prefix in the same way as
+// List, sending each to the first channel returned. Once the listing is
+// complete, the first channel is closed, the error that stopped it, if
+// any, is sent on the second, and then the second is closed too.
+//
+// If ctx is cancelled, the listing stops and ctx.Err() is sent on the
+// error channel. The caller must either receive every entry or cancel
+// ctx, otherwise the goroutine doing the listing leaks.
+func (t *Tree) ListChan(ctx context.Context, prefix []string, opts ...Option) (<-chan ChildEntry, <-chan error) {
+	entries := make(chan ChildEntry)
+	errs := make(chan error, 1)
+	opts = append(opts[:len(opts):len(opts)], WithContext(ctx))
+
+	go func() {
+		defer close(errs)
+		var err error
+		t.List(prefix, func(name string, innerErr error) bool {
+			if innerErr != nil {
+				err = innerErr
+				return false
+			}
+			key := make([]string, len(prefix), len(prefix)+1)
+			copy(key, prefix)
+			select {
+			case entries <- ChildEntry{Name: name, Key: append(key, name)}:
+				return true
+			case <-ctx.Done():
+				err = ctx.Err()
+				return false
+			}
+		}, opts...)
+		close(entries)
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			errs <- err
+		}
+	}()
+	return entries, errs
+}
@@ -0,0 +1,44 @@
+package dynamotree
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestListChan(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+
+	v := AccountT{ID: "12345", Name: "alice"}
+	for _, id := range []string{"1", "2", "3"} {
+		c.Assert(s.Put([]string{"Accounts", id}, &v), IsNil)
+	}
+
+	entries, errs := s.ListChan(context.Background(), []string{"Accounts"})
+	received := []ChildEntry{}
+	for entry := range entries {
+		received = append(received, entry)
+	}
+	c.Assert(<-errs, IsNil)
+	c.Assert(received, DeepEquals, []ChildEntry{
+		{Name: "1", Key: []string{"Accounts", "1"}},
+		{Name: "2", Key: []string{"Accounts", "2"}},
+		{Name: "3", Key: []string{"Accounts", "3"}},
+	})
+
+	// cancelling the context stops the listing
+	ctx, cancel := context.WithCancel(context.Background())
+	entries, errs = s.ListChan(ctx, []string{"Accounts"})
+	entry := <-entries
+	c.Assert(entry.Name, Equals, "1")
+	cancel()
+	for range entries {
+	}
+	c.Assert(<-errs, Equals, context.Canceled)
+}
@@ -0,0 +1,311 @@
+package dynamotree
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// DefaultMaxKeys is the number of keys returned by ListObjects when the
+// input does not specify MaxKeys, as it is for S3.
+const DefaultMaxKeys = 1000
+
+// objectKeyDelimiter joins the parts of a key into the object key by which
+// ListObjects names it.
+const objectKeyDelimiter = "/"
+
+// errUnsupportedDelimiter is returned by ListObjects for a Delimiter other
+// than "/".
+var errUnsupportedDelimiter = errors.New("dynamotree: ListObjects supports only the delimiter \"/\"")
+
+// ListObjectsInput describes a listing made by ListObjects. Its fields
+// have the meaning of the fields of the same names of S3's
+// ListObjectsV2Input, for the object keys formed by joining the parts of
+// each key of the tree with "/".
+type ListObjectsInput struct {
+	// Prefix limits the listing to the object keys that begin with it. It
+	// need not end at a "/": "Accounts/12" lists both "Accounts/123" and
+	// "Accounts/124/Orders".
+	Prefix string
+
+	// Delimiter, if "/", groups the keys that have further parts after
+	// Prefix into CommonPrefixes, so that only a single level of the tree
+	// is listed. If empty, every key below Prefix is listed. No other
+	// delimiter is supported.
+	Delimiter string
+
+	// MaxKeys is the greatest number of Contents and CommonPrefixes
+	// returned together. If zero, DefaultMaxKeys is used.
+	MaxKeys int
+
+	// ContinuationToken resumes a listing from the NextContinuationToken
+	// of an earlier call with the same Prefix and Delimiter.
+	ContinuationToken string
+
+	// StartAfter, if ContinuationToken is empty, starts the listing after
+	// the object key it holds.
+	StartAfter string
+}
+
+// ListObjectsOutput is a page of a listing made by ListObjects.
+type ListObjectsOutput struct {
+	// Contents holds the keys listed that hold an object or a link.
+	Contents []*ObjectSummary
+
+	// CommonPrefixes holds, when Delimiter is "/", the keys listed that
+	// have keys below them, each followed by "/".
+	CommonPrefixes []string
+
+	// KeyCount is the number of Contents and CommonPrefixes.
+	KeyCount int
+
+	// IsTruncated is true if the listing continues from
+	// NextContinuationToken.
+	IsTruncated bool
+
+	// NextContinuationToken, if IsTruncated is true, resumes the listing.
+	NextContinuationToken string
+}
+
+// ObjectSummary is a key listed by ListObjects.
+type ObjectSummary struct {
+	// Key is the object key: the parts of the key joined with "/".
+	Key string
+
+	// Kind is NodeItem or NodeLink. Links are not followed.
+	Kind NodeKind
+}
+
+// ListObjects lists the keys of the tree as S3's ListObjectsV2 lists the
+// keys of a bucket, for tools and code that are built around S3 listings.
+// Each key of the tree is named by its parts joined with "/", and the keys
+// at which nothing is stored appear only within CommonPrefixes.
+//
+// Keys are listed in the order in which Walk visits them, rather than in
+// the order of their object keys: each key comes before the keys below it
+// and after the keys below the siblings that precede it, and, with
+// Delimiter "/", each key that holds an object and has keys below it is
+// listed in Contents directly before it is listed in CommonPrefixes. The
+// listing is the same as S3's for keys whose parts do not contain
+// characters that sort before "/", such as "-" and ".", or "/" itself.
+//
+// With Delimiter "/", one query is made for each key listed to tell
+// whether there are keys below it.
+func (t *Tree) ListObjects(input *ListObjectsInput, opts ...Option) (output *ListObjectsOutput, err error) {
+	defer annotateError(&err, "ListObjects", nil)
+	o, cancel := t.startCall("ListObjects", nil, &err, opts)
+	defer cancel()
+	if err := refuseCapability("ListObjects", nil, o); err != nil {
+		return nil, err
+	}
+	if err := t.ready(); err != nil {
+		return nil, err
+	}
+	if input.Delimiter != "" && input.Delimiter != objectKeyDelimiter {
+		return nil, errUnsupportedDelimiter
+	}
+	after := input.StartAfter
+	if input.ContinuationToken != "" {
+		if after, err = decodeCursor(input.ContinuationToken); err != nil {
+			return nil, err
+		}
+	}
+
+	// The listing is of the children of dir whose names begin with
+	// namePrefix.
+	parts := strings.Split(input.Prefix, objectKeyDelimiter)
+	dir, namePrefix := parts[:len(parts)-1], parts[len(parts)-1]
+	dirString := strings.Join(dir, objectKeyDelimiter)
+	if len(dir) > 0 {
+		dirString += objectKeyDelimiter
+	}
+
+	l := &objectLister{
+		tree:    t,
+		o:       o,
+		maxKeys: input.MaxKeys,
+		output:  &ListObjectsOutput{},
+	}
+	if l.maxKeys <= 0 {
+		l.maxKeys = DefaultMaxKeys
+	}
+
+	// afterParts are the parts, below dir, of the object key after which
+	// the listing starts. A common prefix ends with an empty part.
+	var afterParts []string
+	if after != "" {
+		if !strings.HasPrefix(after, dirString) {
+			if after > input.Prefix {
+				return l.output, nil
+			}
+		} else {
+			afterParts = strings.Split(after[len(dirString):], objectKeyDelimiter)
+			if !strings.HasPrefix(afterParts[0], namePrefix) {
+				if afterParts[0] > namePrefix {
+					return l.output, nil
+				}
+				afterParts = nil
+			}
+		}
+	}
+
+	dir, err = t.transformKey(dir)
+	if err != nil {
+		return nil, err
+	}
+	if input.Delimiter == "" {
+		_, err = l.walk(dir, dirString, namePrefix, afterParts)
+	} else {
+		err = l.listLevel(dir, dirString, namePrefix, afterParts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return l.output, nil
+}
+
+// objectLister accumulates a page of a listing made by ListObjects.
+type objectLister struct {
+	tree    *Tree
+	o       *callOptions
+	maxKeys int
+	output  *ListObjectsOutput
+
+	// last is the object key most recently added to output.
+	last string
+}
+
+// add adds the object key s to the Contents of the output, if kind is not
+// NodeDirectory, or otherwise to its CommonPrefixes. It returns false if
+// the output is full, in which case the listing is truncated before s.
+func (l *objectLister) add(s string, kind NodeKind) bool {
+	out := l.output
+	if out.KeyCount >= l.maxKeys {
+		out.IsTruncated = true
+		out.NextContinuationToken = encodeCursor(l.last)
+		return false
+	}
+	if kind == NodeDirectory {
+		out.CommonPrefixes = append(out.CommonPrefixes, s)
+	} else {
+		out.Contents = append(out.Contents, &ObjectSummary{Key: s, Kind: kind})
+	}
+	out.KeyCount++
+	l.last = s
+	return true
+}
+
+// listLevel lists the children of dir whose names begin with namePrefix,
+// and that come after the key below dir named by afterParts, each as an
+// object or a common prefix, or both. dirString is the object key of dir,
+// followed by "/" unless dir is the root.
+func (l *objectLister) listLevel(dir []string, dirString, namePrefix string, afterParts []string) error {
+	t := l.tree
+	return l.children(dir, namePrefix, afterParts, func(name string, info *NodeInfo) (bool, error) {
+		s := dirString + name
+
+		// afterParts may name the object at the child, whose common
+		// prefix follows it, or its common prefix or a key below it.
+		first := len(afterParts) > 0 && name == afterParts[0]
+		if first && len(afterParts) > 1 {
+			return true, nil
+		}
+		if !first && info.Kind != NodeDirectory && !l.add(s, info.Kind) {
+			return false, nil
+		}
+		hasChildren, err := t.hasChildren(t.dirKey(info.Key), l.o)
+		if err != nil {
+			return false, err
+		}
+		if hasChildren && !l.add(s+objectKeyDelimiter, NodeDirectory) {
+			return false, nil
+		}
+		return true, nil
+	})
+}
+
+// walk lists the objects at and below the children of dir whose names
+// begin with namePrefix, and that come after the key below dir named by
+// afterParts, in the order of Walk. It returns false if the output is
+// full.
+func (l *objectLister) walk(dir []string, dirString, namePrefix string, afterParts []string) (bool, error) {
+	complete := true
+	err := l.children(dir, namePrefix, afterParts, func(name string, info *NodeInfo) (bool, error) {
+		s := dirString + name
+		var below []string
+		if len(afterParts) > 0 && name == afterParts[0] {
+			// The object at the child, if any, precedes the listing.
+			below = afterParts[1:]
+		} else if info.Kind != NodeDirectory && !l.add(s, info.Kind) {
+			complete = false
+			return false, nil
+		}
+		more, err := l.walk(info.Key, s+objectKeyDelimiter, "", below)
+		if err != nil || !more {
+			complete = false
+			return false, err
+		}
+		return true, nil
+	})
+	return complete, err
+}
+
+// children calls childFunc, in the order in which they are stored, with
+// the name and NodeInfo of each child of dir whose name begins with
+// namePrefix, from the child named by afterParts[0], if there is one,
+// until childFunc returns false or an error.
+func (l *objectLister) children(dir []string, namePrefix string, afterParts []string,
+	childFunc func(name string, info *NodeInfo) (bool, error)) error {
+	t := l.tree
+	start := ""
+	if len(afterParts) > 0 && afterParts[0] != "" {
+		start = t.encodePart(afterParts[0])
+	}
+	if t.KeyEncoding == KeyEncodingNone && start < namePrefix {
+		start = namePrefix
+	}
+
+	var childErr error
+	err := t.DB.QueryPagesWithContext(l.o.context(), t.childrenFrom(dir, start, l.o), func(p *dynamodb.QueryOutput, lastPage bool) bool {
+		names, keys := []string{}, [][]string{}
+		stopped := false
+		for _, row := range p.Items {
+			stored := aws.StringValue(row["Child"].S)
+			if strings.HasPrefix(stored, t.SpecialCharacter) {
+				continue
+			}
+			name := t.decodePart(stored)
+			if !strings.HasPrefix(name, namePrefix) {
+				if t.KeyEncoding == KeyEncodingNone {
+					// The children that follow do not begin with
+					// namePrefix either.
+					stopped = true
+					break
+				}
+				continue
+			}
+			key := make([]string, len(dir), len(dir)+1)
+			copy(key, dir)
+			names, keys = append(names, name), append(keys, append(key, name))
+		}
+		infos, err := t.statKeys(keys, l.o)
+		if err != nil {
+			childErr = err
+			return false
+		}
+		for i, name := range names {
+			more, err := childFunc(name, infos[i])
+			if err != nil || !more {
+				childErr = err
+				return false
+			}
+		}
+		return !stopped
+	}, l.o.request()...)
+	if err != nil {
+		return err
+	}
+	return childErr
+}
@@ -0,0 +1,98 @@
+package dynamotree
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestListObjects(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	for _, keyEncoding := range []KeyEncoding{KeyEncodingNone, KeyEncodingHex} {
+		s := &Tree{TableName: uniuri.New(), DB: db, KeyEncoding: keyEncoding}
+		c.Assert(s.CreateTable(), IsNil)
+		for _, key := range [][]string{
+			{"Accounts", "123"},
+			{"Accounts", "124", "Orders", "1"},
+			{"Accounts", "124"},
+			{"Accounts", "200"},
+			{"Zones", "a"},
+		} {
+			c.Assert(s.Put(key, &AccountT{ID: key[len(key)-1]}), IsNil)
+		}
+		c.Assert(s.PutLink([]string{"Accounts", "125"}, []string{"Accounts", "123"}), IsNil)
+
+		// list lists every page, returning the object keys, with common
+		// prefixes ending in "/", and the number of pages.
+		list := func(input ListObjectsInput) ([]string, int) {
+			keys, pages := []string{}, 0
+			for {
+				out, err := s.ListObjects(&input, ConsistentRead())
+				c.Assert(err, IsNil)
+				pages++
+				c.Assert(out.KeyCount, Equals, len(out.Contents)+len(out.CommonPrefixes))
+				for _, object := range out.Contents {
+					keys = append(keys, object.Key)
+				}
+				keys = append(keys, out.CommonPrefixes...)
+				if !out.IsTruncated {
+					c.Assert(out.NextContinuationToken, Equals, "")
+					return keys, pages
+				}
+				c.Assert(out.KeyCount, Equals, input.MaxKeys)
+				input.ContinuationToken = out.NextContinuationToken
+			}
+		}
+
+		keys, pages := list(ListObjectsInput{})
+		c.Assert(keys, DeepEquals, []string{"Accounts/123", "Accounts/124", "Accounts/124/Orders/1",
+			"Accounts/125", "Accounts/200", "Zones/a"}, Commentf("%v", keyEncoding))
+		c.Assert(pages, Equals, 1)
+
+		// Every page size gives the same listing.
+		for maxKeys := 1; maxKeys <= 6; maxKeys++ {
+			paged, _ := list(ListObjectsInput{MaxKeys: maxKeys})
+			c.Assert(paged, DeepEquals, keys, Commentf("%d", maxKeys))
+		}
+
+		keys, _ = list(ListObjectsInput{Prefix: "Accounts/12"})
+		c.Assert(keys, DeepEquals, []string{"Accounts/123", "Accounts/124", "Accounts/124/Orders/1", "Accounts/125"})
+		keys, _ = list(ListObjectsInput{Prefix: "Accounts/124/"})
+		c.Assert(keys, DeepEquals, []string{"Accounts/124/Orders/1"})
+		keys, _ = list(ListObjectsInput{Prefix: "Accounts", StartAfter: "Accounts/124"})
+		c.Assert(keys, DeepEquals, []string{"Accounts/124/Orders/1", "Accounts/125", "Accounts/200"})
+		keys, _ = list(ListObjectsInput{Prefix: "Accounts/", StartAfter: "Zones"})
+		c.Assert(keys, DeepEquals, []string{})
+
+		// With a delimiter, a single level is listed.
+		keys, _ = list(ListObjectsInput{Delimiter: "/"})
+		c.Assert(keys, DeepEquals, []string{"Accounts/", "Zones/"})
+		out, err := s.ListObjects(&ListObjectsInput{Prefix: "Accounts/", Delimiter: "/"}, ConsistentRead())
+		c.Assert(err, IsNil)
+		c.Assert(out.Contents, DeepEquals, []*ObjectSummary{
+			{Key: "Accounts/123", Kind: NodeItem},
+			{Key: "Accounts/124", Kind: NodeItem},
+			{Key: "Accounts/125", Kind: NodeLink},
+			{Key: "Accounts/200", Kind: NodeItem},
+		})
+		c.Assert(out.CommonPrefixes, DeepEquals, []string{"Accounts/124/"})
+		for maxKeys := 1; maxKeys <= 5; maxKeys++ {
+			paged, pages := list(ListObjectsInput{Prefix: "Accounts/", Delimiter: "/", MaxKeys: maxKeys})
+			c.Assert(paged, HasLen, 5, Commentf("%d", maxKeys))
+			c.Assert(pages, Equals, (5+maxKeys-1)/maxKeys)
+		}
+		keys, _ = list(ListObjectsInput{Prefix: "Accounts/", Delimiter: "/", StartAfter: "Accounts/124/"})
+		c.Assert(keys, DeepEquals, []string{"Accounts/125", "Accounts/200"})
+
+		// A directory whose keys have all been deleted is not listed.
+		c.Assert(s.Delete([]string{"Zones", "a"}), IsNil)
+		keys, _ = list(ListObjectsInput{Delimiter: "/"})
+		c.Assert(keys, DeepEquals, []string{"Accounts/"})
+
+		_, err = s.ListObjects(&ListObjectsInput{Delimiter: "-"})
+		c.Assert(err, ErrorMatches, `dynamotree: ListObjects supports only the delimiter "/"`)
+		_, err = s.ListObjects(&ListObjectsInput{ContinuationToken: "!"})
+		c.Assert(err, ErrorMatches, `invalid cursor "!"`)
+	}
+}
@@ -0,0 +1,46 @@
+package dynamotree
+
+// ListVerified causes List, and Walk and the other methods that list
+// directories, to read the row of each child listed and to leave out the
+// children at which nothing is stored and below which there are no keys.
+// These are the entries left behind when an object is removed other than
+// by the tree, such as by DynamoDB's time to live or by a writer that
+// did not remove its directory entry. The rows are read using BatchGetItem,
+// projected to their keys, for each page of children, and a directory is
+// queried for each child whose row is missing, so a listing given
+// ListVerified costs roughly one more request per page, and one per
+// child that is only a directory or a ghost.
+func ListVerified() Option {
+	return func(o *callOptions) {
+		o.listVerified = true
+	}
+}
+
+// verifyChildren returns those of names, the stored names of children of
+// keyPrefix, at which something is stored or below which there are keys.
+func (t *Tree) verifyChildren(keyPrefix []string, names []string, o *callOptions) ([]string, error) {
+	keys := make([][]string, len(names))
+	for i, name := range names {
+		key := make([]string, len(keyPrefix), len(keyPrefix)+1)
+		copy(key, keyPrefix)
+		keys[i] = append(key, t.decodePart(name))
+	}
+	infos, err := t.statKeys(keys, o)
+	if err != nil {
+		return nil, err
+	}
+	rv := make([]string, 0, len(names))
+	for i, info := range infos {
+		if info.Kind == NodeDirectory {
+			hasChildren, err := t.hasChildren(t.dirKey(keys[i]), o)
+			if err != nil {
+				return nil, err
+			}
+			if !hasChildren {
+				continue
+			}
+		}
+		rv = append(rv, names[i])
+	}
+	return rv, nil
+}
@@ -0,0 +1,52 @@
+package dynamotree
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestListVerified(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+	for _, key := range [][]string{{"Accounts", "a"}, {"Accounts", "b"}, {"Accounts", "c", "d"}, {"Accounts", "e"}} {
+		c.Assert(s.Put(key, &AccountT{Name: key[len(key)-1]}), IsNil)
+	}
+
+	// Another writer removes the row of b, leaving its directory entry.
+	_, err := db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(s.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Key":   {S: aws.String(s.EncodeKey([]string{"Accounts", "b"}))},
+			"Child": {S: aws.String(s.SpecialCharacter)},
+		},
+	})
+	c.Assert(err, IsNil)
+
+	list := func(opts ...Option) []string {
+		names := []string{}
+		s.List([]string{"Accounts"}, func(name string, err error) bool {
+			c.Assert(err, IsNil)
+			names = append(names, name)
+			return true
+		}, opts...)
+		return names
+	}
+	c.Assert(list(), DeepEquals, []string{"a", "b", "c", "e"})
+	c.Assert(list(ListVerified()), DeepEquals, []string{"a", "c", "e"})
+
+	var cursor string
+	c.Assert(list(ListVerified(), MaxItems(1), ReturnCursor(&cursor)), DeepEquals, []string{"a"})
+	c.Assert(list(ListVerified(), MaxItems(1), StartAfter(cursor)), DeepEquals, []string{"c"})
+
+	keys := [][]string{}
+	s.Walk([]string{"Accounts"}, func(key []string, err error) bool {
+		c.Assert(err, IsNil)
+		keys = append(keys, key)
+		return true
+	}, ListVerified())
+	c.Assert(keys, DeepEquals, [][]string{{"Accounts", "a"}, {"Accounts", "c"}, {"Accounts", "c", "d"}, {"Accounts", "e"}})
+}
@@ -0,0 +1,219 @@
+package dynamotree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// LoadFormat is the format of the input to a Loader.
+type LoadFormat int
+
+// The formats supported by Loader.
+const (
+	// LoadCSV is CSV with a header row. Each column is stored as a string
+	// attribute named by the header.
+	LoadCSV LoadFormat = iota
+
+	// LoadJSONL is one JSON object per line. Each property of the object is
+	// stored as an attribute.
+	LoadJSONL
+)
+
+// LoadProgress describes the progress of a Loader.
+type LoadProgress struct {
+	// Records is the number of records read from the input.
+	Records int
+
+	// RowsWritten is the number of rows written to the table.
+	RowsWritten int
+
+	// DirectoryRowsSkipped is the number of directory rows that were not
+	// written because an earlier record had already written them.
+	DirectoryRowsSkipped int
+}
+
+// Loader bulk loads records from a CSV or JSONL file into a tree.
+//
+// Each record is stored at a key formed by executing KeyTemplate, a
+// text/template, with the record and splitting the result on "/". For
+// example, "Accounts/{{.ID}}" stores each record under the Accounts prefix
+// according to its ID property.
+//
+// Directory rows shared by many records are written only once per load,
+// so loading many objects under the same prefix costs little more than
+// writing the objects themselves.
+type Loader struct {
+	// Tree is the tree to load records into.
+	Tree *Tree
+
+	// Format is the format of the input.
+	Format LoadFormat
+
+	// KeyTemplate is the template used to produce each record's key.
+	KeyTemplate string
+
+	// RowsPerSecond, if non-zero, limits the rate at which rows are
+	// written to the table.
+	RowsPerSecond float64
+
+	// Progress, if not nil, is called each time a batch of rows is written.
+	Progress func(LoadProgress)
+}
+
+// Load reads records from r and stores them in the tree. It returns the
+// progress made, which describes what was loaded if Load returns an error.
+func (l *Loader) Load(r io.Reader) (LoadProgress, error) {
+	t := l.Tree
+	if err := t.ready(); err != nil {
+		return LoadProgress{}, err
+	}
+
+	progress := LoadProgress{}
+	tmpl, err := template.New("key").Option("missingkey=error").Parse(l.KeyTemplate)
+	if err != nil {
+		return progress, err
+	}
+
+	var next func() (map[string]interface{}, error)
+	switch l.Format {
+	case LoadCSV:
+		next, err = csvRecords(r)
+		if err != nil {
+			return progress, err
+		}
+	case LoadJSONL:
+		next = jsonlRecords(r)
+	default:
+		return progress, fmt.Errorf("unknown load format %d", l.Format)
+	}
+
+	start := t.now()
+	writtenDirectoryRows := map[string]bool{}
+	pending := []*dynamodb.WriteRequest{}
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		if err := t.batchWrite(pending, nil); err != nil {
+			return err
+		}
+		progress.RowsWritten += len(pending)
+		pending = pending[:0]
+		if l.Progress != nil {
+			l.Progress(progress)
+		}
+
+		if l.RowsPerSecond > 0 {
+			expected := time.Duration(float64(progress.RowsWritten) / l.RowsPerSecond * float64(time.Second))
+			if elapsed := t.since(start); elapsed < expected {
+				<-t.clock().After(expected - elapsed)
+			}
+		}
+		return nil
+	}
+
+	for {
+		record, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return progress, fmt.Errorf("record %d: %s", progress.Records+1, err)
+		}
+		progress.Records++
+
+		buf := bytes.NewBuffer(nil)
+		if err := tmpl.Execute(buf, record); err != nil {
+			return progress, fmt.Errorf("record %d: %s", progress.Records, err)
+		}
+		key, err := t.transformKey(strings.Split(buf.String(), "/"))
+		if err != nil {
+			return progress, fmt.Errorf("record %d: %s", progress.Records, err)
+		}
+
+		item := make(rawItem, len(record))
+		for name, value := range record {
+			item[name] = jsonToAttribute(value)
+		}
+		writeRequests, err := t.putRequests(key, item)
+		if err != nil {
+			return progress, fmt.Errorf("record %d: %s", progress.Records, err)
+		}
+		writeRequests = t.flatten(key, writeRequests, nil)
+
+		for _, writeRequest := range writeRequests[:len(writeRequests)-1] {
+			row := writeRequest.PutRequest.Item
+			id := *row["Key"].S + t.SpecialCharacter + *row["Child"].S
+			if writtenDirectoryRows[id] {
+				progress.DirectoryRowsSkipped++
+				continue
+			}
+			writtenDirectoryRows[id] = true
+			pending = append(pending, writeRequest)
+		}
+		pending = append(pending, writeRequests[len(writeRequests)-1])
+
+		if len(pending) >= 25 {
+			if err := flush(); err != nil {
+				return progress, err
+			}
+		}
+	}
+	return progress, flush()
+}
+
+// csvRecords returns a function that returns each record of a CSV file
+// whose first row names the columns.
+func csvRecords(r io.Reader) (func() (map[string]interface{}, error), error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	return func() (map[string]interface{}, error) {
+		row, err := cr.Read()
+		if err != nil {
+			return nil, err
+		}
+		record := make(map[string]interface{}, len(header))
+		for i, name := range header {
+			record[name] = row[i]
+		}
+		return record, nil
+	}, nil
+}
+
+// jsonlRecords returns a function that returns each object in a file of
+// one JSON object per line. Blank lines are ignored.
+func jsonlRecords(r io.Reader) func() (map[string]interface{}, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 400*1024) // longer records could not be stored anyway
+	return func() (map[string]interface{}, error) {
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			decoder := json.NewDecoder(bytes.NewReader(line))
+			decoder.UseNumber()
+			var record map[string]interface{}
+			if err := decoder.Decode(&record); err != nil {
+				return nil, err
+			}
+			return record, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+}
@@ -0,0 +1,85 @@
+package dynamotree
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestLoadCSV(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	err := s.CreateTable()
+	c.Assert(err, IsNil)
+
+	input := "ID,Name,Email\n" +
+		"12345,alice,alice@example.com\n" +
+		"6789,bob,bob@example.com\n"
+	progressCalls := 0
+	l := &Loader{
+		Tree:        s,
+		Format:      LoadCSV,
+		KeyTemplate: "Accounts/{{.ID}}",
+		Progress: func(LoadProgress) {
+			progressCalls++
+		},
+	}
+	progress, err := l.Load(strings.NewReader(input))
+	c.Assert(err, IsNil)
+	c.Assert(progress, DeepEquals, LoadProgress{
+		Records:              2,
+		RowsWritten:          5,
+		DirectoryRowsSkipped: 1,
+	})
+	c.Assert(progressCalls, Equals, 1)
+
+	var v AccountT
+	c.Assert(s.Get([]string{"Accounts", "6789"}, &v), IsNil)
+	c.Assert(v, DeepEquals, AccountT{ID: "6789", Name: "bob", Email: "bob@example.com"})
+
+	items := []string{}
+	s.List([]string{"Accounts"}, func(item string, err error) bool {
+		c.Assert(err, IsNil)
+		items = append(items, item)
+		return true
+	})
+	c.Assert(items, DeepEquals, []string{"12345", "6789"})
+}
+
+func (suite *StoreImplTest) TestLoadJSONL(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	err := s.CreateTable()
+	c.Assert(err, IsNil)
+
+	input := `{"ID": "1", "Name": "alice", "MarshalFailPlease": false}` + "\n" +
+		"\n" +
+		`{"ID": "2", "Name": "bob", "Group": "admins"}` + "\n"
+	l := &Loader{
+		Tree:          s,
+		Format:        LoadJSONL,
+		KeyTemplate:   "Accounts/{{.ID}}",
+		RowsPerSecond: 1000,
+	}
+	progress, err := l.Load(strings.NewReader(input))
+	c.Assert(err, IsNil)
+	c.Assert(progress.Records, Equals, 2)
+
+	var v AccountT
+	c.Assert(s.Get([]string{"Accounts", "1"}, &v), IsNil)
+	c.Assert(v, DeepEquals, AccountT{ID: "1", Name: "alice"})
+
+	// records that can't be keyed are reported
+	l.KeyTemplate = "Accounts/{{.Group}}"
+	progress, err = l.Load(strings.NewReader(input))
+	c.Assert(err, ErrorMatches, `record 1: .*map has no entry for key "Group".*`)
+	c.Assert(progress.Records, Equals, 1)
+
+	_, err = l.Load(strings.NewReader("{not json}\n"))
+	c.Assert(err, ErrorMatches, "record 1: .*")
+}
@@ -0,0 +1,390 @@
+package dynamotree
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// DefaultMaintenanceLease is the duration of the lease that makes a
+// Maintenance the leader, if LeaseDuration is not specified.
+const DefaultMaintenanceLease = time.Minute
+
+// maintenanceChild is the value of the Child attribute of the row that
+// records which Maintenance is the leader. It is stored alongside the
+// metadata row, so it is not part of the tree.
+const maintenanceChild = "maintenance"
+
+// MaintenanceJob is a task run periodically by a Maintenance. Run returns
+// the number of items it processed or repaired, which is recorded in the
+// job's MaintenanceMetrics.
+type MaintenanceJob struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context, t *Tree) (int, error)
+}
+
+// MaintenanceMetrics describes the runs of a MaintenanceJob by one
+// Maintenance.
+type MaintenanceMetrics struct {
+	Runs     int
+	Failures int
+
+	// Items is the total number of items processed by the runs.
+	Items int
+
+	LastStart    time.Time
+	LastDuration time.Duration
+	LastErr      error
+}
+
+// Maintenance runs Jobs periodically in every process that uses a table,
+// such that only one process at a time, the leader, runs them. Leadership
+// is held by a lease recorded in the table, which the leader renews while
+// it runs and releases when it stops, so that if the leader fails another
+// process takes over within LeaseDuration.
+type Maintenance struct {
+	Tree *Tree
+	Jobs []MaintenanceJob
+
+	// LeaseDuration is the duration of the lease that makes the instance
+	// the leader. If not specified, DefaultMaintenanceLease is used. It
+	// must not be negative.
+	LeaseDuration time.Duration
+
+	// OnJob, if not nil, is called after each run of a job, for example to
+	// report the metrics to a monitoring system.
+	OnJob func(job string, items int, duration time.Duration, err error)
+
+	mu       sync.Mutex
+	owner    string
+	leader   bool
+	metrics  map[string]MaintenanceMetrics
+	schedule map[string]time.Time
+}
+
+// Metrics returns the metrics of each job, by name, for the runs made by
+// this instance.
+func (m *Maintenance) Metrics() map[string]MaintenanceMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rv := make(map[string]MaintenanceMetrics, len(m.metrics))
+	for name, metrics := range m.metrics {
+		rv[name] = metrics
+	}
+	return rv
+}
+
+// IsLeader returns true if this instance holds the lease.
+func (m *Maintenance) IsLeader() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.leader
+}
+
+// Run runs the jobs that are due whenever this instance is the leader,
+// until ctx is done, when it releases the lease and returns ctx.Err(), or
+// the tree is closed, when it releases the lease and returns ErrClosed.
+// Jobs are run one at a time, so a job that runs for longer than its
+// interval delays the others. The lease is renewed between jobs, so no
+// job should run for longer than LeaseDuration.
+func (m *Maintenance) Run(ctx context.Context) error {
+	t := m.Tree
+	if err := t.ready(); err != nil {
+		return err
+	}
+	leaseDuration := m.LeaseDuration
+	if leaseDuration == 0 {
+		leaseDuration = DefaultMaintenanceLease
+	}
+	if leaseDuration/3 <= 0 {
+		return fmt.Errorf("cannot hold a lease of %s", leaseDuration)
+	}
+	m.mu.Lock()
+	if m.owner == "" {
+		owner, err := m.Tree.randomHex(16)
+		if err != nil {
+			m.mu.Unlock()
+			return err
+		}
+		m.owner = owner
+		m.metrics = map[string]MaintenanceMetrics{}
+		m.schedule = map[string]time.Time{}
+	}
+	m.mu.Unlock()
+
+	// The lease is renewed well before it expires, and jobs are checked
+	// at least as often as the shortest interval.
+	tick := leaseDuration / 3
+	for _, job := range m.Jobs {
+		if job.Interval > 0 && job.Interval < tick {
+			tick = job.Interval
+		}
+	}
+	closing, done := t.background()
+	defer done()
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	defer m.release()
+
+	for {
+		for _, job := range m.Jobs {
+			if ctx.Err() != nil {
+				break
+			}
+			if err := m.acquire(ctx, leaseDuration); err != nil {
+				return err
+			}
+			if !m.IsLeader() {
+				break
+			}
+			m.runJob(ctx, job)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-closing:
+			return ErrClosed
+		case <-ticker.C:
+		}
+	}
+}
+
+// runJob runs job if it is due.
+func (m *Maintenance) runJob(ctx context.Context, job MaintenanceJob) {
+	start := m.Tree.now()
+	m.mu.Lock()
+	due := !start.Before(m.schedule[job.Name])
+	m.mu.Unlock()
+	if !due {
+		return
+	}
+
+	items, err := job.Run(ctx, m.Tree)
+	duration := m.Tree.since(start)
+
+	m.mu.Lock()
+	m.schedule[job.Name] = start.Add(job.Interval)
+	metrics := m.metrics[job.Name]
+	metrics.Runs++
+	if err != nil {
+		metrics.Failures++
+	}
+	metrics.Items += items
+	metrics.LastStart, metrics.LastDuration, metrics.LastErr = start, duration, err
+	m.metrics[job.Name] = metrics
+	m.mu.Unlock()
+
+	if m.OnJob != nil {
+		m.OnJob(job.Name, items, duration, err)
+	}
+}
+
+func (m *Maintenance) leaseKey() map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"Key":   &dynamodb.AttributeValue{S: aws.String(MetadataKey)},
+		"Child": &dynamodb.AttributeValue{S: aws.String(maintenanceChild)},
+	}
+}
+
+// acquire takes or renews the lease, if it is free or already held by
+// this instance, and records whether this instance is the leader.
+func (m *Maintenance) acquire(ctx context.Context, leaseDuration time.Duration) error {
+	t := m.Tree
+	now := m.Tree.now()
+	expires := expression.Name("Expires")
+	expr, err := expression.NewBuilder().
+		WithCondition(expression.Or(
+			expression.AttributeNotExists(expression.Name("Key")),
+			expression.Name("Owner").Equal(expression.Value(m.owner)),
+			expires.LessThan(expression.Value(now.UnixNano())))).
+		WithUpdate(expression.
+			Set(expression.Name("Owner"), expression.Value(m.owner)).
+			Set(expires, expression.Value(now.Add(leaseDuration).UnixNano()))).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = t.DB.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(t.TableName),
+		Key:                       m.leaseKey(),
+		ConditionExpression:       expr.Condition(),
+		UpdateExpression:          expr.Update(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	leader := err == nil
+	if isConditionalCheckFailed(err) || ctx.Err() != nil {
+		err = nil
+	}
+	m.mu.Lock()
+	m.leader = leader
+	m.mu.Unlock()
+	return err
+}
+
+// release gives up the lease, if this instance holds it, so that another
+// instance can take over at once. The lease may be held even if the last
+// attempt to renew it was interrupted, so the row is removed whenever its
+// owner is this instance.
+func (m *Maintenance) release() {
+	m.mu.Lock()
+	m.leader = false
+	m.mu.Unlock()
+	t := m.Tree
+	expr, err := expression.NewBuilder().
+		WithCondition(expression.Name("Owner").Equal(expression.Value(m.owner))).
+		Build()
+	if err != nil {
+		return
+	}
+	t.DB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName:                 aws.String(t.TableName),
+		Key:                       m.leaseKey(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+}
+
+// GCJob returns a job that runs GC below prefix every interval, removing
+// dangling links and pruning empty directories. Its items are the number
+// of links and directory entries removed.
+func GCJob(prefix []string, interval time.Duration) MaintenanceJob {
+	return MaintenanceJob{
+		Name:     "gc",
+		Interval: interval,
+		Run: func(ctx context.Context, t *Tree) (int, error) {
+			result, err := t.GC(prefix, WithContext(ctx))
+			if err != nil {
+				return 0, err
+			}
+			return len(result.DanglingLinks) + len(result.EmptyDirectories), nil
+		},
+	}
+}
+
+// SweepJob returns a job that enforces the tree's RetentionPolicies every
+// interval, using a Sweeper. Its items are the number of events, versions
+// and deleted objects removed.
+func SweepJob(interval time.Duration) MaintenanceJob {
+	return MaintenanceJob{
+		Name:     "sweep",
+		Interval: interval,
+		Run: func(ctx context.Context, t *Tree) (int, error) {
+			result, err := (&Sweeper{Tree: t}).Sweep(ctx)
+			if err != nil {
+				return 0, err
+			}
+			return result.Events + result.Versions + result.Purged, nil
+		},
+	}
+}
+
+// IntegrityProblem is an inconsistency found by the job returned by
+// IntegrityJob.
+type IntegrityProblem struct {
+	Key     []string
+	Problem string
+}
+
+func (p IntegrityProblem) String() string {
+	return fmt.Sprintf("%q: %s", strings.Join(p.Key, "/"), p.Problem)
+}
+
+// IntegrityJob returns a job that every interval reads a sample of up to
+// sampleSize rows from a random part of the table and checks that each
+// object and link has an entry in its directory, and that each directory
+// entry names a key at or below which something is stored. It calls
+// report, if not nil, with each problem found. Its items are the number of
+// problems found. Problems found in a table that is being modified may be
+// the intermediate states of writes in progress.
+func IntegrityJob(sampleSize int, interval time.Duration, report func(IntegrityProblem)) MaintenanceJob {
+	return MaintenanceJob{
+		Name:     "integrity",
+		Interval: interval,
+		Run: func(ctx context.Context, t *Tree) (int, error) {
+			problems, err := t.checkIntegrity(ctx, sampleSize)
+			if report != nil {
+				for _, problem := range problems {
+					report(problem)
+				}
+			}
+			return len(problems), err
+		},
+	}
+}
+
+// integritySegments is the number of segments into which checkIntegrity
+// divides the table to choose a sample.
+const integritySegments = 16
+
+// checkIntegrity checks a sample of up to sampleSize rows from a random
+// segment of the table.
+func (t *Tree) checkIntegrity(ctx context.Context, sampleSize int) ([]IntegrityProblem, error) {
+	return t.checkSegment(ctx, t.randomIntn(integritySegments), sampleSize)
+}
+
+// checkSegment checks up to sampleSize rows from the given segment of the
+// table.
+func (t *Tree) checkSegment(ctx context.Context, segment, sampleSize int) ([]IntegrityProblem, error) {
+	if err := t.checkScan("IntegrityJob"); err != nil {
+		return nil, err
+	}
+	o, cancel := newCallOptions([]Option{WithContext(ctx), ConsistentRead()})
+	defer cancel()
+	output, err := t.DB.ScanWithContext(ctx, &dynamodb.ScanInput{
+		TableName:      aws.String(t.TableName),
+		ConsistentRead: aws.Bool(true),
+		Segment:        aws.Int64(int64(segment)),
+		TotalSegments:  aws.Int64(integritySegments),
+		Limit:          aws.Int64(int64(sampleSize)),
+	}, o.request()...)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []IntegrityProblem{}
+	for _, row := range output.Items {
+		exportRow, err := t.exportRow(row)
+		if err != nil {
+			return problems, err
+		}
+		if exportRow == nil {
+			continue
+		}
+		key := exportRow.Key
+		switch exportRow.Kind {
+		case ExportObject, ExportLink:
+			if len(key) == 0 {
+				continue
+			}
+			entry, err := t.getItem(t.dirKey(key[:len(key)-1]), t.childName(key), o)
+			if err != nil {
+				return problems, err
+			}
+			if entry == nil {
+				problems = append(problems, IntegrityProblem{Key: key, Problem: "not listed in its directory"})
+			}
+		case ExportDirectoryEntry:
+			leaf, err := t.getRow(t.EncodeKey(key), o)
+			if err != nil {
+				return problems, err
+			}
+			hasChildren, err := t.hasChildren(t.dirKey(key), o)
+			if err != nil {
+				return problems, err
+			}
+			if leaf == nil && !hasChildren {
+				problems = append(problems, IntegrityProblem{Key: key, Problem: "listed but nothing is stored at or below it"})
+			}
+		}
+	}
+	return problems, nil
+}
@@ -0,0 +1,122 @@
+package dynamotree
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestMaintenance(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+	c.Assert(s.PutLink([]string{"Links", "dangling"}, []string{"Accounts", "missing"}), IsNil)
+
+	runs := map[string]int{}
+	var mu sync.Mutex
+	newMaintenance := func(name string) *Maintenance {
+		return &Maintenance{
+			Tree:          s,
+			LeaseDuration: 200 * time.Millisecond,
+			Jobs: []MaintenanceJob{
+				GCJob(nil, time.Hour),
+				{Name: "count", Interval: 10 * time.Millisecond, Run: func(ctx context.Context, t *Tree) (int, error) {
+					mu.Lock()
+					defer mu.Unlock()
+					runs[name]++
+					return 1, nil
+				}},
+			},
+		}
+	}
+	m1, m2 := newMaintenance("m1"), newMaintenance("m2")
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	done1 := make(chan error)
+	go func() { done1 <- m1.Run(ctx1) }()
+	time.Sleep(50 * time.Millisecond)
+	go m2.Run(ctx2)
+	time.Sleep(100 * time.Millisecond)
+
+	// Only the first instance to run is the leader
+	c.Assert(m1.IsLeader(), Equals, true)
+	c.Assert(m2.IsLeader(), Equals, false)
+	mu.Lock()
+	c.Assert(runs["m1"] > 1, Equals, true)
+	c.Assert(runs["m2"], Equals, 0)
+	mu.Unlock()
+	metrics := m1.Metrics()
+	c.Assert(metrics["gc"].Runs, Equals, 1)
+	c.Assert(metrics["gc"].Items, Equals, 3) // the link, and the entries of Links and Links/dangling
+	c.Assert(metrics["count"].Items, Equals, metrics["count"].Runs)
+	_, err := s.GetLink([]string{"Links", "dangling"})
+	c.Assert(err, ErrorIs, ErrNotFound)
+
+	// Once the leader stops, another instance takes over
+	cancel1()
+	c.Assert(<-done1, Equals, context.Canceled)
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(m2.IsLeader(), Equals, true)
+	mu.Lock()
+	c.Assert(runs["m2"] > 0, Equals, true)
+	mu.Unlock()
+
+	for _, d := range []time.Duration{-time.Second, 2} {
+		m := &Maintenance{Tree: s, LeaseDuration: d}
+		c.Assert(m.Run(context.Background()), ErrorMatches, `cannot hold a lease of .*`)
+	}
+
+	// A GC in progress stops once the run is canceled.
+	c.Assert(s.PutLink([]string{"Links", "dangling"}, []string{"Accounts", "missing"}), IsNil)
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = GCJob(nil, time.Hour).Run(canceled, s)
+	c.Assert(err, Equals, context.Canceled)
+	_, err = s.GetLink([]string{"Links", "dangling"})
+	c.Assert(err, IsNil)
+}
+
+func (suite *StoreImplTest) TestIntegrityJob(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+	v := AccountT{ID: "12345", Name: "alice"}
+	c.Assert(s.Put([]string{"Accounts", "alice"}, &v), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "bob"}, &v), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "carol"}, &v), IsNil)
+
+	// Remove the entry of bob, and the object of carol
+	writeRequests, err := s.deleteRequests([]string{"Accounts", "bob"})
+	c.Assert(err, IsNil)
+	c.Assert(s.batchWrite(writeRequests[:1], nil), IsNil)
+	writeRequests, err = s.deleteRequests([]string{"Accounts", "carol"})
+	c.Assert(err, IsNil)
+	c.Assert(s.batchWrite(writeRequests[1:], nil), IsNil)
+
+	problems := []string{}
+	for segment := 0; segment < integritySegments; segment++ {
+		found, err := s.checkSegment(context.Background(), segment, 100)
+		c.Assert(err, IsNil)
+		for _, problem := range found {
+			problems = append(problems, problem.String())
+		}
+	}
+	c.Assert(problems, HasLen, 2)
+	for _, problem := range []string{
+		`"Accounts/bob": not listed in its directory`,
+		`"Accounts/carol": listed but nothing is stored at or below it`,
+	} {
+		c.Assert(problems[0] == problem || problems[1] == problem, Equals, true, Commentf("%s in %q", problem, problems))
+	}
+
+	job := IntegrityJob(100, time.Hour, nil)
+	_, err = job.Run(context.Background(), s)
+	c.Assert(err, IsNil)
+}
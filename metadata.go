@@ -0,0 +1,169 @@
+package dynamotree
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// MetadataKey is the value of the Key attribute of the row in which the
+// tree records its settings. Every key the tree stores begins with the
+// SpecialCharacter, so the metadata row cannot collide with them unless
+// MetadataKey itself begins with the SpecialCharacter.
+const MetadataKey = "_dynamotree"
+
+// metadataChild is the value of the Child attribute of the metadata row.
+const metadataChild = "metadata"
+
+// SchemaVersion is the version of the storage layout that this package
+// writes, which is recorded in the metadata row. Tables that do not record
+// a version are at version 1.
+//
+// A tree refuses to use a table with a newer version than it supports.
+// A table with an older version is read in both layouts, so that it can
+// be used while MigrateSchema rewrites it to the current one.
+const SchemaVersion = 1
+
+// schemaVersion is the version of the layout written by the tree. It is a
+// variable so that migrations can be tested.
+var schemaVersion = SchemaVersion
+
+// schemaMigration describes how rows are rewritten from one version of the
+// storage layout to the next.
+type schemaMigration struct {
+	// rewrite returns the row that replaces row in the new layout, or nil
+	// if row is already in the new layout. It is applied to every row of
+	// the table, except the metadata row, so it must recognize rows that it
+	// has already rewritten.
+	rewrite func(t *Tree, row map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error)
+
+	// legacyKey, if not nil, returns the Key and Child under which the row
+	// that is stored at key and child in the new layout was stored in the
+	// old layout. Directory rows are read only in the current layout, so a
+	// migration must not move them.
+	legacyKey func(t *Tree, key, child string) (string, string)
+}
+
+// schemaMigrations[v] migrates a table from version v to version v+1.
+var schemaMigrations = map[int]schemaMigration{}
+
+func (t *Tree) metadataRowKey() map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"Key":   &dynamodb.AttributeValue{S: aws.String(MetadataKey)},
+		"Child": &dynamodb.AttributeValue{S: aws.String(metadataChild)},
+	}
+}
+
+// metadataItem returns the metadata row for a table at the given version.
+func (t *Tree) metadataItem(version int) map[string]*dynamodb.AttributeValue {
+	item := t.metadataRowKey()
+	item["SpecialCharacter"] = &dynamodb.AttributeValue{S: aws.String(t.SpecialCharacter)}
+	item["SchemaVersion"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(version))}
+	return item
+}
+
+// putMetadata records SpecialCharacter in the metadata row, if it has not
+// been recorded already, and checks that the recorded value matches.
+func (t *Tree) putMetadata() error {
+	if err := t.checkSpecialCharacter(); err != nil {
+		return err
+	}
+	_, err := t.DB.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(t.TableName),
+		Item:                t.metadataItem(schemaVersion),
+		ConditionExpression: aws.String("attribute_not_exists(#K)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#K": aws.String("Key"),
+		},
+	})
+	if isConditionalCheckFailed(err) {
+		return t.checkMetadata()
+	}
+	return err
+}
+
+// checkMetadata returns a *SchemaError if the metadata row records a
+// different SpecialCharacter than the tree is using, or a newer version of
+// the storage layout than it supports. If the table is at an older
+// version, it prepares the tree to read rows in the older layouts. Tables
+// created without a metadata row are not checked.
+func (t *Tree) checkMetadata() error {
+	if err := t.checkSpecialCharacter(); err != nil {
+		return err
+	}
+	resp, err := t.DB.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(t.TableName),
+		Key:            t.metadataRowKey(),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return err
+	}
+	if len(resp.Item) == 0 {
+		return t.setSchemaVersion(1)
+	}
+	recorded := ""
+	if v := resp.Item["SpecialCharacter"]; v != nil {
+		recorded = aws.StringValue(v.S)
+	}
+	if recorded != t.SpecialCharacter {
+		return &SchemaError{
+			TableName: t.TableName,
+			Problem: fmt.Sprintf("the table's SpecialCharacter is %q, not %q",
+				recorded, t.SpecialCharacter),
+		}
+	}
+
+	version := 1
+	if v := resp.Item["SchemaVersion"]; v != nil {
+		if version, err = strconv.Atoi(aws.StringValue(v.N)); err != nil {
+			return &SchemaError{
+				TableName: t.TableName,
+				Problem:   fmt.Sprintf("the table's SchemaVersion %q is not a number", aws.StringValue(v.N)),
+			}
+		}
+	}
+	return t.setSchemaVersion(version)
+}
+
+// setSchemaVersion prepares the tree to use a table whose rows are at the
+// given version of the storage layout.
+func (t *Tree) setSchemaVersion(version int) error {
+	if version > schemaVersion {
+		return &SchemaError{
+			TableName: t.TableName,
+			Problem: fmt.Sprintf("the table uses schema version %d, but this version of dynamotree supports at most %d",
+				version, schemaVersion),
+		}
+	}
+	legacy := []schemaMigration{}
+	for v := version; v < schemaVersion; v++ {
+		m, ok := schemaMigrations[v]
+		if !ok {
+			return &SchemaError{
+				TableName: t.TableName,
+				Problem:   fmt.Sprintf("the table uses schema version %d, which can no longer be read", version),
+			}
+		}
+		legacy = append(legacy, m)
+	}
+	t.tableVersion = version
+	t.legacySchema = legacy
+	return nil
+}
+
+// upgradeRow rewrites a row read from the table into the current layout.
+func (t *Tree) upgradeRow(row map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error) {
+	for _, m := range t.legacySchema {
+		newRow, err := m.rewrite(t, row)
+		if err != nil {
+			return nil, err
+		}
+		if newRow != nil {
+			row = newRow
+		}
+	}
+	return row, nil
+}
@@ -0,0 +1,147 @@
+package dynamotree
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestMetadataSpecialCharacter(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	err := s.CreateTable()
+	c.Assert(err, IsNil)
+	err = s.CreateTable()
+	c.Assert(err, IsNil)
+
+	v := AccountT{ID: "12345"}
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &v), IsNil)
+
+	expectedErr := &SchemaError{
+		TableName: tableName,
+		Problem:   `the table's SpecialCharacter is "¦", not "/"`,
+	}
+	s2 := &Tree{TableName: tableName, DB: db, SpecialCharacter: "/"}
+	c.Assert(s2.CreateTable(), DeepEquals, expectedErr)
+	s2 = &Tree{TableName: tableName, DB: db, SpecialCharacter: "/"}
+	c.Assert(s2.Get([]string{"Accounts", "12345"}, &v), DeepEquals, expectedErr)
+	c.Assert(s2.Put([]string{"Accounts", "12345"}, &v), DeepEquals, expectedErr)
+
+	// tables without a metadata row are not checked
+	_, err = db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key:       s.metadataRowKey(),
+	})
+	c.Assert(err, IsNil)
+	s2 = &Tree{TableName: tableName, DB: db, SpecialCharacter: "/"}
+	c.Assert(s2.Get([]string{"Accounts", "12345"}, &v), ErrorIs, ErrNotFound)
+}
+
+func (suite *StoreImplTest) TestMetadataKeyCollision(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db, SpecialCharacter: "_"}
+	err := s.CreateTable()
+	c.Assert(err, DeepEquals, &SpecialCharacterError{
+		SpecialCharacter: "_",
+		Problem:          "it is a prefix of _dynamotree",
+	})
+}
+
+func (suite *StoreImplTest) TestMetadataNewerSchemaVersion(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+
+	_, err := db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(tableName),
+		Item:      s.metadataItem(SchemaVersion + 1),
+	})
+	c.Assert(err, IsNil)
+
+	s = &Tree{TableName: tableName, DB: db}
+	var v AccountT
+	c.Assert(s.Get([]string{"Accounts", "12345"}, &v), DeepEquals, &SchemaError{
+		TableName: tableName,
+		Problem:   "the table uses schema version 2, but this version of dynamotree supports at most 1",
+	})
+}
+
+func (suite *StoreImplTest) TestMigrateSchema(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+
+	// In the pretend version 1 layout, objects are stored with Child "¦old"
+	// and their names in OldName.
+	for _, id := range []string{"1", "2", "3"} {
+		writeRequests, err := s.putRequests([]string{"Accounts", id}, &AccountT{ID: id})
+		c.Assert(err, IsNil)
+		old := writeRequests[len(writeRequests)-1].PutRequest.Item
+		old["Child"] = &dynamodb.AttributeValue{S: aws.String("¦old")}
+		old["OldName"] = &dynamodb.AttributeValue{S: aws.String("name " + id)}
+		c.Assert(s.batchWrite(writeRequests, nil), IsNil)
+	}
+
+	defer func() {
+		schemaVersion = SchemaVersion
+		delete(schemaMigrations, 1)
+	}()
+	schemaVersion = 2
+	schemaMigrations[1] = schemaMigration{
+		rewrite: func(t *Tree, row map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error) {
+			if *row["Child"].S != "¦old" {
+				return nil, nil
+			}
+			newRow := map[string]*dynamodb.AttributeValue{}
+			for k, v := range row {
+				newRow[k] = v
+			}
+			delete(newRow, "OldName")
+			newRow["Child"] = &dynamodb.AttributeValue{S: aws.String("¦")}
+			newRow["Name"] = row["OldName"]
+			return newRow, nil
+		},
+		legacyKey: func(t *Tree, key, child string) (string, string) {
+			if child == "¦" {
+				child = "¦old"
+			}
+			return key, child
+		},
+	}
+
+	// Rows in either layout are read while the table is migrated.
+	s = &Tree{TableName: tableName, DB: db}
+	c.Assert(s.Put([]string{"Accounts", "4"}, &AccountT{ID: "4", Name: "name 4"}), IsNil)
+	for _, id := range []string{"1", "4"} {
+		var v AccountT
+		c.Assert(s.Get([]string{"Accounts", id}, &v), IsNil)
+		c.Assert(v, DeepEquals, AccountT{ID: id, Name: "name " + id})
+	}
+
+	result, err := MigrateSchema(s, nil)
+	c.Assert(err, IsNil)
+	c.Assert(result.Rows, Equals, 3)
+	c.Assert(s.legacySchema, HasLen, 0)
+
+	row, err := s.getItem("¦Accounts¦1", "¦old", nil)
+	c.Assert(err, IsNil)
+	c.Assert(row, IsNil)
+	row, err = s.getItem(MetadataKey, metadataChild, nil)
+	c.Assert(err, IsNil)
+	c.Assert(*row["SchemaVersion"].N, Equals, "2")
+
+	s = &Tree{TableName: tableName, DB: db}
+	for _, id := range []string{"1", "2", "3", "4"} {
+		var v AccountT
+		c.Assert(s.Get([]string{"Accounts", id}, &v), IsNil)
+		c.Assert(v, DeepEquals, AccountT{ID: id, Name: "name " + id})
+	}
+	result, err = MigrateSchema(s, nil)
+	c.Assert(err, IsNil)
+	c.Assert(result.Rows, Equals, 0)
+}
@@ -0,0 +1,117 @@
+package dynamotree
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// OperationMetrics describes a call to one of the tree's operations, as
+// given to Tree.OnOperation.
+type OperationMetrics struct {
+	// Operation is the name of the method called, such as "Put".
+	Operation string
+
+	// Prefix is the first Tree.MetricsPrefixDepth parts of the key given
+	// to the call, or all of them if it has fewer. Because it leaves out
+	// the rest of the key, it can be used as a dimension of a metric, such
+	// as the collection or tenant that the call was for, without there
+	// being a metric for every key.
+	Prefix []string
+
+	// Duration is how long the call took.
+	Duration time.Duration
+
+	// Requests is the number of requests made to DynamoDB, counting each
+	// retry of a request.
+	Requests int
+
+	// Err is the error returned by the call, if any.
+	Err error
+}
+
+// callStats counts the requests made by a call.
+type callStats struct {
+	mu       sync.Mutex
+	requests int
+	pages    int
+	retries  []RetryAttempt
+}
+
+// count is a request.Option that adds r to the statistics.
+func (s *callStats) count(r *request.Request) {
+	r.Handlers.Send.PushFront(func(*request.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests++
+	})
+	// The error is cleared by the time the request is known to be retried.
+	var failed error
+	var retryCount int
+	r.Handlers.AfterRetry.PushFront(func(r *request.Request) {
+		failed, retryCount = r.Error, r.RetryCount
+	})
+	r.Handlers.AfterRetry.PushBack(func(r *request.Request) {
+		if r.RetryCount == retryCount {
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.retries = append(s.retries, RetryAttempt{Request: r.Operation.Name, Err: failed})
+	})
+	switch r.Operation.Name {
+	case "Query", "Scan":
+		r.Handlers.Complete.PushBack(func(r *request.Request) {
+			if r.Error != nil {
+				return
+			}
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.pages++
+		})
+	}
+}
+
+// startCall is newCallOptions for a call to the operation op on key, which
+// is reported to Tree.OnOperation, and if it is slow to
+// Tree.OnSlowOperation, once the returned function is called with *errp
+// holding the error returned by the call. The call is also counted by
+// Tree.HeatSampler.
+func (t *Tree) startCall(op string, key []string, errp *error, opts []Option) (*callOptions, func()) {
+	o, cancel := newCallOptions(opts)
+	t.sampleHeat(op, key)
+	if t.OnOperation == nil && t.SlowOperationThreshold <= 0 {
+		return o, cancel
+	}
+	prefix := key
+	if len(prefix) > t.MetricsPrefixDepth {
+		prefix = prefix[:t.MetricsPrefixDepth:t.MetricsPrefixDepth]
+	}
+	stats := &callStats{}
+	o.requestOptions = append(o.requestOptions, stats.count)
+	start := t.now()
+	return o, func() {
+		cancel()
+		stats.mu.Lock()
+		defer stats.mu.Unlock()
+		m := OperationMetrics{
+			Operation: op,
+			Prefix:    prefix,
+			Duration:  t.since(start),
+			Requests:  stats.requests,
+			Err:       *errp,
+		}
+		if t.OnOperation != nil {
+			t.OnOperation(m)
+		}
+		if t.SlowOperationThreshold > 0 && m.Duration >= t.SlowOperationThreshold {
+			t.reportSlow(&SlowOperation{
+				OperationMetrics: m,
+				Key:              key,
+				Pages:            stats.pages,
+				Retries:          stats.retries,
+			})
+		}
+	}
+}
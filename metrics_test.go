@@ -0,0 +1,39 @@
+package dynamotree
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestOnOperation(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	var mu sync.Mutex
+	calls := []OperationMetrics{}
+	s := &Tree{TableName: uniuri.New(), DB: db, MetricsPrefixDepth: 2, OnOperation: func(m OperationMetrics) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, m)
+	}}
+	c.Assert(s.CreateTable(), IsNil)
+
+	c.Assert(s.Put([]string{"Tenants", "acme", "Accounts", "12345"}, &AccountT{ID: "12345"}), IsNil)
+	v := AccountT{}
+	c.Assert(s.Get([]string{"Tenants", "acme", "Accounts", "67890"}, &v), ErrorIs, ErrNotFound)
+	s.List([]string{"Tenants"}, func(string, error) bool { return true })
+
+	c.Assert(calls, HasLen, 3)
+	c.Assert(calls[0].Operation, Equals, "Put")
+	c.Assert(calls[0].Prefix, DeepEquals, []string{"Tenants", "acme"})
+	c.Assert(calls[0].Requests, Equals, 1)
+	c.Assert(calls[0].Err, IsNil)
+	c.Assert(calls[0].Duration > 0, Equals, true)
+	c.Assert(calls[1].Operation, Equals, "Get")
+	c.Assert(calls[1].Err, Equals, ErrNotFound)
+	c.Assert(calls[2].Operation, Equals, "List")
+	c.Assert(calls[2].Prefix, DeepEquals, []string{"Tenants"})
+	c.Assert(calls[2].Requests, Equals, 1)
+}
@@ -0,0 +1,359 @@
+package dynamotree
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// MigrateOptions controls the behavior of MigrateDelimiter.
+type MigrateOptions struct {
+	// TransformPart, if not nil, is applied to each part of each key as it
+	// is migrated, for example to escape characters that will be reserved
+	// by the new SpecialCharacter.
+	TransformPart func(part string) (string, error)
+
+	// Progress, if not nil, is called with the number of rows migrated so
+	// far each time a batch of rows is written.
+	Progress func(rows int)
+}
+
+// MigrateResult describes the outcome of MigrateDelimiter.
+type MigrateResult struct {
+	// Rows is the number of rows migrated.
+	Rows int
+}
+
+// MigrateDelimiter copies every row of src's table into dst's table,
+// rewriting the keys, directory entries and links from src's
+// SpecialCharacter to dst's. The trees may use the same table, in which
+// case each row is removed once its replacement has been written. Once
+// all rows have been copied, the result is verified by counting the rows
+// of each tree and the metadata row is updated to record dst's
+// SpecialCharacter.
+//
+// The migration reads the entire table. It is not atomic, and the tree
+// must not be modified while it is running. If it fails, it may be run
+// again once any rows it has written to dst have been removed.
+func MigrateDelimiter(src, dst *Tree, opts MigrateOptions) (*MigrateResult, error) {
+	src.initOnce.Do(src.init)
+	dst.initOnce.Do(dst.init)
+	m := &migration{src: src, dst: dst, opts: opts}
+
+	if src.SpecialCharacter == dst.SpecialCharacter {
+		return nil, fmt.Errorf("source and destination both use SpecialCharacter %q", src.SpecialCharacter)
+	}
+	m.sameTable = src.TableName == dst.TableName
+	if m.sameTable && (strings.HasPrefix(src.SpecialCharacter, dst.SpecialCharacter) ||
+		strings.HasPrefix(dst.SpecialCharacter, src.SpecialCharacter)) {
+		return nil, fmt.Errorf("cannot migrate within a table between SpecialCharacters %q and %q because one is a prefix of the other",
+			src.SpecialCharacter, dst.SpecialCharacter)
+	}
+	if err := dst.checkSpecialCharacter(); err != nil {
+		return nil, err
+	}
+	if err := src.checkScan("MigrateDelimiter"); err != nil {
+		return nil, err
+	}
+	if err := dst.checkScan("MigrateDelimiter"); err != nil {
+		return nil, err
+	}
+
+	existing, err := countRows(dst)
+	if err != nil {
+		return nil, err
+	}
+	if existing != 0 {
+		return nil, fmt.Errorf("destination table %s already contains %d rows using %q",
+			dst.TableName, existing, dst.SpecialCharacter)
+	}
+
+	var innerErr error
+	err = src.DB.ScanPages(&dynamodb.ScanInput{
+		TableName:      aws.String(src.TableName),
+		ConsistentRead: aws.Bool(true),
+	}, func(p *dynamodb.ScanOutput, lastPage bool) bool {
+		innerErr = m.migrate(p.Items)
+		return innerErr == nil
+	})
+	if err == nil {
+		err = innerErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if remaining, err := countRows(src); err != nil {
+		return nil, err
+	} else if m.sameTable && remaining != 0 {
+		return nil, fmt.Errorf("verification failed: %d rows remain using %q", remaining, src.SpecialCharacter)
+	}
+	if written, err := countRows(dst); err != nil {
+		return nil, err
+	} else if written != m.rows {
+		return nil, fmt.Errorf("verification failed: migrated %d rows but found %d", m.rows, written)
+	}
+
+	_, err = dst.DB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(dst.TableName),
+		Item:      dst.metadataItem(schemaVersion),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &MigrateResult{Rows: m.rows}, nil
+}
+
+type migration struct {
+	src, dst  *Tree
+	opts      MigrateOptions
+	sameTable bool
+	rows      int
+}
+
+// migrate writes the replacements for rows to dst and, if the trees share
+// a table, removes rows.
+func (m *migration) migrate(rows []map[string]*dynamodb.AttributeValue) error {
+	puts := []*dynamodb.WriteRequest{}
+	deletes := []*dynamodb.WriteRequest{}
+	for _, row := range rows {
+		if !strings.HasPrefix(aws.StringValue(row["Key"].S), m.src.SpecialCharacter) {
+			continue // the metadata row, or a row already migrated
+		}
+		newRow, err := m.rewriteRow(row)
+		if err != nil {
+			return err
+		}
+		puts = append(puts, &dynamodb.WriteRequest{
+			PutRequest: &dynamodb.PutRequest{Item: newRow},
+		})
+		if m.sameTable {
+			deletes = append(deletes, &dynamodb.WriteRequest{
+				DeleteRequest: &dynamodb.DeleteRequest{
+					Key: map[string]*dynamodb.AttributeValue{
+						"Key":   row["Key"],
+						"Child": row["Child"],
+					},
+				},
+			})
+		}
+	}
+
+	if err := m.dst.batchWrite(puts, nil); err != nil {
+		return err
+	}
+	if err := m.src.batchWrite(deletes, nil); err != nil {
+		return err
+	}
+	m.rows += len(puts)
+	if m.opts.Progress != nil && len(puts) > 0 {
+		m.opts.Progress(m.rows)
+	}
+	return nil
+}
+
+// rewriteRow returns the row that replaces row in dst.
+func (m *migration) rewriteRow(row map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error) {
+	src, dst := m.src, m.dst
+	pathKey, childKey := *row["Key"].S, *row["Child"].S
+
+	linkTarget, isLink := src.linkTarget(row)
+	newRow := make(map[string]*dynamodb.AttributeValue, len(row))
+	for name, value := range row {
+		if isLink && (name == src.LinkAttribute || name == src.SpecialCharacter) {
+			continue // the link target is rewritten below
+		}
+		// Attributes used internally are named using the special character.
+		if strings.HasPrefix(name, src.SpecialCharacter) {
+			name = dst.SpecialCharacter + strings.TrimPrefix(name, src.SpecialCharacter)
+		}
+		newRow[name] = value
+	}
+
+	var newPathKey, newChildKey string
+	switch {
+	case childKey == src.SpecialCharacter:
+		// The row of an object or link
+		key, err := m.rewriteKey(src.DecodeKey(pathKey))
+		if err != nil {
+			return nil, err
+		}
+		newPathKey, newChildKey = dst.EncodeKey(key), dst.SpecialCharacter
+
+		if isLink {
+			target, err := m.rewriteKey(src.DecodeKey(linkTarget))
+			if err != nil {
+				return nil, err
+			}
+			newRow[dst.LinkAttribute] = &dynamodb.AttributeValue{S: aws.String(dst.EncodeKey(target))}
+		}
+
+	case strings.HasPrefix(childKey, src.SpecialCharacter):
+		// Rows stored alongside an object, such as events
+		key, err := m.rewriteKey(src.DecodeKey(pathKey))
+		if err != nil {
+			return nil, err
+		}
+		newPathKey = dst.EncodeKey(key)
+		newChildKey = strings.Replace(childKey, src.SpecialCharacter, dst.SpecialCharacter, -1)
+
+	default:
+		// A directory entry
+		var prefix []string
+		if pathKey != src.SpecialCharacter {
+			prefix = src.DecodeKey(strings.TrimSuffix(pathKey, src.SpecialCharacter))
+		}
+		key, err := m.rewriteKey(append(prefix, src.decodePart(childKey)))
+		if err != nil {
+			return nil, err
+		}
+		newPathKey, newChildKey = dst.dirKey(key[:len(key)-1]), dst.childName(key)
+	}
+
+	newRow["Key"] = &dynamodb.AttributeValue{S: aws.String(newPathKey)}
+	newRow["Child"] = &dynamodb.AttributeValue{S: aws.String(newChildKey)}
+	return newRow, nil
+}
+
+// rewriteKey applies TransformPart to each part of key and checks that the
+// result can be stored in dst.
+func (m *migration) rewriteKey(key []string) ([]string, error) {
+	rv := make([]string, len(key))
+	for i, part := range key {
+		if m.opts.TransformPart != nil {
+			var err error
+			if part, err = m.opts.TransformPart(part); err != nil {
+				return nil, err
+			}
+		}
+		rv[i] = part
+	}
+	// Depth is not checked, because keys that exist already are migrated
+	// as they are.
+	if err := m.dst.validateParts(rv); err != nil {
+		return nil, fmt.Errorf("cannot migrate %q: %s", strings.Join(key, "/"), err)
+	}
+	return rv, nil
+}
+
+// countRows returns the number of rows in t's table whose keys are
+// encoded using t's SpecialCharacter.
+func countRows(t *Tree) (int, error) {
+	count := 0
+	err := t.DB.ScanPages(&dynamodb.ScanInput{
+		TableName:                aws.String(t.TableName),
+		ConsistentRead:           aws.Bool(true),
+		FilterExpression:         aws.String("begins_with(#K, :sc)"),
+		ExpressionAttributeNames: map[string]*string{"#K": aws.String("Key")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":sc": &dynamodb.AttributeValue{S: aws.String(t.SpecialCharacter)},
+		},
+		Select: aws.String(dynamodb.SelectCount),
+	}, func(p *dynamodb.ScanOutput, lastPage bool) bool {
+		count += int(aws.Int64Value(p.Count))
+		return true
+	})
+	return count, err
+}
+
+// MigrateSchema rewrites every row of t's table that is in an older
+// version of the storage layout into the current one, and then records
+// SchemaVersion in the metadata row. Until it has finished, trees read
+// rows in both layouts, so the table remains usable while it runs.
+//
+// Every process writing to the table must use a version of this package
+// that supports SchemaVersion before MigrateSchema is run. If progress is
+// not nil, it is called with the number of rows rewritten so far each
+// time a batch of rows is written.
+func MigrateSchema(t *Tree, progress func(rows int)) (*MigrateResult, error) {
+	if err := t.ready(); err != nil {
+		return nil, err
+	}
+	result := &MigrateResult{}
+	from := t.tableVersion
+	if from == schemaVersion {
+		return result, nil
+	}
+
+	if err := t.checkScan("MigrateSchema"); err != nil {
+		return nil, err
+	}
+	var innerErr error
+	err := t.DB.ScanPages(&dynamodb.ScanInput{
+		TableName:      aws.String(t.TableName),
+		ConsistentRead: aws.Bool(true),
+	}, func(p *dynamodb.ScanOutput, lastPage bool) bool {
+		writeRequests := []*dynamodb.WriteRequest{}
+		for _, row := range p.Items {
+			if aws.StringValue(row["Key"].S) == MetadataKey {
+				continue
+			}
+			newRow, err := t.upgradeRow(row)
+			if err != nil {
+				innerErr = err
+				return false
+			}
+			if reflect.DeepEqual(newRow, row) {
+				continue
+			}
+			writeRequests = append(writeRequests, &dynamodb.WriteRequest{
+				PutRequest: &dynamodb.PutRequest{Item: newRow},
+			})
+			if *newRow["Key"].S != *row["Key"].S || *newRow["Child"].S != *row["Child"].S {
+				writeRequests = append(writeRequests, &dynamodb.WriteRequest{
+					DeleteRequest: &dynamodb.DeleteRequest{
+						Key: map[string]*dynamodb.AttributeValue{
+							"Key":   row["Key"],
+							"Child": row["Child"],
+						},
+					},
+				})
+			}
+			result.Rows++
+		}
+		if innerErr = t.batchWrite(writeRequests, nil); innerErr != nil {
+			return false
+		}
+		if progress != nil && len(writeRequests) > 0 {
+			progress(result.Rows)
+		}
+		return true
+	})
+	if err == nil {
+		err = innerErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The condition guards against a concurrent migration having recorded
+	// a different version.
+	_, err = t.DB.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(t.TableName),
+		Item:                t.metadataItem(schemaVersion),
+		ConditionExpression: aws.String("attribute_not_exists(#V) OR #V = :from"),
+		ExpressionAttributeNames: map[string]*string{
+			"#V": aws.String("SchemaVersion"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":from": &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(from))},
+		},
+	})
+	if isConditionalCheckFailed(err) {
+		return nil, &ConditionFailedError{Op: "MigrateSchema", Err: err}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t.readyMu.Lock()
+	defer t.readyMu.Unlock()
+	if err := t.setSchemaVersion(schemaVersion); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,100 @@
+package dynamotree
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestMigrateDelimiter(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	src := &Tree{TableName: tableName, DB: db, SpecialCharacter: "/"}
+	err := src.CreateTable()
+	c.Assert(err, IsNil)
+
+	alice := AccountT{ID: "12345", Name: "alice"}
+	c.Assert(src.Put([]string{"Accounts", "12345"}, &alice), IsNil)
+	c.Assert(src.Put([]string{"Accounts", "12345", "Keys", "a|b"}, &alice), IsNil)
+	c.Assert(src.PutLink([]string{"ByName", "alice"}, []string{"Accounts", "12345"}), IsNil)
+	_, err = src.AppendEvent([]string{"Accounts", "12345"}, &AccountT{Name: "created"})
+	c.Assert(err, IsNil)
+
+	dst := &Tree{TableName: tableName, DB: db, SpecialCharacter: "|"}
+	rows := 0
+	result, err := MigrateDelimiter(src, dst, MigrateOptions{
+		TransformPart: func(part string) (string, error) {
+			return strings.Replace(part, "|", "%7C", -1), nil
+		},
+		Progress: func(n int) { rows = n },
+	})
+	c.Assert(err, IsNil)
+	c.Assert(result.Rows, Equals, 10)
+	c.Assert(rows, Equals, 10)
+
+	var v AccountT
+	c.Assert(dst.Get([]string{"ByName", "alice"}, &v), IsNil)
+	c.Assert(v, DeepEquals, alice)
+	c.Assert(dst.Get([]string{"Accounts", "12345", "Keys", "a%7Cb"}, &v), IsNil)
+	link, err := dst.GetLink([]string{"ByName", "alice"})
+	c.Assert(err, IsNil)
+	c.Assert(link, DeepEquals, []string{"Accounts", "12345"})
+
+	keys := []string{}
+	dst.Walk(nil, func(key []string, err error) bool {
+		c.Assert(err, IsNil)
+		keys = append(keys, strings.Join(key, "/"))
+		return true
+	})
+	c.Assert(keys, DeepEquals, []string{
+		"Accounts", "Accounts/12345", "Accounts/12345/Keys", "Accounts/12345/Keys/a%7Cb",
+		"ByName", "ByName/alice",
+	})
+
+	events := 0
+	dst.ReadEvents([]string{"Accounts", "12345"}, time.Time{}, func(event *Event, err error) bool {
+		c.Assert(err, IsNil)
+		events++
+		return true
+	})
+	c.Assert(events, Equals, 1)
+
+	// the old delimiter is no longer accepted
+	src = &Tree{TableName: tableName, DB: db, SpecialCharacter: "/"}
+	c.Assert(src.Get([]string{"Accounts", "12345"}, &v), FitsTypeOf, &SchemaError{})
+}
+
+func (suite *StoreImplTest) TestMigrateDelimiterToNewTable(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	src := &Tree{TableName: uniuri.New(), DB: db, SpecialCharacter: "/"}
+	c.Assert(src.CreateTable(), IsNil)
+	dst := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(dst.CreateTable(), IsNil)
+
+	alice := AccountT{ID: "12345", Name: "alice"}
+	c.Assert(src.Put([]string{"Accounts", "12345"}, &alice), IsNil)
+
+	result, err := MigrateDelimiter(src, dst, MigrateOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(result.Rows, Equals, 3)
+
+	var v AccountT
+	c.Assert(dst.Get([]string{"Accounts", "12345"}, &v), IsNil)
+	c.Assert(v, DeepEquals, alice)
+	c.Assert(src.Get([]string{"Accounts", "12345"}, &v), IsNil)
+
+	// the destination must be empty
+	_, err = MigrateDelimiter(src, dst, MigrateOptions{})
+	c.Assert(err, ErrorMatches, "destination table .* already contains 3 rows using \"¦\"")
+
+	// keys that would contain the new delimiter are rejected
+	c.Assert(src.Put([]string{"Accounts", "a¦b"}, &alice), IsNil)
+	dst2 := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(dst2.CreateTable(), IsNil)
+	_, err = MigrateDelimiter(src, dst2, MigrateOptions{})
+	c.Assert(err, ErrorMatches, `cannot migrate "Accounts/a¦b": .*`)
+}
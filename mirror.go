@@ -0,0 +1,350 @@
+package dynamotree
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+)
+
+// DefaultMirrorPollInterval is the PollInterval used when a Mirror does not
+// specify one.
+const DefaultMirrorPollInterval = time.Second
+
+// DefaultMirrorMaxStaleness is the MaxStaleness used when a Mirror does not
+// specify one.
+const DefaultMirrorMaxStaleness = 10 * time.Second
+
+// Mirror maintains an in-memory copy of parts of a tree and serves Get and
+// List from it. The copy is loaded when the mirror is started and is then
+// kept up to date by consuming the table's DynamoDB stream, which must be
+// enabled with a StreamViewType of NEW_IMAGE or NEW_AND_OLD_IMAGES.
+//
+// Reads from the mirror may not reflect writes made in the last
+// PollInterval or so. If the mirror falls more than MaxStaleness behind,
+// for example because it cannot reach the stream, reads are served from
+// the tree instead.
+//
+// Loading the mirror reads every key below each of Prefixes, so a mirror
+// is best suited to small subtrees that are read often and change rarely.
+type Mirror struct {
+	// Tree is the tree being mirrored.
+	Tree *Tree
+
+	// Streams is the client used to read the table's stream.
+	Streams *dynamodbstreams.DynamoDBStreams
+
+	// StreamARN is the ARN of the table's stream. If empty, the table's
+	// latest stream is used.
+	StreamARN string
+
+	// Prefixes are the parts of the tree to mirror. A nil prefix mirrors
+	// the whole tree.
+	Prefixes [][]string
+
+	// PollInterval is how often the stream is read. If zero,
+	// DefaultMirrorPollInterval is used.
+	PollInterval time.Duration
+
+	// MaxStaleness is how far the mirror may fall behind before reads are
+	// served from the tree. If zero, DefaultMirrorMaxStaleness is used.
+	MaxStaleness time.Duration
+
+	mu       sync.RWMutex
+	rows     map[string]map[string]map[string]*dynamodb.AttributeValue
+	lastSync time.Time
+	err      error
+
+	reader    *streamReader
+	stop      chan struct{}
+	done      chan struct{}
+	untrack   func()
+	closeOnce sync.Once
+}
+
+// Start loads the mirror and begins consuming the stream. Start returns
+// once the mirror has been loaded. Call Close to stop consuming the stream.
+func (m *Mirror) Start() error {
+	t := m.Tree
+	if err := t.ready(); err != nil {
+		return err
+	}
+	if t.keyMapper != nil {
+		return ErrKeySchemaStream
+	}
+	if m.PollInterval == 0 {
+		m.PollInterval = DefaultMirrorPollInterval
+	}
+	if m.MaxStaleness == 0 {
+		m.MaxStaleness = DefaultMirrorMaxStaleness
+	}
+
+	if m.StreamARN == "" {
+		arn, err := t.latestStreamARN()
+		if err != nil {
+			return err
+		}
+		m.StreamARN = arn
+	}
+
+	// Find our position in the stream before loading, so that no changes
+	// are missed. Changes made during loading are applied twice, which is
+	// harmless because each record carries the whole new row.
+	reader, err := newStreamReader(m.Streams, m.StreamARN)
+	if err != nil {
+		return err
+	}
+	m.reader = reader
+	syncTime := t.now()
+	if err := m.load(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.lastSync = syncTime
+	m.mu.Unlock()
+
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	go m.run()
+	m.untrack = t.onClose(func(context.Context) error { return m.Close() })
+	return nil
+}
+
+// Close stops consuming the stream. Closing the tree closes the mirror.
+// Close may be called more than once, and while the tree is closing.
+func (m *Mirror) Close() error {
+	if m.stop == nil {
+		return nil
+	}
+	m.closeOnce.Do(func() {
+		close(m.stop)
+		<-m.done
+		m.untrack()
+	})
+	return nil
+}
+
+// Staleness returns how far behind the stream the mirror may be, and the
+// error that prevented it from catching up, if any.
+func (m *Mirror) Staleness() (time.Duration, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.Tree.since(m.lastSync), m.err
+}
+
+// Get fetches an item in the same way as Tree.Get, from the mirror if
+// possible.
+func (m *Mirror) Get(key []string, ob Storable) (err error) {
+	t := m.Tree
+	pathKey, err := t.transformKey(key)
+	if err != nil {
+		return wrapError("Get", key, err)
+	}
+	m.mu.RLock()
+	row, ok, err := m.resolve(pathKey)
+	m.mu.RUnlock()
+	if !ok {
+		return t.Get(key, ob)
+	}
+	defer annotateError(&err, "Get", key)
+	if err != nil {
+		return err
+	}
+	if err := t.verifyChecksum(row); err != nil {
+		return err
+	}
+	return ob.UnmarshalDynamoDB(row)
+}
+
+// resolve returns a copy of the row of the object at key, following
+// symbolic links, so that the caller may use it once m.mu is released. It
+// returns false if the object cannot be found from the mirror.
+func (m *Mirror) resolve(key []string) (map[string]*dynamodb.AttributeValue, bool, error) {
+	t := m.Tree
+	if !m.fresh() {
+		return nil, false, nil
+	}
+	pathKey := t.EncodeKey(key)
+	for hops := 0; ; hops++ {
+		if !m.inScope(pathKey) {
+			return nil, false, nil
+		}
+		row := m.rows[pathKey][t.SpecialCharacter]
+		if row == nil {
+			return nil, true, ErrNotFound
+		}
+		linkTarget, ok := t.linkTarget(row)
+		if !ok {
+			rv := make(map[string]*dynamodb.AttributeValue, len(row))
+			for name, value := range row {
+				rv[name] = value
+			}
+			return rv, true, nil
+		}
+		if hops >= t.MaxLinkHops {
+			return nil, true, &LinkHopsError{Key: key, MaxLinkHops: t.MaxLinkHops}
+		}
+		pathKey = linkTarget
+	}
+}
+
+// List enumerates the immediate children of keyPrefix in the same way as
+// Tree.List, from the mirror if possible.
+func (m *Mirror) List(keyPrefix []string, itemFunc func(string, error) bool) {
+	t := m.Tree
+	prefix, err := t.transformKey(keyPrefix)
+	if err != nil {
+		itemFunc("", err)
+		return
+	}
+	pathKey := t.dirKey(prefix)
+
+	m.mu.RLock()
+	if !m.fresh() || !m.inScope(pathKey) {
+		m.mu.RUnlock()
+		t.List(keyPrefix, itemFunc)
+		return
+	}
+	children := []string{}
+	for child := range m.rows[pathKey] {
+		if !strings.HasPrefix(child, t.SpecialCharacter) {
+			children = append(children, t.decodePart(child))
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Strings(children)
+	for _, child := range children {
+		if !itemFunc(child, nil) {
+			return
+		}
+	}
+}
+
+// fresh returns true if the mirror may be read. The caller must hold mu.
+func (m *Mirror) fresh() bool {
+	return m.rows != nil && m.Tree.since(m.lastSync) <= m.MaxStaleness
+}
+
+// inScope returns true if rows whose Key attribute is pathKey are mirrored.
+func (m *Mirror) inScope(pathKey string) bool {
+	t := m.Tree
+	for _, prefix := range m.Prefixes {
+		if pathKey == t.EncodeKey(prefix) || strings.HasPrefix(pathKey, t.dirKey(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// load reads the current contents of each of Prefixes.
+func (m *Mirror) load() error {
+	t := m.Tree
+	rows := map[string]map[string]map[string]*dynamodb.AttributeValue{}
+	put := func(row map[string]*dynamodb.AttributeValue) {
+		pathKey, childKey := *row["Key"].S, *row["Child"].S
+		if rows[pathKey] == nil {
+			rows[pathKey] = map[string]map[string]*dynamodb.AttributeValue{}
+		}
+		rows[pathKey][childKey] = row
+	}
+	loadLeaf := func(key []string) error {
+		row, err := t.getRow(t.EncodeKey(key), nil)
+		if err != nil {
+			return err
+		}
+		if row != nil {
+			put(row)
+		}
+		return nil
+	}
+
+	for _, prefix := range m.Prefixes {
+		if err := loadLeaf(prefix); err != nil {
+			return err
+		}
+		var err error
+		t.Walk(prefix, func(key []string, innerErr error) bool {
+			if innerErr != nil {
+				err = innerErr
+				return false
+			}
+			put(map[string]*dynamodb.AttributeValue{
+				"Key":   &dynamodb.AttributeValue{S: aws.String(t.dirKey(key[:len(key)-1]))},
+				"Child": &dynamodb.AttributeValue{S: aws.String(t.childName(key))},
+			})
+			err = loadLeaf(key)
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	m.rows = rows
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *Mirror) run() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+
+		syncTime := m.Tree.now()
+		err := m.reader.poll(m.apply)
+		m.mu.Lock()
+		m.err = err
+		if err == nil {
+			m.lastSync = syncTime
+		}
+		m.mu.Unlock()
+	}
+}
+
+// apply applies a single stream record to the mirror.
+func (m *Mirror) apply(record *dynamodbstreams.Record) error {
+	t := m.Tree
+	keys := record.Dynamodb.Keys
+	pathKey, childKey := aws.StringValue(keys["Key"].S), aws.StringValue(keys["Child"].S)
+	if !m.inScope(pathKey) {
+		return nil
+	}
+	// Ignore rows, such as events, that are neither objects nor directory
+	// entries.
+	if strings.HasPrefix(childKey, t.SpecialCharacter) && childKey != t.SpecialCharacter {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch aws.StringValue(record.EventName) {
+	case dynamodbstreams.OperationTypeInsert, dynamodbstreams.OperationTypeModify:
+		if record.Dynamodb.NewImage == nil {
+			return fmt.Errorf("stream %s does not include new images", m.StreamARN)
+		}
+		if m.rows[pathKey] == nil {
+			m.rows[pathKey] = map[string]map[string]*dynamodb.AttributeValue{}
+		}
+		m.rows[pathKey][childKey] = record.Dynamodb.NewImage
+	case dynamodbstreams.OperationTypeRemove:
+		delete(m.rows[pathKey], childKey)
+		if len(m.rows[pathKey]) == 0 {
+			delete(m.rows, pathKey)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package dynamotree
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+// The fake DynamoDB does not implement streams, so this test loads the
+// mirror and feeds it stream records directly.
+func (suite *StoreImplTest) TestMirror(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	err := s.CreateTable()
+	c.Assert(err, IsNil)
+
+	alice := AccountT{ID: "12345", Name: "alice"}
+	bob := AccountT{ID: "6789", Name: "bob"}
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &alice), IsNil)
+	c.Assert(s.PutLink([]string{"Accounts", "alice"}, []string{"Accounts", "12345"}), IsNil)
+	c.Assert(s.Put([]string{"Other", "6789"}, &bob), IsNil)
+
+	m := &Mirror{
+		Tree:         s,
+		Prefixes:     [][]string{{"Accounts"}},
+		MaxStaleness: time.Hour,
+	}
+	c.Assert(m.load(), IsNil)
+	m.lastSync = time.Now()
+
+	list := func(prefix []string) []string {
+		items := []string{}
+		m.List(prefix, func(item string, err error) bool {
+			c.Assert(err, IsNil)
+			items = append(items, item)
+			return true
+		})
+		return items
+	}
+
+	var v AccountT
+	c.Assert(m.Get([]string{"Accounts", "alice"}, &v), IsNil)
+	c.Assert(v, DeepEquals, alice)
+	c.Assert(list([]string{"Accounts"}), DeepEquals, []string{"12345", "alice"})
+
+	// changes are not seen until they arrive from the stream
+	c.Assert(s.Put([]string{"Accounts", "6789"}, &bob), IsNil)
+	c.Assert(m.Get([]string{"Accounts", "6789"}, &v), ErrorIs, ErrNotFound)
+	c.Assert(list([]string{"Accounts"}), DeepEquals, []string{"12345", "alice"})
+
+	record := func(eventName string, pathKey, childKey string, image map[string]*dynamodb.AttributeValue) *dynamodbstreams.Record {
+		return &dynamodbstreams.Record{
+			EventName: aws.String(eventName),
+			Dynamodb: &dynamodbstreams.StreamRecord{
+				Keys: map[string]*dynamodb.AttributeValue{
+					"Key":   {S: aws.String(pathKey)},
+					"Child": {S: aws.String(childKey)},
+				},
+				NewImage: image,
+			},
+		}
+	}
+	leaf, _ := s.getRow(s.EncodeKey([]string{"Accounts", "6789"}), nil)
+	c.Assert(m.apply(record("INSERT", "¦Accounts¦", "6789", map[string]*dynamodb.AttributeValue{
+		"Key":   {S: aws.String("¦Accounts¦")},
+		"Child": {S: aws.String("6789")},
+	})), IsNil)
+	c.Assert(m.apply(record("INSERT", "¦Accounts¦6789", "¦", leaf)), IsNil)
+	c.Assert(m.Get([]string{"Accounts", "6789"}, &v), IsNil)
+	c.Assert(v, DeepEquals, bob)
+	c.Assert(list([]string{"Accounts"}), DeepEquals, []string{"12345", "6789", "alice"})
+
+	c.Assert(m.apply(record("REMOVE", "¦Accounts¦12345", "¦", nil)), IsNil)
+	c.Assert(m.Get([]string{"Accounts", "alice"}, &v), ErrorIs, ErrNotFound)
+
+	// keys outside of the mirrored prefixes are read from the tree
+	c.Assert(m.Get([]string{"Other", "6789"}, &v), IsNil)
+	c.Assert(v, DeepEquals, bob)
+	c.Assert(list([]string{"Other"}), DeepEquals, []string{"6789"})
+
+	// as are all keys when the mirror is stale
+	m.lastSync = time.Now().Add(-2 * time.Hour)
+	c.Assert(m.Get([]string{"Accounts", "alice"}, &v), IsNil)
+	c.Assert(v, DeepEquals, alice)
+
+	// The mirror may be closed by several callers at once, and by closing
+	// the tree. Its polling is stood in for by a goroutine that stops when
+	// it is told to.
+	m.stop, m.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		<-m.stop
+		close(m.done)
+	}()
+	m.untrack = s.onClose(func(context.Context) error { return m.Close() })
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Check(m.Close(), IsNil)
+		}()
+	}
+	c.Assert(s.Close(context.Background()), IsNil)
+	wg.Wait()
+	c.Assert(m.Close(), IsNil)
+}
@@ -0,0 +1,261 @@
+package dynamotree
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// destroyChildPrefix begins the value of the Child attribute of each row
+// that records a namespace waiting to be destroyed. The rows are stored
+// alongside the metadata row, so they are not part of the tree.
+const destroyChildPrefix = "destroy:"
+
+// DestroyNamespace schedules the removal of every row stored for the
+// top-level key namespace, such as a tenant: the objects and links at and
+// below it, and their versions, history, events and backlinks, so that
+// nothing of the namespace remains to be read by GetAsOf or restored from
+// the table. The request is recorded in the table and carried out by the
+// job returned by DestroyJob, which resumes it if it is interrupted.
+// PendingDestructions lists the requests not yet carried out.
+//
+// The tree stores objects as they are given, without encrypting them, so
+// the namespace remains readable until the job has removed its rows. Links
+// elsewhere in the tree to keys in the namespace are left dangling, for GC
+// to remove, but the copies of objects that they hold when
+// Tree.LinkCopyMaxSize is set are removed.
+//
+// Like Delete, DestroyNamespace returns a *GuardError if a WriteGuard
+// protects namespace, and the job stops at a key below it that a
+// WriteGuard protects.
+func (t *Tree) DestroyNamespace(namespace string, opts ...Option) (err error) {
+	defer annotateError(&err, "DestroyNamespace", []string{namespace})
+	o, cancel := newCallOptions(opts)
+	defer cancel()
+	if err := refuseCapability("DestroyNamespace", nil, o); err != nil {
+		return err
+	}
+	if err := t.ready(); err != nil {
+		return err
+	}
+	key, err := t.transformKey([]string{namespace})
+	if err != nil {
+		return err
+	}
+	if err := t.ValidateKey(key); err != nil {
+		return err
+	}
+	if _, err := t.checkGuards(key, guardDelete, o); err != nil {
+		return err
+	}
+	_, err = t.DB.PutItemWithContext(o.context(), &dynamodb.PutItemInput{
+		TableName: aws.String(t.TableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"Key":       &dynamodb.AttributeValue{S: aws.String(MetadataKey)},
+			"Child":     &dynamodb.AttributeValue{S: aws.String(destroyChildPrefix + t.EncodeKey(key))},
+			"Requested": &dynamodb.AttributeValue{S: aws.String(t.now().UTC().Format(time.RFC3339))},
+		},
+		ConditionExpression:      aws.String("attribute_not_exists(#K)"),
+		ExpressionAttributeNames: map[string]*string{"#K": aws.String("Key")},
+	}, o.request()...)
+	if isConditionalCheckFailed(err) {
+		return nil // the namespace is already scheduled to be destroyed
+	}
+	return err
+}
+
+// PendingDestructions returns the namespaces that DestroyNamespace has
+// scheduled to be destroyed, in order, which the job returned by
+// DestroyJob has not yet finished removing.
+func (t *Tree) PendingDestructions(opts ...Option) (namespaces []string, err error) {
+	defer annotateError(&err, "PendingDestructions", nil)
+	o, cancel := newCallOptions(opts)
+	defer cancel()
+	if err := refuseCapability("PendingDestructions", nil, o); err != nil {
+		return nil, err
+	}
+	if err := t.ready(); err != nil {
+		return nil, err
+	}
+	keys, err := t.pendingDestructions(o)
+	if err != nil {
+		return nil, err
+	}
+	namespaces = make([]string, len(keys))
+	for i, key := range keys {
+		namespaces[i] = key[0]
+	}
+	return namespaces, nil
+}
+
+// pendingDestructions returns the keys of the namespaces waiting to be
+// destroyed.
+func (t *Tree) pendingDestructions(o *callOptions) ([][]string, error) {
+	keys := [][]string{}
+	err := t.DB.QueryPagesWithContext(o.context(), &dynamodb.QueryInput{
+		TableName:              aws.String(t.TableName),
+		ConsistentRead:         aws.Bool(true),
+		KeyConditionExpression: aws.String("#K = :key AND begins_with(#C, :destroy)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#K": aws.String("Key"),
+			"#C": aws.String("Child"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":key":     &dynamodb.AttributeValue{S: aws.String(MetadataKey)},
+			":destroy": &dynamodb.AttributeValue{S: aws.String(destroyChildPrefix)},
+		}}, func(p *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, row := range p.Items {
+			pathKey := strings.TrimPrefix(aws.StringValue(row["Child"].S), destroyChildPrefix)
+			if key := t.DecodeKey(pathKey); len(key) == 1 {
+				keys = append(keys, key)
+			}
+		}
+		return true
+	}, o.request()...)
+	return keys, err
+}
+
+// DestroyJob returns a job that every interval removes the rows of the
+// namespaces that DestroyNamespace has scheduled to be destroyed. Its
+// items are the number of rows removed.
+func DestroyJob(interval time.Duration) MaintenanceJob {
+	return MaintenanceJob{
+		Name:     "destroy",
+		Interval: interval,
+		Run: func(ctx context.Context, t *Tree) (int, error) {
+			return t.destroyPending(ctx)
+		},
+	}
+}
+
+// destroyPending removes the rows of each namespace waiting to be
+// destroyed, and then the record that it was waiting, returning the number
+// of rows removed.
+func (t *Tree) destroyPending(ctx context.Context) (int, error) {
+	o, cancel := newCallOptions([]Option{WithContext(ctx), ConsistentRead()})
+	defer cancel()
+	keys, err := t.pendingDestructions(o)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, key := range keys {
+		n, err := t.purge(key, o)
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+
+		// The namespace's own entry in the root directory and the root's
+		// history go last, so that an interrupted purge finds the rest
+		// again.
+		root := []*dynamodb.WriteRequest{
+			purgeRequest(t.dirKey(nil), t.encodePart(key[0])),
+			purgeRequest(t.EncodeKey(nil), t.historyChild()+t.encodePart(key[0])),
+			purgeRequest(MetadataKey, destroyChildPrefix+t.EncodeKey(key)),
+		}
+		if err := t.batchWrite(root, o); err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// purge removes every row stored at and below key, deepest first, along
+// with the backlinks of the links it removes, and returns the number of
+// rows removed. The keys below key are found both by listing them and
+// from the history recorded when versions are kept, so that the versions
+// of deleted objects are removed too. A key that a WriteGuard protects
+// from deletion stops the purge with a *GuardError.
+func (t *Tree) purge(key []string, o *callOptions) (int, error) {
+	if _, err := t.checkGuards(key, guardDelete, o); err != nil {
+		return 0, err
+	}
+	names, err := t.childNamesEver(key, o)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, name := range names {
+		child := make([]string, len(key), len(key)+1)
+		copy(child, key)
+		n, err := t.purge(append(child, name), o)
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+
+	// A link to key itself would name its backlink twice, which a batch
+	// write does not allow.
+	writeRequests := []*dynamodb.WriteRequest{}
+	seen := map[RowID]bool{}
+	remove := func(writeRequest *dynamodb.WriteRequest) {
+		if id := rowID(writeRequest); !seen[id] {
+			seen[id] = true
+			writeRequests = append(writeRequests, writeRequest)
+		}
+	}
+	isObject := false
+	for _, pathKey := range []string{t.EncodeKey(key), t.dirKey(key)} {
+		err := t.DB.QueryPagesWithContext(o.context(), &dynamodb.QueryInput{
+			TableName:              aws.String(t.TableName),
+			ConsistentRead:         o.consistent(),
+			KeyConditionExpression: aws.String("#K = :key"),
+			ExpressionAttributeNames: map[string]*string{
+				"#K": aws.String("Key"),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":key": &dynamodb.AttributeValue{S: aws.String(pathKey)},
+			}}, func(p *dynamodb.QueryOutput, lastPage bool) bool {
+			for _, row := range p.Items {
+				child := aws.StringValue(row["Child"].S)
+				if child == t.SpecialCharacter {
+					target, isLink := t.linkTarget(row)
+					if isLink {
+						remove(&dynamodb.WriteRequest{
+							DeleteRequest: &dynamodb.DeleteRequest{Key: t.backlinkRow(key, target)},
+						})
+					}
+					isObject = !isLink
+				}
+				remove(purgeRequest(pathKey, child))
+			}
+			return true
+		}, o.request()...)
+		if err != nil {
+			return removed, err
+		}
+	}
+	if len(writeRequests) == 0 {
+		return removed, nil
+	}
+	if isObject {
+		// Links elsewhere may hold copies of the object, which are removed
+		// while the backlinks that find them remain.
+		if err := t.refreshLinkCopies(key, nil, o); err != nil {
+			return removed, err
+		}
+	}
+	if err := t.batchWrite(writeRequests, o); err != nil {
+		return removed, err
+	}
+	return removed + len(writeRequests), nil
+}
+
+// purgeRequest returns the request that removes the row with the given Key
+// and Child.
+func purgeRequest(key, child string) *dynamodb.WriteRequest {
+	return &dynamodb.WriteRequest{
+		DeleteRequest: &dynamodb.DeleteRequest{
+			Key: map[string]*dynamodb.AttributeValue{
+				"Key":   &dynamodb.AttributeValue{S: aws.String(key)},
+				"Child": &dynamodb.AttributeValue{S: aws.String(child)},
+			},
+		},
+	}
+}
@@ -0,0 +1,90 @@
+package dynamotree
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestDestroyNamespace(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db, KeepVersions: true, MaintainBacklinks: true}
+	c.Assert(s.CreateTable(), IsNil)
+	v := AccountT{ID: "12345", Name: "alice"}
+	c.Assert(s.Put([]string{"t1", "Accounts", "12345"}, &v), IsNil)
+	c.Assert(s.Put([]string{"t1", "Accounts", "6789"}, &v), IsNil)
+	c.Assert(s.Delete([]string{"t1", "Accounts", "6789"}), IsNil)
+	c.Assert(s.PutLink([]string{"t1", "Shared"}, []string{"t2", "Accounts", "1"}), IsNil)
+	_, err := s.AppendEvent([]string{"t1", "Accounts", "12345"}, &v)
+	c.Assert(err, IsNil)
+	c.Assert(s.Put([]string{"t2", "Accounts", "1"}, &v), IsNil)
+
+	c.Assert(s.DestroyNamespace("t1"), IsNil)
+	c.Assert(s.DestroyNamespace("t1"), IsNil)
+	pending, err := s.PendingDestructions()
+	c.Assert(err, IsNil)
+	c.Assert(pending, DeepEquals, []string{"t1"})
+	c.Assert(s.Get([]string{"t1", "Accounts", "12345"}, &v), IsNil)
+
+	removed, err := DestroyJob(0).Run(context.Background(), s)
+	c.Assert(err, IsNil)
+	c.Assert(removed > 0, Equals, true)
+
+	// Nothing of t1 remains, not even the versions of the deleted object
+	// or the backlink of its link, while t2 is untouched.
+	output, err := db.Scan(&dynamodb.ScanInput{TableName: aws.String(s.TableName)})
+	c.Assert(err, IsNil)
+	for _, row := range output.Items {
+		pathKey, child := aws.StringValue(row["Key"].S), aws.StringValue(row["Child"].S)
+		c.Assert(strings.Contains(pathKey+child, "t1"), Equals, false, Commentf("%s %s", pathKey, child))
+	}
+	refs, err := s.References([]string{"t2", "Accounts", "1"})
+	c.Assert(err, IsNil)
+	c.Assert(refs, HasLen, 0)
+	c.Assert(s.Get([]string{"t2", "Accounts", "1"}, &v), IsNil)
+	c.Assert(walkKeys(c, s, nil), DeepEquals, [][]string{
+		{"t2"},
+		{"t2", "Accounts"},
+		{"t2", "Accounts", "1"},
+	})
+
+	pending, err = s.PendingDestructions()
+	c.Assert(err, IsNil)
+	c.Assert(pending, HasLen, 0)
+	removed, err = DestroyJob(0).Run(context.Background(), s)
+	c.Assert(err, IsNil)
+	c.Assert(removed, Equals, 0)
+}
+
+func (suite *StoreImplTest) TestDestroyNamespaceGuarded(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+	v := AccountT{ID: "12345", Name: "alice"}
+	c.Assert(s.Put([]string{"t1", "Accounts", "1"}, &v), IsNil)
+	c.Assert(s.Put([]string{"t2", "Audit", "1"}, &v), IsNil)
+	s.WriteGuards = []WriteGuard{
+		{Prefix: []string{"t1"}, Mode: GuardImmutable},
+		{Prefix: []string{"t2", "Audit"}, Mode: GuardAppendOnly},
+	}
+
+	c.Assert(s.DestroyNamespace("t1"), ErrorIs, ErrWriteGuarded)
+	pending, err := s.PendingDestructions()
+	c.Assert(err, IsNil)
+	c.Assert(pending, HasLen, 0)
+
+	// A key below the namespace that is guarded stops the job, which
+	// leaves it and the namespace waiting to be destroyed.
+	c.Assert(s.DestroyNamespace("t2"), IsNil)
+	_, err = DestroyJob(0).Run(context.Background(), s)
+	c.Assert(err, ErrorIs, ErrWriteGuarded)
+	c.Assert(s.Get([]string{"t2", "Audit", "1"}, &v, ConsistentRead()), IsNil)
+	pending, err = s.PendingDestructions()
+	c.Assert(err, IsNil)
+	c.Assert(pending, DeepEquals, []string{"t2"})
+}
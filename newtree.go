@@ -0,0 +1,124 @@
+package dynamotree
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// ConfigError is returned by NewTree and CheckConfig, and by the tree's
+// first operation, when one of the tree's settings cannot be used.
+type ConfigError struct {
+	Field   string
+	Problem string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("dynamotree: Tree.%s cannot be used because %s", e.Field, e.Problem)
+}
+
+// tableNamePattern matches the names that DynamoDB allows for tables.
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,255}$`)
+
+// NewTree returns a tree that stores its data in the table tableName using
+// db, once each of configure has been applied to it and its settings have
+// been checked by CheckConfig, so that a tree that is misconfigured is
+// reported where it is created rather than by its first operation. db may
+// be nil if Endpoint or Region is set by configure, or the region is given
+// by the environment. For example:
+//
+//	tree, err := dynamotree.NewTree("Accounts", db, func(t *dynamotree.Tree) {
+//		t.KeepVersions = true
+//		t.ProtectLinks = true
+//	})
+//
+// NewTree does not contact DynamoDB. Open does, to check that the table
+// exists and can be read.
+func NewTree(tableName string, db *dynamodb.DynamoDB, configure ...func(*Tree)) (*Tree, error) {
+	t := &Tree{TableName: tableName, DB: db}
+	for _, fn := range configure {
+		fn(t)
+	}
+	if err := t.CheckConfig(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// CheckConfig returns a *ConfigError, or for SpecialCharacter a
+// *SpecialCharacterError, describing the first of the tree's settings that
+// cannot be used: a TableName that DynamoDB does not allow, a negative
+// limit or capacity, a LinkAttribute that names a key attribute, a
+// KeySchema or ItemType whose attribute names conflict with each other or
+// with the attributes the tree writes, a RetentionPolicy with an
+// ObjectTTL but no TTLAttribute, a LinkCopyMaxSize without
+// MaintainBacklinks, or a client with no region or endpoint. The tree's
+// first operation makes the same checks, but calling CheckConfig, or
+// creating the tree with NewTree, reports them earlier.
+func (t *Tree) CheckConfig() error {
+	t.initOnce.Do(t.init)
+	if !tableNamePattern.MatchString(t.TableName) {
+		return &ConfigError{Field: "TableName", Problem: fmt.Sprintf(
+			"%q is not 3 to 255 letters, digits, underscores, hyphens and periods", t.TableName)}
+	}
+	if err := t.checkSpecialCharacter(); err != nil {
+		return err
+	}
+	if t.LinkAttribute == "Key" || t.LinkAttribute == "Child" ||
+		t.LinkAttribute == t.KeySchema.hashAttribute() || t.LinkAttribute == t.KeySchema.rangeAttribute() {
+		return &ConfigError{Field: "LinkAttribute", Problem: fmt.Sprintf(
+			"%q is a key attribute of the table", t.LinkAttribute)}
+	}
+	for _, limit := range []struct {
+		field string
+		value int64
+	}{
+		{"MaxKeyDepth", int64(t.MaxKeyDepth)},
+		{"MaxLinkHops", int64(t.MaxLinkHops)},
+		{"LinkCopyMaxSize", int64(t.LinkCopyMaxSize)},
+		{"CoalesceWrites", int64(t.CoalesceWrites)},
+		{"ReadCapacityUnits", t.ReadCapacityUnits},
+		{"WriteCapacityUnits", t.WriteCapacityUnits},
+	} {
+		if limit.value < 0 {
+			return &ConfigError{Field: limit.field, Problem: "it is negative"}
+		}
+	}
+	if t.ItemType != "" && t.KeySchema != nil && t.KeySchema.TypeAttribute != "" {
+		return &ConfigError{Field: "ItemType", Problem: "KeySchema.TypeAttribute is also set"}
+	}
+	if t.ItemType != "" {
+		switch m := t.keySchema(); ItemTypeAttribute {
+		case m.hashAttribute(), m.rangeAttribute():
+			return &ConfigError{Field: "ItemType", Problem: fmt.Sprintf(
+				"its attribute %q is a key attribute", ItemTypeAttribute)}
+		}
+	}
+	if err := t.KeySchema.check(); err != nil {
+		return err
+	}
+	if m := t.KeySchema; m != nil {
+		for _, name := range []string{m.hashAttribute(), m.rangeAttribute()} {
+			if name == t.TTLAttribute || strings.HasPrefix(name, t.SpecialCharacter) {
+				return &ConfigError{Field: "KeySchema", Problem: fmt.Sprintf(
+					"%q is an attribute that the tree writes in its rows", name)}
+			}
+		}
+	}
+	for _, policy := range t.RetentionPolicies {
+		if policy.ObjectTTL > 0 && t.TTLAttribute == "" {
+			return &ConfigError{Field: "RetentionPolicies", Problem: "ObjectTTL is set but TTLAttribute is not"}
+		}
+	}
+	if t.LinkCopyMaxSize > 0 && !t.MaintainBacklinks {
+		return &ConfigError{Field: "LinkCopyMaxSize", Problem: "MaintainBacklinks is not set"}
+	}
+	if aws.StringValue(t.DB.Config.Region) == "" && aws.StringValue(t.DB.Config.Endpoint) == "" {
+		return &ConfigError{Field: "DB", Problem: "its client has no region or endpoint; " +
+			"set Region, or AWS_REGION in the environment"}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package dynamotree
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestNewTree(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s, err := NewTree(uniuri.New(), db, func(t *Tree) {
+		t.ProtectLinks = true
+		t.ReadCapacityUnits = 5
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.ProtectLinks, Equals, true)
+	c.Assert(s.TableDefinition().ReadCapacityUnits, Equals, int64(5))
+	c.Assert(s.TableDefinition().WriteCapacityUnits, Equals, int64(1))
+	c.Assert(s.CreateTable(), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &AccountT{ID: "12345"}), IsNil)
+
+	configError := func(field string, configure func(*Tree)) {
+		_, err := NewTree(uniuri.New(), db, configure)
+		c.Assert(err, FitsTypeOf, &ConfigError{}, Commentf("%s", field))
+		c.Assert(err.(*ConfigError).Field, Equals, field)
+	}
+	configError("TableName", func(t *Tree) { t.TableName = "a" })
+	configError("TableName", func(t *Tree) { t.TableName = "has space" })
+	configError("LinkAttribute", func(t *Tree) { t.LinkAttribute = "Child" })
+	configError("MaxLinkHops", func(t *Tree) { t.MaxLinkHops = -1 })
+	configError("WriteCapacityUnits", func(t *Tree) { t.WriteCapacityUnits = -1 })
+	configError("LinkCopyMaxSize", func(t *Tree) { t.LinkCopyMaxSize = 100 })
+	configError("DB", func(t *Tree) {
+		config := testConfig.Copy()
+		config.Region, config.Endpoint = aws.String(""), nil
+		t.DB = dynamodb.New(session.New(), config)
+	})
+
+	_, err = NewTree(uniuri.New(), db, func(t *Tree) { t.SpecialCharacter = "::" })
+	c.Assert(err, FitsTypeOf, &SpecialCharacterError{})
+	_, err = NewTree(uniuri.New(), db, func(t *Tree) { t.MaxKeyDepth = -1 })
+	c.Assert(err, ErrorMatches, `dynamotree: Tree.MaxKeyDepth cannot be used because it is negative`)
+
+	// A tree configured without NewTree reports the same error from its
+	// first operation.
+	s = &Tree{TableName: "a", DB: db}
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &AccountT{ID: "12345"}), ErrorMatches,
+		`.*Tree.TableName cannot be used because "a" is not 3 to 255 .*`)
+}
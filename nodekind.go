@@ -0,0 +1,310 @@
+package dynamotree
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// NodeKind identifies what is stored at a key.
+type NodeKind int
+
+// The kinds of node found at a key.
+const (
+	// NodeMissing is a key at and below which nothing is stored.
+	NodeMissing NodeKind = iota
+
+	// NodeItem is a key at which an object is stored, as read by Get.
+	NodeItem
+
+	// NodeLink is a key at which a symbolic link is stored, as read by
+	// GetLink.
+	NodeLink
+
+	// NodeDirectory is a key at which nothing is stored, but which is
+	// listed because other keys are stored below it.
+	NodeDirectory
+)
+
+func (k NodeKind) String() string {
+	switch k {
+	case NodeMissing:
+		return "missing"
+	case NodeItem:
+		return "item"
+	case NodeLink:
+		return "link"
+	case NodeDirectory:
+		return "directory"
+	}
+	return "unknown"
+}
+
+// NodeInfo describes the node at Key, as returned by Stat, ListInfo and
+// WalkInfo. Links are not followed.
+type NodeInfo struct {
+	Key  []string
+	Kind NodeKind
+
+	// LinkTarget is the target of the link, if Kind is NodeLink.
+	LinkTarget []string
+
+	// HasChildren is true if other keys are stored below Key, as is always
+	// the case for NodeDirectory. An item or link may have children too.
+	// ListInfo does not read the children of each key, so it sets
+	// HasChildren only for NodeDirectory.
+	HasChildren bool
+}
+
+// Kind returns NodeMissing.
+func (e *NotFoundError) Kind() NodeKind { return NodeMissing }
+
+// Kind returns NodeDirectory.
+func (e *IsDirectoryError) Kind() NodeKind { return NodeDirectory }
+
+// Kind returns NodeLink.
+func (e *IsLinkError) Kind() NodeKind { return NodeLink }
+
+// Kind returns NodeItem, as the key holds an object rather than a link.
+func (e *NotLinkError) Kind() NodeKind { return NodeItem }
+
+// ErrorKind returns the kind of node found at the key of err, if err is or
+// wraps a NotFoundError, IsDirectoryError, IsLinkError or NotLinkError, or
+// false otherwise.
+func ErrorKind(err error) (NodeKind, bool) {
+	var kindErr interface{ Kind() NodeKind }
+	if !errors.As(err, &kindErr) {
+		return NodeMissing, false
+	}
+	return kindErr.Kind(), true
+}
+
+// Stat returns what is stored at key, without following links. If nothing
+// is stored at or below key, Stat returns a NodeInfo whose Kind is
+// NodeMissing rather than an error. Stat reads the row at key and queries
+// its directory, so it costs two requests.
+func (t *Tree) Stat(key []string, opts ...Option) (info *NodeInfo, err error) {
+	defer annotateError(&err, "Stat", key)
+	o, cancel := t.startCall("Stat", key, &err, opts)
+	defer cancel()
+	if err := t.ready(); err != nil {
+		return nil, err
+	}
+	if err := t.checkCapability("Stat", key, o); err != nil {
+		return nil, err
+	}
+	key, err = t.transformKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.ValidateKey(key); err != nil {
+		return nil, err
+	}
+	row, err := t.getRow(t.EncodeKey(key), o)
+	if err != nil {
+		return nil, err
+	}
+	hasChildren, err := t.hasChildren(t.dirKey(key), o)
+	if err != nil {
+		return nil, err
+	}
+	info = t.nodeInfo(key, row)
+	info.HasChildren = hasChildren
+	if info.Kind == NodeDirectory && !hasChildren {
+		info.Kind = NodeMissing
+	}
+	return info, nil
+}
+
+// nodeInfo returns the NodeInfo of key, whose row is row. A nil row is
+// assumed to be that of a key listed in its directory.
+func (t *Tree) nodeInfo(key []string, row map[string]*dynamodb.AttributeValue) *NodeInfo {
+	if row == nil {
+		return &NodeInfo{Key: key, Kind: NodeDirectory, HasChildren: true}
+	}
+	if linkTarget, ok := t.linkTarget(row); ok {
+		return &NodeInfo{Key: key, Kind: NodeLink, LinkTarget: t.DecodeKey(linkTarget)}
+	}
+	return &NodeInfo{Key: key, Kind: NodeItem}
+}
+
+// ListInfo enumerates the immediate children of keyPrefix as List does,
+// calling itemFunc with a NodeInfo for each. The rows of the children are
+// read using BatchGetItem, 100 at a time, so ListInfo costs roughly one
+// more request per 100 children than List. A child at which nothing is
+// stored is reported as NodeDirectory, as it is listed only because keys
+// are stored below it.
+func (t *Tree) ListInfo(keyPrefix []string, itemFunc func(*NodeInfo, error) bool, opts ...Option) {
+	fn, prefix := itemFunc, keyPrefix
+	itemFunc = func(info *NodeInfo, err error) bool { return fn(info, wrapError("ListInfo", prefix, err)) }
+	o, cancel := newCallOptions(opts)
+	defer cancel()
+	if err := refuseCapability("ListInfo", keyPrefix, o); err != nil {
+		itemFunc(nil, err)
+		return
+	}
+	if err := t.ready(); err != nil {
+		itemFunc(nil, err)
+		return
+	}
+	keyPrefix, err := t.transformKey(keyPrefix)
+	if err != nil {
+		itemFunc(nil, err)
+		return
+	}
+	keys, err := t.childKeys(keyPrefix, o)
+	if err != nil {
+		itemFunc(nil, err)
+		return
+	}
+	infos, err := t.statKeys(keys, o)
+	if err != nil {
+		itemFunc(nil, err)
+		return
+	}
+	for _, info := range infos {
+		if !itemFunc(info, nil) {
+			return
+		}
+	}
+}
+
+// WalkInfo enumerates every key below prefix as Walk does, calling
+// walkFunc with a NodeInfo for each. As Walk lists each key to find its
+// descendants, the HasChildren of each NodeInfo is set, and WalkInfo costs
+// only the BatchGetItem requests that ListInfo adds to List.
+func (t *Tree) WalkInfo(prefix []string, walkFunc func(*NodeInfo, error) bool, opts ...Option) {
+	fn, keyPrefix := walkFunc, prefix
+	walkFunc = func(info *NodeInfo, err error) bool { return fn(info, wrapError("WalkInfo", keyPrefix, err)) }
+	o, cancel := newCallOptions(opts)
+	defer cancel()
+	o.list = listBudget{} // the limits of List do not apply to each directory
+	if err := refuseCapability("WalkInfo", prefix, o); err != nil {
+		walkFunc(nil, err)
+		return
+	}
+	if err := t.ready(); err != nil {
+		walkFunc(nil, err)
+		return
+	}
+	prefix, err := t.transformKey(prefix)
+	if err != nil {
+		walkFunc(nil, err)
+		return
+	}
+	keys, err := t.childKeys(prefix, o)
+	if err != nil {
+		walkFunc(nil, err)
+		return
+	}
+	if _, err := t.walkInfo(keys, walkFunc, o); err != nil {
+		walkFunc(nil, err)
+	}
+}
+
+// walkInfo visits keys, which are the children of a directory, and their
+// descendants, returning false if walkFunc asked to stop.
+func (t *Tree) walkInfo(keys [][]string, walkFunc func(*NodeInfo, error) bool, o *callOptions) (bool, error) {
+	infos, err := t.statKeys(keys, o)
+	if err != nil {
+		return false, err
+	}
+	for _, info := range infos {
+		children, err := t.childKeys(info.Key, o)
+		if err != nil {
+			return false, err
+		}
+		info.HasChildren = len(children) > 0
+		if info.Kind == NodeDirectory && !info.HasChildren {
+			info.Kind = NodeMissing
+		}
+		if !walkFunc(info, nil) {
+			return false, nil
+		}
+		if more, err := t.walkInfo(children, walkFunc, o); err != nil || !more {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// childKeys returns the keys of the immediate children of prefix.
+func (t *Tree) childKeys(prefix []string, o *callOptions) ([][]string, error) {
+	keys := [][]string{}
+	var err error
+	t.list(prefix, func(child string, innerErr error) bool {
+		if innerErr != nil {
+			err = innerErr
+			return false
+		}
+		key := make([]string, len(prefix), len(prefix)+1)
+		copy(key, prefix)
+		keys = append(keys, append(key, child))
+		return true
+	}, o)
+	return keys, err
+}
+
+// statKeys returns the NodeInfo of each of keys, reading only the
+// attributes that identify links.
+func (t *Tree) statKeys(keys [][]string, o *callOptions) ([]*NodeInfo, error) {
+	infos := make([]*NodeInfo, len(keys))
+	if len(t.legacySchema) > 0 {
+		// The table is being migrated, so each row may be in an older
+		// layout.
+		for i, key := range keys {
+			row, err := t.getRow(t.EncodeKey(key), o)
+			if err != nil {
+				return nil, err
+			}
+			infos[i] = t.nodeInfo(key, row)
+		}
+		return infos, nil
+	}
+
+	names := map[string]*string{"#K": aws.String("Key"), "#L": aws.String(t.LinkAttribute)}
+	projection := "#K, #L"
+	if t.LinkAttribute != t.SpecialCharacter {
+		names["#S"] = aws.String(t.SpecialCharacter)
+		projection += ", #S"
+	}
+	rows := map[string]map[string]*dynamodb.AttributeValue{}
+	for start := 0; start < len(keys); start += maxBatchGetKeys {
+		end := start + maxBatchGetKeys
+		if end > len(keys) {
+			end = len(keys)
+		}
+		requestKeys := make([]map[string]*dynamodb.AttributeValue, 0, end-start)
+		for _, key := range keys[start:end] {
+			requestKeys = append(requestKeys, map[string]*dynamodb.AttributeValue{
+				"Key":   &dynamodb.AttributeValue{S: aws.String(t.EncodeKey(key))},
+				"Child": &dynamodb.AttributeValue{S: aws.String(t.SpecialCharacter)},
+			})
+		}
+		requestItems := map[string]*dynamodb.KeysAndAttributes{
+			t.TableName: &dynamodb.KeysAndAttributes{
+				Keys:                     requestKeys,
+				ConsistentRead:           o.consistent(),
+				ProjectionExpression:     aws.String(projection),
+				ExpressionAttributeNames: names,
+			},
+		}
+		for len(requestItems) > 0 {
+			output, err := t.DB.BatchGetItemWithContext(o.context(), &dynamodb.BatchGetItemInput{
+				RequestItems: requestItems,
+			}, o.request()...)
+			if err != nil {
+				return nil, err
+			}
+			for _, row := range output.Responses[t.TableName] {
+				rows[aws.StringValue(row["Key"].S)] = row
+			}
+			requestItems = output.UnprocessedKeys
+		}
+	}
+	for i, key := range keys {
+		infos[i] = t.nodeInfo(key, rows[t.EncodeKey(key)])
+	}
+	return infos, nil
+}
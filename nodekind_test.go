@@ -0,0 +1,84 @@
+package dynamotree
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestNodeKind(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db, ReportDirectories: true}
+	c.Assert(s.CreateTable(), IsNil)
+
+	v := AccountT{ID: "12345", Name: "alice"}
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &v), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "12345", "Links", "a"}, &v), IsNil)
+	c.Assert(s.PutLink([]string{"Accounts", "alice"}, []string{"Accounts", "12345"}), IsNil)
+
+	info, err := s.Stat([]string{"Accounts", "12345"})
+	c.Assert(err, IsNil)
+	c.Assert(info, DeepEquals, &NodeInfo{Key: []string{"Accounts", "12345"}, Kind: NodeItem, HasChildren: true})
+	info, err = s.Stat([]string{"Accounts", "alice"})
+	c.Assert(err, IsNil)
+	c.Assert(info, DeepEquals, &NodeInfo{Key: []string{"Accounts", "alice"}, Kind: NodeLink,
+		LinkTarget: []string{"Accounts", "12345"}})
+	info, err = s.Stat([]string{"Accounts"})
+	c.Assert(err, IsNil)
+	c.Assert(info.Kind, Equals, NodeDirectory)
+	c.Assert(info.HasChildren, Equals, true)
+	info, err = s.Stat([]string{"Accounts", "bob"})
+	c.Assert(err, IsNil)
+	c.Assert(info.Kind, Equals, NodeMissing)
+	c.Assert(fmt.Sprint(info.Kind), Equals, "missing")
+
+	// The errors of Get and GetLink report the kind of node they found.
+	err = s.Get([]string{"Accounts"}, &v)
+	kind, ok := ErrorKind(err)
+	c.Assert(ok, Equals, true)
+	c.Assert(kind, Equals, NodeDirectory)
+	_, err = s.GetLink([]string{"Accounts", "12345"})
+	kind, ok = ErrorKind(err)
+	c.Assert(ok, Equals, true)
+	c.Assert(kind, Equals, NodeItem)
+	err = s.Get([]string{"Accounts", "bob"}, &v)
+	kind, ok = ErrorKind(err)
+	c.Assert(ok, Equals, true)
+	c.Assert(kind, Equals, NodeMissing)
+	_, ok = ErrorKind(fmt.Errorf("other"))
+	c.Assert(ok, Equals, false)
+
+	kinds := map[string]NodeKind{}
+	s.ListInfo([]string{"Accounts"}, func(info *NodeInfo, err error) bool {
+		c.Assert(err, IsNil)
+		kinds[info.Key[len(info.Key)-1]] = info.Kind
+		return true
+	})
+	c.Assert(kinds, DeepEquals, map[string]NodeKind{"12345": NodeItem, "alice": NodeLink})
+
+	infos := []NodeInfo{}
+	s.WalkInfo(nil, func(info *NodeInfo, err error) bool {
+		c.Assert(err, IsNil)
+		infos = append(infos, *info)
+		return true
+	})
+	c.Assert(infos, DeepEquals, []NodeInfo{
+		{Key: []string{"Accounts"}, Kind: NodeDirectory, HasChildren: true},
+		{Key: []string{"Accounts", "12345"}, Kind: NodeItem, HasChildren: true},
+		{Key: []string{"Accounts", "12345", "Links"}, Kind: NodeDirectory, HasChildren: true},
+		{Key: []string{"Accounts", "12345", "Links", "a"}, Kind: NodeItem},
+		{Key: []string{"Accounts", "alice"}, Kind: NodeLink, LinkTarget: []string{"Accounts", "12345"}},
+	})
+
+	count := 0
+	s.WalkInfo(nil, func(info *NodeInfo, err error) bool {
+		c.Assert(err, IsNil)
+		count++
+		return count < 2
+	})
+	c.Assert(count, Equals, 2)
+}
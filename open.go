@@ -0,0 +1,114 @@
+package dynamotree
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// WarmConnections causes Open to make n requests at once, rather than one,
+// so that the HTTP client is left with n connections to DynamoDB ready for
+// use. The client keeps no more idle connections than its transport's
+// MaxIdleConnsPerHost allows, which for http.DefaultTransport is 2.
+func WarmConnections(n int) Option {
+	return func(o *callOptions) {
+		o.warmConnections = n
+	}
+}
+
+// KeepAlive causes Open to read the table's metadata row every interval,
+// until the context given to Open is done or the tree is closed, so that
+// the connections it establishes are not closed as idle, and the
+// credentials are refreshed before they expire rather than when a request
+// needs them.
+func KeepAlive(interval time.Duration) Option {
+	return func(o *callOptions) {
+		o.keepAlive = interval
+	}
+}
+
+// Open prepares the tree for use: it retrieves the tree's credentials,
+// connects to DynamoDB, which resolves its endpoint, and makes the checks
+// that the tree otherwise makes before its first operation, so that they
+// are reported by Open and their latency is not added to the first
+// request. If the table does not exist, or the credentials do not allow
+// the table to be read, Open returns an error.
+//
+// Open need not be called, but is worthwhile where latency matters from
+// the first request. In AWS Lambda, for example, it should be called
+// while the function initializes, outside of the handler, so that the
+// connections it opens are reused by each invocation of the handler.
+func (t *Tree) Open(ctx context.Context, opts ...Option) (err error) {
+	defer annotateError(&err, "Open", nil)
+	o, cancel := newCallOptions(append([]Option{WithContext(ctx)}, opts...))
+	defer cancel()
+	if err := refuseCapability("Open", nil, o); err != nil {
+		return err
+	}
+	t.initOnce.Do(t.init)
+	if creds := t.DB.Config.Credentials; creds != nil {
+		if _, err := creds.GetWithContext(o.context()); err != nil {
+			return err
+		}
+	}
+
+	n := o.warmConnections
+	if n < 1 {
+		n = 1
+	}
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			errs <- t.ping(o)
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := t.ready(); err != nil {
+		return err
+	}
+
+	if o.keepAlive > 0 {
+		closing, done := t.background()
+		go func() {
+			defer done()
+			t.keepAlive(o.keepAlive, &callOptions{ctx: ctx, requestOptions: o.requestOptions}, closing)
+		}()
+	}
+	return nil
+}
+
+// ping reads the table's metadata row.
+func (t *Tree) ping(o *callOptions) error {
+	_, err := t.DB.GetItemWithContext(o.context(), &dynamodb.GetItemInput{
+		TableName: aws.String(t.TableName),
+		Key:       t.metadataRowKey(),
+	}, o.request()...)
+	return err
+}
+
+// keepAlive pings the table every interval until the context of o is
+// done or closing is closed. Failures are ignored, since the operations
+// that follow report them.
+func (t *Tree) keepAlive(interval time.Duration, o *callOptions, closing <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-o.context().Done():
+			return
+		case <-closing:
+			return
+		case <-ticker.C:
+		}
+		t.ping(o)
+	}
+}
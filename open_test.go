@@ -0,0 +1,36 @@
+package dynamotree
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestOpen(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	tableName := uniuri.New()
+	c.Assert((&Tree{TableName: tableName, DB: db}).CreateTable(), IsNil)
+
+	transport := &countingTransport{}
+	s := &Tree{TableName: tableName, DB: db, HTTPClient: &http.Client{Transport: transport}}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.Assert(s.Open(ctx, WarmConnections(3), KeepAlive(10*time.Millisecond)), IsNil)
+	opened := atomic.LoadInt64(&transport.requests)
+	c.Assert(opened >= 3, Equals, true)
+	time.Sleep(50 * time.Millisecond)
+	c.Assert(atomic.LoadInt64(&transport.requests) > opened, Equals, true)
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	stopped := atomic.LoadInt64(&transport.requests)
+	time.Sleep(30 * time.Millisecond)
+	c.Assert(atomic.LoadInt64(&transport.requests), Equals, stopped)
+
+	err := (&Tree{TableName: uniuri.New(), DB: db}).Open(context.Background())
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,536 @@
+package dynamotree
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// Option configures a single call to one of the tree's methods, for
+// example:
+//
+//	tree.Get(key, &item, dynamotree.ConsistentRead(), dynamotree.WithTimeout(time.Second))
+//
+// Options that do not apply to a method, such as WithCondition given to
+// Get, are ignored.
+type Option func(*callOptions)
+
+// WriteOption is the name by which Option was known when it applied only
+// to Put, PutLink and Delete.
+type WriteOption = Option
+
+type callOptions struct {
+	ctx            aws.Context
+	timeout        time.Duration
+	tag            string
+	requestOptions []request.Option
+	consistentRead bool
+	atomicLinks    bool
+	condition      *expression.ConditionBuilder
+
+	oldItem      Storable
+	oldItemFound *bool
+	leafFirst    bool
+	replaceLink  bool
+	flat         bool
+	rollBack     bool
+	admin        bool
+
+	warmConnections int
+	keepAlive       time.Duration
+
+	failIfReferenced bool
+	writeRate        float64
+	redact           bool
+	dryRun           bool
+
+	progress      func(Progress)
+	expectedItems int
+	checkpoint    string
+
+	consumedCapacity *float64
+	queryStats       *QueryStats
+	writeCounts      *WriteCounts
+	statsMu          sync.Mutex
+
+	splitDeadline bool
+	budget        budget
+	listVerified  bool
+	capability    *string
+	capabilityKey []string
+
+	list listBudget
+}
+
+// listBudget holds the options that limit a single call to List.
+type listBudget struct {
+	maxItems   int
+	capacity   float64
+	startAfter string
+	cursor     *string
+}
+
+// newCallOptions applies opts. The returned function releases the
+// resources of the call's context and must be called when the call is
+// complete.
+func newCallOptions(opts []Option) (*callOptions, func()) {
+	o := &callOptions{ctx: context.Background()}
+	for _, opt := range opts {
+		opt(o)
+	}
+	cancel := func() {}
+	if o.timeout > 0 {
+		var ctx context.Context
+		ctx, cancel = context.WithTimeout(o.ctx, o.timeout)
+		o.ctx = ctx
+	}
+	if o.tag == "" {
+		o.tag, _ = o.ctx.Value(requestTagKey{}).(string)
+	}
+	if o.tag != "" {
+		o.requestOptions = append(o.requestOptions, o.setRequestTag)
+	}
+	if o.consumedCapacity != nil {
+		o.requestOptions = append(o.requestOptions, o.countConsumedCapacity)
+	}
+	if o.queryStats != nil {
+		o.requestOptions = append(o.requestOptions, o.countQueryStats)
+	}
+	if o.splitDeadline {
+		o.requestOptions = append(o.requestOptions, o.shareDeadline)
+	}
+	return o, cancel
+}
+
+// context returns the context in which requests are made. o may be nil,
+// as it is for requests made on behalf of methods that take no options.
+func (o *callOptions) context() aws.Context {
+	if o == nil {
+		return context.Background()
+	}
+	return o.ctx
+}
+
+// request returns the options of each request made to DynamoDB.
+func (o *callOptions) request() []request.Option {
+	if o == nil {
+		return []request.Option{classifyThrottling}
+	}
+	return append([]request.Option{classifyThrottling}, o.requestOptions...)
+}
+
+// classifyThrottling is a request.Option that replaces the error of a
+// request that was throttled, once it will no longer be retried, with a
+// *ThrottledError suggesting how long to wait before trying again.
+func classifyThrottling(r *request.Request) {
+	r.Handlers.AfterRetry.PushBack(func(r *request.Request) {
+		if r.Error == nil || r.WillRetry() || !isThrottling(r.Error) {
+			return
+		}
+		r.Error = &ThrottledError{Err: r.Error, RetryAfter: r.RetryRules(r)}
+	})
+}
+
+// listBudget returns the limits of a call to List.
+func (o *callOptions) listBudget() listBudget {
+	if o == nil {
+		return listBudget{}
+	}
+	return o.list
+}
+
+// consistent returns true if reads should be strongly consistent.
+func (o *callOptions) consistent() *bool {
+	if o == nil || !o.consistentRead {
+		return nil
+	}
+	return aws.Bool(true)
+}
+
+// WithContext causes the requests made by a call to use ctx, so that they
+// can be cancelled, and so that they carry the values, such as tracing
+// spans, that ctx holds.
+func WithContext(ctx context.Context) Option {
+	return func(o *callOptions) {
+		o.ctx = ctx
+	}
+}
+
+// WithTimeout limits the time a call may take, including any retries, to
+// d. If the limit is reached, the call returns an error.
+func WithTimeout(d time.Duration) Option {
+	return func(o *callOptions) {
+		o.timeout = d
+	}
+}
+
+// RequestTagHeader is the HTTP header in which the tag given using
+// WithRequestTag is sent with each request. The tag therefore appears
+// wherever requests are logged or traced, for example by the SDK when
+// aws.LogDebugWithHTTPBody is enabled.
+const RequestTagHeader = "X-Dynamotree-Request-Tag"
+
+// WithRequestTag attaches tag, such as the ID of the user request being
+// served, to each request that a call makes, so that the requests can be
+// correlated with it.
+func WithRequestTag(tag string) Option {
+	return func(o *callOptions) {
+		o.tag = tag
+	}
+}
+
+type requestTagKey struct{}
+
+// ContextWithRequestTag returns a copy of ctx that carries tag. Calls given
+// the context using WithContext attach tag to their requests as though
+// WithRequestTag had been given, so that a tag set once for a user request
+// applies to every call made while serving it.
+func ContextWithRequestTag(ctx context.Context, tag string) context.Context {
+	return context.WithValue(ctx, requestTagKey{}, tag)
+}
+
+// setRequestTag is a request.Option that sends o.tag with a request.
+func (o *callOptions) setRequestTag(r *request.Request) {
+	r.HTTPRequest.Header.Set(RequestTagHeader, o.tag)
+}
+
+// WithRequestOptions applies opts to each request that a call makes to
+// DynamoDB, for example to add headers or handlers that record tracing
+// attributes.
+func WithRequestOptions(opts ...request.Option) Option {
+	return func(o *callOptions) {
+		o.requestOptions = append(o.requestOptions, opts...)
+	}
+}
+
+// ConsistentRead causes a call to use strongly consistent reads. When Get
+// follows symbolic links, each link and the object it leads to are read
+// with strongly consistent reads, but they are read one after another, so
+// a link may be changed after it is read and before its target is; see
+// ResolveLinksAtomically.
+func ConsistentRead() Option {
+	return func(o *callOptions) {
+		o.consistentRead = true
+	}
+}
+
+// DryRun causes GC to report the garbage it finds without removing it.
+func DryRun() Option {
+	return func(o *callOptions) {
+		o.dryRun = true
+	}
+}
+
+// ResolveLinksAtomically causes Get, when the key is a symbolic link, to
+// read the links it follows and the object they lead to in a single
+// TransactGetItems request, so that the object returned is the one the
+// links referred to at one point in time. Get first follows the links
+// using strongly consistent reads, then reads the rows it found together,
+// and if a link was changed in the meantime it follows the links again
+// from the rows read together. Each transaction consumes twice the
+// capacity of the reads it makes.
+//
+// While the table is being migrated to a newer schema, rows may not be
+// stored where the transaction would read them, so links are followed
+// using strongly consistent reads instead.
+func ResolveLinksAtomically() Option {
+	return func(o *callOptions) {
+		o.consistentRead = true
+		o.atomicLinks = true
+	}
+}
+
+// WithCondition causes a write to succeed only if cond is true of the row
+// currently stored at the key, for example:
+//
+//	tree.Put(key, item, dynamotree.WithCondition(
+//	    expression.Name("Status").Equal(expression.Value("PENDING"))))
+//
+// If the condition is not met, the write returns ErrConditionFailed and
+// the tree is not modified. If WithCondition is given more than once, all
+// of the conditions must be met.
+func WithCondition(cond expression.ConditionBuilder) Option {
+	return func(o *callOptions) {
+		c := cond
+		if o.condition != nil {
+			c = o.condition.And(cond)
+		}
+		o.condition = &c
+	}
+}
+
+// ReturnOldItem causes Put, PutLink or Delete to fill in ob with the item
+// stored at the key before it was written. If found is not nil, it is set
+// to whether there was such an item; if there was not, ob is not
+// modified. The old row of a link is returned as it is stored.
+func ReturnOldItem(ob Storable, found *bool) Option {
+	return func(o *callOptions) {
+		o.oldItem = ob
+		o.oldItemFound = found
+	}
+}
+
+// ReplaceLink causes Put to replace a symbolic link stored at the key with
+// the object even if Tree.ProtectLinks is set.
+func ReplaceLink() Option {
+	return func(o *callOptions) {
+		o.replaceLink = true
+	}
+}
+
+// WriteLeafFirst causes Put or PutLink to write the row of the object (or
+// link) before its directory entries, so that if the write fails part way
+// through, no directory entry is left naming a key that does not exist.
+// The object may instead be stored but not yet listed by List or Walk
+// until the write is retried.
+func WriteLeafFirst() Option {
+	return func(o *callOptions) {
+		o.leafFirst = true
+	}
+}
+
+// RollBackOnFailure causes Put or PutLink to write the row of the object
+// (or link) after its directory entries and, if the write fails, to
+// remove the directory entries that nothing is stored at or below, so
+// that a failed write leaves no entry naming a key that does not exist.
+// The *MultiRowError returned lists the rows removed in RolledBack. If the
+// write fails because of WithCondition, the *ConditionFailedError is
+// returned once the entries have been removed.
+//
+// RollBackOnFailure takes precedence over WriteLeafFirst, and is ignored
+// by Delete. Like GC, the rollback is not atomic with respect to
+// concurrent writers below the same key.
+func RollBackOnFailure() Option {
+	return func(o *callOptions) {
+		o.rollBack = true
+	}
+}
+
+// ReturnConsumedCapacity causes a call to add the capacity units consumed
+// by each of the requests it makes to *total.
+func ReturnConsumedCapacity(total *float64) Option {
+	return func(o *callOptions) {
+		o.consumedCapacity = total
+	}
+}
+
+// QueryStats describes the Query and Scan requests made by a call, such as
+// one to List or Walk. Comparing ScannedCount with Count shows how much of
+// what was read was discarded by filters, as by Find.
+type QueryStats struct {
+	// Pages is the number of requests made.
+	Pages int
+
+	// ScannedCount is the number of items read, before any filter was
+	// applied.
+	ScannedCount int64
+
+	// Count is the number of items returned.
+	Count int64
+
+	// CapacityUnits is the read capacity consumed.
+	CapacityUnits float64
+
+	// LastEvaluatedKey is the LastEvaluatedKey of the last page read, which
+	// is nil if the last page read was the last page of its results.
+	LastEvaluatedKey map[string]*dynamodb.AttributeValue
+}
+
+// ReturnQueryStats causes a call to add the statistics of each Query and
+// Scan request it makes to *stats.
+func ReturnQueryStats(stats *QueryStats) Option {
+	return func(o *callOptions) {
+		o.queryStats = stats
+	}
+}
+
+// countQueryStats is a request.Option that adds the statistics of a Query
+// or Scan request to o.queryStats.
+func (o *callOptions) countQueryStats(r *request.Request) {
+	total := aws.String(dynamodb.ReturnConsumedCapacityTotal)
+	switch input := r.Params.(type) {
+	case *dynamodb.QueryInput:
+		input.ReturnConsumedCapacity = total
+	case *dynamodb.ScanInput:
+		input.ReturnConsumedCapacity = total
+	default:
+		return
+	}
+
+	r.Handlers.Complete.PushBack(func(r *request.Request) {
+		if r.Error != nil {
+			return
+		}
+		o.statsMu.Lock()
+		defer o.statsMu.Unlock()
+		stats := o.queryStats
+		var capacity *dynamodb.ConsumedCapacity
+		switch output := r.Data.(type) {
+		case *dynamodb.QueryOutput:
+			stats.ScannedCount += aws.Int64Value(output.ScannedCount)
+			stats.Count += aws.Int64Value(output.Count)
+			stats.LastEvaluatedKey = output.LastEvaluatedKey
+			capacity = output.ConsumedCapacity
+		case *dynamodb.ScanOutput:
+			stats.ScannedCount += aws.Int64Value(output.ScannedCount)
+			stats.Count += aws.Int64Value(output.Count)
+			stats.LastEvaluatedKey = output.LastEvaluatedKey
+			capacity = output.ConsumedCapacity
+		default:
+			return
+		}
+		stats.Pages++
+		if capacity != nil {
+			stats.CapacityUnits += aws.Float64Value(capacity.CapacityUnits)
+		}
+	})
+}
+
+// RetryThrottledReads causes the reads made by a call to be retried up to
+// maxRetries times if they are throttled, waiting between attempts for an
+// exponentially increasing delay of at most maxBackoff. It replaces the
+// retry policy of the DB client for those requests. Writes are retried
+// according to the client's policy, as they may not be safe to repeat.
+func RetryThrottledReads(maxRetries int, maxBackoff time.Duration) Option {
+	retryer := client.DefaultRetryer{
+		NumMaxRetries:    maxRetries,
+		MinRetryDelay:    maxBackoff / 64,
+		MinThrottleDelay: maxBackoff / 64,
+		MaxRetryDelay:    maxBackoff,
+		MaxThrottleDelay: maxBackoff,
+	}
+	return WithRequestOptions(func(r *request.Request) {
+		switch r.Operation.Name {
+		case "GetItem", "BatchGetItem", "Query", "Scan":
+			r.Retryer = retryer
+		}
+	})
+}
+
+// MaxItems causes List to stop once it has returned n items.
+func MaxItems(n int) Option {
+	return func(o *callOptions) {
+		o.list.maxItems = n
+	}
+}
+
+// CapacityBudget causes List to stop once the pages it has read have
+// consumed at least units read capacity units, so that a background job
+// enumerating a large directory can pace itself rather than starving
+// other traffic on the table. Because the budget is checked after each
+// page, List may exceed it by up to the capacity of one page.
+func CapacityBudget(units float64) Option {
+	return func(o *callOptions) {
+		o.list.capacity = units
+	}
+}
+
+// ReturnCursor causes List to set *cursor to a value that can be passed
+// to StartAfter to resume the listing where it stopped, whether because
+// of MaxItems, CapacityBudget or because itemFunc returned false. If List
+// enumerated every item, *cursor is set to the empty string. A cursor
+// returned when List stopped at its last item resumes a listing that
+// returns nothing.
+func ReturnCursor(cursor *string) Option {
+	return func(o *callOptions) {
+		o.list.cursor = cursor
+	}
+}
+
+// StartAfter causes List to resume from a cursor returned using
+// ReturnCursor by an earlier call with the same prefix. An empty cursor
+// starts from the beginning.
+func StartAfter(cursor string) Option {
+	return func(o *callOptions) {
+		o.list.startAfter = cursor
+	}
+}
+
+// encodeCursor returns the cursor that resumes a listing after the child
+// whose stored name is child.
+func encodeCursor(child string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(child))
+}
+
+// decodeCursor returns the stored name of the child that cursor resumes
+// after.
+func decodeCursor(cursor string) (string, error) {
+	child, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(child) == 0 {
+		return "", fmt.Errorf("invalid cursor %q", cursor)
+	}
+	return string(child), nil
+}
+
+// countConsumedCapacity is a request.Option that asks DynamoDB to report
+// the capacity consumed by a request, and adds it to o.consumedCapacity.
+func (o *callOptions) countConsumedCapacity(r *request.Request) {
+	total := aws.String(dynamodb.ReturnConsumedCapacityTotal)
+	switch input := r.Params.(type) {
+	case *dynamodb.GetItemInput:
+		input.ReturnConsumedCapacity = total
+	case *dynamodb.PutItemInput:
+		input.ReturnConsumedCapacity = total
+	case *dynamodb.DeleteItemInput:
+		input.ReturnConsumedCapacity = total
+	case *dynamodb.UpdateItemInput:
+		input.ReturnConsumedCapacity = total
+	case *dynamodb.QueryInput:
+		input.ReturnConsumedCapacity = total
+	case *dynamodb.ScanInput:
+		input.ReturnConsumedCapacity = total
+	case *dynamodb.BatchWriteItemInput:
+		input.ReturnConsumedCapacity = total
+	case *dynamodb.BatchGetItemInput:
+		input.ReturnConsumedCapacity = total
+	case *dynamodb.TransactGetItemsInput:
+		input.ReturnConsumedCapacity = total
+	}
+
+	r.Handlers.Complete.PushBack(func(r *request.Request) {
+		if r.Error != nil {
+			return
+		}
+		o.statsMu.Lock()
+		defer o.statsMu.Unlock()
+		add := func(c *dynamodb.ConsumedCapacity) {
+			if c != nil {
+				*o.consumedCapacity += aws.Float64Value(c.CapacityUnits)
+			}
+		}
+		switch output := r.Data.(type) {
+		case *dynamodb.GetItemOutput:
+			add(output.ConsumedCapacity)
+		case *dynamodb.PutItemOutput:
+			add(output.ConsumedCapacity)
+		case *dynamodb.DeleteItemOutput:
+			add(output.ConsumedCapacity)
+		case *dynamodb.UpdateItemOutput:
+			add(output.ConsumedCapacity)
+		case *dynamodb.QueryOutput:
+			add(output.ConsumedCapacity)
+		case *dynamodb.ScanOutput:
+			add(output.ConsumedCapacity)
+		case *dynamodb.BatchWriteItemOutput:
+			for _, c := range output.ConsumedCapacity {
+				add(c)
+			}
+		case *dynamodb.BatchGetItemOutput:
+			for _, c := range output.ConsumedCapacity {
+				add(c)
+			}
+		case *dynamodb.TransactGetItemsOutput:
+			for _, c := range output.ConsumedCapacity {
+				add(c)
+			}
+		}
+	})
+}
@@ -0,0 +1,379 @@
+package dynamotree
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestWithCondition(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	err := s.CreateTable()
+	c.Assert(err, IsNil)
+
+	v := AccountT{
+		ID:    "12345",
+		Name:  "alice",
+		Email: "alice@example.com",
+	}
+	key := []string{"Accounts", "12345"}
+	isBob := WithCondition(expression.Name("Name").Equal(expression.Value("bob")))
+	isAlice := WithCondition(expression.Name("Name").Equal(expression.Value("alice")))
+
+	// the condition is evaluated against the (missing) existing object
+	err = s.Put(key, &v, isAlice)
+	c.Assert(err, ErrorIs, ErrConditionFailed)
+	err = s.Get(key, &AccountT{})
+	c.Assert(err, ErrorIs, ErrNotFound)
+	items := []string{}
+	s.List(nil, func(item string, err error) bool {
+		c.Assert(err, IsNil)
+		items = append(items, item)
+		return true
+	})
+	c.Assert(items, DeepEquals, []string{})
+
+	err = s.Put(key, &v, WithCondition(expression.AttributeNotExists(expression.Name("Key"))))
+	c.Assert(err, IsNil)
+
+	v2 := v
+	v2.Name = "bob"
+	err = s.Put(key, &v2, isBob)
+	c.Assert(err, ErrorIs, ErrConditionFailed)
+	err = s.Put(key, &v2, isAlice, isBob)
+	c.Assert(err, ErrorIs, ErrConditionFailed)
+	err = s.Put(key, &v2, isAlice)
+	c.Assert(err, IsNil)
+
+	var v3 AccountT
+	err = s.Get(key, &v3)
+	c.Assert(err, IsNil)
+	c.Assert(v3, DeepEquals, v2)
+
+	err = s.Delete(key, isAlice)
+	c.Assert(err, ErrorIs, ErrConditionFailed)
+	err = s.Get(key, &v3)
+	c.Assert(err, IsNil)
+
+	err = s.Delete(key, isBob)
+	c.Assert(err, IsNil)
+	err = s.Get(key, &v3)
+	c.Assert(err, ErrorIs, ErrNotFound)
+}
+
+func (suite *StoreImplTest) TestPutLinkWithCondition(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	err := s.CreateTable()
+	c.Assert(err, IsNil)
+
+	key := []string{"AccountsByEmail", "alice@example.com"}
+	err = s.PutLink(key, []string{"Accounts", "12345"})
+	c.Assert(err, IsNil)
+
+	// only replace the link if it still points where we expect
+	pointsAt := func(target []string) Option {
+		return WithCondition(expression.Name(s.SpecialCharacter).Equal(
+			expression.Value(s.EncodeKey(target))))
+	}
+	err = s.PutLink(key, []string{"Accounts", "6789"}, pointsAt([]string{"Accounts", "0000"}))
+	c.Assert(err, ErrorIs, ErrConditionFailed)
+	err = s.PutLink(key, []string{"Accounts", "6789"}, pointsAt([]string{"Accounts", "12345"}))
+	c.Assert(err, IsNil)
+
+	link, err := s.GetLink(key)
+	c.Assert(err, IsNil)
+	c.Assert(link, DeepEquals, []string{"Accounts", "6789"})
+}
+
+func (suite *StoreImplTest) TestReturnOldItem(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+
+	key := []string{"Accounts", "12345"}
+	alice := AccountT{ID: "12345", Name: "alice"}
+	bob := AccountT{ID: "12345", Name: "bob"}
+
+	var old AccountT
+	found := true
+	c.Assert(s.Put(key, &alice, ReturnOldItem(&old, &found)), IsNil)
+	c.Assert(found, Equals, false)
+	c.Assert(old, DeepEquals, AccountT{})
+
+	c.Assert(s.Put(key, &bob, ReturnOldItem(&old, &found)), IsNil)
+	c.Assert(found, Equals, true)
+	c.Assert(old, DeepEquals, alice)
+
+	old = AccountT{}
+	c.Assert(s.Delete(key, ReturnOldItem(&old, nil)), IsNil)
+	c.Assert(old, DeepEquals, bob)
+	c.Assert(s.Get(key, &old), ErrorIs, ErrNotFound)
+}
+
+func (suite *StoreImplTest) TestRollBackOnFailure(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+	v := AccountT{ID: "12345", Name: "alice"}
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &v), IsNil)
+
+	listAccounts := func() []string {
+		items := []string{}
+		s.List([]string{"Accounts"}, func(item string, err error) bool {
+			c.Assert(err, IsNil)
+			items = append(items, item)
+			return true
+		})
+		return items
+	}
+
+	key := []string{"Accounts", "6789", "Profile"}
+	err := s.Put(key, &v, RollBackOnFailure(),
+		WithCondition(expression.AttributeExists(expression.Name("Key"))))
+	c.Assert(err, ErrorIs, ErrConditionFailed)
+	c.Assert(listAccounts(), DeepEquals, []string{"12345"})
+	c.Assert(s.Get(key, &v), ErrorIs, ErrNotFound)
+
+	err = s.Put(key, &v, RollBackOnFailure(), throttleRequest("PutItem", 1))
+	multiRowErr, ok := err.(*MultiRowError)
+	c.Assert(ok, Equals, true)
+	c.Assert(multiRowErr.LeafWritten, Equals, false)
+	c.Assert(multiRowErr.Written, DeepEquals, []RowID{{Key: "¦", Child: "Accounts"}})
+	c.Assert(multiRowErr.RolledBack, DeepEquals, []RowID{
+		{Key: "¦Accounts¦6789¦", Child: "Profile"},
+		{Key: "¦Accounts¦", Child: "6789"},
+	})
+	c.Assert(err, ErrorIs, ErrThrottled)
+	c.Assert(listAccounts(), DeepEquals, []string{"12345"})
+
+	c.Assert(s.Put(key, &v, RollBackOnFailure()), IsNil)
+	c.Assert(listAccounts(), DeepEquals, []string{"12345", "6789"})
+}
+
+func (suite *StoreImplTest) TestWriteLeafFirst(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+
+	key := []string{"Accounts", "12345"}
+	v := AccountT{ID: "12345", Name: "alice"}
+	err := s.Put(key, &v, WriteLeafFirst(), throttleRequest("BatchWriteItem", 1))
+	multiRowErr, ok := err.(*MultiRowError)
+	c.Assert(ok, Equals, true)
+	c.Assert(multiRowErr.LeafWritten, Equals, true)
+
+	var v2 AccountT
+	c.Assert(s.Get(key, &v2), IsNil)
+	c.Assert(v2, DeepEquals, v)
+	s.List([]string{"Accounts"}, func(item string, err error) bool {
+		c.Errorf("unexpected item %q, %v", item, err)
+		return true
+	})
+}
+
+func (suite *StoreImplTest) TestListBudget(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		c.Assert(s.Put([]string{"Accounts", id}, &AccountT{ID: id}), IsNil)
+	}
+
+	list := func(opts ...Option) []string {
+		items := []string{}
+		s.List([]string{"Accounts"}, func(item string, err error) bool {
+			c.Assert(err, IsNil)
+			items = append(items, item)
+			return true
+		}, opts...)
+		return items
+	}
+
+	var cursor string
+	c.Assert(list(MaxItems(2), ReturnCursor(&cursor)), DeepEquals, []string{"1", "2"})
+	c.Assert(cursor, Not(Equals), "")
+	c.Assert(list(MaxItems(2), StartAfter(cursor), ReturnCursor(&cursor)), DeepEquals, []string{"3", "4"})
+	c.Assert(list(MaxItems(2), StartAfter(cursor), ReturnCursor(&cursor)), DeepEquals, []string{"5"})
+	c.Assert(cursor, Equals, "")
+
+	// stopping early also returns a cursor
+	s.List([]string{"Accounts"}, func(item string, err error) bool {
+		return item != "3"
+	}, ReturnCursor(&cursor))
+	c.Assert(list(StartAfter(cursor)), DeepEquals, []string{"4", "5"})
+
+	var consumed float64
+	c.Assert(list(CapacityBudget(1000), ReturnCursor(&cursor), ReturnConsumedCapacity(&consumed)),
+		DeepEquals, []string{"1", "2", "3", "4", "5"})
+	c.Assert(cursor, Equals, "")
+	c.Assert(consumed > 0, Equals, true)
+
+	err := error(nil)
+	s.List([]string{"Accounts"}, func(item string, innerErr error) bool {
+		err = innerErr
+		return false
+	}, StartAfter("!"))
+	c.Assert(err, ErrorMatches, `invalid cursor "!"`)
+}
+
+func (suite *StoreImplTest) TestReturnQueryStats(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+	for _, id := range []string{"1", "2", "3"} {
+		c.Assert(s.Put([]string{"Accounts", id}, &AccountT{ID: id, Name: "alice"}), IsNil)
+	}
+	c.Assert(s.Put([]string{"Accounts", "4"}, &AccountT{ID: "4", Name: "bob"}), IsNil)
+
+	var stats QueryStats
+	s.List([]string{"Accounts"}, func(item string, err error) bool {
+		c.Assert(err, IsNil)
+		return true
+	}, ReturnQueryStats(&stats))
+	c.Assert(stats.Pages, Equals, 1)
+	c.Assert(stats.ScannedCount, Equals, int64(4))
+	c.Assert(stats.Count, Equals, int64(4))
+	c.Assert(stats.CapacityUnits > 0, Equals, true)
+	c.Assert(stats.LastEvaluatedKey, IsNil)
+
+	// Find reads every object but returns only the matches
+	stats = QueryStats{}
+	s.Find(nil, expression.Name("Name").Equal(expression.Value("bob")),
+		func(key []string, item map[string]*dynamodb.AttributeValue, err error) bool {
+			c.Assert(err, IsNil)
+			return true
+		}, ReturnQueryStats(&stats))
+	// Walk lists the root, Accounts and each account, and each of
+	// Accounts and the accounts is then read.
+	c.Assert(stats.Pages, Equals, 1+2*(1+4))
+	c.Assert(stats.ScannedCount, Equals, int64(1+4+4))
+	c.Assert(stats.Count, Equals, int64(1+4+1))
+}
+
+func (suite *StoreImplTest) TestRetryThrottledReads(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+	key := []string{"Accounts", "12345"}
+	v := AccountT{ID: "12345", Name: "alice"}
+	c.Assert(s.Put(key, &v), IsNil)
+
+	// throttle causes the first n attempts of each GetItem to be throttled.
+	attempts := 0
+	throttle := func(n int) Option {
+		attempts = 0
+		return WithRequestOptions(func(r *request.Request) {
+			if r.Operation.Name != "GetItem" {
+				return
+			}
+			r.Handlers.Send.PushBack(func(r *request.Request) {
+				if attempts++; attempts <= n {
+					r.Error = awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "throttled", nil)
+				}
+			})
+		})
+	}
+
+	var v2 AccountT
+	c.Assert(s.Get(key, &v2, throttle(2), RetryThrottledReads(3, time.Millisecond)), IsNil)
+	c.Assert(attempts, Equals, 3)
+	c.Assert(v2, DeepEquals, v)
+
+	err := s.Get(key, &v2, throttle(10), RetryThrottledReads(3, 10*time.Millisecond))
+	c.Assert(attempts, Equals, 4)
+	c.Assert(err, ErrorIs, ErrThrottled)
+	throttled, ok := err.(*ThrottledError)
+	c.Assert(ok, Equals, true)
+	c.Assert(throttled.Op, Equals, "Get")
+	c.Assert(throttled.RetryAfter > 0, Equals, true)
+	c.Assert(throttled.RetryAfter <= 10*time.Millisecond, Equals, true)
+	c.Assert(throttled.Err.(awserr.Error).Code(), Equals, dynamodb.ErrCodeProvisionedThroughputExceededException)
+}
+
+func (suite *StoreImplTest) TestWithRequestTag(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+	key := []string{"Accounts", "12345"}
+	v := AccountT{ID: "12345", Name: "alice"}
+
+	tags := []string{}
+	record := WithRequestOptions(func(r *request.Request) {
+		r.Handlers.Send.PushFront(func(r *request.Request) {
+			tags = append(tags, r.HTTPRequest.Header.Get(RequestTagHeader))
+		})
+	})
+	c.Assert(s.Put(key, &v, WithRequestTag("req-1"), record), IsNil)
+	var v2 AccountT
+	ctx := ContextWithRequestTag(context.Background(), "req-2")
+	c.Assert(s.Get(key, &v2, WithContext(ctx), record), IsNil)
+	c.Assert(s.Get(key, &v2, WithContext(ctx), WithRequestTag("req-3"), record), IsNil)
+	c.Assert(s.Get(key, &v2, record), IsNil)
+	c.Assert(tags, DeepEquals, []string{"req-1", "req-2", "req-3", ""})
+}
+
+func (suite *StoreImplTest) TestCallOptions(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+	key := []string{"Accounts", "12345"}
+	v := AccountT{ID: "12345", Name: "alice"}
+
+	// one BatchWriteItem writing three rows
+	consumed := 0.0
+	c.Assert(s.Put(key, &v, ReturnConsumedCapacity(&consumed)), IsNil)
+	c.Assert(consumed, Equals, 3.0)
+
+	// The capacity consumed by concurrent requests is added up safely.
+	for _, name := range []string{"1", "2", "3", "4"} {
+		c.Assert(s.Put([]string{"Accounts", "12345", name}, &v), IsNil)
+	}
+	consumed = 0
+	c.Assert(s.WalkParallel([]string{"Accounts"}, 4, func([]string) error { return nil },
+		ReturnConsumedCapacity(&consumed)), IsNil)
+	c.Assert(consumed > 0, Equals, true)
+	c.Assert(s.DeleteAll([]string{"Accounts", "12345"}), IsNil)
+	c.Assert(s.Put(key, &v), IsNil)
+
+	operations := []string{}
+	consistent := []bool{}
+	record := WithRequestOptions(func(r *request.Request) {
+		operations = append(operations, r.Operation.Name)
+		switch input := r.Params.(type) {
+		case *dynamodb.GetItemInput:
+			consistent = append(consistent, aws.BoolValue(input.ConsistentRead))
+		case *dynamodb.QueryInput:
+			consistent = append(consistent, aws.BoolValue(input.ConsistentRead))
+		}
+	})
+	var v2 AccountT
+	c.Assert(s.Get(key, &v2, record), IsNil)
+	c.Assert(s.Get(key, &v2, record, ConsistentRead()), IsNil)
+	s.Walk(nil, func(key []string, err error) bool {
+		c.Assert(err, IsNil)
+		return true
+	}, record, ConsistentRead())
+	c.Assert(operations, DeepEquals, []string{"GetItem", "GetItem", "Query", "Query", "Query"})
+	c.Assert(consistent, DeepEquals, []bool{false, true, true, true, true})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := s.Get(key, &v2, WithContext(ctx))
+	c.Assert(err, NotNil)
+	c.Assert(err.(awserr.Error).Code(), Equals, request.CanceledErrorCode)
+
+	err = s.Put(key, &v, WithTimeout(time.Nanosecond))
+	c.Assert(err, NotNil)
+}
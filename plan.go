@@ -0,0 +1,137 @@
+package dynamotree
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Plan describes the DynamoDB requests that a mutating operation would
+// issue. Plans are returned by PlanPut, PlanPutLink and PlanDelete, which
+// compute the requests without executing them, so that an operation can
+// be verified before it is run against a live table.
+type Plan struct {
+	// Requests are the BatchWriteItem calls that the operation would make,
+	// in the order in which they would be made.
+	Requests []*dynamodb.BatchWriteItemInput
+}
+
+// newPlan divides writeRequests into the batches of 25, the maximum that
+// BatchWriteItem allows.
+func (t *Tree) newPlan(writeRequests []*dynamodb.WriteRequest) *Plan {
+	plan := &Plan{}
+	for i := 0; i < len(writeRequests); i += 25 {
+		n := i + 25
+		if n >= len(writeRequests) {
+			n = len(writeRequests)
+		}
+		plan.Requests = append(plan.Requests, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]*dynamodb.WriteRequest{
+				t.TableName: writeRequests[i:n],
+			},
+		})
+	}
+	return plan
+}
+
+// PlanPut returns the requests that Put would issue to store item at key,
+// without issuing them.
+func (t *Tree) PlanPut(key []string, item Storable) (*Plan, error) {
+	t.initOnce.Do(t.init)
+	key, err := t.transformKey(key)
+	if err != nil {
+		return nil, err
+	}
+	writeRequests, err := t.putRequests(key, item)
+	if err != nil {
+		return nil, err
+	}
+	return t.newPlan(t.flatten(key, writeRequests, nil)), nil
+}
+
+// PlanPutLink returns the requests that PutLink would issue to create a
+// link from key to target, without issuing them.
+func (t *Tree) PlanPutLink(key []string, target []string) (*Plan, error) {
+	t.initOnce.Do(t.init)
+	key, target, err := t.transformLink(key, target)
+	if err != nil {
+		return nil, err
+	}
+	writeRequests, err := t.putLinkRequests(key, target)
+	if err != nil {
+		return nil, err
+	}
+	return t.newPlan(t.flatten(key, writeRequests, nil)), nil
+}
+
+// PlanDelete returns the requests that Delete would issue to remove the
+// item at key, without issuing them. The plan assumes that nothing is
+// stored below key; otherwise Delete keeps the key's directory entry. It
+// also omits the backlink that Delete removes when key holds a link and
+// Tree.MaintainBacklinks is set, as finding it requires a read.
+func (t *Tree) PlanDelete(key []string) (*Plan, error) {
+	t.initOnce.Do(t.init)
+	key, err := t.transformKey(key)
+	if err != nil {
+		return nil, err
+	}
+	writeRequests, err := t.deleteRequests(key)
+	if err != nil {
+		return nil, err
+	}
+	return t.newPlan(t.tenantRows(writeRequests)), nil
+}
+
+// String returns a human-readable description of the plan, with one line
+// for each row that would be written or removed.
+func (p *Plan) String() string {
+	buf := bytes.NewBuffer(nil)
+	for i, input := range p.Requests {
+		tableNames := []string{}
+		for tableName := range input.RequestItems {
+			tableNames = append(tableNames, tableName)
+		}
+		sort.Strings(tableNames)
+
+		for _, tableName := range tableNames {
+			fmt.Fprintf(buf, "BatchWriteItem %d of %d on %s:\n", i+1, len(p.Requests), tableName)
+			for _, writeRequest := range input.RequestItems[tableName] {
+				switch {
+				case writeRequest.PutRequest != nil:
+					fmt.Fprintf(buf, "  PUT    %s\n", describeRow(writeRequest.PutRequest.Item))
+				case writeRequest.DeleteRequest != nil:
+					fmt.Fprintf(buf, "  DELETE %s\n", describeRow(writeRequest.DeleteRequest.Key))
+				}
+			}
+		}
+	}
+	return buf.String()
+}
+
+// describeRow returns a one line description of a row, giving the Key and
+// Child attributes followed by the names of the remaining attributes.
+func describeRow(row map[string]*dynamodb.AttributeValue) string {
+	rv := fmt.Sprintf("Key=%q Child=%q", stringValue(row["Key"]), stringValue(row["Child"]))
+
+	names := []string{}
+	for name := range row {
+		if name != "Key" && name != "Child" {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		sort.Strings(names)
+		rv += " Attributes=" + strings.Join(names, ",")
+	}
+	return rv
+}
+
+func stringValue(v *dynamodb.AttributeValue) string {
+	if v == nil || v.S == nil {
+		return ""
+	}
+	return *v.S
+}
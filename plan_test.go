@@ -0,0 +1,60 @@
+package dynamotree
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestPlan(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	err := s.CreateTable()
+	c.Assert(err, IsNil)
+
+	v := AccountT{
+		ID:    "12345",
+		Name:  "alice",
+		Email: "alice@example.com",
+	}
+	plan, err := s.PlanPut([]string{"Accounts", "12345"}, &v)
+	c.Assert(err, IsNil)
+	c.Assert(plan.Requests, HasLen, 1)
+	c.Assert(plan.Requests[0].RequestItems[tableName], HasLen, 3)
+	c.Assert(plan.String(), Equals, "BatchWriteItem 1 of 1 on "+tableName+":\n"+
+		"  PUT    Key=\"¦\" Child=\"Accounts\"\n"+
+		"  PUT    Key=\"¦Accounts¦\" Child=\"12345\"\n"+
+		"  PUT    Key=\"¦Accounts¦12345\" Child=\"¦\" Attributes=Email,ID,MarshalFailPlease,Name,UnmarshalFailPlease\n")
+
+	// planning does not modify the table
+	err = s.Get([]string{"Accounts", "12345"}, &v)
+	c.Assert(err, ErrorIs, ErrNotFound)
+
+	plan, err = s.PlanPutLink([]string{"AccountsByEmail", "alice@example.com"}, []string{"Accounts", "12345"})
+	c.Assert(err, IsNil)
+	c.Assert(plan.String(), Equals, "BatchWriteItem 1 of 1 on "+tableName+":\n"+
+		"  PUT    Key=\"¦\" Child=\"AccountsByEmail\"\n"+
+		"  PUT    Key=\"¦AccountsByEmail¦\" Child=\"alice@example.com\"\n"+
+		"  PUT    Key=\"¦AccountsByEmail¦alice@example.com\" Child=\"¦\" Attributes=¦\n")
+
+	plan, err = s.PlanDelete([]string{"Accounts", "12345"})
+	c.Assert(err, IsNil)
+	c.Assert(plan.String(), Equals, "BatchWriteItem 1 of 1 on "+tableName+":\n"+
+		"  DELETE Key=\"¦Accounts¦\" Child=\"12345\"\n"+
+		"  DELETE Key=\"¦Accounts¦12345\" Child=\"¦\"\n")
+
+	_, err = s.PlanPut([]string{"Accounts", "12¦345"}, &v)
+	c.Assert(err, ErrorIs, ErrReservedCharacterInKey)
+
+	key := []string{}
+	for i := 0; i < 30; i++ {
+		key = append(key, "X")
+	}
+	plan, err = s.PlanPut(key, &v)
+	c.Assert(err, IsNil)
+	c.Assert(plan.Requests, HasLen, 2)
+	c.Assert(plan.Requests[0].RequestItems[tableName], HasLen, 25)
+	c.Assert(plan.Requests[1].RequestItems[tableName], HasLen, 6)
+}
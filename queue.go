@@ -0,0 +1,269 @@
+package dynamotree
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// DefaultVisibilityTimeout is the VisibilityTimeout used when a Queue does
+// not specify one.
+const DefaultVisibilityTimeout = 30 * time.Second
+
+// ErrQueueEmpty is returned by Dequeue when there are no messages available
+var ErrQueueEmpty = errors.New("queue is empty")
+
+// ErrLeaseExpired is returned by Ack and Nack when the lease on a message has
+// expired, allowing it to be dequeued again, or the message has been
+// acknowledged already
+var ErrLeaseExpired = errors.New("lease expired")
+
+// Queue is a work queue stored in a Tree. Each message is an object whose
+// key is Prefix followed by a message ID. IDs begin with the time at which
+// the message was enqueued, so messages are dequeued in roughly the order
+// in which they were enqueued.
+//
+// A dequeued message is leased to the consumer for VisibilityTimeout, during
+// which it cannot be dequeued again. The consumer must call Ack to remove
+// the message once it has been processed, or Nack to return it to the queue
+// immediately. If the consumer does neither, the message becomes available
+// again once the lease expires.
+type Queue struct {
+	// Tree is the tree in which messages are stored.
+	Tree *Tree
+
+	// Prefix is the key below which messages are stored.
+	Prefix []string
+
+	// VisibilityTimeout is the duration of the lease on a dequeued message.
+	// If zero, DefaultVisibilityTimeout is used.
+	VisibilityTimeout time.Duration
+}
+
+// Message identifies a message that has been dequeued.
+type Message struct {
+	// ID is the last part of the message's key.
+	ID string
+
+	// Receipt identifies the lease on the message.
+	Receipt string
+}
+
+// The attributes recording the lease on a message. The names include the
+// special character so that they cannot collide with the attributes of the
+// message itself.
+func (q *Queue) leaseExpiresAttribute() string { return q.Tree.SpecialCharacter + "LeaseExpires" }
+func (q *Queue) receiptAttribute() string      { return q.Tree.SpecialCharacter + "Receipt" }
+
+func (q *Queue) key(id string) []string {
+	key := make([]string, len(q.Prefix), len(q.Prefix)+1)
+	copy(key, q.Prefix)
+	return append(key, id)
+}
+
+// Enqueue adds item to the queue and returns the ID of the new message.
+func (q *Queue) Enqueue(item Storable) (string, error) {
+	id, err := q.Tree.newTimeOrderedID(q.Tree.now())
+	if err != nil {
+		return "", err
+	}
+	if err := q.Tree.Put(q.key(id), item); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Dequeue leases the oldest available message, unmarshals it into ob and
+// returns it. If no message is available, Dequeue returns ErrQueueEmpty.
+func (q *Queue) Dequeue(ob Storable) (*Message, error) {
+	t := q.Tree
+	if err := t.ready(); err != nil {
+		return nil, err
+	}
+	visibilityTimeout := q.VisibilityTimeout
+	if visibilityTimeout == 0 {
+		visibilityTimeout = DefaultVisibilityTimeout
+	}
+
+	var message *Message
+	var err error
+	t.List(q.Prefix, func(id string, innerErr error) bool {
+		if innerErr != nil {
+			err = innerErr
+			return false
+		}
+		message, err = q.lease(id, visibilityTimeout, ob)
+		return message == nil && err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if message == nil {
+		return nil, ErrQueueEmpty
+	}
+	return message, nil
+}
+
+// lease attempts to lease the message id. It returns nil if the message
+// has been removed or is leased by another consumer.
+func (q *Queue) lease(id string, visibilityTimeout time.Duration, ob Storable) (*Message, error) {
+	t := q.Tree
+	receipt, err := t.randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	key, err := t.transformKey(q.key(id))
+	if err != nil {
+		return nil, err
+	}
+
+	now := t.now()
+	leaseExpires := expression.Name(q.leaseExpiresAttribute())
+	expr, err := expression.NewBuilder().
+		WithCondition(expression.And(
+			expression.AttributeExists(expression.Name("Key")),
+			expression.AttributeNotExists(expression.Name(t.SpecialCharacter)),
+			expression.AttributeNotExists(expression.Name(t.LinkAttribute)),
+			expression.Or(
+				expression.AttributeNotExists(leaseExpires),
+				leaseExpires.LessThan(expression.Value(now.UnixNano()))))).
+		WithUpdate(expression.
+			Set(leaseExpires, expression.Value(now.Add(visibilityTimeout).UnixNano())).
+			Set(expression.Name(q.receiptAttribute()), expression.Value(receipt))).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.DB.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(t.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Key": &dynamodb.AttributeValue{
+				S: aws.String(t.EncodeKey(key)),
+			},
+			"Child": &dynamodb.AttributeValue{
+				S: aws.String(t.SpecialCharacter),
+			},
+		},
+		ConditionExpression:       expr.Condition(),
+		UpdateExpression:          expr.Update(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ReturnValues:              aws.String(dynamodb.ReturnValueAllNew),
+	})
+	if isConditionalCheckFailed(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	item := resp.Attributes
+	delete(item, q.leaseExpiresAttribute())
+	delete(item, q.receiptAttribute())
+	if err := ob.UnmarshalDynamoDB(item); err != nil {
+		return nil, err
+	}
+	return &Message{ID: id, Receipt: receipt}, nil
+}
+
+// Ack removes message from the queue. If the lease on the message has
+// expired, Ack returns ErrLeaseExpired and the message is not removed.
+func (q *Queue) Ack(message *Message) error {
+	err := q.Tree.Delete(q.key(message.ID), WithCondition(q.leaseCondition(message)))
+	if errors.Is(err, ErrConditionFailed) {
+		return ErrLeaseExpired
+	}
+	return err
+}
+
+// Nack releases the lease on message, making it available to be dequeued
+// again immediately. If the lease on the message has expired, Nack returns
+// ErrLeaseExpired.
+func (q *Queue) Nack(message *Message) error {
+	t := q.Tree
+	if err := t.ready(); err != nil {
+		return err
+	}
+	key, err := t.transformKey(q.key(message.ID))
+	if err != nil {
+		return err
+	}
+
+	expr, err := expression.NewBuilder().
+		WithCondition(q.leaseCondition(message)).
+		WithUpdate(expression.
+			Remove(expression.Name(q.leaseExpiresAttribute())).
+			Remove(expression.Name(q.receiptAttribute()))).
+		Build()
+	if err != nil {
+		return err
+	}
+	_, err = t.DB.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(t.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Key": &dynamodb.AttributeValue{
+				S: aws.String(t.EncodeKey(key)),
+			},
+			"Child": &dynamodb.AttributeValue{
+				S: aws.String(t.SpecialCharacter),
+			},
+		},
+		ConditionExpression:       expr.Condition(),
+		UpdateExpression:          expr.Update(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	if isConditionalCheckFailed(err) {
+		return ErrLeaseExpired
+	}
+	return err
+}
+
+// leaseCondition returns a condition that is true if the lease on message
+// is current.
+func (q *Queue) leaseCondition(message *Message) expression.ConditionBuilder {
+	return expression.And(
+		expression.Name(q.receiptAttribute()).Equal(expression.Value(message.Receipt)),
+		expression.Name(q.leaseExpiresAttribute()).GreaterThan(expression.Value(q.Tree.now().UnixNano())))
+}
+
+// newTimeOrderedID returns a unique ID beginning with now, such that IDs
+// sort in the order of their times.
+func (t *Tree) newTimeOrderedID(now time.Time) (string, error) {
+	suffix, err := t.randomHex(4)
+	if err != nil {
+		return "", err
+	}
+	return timeOrderedPrefix(now) + "-" + suffix, nil
+}
+
+// timeOrderedPrefix returns the prefix of the IDs returned by
+// newTimeOrderedID for t. Times before 1970 are treated as 1970.
+func timeOrderedPrefix(t time.Time) string {
+	nsec := int64(0)
+	if t.After(time.Unix(0, 0)) {
+		nsec = t.UnixNano()
+	}
+	return fmt.Sprintf("%019d", nsec)
+}
+
+// timeOrderedIDTime returns the time encoded in an ID returned by
+// newTimeOrderedID.
+func timeOrderedIDTime(id string) (time.Time, error) {
+	i := strings.IndexByte(id, '-')
+	if i < 0 {
+		i = len(id)
+	}
+	nsec, err := strconv.ParseInt(id[:i], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, nsec), nil
+}
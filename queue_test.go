@@ -0,0 +1,131 @@
+package dynamotree
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestQueue(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	err := s.CreateTable()
+	c.Assert(err, IsNil)
+
+	q := &Queue{Tree: s, Prefix: []string{"Queues", "Email"}}
+
+	_, err = q.Dequeue(&AccountT{})
+	c.Assert(err, Equals, ErrQueueEmpty)
+
+	id1, err := q.Enqueue(&AccountT{ID: "1", Name: "alice"})
+	c.Assert(err, IsNil)
+	id2, err := q.Enqueue(&AccountT{ID: "2", Name: "bob"})
+	c.Assert(err, IsNil)
+	c.Assert(id1 < id2, Equals, true)
+
+	var v AccountT
+	m1, err := q.Dequeue(&v)
+	c.Assert(err, IsNil)
+	c.Assert(m1.ID, Equals, id1)
+	c.Assert(v, DeepEquals, AccountT{ID: "1", Name: "alice"})
+
+	// the first message is leased, so we get the second one
+	m2, err := q.Dequeue(&v)
+	c.Assert(err, IsNil)
+	c.Assert(m2.ID, Equals, id2)
+	c.Assert(v, DeepEquals, AccountT{ID: "2", Name: "bob"})
+
+	_, err = q.Dequeue(&v)
+	c.Assert(err, Equals, ErrQueueEmpty)
+
+	// Nack makes the message available again
+	err = q.Nack(m1)
+	c.Assert(err, IsNil)
+	err = q.Ack(m1)
+	c.Assert(err, Equals, ErrLeaseExpired)
+	m3, err := q.Dequeue(&v)
+	c.Assert(err, IsNil)
+	c.Assert(m3.ID, Equals, id1)
+
+	err = q.Ack(m3)
+	c.Assert(err, IsNil)
+	err = q.Ack(m3)
+	c.Assert(err, Equals, ErrLeaseExpired)
+	err = q.Ack(m2)
+	c.Assert(err, IsNil)
+
+	_, err = q.Dequeue(&v)
+	c.Assert(err, Equals, ErrQueueEmpty)
+	items := []string{}
+	s.List(q.Prefix, func(item string, err error) bool {
+		c.Assert(err, IsNil)
+		items = append(items, item)
+		return true
+	})
+	c.Assert(items, DeepEquals, []string{})
+}
+
+func (suite *StoreImplTest) TestQueueVisibilityTimeout(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	err := s.CreateTable()
+	c.Assert(err, IsNil)
+
+	q := &Queue{Tree: s, Prefix: []string{"Queue"}, VisibilityTimeout: 10 * time.Millisecond}
+	id, err := q.Enqueue(&AccountT{ID: "1"})
+	c.Assert(err, IsNil)
+
+	var v AccountT
+	m1, err := q.Dequeue(&v)
+	c.Assert(err, IsNil)
+	_, err = q.Dequeue(&v)
+	c.Assert(err, Equals, ErrQueueEmpty)
+
+	time.Sleep(20 * time.Millisecond)
+	m2, err := q.Dequeue(&v)
+	c.Assert(err, IsNil)
+	c.Assert(m2.ID, Equals, id)
+
+	// the first consumer has lost its lease
+	err = q.Ack(m1)
+	c.Assert(err, Equals, ErrLeaseExpired)
+	err = q.Ack(m2)
+	c.Assert(err, IsNil)
+}
+
+func (suite *StoreImplTest) TestQueueKeyTransformer(c *C) {
+	s := &Tree{TableName: uniuri.New(), DB: dynamodb.New(session.New(), testConfig),
+		KeyTransformer: func(key []string) ([]string, error) {
+			for i, part := range key {
+				key[i] = strings.ToLower(part)
+			}
+			return key, nil
+		},
+	}
+	c.Assert(s.CreateTable(), IsNil)
+
+	q := &Queue{Tree: s, Prefix: []string{"Queues", "Email"}}
+	id, err := q.Enqueue(&AccountT{ID: "1"})
+	c.Assert(err, IsNil)
+
+	var v AccountT
+	m1, err := q.Dequeue(&v)
+	c.Assert(err, IsNil)
+	c.Assert(m1.ID, Equals, id)
+	c.Assert(v, DeepEquals, AccountT{ID: "1"})
+	_, err = q.Dequeue(&v)
+	c.Assert(err, Equals, ErrQueueEmpty)
+
+	c.Assert(q.Nack(m1), IsNil)
+	m2, err := q.Dequeue(&v)
+	c.Assert(err, IsNil)
+	c.Assert(q.Ack(m2), IsNil)
+	_, err = q.Dequeue(&v)
+	c.Assert(err, Equals, ErrQueueEmpty)
+}
@@ -0,0 +1,84 @@
+package dynamotree
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Redactor masks the sensitive attributes of objects, such as password
+// hashes and tokens, wherever the tree's attributes are shown or copied
+// outside of the table: by ExportArchive and WriteTableExport given
+// Redacted, and by Tree.Redact, which logging, auditing and debugging
+// tools can call so that they all mask the same attributes.
+type Redactor interface {
+	// Redact returns the value to show in place of value, the attribute
+	// called name of the object stored at key. It returns value itself if
+	// the attribute is not sensitive, or nil to omit the attribute.
+	Redact(key []string, name string, value *dynamodb.AttributeValue) *dynamodb.AttributeValue
+}
+
+// RedactedValue is the value shown in place of the attributes masked by
+// RedactAttributes.
+const RedactedValue = "REDACTED"
+
+// RedactAttributes is a Redactor that masks each attribute whose name it
+// lists, of the object at any key, by replacing its value with the string
+// RedactedValue.
+type RedactAttributes []string
+
+// Redact implements Redactor.
+func (r RedactAttributes) Redact(key []string, name string, value *dynamodb.AttributeValue) *dynamodb.AttributeValue {
+	for _, redacted := range r {
+		if name == redacted {
+			return &dynamodb.AttributeValue{S: aws.String(RedactedValue)}
+		}
+	}
+	return value
+}
+
+// Redacted causes ExportArchive, WriteTableExport and ExportSubject to mask
+// attributes using Tree.Redactor. A redacted export cannot restore the masked
+// attributes, so it suits sharing data rather than backing it up.
+func Redacted() Option {
+	return func(o *callOptions) {
+		o.redact = true
+	}
+}
+
+// Redact returns a copy of attributes, those of the object at key, in which
+// the attributes that Tree.Redactor considers sensitive are masked. The
+// attributes that the tree itself uses, Key, Child, the LinkAttribute and
+// those whose names begin with the SpecialCharacter, are never masked. If
+// Tree.Redactor is nil, Redact returns attributes unchanged.
+func (t *Tree) Redact(key []string, attributes map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	t.initOnce.Do(t.init)
+	if t.Redactor == nil {
+		return attributes
+	}
+	rv := make(map[string]*dynamodb.AttributeValue, len(attributes))
+	for name, value := range attributes {
+		if name == "Key" || name == "Child" || name == t.LinkAttribute || strings.HasPrefix(name, t.SpecialCharacter) {
+			rv[name] = value
+			continue
+		}
+		if value = t.Redactor.Redact(key, name, value); value != nil {
+			rv[name] = value
+		}
+	}
+	return rv
+}
+
+// redactRow returns row, as stored in the table, with its attributes masked
+// if o asks for them to be, according to the key that row belongs to.
+func (t *Tree) redactRow(row map[string]*dynamodb.AttributeValue, o *callOptions) (map[string]*dynamodb.AttributeValue, error) {
+	if !o.redact {
+		return row, nil
+	}
+	exportRow, err := t.exportRow(row)
+	if err != nil || exportRow == nil || exportRow.Kind == ExportMetadata || exportRow.Kind == ExportDirectoryEntry {
+		return row, err
+	}
+	return t.Redact(exportRow.Key, row), nil
+}
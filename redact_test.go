@@ -0,0 +1,68 @@
+package dynamotree
+
+import (
+	"bytes"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestRedact(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db, KeepVersions: true, Redactor: RedactAttributes{"Email"}}
+	c.Assert(s.CreateTable(), IsNil)
+	alice := AccountT{ID: "12345", Name: "alice", Email: "alice@example.com"}
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &alice), IsNil)
+	c.Assert(s.PutLink([]string{"Current"}, []string{"Accounts", "12345"}), IsNil)
+
+	attributes := map[string]*dynamodb.AttributeValue{
+		"Key":   &dynamodb.AttributeValue{S: aws.String("¦Accounts¦12345")},
+		"Name":  &dynamodb.AttributeValue{S: aws.String("alice")},
+		"Email": &dynamodb.AttributeValue{S: aws.String("alice@example.com")},
+	}
+	redacted := s.Redact([]string{"Accounts", "12345"}, attributes)
+	c.Assert(aws.StringValue(redacted["Email"].S), Equals, RedactedValue)
+	c.Assert(aws.StringValue(redacted["Name"].S), Equals, "alice")
+	c.Assert(aws.StringValue(redacted["Key"].S), Equals, "¦Accounts¦12345")
+	c.Assert(aws.StringValue(attributes["Email"].S), Equals, "alice@example.com")
+
+	// An archive is redacted only if asked
+	for _, redact := range []bool{false, true} {
+		opts := []Option{}
+		if redact {
+			opts = append(opts, Redacted())
+		}
+		buf := bytes.NewBuffer(nil)
+		c.Assert(s.ExportArchive([]string{"Accounts"}, buf, ArchiveTar, opts...), IsNil)
+		s2 := &Tree{TableName: uniuri.New(), DB: db}
+		c.Assert(s2.CreateTable(), IsNil)
+		c.Assert(s2.ImportArchive(buf, ArchiveTar), IsNil)
+		var v AccountT
+		c.Assert(s2.Get([]string{"Accounts", "12345"}, &v), IsNil)
+		if redact {
+			c.Assert(v, DeepEquals, AccountT{ID: "12345", Name: "alice", Email: RedactedValue})
+		} else {
+			c.Assert(v, DeepEquals, alice)
+		}
+	}
+
+	// A table export masks the versions of the object too, but not links
+	buf := bytes.NewBuffer(nil)
+	c.Assert(s.WriteTableExport(nil, buf, Redacted()), IsNil)
+	kinds := map[ExportRowKind]int{}
+	s.ReadTableExport(bytes.NewReader(buf.Bytes()), func(row *ExportRow, err error) bool {
+		c.Assert(err, IsNil)
+		if email, ok := row.Row["Email"]; ok {
+			c.Assert(aws.StringValue(email.S), Equals, RedactedValue)
+			kinds[row.Kind]++
+		}
+		if row.Kind == ExportLink {
+			c.Assert(row.LinkTarget, DeepEquals, []string{"Accounts", "12345"})
+		}
+		return true
+	})
+	c.Assert(kinds, DeepEquals, map[ExportRowKind]int{ExportObject: 1, ExportAuxiliary: 1})
+}
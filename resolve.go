@@ -0,0 +1,91 @@
+package dynamotree
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// maxResolveAttempts is the number of times resolveAtomically reads the
+// rows of the links from key together before it gives up because the
+// links keep changing.
+const maxResolveAttempts = 4
+
+// resolveAtomically returns the row of the object at key, following
+// symbolic links as they were at one point in time. chain holds the path
+// keys of the rows that the links from key were found to lead through, as
+// far as the object.
+func (t *Tree) resolveAtomically(key []string, chain []string, o *callOptions) (map[string]*dynamodb.AttributeValue, error) {
+	for attempt := 0; attempt < maxResolveAttempts; attempt++ {
+		rows, err := t.transactGetRows(chain, o)
+		if err != nil {
+			return nil, err
+		}
+
+		// Follow the links as they were read together, as far as they
+		// still lead through chain.
+		for i, row := range rows {
+			if row == nil {
+				return nil, t.notFound(chain[i], o)
+			}
+			linkTarget, ok := t.linkTarget(row)
+			if !ok {
+				return row, nil
+			}
+			if i >= t.MaxLinkHops {
+				return nil, &LinkHopsError{Key: key, MaxLinkHops: t.MaxLinkHops}
+			}
+			if i+1 == len(chain) || chain[i+1] != linkTarget {
+				// The link changed since it was followed, so its new
+				// target is read too.
+				if err := t.checkCapabilityReach(key, t.DecodeKey(linkTarget), true, o); err != nil {
+					return nil, err
+				}
+				for _, pathKey := range chain[:i+1] {
+					if pathKey == linkTarget {
+						// The links now form a cycle, which would be
+						// followed until MaxLinkHops.
+						return nil, &LinkHopsError{Key: key, MaxLinkHops: t.MaxLinkHops}
+					}
+				}
+				chain = append(chain[:i+1:i+1], linkTarget)
+				break
+			}
+		}
+	}
+	return nil, fmt.Errorf("the links from %q changed while they were being followed",
+		strings.Join(key, "/"))
+}
+
+// transactGetRows returns the object or link rows whose Keys are pathKeys,
+// read in a single transaction, with nil for each row that does not
+// exist.
+func (t *Tree) transactGetRows(pathKeys []string, o *callOptions) ([]map[string]*dynamodb.AttributeValue, error) {
+	items := make([]*dynamodb.TransactGetItem, len(pathKeys))
+	for i, pathKey := range pathKeys {
+		items[i] = &dynamodb.TransactGetItem{
+			Get: &dynamodb.Get{
+				TableName: aws.String(t.TableName),
+				Key: map[string]*dynamodb.AttributeValue{
+					"Key":   &dynamodb.AttributeValue{S: aws.String(pathKey)},
+					"Child": &dynamodb.AttributeValue{S: aws.String(t.SpecialCharacter)},
+				},
+			},
+		}
+	}
+	output, err := t.DB.TransactGetItemsWithContext(o.context(), &dynamodb.TransactGetItemsInput{
+		TransactItems: items,
+	}, o.request()...)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]map[string]*dynamodb.AttributeValue, len(pathKeys))
+	for i, response := range output.Responses {
+		if i < len(rows) && len(response.Item) > 0 {
+			rows[i] = response.Item
+		}
+	}
+	return rows, nil
+}
@@ -0,0 +1,72 @@
+package dynamotree
+
+import (
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestResolveLinksAtomically(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+	bob := AccountT{ID: "6789", Name: "bob"}
+	carol := AccountT{ID: "1111", Name: "carol"}
+	c.Assert(s.Put([]string{"Accounts", "bob"}, &bob), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "carol"}, &carol), IsNil)
+	c.Assert(s.PutLink([]string{"Current"}, []string{"Accounts", "bob"}), IsNil)
+
+	var v AccountT
+	c.Assert(s.Get([]string{"Accounts", "bob"}, &v, ResolveLinksAtomically()), IsNil)
+	c.Assert(v, DeepEquals, bob)
+
+	// The link is changed after it is followed and before the rows are
+	// read together, so it is followed again.
+	transactions := 0
+	changeLink := WithRequestOptions(func(r *request.Request) {
+		if r.Operation.Name != "TransactGetItems" {
+			return
+		}
+		if transactions++; transactions == 1 {
+			c.Assert(s.PutLink([]string{"Current"}, []string{"Accounts", "carol"}), IsNil)
+		}
+	})
+	c.Assert(s.Get([]string{"Current"}, &v, ResolveLinksAtomically(), changeLink), IsNil)
+	c.Assert(v, DeepEquals, carol)
+	c.Assert(transactions, Equals, 2)
+
+	// The target is removed
+	transactions = 0
+	removeTarget := WithRequestOptions(func(r *request.Request) {
+		if r.Operation.Name == "TransactGetItems" {
+			transactions++
+			c.Assert(s.Delete([]string{"Accounts", "carol"}), IsNil)
+		}
+	})
+	err := s.Get([]string{"Current"}, &v, ResolveLinksAtomically(), removeTarget)
+	c.Assert(err, ErrorIs, ErrNotFound)
+	c.Assert(transactions, Equals, 1)
+
+	// An object is read without a transaction
+	transactions = 0
+	c.Assert(s.Get([]string{"Accounts", "bob"}, &v, ResolveLinksAtomically(), removeTarget), IsNil)
+	c.Assert(transactions, Equals, 0)
+
+	// The links are changed to form a cycle.
+	c.Assert(s.PutLink([]string{"Loop"}, []string{"Next"}), IsNil)
+	c.Assert(s.PutLink([]string{"Next"}, []string{"Accounts", "bob"}), IsNil)
+	transactions = 0
+	formCycle := WithRequestOptions(func(r *request.Request) {
+		if r.Operation.Name != "TransactGetItems" {
+			return
+		}
+		if transactions++; transactions == 1 {
+			c.Assert(s.PutLink([]string{"Next"}, []string{"Loop"}), IsNil)
+		}
+	})
+	err = s.Get([]string{"Loop"}, &v, ResolveLinksAtomically(), formCycle)
+	c.Assert(err, FitsTypeOf, &LinkHopsError{})
+	c.Assert(transactions, Equals, 1)
+}
@@ -0,0 +1,452 @@
+package dynamotree
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// RetentionPolicy limits how long the history of the keys at and below
+// Prefix is kept. Each key is subject to the policy with the longest
+// matching Prefix. Zero values keep everything.
+type RetentionPolicy struct {
+	Prefix []string
+
+	// MaxVersions is the number of versions of each object, recorded when
+	// Tree.KeepVersions is set, that are kept.
+	MaxVersions int
+
+	// EventTTL is how long events appended by AppendEvent are kept.
+	EventTTL time.Duration
+
+	// ObjectTTL is how long objects stored by Put are kept. Put gives each
+	// object Tree.TTLAttribute, holding the time at which it expires,
+	// unless the object already has it, so that DynamoDB removes it. Sweep
+	// then removes the directory entries that these objects leave, and
+	// the entries of directories below Prefix left with nothing in them,
+	// as well as the expired objects that DynamoDB has yet to remove.
+	// Tree.TTLAttribute must be set.
+	ObjectTTL time.Duration
+
+	// PurgeDeletedAfter is how long the versions of an object that has
+	// been deleted are kept. Until then, the deleted object can still be
+	// read using GetAsOf, much as a file can be recovered from the trash.
+	PurgeDeletedAfter time.Duration
+}
+
+// retentionPolicy returns the policy that applies to key, or nil if there
+// is none.
+func (t *Tree) retentionPolicy(key []string) *RetentionPolicy {
+	var rv *RetentionPolicy
+	for i := range t.RetentionPolicies {
+		policy := &t.RetentionPolicies[i]
+		if hasKeyPrefix(key, policy.Prefix) && (rv == nil || len(policy.Prefix) > len(rv.Prefix)) {
+			rv = policy
+		}
+	}
+	return rv
+}
+
+// eventExpiry returns the attribute that causes DynamoDB to remove an
+// event appended to key at now, if the event is subject to an EventTTL
+// and the tree has a TTLAttribute.
+func (t *Tree) eventExpiry(key []string, now time.Time) (string, *dynamodb.AttributeValue) {
+	if t.TTLAttribute == "" {
+		return "", nil
+	}
+	policy := t.retentionPolicy(key)
+	if policy == nil || policy.EventTTL <= 0 {
+		return "", nil
+	}
+	expires := now.Add(policy.EventTTL).Unix()
+	return t.TTLAttribute, &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(expires, 10))}
+}
+
+// objectExpiry returns the attribute that causes DynamoDB to remove an
+// object stored at key at now, if the object is subject to an ObjectTTL.
+func (t *Tree) objectExpiry(key []string, now time.Time) (string, *dynamodb.AttributeValue) {
+	policy := t.retentionPolicy(key)
+	if t.TTLAttribute == "" || policy == nil || policy.ObjectTTL <= 0 {
+		return "", nil
+	}
+	expires := now.Add(policy.ObjectTTL).Unix()
+	return t.TTLAttribute, &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(expires, 10))}
+}
+
+// SweepResult describes the rows removed by Sweep.
+type SweepResult struct {
+	// Keys is the number of keys examined.
+	Keys int
+
+	// Events is the number of expired events removed.
+	Events int
+
+	// Versions is the number of versions removed beyond MaxVersions.
+	Versions int
+
+	// Purged is the number of deleted objects whose versions were all
+	// removed.
+	Purged int
+
+	// Expired is the number of objects whose ObjectTTL had passed that
+	// were removed, with their directory entries.
+	Expired int
+
+	// Entries is the number of directory entries removed, subject to an
+	// ObjectTTL, of keys at which nothing was stored any longer, such as
+	// those of objects that DynamoDB removed once they expired.
+	Entries int
+}
+
+// Sweeper enforces Tree.RetentionPolicies by removing the versions,
+// events and objects that the policies no longer keep, and the directory
+// entries of the objects that DynamoDB removed once they expired. Events are also given an
+// expiry time when they are appended if Tree.TTLAttribute is set, so that
+// DynamoDB removes them without a sweep, but versions can only be removed
+// by sweeping.
+//
+// Sweep may be called by a standalone job, such as a scheduled task, or
+// Run may be used to sweep periodically within a long-running process.
+// Sweeping removes rows below WriteGuards, as expiring them is a form of
+// maintenance.
+type Sweeper struct {
+	Tree *Tree
+
+	// Interval is the time between the start of each sweep made by Run.
+	// If not specified, DefaultSweepInterval is used.
+	Interval time.Duration
+
+	// OnSweep, if not nil, is called by Run with the outcome of each
+	// sweep.
+	OnSweep func(*SweepResult, error)
+}
+
+// DefaultSweepInterval is the interval between the sweeps made by
+// Sweeper.Run if Interval is not specified.
+const DefaultSweepInterval = time.Hour
+
+// Run sweeps the tree every Interval until ctx is done, when it returns
+// ctx.Err(), or the tree is closed, when it returns ErrClosed. A sweep that
+// fails is reported to OnSweep and retried at the next interval.
+func (s *Sweeper) Run(ctx context.Context) error {
+	interval := s.Interval
+	if interval == 0 {
+		interval = DefaultSweepInterval
+	}
+	closing, done := s.Tree.background()
+	defer done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		result, err := s.Sweep(ctx)
+		if s.OnSweep != nil {
+			s.OnSweep(result, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-closing:
+			return ErrClosed
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep traverses the keys below the prefix of each of the tree's
+// retention policies, including keys that are now deleted, and removes the
+// rows that the policies no longer keep.
+func (s *Sweeper) Sweep(ctx context.Context) (*SweepResult, error) {
+	t := s.Tree
+	if err := t.ready(); err != nil {
+		return nil, err
+	}
+	o, cancel := newCallOptions([]Option{WithContext(ctx), ConsistentRead()})
+	defer cancel()
+
+	// Prefixes below the prefix of another policy are swept along with it.
+	prefixes := [][]string{}
+	for _, policy := range t.RetentionPolicies {
+		prefixes = append(prefixes, policy.Prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) < len(prefixes[j]) })
+	roots := [][]string{}
+	for _, prefix := range prefixes {
+		covered := false
+		for _, root := range roots {
+			covered = covered || hasKeyPrefix(prefix, root)
+		}
+		if !covered {
+			roots = append(roots, prefix)
+		}
+	}
+
+	sw := &sweep{tree: t, o: o, now: t.now(), result: &SweepResult{}}
+	for _, root := range roots {
+		if _, err := sw.sweep(root, false, false); err != nil {
+			return sw.result, err
+		}
+	}
+	return sw.result, nil
+}
+
+// sweep holds the state of a call to Sweep.
+type sweep struct {
+	tree   *Tree
+	o      *callOptions
+	now    time.Time
+	result *SweepResult
+}
+
+// sweep removes the expired rows of key and of each key below it. It
+// returns true if any history of key remains. inHistory is true if key is
+// recorded in the history of its directory, and listed if it has an entry
+// in the directory.
+func (sw *sweep) sweep(key []string, inHistory, listed bool) (bool, error) {
+	t := sw.tree
+	sw.result.Keys++
+	policy := t.retentionPolicy(key)
+	if policy == nil {
+		policy = &RetentionPolicy{}
+	}
+
+	if policy.EventTTL > 0 {
+		if err := sw.expireEvents(key, policy.EventTTL); err != nil {
+			return false, err
+		}
+	}
+	remaining, err := sw.pruneVersions(key, policy)
+	if err != nil {
+		return false, err
+	}
+
+	// The children are those listed now and those recorded in the history.
+	names := map[string]bool{}
+	entries := map[string]bool{}
+	t.list(key, func(name string, innerErr error) bool {
+		if innerErr != nil {
+			err = innerErr
+			return false
+		}
+		names[name] = false
+		entries[name] = true
+		return true
+	}, sw.o)
+	if err != nil {
+		return false, err
+	}
+	history, err := t.historyNames(key, sw.o)
+	if err != nil {
+		return false, err
+	}
+	for _, name := range history {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		child := make([]string, len(key), len(key)+1)
+		copy(child, key)
+		childRemaining, err := sw.sweep(append(child, name), names[name], entries[name])
+		if err != nil {
+			return false, err
+		}
+		remaining = remaining || childRemaining
+	}
+
+	// The keys below have been swept, so that a directory left with
+	// nothing in it is removed as well.
+	if policy.ObjectTTL > 0 && len(key) > len(policy.Prefix) {
+		if err := sw.expireObject(key, listed); err != nil {
+			return false, err
+		}
+	}
+
+	// Once nothing of key remains, it is removed from the history.
+	if !remaining && inHistory && len(key) > 0 {
+		err := t.batchWrite([]*dynamodb.WriteRequest{sw.deleteRequest(
+			t.EncodeKey(key[:len(key)-1]), t.historyChild()+t.encodePart(key[len(key)-1]))}, sw.o)
+		if err != nil {
+			return false, err
+		}
+	}
+	return remaining || !inHistory, nil
+}
+
+// expireEvents removes the events of key appended more than ttl ago.
+func (sw *sweep) expireEvents(key []string, ttl time.Duration) error {
+	t := sw.tree
+	eventsChild := t.eventsChild()
+	rows, err := sw.query(t.EncodeKey(key), eventsChild, eventsChild+timeOrderedPrefix(sw.now.Add(-ttl)), true)
+	if err != nil {
+		return err
+	}
+	sw.result.Events += len(rows)
+	return sw.remove(rows)
+}
+
+// expireObject removes the object at key if its ObjectTTL has passed, and
+// the directory entry of key if nothing remains at or below it. listed is
+// true if key has a directory entry.
+func (sw *sweep) expireObject(key []string, listed bool) error {
+	t := sw.tree
+	pathKey := t.EncodeKey(key)
+	row, err := t.getRow(pathKey, sw.o)
+	if err != nil {
+		return err
+	}
+	expires, hasExpiry := row[t.TTLAttribute]
+	expired := false
+	if hasExpiry && expires.N != nil {
+		at, err := strconv.ParseInt(aws.StringValue(expires.N), 10, 64)
+		expired = err == nil && at <= sw.now.Unix()
+	}
+	if (row != nil && !expired) || (row == nil && !listed) {
+		return nil
+	}
+
+	writeRequests := []*dynamodb.WriteRequest{}
+	if expired {
+		// The object is only removed if it has not been stored again
+		// since it was read.
+		_, err := t.DB.DeleteItemWithContext(sw.o.context(), &dynamodb.DeleteItemInput{
+			TableName: aws.String(t.TableName),
+			Key: map[string]*dynamodb.AttributeValue{
+				"Key":   &dynamodb.AttributeValue{S: aws.String(pathKey)},
+				"Child": &dynamodb.AttributeValue{S: aws.String(t.SpecialCharacter)},
+			},
+			ConditionExpression:       aws.String("#T = :expires"),
+			ExpressionAttributeNames:  map[string]*string{"#T": aws.String(t.TTLAttribute)},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":expires": expires},
+		}, sw.o.request()...)
+		if isConditionalCheckFailed(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		sw.result.Expired++
+		versions, err := t.versionRequests(key, nil)
+		if err != nil {
+			return err
+		}
+		writeRequests = append(writeRequests, versions...)
+	}
+
+	hasChildren, err := t.hasChildren(t.dirKey(key), sw.o)
+	if err != nil {
+		return err
+	}
+	if listed && !hasChildren {
+		if !expired {
+			sw.result.Entries++
+		}
+		writeRequests = append(writeRequests, sw.deleteRequest(t.dirKey(key[:len(key)-1]), t.childName(key)))
+	}
+	if err := t.batchWrite(writeRequests, sw.o); err != nil {
+		return err
+	}
+	if expired {
+		return t.refreshLinkCopies(key, nil, sw.o)
+	}
+	return nil
+}
+
+// pruneVersions removes the versions of key that policy does not keep,
+// and returns true if any remain.
+func (sw *sweep) pruneVersions(key []string, policy *RetentionPolicy) (bool, error) {
+	t := sw.tree
+	if policy.MaxVersions <= 0 && policy.PurgeDeletedAfter <= 0 {
+		return true, nil
+	}
+	versionsChild := t.versionsChild()
+	versions, err := sw.query(t.EncodeKey(key), versionsChild, versionsChild+":", false)
+	if err != nil || len(versions) == 0 {
+		return false, err
+	}
+
+	latest := versions[0]
+	if _, deleted := latest[t.deletedAttribute()]; deleted && policy.PurgeDeletedAfter > 0 {
+		deletedAt, err := timeOrderedIDTime(strings.TrimPrefix(aws.StringValue(latest["Child"].S), versionsChild))
+		if err != nil {
+			return false, err
+		}
+		if deletedAt.Before(sw.now.Add(-policy.PurgeDeletedAfter)) {
+			// The object may have been stored again since without keeping
+			// versions.
+			row, err := t.getRow(t.EncodeKey(key), sw.o)
+			if err != nil {
+				return false, err
+			}
+			if row == nil {
+				sw.result.Purged++
+				return false, sw.remove(versions)
+			}
+		}
+	}
+
+	if policy.MaxVersions > 0 && len(versions) > policy.MaxVersions {
+		sw.result.Versions += len(versions) - policy.MaxVersions
+		if err := sw.remove(versions[policy.MaxVersions:]); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// query returns the Key, Child and deletedAttribute of each row with the
+// key pathKey and a Child between start and end, in ascending order of
+// Child if forward is true and descending order otherwise.
+func (sw *sweep) query(pathKey, start, end string, forward bool) ([]map[string]*dynamodb.AttributeValue, error) {
+	t := sw.tree
+	rows := []map[string]*dynamodb.AttributeValue{}
+	err := t.DB.QueryPagesWithContext(sw.o.context(), &dynamodb.QueryInput{
+		TableName:              aws.String(t.TableName),
+		ConsistentRead:         sw.o.consistent(),
+		KeyConditionExpression: aws.String("#K = :key AND #C BETWEEN :start AND :end"),
+		ProjectionExpression:   aws.String("#K, #C, #D"),
+		ExpressionAttributeNames: map[string]*string{
+			"#K": aws.String("Key"),
+			"#C": aws.String("Child"),
+			"#D": aws.String(t.deletedAttribute()),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":key":   &dynamodb.AttributeValue{S: aws.String(pathKey)},
+			":start": &dynamodb.AttributeValue{S: aws.String(start)},
+			":end":   &dynamodb.AttributeValue{S: aws.String(end)},
+		},
+		ScanIndexForward: aws.Bool(forward),
+	}, func(p *dynamodb.QueryOutput, lastPage bool) bool {
+		rows = append(rows, p.Items...)
+		return true
+	}, sw.o.request()...)
+	return rows, err
+}
+
+// remove removes rows, each of which holds at least Key and Child.
+func (sw *sweep) remove(rows []map[string]*dynamodb.AttributeValue) error {
+	writeRequests := make([]*dynamodb.WriteRequest, len(rows))
+	for i, row := range rows {
+		writeRequests[i] = sw.deleteRequest(aws.StringValue(row["Key"].S), aws.StringValue(row["Child"].S))
+	}
+	return sw.tree.batchWrite(writeRequests, sw.o)
+}
+
+func (sw *sweep) deleteRequest(pathKey, childKey string) *dynamodb.WriteRequest {
+	return &dynamodb.WriteRequest{
+		DeleteRequest: &dynamodb.DeleteRequest{
+			Key: map[string]*dynamodb.AttributeValue{
+				"Key":   &dynamodb.AttributeValue{S: aws.String(pathKey)},
+				"Child": &dynamodb.AttributeValue{S: aws.String(childKey)},
+			},
+		},
+	}
+}
@@ -0,0 +1,137 @@
+package dynamotree
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestSweeper(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db, KeepVersions: true, TTLAttribute: "Expires",
+		RetentionPolicies: []RetentionPolicy{
+			{Prefix: []string{"Accounts"}, MaxVersions: 2, PurgeDeletedAfter: time.Millisecond},
+			{Prefix: []string{"Accounts", "carol"}, MaxVersions: 1, EventTTL: time.Millisecond},
+		}}
+	c.Assert(s.CreateTable(), IsNil)
+
+	for _, name := range []string{"a", "b", "c", "d"} {
+		c.Assert(s.Put([]string{"Accounts", "alice"}, &AccountT{Name: name}), IsNil)
+		c.Assert(s.Put([]string{"Accounts", "carol"}, &AccountT{Name: name}), IsNil)
+		c.Assert(s.Put([]string{"Other", "dave"}, &AccountT{Name: name}), IsNil)
+	}
+	c.Assert(s.Put([]string{"Accounts", "bob", "Settings"}, &AccountT{Name: "bob"}), IsNil)
+	c.Assert(s.Delete([]string{"Accounts", "bob", "Settings"}), IsNil)
+	_, err := s.AppendEvent([]string{"Accounts", "carol"}, &AccountT{Name: "login"})
+	c.Assert(err, IsNil)
+	_, err = s.AppendEvent([]string{"Accounts", "alice"}, &AccountT{Name: "login"})
+	c.Assert(err, IsNil)
+	beforeSweep := time.Now()
+	time.Sleep(5 * time.Millisecond)
+
+	s.ReadEvents([]string{"Accounts", "carol"}, time.Time{}, func(event *Event, err error) bool {
+		c.Assert(err, IsNil)
+		c.Assert(event.Item["Expires"], NotNil)
+		return true
+	})
+
+	sweeper := &Sweeper{Tree: s}
+	result, err := sweeper.Sweep(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(*result, DeepEquals, SweepResult{Keys: 5, Events: 1, Versions: 5, Purged: 1})
+
+	countVersions := func(key []string) int {
+		rows, err := (&sweep{tree: s, o: &callOptions{ctx: context.Background()}}).query(
+			s.EncodeKey(key), s.versionsChild(), s.versionsChild()+":", true)
+		c.Assert(err, IsNil)
+		return len(rows)
+	}
+	c.Assert(countVersions([]string{"Accounts", "alice"}), Equals, 2)
+	c.Assert(countVersions([]string{"Accounts", "carol"}), Equals, 1)
+	c.Assert(countVersions([]string{"Other", "dave"}), Equals, 4)
+
+	var v AccountT
+	c.Assert(s.GetAsOf([]string{"Accounts", "alice"}, beforeSweep, &v), IsNil)
+	c.Assert(v.Name, Equals, "d")
+	c.Assert(s.GetAsOf([]string{"Accounts", "bob", "Settings"}, beforeSweep, &v), ErrorIs, ErrNotFound)
+
+	// The purged object no longer appears in the history
+	names := []string{}
+	s.ListAsOf([]string{"Accounts"}, beforeSweep, func(name string, err error) bool {
+		c.Assert(err, IsNil)
+		names = append(names, name)
+		return true
+	})
+	c.Assert(names, DeepEquals, []string{"alice", "carol"})
+	history, err := s.historyNames([]string{"Accounts", "bob"}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(history, DeepEquals, []string{})
+
+	events := 0
+	s.ReadEvents([]string{"Accounts", "alice"}, time.Time{}, func(event *Event, err error) bool {
+		c.Assert(err, IsNil)
+		events++
+		return true
+	})
+	c.Assert(events, Equals, 1)
+
+	// A second sweep has nothing to do
+	result, err = sweeper.Sweep(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(*result, DeepEquals, SweepResult{Keys: 4})
+}
+
+func (suite *StoreImplTest) TestObjectTTL(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	clock := &fakeClock{now: time.Unix(1500000000, 0)}
+	s := &Tree{TableName: uniuri.New(), DB: db, TTLAttribute: "Expires", Clock: clock,
+		RetentionPolicies: []RetentionPolicy{{Prefix: []string{"Sessions"}, ObjectTTL: 24 * time.Hour}}}
+	c.Assert(s.CreateTable(), IsNil)
+
+	for _, key := range [][]string{{"Sessions", "a"}, {"Sessions", "b"}, {"Sessions", "c", "d"}, {"Other", "x"}} {
+		c.Assert(s.Put(key, &AccountT{Name: key[len(key)-1]}), IsNil)
+	}
+	c.Assert(s.Put([]string{"Sessions", "keep"}, rawItem{"Expires": {N: aws.String("2000000000")}}), IsNil)
+
+	item := rawItem{}
+	c.Assert(s.Get([]string{"Sessions", "a"}, item), IsNil)
+	c.Assert(aws.StringValue(item["Expires"].N), Equals, "1500086400")
+	item = rawItem{}
+	c.Assert(s.Get([]string{"Other", "x"}, item), IsNil)
+	c.Assert(item["Expires"], IsNil)
+
+	// DynamoDB removes the object row of Sessions/b, but not yet the
+	// others, leaving the directory entries behind.
+	_, err := db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(s.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Key":   {S: aws.String(s.EncodeKey([]string{"Sessions", "b"}))},
+			"Child": {S: aws.String(s.SpecialCharacter)},
+		},
+	})
+	c.Assert(err, IsNil)
+	clock.now = clock.now.Add(25 * time.Hour)
+
+	sweeper := &Sweeper{Tree: s}
+	result, err := sweeper.Sweep(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(*result, DeepEquals, SweepResult{Keys: 6, Expired: 2, Entries: 2})
+	c.Assert(walkKeys(c, s, []string{"Sessions"}), DeepEquals, [][]string{{"Sessions", "keep"}})
+	c.Assert(s.Get([]string{"Sessions", "a"}, &AccountT{}), ErrorIs, ErrNotFound)
+	c.Assert(s.Get([]string{"Other", "x"}, &AccountT{}), IsNil)
+
+	result, err = sweeper.Sweep(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(*result, DeepEquals, SweepResult{Keys: 2})
+
+	_, err = NewTree(uniuri.New(), db, func(t *Tree) {
+		t.RetentionPolicies = []RetentionPolicy{{ObjectTTL: time.Hour}}
+	})
+	c.Assert(err, FitsTypeOf, &ConfigError{})
+	c.Assert(err.(*ConfigError).Field, Equals, "RetentionPolicies")
+}
@@ -0,0 +1,72 @@
+package dynamotree
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// ErrScanForbidden is matched, using errors.Is, by the *ScanError returned
+// by an operation that would scan the table of a tree that has NoScan set.
+var ErrScanForbidden = errors.New("scan forbidden")
+
+// ScanError is returned by an operation that would scan the table of a
+// tree that has NoScan set.
+type ScanError struct {
+	// Operation is the name of the operation, such as "MigrateSchema".
+	Operation string
+}
+
+func (e *ScanError) Error() string {
+	return fmt.Sprintf("%s must scan table, which Tree.NoScan forbids", e.Operation)
+}
+
+// Is returns true if target is ErrScanForbidden.
+func (e *ScanError) Is(target error) bool { return target == ErrScanForbidden }
+
+// ScanOperations are the names of the operations that scan the whole
+// table, rather than querying the rows of the keys they are given, as
+// they are given to Tree.OnScan and recorded in a *ScanError. The time
+// and capacity that they take grows with the size of the table.
+var ScanOperations = []string{
+	"MigrateDelimiter",
+	"MigrateSchema",
+	"IntegrityJob",
+}
+
+// scanHandlerName is the name of the handler that rejects the scans of a
+// tree that has NoScan set.
+const scanHandlerName = "dynamotree.NoScan"
+
+// checkScan reports to OnScan that operation is about to scan the table,
+// and returns a *ScanError if NoScan is set.
+func (t *Tree) checkScan(operation string) error {
+	if t.OnScan != nil {
+		t.OnScan(operation)
+	}
+	if t.NoScan {
+		return &ScanError{Operation: operation}
+	}
+	return nil
+}
+
+// withoutScans returns a copy of db that fails each Scan of table with a
+// *ScanError, so that an operation that is not listed in ScanOperations
+// cannot scan the table either. The copy shares db's configuration and
+// retryer.
+func withoutScans(db *dynamodb.DynamoDB, table string) *dynamodb.DynamoDB {
+	c := *db.Client
+	c.Handlers = db.Handlers.Copy()
+	c.Handlers.Validate.RemoveByName(scanHandlerName)
+	c.Handlers.Validate.PushFrontNamed(request.NamedHandler{Name: scanHandlerName, Fn: func(r *request.Request) {
+		if in, ok := r.Params.(*dynamodb.ScanInput); ok && aws.StringValue(in.TableName) == table {
+			r.Error = &ScanError{Operation: "Scan"}
+		}
+	}})
+	rv := *db
+	rv.Client = &c
+	return &rv
+}
@@ -0,0 +1,46 @@
+package dynamotree
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestNoScan(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	scans := []string{}
+	s := &Tree{TableName: uniuri.New(), DB: db, OnScan: func(operation string) {
+		scans = append(scans, operation)
+	}}
+	c.Assert(s.CreateTable(), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &AccountT{ID: "12345"}), IsNil)
+	_, err := s.checkSegment(context.Background(), 0, 100)
+	c.Assert(err, IsNil)
+	c.Assert(scans, DeepEquals, []string{"IntegrityJob"})
+
+	scans = scans[:0]
+	s2 := &Tree{TableName: s.TableName, DB: db, NoScan: true, OnScan: s.OnScan}
+	v := AccountT{}
+	c.Assert(s2.Get([]string{"Accounts", "12345"}, &v), IsNil)
+	c.Assert(walkKeys(c, s2, []string{}), HasLen, 2)
+	c.Assert(scans, HasLen, 0)
+
+	_, err = s2.checkSegment(context.Background(), 0, 100)
+	c.Assert(err, ErrorIs, ErrScanForbidden)
+	c.Assert(err, DeepEquals, &ScanError{Operation: "IntegrityJob"})
+	_, err = MigrateSchema(s2, nil)
+	c.Assert(err, IsNil) // already at the current version, so nothing is read
+	_, err = MigrateDelimiter(s2, &Tree{TableName: uniuri.New(), DB: db, SpecialCharacter: "|"}, MigrateOptions{})
+	c.Assert(err, ErrorMatches, "MigrateDelimiter must scan table, which Tree.NoScan forbids")
+	c.Assert(scans, DeepEquals, []string{"IntegrityJob", "MigrateDelimiter"})
+
+	// Scans through the tree's client are refused too.
+	_, err = s2.DB.Scan(&dynamodb.ScanInput{TableName: aws.String(s2.TableName)})
+	c.Assert(err, ErrorIs, ErrScanForbidden)
+	_, err = db.Scan(&dynamodb.ScanInput{TableName: aws.String(s2.TableName)})
+	c.Assert(err, IsNil)
+}
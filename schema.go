@@ -0,0 +1,61 @@
+package dynamotree
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// CheckSchema returns a *SchemaError if the table does not exist or its
+// key schema differs from the one given by TableDefinition. Set
+// VerifySchema to have the tree call CheckSchema before its first
+// operation, so that a misconfigured table fails fast instead of causing
+// confusing validation errors later.
+func (t *Tree) CheckSchema(opts ...Option) error {
+	t.initOnce.Do(t.init)
+	o, cancel := newCallOptions(opts)
+	defer cancel()
+	resp, err := t.DB.DescribeTableWithContext(o.context(), &dynamodb.DescribeTableInput{
+		TableName: aws.String(t.TableName),
+	}, o.request()...)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+		return &SchemaError{TableName: t.TableName, Problem: "the table does not exist"}
+	}
+	if err != nil {
+		return err
+	}
+
+	attributeTypes := map[string]string{}
+	for _, def := range resp.Table.AttributeDefinitions {
+		attributeTypes[aws.StringValue(def.AttributeName)] = aws.StringValue(def.AttributeType)
+	}
+	keyTypes := map[string]string{}
+	for _, e := range resp.Table.KeySchema {
+		keyTypes[aws.StringValue(e.KeyType)] = aws.StringValue(e.AttributeName)
+	}
+
+	expected := t.TableDefinition()
+	for _, key := range []struct{ keyType, name string }{
+		{dynamodb.KeyTypeHash, expected.HashKey},
+		{dynamodb.KeyTypeRange, expected.RangeKey},
+	} {
+		keyType, name := key.keyType, key.name
+		if keyTypes[keyType] != name {
+			return &SchemaError{
+				TableName: t.TableName,
+				Problem: fmt.Sprintf("the %s key is %q, not %q",
+					keyType, keyTypes[keyType], name),
+			}
+		}
+		if attributeTypes[name] != dynamodb.ScalarAttributeTypeS {
+			return &SchemaError{
+				TableName: t.TableName,
+				Problem: fmt.Sprintf("the type of %q is %q, not %q",
+					name, attributeTypes[name], dynamodb.ScalarAttributeTypeS),
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package dynamotree
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestVerifySchema(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db, VerifySchema: true}
+
+	v := AccountT{ID: "12345"}
+	err := s.Put([]string{"Accounts", "12345"}, &v)
+	c.Assert(err, DeepEquals, &SchemaError{TableName: tableName, Problem: "the table does not exist"})
+
+	err = s.CreateTable()
+	c.Assert(err, IsNil)
+	err = s.Put([]string{"Accounts", "12345"}, &v)
+	c.Assert(err, IsNil)
+	c.Assert(s.Get([]string{"Accounts", "12345"}, &v), IsNil)
+}
+
+func (suite *StoreImplTest) TestCheckSchemaWrongKeys(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	_, err := db.CreateTable(&dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("Key"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+			{AttributeName: aws.String("Sort"), KeyType: aws.String(dynamodb.KeyTypeRange)},
+		},
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("Key"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+			{AttributeName: aws.String("Sort"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+		},
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(1),
+			WriteCapacityUnits: aws.Int64(1),
+		},
+	})
+	c.Assert(err, IsNil)
+
+	s := &Tree{TableName: tableName, DB: db, VerifySchema: true}
+	err = s.CheckSchema()
+	c.Assert(err, ErrorMatches, `table .* is not usable by dynamotree: the RANGE key is "Sort", not "Child"`)
+
+	err = s.Delete([]string{"Accounts", "12345"})
+	c.Assert(err, FitsTypeOf, &SchemaError{})
+}
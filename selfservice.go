@@ -0,0 +1,246 @@
+package dynamotree
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// selfServiceStatusRetention is how long a SelfServiceHandler remembers a
+// deletion once it has finished.
+const selfServiceStatusRetention = time.Hour
+
+// SelfServiceHandler is an http.Handler that serves the two requests a
+// tenant most often makes of its own data: to export it and to delete it.
+// It serves, relative to the path at which it is mounted, such as with
+// http.StripPrefix:
+//
+//	GET  /export  the tenant's subtree, as an archive written by ExportArchive
+//	POST /delete  starts deleting the tenant's subtree, with DeleteAll
+//	GET  /delete  the status of the deletion, as a SelfServiceDeleteStatus
+//
+// A deletion runs in the background, recording its progress as a
+// checkpoint, so that if it fails or the process stops before it
+// completes, it is resumed by the next POST. Its status is reported as
+// JSON, and a deletion that is running is reported with 202 Accepted.
+type SelfServiceHandler struct {
+	// Tree is the tree that holds the tenants' data.
+	Tree *Tree
+
+	// Subtree returns the key of the subtree that belongs to the tenant
+	// making r, or an error, which is reported with 403 Forbidden, if r
+	// is not authenticated as a tenant. It is required. An empty key,
+	// which would be the whole tree, is refused with 403 Forbidden too.
+	Subtree func(r *http.Request) ([]string, error)
+
+	// Format is the format of the archives exported.
+	Format ArchiveFormat
+
+	mu        sync.Mutex
+	deletions map[string]*selfServiceDeletion
+}
+
+// selfServiceDeletion is a deletion started by a SelfServiceHandler.
+type selfServiceDeletion struct {
+	status SelfServiceDeleteStatus
+
+	// finished is when the deletion completed or failed, or zero while it
+	// is running.
+	finished time.Time
+}
+
+// SelfServiceDeleteStatus is the status of a deletion started by a
+// SelfServiceHandler.
+type SelfServiceDeleteStatus struct {
+	// State is "running", "completed" or "failed" for a deletion started
+	// by this handler, "interrupted" for one that stopped before it
+	// completed in another process, or "none" if no deletion was started.
+	// The handler forgets a deletion an hour after it has finished, and
+	// then reports it as it would one made by another process.
+	State string `json:"state"`
+
+	// Items is the number of keys deleted so far.
+	Items int `json:"items"`
+
+	// Error is the reason that a failed deletion stopped.
+	Error string `json:"error,omitempty"`
+}
+
+func (h *SelfServiceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var serve func(http.ResponseWriter, *http.Request, []string)
+	switch {
+	case r.URL.Path == "/export" && r.Method == http.MethodGet:
+		serve = h.serveExport
+	case r.URL.Path == "/delete" && r.Method == http.MethodPost:
+		serve = h.serveDelete
+	case r.URL.Path == "/delete" && r.Method == http.MethodGet:
+		serve = h.serveDeleteStatus
+	case r.URL.Path == "/export":
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	case r.URL.Path == "/delete":
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if h.Subtree == nil {
+		http.Error(w, "dynamotree: SelfServiceHandler.Subtree is nil", http.StatusInternalServerError)
+		return
+	}
+	subtree, err := h.Subtree(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if len(subtree) == 0 {
+		// The whole tree belongs to no one tenant.
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	serve(w, r, subtree)
+}
+
+func (h *SelfServiceHandler) serveExport(w http.ResponseWriter, r *http.Request, subtree []string) {
+	contentType, filename := "application/x-tar", "export.tar"
+	if h.Format == ArchiveZip {
+		contentType, filename = "application/zip", "export.zip"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	cw := &countingWriter{w: w}
+	err := h.Tree.ExportArchive(subtree, cw, h.Format, WithContext(r.Context()))
+	if err != nil && cw.n == 0 {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	// Once the archive has begun, a failure can only be reported by
+	// ending it early, which the client sees as a truncated archive.
+}
+
+func (h *SelfServiceHandler) serveDelete(w http.ResponseWriter, r *http.Request, subtree []string) {
+	name := h.Tree.EncodeKey(subtree)
+	h.mu.Lock()
+	if h.deletions == nil {
+		h.deletions = map[string]*selfServiceDeletion{}
+	}
+	h.evict()
+	d, ok := h.deletions[name]
+	if !ok || d.status.State != "running" {
+		d = &selfServiceDeletion{status: SelfServiceDeleteStatus{State: "running"}}
+		h.deletions[name] = d
+		go h.delete(subtree, d)
+	}
+	rv := d.status
+	h.mu.Unlock()
+	w.Header().Set("Location", r.URL.String())
+	h.writeStatus(w, &rv)
+}
+
+// delete deletes subtree, recording its progress in d.
+func (h *SelfServiceHandler) delete(subtree []string, d *selfServiceDeletion) {
+	t := h.Tree
+	closing, done := t.background()
+	defer done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := t.DeleteAll(subtree, WithContext(ctx), WithCheckpoint(selfServiceCheckpoint(t, subtree)),
+		WithProgress(func(p Progress) {
+			h.mu.Lock()
+			d.status.Items = p.Items
+			h.mu.Unlock()
+		}))
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		d.status.State, d.status.Error = "failed", err.Error()
+	} else {
+		d.status.State = "completed"
+	}
+	d.finished = t.now()
+}
+
+// evict forgets the deletions that finished more than
+// selfServiceStatusRetention ago. h.mu must be held.
+func (h *SelfServiceHandler) evict() {
+	for name, d := range h.deletions {
+		if !d.finished.IsZero() && h.Tree.since(d.finished) > selfServiceStatusRetention {
+			delete(h.deletions, name)
+		}
+	}
+}
+
+func (h *SelfServiceHandler) serveDeleteStatus(w http.ResponseWriter, r *http.Request, subtree []string) {
+	t := h.Tree
+	h.mu.Lock()
+	h.evict()
+	d, ok := h.deletions[t.EncodeKey(subtree)]
+	var rv SelfServiceDeleteStatus
+	if ok {
+		rv = d.status
+	}
+	h.mu.Unlock()
+
+	if !ok {
+		// The deletion may have been started in another process.
+		if err := t.ready(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		row, err := t.getItem(MetadataKey, checkpointChild(selfServiceCheckpoint(t, subtree)),
+			&callOptions{ctx: r.Context(), consistentRead: true})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rv.State = "none"
+		if row != nil {
+			rv.State = "interrupted"
+			rv.Items, _ = strconv.Atoi(aws.StringValue(jobAttribute(row, "Items").N))
+		}
+	}
+	h.writeStatus(w, &rv)
+}
+
+func (h *SelfServiceHandler) writeStatus(w http.ResponseWriter, status *SelfServiceDeleteStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	if status.State == "running" {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// selfServiceCheckpoint returns the name of the checkpoint of the deletion
+// of subtree by a SelfServiceHandler.
+func selfServiceCheckpoint(t *Tree, subtree []string) string {
+	return "self-service-delete:" + t.EncodeKey(subtree)
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,155 @@
+package dynamotree
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestSelfServiceHandler(c *C) {
+	s := &Tree{TableName: uniuri.New(), DB: dynamodb.New(session.New(), testConfig)}
+	c.Assert(s.CreateTable(), IsNil)
+	for _, key := range [][]string{{"Tenants", "a", "1"}, {"Tenants", "a", "2", "x"}, {"Tenants", "b", "1"}} {
+		c.Assert(s.Put(key, &AccountT{ID: key[len(key)-1]}), IsNil)
+	}
+
+	h := &SelfServiceHandler{Tree: s, Subtree: func(r *http.Request) ([]string, error) {
+		tenant := r.Header.Get("X-Tenant")
+		if tenant == "" {
+			return nil, errors.New("not signed in")
+		}
+		if tenant == "-" {
+			return nil, nil
+		}
+		return []string{"Tenants", tenant}, nil
+	}}
+	serve := func(method, path, tenant string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		if tenant != "" {
+			r.Header.Set("X-Tenant", tenant)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	status := func(w *httptest.ResponseRecorder) SelfServiceDeleteStatus {
+		var rv SelfServiceDeleteStatus
+		c.Assert(json.Unmarshal(w.Body.Bytes(), &rv), IsNil)
+		return rv
+	}
+
+	c.Assert(serve("GET", "/export", "").Code, Equals, http.StatusForbidden)
+	c.Assert(serve("POST", "/delete", "-").Code, Equals, http.StatusForbidden)
+	w := httptest.NewRecorder()
+	(&SelfServiceHandler{Tree: s}).ServeHTTP(w, httptest.NewRequest("POST", "/delete", nil))
+	c.Assert(w.Code, Equals, http.StatusInternalServerError)
+	c.Assert(serve("PUT", "/delete", "a").Code, Equals, http.StatusMethodNotAllowed)
+	c.Assert(serve("GET", "/other", "a").Code, Equals, http.StatusNotFound)
+	c.Assert(walkKeys(c, s, []string{"Tenants"}), HasLen, 6)
+
+	w = serve("GET", "/export", "a")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(w.Header().Get("Content-Type"), Equals, "application/x-tar")
+	names := []string{}
+	tr := tar.NewReader(w.Body)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, IsNil)
+		names = append(names, header.Name)
+	}
+	c.Assert(names, DeepEquals, []string{"Tenants/a/1.json", "Tenants/a/2/x.json"})
+
+	c.Assert(status(serve("GET", "/delete", "a")), DeepEquals, SelfServiceDeleteStatus{State: "none"})
+	w = serve("POST", "/delete", "a")
+	c.Assert(w.Code, Equals, http.StatusAccepted)
+	c.Assert(w.Header().Get("Location"), Equals, "/delete")
+	c.Assert(status(w).State, Equals, "running")
+	deadline := time.Now().Add(10 * time.Second)
+	for status(serve("GET", "/delete", "a")).State == "running" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	w = serve("GET", "/delete", "a")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(status(w), DeepEquals, SelfServiceDeleteStatus{State: "completed", Items: 4})
+
+	c.Assert(walkKeys(c, s, nil), DeepEquals, [][]string{{"Tenants"}, {"Tenants", "b"}, {"Tenants", "b", "1"}})
+
+	// A deletion interrupted in another process is reported from its
+	// checkpoint, and resumed by the next request.
+	c.Assert(s.Put([]string{"Tenants", "b", "2"}, &AccountT{ID: "2"}), IsNil)
+	o, cancel := newCallOptions([]Option{WithCheckpoint(selfServiceCheckpoint(s, []string{"Tenants", "b"}))})
+	defer cancel()
+	j, err := s.startJob("DeleteAll", [][]string{{"Tenants", "b"}}, o)
+	c.Assert(err, IsNil)
+	c.Assert(j.done([]string{"Tenants", "b", "1"}), IsNil)
+	j.stop()
+	c.Assert(status(serve("GET", "/delete", "b")), DeepEquals, SelfServiceDeleteStatus{State: "interrupted", Items: 1})
+	c.Assert(status(serve("POST", "/delete", "b")).State, Equals, "running")
+	for status(serve("GET", "/delete", "b")).State == "running" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(status(serve("GET", "/delete", "b")), DeepEquals, SelfServiceDeleteStatus{State: "completed", Items: 4})
+	c.Assert(walkKeys(c, s, nil), DeepEquals, [][]string{{"Tenants"}})
+}
+
+func (suite *StoreImplTest) TestSelfServiceHandlerForgets(c *C) {
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db, Clock: clock}
+	c.Assert(s.CreateTable(), IsNil)
+	c.Assert(s.Put([]string{"Tenants", "a", "1"}, &AccountT{ID: "1"}), IsNil)
+	h := &SelfServiceHandler{Tree: s, Subtree: func(r *http.Request) ([]string, error) {
+		return []string{"Tenants", r.Header.Get("X-Tenant")}, nil
+	}}
+	status := func(tenant string) SelfServiceDeleteStatus {
+		r := httptest.NewRequest("GET", "/delete", nil)
+		r.Header.Set("X-Tenant", tenant)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		var rv SelfServiceDeleteStatus
+		c.Assert(json.Unmarshal(w.Body.Bytes(), &rv), IsNil)
+		return rv
+	}
+
+	r := httptest.NewRequest("POST", "/delete", nil)
+	r.Header.Set("X-Tenant", "a")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	deadline := time.Now().Add(10 * time.Second)
+	for status("a").State == "running" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(status("a").State, Equals, "completed")
+
+	// A finished deletion is forgotten once it has been kept long enough.
+	clock.mu.Lock()
+	clock.now = clock.now.Add(2 * selfServiceStatusRetention)
+	clock.mu.Unlock()
+	c.Assert(status("a"), DeepEquals, SelfServiceDeleteStatus{State: "none"})
+	h.mu.Lock()
+	c.Assert(h.deletions, HasLen, 0)
+	h.mu.Unlock()
+
+	// A checkpoint that has recorded no progress is interrupted at zero.
+	_, err := db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.TableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"Key":   {S: aws.String(MetadataKey)},
+			"Child": {S: aws.String(checkpointChild(selfServiceCheckpoint(s, []string{"Tenants", "c"})))},
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(status("c"), DeepEquals, SelfServiceDeleteStatus{State: "interrupted"})
+}
@@ -1,11 +1,17 @@
 package dynamotree
 
 import (
+	"errors"
 	"flag"
 	"log"
 	"os"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/crewjam/dynamotree/internal/dynamodblocal"
 	"github.com/crewjam/fakeaws/fakedynamodb"
 	. "gopkg.in/check.v1"
 )
@@ -13,17 +19,72 @@ import (
 // Hook up gocheck into the "go test" runner.
 func Test(t *testing.T) { TestingT(t) }
 
-var fakeDynamodbServer *fakedynamodb.FakeDynamoDB
+var useDynamodbLocal = flag.Bool("dynamodb-local", false,
+	"Run the tests against DynamoDB Local rather than fakedynamodb. The "+
+		"endpoint is taken from $"+dynamodblocal.EndpointEnv+" if set, otherwise "+
+		"a docker container is started.")
+
+// testConfig is the configuration for the DynamoDB service the tests use.
+var testConfig *aws.Config
 
 func TestMain(m *testing.M) {
 	flag.Parse()
 
-	var err error
-	fakeDynamodbServer, err = fakedynamodb.New()
+	if *useDynamodbLocal {
+		server, err := dynamodblocal.Start()
+		if err != nil {
+			log.Panicf("dynamodblocal: %s", err)
+		}
+		testConfig = server.Config
+		rv := m.Run()
+		server.Close()
+		os.Exit(rv)
+	}
+
+	fakeDynamodbServer, err := fakedynamodb.New()
 	if err != nil {
 		log.Panicf("fakedynamodb: %s", err)
 	}
 	defer fakeDynamodbServer.Close()
+	testConfig = fakeDynamodbServer.Config
 
 	os.Exit(m.Run())
 }
+
+// ErrorIs checks that the obtained error matches the expected one
+// according to errors.Is.
+var ErrorIs Checker = &errorIsChecker{
+	&CheckerInfo{Name: "ErrorIs", Params: []string{"obtained", "expected"}},
+}
+
+type errorIsChecker struct {
+	*CheckerInfo
+}
+
+func (checker *errorIsChecker) Check(params []interface{}, names []string) (result bool, errorString string) {
+	err, ok := params[0].(error)
+	if !ok && params[0] != nil {
+		return false, "obtained value is not an error"
+	}
+	target, ok := params[1].(error)
+	if !ok {
+		return false, "expected value is not an error"
+	}
+	return errors.Is(err, target), ""
+}
+
+// throttleRequest returns an option that causes the nth request of a call
+// to the named DynamoDB operation to fail as though it were throttled.
+func throttleRequest(operation string, n int) Option {
+	calls := 0
+	return WithRequestOptions(func(r *request.Request) {
+		if r.Operation.Name != operation {
+			return
+		}
+		if calls++; calls == n {
+			r.Handlers.Build.PushBack(func(r *request.Request) {
+				r.Error = awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "throttled", nil)
+			})
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package dynamotree
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SlowOperation describes a call to one of the tree's operations that took
+// at least Tree.SlowOperationThreshold, as given to Tree.OnSlowOperation.
+// A slow call is often one to a key that is deeply nested, or to a
+// directory with very many entries, or one whose requests were throttled.
+type SlowOperation struct {
+	OperationMetrics
+
+	// Key is the key given to the call, in full.
+	Key []string
+
+	// Pages is the number of pages of Query and Scan results read.
+	Pages int
+
+	// Retries are the requests that failed and were retried, in order.
+	Retries []RetryAttempt
+}
+
+// RetryAttempt is a request that failed and was retried.
+type RetryAttempt struct {
+	// Request is the name of the DynamoDB operation, such as "Query".
+	Request string
+
+	// Err is the error with which the request failed.
+	Err error
+}
+
+func (s *SlowOperation) String() string {
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "slow %s %q: %s, %d requests, %d pages",
+		s.Operation, strings.Join(s.Key, "/"), s.Duration, s.Requests, s.Pages)
+	for _, retry := range s.Retries {
+		fmt.Fprintf(buf, ", retried %s: %s", retry.Request, firstLine(retry.Err))
+	}
+	if s.Err != nil {
+		fmt.Fprintf(buf, ", failed: %s", firstLine(s.Err))
+	}
+	return buf.String()
+}
+
+// firstLine returns the first line of the message of err, since the errors
+// of the AWS SDK can run to several.
+func firstLine(err error) string {
+	msg := err.Error()
+	if i := strings.IndexByte(msg, '\n'); i >= 0 {
+		msg = msg[:i]
+	}
+	return msg
+}
+
+// reportSlow passes s to OnSlowOperation, or if it is nil, writes it to the
+// Logger of DB's configuration, if it has one.
+func (t *Tree) reportSlow(s *SlowOperation) {
+	if t.OnSlowOperation != nil {
+		t.OnSlowOperation(s)
+		return
+	}
+	if logger := t.DB.Config.Logger; logger != nil {
+		logger.Log("dynamotree:", s.String())
+	}
+}
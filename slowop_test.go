@@ -0,0 +1,57 @@
+package dynamotree
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestSlowOperation(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	slow := []*SlowOperation{}
+	s := &Tree{TableName: uniuri.New(), DB: db, SlowOperationThreshold: time.Hour,
+		OnSlowOperation: func(op *SlowOperation) { slow = append(slow, op) }}
+	c.Assert(s.CreateTable(), IsNil)
+	key := []string{"Accounts", "12345"}
+	c.Assert(s.Put(key, &AccountT{ID: "12345"}), IsNil)
+	c.Assert(slow, HasLen, 0)
+
+	s.SlowOperationThreshold = time.Nanosecond
+	attempts := 0
+	throttleOnce := WithRequestOptions(func(r *request.Request) {
+		r.Handlers.Send.PushBack(func(r *request.Request) {
+			if attempts++; attempts == 1 {
+				r.Error = awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "throttled", nil)
+			}
+		})
+	})
+	var v AccountT
+	c.Assert(s.Get(key, &v, throttleOnce, RetryThrottledReads(3, time.Millisecond)), IsNil)
+	s.List([]string{"Accounts"}, func(string, error) bool { return true })
+
+	c.Assert(slow, HasLen, 2)
+	c.Assert(slow[0].Operation, Equals, "Get")
+	c.Assert(slow[0].Key, DeepEquals, key)
+	c.Assert(slow[0].Requests, Equals, 2)
+	c.Assert(slow[0].Retries, HasLen, 1)
+	c.Assert(slow[0].Retries[0].Request, Equals, "GetItem")
+	c.Assert(slow[0].Retries[0].Err.(awserr.Error).Code(), Equals, dynamodb.ErrCodeProvisionedThroughputExceededException)
+	c.Assert(slow[0].String(), Matches, `slow Get "Accounts/12345": .*, 2 requests, 0 pages, retried GetItem: ProvisionedThroughputExceededException: throttled`)
+	c.Assert(slow[1].Operation, Equals, "List")
+	c.Assert(slow[1].Pages, Equals, 1)
+
+	// Without OnSlowOperation, slow calls are logged.
+	logged := []interface{}{}
+	config := testConfig.Copy()
+	config.Logger = aws.LoggerFunc(func(args ...interface{}) { logged = append(logged, args...) })
+	s2 := &Tree{TableName: s.TableName, DB: dynamodb.New(session.New(), config), SlowOperationThreshold: time.Nanosecond}
+	c.Assert(s2.Get(key, &v), IsNil)
+	c.Assert(logged, HasLen, 2)
+	c.Assert(logged[1], Matches, `slow Get "Accounts/12345": .*`)
+}
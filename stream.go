@@ -0,0 +1,157 @@
+package dynamotree
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+)
+
+// streamReader reads the records of a table's DynamoDB stream, for Mirror
+// and ExpiryCleaner.
+type streamReader struct {
+	streams *dynamodbstreams.DynamoDBStreams
+	arn     string
+	shards  map[string]*streamShard
+}
+
+// streamShard tracks our position in a shard of the stream.
+type streamShard struct {
+	parent   string
+	iterator *string
+	closed   bool
+}
+
+// latestStreamARN returns the ARN of the table's latest stream.
+func (t *Tree) latestStreamARN() (string, error) {
+	resp, err := t.DB.DescribeTable(&dynamodb.DescribeTableInput{
+		TableName: aws.String(t.TableName),
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.Table.LatestStreamArn == nil {
+		return "", fmt.Errorf("table %s does not have a stream", t.TableName)
+	}
+	return *resp.Table.LatestStreamArn, nil
+}
+
+// newStreamReader returns a reader of the stream arn, positioned at its
+// latest records.
+func newStreamReader(streams *dynamodbstreams.DynamoDBStreams, arn string) (*streamReader, error) {
+	r := &streamReader{streams: streams, arn: arn, shards: map[string]*streamShard{}}
+	if err := r.discoverShards(true); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// discoverShards adds any new shards of the stream to r.shards. When
+// starting, open shards are read from their latest records and closed
+// shards are ignored. Shards that appear later are read from the
+// beginning.
+func (r *streamReader) discoverShards(starting bool) error {
+	seen := map[string]bool{}
+	var lastShardID *string
+	for {
+		resp, err := r.streams.DescribeStream(&dynamodbstreams.DescribeStreamInput{
+			StreamArn:             aws.String(r.arn),
+			ExclusiveStartShardId: lastShardID,
+		})
+		if err != nil {
+			return err
+		}
+		for _, shard := range resp.StreamDescription.Shards {
+			shardID := *shard.ShardId
+			seen[shardID] = true
+			if _, ok := r.shards[shardID]; ok {
+				continue
+			}
+			s := &streamShard{parent: aws.StringValue(shard.ParentShardId)}
+			if starting {
+				if shard.SequenceNumberRange != nil && shard.SequenceNumberRange.EndingSequenceNumber != nil {
+					s.closed = true
+				} else {
+					iterator, err := r.shardIterator(shardID, dynamodbstreams.ShardIteratorTypeLatest)
+					if err != nil {
+						return err
+					}
+					s.iterator = iterator
+				}
+			}
+			r.shards[shardID] = s
+		}
+		lastShardID = resp.StreamDescription.LastEvaluatedShardId
+		if lastShardID == nil {
+			break
+		}
+	}
+
+	// Forget closed shards that have been trimmed from the stream.
+	for shardID, s := range r.shards {
+		if s.closed && !seen[shardID] {
+			delete(r.shards, shardID)
+		}
+	}
+	return nil
+}
+
+func (r *streamReader) shardIterator(shardID string, iteratorType string) (*string, error) {
+	resp, err := r.streams.GetShardIterator(&dynamodbstreams.GetShardIteratorInput{
+		StreamArn:         aws.String(r.arn),
+		ShardId:           aws.String(shardID),
+		ShardIteratorType: aws.String(iteratorType),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.ShardIterator, nil
+}
+
+// poll calls apply with the records available in each open shard. A shard
+// is not read until its parent has been read to the end, so that the
+// changes to each row are applied in order. If apply returns an error,
+// poll returns it, and the records of its page are read again by the next
+// poll.
+func (r *streamReader) poll(apply func(*dynamodbstreams.Record) error) error {
+	if err := r.discoverShards(false); err != nil {
+		return err
+	}
+	for shardID, s := range r.shards {
+		if s.closed {
+			continue
+		}
+		if s.iterator == nil {
+			if parent, ok := r.shards[s.parent]; ok && !parent.closed {
+				continue
+			}
+			iterator, err := r.shardIterator(shardID, dynamodbstreams.ShardIteratorTypeTrimHorizon)
+			if err != nil {
+				return err
+			}
+			s.iterator = iterator
+		}
+		for s.iterator != nil {
+			resp, err := r.streams.GetRecords(&dynamodbstreams.GetRecordsInput{
+				ShardIterator: s.iterator,
+			})
+			if err != nil {
+				return err
+			}
+			for _, record := range resp.Records {
+				if err := apply(record); err != nil {
+					return err
+				}
+			}
+			s.iterator = resp.NextShardIterator
+			if len(resp.Records) == 0 {
+				break
+			}
+		}
+		if s.iterator == nil {
+			s.closed = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package dynamotree
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// SubjectBundle holds everything the tree stores about the keys at and
+// below Prefix, as gathered by ExportSubject, for example to answer a
+// data subject's request for their data.
+type SubjectBundle struct {
+	Prefix []string
+
+	// Objects are the objects stored at and below Prefix, and Links the
+	// symbolic links.
+	Objects []SubjectObject
+	Links   []SubjectLink
+
+	// Referrers are the links stored elsewhere in the tree that point at
+	// keys at or below Prefix, as recorded by Tree.MaintainBacklinks.
+	Referrers []SubjectLink
+
+	// Versions are the versions of the objects and links, including those
+	// since deleted, recorded by Tree.KeepVersions, oldest first for each
+	// key.
+	Versions []SubjectVersion
+
+	// Events are the events appended to the keys by AppendEvent.
+	Events []SubjectEvent
+}
+
+// SubjectObject is an object in a SubjectBundle.
+type SubjectObject struct {
+	Key  []string
+	Item map[string]*dynamodb.AttributeValue
+}
+
+// SubjectLink is a link from Key to Target in a SubjectBundle.
+type SubjectLink struct {
+	Key    []string
+	Target []string
+}
+
+// SubjectVersion is a version of the object or link at Key in a
+// SubjectBundle. Deleted is true for the version recording that the key
+// was deleted.
+type SubjectVersion struct {
+	Key        []string
+	Time       time.Time
+	Deleted    bool
+	Item       map[string]*dynamodb.AttributeValue
+	LinkTarget []string
+}
+
+// SubjectEvent is an event appended to Key in a SubjectBundle.
+type SubjectEvent struct {
+	Key []string
+	Event
+}
+
+// ExportSubject gathers the objects and links stored at and below prefix,
+// the links elsewhere that point into it, and the versions and events of
+// every key below it, including keys since deleted, into a bundle. Given
+// Redacted, the attributes that Tree.Redactor considers sensitive are
+// masked.
+//
+// ExportSubject issues a Query for each key it visits, as Walk does, and
+// another for each key whose history was recorded, so gathering a large
+// subtree is expensive.
+func (t *Tree) ExportSubject(prefix []string, opts ...Option) (bundle *SubjectBundle, err error) {
+	defer annotateError(&err, "ExportSubject", prefix)
+	o, cancel := newCallOptions(opts)
+	defer cancel()
+	o.list = listBudget{} // the limits of List do not apply to each directory
+	if err := refuseCapability("ExportSubject", prefix, o); err != nil {
+		return nil, err
+	}
+	if err := t.ready(); err != nil {
+		return nil, err
+	}
+	prefix, err = t.transformKey(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.ValidateKey(prefix); err != nil {
+		return nil, err
+	}
+	bundle = &SubjectBundle{Prefix: prefix}
+	if err := t.exportSubject(bundle, prefix, o); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// exportSubject adds the rows of key, and of the keys below it, to bundle.
+func (t *Tree) exportSubject(bundle *SubjectBundle, key []string, o *callOptions) error {
+	item := func(row map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+		rv := objectAttributes(t, row)
+		if o.redact {
+			rv = t.Redact(key, rv)
+		}
+		return rv
+	}
+
+	eventsChild, versionsChild, backlinksChild := t.eventsChild(), t.versionsChild(), t.backlinksChild()
+	var innerErr error
+	err := t.DB.QueryPagesWithContext(o.context(), &dynamodb.QueryInput{
+		TableName:              aws.String(t.TableName),
+		ConsistentRead:         o.consistent(),
+		KeyConditionExpression: aws.String("#K = :key"),
+		ExpressionAttributeNames: map[string]*string{
+			"#K": aws.String("Key"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":key": &dynamodb.AttributeValue{S: aws.String(t.EncodeKey(key))},
+		}}, func(p *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, row := range p.Items {
+			child := aws.StringValue(row["Child"].S)
+			switch {
+			case child == t.SpecialCharacter:
+				if target, isLink := t.linkTarget(row); isLink {
+					bundle.Links = append(bundle.Links, SubjectLink{Key: key, Target: t.DecodeKey(target)})
+				} else {
+					bundle.Objects = append(bundle.Objects, SubjectObject{Key: key, Item: item(row)})
+				}
+			case strings.HasPrefix(child, eventsChild):
+				event := Event{ID: strings.TrimPrefix(child, eventsChild), Item: item(row)}
+				if event.Time, innerErr = timeOrderedIDTime(event.ID); innerErr != nil {
+					return false
+				}
+				bundle.Events = append(bundle.Events, SubjectEvent{Key: key, Event: event})
+			case strings.HasPrefix(child, versionsChild):
+				version := SubjectVersion{Key: key}
+				if version.Time, innerErr = timeOrderedIDTime(strings.TrimPrefix(child, versionsChild)); innerErr != nil {
+					return false
+				}
+				if _, deleted := row[t.deletedAttribute()]; deleted {
+					version.Deleted = true
+				} else if target, isLink := t.linkTarget(row); isLink {
+					version.LinkTarget = t.DecodeKey(target)
+				} else {
+					version.Item = item(row)
+				}
+				bundle.Versions = append(bundle.Versions, version)
+			case strings.HasPrefix(child, backlinksChild):
+				link := t.DecodeKey(strings.TrimPrefix(child, backlinksChild))
+				if !hasKeyPrefix(link, bundle.Prefix) {
+					bundle.Referrers = append(bundle.Referrers, SubjectLink{Key: link, Target: key})
+				}
+			}
+		}
+		return true
+	}, o.request()...)
+	if err == nil {
+		err = innerErr
+	}
+	if err != nil {
+		return err
+	}
+
+	names, err := t.childNamesEver(key, o)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		child := make([]string, len(key), len(key)+1)
+		copy(child, key)
+		if err := t.exportSubject(bundle, append(child, name), o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the bundle to w as a JSON document, in which each
+// item's attributes are given as ExportArchive writes them.
+func (b *SubjectBundle) WriteJSON(w io.Writer) error {
+	type object struct {
+		Key  []string               `json:"key"`
+		Item map[string]interface{} `json:"item"`
+	}
+	type link struct {
+		Key    []string `json:"key"`
+		Target []string `json:"target"`
+	}
+	type version struct {
+		Key        []string               `json:"key"`
+		Time       time.Time              `json:"time"`
+		Deleted    bool                   `json:"deleted,omitempty"`
+		Item       map[string]interface{} `json:"item,omitempty"`
+		LinkTarget []string               `json:"linkTarget,omitempty"`
+	}
+	type event struct {
+		Key  []string               `json:"key"`
+		ID   string                 `json:"id"`
+		Time time.Time              `json:"time"`
+		Item map[string]interface{} `json:"item"`
+	}
+	doc := struct {
+		Prefix    []string  `json:"prefix"`
+		Objects   []object  `json:"objects"`
+		Links     []link    `json:"links"`
+		Referrers []link    `json:"referrers"`
+		Versions  []version `json:"versions"`
+		Events    []event   `json:"events"`
+	}{Prefix: b.Prefix, Objects: []object{}, Links: []link{}, Referrers: []link{}, Versions: []version{}, Events: []event{}}
+	for _, o := range b.Objects {
+		doc.Objects = append(doc.Objects, object{Key: o.Key, Item: attributesToJSON(o.Item)})
+	}
+	for _, l := range b.Links {
+		doc.Links = append(doc.Links, link{Key: l.Key, Target: l.Target})
+	}
+	for _, l := range b.Referrers {
+		doc.Referrers = append(doc.Referrers, link{Key: l.Key, Target: l.Target})
+	}
+	for _, v := range b.Versions {
+		rv := version{Key: v.Key, Time: v.Time, Deleted: v.Deleted, LinkTarget: v.LinkTarget}
+		if v.Item != nil {
+			rv.Item = attributesToJSON(v.Item)
+		}
+		doc.Versions = append(doc.Versions, rv)
+	}
+	for _, e := range b.Events {
+		doc.Events = append(doc.Events, event{Key: e.Key, ID: e.ID, Time: e.Time, Item: attributesToJSON(e.Item)})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
+}
@@ -0,0 +1,83 @@
+package dynamotree
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestExportSubject(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db, KeepVersions: true, MaintainBacklinks: true,
+		Redactor: RedactAttributes{"Email"}}
+	c.Assert(s.CreateTable(), IsNil)
+	alice := AccountT{ID: "12345", Name: "alice", Email: "alice@example.com"}
+	c.Assert(s.Put([]string{"Users", "alice", "Account"}, &alice), IsNil)
+	c.Assert(s.Put([]string{"Users", "alice", "Old"}, &alice), IsNil)
+	c.Assert(s.Delete([]string{"Users", "alice", "Old"}), IsNil)
+	c.Assert(s.PutLink([]string{"Users", "alice", "Self"}, []string{"Users", "alice", "Account"}), IsNil)
+	c.Assert(s.PutLink([]string{"Teams", "admins", "alice"}, []string{"Users", "alice", "Account"}), IsNil)
+	c.Assert(s.Put([]string{"Users", "bob", "Account"}, &AccountT{ID: "6789", Name: "bob"}), IsNil)
+	_, err := s.AppendEvent([]string{"Users", "alice", "Account"}, &AccountT{Name: "login"})
+	c.Assert(err, IsNil)
+
+	bundle, err := s.ExportSubject([]string{"Users", "alice"})
+	c.Assert(err, IsNil)
+	c.Assert(bundle.Prefix, DeepEquals, []string{"Users", "alice"})
+	c.Assert(bundle.Objects, HasLen, 1)
+	c.Assert(bundle.Objects[0].Key, DeepEquals, []string{"Users", "alice", "Account"})
+	c.Assert(aws.StringValue(bundle.Objects[0].Item["Email"].S), Equals, "alice@example.com")
+	c.Assert(bundle.Objects[0].Item["Key"], IsNil)
+	c.Assert(bundle.Links, DeepEquals, []SubjectLink{
+		{Key: []string{"Users", "alice", "Self"}, Target: []string{"Users", "alice", "Account"}},
+	})
+	c.Assert(bundle.Referrers, DeepEquals, []SubjectLink{
+		{Key: []string{"Teams", "admins", "alice"}, Target: []string{"Users", "alice", "Account"}},
+	})
+	c.Assert(bundle.Events, HasLen, 1)
+	c.Assert(aws.StringValue(bundle.Events[0].Item["Name"].S), Equals, "login")
+
+	// The versions include those of the deleted object
+	versions := map[string][]bool{}
+	for _, version := range bundle.Versions {
+		versions[version.Key[2]] = append(versions[version.Key[2]], version.Deleted)
+		if !version.Deleted && version.LinkTarget == nil {
+			c.Assert(aws.StringValue(version.Item["Name"].S), Equals, "alice")
+		}
+	}
+	c.Assert(versions, DeepEquals, map[string][]bool{
+		"Account": {false},
+		"Old":     {false, true},
+		"Self":    {false},
+	})
+
+	// Redacted masks the attributes in each part of the bundle
+	bundle, err = s.ExportSubject([]string{"Users", "alice"}, Redacted())
+	c.Assert(err, IsNil)
+	c.Assert(aws.StringValue(bundle.Objects[0].Item["Email"].S), Equals, RedactedValue)
+	for _, version := range bundle.Versions {
+		if version.Item != nil {
+			c.Assert(aws.StringValue(version.Item["Email"].S), Equals, RedactedValue)
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(bundle.WriteJSON(buf), IsNil)
+	var doc map[string]interface{}
+	c.Assert(json.Unmarshal(buf.Bytes(), &doc), IsNil)
+	c.Assert(doc["objects"], HasLen, 1)
+	c.Assert(doc["referrers"], HasLen, 1)
+	c.Assert(doc["versions"], HasLen, 4)
+	c.Assert(doc["events"], HasLen, 1)
+
+	// A key with nothing stored gives an empty bundle
+	bundle, err = s.ExportSubject([]string{"Users", "carol"})
+	c.Assert(err, IsNil)
+	c.Assert(bundle.Objects, HasLen, 0)
+	c.Assert(bundle.Versions, HasLen, 0)
+}
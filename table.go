@@ -0,0 +1,259 @@
+package dynamotree
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// TableDefinition describes the DynamoDB table that a Tree expects. It is
+// what CreateTable creates, and can be rendered for CloudFormation or
+// Terraform, so that tables managed as infrastructure-as-code match the
+// library's expectations.
+type TableDefinition struct {
+	TableName string `json:"TableName"`
+
+	// HashKey and RangeKey are the names of the table's key attributes,
+	// which are both strings.
+	HashKey  string `json:"HashKey"`
+	RangeKey string `json:"RangeKey"`
+
+	ReadCapacityUnits  int64 `json:"ReadCapacityUnits"`
+	WriteCapacityUnits int64 `json:"WriteCapacityUnits"`
+
+	// GlobalSecondaryIndexes are the indexes the table must have.
+	GlobalSecondaryIndexes []IndexDefinition `json:"GlobalSecondaryIndexes,omitempty"`
+
+	// TimeToLiveAttribute is the name of the attribute holding each row's
+	// expiry time, or empty if the table does not use TTL.
+	TimeToLiveAttribute string `json:"TimeToLiveAttribute,omitempty"`
+
+	// StreamViewType is the kind of stream the table must have, or empty if
+	// none is required. Mirror requires NEW_IMAGE or NEW_AND_OLD_IMAGES.
+	StreamViewType string `json:"StreamViewType,omitempty"`
+}
+
+// IndexDefinition describes a global secondary index. The key attributes
+// of the index are strings, and all attributes are projected into it.
+type IndexDefinition struct {
+	IndexName string `json:"IndexName"`
+	HashKey   string `json:"HashKey"`
+	RangeKey  string `json:"RangeKey,omitempty"`
+}
+
+// TableDefinition returns a description of the table the tree expects.
+func (t *Tree) TableDefinition() *TableDefinition {
+	t.initOnce.Do(t.init)
+	d := &TableDefinition{
+		TableName:           t.TableName,
+		HashKey:             t.KeySchema.hashAttribute(),
+		RangeKey:            t.KeySchema.rangeAttribute(),
+		ReadCapacityUnits:   t.ReadCapacityUnits,
+		WriteCapacityUnits:  t.WriteCapacityUnits,
+		TimeToLiveAttribute: t.TTLAttribute,
+	}
+	if d.ReadCapacityUnits == 0 {
+		d.ReadCapacityUnits = 1
+	}
+	if d.WriteCapacityUnits == 0 {
+		d.WriteCapacityUnits = 1
+	}
+	return d
+}
+
+// attributeNames returns the names of the key attributes of the table and
+// its indexes, without duplicates.
+func (d *TableDefinition) attributeNames() []string {
+	names := []string{}
+	seen := map[string]bool{}
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	add(d.HashKey)
+	add(d.RangeKey)
+	for _, index := range d.GlobalSecondaryIndexes {
+		add(index.HashKey)
+		add(index.RangeKey)
+	}
+	return names
+}
+
+func keySchema(hashKey, rangeKey string) []*dynamodb.KeySchemaElement {
+	rv := []*dynamodb.KeySchemaElement{
+		{
+			AttributeName: aws.String(hashKey),
+			KeyType:       aws.String(dynamodb.KeyTypeHash),
+		},
+	}
+	if rangeKey != "" {
+		rv = append(rv, &dynamodb.KeySchemaElement{
+			AttributeName: aws.String(rangeKey),
+			KeyType:       aws.String(dynamodb.KeyTypeRange),
+		})
+	}
+	return rv
+}
+
+// CreateTableInput returns the request that creates the table. TTL cannot
+// be enabled when a table is created, so it is not included.
+func (d *TableDefinition) CreateTableInput() *dynamodb.CreateTableInput {
+	throughput := &dynamodb.ProvisionedThroughput{
+		ReadCapacityUnits:  aws.Int64(d.ReadCapacityUnits),
+		WriteCapacityUnits: aws.Int64(d.WriteCapacityUnits),
+	}
+	input := &dynamodb.CreateTableInput{
+		TableName:             aws.String(d.TableName),
+		KeySchema:             keySchema(d.HashKey, d.RangeKey),
+		ProvisionedThroughput: throughput,
+	}
+	for _, name := range d.attributeNames() {
+		input.AttributeDefinitions = append(input.AttributeDefinitions, &dynamodb.AttributeDefinition{
+			AttributeName: aws.String(name),
+			AttributeType: aws.String(dynamodb.ScalarAttributeTypeS),
+		})
+	}
+	for _, index := range d.GlobalSecondaryIndexes {
+		input.GlobalSecondaryIndexes = append(input.GlobalSecondaryIndexes, &dynamodb.GlobalSecondaryIndex{
+			IndexName:             aws.String(index.IndexName),
+			KeySchema:             keySchema(index.HashKey, index.RangeKey),
+			Projection:            &dynamodb.Projection{ProjectionType: aws.String(dynamodb.ProjectionTypeAll)},
+			ProvisionedThroughput: throughput,
+		})
+	}
+	if d.StreamViewType != "" {
+		input.StreamSpecification = &dynamodb.StreamSpecification{
+			StreamEnabled:  aws.Bool(true),
+			StreamViewType: aws.String(d.StreamViewType),
+		}
+	}
+	return input
+}
+
+// CloudFormation returns an AWS::DynamoDB::Table resource describing the
+// table, as JSON.
+func (d *TableDefinition) CloudFormation() ([]byte, error) {
+	type keySchemaElement struct {
+		AttributeName string
+		KeyType       string
+	}
+	type attributeDefinition struct {
+		AttributeName string
+		AttributeType string
+	}
+	type throughput struct {
+		ReadCapacityUnits  int64
+		WriteCapacityUnits int64
+	}
+	type projection struct {
+		ProjectionType string
+	}
+	type index struct {
+		IndexName             string
+		KeySchema             []keySchemaElement
+		Projection            projection
+		ProvisionedThroughput throughput
+	}
+	type ttl struct {
+		AttributeName string
+		Enabled       bool
+	}
+	type stream struct {
+		StreamViewType string
+	}
+	type properties struct {
+		TableName               string
+		KeySchema               []keySchemaElement
+		AttributeDefinitions    []attributeDefinition
+		ProvisionedThroughput   throughput
+		GlobalSecondaryIndexes  []index `json:",omitempty"`
+		TimeToLiveSpecification *ttl    `json:",omitempty"`
+		StreamSpecification     *stream `json:",omitempty"`
+	}
+
+	toKeySchema := func(hashKey, rangeKey string) []keySchemaElement {
+		rv := []keySchemaElement{}
+		for _, e := range keySchema(hashKey, rangeKey) {
+			rv = append(rv, keySchemaElement{*e.AttributeName, *e.KeyType})
+		}
+		return rv
+	}
+	tp := throughput{d.ReadCapacityUnits, d.WriteCapacityUnits}
+	p := properties{
+		TableName:             d.TableName,
+		KeySchema:             toKeySchema(d.HashKey, d.RangeKey),
+		ProvisionedThroughput: tp,
+	}
+	for _, name := range d.attributeNames() {
+		p.AttributeDefinitions = append(p.AttributeDefinitions,
+			attributeDefinition{name, dynamodb.ScalarAttributeTypeS})
+	}
+	for _, i := range d.GlobalSecondaryIndexes {
+		p.GlobalSecondaryIndexes = append(p.GlobalSecondaryIndexes, index{
+			IndexName:             i.IndexName,
+			KeySchema:             toKeySchema(i.HashKey, i.RangeKey),
+			Projection:            projection{dynamodb.ProjectionTypeAll},
+			ProvisionedThroughput: tp,
+		})
+	}
+	if d.TimeToLiveAttribute != "" {
+		p.TimeToLiveSpecification = &ttl{d.TimeToLiveAttribute, true}
+	}
+	if d.StreamViewType != "" {
+		p.StreamSpecification = &stream{d.StreamViewType}
+	}
+
+	return json.MarshalIndent(struct {
+		Type       string
+		Properties properties
+	}{"AWS::DynamoDB::Table", p}, "", "  ")
+}
+
+var nonIdentifierCharacters = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// Terraform returns an aws_dynamodb_table resource describing the table.
+// The resource is named after the table.
+func (d *TableDefinition) Terraform() string {
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "resource \"aws_dynamodb_table\" %q {\n",
+		nonIdentifierCharacters.ReplaceAllString(d.TableName, "_"))
+	fmt.Fprintf(buf, "  name           = %q\n", d.TableName)
+	fmt.Fprintf(buf, "  hash_key       = %q\n", d.HashKey)
+	if d.RangeKey != "" {
+		fmt.Fprintf(buf, "  range_key      = %q\n", d.RangeKey)
+	}
+	fmt.Fprintf(buf, "  read_capacity  = %d\n", d.ReadCapacityUnits)
+	fmt.Fprintf(buf, "  write_capacity = %d\n", d.WriteCapacityUnits)
+
+	for _, name := range d.attributeNames() {
+		fmt.Fprintf(buf, "\n  attribute {\n    name = %q\n    type = %q\n  }\n",
+			name, dynamodb.ScalarAttributeTypeS)
+	}
+	for _, index := range d.GlobalSecondaryIndexes {
+		fmt.Fprintf(buf, "\n  global_secondary_index {\n")
+		fmt.Fprintf(buf, "    name            = %q\n", index.IndexName)
+		fmt.Fprintf(buf, "    hash_key        = %q\n", index.HashKey)
+		if index.RangeKey != "" {
+			fmt.Fprintf(buf, "    range_key       = %q\n", index.RangeKey)
+		}
+		fmt.Fprintf(buf, "    projection_type = %q\n", dynamodb.ProjectionTypeAll)
+		fmt.Fprintf(buf, "    read_capacity   = %d\n", d.ReadCapacityUnits)
+		fmt.Fprintf(buf, "    write_capacity  = %d\n", d.WriteCapacityUnits)
+		fmt.Fprintf(buf, "  }\n")
+	}
+	if d.TimeToLiveAttribute != "" {
+		fmt.Fprintf(buf, "\n  ttl {\n    attribute_name = %q\n    enabled        = true\n  }\n",
+			d.TimeToLiveAttribute)
+	}
+	if d.StreamViewType != "" {
+		fmt.Fprintf(buf, "\n  stream_enabled   = true\n  stream_view_type = %q\n", d.StreamViewType)
+	}
+	fmt.Fprintf(buf, "}\n")
+	return buf.String()
+}
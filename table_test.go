@@ -0,0 +1,152 @@
+package dynamotree
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestTableDefinition(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	err := s.CreateTable()
+	c.Assert(err, IsNil)
+
+	d := s.TableDefinition()
+	resp, err := db.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+	c.Assert(err, IsNil)
+	c.Assert(resp.Table.KeySchema, DeepEquals, d.CreateTableInput().KeySchema)
+
+	buf, err := json.Marshal(d)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf), Equals, `{"TableName":"`+tableName+`","HashKey":"Key","RangeKey":"Child",`+
+		`"ReadCapacityUnits":1,"WriteCapacityUnits":1}`)
+
+	// The TTL the tree depends on is part of the table's definition.
+	s.TTLAttribute = "Expires"
+	c.Assert(s.TableDefinition().TimeToLiveAttribute, Equals, "Expires")
+	c.Assert(s.TableDefinition().Terraform(), Matches, `(?s).*attribute_name = "Expires".*`)
+}
+
+func (suite *StoreImplTest) TestTableDefinitionRendering(c *C) {
+	d := &TableDefinition{
+		TableName:          "my-table",
+		HashKey:            "Key",
+		RangeKey:           "Child",
+		ReadCapacityUnits:  5,
+		WriteCapacityUnits: 2,
+		GlobalSecondaryIndexes: []IndexDefinition{
+			{IndexName: "ByChild", HashKey: "Child", RangeKey: "Key"},
+		},
+		TimeToLiveAttribute: "Expires",
+		StreamViewType:      dynamodb.StreamViewTypeNewImage,
+	}
+
+	input := d.CreateTableInput()
+	c.Assert(input.AttributeDefinitions, HasLen, 2)
+	c.Assert(input.GlobalSecondaryIndexes, HasLen, 1)
+	c.Assert(*input.StreamSpecification.StreamViewType, Equals, "NEW_IMAGE")
+
+	cfn, err := d.CloudFormation()
+	c.Assert(err, IsNil)
+	c.Assert(string(cfn), Equals, `{
+  "Type": "AWS::DynamoDB::Table",
+  "Properties": {
+    "TableName": "my-table",
+    "KeySchema": [
+      {
+        "AttributeName": "Key",
+        "KeyType": "HASH"
+      },
+      {
+        "AttributeName": "Child",
+        "KeyType": "RANGE"
+      }
+    ],
+    "AttributeDefinitions": [
+      {
+        "AttributeName": "Key",
+        "AttributeType": "S"
+      },
+      {
+        "AttributeName": "Child",
+        "AttributeType": "S"
+      }
+    ],
+    "ProvisionedThroughput": {
+      "ReadCapacityUnits": 5,
+      "WriteCapacityUnits": 2
+    },
+    "GlobalSecondaryIndexes": [
+      {
+        "IndexName": "ByChild",
+        "KeySchema": [
+          {
+            "AttributeName": "Child",
+            "KeyType": "HASH"
+          },
+          {
+            "AttributeName": "Key",
+            "KeyType": "RANGE"
+          }
+        ],
+        "Projection": {
+          "ProjectionType": "ALL"
+        },
+        "ProvisionedThroughput": {
+          "ReadCapacityUnits": 5,
+          "WriteCapacityUnits": 2
+        }
+      }
+    ],
+    "TimeToLiveSpecification": {
+      "AttributeName": "Expires",
+      "Enabled": true
+    },
+    "StreamSpecification": {
+      "StreamViewType": "NEW_IMAGE"
+    }
+  }
+}`)
+
+	c.Assert(d.Terraform(), Equals, `resource "aws_dynamodb_table" "my_table" {
+  name           = "my-table"
+  hash_key       = "Key"
+  range_key      = "Child"
+  read_capacity  = 5
+  write_capacity = 2
+
+  attribute {
+    name = "Key"
+    type = "S"
+  }
+
+  attribute {
+    name = "Child"
+    type = "S"
+  }
+
+  global_secondary_index {
+    name            = "ByChild"
+    hash_key        = "Child"
+    range_key       = "Key"
+    projection_type = "ALL"
+    read_capacity   = 5
+    write_capacity  = 2
+  }
+
+  ttl {
+    attribute_name = "Expires"
+    enabled        = true
+  }
+
+  stream_enabled   = true
+  stream_view_type = "NEW_IMAGE"
+}
+`)
+}
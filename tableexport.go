@@ -0,0 +1,475 @@
+package dynamotree
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// The functions in this file read and write the DYNAMODB_JSON format used
+// by DynamoDB's table export to S3 and import from S3. Each line of a data
+// file holds one row of the table:
+//
+//	{"Item":{"Key":{"S":"¦Accounts¦alice"},"Child":{"S":"¦"},"Name":{"S":"alice"}}}
+//
+// Exports are written by DynamoDB as gzip-compressed files listed in the
+// export's manifest-files.json, which ReadExportManifest reads.
+
+// ExportRowKind identifies what a row read by ReadTableExport stores.
+type ExportRowKind int
+
+// The kinds of row found in a table export.
+const (
+	// ExportObject is the row of an object.
+	ExportObject ExportRowKind = iota
+
+	// ExportLink is the row of a symbolic link.
+	ExportLink
+
+	// ExportDirectoryEntry is the entry naming Key in its directory.
+	ExportDirectoryEntry
+
+	// ExportAuxiliary is a row stored alongside the object at Key, such as
+	// an event or a backlink.
+	ExportAuxiliary
+
+	// ExportMetadata is the tree's metadata row. Its Key is nil.
+	ExportMetadata
+)
+
+// ExportRow is a row read by ReadTableExport.
+type ExportRow struct {
+	Kind ExportRowKind
+
+	// Key is the key of the tree the row belongs to.
+	Key []string
+
+	// LinkTarget is the target of a link, if Kind is ExportLink.
+	LinkTarget []string
+
+	// Row holds every attribute of the row, including Key and Child, as
+	// it is stored in the table.
+	Row map[string]*dynamodb.AttributeValue
+}
+
+// Item returns the attributes of the row other than Key and Child, for
+// example to unmarshal an object.
+func (r *ExportRow) Item() map[string]*dynamodb.AttributeValue {
+	item := make(map[string]*dynamodb.AttributeValue, len(r.Row))
+	for name, value := range r.Row {
+		if name != "Key" && name != "Child" {
+			item[name] = value
+		}
+	}
+	return item
+}
+
+// WriteTableExport writes to w every row that stores the part of the tree
+// at and below prefix, in the format of a DynamoDB table export, so that
+// the data can be loaded into a new table using DynamoDB's import from S3
+// with the input format DYNAMODB_JSON. The rows written include the
+// directory entries leading to prefix, the events and backlinks of each
+// object, and the tree's metadata row, so that the imported table is a
+// complete tree. Rows are written as they are stored, without
+// compression; wrap w with gzip.NewWriter and choose the GZIP compression
+// type when importing to reduce the size of the data. Given Redacted, the
+// attributes that Tree.Redactor considers sensitive are masked, including
+// those of the versions and events stored alongside each object.
+func (t *Tree) WriteTableExport(prefix []string, w io.Writer, opts ...Option) (err error) {
+	defer annotateError(&err, "WriteTableExport", prefix)
+	o, cancel := newCallOptions(opts)
+	defer cancel()
+	if err := refuseCapability("WriteTableExport", prefix, o); err != nil {
+		return err
+	}
+	if err := t.ready(); err != nil {
+		return err
+	}
+	prefix, err = t.transformKey(prefix)
+	if err != nil {
+		return err
+	}
+
+	e := &tableExporter{tree: t, o: o, w: bufio.NewWriter(w)}
+	metadata, err := t.getItem(MetadataKey, metadataChild, o)
+	if err != nil {
+		return err
+	}
+	if metadata != nil {
+		if err := e.write(metadata); err != nil {
+			return err
+		}
+	}
+	_, ancestors := t.directoryRequests(prefix)
+	for _, writeRequest := range ancestors {
+		if err := e.write(writeRequest.PutRequest.Item); err != nil {
+			return err
+		}
+	}
+	if err := e.export(prefix); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+type tableExporter struct {
+	tree *Tree
+	o    *callOptions
+	w    *bufio.Writer
+}
+
+// export writes the rows stored at key, followed by those of each of its
+// descendants.
+func (e *tableExporter) export(key []string) error {
+	t := e.tree
+	children := []string{}
+	collect := func(row map[string]*dynamodb.AttributeValue) {
+		child := aws.StringValue(row["Child"].S)
+		if !strings.HasPrefix(child, t.SpecialCharacter) {
+			children = append(children, t.decodePart(child))
+		}
+	}
+
+	pathKey, dirKey := t.EncodeKey(key), t.dirKey(key)
+	if err := e.exportRows(pathKey, collect); err != nil {
+		return err
+	}
+	// The rows of the root object and the root directory share a Key.
+	if dirKey != pathKey {
+		if err := e.exportRows(dirKey, collect); err != nil {
+			return err
+		}
+	}
+
+	for _, child := range children {
+		childKey := make([]string, len(key), len(key)+1)
+		copy(childKey, key)
+		if err := e.export(append(childKey, child)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportRows writes every row whose Key is pathKey, and calls fn with each.
+func (e *tableExporter) exportRows(pathKey string, fn func(map[string]*dynamodb.AttributeValue)) error {
+	t := e.tree
+	var innerErr error
+	err := t.DB.QueryPagesWithContext(e.o.context(), &dynamodb.QueryInput{
+		TableName:              aws.String(t.TableName),
+		ConsistentRead:         e.o.consistent(),
+		KeyConditionExpression: aws.String("#K = :key"),
+		ExpressionAttributeNames: map[string]*string{
+			"#K": aws.String("Key"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":key": &dynamodb.AttributeValue{S: aws.String(pathKey)},
+		}}, func(p *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, row := range p.Items {
+			if innerErr = e.write(row); innerErr != nil {
+				return false
+			}
+			fn(row)
+		}
+		return true
+	}, e.o.request()...)
+	if err == nil {
+		err = innerErr
+	}
+	return err
+}
+
+// write writes row as a line of the export.
+func (e *tableExporter) write(row map[string]*dynamodb.AttributeValue) error {
+	row, err := e.tree.redactRow(row, e.o)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(map[string]interface{}{"Item": attributesToDynamoDBJSON(row)})
+	if err != nil {
+		return err
+	}
+	if _, err := e.w.Write(buf); err != nil {
+		return err
+	}
+	return e.w.WriteByte('\n')
+}
+
+// ReadTableExport reads a data file of a DynamoDB table export in the
+// DYNAMODB_JSON format from r, such as one written by WriteTableExport or
+// by DynamoDB's export to S3, and calls fn with each row, identifying the
+// key of the tree it belongs to. Data files compressed with gzip, as
+// DynamoDB writes them, are decompressed. Rows appear in the order in
+// which they were written, which for DynamoDB's exports is not the order
+// of the keys. Rows that do not belong to the tree, such as those written
+// using a different SpecialCharacter, are skipped.
+//
+// If fn returns false, ReadTableExport stops. If an error occurs, fn is
+// called with nil and the error.
+func (t *Tree) ReadTableExport(r io.Reader, fn func(*ExportRow, error) bool) {
+	t.initOnce.Do(t.init)
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			fn(nil, err)
+			return
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+
+	decoder := json.NewDecoder(br)
+	for line := 1; ; line++ {
+		var record struct {
+			Item map[string]map[string]interface{}
+		}
+		if err := decoder.Decode(&record); err == io.EOF {
+			return
+		} else if err != nil {
+			fn(nil, fmt.Errorf("line %d: %s", line, err))
+			return
+		}
+		row, err := dynamoDBJSONToAttributes(record.Item)
+		if err != nil {
+			fn(nil, fmt.Errorf("line %d: %s", line, err))
+			return
+		}
+		exportRow, err := t.exportRow(row)
+		if err != nil {
+			fn(nil, fmt.Errorf("line %d: %s", line, err))
+			return
+		}
+		if exportRow != nil && !fn(exportRow, nil) {
+			return
+		}
+	}
+}
+
+// exportRow identifies the key of the tree to which row belongs, or returns
+// nil if it does not belong to the tree.
+func (t *Tree) exportRow(row map[string]*dynamodb.AttributeValue) (*ExportRow, error) {
+	if row["Key"] == nil || row["Key"].S == nil || row["Child"] == nil || row["Child"].S == nil {
+		return nil, fmt.Errorf("row does not have string attributes Key and Child")
+	}
+	pathKey, childKey := *row["Key"].S, *row["Child"].S
+	exportRow := &ExportRow{Row: row}
+	switch {
+	case pathKey == MetadataKey:
+		exportRow.Kind = ExportMetadata
+	case !strings.HasPrefix(pathKey, t.SpecialCharacter):
+		return nil, nil
+	case childKey == t.SpecialCharacter:
+		exportRow.Key = t.DecodeKey(pathKey)
+		exportRow.Kind = ExportObject
+		if linkTarget, isLink := t.linkTarget(row); isLink {
+			exportRow.Kind = ExportLink
+			exportRow.LinkTarget = t.DecodeKey(linkTarget)
+		}
+	case strings.HasPrefix(childKey, t.SpecialCharacter):
+		exportRow.Key = t.DecodeKey(pathKey)
+		exportRow.Kind = ExportAuxiliary
+	default:
+		var prefix []string
+		if pathKey != t.SpecialCharacter {
+			prefix = t.DecodeKey(strings.TrimSuffix(pathKey, t.SpecialCharacter))
+		}
+		exportRow.Key = append(prefix, t.decodePart(childKey))
+		exportRow.Kind = ExportDirectoryEntry
+	}
+	return exportRow, nil
+}
+
+// ExportDataFile describes a data file of a DynamoDB table export.
+type ExportDataFile struct {
+	DataFileS3Key string `json:"dataFileS3Key"`
+	ItemCount     int64  `json:"itemCount"`
+	MD5Checksum   string `json:"md5Checksum"`
+	ETag          string `json:"etag"`
+}
+
+// ReadExportManifest reads the manifest-files.json of a DynamoDB table
+// export from r and returns the data files it lists, each of which can be
+// read using ReadTableExport.
+func ReadExportManifest(r io.Reader) ([]ExportDataFile, error) {
+	files := []ExportDataFile{}
+	decoder := json.NewDecoder(r)
+	for {
+		var file ExportDataFile
+		if err := decoder.Decode(&file); err == io.EOF {
+			return files, nil
+		} else if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+}
+
+func attributesToDynamoDBJSON(item map[string]*dynamodb.AttributeValue) map[string]map[string]interface{} {
+	rv := make(map[string]map[string]interface{}, len(item))
+	for k, v := range item {
+		rv[k] = attributeToDynamoDBJSON(v)
+	}
+	return rv
+}
+
+// attributeToDynamoDBJSON returns v in the form used by DynamoDB's
+// exports, such as {"S": "alice"} or {"N": "42"}.
+func attributeToDynamoDBJSON(v *dynamodb.AttributeValue) map[string]interface{} {
+	switch {
+	case v.S != nil:
+		return map[string]interface{}{"S": *v.S}
+	case v.N != nil:
+		return map[string]interface{}{"N": *v.N}
+	case v.BOOL != nil:
+		return map[string]interface{}{"BOOL": *v.BOOL}
+	case v.B != nil:
+		return map[string]interface{}{"B": base64.StdEncoding.EncodeToString(v.B)}
+	case v.M != nil:
+		return map[string]interface{}{"M": attributesToDynamoDBJSON(v.M)}
+	case v.L != nil:
+		l := make([]interface{}, len(v.L))
+		for i, e := range v.L {
+			l[i] = attributeToDynamoDBJSON(e)
+		}
+		return map[string]interface{}{"L": l}
+	case v.SS != nil:
+		return map[string]interface{}{"SS": aws.StringValueSlice(v.SS)}
+	case v.NS != nil:
+		return map[string]interface{}{"NS": aws.StringValueSlice(v.NS)}
+	case v.BS != nil:
+		bs := make([]string, len(v.BS))
+		for i, b := range v.BS {
+			bs[i] = base64.StdEncoding.EncodeToString(b)
+		}
+		return map[string]interface{}{"BS": bs}
+	}
+	return map[string]interface{}{"NULL": true}
+}
+
+func dynamoDBJSONToAttributes(item map[string]map[string]interface{}) (map[string]*dynamodb.AttributeValue, error) {
+	rv := make(map[string]*dynamodb.AttributeValue, len(item))
+	for k, v := range item {
+		value, err := dynamoDBJSONToAttribute(v)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %q: %s", k, err)
+		}
+		rv[k] = value
+	}
+	return rv, nil
+}
+
+// dynamoDBJSONToAttribute is the inverse of attributeToDynamoDBJSON.
+func dynamoDBJSONToAttribute(v map[string]interface{}) (*dynamodb.AttributeValue, error) {
+	if len(v) != 1 {
+		return nil, fmt.Errorf("expected a single type, found %d", len(v))
+	}
+	var typ string
+	var value interface{}
+	for typ, value = range v {
+	}
+
+	invalid := fmt.Errorf("invalid value of type %q", typ)
+	switch typ {
+	case "S", "N", "B":
+		s, ok := value.(string)
+		if !ok {
+			return nil, invalid
+		}
+		switch typ {
+		case "S":
+			return &dynamodb.AttributeValue{S: aws.String(s)}, nil
+		case "N":
+			return &dynamodb.AttributeValue{N: aws.String(s)}, nil
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return &dynamodb.AttributeValue{B: b}, nil
+
+	case "BOOL", "NULL":
+		b, ok := value.(bool)
+		if !ok {
+			return nil, invalid
+		}
+		if typ == "BOOL" {
+			return &dynamodb.AttributeValue{BOOL: aws.Bool(b)}, nil
+		}
+		return &dynamodb.AttributeValue{NULL: aws.Bool(b)}, nil
+
+	case "M":
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, invalid
+		}
+		rv := make(map[string]*dynamodb.AttributeValue, len(m))
+		for name, e := range m {
+			em, ok := e.(map[string]interface{})
+			if !ok {
+				return nil, invalid
+			}
+			ev, err := dynamoDBJSONToAttribute(em)
+			if err != nil {
+				return nil, err
+			}
+			rv[name] = ev
+		}
+		return &dynamodb.AttributeValue{M: rv}, nil
+
+	case "L":
+		l, ok := value.([]interface{})
+		if !ok {
+			return nil, invalid
+		}
+		rv := make([]*dynamodb.AttributeValue, len(l))
+		for i, e := range l {
+			em, ok := e.(map[string]interface{})
+			if !ok {
+				return nil, invalid
+			}
+			ev, err := dynamoDBJSONToAttribute(em)
+			if err != nil {
+				return nil, err
+			}
+			rv[i] = ev
+		}
+		return &dynamodb.AttributeValue{L: rv}, nil
+
+	case "SS", "NS", "BS":
+		l, ok := value.([]interface{})
+		if !ok {
+			return nil, invalid
+		}
+		strs := make([]string, len(l))
+		for i, e := range l {
+			if strs[i], ok = e.(string); !ok {
+				return nil, invalid
+			}
+		}
+		switch typ {
+		case "SS":
+			return &dynamodb.AttributeValue{SS: aws.StringSlice(strs)}, nil
+		case "NS":
+			return &dynamodb.AttributeValue{NS: aws.StringSlice(strs)}, nil
+		}
+		bs := make([][]byte, len(strs))
+		for i, s := range strs {
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, err
+			}
+			bs[i] = b
+		}
+		return &dynamodb.AttributeValue{BS: bs}, nil
+	}
+	return nil, fmt.Errorf("unknown type %q", typ)
+}
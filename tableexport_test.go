@@ -0,0 +1,122 @@
+package dynamotree
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestTableExport(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+
+	alice := AccountT{ID: "12345", Name: "alice", Email: "alice@example.com"}
+	bob := AccountT{ID: "6789", Name: "bob", Email: "bob@example.com"}
+	c.Assert(s.Put([]string{"Tenants", "t1", "Accounts", "12345"}, &alice), IsNil)
+	c.Assert(s.PutLink([]string{"Tenants", "t1", "ByEmail", "alice@example.com"},
+		[]string{"Tenants", "t1", "Accounts", "12345"}), IsNil)
+	_, err := s.AppendEvent([]string{"Tenants", "t1", "Accounts", "12345"}, &bob)
+	c.Assert(err, IsNil)
+	c.Assert(s.Put([]string{"Tenants", "t2", "Accounts", "6789"}, &bob), IsNil)
+
+	buf := bytes.NewBuffer(nil)
+	gz := gzip.NewWriter(buf)
+	c.Assert(s.WriteTableExport([]string{"Tenants", "t1"}, gz), IsNil)
+	c.Assert(gz.Close(), IsNil)
+
+	rows := []string{}
+	writeRequests := []*dynamodb.WriteRequest{}
+	s.ReadTableExport(bytes.NewReader(buf.Bytes()), func(row *ExportRow, err error) bool {
+		c.Assert(err, IsNil)
+		rows = append(rows, fmt.Sprintf("%d %s %s", row.Kind, strings.Join(row.Key, "/"), strings.Join(row.LinkTarget, "/")))
+		if row.Kind == ExportObject {
+			c.Assert(row.Item()["Name"], DeepEquals, &dynamodb.AttributeValue{S: aws.String("alice")})
+		}
+		writeRequests = append(writeRequests, &dynamodb.WriteRequest{
+			PutRequest: &dynamodb.PutRequest{Item: row.Row},
+		})
+		return true
+	})
+	c.Assert(rows, DeepEquals, []string{
+		"4  ",
+		"2 Tenants ",
+		"2 Tenants/t1 ",
+		"2 Tenants/t1/Accounts ",
+		"2 Tenants/t1/ByEmail ",
+		"2 Tenants/t1/Accounts/12345 ",
+		"0 Tenants/t1/Accounts/12345 ",
+		"3 Tenants/t1/Accounts/12345 ",
+		"2 Tenants/t1/ByEmail/alice@example.com ",
+		"1 Tenants/t1/ByEmail/alice@example.com Tenants/t1/Accounts/12345",
+	})
+
+	// Load the rows as DynamoDB's import would, into a new table
+	s2 := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s2.CreateTable(), IsNil)
+	c.Assert(s2.batchWrite(writeRequests, nil), IsNil)
+
+	var v AccountT
+	c.Assert(s2.Get([]string{"Tenants", "t1", "ByEmail", "alice@example.com"}, &v), IsNil)
+	c.Assert(v, DeepEquals, alice)
+	c.Assert(s2.Get([]string{"Tenants", "t2", "Accounts", "6789"}, &v), ErrorIs, ErrNotFound)
+	events := 0
+	s2.ReadEvents([]string{"Tenants", "t1", "Accounts", "12345"}, time.Time{}, func(event *Event, err error) bool {
+		c.Assert(err, IsNil)
+		events++
+		return true
+	})
+	c.Assert(events, Equals, 1)
+	keys := []string{}
+	s2.Walk(nil, func(key []string, err error) bool {
+		c.Assert(err, IsNil)
+		keys = append(keys, strings.Join(key, "/"))
+		return true
+	})
+	c.Assert(keys, DeepEquals, []string{"Tenants", "Tenants/t1", "Tenants/t1/Accounts",
+		"Tenants/t1/Accounts/12345", "Tenants/t1/ByEmail", "Tenants/t1/ByEmail/alice@example.com"})
+}
+
+func (suite *StoreImplTest) TestReadTableExport(c *C) {
+	s := &Tree{TableName: uniuri.New()}
+	export := `{"Item":{"Key":{"S":"¦Links"},"Child":{"S":"x"}}}
+{"Item":{"Key":{"S":"¦Links¦x"},"Child":{"S":"¦"},"N":{"N":"42"},"L":{"L":[{"BOOL":true},{"NULL":true}]},"M":{"M":{"SS":{"SS":["a","b"]}}},"B":{"B":"AAE="}}}
+{"Item":{"Key":{"S":"other"},"Child":{"S":"¦"}}}
+`
+	rows := []*ExportRow{}
+	s.ReadTableExport(strings.NewReader(export), func(row *ExportRow, err error) bool {
+		c.Assert(err, IsNil)
+		rows = append(rows, row)
+		return true
+	})
+	c.Assert(rows, HasLen, 2)
+	c.Assert(rows[0].Kind, Equals, ExportDirectoryEntry)
+	c.Assert(rows[0].Key, DeepEquals, []string{"Links", "x"})
+	c.Assert(rows[1].Kind, Equals, ExportObject)
+	c.Assert(rows[1].Item(), DeepEquals, map[string]*dynamodb.AttributeValue{
+		"N": {N: aws.String("42")},
+		"L": {L: []*dynamodb.AttributeValue{{BOOL: aws.Bool(true)}, {NULL: aws.Bool(true)}}},
+		"M": {M: map[string]*dynamodb.AttributeValue{"SS": {SS: aws.StringSlice([]string{"a", "b"})}}},
+		"B": {B: []byte{0, 1}},
+	})
+
+	var err error
+	s.ReadTableExport(strings.NewReader(`{"Item":{"Key":{"X":"¦"}}}`), func(row *ExportRow, innerErr error) bool {
+		err = innerErr
+		return true
+	})
+	c.Assert(err, ErrorMatches, `line 1: attribute "Key": unknown type "X"`)
+
+	files, err := ReadExportManifest(strings.NewReader(
+		`{"itemCount":3,"md5Checksum":"x","etag":"y","dataFileS3Key":"AWSDynamoDB/1/data/a.json.gz"}` + "\n"))
+	c.Assert(err, IsNil)
+	c.Assert(files, DeepEquals, []ExportDataFile{{DataFileS3Key: "AWSDynamoDB/1/data/a.json.gz", ItemCount: 3, MD5Checksum: "x", ETag: "y"}})
+}
@@ -0,0 +1,167 @@
+package dynamotree
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// ErrNoTenant is returned when Tree.TenantPartitions is set and an object,
+// link or event would be stored at the empty key, which belongs to no
+// tenant.
+var ErrNoTenant = errors.New("A key has no tenant")
+
+// TenantActions are the DynamoDB actions that TenantPolicy allows on the
+// rows of a tenant.
+var TenantActions = []string{
+	"dynamodb:GetItem",
+	"dynamodb:BatchGetItem",
+	"dynamodb:Query",
+	"dynamodb:PutItem",
+	"dynamodb:UpdateItem",
+	"dynamodb:DeleteItem",
+	"dynamodb:BatchWriteItem",
+}
+
+// checkTenant returns ErrNoTenant if Tree.TenantPartitions is set and key,
+// which is to be written, has no tenant.
+func (t *Tree) checkTenant(key []string) error {
+	if t.TenantPartitions && len(key) == 0 {
+		return ErrNoTenant
+	}
+	return nil
+}
+
+// stampTenant adds Tree.TenantAttribute, holding the tenant of key, to
+// each row that writeRequests put.
+func (t *Tree) stampTenant(key []string, writeRequests []*dynamodb.WriteRequest) {
+	if t.TenantAttribute == "" || len(key) == 0 {
+		return
+	}
+	tenant := &dynamodb.AttributeValue{S: aws.String(key[0])}
+	for _, writeRequest := range writeRequests {
+		if writeRequest.PutRequest != nil {
+			writeRequest.PutRequest.Item[t.TenantAttribute] = tenant
+		}
+	}
+}
+
+// tenantRows returns writeRequests without the rows stored in the root
+// partition, which holds no tenant's rows, if Tree.TenantPartitions is
+// set. Those are the entries of tenants in the root directory and the
+// history of the root, which PutTenant writes instead.
+func (t *Tree) tenantRows(writeRequests []*dynamodb.WriteRequest) []*dynamodb.WriteRequest {
+	if !t.TenantPartitions {
+		return writeRequests
+	}
+	rv := writeRequests[:0:0]
+	for _, writeRequest := range writeRequests {
+		if rowID(writeRequest).Key != t.dirKey(nil) {
+			rv = append(rv, writeRequest)
+		}
+	}
+	return rv
+}
+
+// PutTenant records tenant in the root directory, so that List and Walk
+// of the root find it, along with the history of the root that GetAsOf
+// and ListAsOf use when Tree.KeepVersions is set. When
+// Tree.TenantPartitions is set, Put and PutLink do not write these rows,
+// as they are shared by every tenant, so PutTenant must be called, with
+// credentials not confined to a tenant, for each tenant that should be
+// listed. Calling it again for a tenant already recorded has no effect.
+func (t *Tree) PutTenant(tenant string, opts ...Option) (err error) {
+	defer annotateError(&err, "PutTenant", []string{tenant})
+	o, cancel := newCallOptions(opts)
+	defer cancel()
+	if err := refuseCapability("PutTenant", nil, o); err != nil {
+		return err
+	}
+	if err := t.ready(); err != nil {
+		return err
+	}
+	key, err := t.transformKey([]string{tenant})
+	if err != nil {
+		return err
+	}
+	if err := t.ValidateKey(key); err != nil {
+		return err
+	}
+	_, writeRequests := t.directoryRequests(key)
+	if t.KeepVersions {
+		writeRequests = append(writeRequests, &dynamodb.WriteRequest{
+			PutRequest: &dynamodb.PutRequest{
+				Item: map[string]*dynamodb.AttributeValue{
+					"Key":   &dynamodb.AttributeValue{S: aws.String(t.EncodeKey(nil))},
+					"Child": &dynamodb.AttributeValue{S: aws.String(t.historyChild() + t.childName(key))},
+				},
+			},
+		})
+	}
+	t.stampTenant(key, writeRequests)
+	return t.batchWrite(writeRequests, o)
+}
+
+// TenantLeadingKeys returns the patterns, for the StringLike condition
+// operator of IAM, that match the partition keys of every row the tree
+// stores for the keys at and below tenant: the row of tenant itself, and
+// those whose partition keys begin with its directory key. When
+// Tree.TenantPartitions is set, these are all of the rows that Put,
+// PutLink, Delete and AppendEvent write for the tenant's keys, except
+// for backlinks, which are stored with the target of each link. They
+// include the KeyPrefix of Tree.KeySchema.
+func (t *Tree) TenantLeadingKeys(tenant string) []string {
+	t.initOnce.Do(t.init)
+	key := []string{tenant}
+	prefix := t.KeySchema.prefix()
+	return []string{prefix + t.EncodeKey(key), prefix + t.dirKey(key) + "*"}
+}
+
+// TenantPolicy returns an IAM policy document that confines its holder to
+// the rows of tenant in the table whose ARN is tableARN, using the
+// dynamodb:LeadingKeys condition key with the patterns given by
+// TenantLeadingKeys. The policy also allows the holder to read the
+// table's metadata row, which the tree checks before its first
+// operation. Tree.TenantPartitions should be set for the trees that use
+// it, so that writes do not reach the root directory, which the policy
+// does not allow.
+//
+// IAM treats * and ? in the patterns as wildcards, so they should not
+// appear in tenant or the SpecialCharacter.
+func (t *Tree) TenantPolicy(tenant, tableARN string) ([]byte, error) {
+	type statement struct {
+		Sid       string
+		Effect    string
+		Action    []string
+		Resource  string
+		Condition map[string]map[string][]string
+	}
+	return json.MarshalIndent(struct {
+		Version   string
+		Statement []statement
+	}{
+		Version: "2012-10-17",
+		Statement: []statement{
+			{
+				Sid:      "TenantRows",
+				Effect:   "Allow",
+				Action:   TenantActions,
+				Resource: tableARN,
+				Condition: map[string]map[string][]string{
+					"ForAllValues:StringLike": {"dynamodb:LeadingKeys": t.TenantLeadingKeys(tenant)},
+				},
+			},
+			{
+				Sid:      "TreeMetadata",
+				Effect:   "Allow",
+				Action:   []string{"dynamodb:GetItem"},
+				Resource: tableARN,
+				Condition: map[string]map[string][]string{
+					"ForAllValues:StringEquals": {"dynamodb:LeadingKeys": {MetadataKey}},
+				},
+			},
+		},
+	}, "", "  ")
+}
@@ -0,0 +1,74 @@
+package dynamotree
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestTenantPartitions(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db, KeepVersions: true,
+		TenantAttribute: "TenantID", TenantPartitions: true}
+	c.Assert(s.CreateTable(), IsNil)
+	v := AccountT{ID: "12345", Name: "alice"}
+	c.Assert(s.Put([]string{"t1", "Accounts", "12345"}, &v), IsNil)
+	c.Assert(s.PutLink([]string{"t1", "Current"}, []string{"t1", "Accounts", "12345"}), IsNil)
+	_, err := s.AppendEvent([]string{"t1", "Accounts", "12345"}, &v)
+	c.Assert(err, IsNil)
+	c.Assert(s.Put([]string{"t2"}, &v), IsNil)
+	c.Assert(s.Put(nil, &v), ErrorIs, ErrNoTenant)
+
+	// Every row has a partition key that begins with its tenant, and
+	// records the tenant
+	output, err := db.Scan(&dynamodb.ScanInput{TableName: aws.String(s.TableName)})
+	c.Assert(err, IsNil)
+	for _, row := range output.Items {
+		pathKey := aws.StringValue(row["Key"].S)
+		if pathKey == MetadataKey {
+			continue
+		}
+		tenant := aws.StringValue(row["TenantID"].S)
+		c.Assert(tenant == "t1" || tenant == "t2", Equals, true, Commentf("%s", pathKey))
+		c.Assert(pathKey == "¦"+tenant || strings.HasPrefix(pathKey, "¦"+tenant+"¦"), Equals, true)
+	}
+
+	// The tenants are found in the root only once they are recorded
+	c.Assert(walkKeys(c, s, nil), HasLen, 0)
+	c.Assert(s.PutTenant("t1"), IsNil)
+	c.Assert(s.PutTenant("t1"), IsNil)
+	c.Assert(walkKeys(c, s, nil), DeepEquals, [][]string{
+		{"t1"},
+		{"t1", "Accounts"},
+		{"t1", "Accounts", "12345"},
+		{"t1", "Current"},
+	})
+
+	// Deleting a top-level key leaves the root directory alone
+	c.Assert(s.Delete([]string{"t2"}), IsNil)
+	c.Assert(s.Get([]string{"t2"}, &v), ErrorIs, ErrNotFound)
+	c.Assert(s.Get([]string{"t1", "Current"}, &v), IsNil)
+
+	c.Assert(s.TenantLeadingKeys("t1"), DeepEquals, []string{"¦t1", "¦t1¦*"})
+	buf, err := s.TenantPolicy("t1", "arn:aws:dynamodb:us-east-1:123456789012:table/tree")
+	c.Assert(err, IsNil)
+	var policy struct {
+		Statement []struct {
+			Action    []string
+			Resource  string
+			Condition map[string]map[string][]string
+		}
+	}
+	c.Assert(json.Unmarshal(buf, &policy), IsNil)
+	c.Assert(policy.Statement, HasLen, 2)
+	c.Assert(policy.Statement[0].Action, DeepEquals, TenantActions)
+	c.Assert(policy.Statement[0].Condition["ForAllValues:StringLike"]["dynamodb:LeadingKeys"], DeepEquals,
+		[]string{"¦t1", "¦t1¦*"})
+	c.Assert(policy.Statement[1].Condition["ForAllValues:StringEquals"]["dynamodb:LeadingKeys"], DeepEquals,
+		[]string{MetadataKey})
+}
@@ -38,6 +38,15 @@
 // "¦Accounts/123456¦" refers to a list of objects having a common prefix. Both can
 // (and often do) coexist peacefully.
 //
+// The Root
+//
+// The empty key names the root of the tree, which is also its top level
+// directory. Both are stored under Key=`¦`: a key with a single part, such as
+// []string{"Accounts"}, has its directory entry at Key=`¦`, Child=`Accounts`, and
+// an object stored at the root, such as a global singleton configuration, has its
+// row at Key=`¦`, Child=`¦`. The root object is never listed as a child of the
+// root.
+//
 // Symbolic Links
 //
 // Sometimes it makes sense to place in object in more than one place in the hierarchy.
@@ -52,6 +61,9 @@
 //     - Key=`¦Links`, Child=`xyzpdq`
 //     - Key=`¦Links¦xyzpdq`, Child=`¦`, ¦=`¦Accounts¦123456¦Links¦xyzpdq`
 //
+// The attribute holding the link target is named by Tree.LinkAttribute, which
+// defaults to the reserved character.
+//
 // Reserved Character
 //
 // For each tree you must choose a reserved character to be used as a delimiter. You
@@ -65,6 +77,9 @@ package dynamotree
 
 import (
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 )
@@ -73,6 +88,10 @@ import (
 // is not specified.
 const DefaultSpecialCharacter = "¦"
 
+// DefaultMaxLinkHops is the default value of Tree.MaxLinkHops if one
+// is not specified.
+const DefaultMaxLinkHops = 8
+
 // Storable is an interface that describes the methods an object
 // must expose to be storable by Store.
 type Storable interface {
@@ -80,16 +99,322 @@ type Storable interface {
 	MarshalDynamoDB() (map[string]*dynamodb.AttributeValue, error)
 }
 
-// ErrNotFound is returned when the object requested does not exist
+// ErrNotFound is matched, using errors.Is, by the *NotFoundError returned
+// when the object requested does not exist
 var ErrNotFound = errors.New("not found")
 
-// ErrNotLink is returned when GetLink is called and the object is not a link
+// ErrIsDirectory is matched, using errors.Is, by the *IsDirectoryError
+// returned when Tree.ReportDirectories is set and Get is called on a key
+// at which nothing is stored but below which there are other keys
+var ErrIsDirectory = errors.New("is a directory")
+
+// ErrIsLink is matched, using errors.Is, by the *IsLinkError returned when
+// Tree.ProtectLinks is set and Put is called on a key that holds a
+// symbolic link
+var ErrIsLink = errors.New("is a link")
+
+// ErrNotLink is matched, using errors.Is, by the *NotLinkError returned when
+// GetLink is called and the object is not a link
 var ErrNotLink = errors.New("not a link")
 
-// ErrReservedCharacterInKey is returned when storing an object with a key that
+// ErrAlreadyExists is matched, using errors.Is, by the *AlreadyExistsError
+// returned when creating a link with PutLinkIfAbsent and a link already
+// exists at the key
+var ErrAlreadyExists = errors.New("already exists")
+
+// ErrConditionFailed is matched, using errors.Is, by the
+// *ConditionFailedError returned when a write is made using WithCondition
+// and the condition is not met
+var ErrConditionFailed = errors.New("condition failed")
+
+// ErrReservedCharacterInKey is matched, using errors.Is, by the
+// *ReservedCharacterError returned when storing an object with a key that
 // contains the reserved character
 var ErrReservedCharacterInKey = errors.New("A key part contains the reserved character")
 
-// ErrReservedCharacterInAttribute is returned when storing an object with an attribute
+// ErrEmptyKeyPart is returned when storing an object with a key that
+// has an empty part
+var ErrEmptyKeyPart = errors.New("A key part is empty")
+
+// ErrReservedCharacterInAttribute is matched, using errors.Is, by the
+// *ReservedCharacterError returned when storing an object with an attribute
 // that begins with the reserved character.
 var ErrReservedCharacterInAttribute = errors.New("An attribute name starts with the reserved string")
+
+// ErrReservedAttribute is matched, using errors.Is, by the
+// *ReservedAttributeError returned when storing an object with an
+// attribute named Tree.LinkAttribute, or with the name of one of the
+// attributes of Tree.KeySchema.
+var ErrReservedAttribute = errors.New("An attribute name is reserved")
+
+// KeyDepthError is returned when storing an object or link with a key that
+// has more parts than Tree.MaxKeyDepth allows. Op is the operation that
+// was refused, if the error was returned by one of the tree's methods.
+type KeyDepthError struct {
+	Op          string
+	Key         []string
+	MaxKeyDepth int
+}
+
+func (e *KeyDepthError) Error() string {
+	return opPrefix(e.Op) + fmt.Sprintf("key %q has %d parts, more than the maximum of %d",
+		strings.Join(e.Key, "/"), len(e.Key), e.MaxKeyDepth)
+}
+
+// LinkHopsError is returned when resolving a key requires following more
+// than Tree.MaxLinkHops symbolic links, for example because the links
+// form a cycle. Op is the operation that failed, if the error was returned
+// by one of the tree's methods.
+type LinkHopsError struct {
+	Op          string
+	Key         []string
+	MaxLinkHops int
+}
+
+func (e *LinkHopsError) Error() string {
+	return opPrefix(e.Op) + fmt.Sprintf("resolving %q requires following more than %d links",
+		strings.Join(e.Key, "/"), e.MaxLinkHops)
+}
+
+// opPrefix returns the prefix of the message of an error returned by the
+// operation op, or "" if op is empty.
+func opPrefix(op string) string {
+	if op == "" {
+		return ""
+	}
+	return op + ": "
+}
+
+// ErrThrottled is matched, using errors.Is, by the errors returned when
+// DynamoDB rejects a request because it exceeds the table's throughput or
+// the account's limits.
+var ErrThrottled = errors.New("throttled")
+
+// NotFoundError is returned when the object requested does not exist. It
+// matches ErrNotFound using errors.Is.
+type NotFoundError struct {
+	Op  string
+	Key []string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s %q: not found", e.Op, strings.Join(e.Key, "/"))
+}
+
+// Is returns true if target is ErrNotFound.
+func (e *NotFoundError) Is(target error) bool { return target == ErrNotFound }
+
+// IsDirectoryError is returned when Tree.ReportDirectories is set and Get
+// is called on a key at which nothing is stored, or a link to one, but
+// below which there are other keys. So that callers that only check for
+// missing objects need not change, it matches both ErrIsDirectory and
+// ErrNotFound using errors.Is.
+type IsDirectoryError struct {
+	Op  string
+	Key []string
+}
+
+func (e *IsDirectoryError) Error() string {
+	return fmt.Sprintf("%s %q: is a directory", e.Op, strings.Join(e.Key, "/"))
+}
+
+// Is returns true if target is ErrIsDirectory or ErrNotFound.
+func (e *IsDirectoryError) Is(target error) bool {
+	return target == ErrIsDirectory || target == ErrNotFound
+}
+
+// IsLinkError is returned when Tree.ProtectLinks is set and Put is called
+// on a key that holds a symbolic link. It matches ErrIsLink using
+// errors.Is.
+type IsLinkError struct {
+	Op  string
+	Key []string
+}
+
+func (e *IsLinkError) Error() string {
+	return fmt.Sprintf("%s %q: is a link", e.Op, strings.Join(e.Key, "/"))
+}
+
+// Is returns true if target is ErrIsLink.
+func (e *IsLinkError) Is(target error) bool { return target == ErrIsLink }
+
+// NotLinkError is returned when the object at a key is expected to be a
+// link but is not. It matches ErrNotLink using errors.Is.
+type NotLinkError struct {
+	Op  string
+	Key []string
+}
+
+func (e *NotLinkError) Error() string {
+	return fmt.Sprintf("%s %q: not a link", e.Op, strings.Join(e.Key, "/"))
+}
+
+// Is returns true if target is ErrNotLink.
+func (e *NotLinkError) Is(target error) bool { return target == ErrNotLink }
+
+// AlreadyExistsError is returned when creating something at a key at which
+// something is already stored. It matches ErrAlreadyExists using
+// errors.Is.
+type AlreadyExistsError struct {
+	Op  string
+	Key []string
+}
+
+func (e *AlreadyExistsError) Error() string {
+	return fmt.Sprintf("%s %q: already exists", e.Op, strings.Join(e.Key, "/"))
+}
+
+// Is returns true if target is ErrAlreadyExists.
+func (e *AlreadyExistsError) Is(target error) bool { return target == ErrAlreadyExists }
+
+// ReservedAttributeError is returned when storing an object with an
+// attribute (Attribute) named Tree.LinkAttribute, or, when the tree has a
+// KeySchema or an ItemType, with the name of its hash, range or type
+// attribute, which the tree writes in each row itself. It matches
+// ErrReservedAttribute using errors.Is.
+type ReservedAttributeError struct {
+	Op        string
+	Key       []string
+	Attribute string
+}
+
+func (e *ReservedAttributeError) Error() string {
+	return fmt.Sprintf("%s %q: attribute name %q is reserved", e.Op, strings.Join(e.Key, "/"), e.Attribute)
+}
+
+// Is returns true if target is ErrReservedAttribute.
+func (e *ReservedAttributeError) Is(target error) bool { return target == ErrReservedAttribute }
+
+// ReservedCharacterError is returned when storing an object whose key has
+// a part (Component) that contains the reserved character, or which has an
+// attribute whose name (Attribute) begins with it. It matches
+// ErrReservedCharacterInKey or ErrReservedCharacterInAttribute,
+// respectively, using errors.Is. Op is the operation that was refused, if
+// the error was returned by one of the tree's methods.
+type ReservedCharacterError struct {
+	Op        string
+	Key       []string
+	Component string
+	Attribute string
+}
+
+func (e *ReservedCharacterError) Error() string {
+	if e.Attribute != "" {
+		return opPrefix(e.Op) + fmt.Sprintf("attribute name %q starts with the reserved string", e.Attribute)
+	}
+	return opPrefix(e.Op) + fmt.Sprintf("part %q of key %q contains the reserved character",
+		e.Component, strings.Join(e.Key, "/"))
+}
+
+// Is returns true if target is the sentinel error that e refines.
+func (e *ReservedCharacterError) Is(target error) bool {
+	if e.Attribute != "" {
+		return target == ErrReservedCharacterInAttribute
+	}
+	return target == ErrReservedCharacterInKey
+}
+
+// ConditionFailedError is returned when a write is made using
+// WithCondition and the condition is not met. Err is the error returned
+// by DynamoDB. It matches ErrConditionFailed using errors.Is.
+type ConditionFailedError struct {
+	Op  string
+	Key []string
+	Err error
+}
+
+func (e *ConditionFailedError) Error() string {
+	return fmt.Sprintf("%s %q: condition failed", e.Op, strings.Join(e.Key, "/"))
+}
+
+// Is returns true if target is ErrConditionFailed.
+func (e *ConditionFailedError) Is(target error) bool { return target == ErrConditionFailed }
+
+// Unwrap returns the error returned by DynamoDB.
+func (e *ConditionFailedError) Unwrap() error { return e.Err }
+
+// ThrottledError is returned when DynamoDB rejects a request because of
+// throttling, once the SDK has stopped retrying it. Err is the error
+// returned by DynamoDB. RetryAfter is how long the caller should wait
+// before trying again: the delay the SDK's retryer would have applied
+// before its next attempt. It matches ErrThrottled using errors.Is.
+type ThrottledError struct {
+	Op         string
+	Key        []string
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	if e.Op == "" {
+		return fmt.Sprintf("throttled: %s", e.Err)
+	}
+	return fmt.Sprintf("%s %q: throttled: %s", e.Op, strings.Join(e.Key, "/"), e.Err)
+}
+
+// Is returns true if target is ErrThrottled.
+func (e *ThrottledError) Is(target error) bool { return target == ErrThrottled }
+
+// Unwrap returns the error returned by DynamoDB.
+func (e *ThrottledError) Unwrap() error { return e.Err }
+
+// RowID identifies a row of the table by its hash and range keys.
+type RowID struct {
+	Key   string
+	Child string
+}
+
+func (r RowID) String() string { return fmt.Sprintf("Key=%q Child=%q", r.Key, r.Child) }
+
+// MultiRowError is returned when an operation that writes several rows,
+// such as Put, fails after some of them may have been written. Written
+// lists the rows known to have been written and Failed the rows that were
+// not, including those that were never attempted. LeafWritten reports
+// whether the row of the object (or link) itself was written; if it was
+// not, retrying the operation is enough to repair the tree, and otherwise
+// GC removes any directory entries left over. RolledBack lists the rows
+// that were written and then removed again, when RollBackOnFailure is
+// given. Err is the error that stopped the operation.
+type MultiRowError struct {
+	Op          string
+	Key         []string
+	Written     []RowID
+	Failed      []RowID
+	RolledBack  []RowID
+	LeafWritten bool
+	Err         error
+}
+
+func (e *MultiRowError) Error() string {
+	leaf := "object row not written"
+	if e.LeafWritten {
+		leaf = "object row written"
+	}
+	return fmt.Sprintf("%s %q: wrote %d of %d rows, %s: %s", e.Op, strings.Join(e.Key, "/"),
+		len(e.Written), len(e.Written)+len(e.Failed), leaf, e.Err)
+}
+
+// Unwrap returns the error that stopped the operation.
+func (e *MultiRowError) Unwrap() error { return e.Err }
+
+// SchemaError is returned when the table does not have the schema that
+// the tree expects.
+type SchemaError struct {
+	TableName string
+	Problem   string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("table %s is not usable by dynamotree: %s", e.TableName, e.Problem)
+}
+
+// SpecialCharacterError is returned when Tree.SpecialCharacter cannot be
+// used to delimit keys. See ValidateSpecialCharacter.
+type SpecialCharacterError struct {
+	SpecialCharacter string
+	Problem          string
+}
+
+func (e *SpecialCharacterError) Error() string {
+	return fmt.Sprintf("SpecialCharacter %q cannot be used because %s", e.SpecialCharacter, e.Problem)
+}
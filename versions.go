@@ -0,0 +1,272 @@
+package dynamotree
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// versionsChild returns the range key below which the versions of an
+// object are stored, ¦.versions¦, each named by an ID that sorts in the
+// order in which the versions were written.
+func (t *Tree) versionsChild() string {
+	return t.SpecialCharacter + ".versions" + t.SpecialCharacter
+}
+
+// historyChild returns the range key below which the names of the keys
+// ever stored in a directory are recorded, ¦.history¦. The names are
+// stored alongside the directory's own object, rather than with its
+// entries, so that they are not counted by hasChildren.
+func (t *Tree) historyChild() string {
+	return t.SpecialCharacter + ".history" + t.SpecialCharacter
+}
+
+// deletedAttribute is the attribute that marks the version recording that
+// an object was deleted.
+func (t *Tree) deletedAttribute() string { return t.SpecialCharacter + "Deleted" }
+
+// versionRequests returns the write requests that record, if KeepVersions
+// is set, that the object at key had the given attributes, or was deleted
+// if attributes is nil.
+func (t *Tree) versionRequests(key []string, attributes map[string]*dynamodb.AttributeValue) ([]*dynamodb.WriteRequest, error) {
+	if !t.KeepVersions {
+		return nil, nil
+	}
+	id, err := t.newTimeOrderedID(t.now())
+	if err != nil {
+		return nil, err
+	}
+
+	writeRequests := []*dynamodb.WriteRequest{}
+	if attributes != nil {
+		for i := range key {
+			writeRequests = append(writeRequests, &dynamodb.WriteRequest{
+				PutRequest: &dynamodb.PutRequest{
+					Item: map[string]*dynamodb.AttributeValue{
+						"Key":   &dynamodb.AttributeValue{S: aws.String(t.EncodeKey(key[:i]))},
+						"Child": &dynamodb.AttributeValue{S: aws.String(t.historyChild() + t.encodePart(key[i]))},
+					},
+				},
+			})
+		}
+	}
+
+	version := make(map[string]*dynamodb.AttributeValue, len(attributes)+2)
+	for name, value := range attributes {
+		version[name] = value
+	}
+	if attributes == nil {
+		version[t.deletedAttribute()] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+	}
+	version["Key"] = &dynamodb.AttributeValue{S: aws.String(t.EncodeKey(key))}
+	version["Child"] = &dynamodb.AttributeValue{S: aws.String(t.versionsChild() + id)}
+	writeRequests = append(writeRequests, &dynamodb.WriteRequest{
+		PutRequest: &dynamodb.PutRequest{Item: version},
+	})
+	return writeRequests, nil
+}
+
+// GetAsOf fetches the item that was stored at key at the time asOf, as
+// recorded when Tree.KeepVersions is set, and fills in ob as Get does.
+// Symbolic links are followed as they were at asOf. If no object was
+// stored at key at that time, or versions were not being kept, GetAsOf
+// returns ErrNotFound.
+func (t *Tree) GetAsOf(key []string, asOf time.Time, ob Storable, opts ...Option) (err error) {
+	defer annotateError(&err, "GetAsOf", key)
+	o, cancel := t.startCall("GetAsOf", key, &err, opts)
+	defer cancel()
+	if err := refuseCapability("GetAsOf", key, o); err != nil {
+		return err
+	}
+	if err := t.ready(); err != nil {
+		return err
+	}
+	key, err = t.transformKey(key)
+	if err != nil {
+		return err
+	}
+	pathKey := t.EncodeKey(key)
+	for hops := 0; ; hops++ {
+		row, err := t.versionAt(pathKey, asOf, o)
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			return ErrNotFound
+		}
+		linkTarget, ok := t.linkTarget(row)
+		if !ok {
+			if err := t.verifyChecksum(row); err != nil {
+				return err
+			}
+			delete(row, "Key")
+			delete(row, "Child")
+			return ob.UnmarshalDynamoDB(row)
+		}
+		if hops >= t.MaxLinkHops {
+			return &LinkHopsError{Key: key, MaxLinkHops: t.MaxLinkHops}
+		}
+		pathKey = linkTarget
+	}
+}
+
+// versionAt returns the version of the object or link whose row has the
+// key pathKey that was current at asOf, or nil if there was none.
+func (t *Tree) versionAt(pathKey string, asOf time.Time, o *callOptions) (map[string]*dynamodb.AttributeValue, error) {
+	// Version IDs continue with "-", which sorts before ".".
+	versionsChild := t.versionsChild()
+	output, err := t.DB.QueryWithContext(o.context(), &dynamodb.QueryInput{
+		TableName:              aws.String(t.TableName),
+		ConsistentRead:         o.consistent(),
+		KeyConditionExpression: aws.String("#K = :key AND #C BETWEEN :start AND :end"),
+		ExpressionAttributeNames: map[string]*string{
+			"#K": aws.String("Key"),
+			"#C": aws.String("Child"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":key":   &dynamodb.AttributeValue{S: aws.String(pathKey)},
+			":start": &dynamodb.AttributeValue{S: aws.String(versionsChild)},
+			":end":   &dynamodb.AttributeValue{S: aws.String(versionsChild + timeOrderedPrefix(asOf) + ".")},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int64(1),
+	}, o.request()...)
+	if err != nil {
+		return nil, err
+	}
+	if len(output.Items) == 0 {
+		return nil, nil
+	}
+	row := output.Items[0]
+	if _, deleted := row[t.deletedAttribute()]; deleted {
+		return nil, nil
+	}
+	return row, nil
+}
+
+// ListAsOf enumerates the immediate children of keyPrefix as they were at
+// the time asOf, as recorded when Tree.KeepVersions is set. A child is
+// listed if an object or link was stored at it, or at any key below it, at
+// that time. Deciding this may require reading the history of every key
+// below the child, so ListAsOf is much more expensive than List.
+//
+// itemFunc is called as it is by List.
+func (t *Tree) ListAsOf(keyPrefix []string, asOf time.Time, itemFunc func(string, error) bool, opts ...Option) {
+	fn, prefix := itemFunc, keyPrefix
+	itemFunc = func(item string, err error) bool { return fn(item, wrapError("ListAsOf", prefix, err)) }
+	o, cancel := newCallOptions(opts)
+	defer cancel()
+	if err := refuseCapability("ListAsOf", keyPrefix, o); err != nil {
+		itemFunc("", err)
+		return
+	}
+	if err := t.ready(); err != nil {
+		itemFunc("", err)
+		return
+	}
+	keyPrefix, err := t.transformKey(keyPrefix)
+	if err != nil {
+		itemFunc("", err)
+		return
+	}
+
+	names, err := t.historyNames(keyPrefix, o)
+	if err != nil {
+		itemFunc("", err)
+		return
+	}
+	for _, name := range names {
+		key := make([]string, len(keyPrefix), len(keyPrefix)+1)
+		copy(key, keyPrefix)
+		existed, err := t.existedAt(append(key, name), asOf, o)
+		if err != nil {
+			itemFunc("", err)
+			return
+		}
+		if existed && !itemFunc(name, nil) {
+			return
+		}
+	}
+}
+
+// existedAt returns true if an object or link was stored at or below key
+// at asOf.
+func (t *Tree) existedAt(key []string, asOf time.Time, o *callOptions) (bool, error) {
+	row, err := t.versionAt(t.EncodeKey(key), asOf, o)
+	if err != nil || row != nil {
+		return row != nil, err
+	}
+	names, err := t.historyNames(key, o)
+	if err != nil {
+		return false, err
+	}
+	for _, name := range names {
+		child := make([]string, len(key), len(key)+1)
+		copy(child, key)
+		existed, err := t.existedAt(append(child, name), asOf, o)
+		if err != nil || existed {
+			return existed, err
+		}
+	}
+	return false, nil
+}
+
+// historyNames returns the names of the children ever stored below
+// prefix while versions were kept.
+func (t *Tree) historyNames(prefix []string, o *callOptions) ([]string, error) {
+	historyChild := t.historyChild()
+	names := []string{}
+	err := t.DB.QueryPagesWithContext(o.context(), &dynamodb.QueryInput{
+		TableName:              aws.String(t.TableName),
+		ConsistentRead:         o.consistent(),
+		KeyConditionExpression: aws.String("#K = :key AND begins_with(#C, :history)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#K": aws.String("Key"),
+			"#C": aws.String("Child"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":key":     &dynamodb.AttributeValue{S: aws.String(t.EncodeKey(prefix))},
+			":history": &dynamodb.AttributeValue{S: aws.String(historyChild)},
+		}}, func(p *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, row := range p.Items {
+			names = append(names, t.decodePart(strings.TrimPrefix(aws.StringValue(row["Child"].S), historyChild)))
+		}
+		return true
+	}, o.request()...)
+	return names, err
+}
+
+// childNamesEver returns, in order, the names of the children of prefix
+// that are listed now or were ever stored while versions were kept, so
+// that the rows of deleted keys can be found too.
+func (t *Tree) childNamesEver(prefix []string, o *callOptions) ([]string, error) {
+	names := map[string]bool{}
+	var err error
+	t.list(prefix, func(name string, innerErr error) bool {
+		if innerErr != nil {
+			err = innerErr
+			return false
+		}
+		names[name] = true
+		return true
+	}, o)
+	if err != nil {
+		return nil, err
+	}
+	history, err := t.historyNames(prefix, o)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range history {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted, nil
+}
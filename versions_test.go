@@ -0,0 +1,75 @@
+package dynamotree
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestGetAsOf(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db, KeepVersions: true}
+	c.Assert(s.CreateTable(), IsNil)
+
+	// tick returns the current time, making sure that writes made before
+	// and after it have different times.
+	tick := func() time.Time {
+		time.Sleep(2 * time.Millisecond)
+		defer time.Sleep(2 * time.Millisecond)
+		return time.Now()
+	}
+
+	before := tick()
+	c.Assert(s.Put([]string{"Accounts", "alice"}, &AccountT{ID: "1", Name: "alice"}), IsNil)
+	c.Assert(s.PutLink([]string{"Links", "a"}, []string{"Accounts", "alice"}), IsNil)
+	t1 := tick()
+	c.Assert(s.Put([]string{"Accounts", "alice"}, &AccountT{ID: "1", Name: "Alice"}), IsNil)
+	c.Assert(s.Put([]string{"Accounts", "bob", "Settings"}, &AccountT{ID: "2", Name: "bob"}), IsNil)
+	t2 := tick()
+	c.Assert(s.Delete([]string{"Accounts", "alice"}), IsNil)
+	c.Assert(s.Delete([]string{"Accounts", "bob", "Settings"}), IsNil)
+	t3 := tick()
+
+	var v AccountT
+	c.Assert(s.GetAsOf([]string{"Accounts", "alice"}, before, &v), ErrorIs, ErrNotFound)
+	c.Assert(s.GetAsOf([]string{"Accounts", "alice"}, t1, &v), IsNil)
+	c.Assert(v.Name, Equals, "alice")
+	c.Assert(s.GetAsOf([]string{"Links", "a"}, t2, &v), IsNil)
+	c.Assert(v.Name, Equals, "Alice")
+	err := s.GetAsOf([]string{"Accounts", "alice"}, t3, &v)
+	c.Assert(err, ErrorIs, ErrNotFound)
+	c.Assert(err.(*NotFoundError).Op, Equals, "GetAsOf")
+	c.Assert(s.GetAsOf([]string{"Links", "a"}, t3, &v), ErrorIs, ErrNotFound)
+
+	// The current state is unaffected by the history
+	c.Assert(s.Get([]string{"Accounts", "alice"}, &v), ErrorIs, ErrNotFound)
+	c.Assert(s.Get([]string{"Links", "a"}, &v), ErrorIs, ErrNotFound)
+
+	list := func(prefix []string, asOf time.Time) []string {
+		names := []string{}
+		s.ListAsOf(prefix, asOf, func(name string, err error) bool {
+			c.Assert(err, IsNil)
+			names = append(names, name)
+			return true
+		})
+		return names
+	}
+	c.Assert(list(nil, before), DeepEquals, []string{})
+	c.Assert(list(nil, t1), DeepEquals, []string{"Accounts", "Links"})
+	c.Assert(list([]string{"Accounts"}, t1), DeepEquals, []string{"alice"})
+	c.Assert(list([]string{"Accounts"}, t2), DeepEquals, []string{"alice", "bob"})
+	c.Assert(list(nil, t3), DeepEquals, []string{"Links"})
+	c.Assert(list([]string{"Accounts"}, t3), DeepEquals, []string{})
+
+	// List does not see the history
+	names := []string{}
+	s.List([]string{"Accounts"}, func(name string, err error) bool {
+		c.Assert(err, IsNil)
+		names = append(names, name)
+		return true
+	})
+	c.Assert(names, DeepEquals, []string{"bob"})
+}
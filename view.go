@@ -0,0 +1,226 @@
+package dynamotree
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// View presents the children of a single directory, whose names are
+// themselves hierarchical, as a hierarchy of directories derived from the
+// names by splitting them on Delimiter, much as the delimiter of S3's
+// ListObjects does. For example, with Delimiter "-", the children
+// "2024-05-01", "2024-05-02" and "2024-06-01" of Prefix are listed as the
+// directory "2024", which holds the directories "05" and "06". No rows are
+// written for the directories of a view.
+type View struct {
+	// Tree is the tree that holds the directory.
+	Tree *Tree
+
+	// Prefix is the key of the directory whose children are viewed.
+	Prefix []string
+
+	// Delimiter separates the levels of the names of the children.
+	Delimiter string
+}
+
+// ViewEntry is an entry listed by View.List.
+type ViewEntry struct {
+	// Name is the part of the names of the children that follows the path
+	// listed, up to the next Delimiter.
+	Name string
+
+	// Directory is true if Name is followed by Delimiter in the names of
+	// one or more children, so that it can be listed in turn. If a child is
+	// also named by the path and Name alone, it is listed separately, with
+	// Directory false, before the directory.
+	Directory bool
+
+	// Key is the key of the child, or nil if Directory is true.
+	Key []string
+}
+
+// errEmptyDelimiter is returned by View.List if the view has no Delimiter.
+var errEmptyDelimiter = errors.New("dynamotree: View.Delimiter is empty")
+
+// Key returns the key of the child of Prefix that path names in the view.
+func (v *View) Key(path []string) []string {
+	key := make([]string, len(v.Prefix), len(v.Prefix)+1)
+	copy(key, v.Prefix)
+	return append(key, strings.Join(path, v.Delimiter))
+}
+
+// List enumerates the entries of the view directly below path, a list of
+// the levels of a name, in the order of their names, calling itemFunc with
+// each as Tree.List does. An empty path lists the top level of the view.
+//
+// Unless Tree.KeyEncoding is set, only the children whose names begin with
+// path are read, and each directory of the view is skipped over with a
+// new query rather than by reading the children within it. Otherwise,
+// every child of Prefix is read.
+func (v *View) List(path []string, itemFunc func(*ViewEntry, error) bool, opts ...Option) {
+	t := v.Tree
+	fn := itemFunc
+	itemFunc = func(entry *ViewEntry, err error) bool { return fn(entry, wrapError("List", v.Key(path), err)) }
+	o, cancel := newCallOptions(opts)
+	defer cancel()
+	if err := refuseCapability("View.List", v.Key(path), o); err != nil {
+		itemFunc(nil, err)
+		return
+	}
+	if err := t.ready(); err != nil {
+		itemFunc(nil, err)
+		return
+	}
+	if v.Delimiter == "" {
+		itemFunc(nil, errEmptyDelimiter)
+		return
+	}
+	prefix, err := t.transformKey(v.Prefix)
+	if err != nil {
+		itemFunc(nil, err)
+		return
+	}
+	namePrefix := ""
+	if len(path) > 0 {
+		namePrefix = strings.Join(path, v.Delimiter) + v.Delimiter
+	}
+
+	// entry returns the entry of the view that holds the child name, and
+	// the name from which the children that follow the entry begin, if
+	// there is one.
+	entry := func(name string) (*ViewEntry, string) {
+		rest := name[len(namePrefix):]
+		i := strings.Index(rest, v.Delimiter)
+		if i < 0 {
+			key := make([]string, len(v.Prefix), len(v.Prefix)+1)
+			copy(key, v.Prefix)
+			return &ViewEntry{Name: rest, Key: append(key, name)}, ""
+		}
+		next, _ := successor(namePrefix + rest[:i+len(v.Delimiter)])
+		return &ViewEntry{Name: rest[:i], Directory: true}, next
+	}
+
+	if t.KeyEncoding != KeyEncodingNone {
+		v.listEncoded(prefix, namePrefix, entry, itemFunc, o)
+		return
+	}
+
+	// No directory is named by the delimiter, which separates the names.
+	start, lastDirectory := namePrefix, v.Delimiter
+	for {
+		var seek string
+		stopped := false
+		err := t.DB.QueryPagesWithContext(o.context(), t.childrenFrom(prefix, start, o), func(p *dynamodb.QueryOutput, lastPage bool) bool {
+			for _, row := range p.Items {
+				name := aws.StringValue(row["Child"].S)
+				if strings.HasPrefix(name, t.SpecialCharacter) {
+					continue
+				}
+				if !strings.HasPrefix(name, namePrefix) {
+					stopped = true
+					return false
+				}
+				e, next := entry(name)
+				if e.Directory && e.Name == lastDirectory {
+					continue
+				}
+				if !itemFunc(e, nil) {
+					stopped = true
+					return false
+				}
+				if e.Directory {
+					lastDirectory = e.Name
+					if next != "" {
+						seek = next
+						return false
+					}
+				}
+			}
+			return true
+		}, o.request()...)
+		if err != nil {
+			itemFunc(nil, err)
+			return
+		}
+		if stopped || seek == "" {
+			return
+		}
+		start = seek
+	}
+}
+
+// childrenFrom returns the query for the children of prefix whose stored
+// names are start or after it.
+func (t *Tree) childrenFrom(prefix []string, start string, o *callOptions) *dynamodb.QueryInput {
+	input := &dynamodb.QueryInput{
+		TableName:                aws.String(t.TableName),
+		ConsistentRead:           o.consistent(),
+		KeyConditionExpression:   aws.String("#K = :key"),
+		ExpressionAttributeNames: map[string]*string{"#K": aws.String("Key")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":key": &dynamodb.AttributeValue{S: aws.String(t.dirKey(prefix))},
+		},
+	}
+	if start != "" {
+		input.KeyConditionExpression = aws.String("#K = :key AND #C >= :start")
+		input.ExpressionAttributeNames["#C"] = aws.String("Child")
+		input.ExpressionAttributeValues[":start"] = &dynamodb.AttributeValue{S: aws.String(start)}
+	}
+	return input
+}
+
+// listEncoded lists the view of a tree whose stored names are encoded, and
+// so do not sort in the order of the names, by reading every child.
+func (v *View) listEncoded(prefix []string, namePrefix string, entry func(string) (*ViewEntry, string),
+	itemFunc func(*ViewEntry, error) bool, o *callOptions) {
+	names := []string{}
+	var err error
+	v.Tree.list(prefix, func(name string, innerErr error) bool {
+		if innerErr != nil {
+			err = innerErr
+			return false
+		}
+		if strings.HasPrefix(name, namePrefix) {
+			names = append(names, name)
+		}
+		return true
+	}, o)
+	if err != nil {
+		itemFunc(nil, err)
+		return
+	}
+	sort.Strings(names)
+	lastDirectory := v.Delimiter
+	for _, name := range names {
+		e, _ := entry(name)
+		if e.Directory && e.Name == lastDirectory {
+			continue
+		}
+		if !itemFunc(e, nil) {
+			return
+		}
+		if e.Directory {
+			lastDirectory = e.Name
+		}
+	}
+}
+
+// successor returns the least string greater than every string that
+// begins with s, or false if there is none.
+func successor(s string) (string, bool) {
+	r, size := utf8.DecodeLastRuneInString(s)
+	if size == 0 || r == utf8.RuneError || r == utf8.MaxRune {
+		return "", false
+	}
+	r++
+	if r == 0xD800 {
+		// The surrogates cannot be encoded.
+		r = 0xE000
+	}
+	return s[:len(s)-size] + string(r), true
+}
@@ -0,0 +1,44 @@
+package dynamotree
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestView(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	for _, keyEncoding := range []KeyEncoding{KeyEncodingNone, KeyEncodingHex} {
+		s := &Tree{TableName: uniuri.New(), DB: db, KeyEncoding: keyEncoding}
+		c.Assert(s.CreateTable(), IsNil)
+		for _, name := range []string{"2024-05-01", "2024-05-02", "2024-06-01", "2024", "2025-01-01", "notes"} {
+			c.Assert(s.Put([]string{"Logs", name}, &AccountT{ID: name}), IsNil)
+		}
+		c.Assert(s.Put([]string{"Logs", "2024-05-01", "detail"}, &AccountT{ID: "detail"}), IsNil)
+
+		v := &View{Tree: s, Prefix: []string{"Logs"}, Delimiter: "-"}
+		list := func(path ...string) []string {
+			entries := []string{}
+			v.List(path, func(entry *ViewEntry, err error) bool {
+				c.Assert(err, IsNil)
+				if entry.Directory {
+					entries = append(entries, entry.Name+"/")
+				} else {
+					c.Assert(entry.Key, DeepEquals, v.Key(append(path, entry.Name)))
+					entries = append(entries, entry.Name)
+				}
+				return true
+			}, ConsistentRead())
+			return entries
+		}
+		c.Assert(list(), DeepEquals, []string{"2024", "2024/", "2025/", "notes"}, Commentf("%v", keyEncoding))
+		c.Assert(list("2024"), DeepEquals, []string{"05/", "06/"})
+		c.Assert(list("2024", "05"), DeepEquals, []string{"01", "02"})
+		c.Assert(list("2023"), DeepEquals, []string{})
+
+		var item AccountT
+		c.Assert(s.Get(v.Key([]string{"2024", "05", "02"}), &item), IsNil)
+		c.Assert(item.ID, Equals, "2024-05-02")
+	}
+}
@@ -0,0 +1,263 @@
+package dynamotree
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Walk enumerates every key below prefix, depth first. For each key found
+// it calls walkFunc with the full key. A key is visited before any of its
+// descendants. If an error occurs, walkFunc is called with a non-nil error.
+// walkFunc should return true to continue iterating or false to stop.
+//
+// Walk issues one Query for each key it visits, so walking a large subtree
+// is expensive. See EstimateCost. Its progress can be reported using
+// WithProgress, and a walk given WithCheckpoint that fails or is stopped by
+// walkFunc resumes where it left off when it is called again.
+func (t *Tree) Walk(prefix []string, walkFunc func([]string, error) bool, opts ...Option) {
+	fn, keyPrefix := walkFunc, prefix
+	var walkErr error
+	walkFunc = func(key []string, err error) bool {
+		if err != nil && walkErr == nil {
+			walkErr = err
+		}
+		return fn(key, wrapError("Walk", keyPrefix, err))
+	}
+	o, cancel := t.startCall("Walk", keyPrefix, &walkErr, opts)
+	defer cancel()
+	o.list = listBudget{} // the limits of List do not apply to each directory
+	if err := t.ready(); err != nil {
+		walkFunc(nil, err)
+		return
+	}
+	if err := t.checkCapability("Walk", prefix, o); err != nil {
+		walkFunc(nil, err)
+		return
+	}
+	prefix, err := t.transformKey(prefix)
+	if err != nil {
+		walkFunc(nil, err)
+		return
+	}
+	j, err := t.startJob("Walk", [][]string{prefix}, o)
+	if err != nil {
+		walkFunc(nil, err)
+		return
+	}
+	complete, err := j.walk(prefix, func(key []string) (bool, error) {
+		return walkFunc(key, nil), nil
+	})
+	if err == nil && !complete {
+		j.stop()
+		return
+	}
+	if err := j.finish(err); err != nil {
+		walkFunc(nil, err)
+	}
+}
+
+// walk visits the descendants of prefix, returning false if walkFunc
+// asked to stop.
+func (t *Tree) walk(prefix []string, walkFunc func([]string, error) bool, o *callOptions) bool {
+	children := []string{}
+	var err error
+	t.list(prefix, func(child string, innerErr error) bool {
+		if innerErr != nil {
+			err = innerErr
+			return false
+		}
+		children = append(children, child)
+		return true
+	}, o)
+	if err != nil {
+		walkFunc(nil, err)
+		return false
+	}
+
+	for _, child := range children {
+		key := make([]string, len(prefix), len(prefix)+1)
+		copy(key, prefix)
+		key = append(key, child)
+		if !walkFunc(key, nil) {
+			return false
+		}
+		if !t.walk(key, walkFunc, o) {
+			return false
+		}
+	}
+	return true
+}
+
+// WalkError describes an error that occurred at Key during WalkParallel,
+// either listing the directory at Key or returned by walkFunc for Key.
+type WalkError struct {
+	Key []string
+	Err error
+}
+
+func (e *WalkError) Error() string {
+	return fmt.Sprintf("%q: %s", strings.Join(e.Key, "/"), e.Err)
+}
+
+// Unwrap returns the error that occurred.
+func (e *WalkError) Unwrap() error { return e.Err }
+
+// WalkErrors is returned by WalkParallel when one or more errors occur. The
+// errors are sorted by key, so that the result does not depend on the order
+// in which the workers ran.
+type WalkErrors []*WalkError
+
+func (e WalkErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", e[0], len(e)-1)
+}
+
+// WalkParallel enumerates every key below prefix, as Walk does, using up
+// to concurrency workers to list directories at the same time. walkFunc is
+// called with each key found, from several goroutines at once, and a key
+// is always visited before any of its descendants but otherwise in no
+// particular order. If walkFunc returns an error, the descendants of the
+// key are not visited, but the rest of the traversal continues.
+//
+// WalkParallel returns nil once every key has been visited, or WalkErrors
+// describing every error that occurred. To stop a traversal early, cancel
+// the context given using WithContext.
+func (t *Tree) WalkParallel(prefix []string, concurrency int, walkFunc func([]string) error, opts ...Option) (err error) {
+	defer annotateError(&err, "WalkParallel", prefix)
+	o, cancel := t.startCall("WalkParallel", prefix, &err, opts)
+	defer cancel()
+	o.list = listBudget{} // the limits of List do not apply to each directory
+	if err := refuseCapability("WalkParallel", prefix, o); err != nil {
+		return err
+	}
+	if err := t.ready(); err != nil {
+		return err
+	}
+	prefix, err = t.transformKey(prefix)
+	if err != nil {
+		return err
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	w := &parallelWalker{tree: t, walkFunc: walkFunc, o: o, queue: [][]string{prefix}, pending: 1}
+	w.cond = sync.NewCond(&w.mu)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.work()
+		}()
+	}
+	wg.Wait()
+
+	if err := o.context().Err(); err != nil {
+		w.errs = append(w.errs, &WalkError{Key: prefix, Err: err})
+	}
+	if len(w.errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(w.errs, func(i, j int) bool { return lessKey(w.errs[i].Key, w.errs[j].Key) })
+	return w.errs
+}
+
+// parallelWalker holds the state of a call to WalkParallel. The queue
+// holds the directories waiting to be listed, and pending counts those
+// directories as well as the ones being listed.
+type parallelWalker struct {
+	tree     *Tree
+	walkFunc func([]string) error
+	o        *callOptions
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   [][]string
+	pending int
+	errs    WalkErrors
+}
+
+// work lists directories from the queue until none remain.
+func (w *parallelWalker) work() {
+	for {
+		w.mu.Lock()
+		for len(w.queue) == 0 && w.pending > 0 {
+			w.cond.Wait()
+		}
+		if w.pending == 0 {
+			w.mu.Unlock()
+			return
+		}
+		prefix := w.queue[len(w.queue)-1]
+		w.queue = w.queue[:len(w.queue)-1]
+		w.mu.Unlock()
+
+		if w.o.context().Err() == nil {
+			w.visit(prefix)
+		}
+
+		w.mu.Lock()
+		w.pending--
+		if w.pending == 0 {
+			w.cond.Broadcast()
+		}
+		w.mu.Unlock()
+	}
+}
+
+// visit lists the directory prefix, calls walkFunc for each of its
+// children and queues the children to be listed in turn.
+func (w *parallelWalker) visit(prefix []string) {
+	children := []string{}
+	var err error
+	w.tree.list(prefix, func(child string, innerErr error) bool {
+		if innerErr != nil {
+			err = innerErr
+			return false
+		}
+		children = append(children, child)
+		return true
+	}, w.o)
+	if err != nil {
+		if w.o.context().Err() == nil {
+			w.fail(prefix, err)
+		}
+		return
+	}
+
+	for _, child := range children {
+		key := make([]string, len(prefix), len(prefix)+1)
+		copy(key, prefix)
+		key = append(key, child)
+		if err := w.walkFunc(key); err != nil {
+			w.fail(key, err)
+			continue
+		}
+		w.mu.Lock()
+		w.queue = append(w.queue, key)
+		w.pending++
+		w.cond.Signal()
+		w.mu.Unlock()
+	}
+}
+
+func (w *parallelWalker) fail(key []string, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.errs = append(w.errs, &WalkError{Key: key, Err: err})
+}
+
+// lessKey returns true if a sorts before b, comparing part by part.
+func lessKey(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
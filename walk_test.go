@@ -0,0 +1,111 @@
+package dynamotree
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestWalk(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	err := s.CreateTable()
+	c.Assert(err, IsNil)
+
+	v := AccountT{
+		ID:    "12345",
+		Name:  "alice",
+		Email: "alice@example.com",
+	}
+	err = s.Put([]string{"Accounts", "12345", "Links", "xyzpdq"}, &v)
+	c.Assert(err, IsNil)
+	err = s.Put([]string{"Accounts", "6789"}, &v)
+	c.Assert(err, IsNil)
+	err = s.PutLink([]string{"Links", "xyzpdq"}, []string{"Accounts", "12345", "Links", "xyzpdq"})
+	c.Assert(err, IsNil)
+
+	keys := [][]string{}
+	s.Walk(nil, func(key []string, err error) bool {
+		c.Assert(err, IsNil)
+		keys = append(keys, key)
+		return true
+	})
+	c.Assert(keys, DeepEquals, [][]string{
+		{"Accounts"},
+		{"Accounts", "12345"},
+		{"Accounts", "12345", "Links"},
+		{"Accounts", "12345", "Links", "xyzpdq"},
+		{"Accounts", "6789"},
+		{"Links"},
+		{"Links", "xyzpdq"},
+	})
+
+	keys = [][]string{}
+	s.Walk([]string{"Accounts"}, func(key []string, err error) bool {
+		c.Assert(err, IsNil)
+		keys = append(keys, key)
+		return len(keys) < 2
+	})
+	c.Assert(keys, DeepEquals, [][]string{
+		{"Accounts", "12345"},
+		{"Accounts", "12345", "Links"},
+	})
+}
+
+func (suite *StoreImplTest) TestWalkParallel(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+
+	v := AccountT{ID: "12345", Name: "alice"}
+	expected := [][]string{}
+	for _, account := range []string{"1", "2", "3", "4"} {
+		for _, link := range []string{"a", "b", "c"} {
+			c.Assert(s.Put([]string{"Accounts", account, "Links", link}, &v), IsNil)
+			expected = append(expected, []string{"Accounts", account, "Links", link})
+		}
+		expected = append(expected, []string{"Accounts", account}, []string{"Accounts", account, "Links"})
+	}
+	expected = append(expected, []string{"Accounts"})
+	sort.Slice(expected, func(i, j int) bool { return lessKey(expected[i], expected[j]) })
+
+	var mu sync.Mutex
+	keys := [][]string{}
+	err := s.WalkParallel(nil, 4, func(key []string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, key)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	sort.Slice(keys, func(i, j int) bool { return lessKey(keys[i], keys[j]) })
+	c.Assert(keys, DeepEquals, expected)
+
+	// Errors skip the subtree and are reported in key order.
+	keys = [][]string{}
+	err = s.WalkParallel([]string{"Accounts"}, 3, func(key []string) error {
+		if len(key) == 2 && key[1] != "1" {
+			return fmt.Errorf("skip %s", key[1])
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, key)
+		return nil
+	})
+	walkErrors, ok := err.(WalkErrors)
+	c.Assert(ok, Equals, true)
+	c.Assert(walkErrors, HasLen, 3)
+	for i, account := range []string{"2", "3", "4"} {
+		c.Assert(walkErrors[i].Key, DeepEquals, []string{"Accounts", account})
+		c.Assert(walkErrors[i].Err, ErrorMatches, "skip "+account)
+	}
+	c.Assert(err, ErrorMatches, `"Accounts/2": skip 2 \(and 2 more errors\)`)
+	c.Assert(keys, HasLen, 5)
+}
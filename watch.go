@@ -0,0 +1,89 @@
+package dynamotree
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// WatchKey polls the object at key every interval and reports each change
+// to fn. When the object changes, it is unmarshaled into ob and fn is called
+// with a nil error. If the object is removed, fn is called with ErrNotFound,
+// and if fetching the object fails, fn is called with the error. The first
+// poll always reports the object's current state.
+//
+// Changes are detected by comparing the stored attributes of the object, so
+// objects that maintain a version or modification time attribute are
+// reported each time it changes. Changes made and reverted between polls
+// are not seen. Symbolic links are followed as they are by Get.
+//
+// WatchKey is a lightweight alternative to DynamoDB Streams, suited to
+// small, rarely changing objects such as configuration. Each poll reads
+// the object once, so the cost of watching is proportional to the
+// frequency of polling rather than the frequency of changes.
+//
+// WatchKey returns nil when fn returns false, ctx.Err() when ctx is done,
+// or ErrClosed when the tree is closed. Interval must be positive. opts
+// apply to each poll, and WithTimeout bounds the whole watch.
+func (t *Tree) WatchKey(ctx context.Context, key []string, interval time.Duration, ob Storable, fn func(error) bool, opts ...Option) error {
+	if interval <= 0 {
+		return fmt.Errorf("cannot watch at an interval of %s", interval)
+	}
+	if err := t.ready(); err != nil {
+		return err
+	}
+	key, err := t.transformKey(key)
+	if err != nil {
+		return err
+	}
+
+	o, cancel := newCallOptions(append(opts[:len(opts):len(opts)], WithContext(ctx)))
+	defer cancel()
+	closing, done := t.background()
+	defer done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	first := true
+	var lastRow map[string]*dynamodb.AttributeValue
+	var lastErr error
+	for {
+		row, err := t.resolve(key, o)
+		if err == nil {
+			if err = t.verifyChecksum(row); err != nil {
+				row = nil
+			}
+		}
+		if first || !sameError(err, lastErr) || !reflect.DeepEqual(row, lastRow) {
+			first = false
+			lastRow, lastErr = row, err
+			if err == nil {
+				err = ob.UnmarshalDynamoDB(row)
+			}
+			if !fn(err) {
+				return nil
+			}
+		}
+
+		select {
+		case <-o.context().Done():
+			return o.context().Err()
+		case <-closing:
+			return ErrClosed
+		case <-ticker.C:
+		}
+	}
+}
+
+// sameError reports whether err is the same failure as lastErr, comparing
+// their messages, since errors returned by the tree are wrapped afresh by
+// each call.
+func sameError(err, lastErr error) bool {
+	if err == nil || lastErr == nil {
+		return err == lastErr
+	}
+	return err.Error() == lastErr.Error()
+}
@@ -0,0 +1,104 @@
+package dynamotree
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestWatchKey(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	err := s.CreateTable()
+	c.Assert(err, IsNil)
+
+	key := []string{"Config", "12345"}
+	v := AccountT{
+		ID:    "12345",
+		Name:  "alice",
+		Email: "alice@example.com",
+	}
+	err = s.Put(key, &v)
+	c.Assert(err, IsNil)
+	err = s.PutLink([]string{"Link"}, key)
+	c.Assert(err, IsNil)
+
+	seen := []string{}
+	var watched AccountT
+	err = s.WatchKey(context.Background(), []string{"Link"}, time.Millisecond, &watched, func(err error) bool {
+		if err == ErrNotFound {
+			seen = append(seen, "<deleted>")
+			return false
+		}
+		c.Assert(err, IsNil)
+		seen = append(seen, watched.Name)
+
+		switch watched.Name {
+		case "alice":
+			v.Name = "bob"
+			c.Assert(s.Put(key, &v), IsNil)
+		case "bob":
+			c.Assert(s.Delete(key), IsNil)
+		}
+		return true
+	})
+	c.Assert(err, IsNil)
+	c.Assert(seen, DeepEquals, []string{"alice", "bob", "<deleted>"})
+}
+
+func (suite *StoreImplTest) TestWatchKeyCancel(c *C) {
+	tableName := uniuri.New()
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: tableName, DB: db}
+	err := s.CreateTable()
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err = s.WatchKey(ctx, []string{"Config"}, time.Millisecond, &AccountT{}, func(err error) bool {
+		c.Assert(err, ErrorIs, ErrNotFound)
+		calls++
+		cancel()
+		return true
+	})
+	c.Assert(err, Equals, context.Canceled)
+	c.Assert(calls, Equals, 1)
+
+	// WithTimeout bounds the whole watch.
+	err = s.WatchKey(context.Background(), []string{"Config"}, time.Millisecond, &AccountT{}, func(err error) bool {
+		return true
+	}, WithTimeout(20*time.Millisecond))
+	c.Assert(err, Equals, context.DeadlineExceeded)
+}
+
+func (suite *StoreImplTest) TestWatchKeyErrors(c *C) {
+	s := &Tree{TableName: uniuri.New(), DB: dynamodb.New(session.New(), testConfig)}
+	c.Assert(s.CreateTable(), IsNil)
+
+	err := s.WatchKey(context.Background(), []string{"Config"}, 0, &AccountT{}, func(err error) bool {
+		c.Fatalf("fn called with %v", err)
+		return false
+	})
+	c.Assert(err, ErrorMatches, `cannot watch at an interval of 0s`)
+
+	// A failure that repeats on each poll is reported once.
+	c.Assert(s.PutLink([]string{"A"}, []string{"B"}), IsNil)
+	c.Assert(s.PutLink([]string{"B"}, []string{"A"}), IsNil)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	calls := 0
+	s.WatchKey(ctx, []string{"A"}, time.Millisecond, &AccountT{}, func(err error) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		c.Assert(err, ErrorMatches, `resolving "A" requires following more than \d+ links`)
+		calls++
+		return true
+	})
+	c.Assert(calls, Equals, 1)
+}
@@ -0,0 +1,203 @@
+package dynamotree
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// WideDirectory describes a directory with at least Tree.WideDirectoryThreshold
+// children, as given to Tree.OnWideDirectory.
+//
+// The children of a directory are all stored under one partition key, the
+// key of the directory, and so are served by a single partition of the
+// table, which DynamoDB limits to about 3000 reads and 1000 writes a
+// second. A very wide directory is also slow and costly to list. Spreading
+// the children of such a directory over shards, each a directory of its
+// own, with ReshardDirectory, spreads them over partitions.
+type WideDirectory struct {
+	// Prefix is the key of the directory, as it is stored.
+	Prefix []string
+
+	// Children is the number of children found, which the directory has at
+	// least.
+	Children int
+
+	// Op is the operation that found the children.
+	Op string
+}
+
+func (w *WideDirectory) String() string {
+	return fmt.Sprintf("wide directory %q: %s found at least %d children; consider ReshardDirectory",
+		strings.Join(w.Prefix, "/"), w.Op, w.Children)
+}
+
+// noteChildren records that op found children children of prefix, and
+// reports the directory as wide if they reach Tree.WideDirectoryThreshold
+// and it has not been reported before.
+func (t *Tree) noteChildren(op string, prefix []string, children int) {
+	if t.WideDirectoryThreshold <= 0 || children < t.WideDirectoryThreshold {
+		return
+	}
+	if _, reported := t.wideDirectories.LoadOrStore(t.dirKey(prefix), true); reported {
+		return
+	}
+	w := &WideDirectory{Prefix: prefix, Children: children, Op: op}
+	if t.OnWideDirectory != nil {
+		t.OnWideDirectory(w)
+		return
+	}
+	if logger := t.DB.Config.Logger; logger != nil {
+		logger.Log("dynamotree:", w.String())
+	}
+}
+
+// CountChildren returns the number of children of prefix. It reads every
+// row of the directory, and so consumes as much capacity as listing it
+// does. A directory found to have Tree.WideDirectoryThreshold children or
+// more is reported to Tree.OnWideDirectory.
+func (t *Tree) CountChildren(prefix []string, opts ...Option) (n int, err error) {
+	defer annotateError(&err, "CountChildren", prefix)
+	o, cancel := newCallOptions(opts)
+	defer cancel()
+	if err := refuseCapability("CountChildren", prefix, o); err != nil {
+		return 0, err
+	}
+	if err := t.ready(); err != nil {
+		return 0, err
+	}
+	prefix, err = t.transformKey(prefix)
+	if err != nil {
+		return 0, err
+	}
+	input := t.childrenFrom(prefix, "", o)
+	input.ProjectionExpression = aws.String("#C")
+	input.ExpressionAttributeNames["#C"] = aws.String("Child")
+	err = t.DB.QueryPagesWithContext(o.context(), input, func(p *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, row := range p.Items {
+			if !strings.HasPrefix(aws.StringValue(row["Child"].S), t.SpecialCharacter) {
+				n++
+			}
+		}
+		return true
+	}, o.request()...)
+	if err != nil {
+		return 0, err
+	}
+	t.noteChildren("CountChildren", prefix, n)
+	return n, nil
+}
+
+// shardPrefix begins the name of each shard made by ReshardDirectory.
+const shardPrefix = "shard-"
+
+// DirectoryShards returns the names of the shards of a directory
+// resharded by ReshardDirectory into shards shards, in order, such as to
+// list each of them in turn.
+func DirectoryShards(shards int) []string {
+	names := make([]string, shards)
+	for i := range names {
+		names[i] = shardName(i, shards)
+	}
+	return names
+}
+
+// ShardKey returns the key at which ReshardDirectory, given shards, stores
+// the child name of prefix: the key prefix/shard-NN/name, where NN is
+// chosen by a hash of name.
+func ShardKey(prefix []string, name string, shards int) []string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	key := make([]string, len(prefix), len(prefix)+2)
+	copy(key, prefix)
+	return append(key, shardName(int(h.Sum32()%uint32(shards)), shards), name)
+}
+
+// shardName returns the name of the shard i of shards.
+func shardName(i, shards int) string {
+	width := len(strconv.Itoa(shards - 1))
+	return fmt.Sprintf("%s%0*d", shardPrefix, width, i)
+}
+
+// isShardName returns true if name is the name of one of shards shards.
+func isShardName(name string, shards int) bool {
+	if !strings.HasPrefix(name, shardPrefix) {
+		return false
+	}
+	i, err := strconv.Atoi(name[len(shardPrefix):])
+	return err == nil && i >= 0 && i < shards && name == shardName(i, shards)
+}
+
+// ReshardDirectory spreads the children of prefix, a wide directory, over
+// shards directories below it, moving each child, with the keys below it,
+// to the key returned by ShardKey, so that they are stored under shards
+// partition keys rather than one. Callers must then read and write the
+// children at their new keys, and list the directory by listing each of
+// DirectoryShards in turn.
+//
+// Each child is moved by copying it, as Copy does, and then deleting it,
+// as DeleteAll does, so a reader may find a child at both keys, or, with
+// Tree.KeepVersions, at neither for a moment; the directory should not be
+// written while it is being resharded. Links elsewhere in the tree to the
+// keys moved are not changed. If ReshardDirectory fails part way through,
+// calling it again moves the children that remain. Children whose names
+// are those of shards are left in place. Its progress, counting each
+// child moved, can be reported using WithProgress.
+func (t *Tree) ReshardDirectory(prefix []string, shards int, opts ...Option) (err error) {
+	defer annotateError(&err, "ReshardDirectory", prefix)
+	o, cancel := newCallOptions(opts)
+	defer cancel()
+	if shards < 2 {
+		return fmt.Errorf("cannot reshard into %d shards", shards)
+	}
+	if err := refuseCapability("ReshardDirectory", prefix, o); err != nil {
+		return err
+	}
+	if err := t.ready(); err != nil {
+		return err
+	}
+	storedPrefix, err := t.transformKey(prefix)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	t.list(storedPrefix, func(name string, innerErr error) bool {
+		if innerErr != nil {
+			err = innerErr
+			return false
+		}
+		if !isShardName(name, shards) {
+			names = append(names, name)
+		}
+		return true
+	}, o)
+	if err != nil {
+		return err
+	}
+
+	j, err := t.startJob("ReshardDirectory", [][]string{storedPrefix}, o)
+	if err != nil {
+		return err
+	}
+	inner := []Option{WithContext(o.context())}
+	for _, name := range names {
+		key := make([]string, len(prefix), len(prefix)+1)
+		copy(key, prefix)
+		key = append(key, name)
+		err := t.Copy(key, t, ShardKey(prefix, name, shards), inner...)
+		if err == nil {
+			err = t.DeleteAll(key, inner...)
+		}
+		if err == nil {
+			err = j.done(key)
+		}
+		if err != nil {
+			return j.finish(err)
+		}
+	}
+	return j.finish(nil)
+}
@@ -0,0 +1,69 @@
+package dynamotree
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestWideDirectories(c *C) {
+	reported := []WideDirectory{}
+	s := &Tree{TableName: uniuri.New(), DB: dynamodb.New(session.New(), testConfig),
+		WideDirectoryThreshold: 5,
+		OnWideDirectory:        func(w *WideDirectory) { reported = append(reported, *w) }}
+	c.Assert(s.CreateTable(), IsNil)
+	for i := 0; i < 6; i++ {
+		c.Assert(s.Put([]string{"Users", fmt.Sprintf("u%d", i)}, &AccountT{ID: fmt.Sprint(i)}), IsNil)
+	}
+	c.Assert(s.Put([]string{"Users", "u0", "Profile"}, &AccountT{ID: "p"}), IsNil)
+	c.Assert(s.Put([]string{"Groups", "g"}, &AccountT{ID: "g"}), IsNil)
+
+	n, err := s.CountChildren([]string{"Groups"})
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+	c.Assert(reported, HasLen, 0)
+
+	n, err = s.CountChildren([]string{"Users"})
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 6)
+	c.Assert(reported, DeepEquals, []WideDirectory{{Prefix: []string{"Users"}, Children: 6, Op: "CountChildren"}})
+
+	// A directory is reported only once.
+	c.Assert(walkKeys(c, s, []string{"Users"}), HasLen, 7)
+	c.Assert(reported, HasLen, 1)
+
+	c.Assert(DirectoryShards(2), DeepEquals, []string{"shard-0", "shard-1"})
+	c.Assert(DirectoryShards(12)[3], Equals, "shard-03")
+	c.Assert(s.ReshardDirectory([]string{"Users"}, 1), ErrorMatches, `cannot reshard into 1 shards`)
+
+	progress := 0
+	c.Assert(s.ReshardDirectory([]string{"Users"}, 4, WithProgress(func(p Progress) { progress = p.Items })), IsNil)
+	c.Assert(progress, Equals, 6)
+	for i := 0; i < 6; i++ {
+		name := fmt.Sprintf("u%d", i)
+		c.Assert(s.Get([]string{"Users", name}, &AccountT{}), ErrorIs, ErrNotFound)
+		v := AccountT{}
+		c.Assert(s.Get(ShardKey([]string{"Users"}, name, 4), &v), IsNil)
+		c.Assert(v.ID, Equals, fmt.Sprint(i))
+	}
+	v := AccountT{}
+	c.Assert(s.Get(append(ShardKey([]string{"Users"}, "u0", 4), "Profile"), &v), IsNil)
+	c.Assert(v.ID, Equals, "p")
+
+	total := 0
+	for _, shard := range DirectoryShards(4) {
+		n, err := s.CountChildren([]string{"Users", shard})
+		c.Assert(err, IsNil)
+		total += n
+	}
+	c.Assert(total, Equals, 6)
+
+	// Resharding again leaves the shards in place.
+	c.Assert(s.ReshardDirectory([]string{"Users"}, 4), IsNil)
+	n, err = s.CountChildren([]string{"Users"})
+	c.Assert(err, IsNil)
+	c.Assert(n <= 4, Equals, true)
+}
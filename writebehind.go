@@ -0,0 +1,283 @@
+package dynamotree
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+)
+
+// ErrWriteBehindClosed is returned by the methods of a WriteBehind once it
+// has been closed.
+var ErrWriteBehindClosed = errors.New("write-behind buffer is closed")
+
+// DefaultWriteBehindPending is the number of writes a WriteBehind holds
+// if WriteBehind.MaxPending is zero.
+const DefaultWriteBehindPending = 1000
+
+// WriteBehind is a buffer of writes to a tree that are made in the
+// background, for ingestion pipelines in which the latency of each write
+// matters more than knowing that it is durable when the call returns.
+// Put, PutLink and Delete check the key, and Put marshals the item, and
+// then queue the write and return at once; the tree's own Put, PutLink
+// and Delete are called later, by the buffer's workers.
+//
+// A write that has been queued is lost if the process ends before it is
+// made, and a write that fails is reported only to OnError and by the next
+// call to Flush or Close. Callers that must know that a write is durable
+// should call Flush, or use the tree's methods directly. Writes to the
+// same key are made in the order in which they were queued.
+//
+//	wb := &dynamotree.WriteBehind{Tree: tree, Workers: 4}
+//	for _, record := range records {
+//		if err := wb.Put(record.Key, record); err != nil {
+//			...
+//		}
+//	}
+//	if err := wb.Close(ctx); err != nil {
+//		...
+//	}
+type WriteBehind struct {
+	// Tree is the tree to write to.
+	Tree *Tree
+
+	// Workers is the number of writes made at once. If zero, writes are
+	// made one at a time, in the order in which they were queued.
+	// Setting Tree.CoalesceWrites lets concurrent writes share requests.
+	Workers int
+
+	// MaxPending is the most writes that may be queued. Once as many are
+	// waiting, Put, PutLink and Delete block until there is room. If zero,
+	// DefaultWriteBehindPending is used.
+	MaxPending int
+
+	// Options are given to each write.
+	Options []Option
+
+	// OnError, if not nil, is called, by one of the workers, with the key
+	// and error of each write that fails.
+	OnError func(key []string, err error)
+
+	startOnce sync.Once
+	queues    []chan writeBehindOp
+	workers   sync.WaitGroup
+	untrack   func()
+
+	mu     sync.RWMutex
+	closed bool
+
+	errMu sync.Mutex
+	err   error
+}
+
+// writeBehindOp is a queued write, or, if flushed is not nil, a marker
+// that is closed once the writes queued before it have been made.
+type writeBehindOp struct {
+	key     []string
+	item    rawItem
+	link    bool
+	target  []string
+	delete  bool
+	flushed chan struct{}
+}
+
+// Put queues item to be stored at key. It returns an error if key is not
+// valid or item cannot be marshalled, and otherwise nil, whether or not the
+// write later succeeds.
+func (w *WriteBehind) Put(key []string, item Storable) (err error) {
+	defer annotateError(&err, "Put", key)
+	if err := w.checkKey(key); err != nil {
+		return err
+	}
+	marshalled, err := item.MarshalDynamoDB()
+	if err != nil {
+		return err
+	}
+	return w.enqueue(writeBehindOp{key: append([]string(nil), key...), item: marshalled})
+}
+
+// PutLink queues a link from key to target to be created. It returns an
+// error if either key is not valid.
+func (w *WriteBehind) PutLink(key []string, target []string) (err error) {
+	defer annotateError(&err, "PutLink", key)
+	if err := w.checkKey(key); err != nil {
+		return err
+	}
+	if err := w.checkKey(target); err != nil {
+		return err
+	}
+	return w.enqueue(writeBehindOp{
+		key:    append([]string(nil), key...),
+		link:   true,
+		target: append([]string(nil), target...),
+	})
+}
+
+// Delete queues key to be deleted. It returns an error if key is not
+// valid.
+func (w *WriteBehind) Delete(key []string) (err error) {
+	defer annotateError(&err, "Delete", key)
+	if err := w.checkKey(key); err != nil {
+		return err
+	}
+	return w.enqueue(writeBehindOp{key: append([]string(nil), key...), delete: true})
+}
+
+// Flush waits until the writes queued before it have been made, and
+// returns the error of the first of the writes that failed since the last
+// call to Flush, if any. If ctx is done first, it returns ctx's error, and
+// the writes continue.
+func (w *WriteBehind) Flush(ctx context.Context) error {
+	w.start()
+	w.mu.RLock()
+	if w.closed {
+		w.mu.RUnlock()
+		return w.takeErr()
+	}
+	markers := make([]chan struct{}, len(w.queues))
+	for i, queue := range w.queues {
+		markers[i] = make(chan struct{})
+		select {
+		case queue <- writeBehindOp{flushed: markers[i]}:
+		case <-ctx.Done():
+			w.mu.RUnlock()
+			return ctx.Err()
+		}
+	}
+	w.mu.RUnlock()
+	for _, marker := range markers {
+		select {
+		case <-marker:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return w.takeErr()
+}
+
+// Close stops the buffer from accepting writes, waits until those queued
+// have been made, and returns the error of the first of them that failed
+// since the last call to Flush, if any. If ctx is done first, it returns
+// ctx's error, and the writes continue. Closing the tree closes the
+// buffer.
+func (w *WriteBehind) Close(ctx context.Context) error {
+	w.start()
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		for _, queue := range w.queues {
+			close(queue)
+		}
+		w.untrack()
+	}
+	w.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		w.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return w.takeErr()
+}
+
+// checkKey returns the error that the tree would return for key before
+// writing anything.
+func (w *WriteBehind) checkKey(key []string) error {
+	t := w.Tree
+	t.initOnce.Do(t.init)
+	key, err := t.transformKey(key)
+	if err != nil {
+		return err
+	}
+	if err := t.ValidateKey(key); err != nil {
+		return err
+	}
+	return t.checkTenant(key)
+}
+
+// start starts the workers.
+func (w *WriteBehind) start() {
+	w.startOnce.Do(func() {
+		workers := w.Workers
+		if workers <= 0 {
+			workers = 1
+		}
+		maxPending := w.MaxPending
+		if maxPending <= 0 {
+			maxPending = DefaultWriteBehindPending
+		}
+		size := maxPending / workers
+		if size < 1 {
+			size = 1
+		}
+		w.queues = make([]chan writeBehindOp, workers)
+		for i := range w.queues {
+			w.queues[i] = make(chan writeBehindOp, size)
+			w.workers.Add(1)
+			go w.work(w.queues[i])
+		}
+		w.untrack = w.Tree.onClose(w.Close)
+	})
+}
+
+// enqueue queues op for the worker that writes op's key.
+func (w *WriteBehind) enqueue(op writeBehindOp) error {
+	w.start()
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return ErrWriteBehindClosed
+	}
+	h := fnv.New32a()
+	for _, part := range op.key {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	w.queues[h.Sum32()%uint32(len(w.queues))] <- op
+	return nil
+}
+
+// work makes the writes in queue until it is closed.
+func (w *WriteBehind) work(queue chan writeBehindOp) {
+	defer w.workers.Done()
+	for op := range queue {
+		if op.flushed != nil {
+			close(op.flushed)
+			continue
+		}
+		var err error
+		switch {
+		case op.delete:
+			err = w.Tree.Delete(op.key, w.Options...)
+		case op.link:
+			err = w.Tree.PutLink(op.key, op.target, w.Options...)
+		default:
+			err = w.Tree.Put(op.key, op.item, w.Options...)
+		}
+		if err == nil {
+			continue
+		}
+		w.errMu.Lock()
+		if w.err == nil {
+			w.err = err
+		}
+		w.errMu.Unlock()
+		if w.OnError != nil {
+			w.OnError(op.key, err)
+		}
+	}
+}
+
+// takeErr returns the first error recorded since it was last called.
+func (w *WriteBehind) takeErr() error {
+	w.errMu.Lock()
+	defer w.errMu.Unlock()
+	err := w.err
+	w.err = nil
+	return err
+}
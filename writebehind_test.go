@@ -0,0 +1,92 @@
+package dynamotree
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestWriteBehind(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db, ProtectLinks: true}
+	c.Assert(s.CreateTable(), IsNil)
+
+	var mu sync.Mutex
+	failed := [][]string{}
+	wb := &WriteBehind{Tree: s, Workers: 4, MaxPending: 8, OnError: func(key []string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed = append(failed, key)
+	}}
+	for i := 0; i < 20; i++ {
+		c.Assert(wb.Put([]string{"Accounts", fmt.Sprint(i)}, &AccountT{ID: fmt.Sprint(i)}), IsNil)
+	}
+	c.Assert(wb.PutLink([]string{"Users", "alice"}, []string{"Accounts", "1"}), IsNil)
+	c.Assert(wb.PutLink([]string{"Users", "root"}, nil), IsNil)
+	c.Assert(wb.Delete([]string{"Accounts", "0"}), IsNil)
+	c.Assert(wb.Flush(context.Background()), IsNil)
+
+	c.Assert(walkKeys(c, s, []string{"Accounts"}), HasLen, 19)
+	v := AccountT{}
+	c.Assert(s.Get([]string{"Users", "alice"}, &v, ConsistentRead()), IsNil)
+	c.Assert(v.ID, Equals, "1")
+	c.Assert(s.Get([]string{"Accounts", "0"}, &v, ConsistentRead()), ErrorIs, ErrNotFound)
+	target, err := s.GetLink([]string{"Users", "root"}, ConsistentRead())
+	c.Assert(err, IsNil)
+	c.Assert(target, HasLen, 0)
+
+	// Invalid keys and items are reported at once.
+	c.Assert(wb.Put([]string{"Accounts", ""}, &AccountT{}), ErrorIs, ErrEmptyKeyPart)
+	c.Assert(wb.Put([]string{"Accounts", "2"}, &AccountT{MarshalFailPlease: true}), ErrorMatches, ".*could not grob the frob")
+
+	// A write that fails is reported by OnError and the next Flush.
+	c.Assert(wb.Put([]string{"Users", "alice"}, &AccountT{ID: "alice"}), IsNil)
+	c.Assert(wb.Flush(context.Background()), ErrorIs, ErrIsLink)
+	c.Assert(failed, DeepEquals, [][]string{{"Users", "alice"}})
+	c.Assert(wb.Flush(context.Background()), IsNil)
+
+	// Close makes the writes queued before it.
+	c.Assert(wb.Put([]string{"Accounts", "20"}, &AccountT{ID: "20"}), IsNil)
+	c.Assert(wb.Close(context.Background()), IsNil)
+	c.Assert(s.Get([]string{"Accounts", "20"}, &v, ConsistentRead()), IsNil)
+	c.Assert(wb.Put([]string{"Accounts", "21"}, &AccountT{ID: "21"}), ErrorIs, ErrWriteBehindClosed)
+	c.Assert(wb.Close(context.Background()), IsNil)
+}
+
+func (suite *StoreImplTest) TestWriteBehindReusedKey(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+	wb := &WriteBehind{Tree: s, Workers: 1, MaxPending: 64}
+
+	// The caller may reuse its slices as soon as each call returns.
+	key, target := []string{"Accounts", ""}, []string{"Accounts", ""}
+	for i := 0; i < 10; i++ {
+		key[1] = fmt.Sprint(i)
+		c.Assert(wb.Put(key, &AccountT{ID: fmt.Sprint(i)}), IsNil)
+	}
+	link := []string{"Users", "alice"}
+	target[1] = "3"
+	c.Assert(wb.PutLink(link, target), IsNil)
+	link[1], target[1] = "bob", "4"
+	key[1] = "0"
+	c.Assert(wb.Delete(key), IsNil)
+	key[1] = "9"
+	c.Assert(wb.Close(context.Background()), IsNil)
+
+	v := AccountT{}
+	for i := 1; i < 10; i++ {
+		c.Assert(s.Get([]string{"Accounts", fmt.Sprint(i)}, &v, ConsistentRead()), IsNil)
+		c.Assert(v.ID, Equals, fmt.Sprint(i))
+	}
+	c.Assert(s.Get([]string{"Accounts", "0"}, &v, ConsistentRead()), ErrorIs, ErrNotFound)
+	target, err := s.GetLink([]string{"Users", "alice"}, ConsistentRead())
+	c.Assert(err, IsNil)
+	c.Assert(target, DeepEquals, []string{"Accounts", "3"})
+	c.Assert(s.Get([]string{"Users", "bob"}, &v, ConsistentRead()), ErrorIs, ErrNotFound)
+}
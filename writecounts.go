@@ -0,0 +1,116 @@
+package dynamotree
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// RowCounts counts rows of the table by what they store.
+type RowCounts struct {
+	// Directory is the number of directory entries.
+	Directory int
+
+	// Objects is the number of rows of objects, and Links the number of
+	// rows of symbolic links.
+	Objects int
+	Links   int
+
+	// Auxiliary is the number of rows stored alongside objects, such as
+	// versions, history and backlinks.
+	Auxiliary int
+}
+
+// Total returns the number of rows counted.
+func (c RowCounts) Total() int { return c.Directory + c.Objects + c.Links + c.Auxiliary }
+
+// WriteCounts describes the rows written and removed by the calls given
+// ReturnWriteCounts.
+type WriteCounts struct {
+	Written RowCounts
+	Removed RowCounts
+}
+
+// ReturnWriteCounts causes Put, PutLink, PutLinkIfAbsent, Delete and
+// DeleteChildren to add the rows they write and remove to *counts. As
+// DeleteAll, Copy and Sync pass their options to each Put, PutLink and
+// Delete, they add to *counts too. Put rewrites the directory entries
+// leading to its key, so they are counted as written even if they were
+// already stored, and a removal counts the directory entry it removes even
+// if the entry was not stored. If a call fails, only the rows known to
+// have been written or removed, as described by a *MultiRowError, are
+// counted.
+//
+// Counting the row that Delete removes requires knowing whether it is a
+// link, so unless Tree.MaintainBacklinks is set, Delete reads the row
+// first.
+func ReturnWriteCounts(counts *WriteCounts) Option {
+	return func(o *callOptions) {
+		o.writeCounts = counts
+	}
+}
+
+// countWrites adds the rows of writeRequests, which were issued with the
+// outcome err, to the counts given using ReturnWriteCounts. leafKinds gives
+// the kind of each object or link whose row is removed; a removal of a row
+// whose kind is not NodeItem or NodeLink is not counted.
+func (t *Tree) countWrites(writeRequests []*dynamodb.WriteRequest, err error, leafKinds map[RowID]NodeKind, o *callOptions) {
+	if o == nil || o.writeCounts == nil {
+		return
+	}
+	var written map[RowID]bool
+	if err != nil {
+		var e *MultiRowError
+		if !errors.As(err, &e) {
+			return
+		}
+		written = make(map[RowID]bool, len(e.Written))
+		for _, id := range e.Written {
+			written[id] = true
+		}
+	}
+
+	o.statsMu.Lock()
+	defer o.statsMu.Unlock()
+	for _, writeRequest := range writeRequests {
+		id := rowID(writeRequest)
+		if written != nil && !written[id] {
+			continue
+		}
+		counts := &o.writeCounts.Written
+		if writeRequest.DeleteRequest != nil {
+			counts = &o.writeCounts.Removed
+		}
+		switch {
+		case id.Child == t.SpecialCharacter && writeRequest.PutRequest != nil:
+			if _, isLink := t.linkTarget(writeRequest.PutRequest.Item); isLink {
+				counts.Links++
+			} else {
+				counts.Objects++
+			}
+		case id.Child == t.SpecialCharacter:
+			switch leafKinds[id] {
+			case NodeItem:
+				counts.Objects++
+			case NodeLink:
+				counts.Links++
+			}
+		case strings.HasPrefix(id.Child, t.SpecialCharacter):
+			counts.Auxiliary++
+		default:
+			counts.Directory++
+		}
+	}
+}
+
+// countLinksWritten adds n link rows to the rows written, as counted using
+// ReturnWriteCounts.
+func (o *callOptions) countLinksWritten(n int) {
+	if o == nil || o.writeCounts == nil {
+		return
+	}
+	o.statsMu.Lock()
+	defer o.statsMu.Unlock()
+	o.writeCounts.Written.Links += n
+}
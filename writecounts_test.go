@@ -0,0 +1,46 @@
+package dynamotree
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/dchest/uniuri"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *StoreImplTest) TestWriteCounts(c *C) {
+	db := dynamodb.New(session.New(), testConfig)
+	s := &Tree{TableName: uniuri.New(), DB: db}
+	c.Assert(s.CreateTable(), IsNil)
+	v := AccountT{ID: "12345", Name: "alice"}
+
+	counts := WriteCounts{}
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &v, ReturnWriteCounts(&counts)), IsNil)
+	c.Assert(counts, DeepEquals, WriteCounts{Written: RowCounts{Directory: 2, Objects: 1}})
+	c.Assert(s.PutLink([]string{"Links", "alice"}, []string{"Accounts", "12345"}, ReturnWriteCounts(&counts)), IsNil)
+	c.Assert(counts, DeepEquals, WriteCounts{Written: RowCounts{Directory: 4, Objects: 1, Links: 1}})
+	c.Assert(counts.Written.Total(), Equals, 6)
+
+	counts = WriteCounts{}
+	c.Assert(s.Delete([]string{"Links", "alice"}, ReturnWriteCounts(&counts)), IsNil)
+	c.Assert(counts, DeepEquals, WriteCounts{Removed: RowCounts{Directory: 1, Links: 1}})
+
+	// DeleteAll counts the rows removed by each Delete. Nothing is stored
+	// at Accounts, so its own row is not counted.
+	counts = WriteCounts{}
+	c.Assert(s.DeleteAll([]string{"Accounts"}, ReturnWriteCounts(&counts)), IsNil)
+	c.Assert(counts, DeepEquals, WriteCounts{Removed: RowCounts{Directory: 2, Objects: 1}})
+
+	// Auxiliary rows, such as backlinks, are counted separately.
+	s = &Tree{TableName: uniuri.New(), DB: db, MaintainBacklinks: true}
+	c.Assert(s.CreateTable(), IsNil)
+	counts = WriteCounts{}
+	c.Assert(s.Put([]string{"Accounts", "12345"}, &v), IsNil)
+	c.Assert(s.PutLink([]string{"Accounts", "alice"}, []string{"Accounts", "12345"}, ReturnWriteCounts(&counts)), IsNil)
+	c.Assert(counts, DeepEquals, WriteCounts{Written: RowCounts{Directory: 2, Links: 1, Auxiliary: 1}})
+
+	counts = WriteCounts{}
+	deleted, err := s.DeleteChildren([]string{"Accounts"}, nil, ReturnWriteCounts(&counts))
+	c.Assert(err, IsNil)
+	c.Assert(deleted, Equals, 2)
+	c.Assert(counts, DeepEquals, WriteCounts{Removed: RowCounts{Directory: 2, Objects: 1, Links: 1, Auxiliary: 1}})
+}